@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"linedb"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The flat login response gives only keywords and URLs of userpics. Their
+// descriptions, comments and comment counts are shown only on the allpics
+// page, so the dump scrapes that page. The markup differs between LJ
+// versions and forks, so the parser looks for userpic images and the
+// labelled text after each of them.
+
+type pictureInfo struct {
+	url          string
+	description  string
+	comment      string
+	commentCount int
+}
+
+func addPictureInfoTable(e *linedb.Encoder, infoMap map[string]*pictureInfo) {
+	urls := make([]string, 0, len(infoMap))
+	for url := range infoMap {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	e.Comment("url description comment comment-count")
+	e.Table("pictureInfo")
+	for _, url := range urls {
+		info := infoMap[url]
+		e.AddString(info.url).AddString(info.description).AddString(info.comment).AddInt(info.commentCount).EndRow()
+	}
+	e.EndTable()
+}
+
+var allPicsImagePattern = regexp.MustCompile(`(?i)<img\b[^>]*?\ssrc\s*=\s*["']?(https?://[^"'\s>]*userpic[^"'\s>]*)`)
+var allPicsDescriptionPattern = regexp.MustCompile(`(?im)^\s*description:\s*(.+)$`)
+var allPicsCommentPattern = regexp.MustCompile(`(?im)^\s*comment:\s*(.+)$`)
+var allPicsCommentCountPattern = regexp.MustCompile(`(?i)\b([0-9]+)\s+comments?\b`)
+
+// Extract pictures from the allpics page in the page order. The text
+// between a picture and the next one describes the picture.
+func parseAllPicsPage(page string) []*pictureInfo {
+	var infos []*pictureInfo
+	byUrl := make(map[string]*pictureInfo)
+	matches := allPicsImagePattern.FindAllStringSubmatchIndex(page, -1)
+	for i, m := range matches {
+		url := page[m[2]:m[3]]
+		end := len(page)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		text := htmlToText(page[m[1]:end])
+		info := byUrl[url]
+		if info == nil {
+			info = &pictureInfo{url: url}
+			byUrl[url] = info
+			infos = append(infos, info)
+		}
+		if found := allPicsDescriptionPattern.FindStringSubmatch(text); found != nil && info.description == "" {
+			info.description = strings.TrimSpace(found[1])
+		}
+		if found := allPicsCommentPattern.FindStringSubmatch(text); found != nil && info.comment == "" {
+			info.comment = strings.TrimSpace(found[1])
+		}
+		if found := allPicsCommentCountPattern.FindStringSubmatch(text); found != nil && info.commentCount == 0 {
+			info.commentCount, _ = strconv.Atoi(found[1])
+		}
+	}
+	return infos
+}
+
+// Fetch the allpics page of the account and merge the picture details
+// into accountData. Pictures that are no longer on the page keep their
+// details. Return true when anything changed.
+func dumpPictureInfo(session *ljSession, accountData *accountData) (bool, *Report) {
+	geturl := session.config.server + "/allpics.bml?user=" + session.config.username
+	resp, err := session.client.Get(geturl)
+	if err != nil {
+		return false, WrapErr(err, "failed to get %s", geturl)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return false, ReportMsg("unexpected status %s for %s", resp.Status, geturl)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxMediaFileSize))
+	if err != nil {
+		return false, WrapErr(err, "failed to read %s", geturl)
+	}
+	infos := parseAllPicsPage(string(data))
+	if len(infos) == 0 {
+		return false, ReportMsg("no userpics found on %s, the page layout may have changed", geturl)
+	}
+	if accountData.pictureInfo == nil {
+		accountData.pictureInfo = make(map[string]*pictureInfo)
+	}
+	updated := false
+	for _, info := range infos {
+		if old := accountData.pictureInfo[info.url]; old == nil || *old != *info {
+			accountData.pictureInfo[info.url] = info
+			updated = true
+		}
+	}
+	return updated, nil
+}
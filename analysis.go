@@ -0,0 +1,223 @@
+package main
+
+import (
+	"linedb"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The per-year number of entries that the server reported for the journal
+// with getdaycounts. It is stored so verify and stats can compare it with
+// the archive without contacting the server.
+const serverCountsDBFileName = "server-counts.linedb"
+
+type serverCounts struct {
+	fetched    string
+	yearCounts map[int]int
+}
+
+// Year where the archive has fewer entries than the server reported
+type yearGap struct {
+	year     int
+	server   int
+	archived int
+}
+
+func (gap *yearGap) missingYear() bool {
+	return gap.archived == 0
+}
+
+type LJDayCount struct {
+	Date  string `xmlrpc:"date"`
+	Count int    `xmlrpc:"count"`
+}
+
+// Get the number of entries per day in the YYYY-MM-DD form
+func fetchServerDayCounts(session *ljSession, journal string) ([]LJDayCount, *Report) {
+	type LJGetDayCountsResult struct {
+		DayCounts []LJDayCount `xmlrpc:"daycounts"`
+	}
+	var result LJGetDayCountsResult
+	params := usejournalParams(session.config, journal, map[string]interface{}{})
+	if r := callLJXmlRpcMethod(session, "getdaycounts", params, &result); r != nil {
+		return nil, r
+	}
+	return result.DayCounts, nil
+}
+
+func fetchServerYearCounts(session *ljSession, journal string) (map[int]int, *Report) {
+	dayCounts, r := fetchServerDayCounts(session, journal)
+	if r != nil {
+		return nil, r
+	}
+	yearCounts := make(map[int]int)
+	for _, dayCount := range dayCounts {
+		if len(dayCount.Date) < 4 {
+			continue
+		}
+		year, err := strconv.Atoi(dayCount.Date[0:4])
+		if err != nil {
+			session.config.warn("unexpected date %s in getdaycounts response", dayCount.Date)
+			continue
+		}
+		yearCounts[year] += dayCount.Count
+	}
+	return yearCounts, nil
+}
+
+func sortedYears(yearCounts map[int]int) []int {
+	years := make([]int, 0, len(yearCounts))
+	for year := range yearCounts {
+		years = append(years, year)
+	}
+	sort.Ints(years)
+	return years
+}
+
+func writeServerCounts(dir string, counts *serverCounts) *Report {
+	e := linedb.NewByteEncoder()
+	e.Scalar("fetched").AddString(counts.fetched)
+	e.EmptyLine()
+	e.Comment("year number-of-entries")
+	e.Table("years")
+	for _, year := range sortedYears(counts.yearCounts) {
+		e.AddInt(year).AddInt(counts.yearCounts[year]).EndRow()
+	}
+	e.EndTable()
+
+	dbpath := filepath.Join(dir, serverCountsDBFileName)
+	if err := writeFileTempRename(dbpath, e.GetBytes()); err != nil {
+		return WrapErr(err, "failed to write server counts file %s", dbpath)
+	}
+	return nil
+}
+
+// Return nil without error when the counts were never fetched.
+func readServerCounts(dir string) (*serverCounts, *Report) {
+	dbpath := filepath.Join(dir, serverCountsDBFileName)
+	dbdata, err := archiveStore.ReadFile(dbpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, WrapErr(err, "")
+	}
+	counts := &serverCounts{yearCounts: make(map[int]int)}
+	d := newLinedbDecoder(dbdata)
+	for d.NextItem() {
+		switch d.ItemKind {
+		case linedb.ScalarItem:
+			switch d.ItemName {
+			case "fetched":
+				counts.fetched = d.GetString()
+			}
+		case linedb.TableItem:
+			for d.NextRow() {
+				switch d.ItemName {
+				case "years":
+					counts.yearCounts[d.GetInt()] = d.GetInt()
+				}
+			}
+		}
+	}
+	if err := d.GetError(); err != nil {
+		return nil, WrapErr(err, "error while parsing server counts file %s as linedb", dbpath)
+	}
+	return counts, nil
+}
+
+func archivedYearCounts(entries []*archivedEntry) map[int]int {
+	yearCounts := make(map[int]int)
+	for _, entry := range entries {
+		year, _ := entry.yearMonth()
+		yearCounts[year]++
+	}
+	return yearCounts
+}
+
+// Find years where the archive has fewer entries than the server. More
+// archived entries are fine as entries deleted on the server stay in the
+// archive.
+func findYearGaps(server, archived map[int]int) []yearGap {
+	var gaps []yearGap
+	for _, year := range sortedYears(server) {
+		if archived[year] < server[year] {
+			gaps = append(gaps, yearGap{year, server[year], archived[year]})
+		}
+	}
+	return gaps
+}
+
+// Log the gaps prominently and return their number.
+func reportYearGaps(journal string, gaps []yearGap) int {
+	for _, gap := range gaps {
+		if gap.missingYear() {
+			log("*** PROBABLE MISSING YEAR %d in %s: the server has %d entries, the archive has none",
+				gap.year, journal, gap.server)
+		} else {
+			log("*** INCOMPLETE YEAR %d in %s: the server has %d entries, the archive has %d",
+				gap.year, journal, gap.server, gap.archived)
+		}
+	}
+	if len(gaps) != 0 {
+		log("Entries can be missing when the user had no permission to read them at the time of the dump or when lastSync in %s skipped them. Check the access rights and consider removing lastSync to re-sync the journal",
+			journalDBFileName)
+	}
+	return len(gaps)
+}
+
+// Compare the archive with the stored server counts and return the gaps.
+// Return nil if the server counts were never fetched.
+func checkJournalYearGaps(dir string) ([]yearGap, *serverCounts, *Report) {
+	counts, r := readServerCounts(dir)
+	if r != nil || counts == nil {
+		return nil, nil, r
+	}
+	entries, r := readJournalEntries(dir)
+	if r != nil {
+		return nil, nil, r
+	}
+	return findYearGaps(counts.yearCounts, archivedYearCounts(entries)), counts, nil
+}
+
+func runAnalyze(config *Config) *Report {
+	session, r := openLJSession(config)
+	if r != nil {
+		return r
+	}
+	totalGaps := 0
+	for _, journal := range config.journals {
+		dir := config.journalDir(journal)
+		if _, err := archiveStore.Stat(dir); err != nil {
+			config.warn("journal %s has no archive directory %s", journal, dir)
+			continue
+		}
+		log("Fetching entry counts for: %s", journal)
+		yearCounts, r := fetchServerYearCounts(session, journal)
+		if r != nil {
+			return r
+		}
+		counts := &serverCounts{
+			fetched:    time.Now().UTC().Format(time.RFC3339),
+			yearCounts: yearCounts,
+		}
+		if r := writeServerCounts(dir, counts); r != nil {
+			return r
+		}
+		gaps, _, r := checkJournalYearGaps(dir)
+		if r != nil {
+			return r
+		}
+		if len(gaps) == 0 {
+			log("All %d years of %s on the server are fully archived", len(yearCounts), journal)
+		}
+		totalGaps += reportYearGaps(journal, gaps)
+	}
+	if totalGaps != 0 {
+		return ReportMsg("found %d years with missing entries", totalGaps)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// With -anonymize exports replace names of commenters with pseudonyms,
+// remove e-mail and IP addresses from comments and drop screened comments
+// and earlier versions of edited ones, so the export can be shared without
+// exposing other people. The journal and the account keep their names. A
+// pseudonym is a keyed hash of the name, so the same commenter gets the
+// same pseudonym in all exports of the dump directory while the names
+// cannot be recovered by hashing known names. The key is generated on
+// first use and kept in account.data.
+
+const anonymizeKeyFileName = "anonymize.key"
+
+const anonymizedText = "[removed]"
+
+var emailAddressPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+var ipv4AddressPattern = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+var ipv6AddressPattern = regexp.MustCompile(`(?i)\b(?:[0-9a-f]{1,4}:){7}[0-9a-f]{1,4}\b`)
+var ljUserTagPattern = regexp.MustCompile(`(?i)(<lj\s+(?:user|comm)\s*=\s*["']?)([A-Za-z0-9_-]+)`)
+
+type commentAnonymizer struct {
+	key []byte
+
+	// Lower case names of the journal and the account that keep their
+	// names
+	kept map[string]bool
+}
+
+func newCommentAnonymizer(config *Config, journal string, key []byte) *commentAnonymizer {
+	return &commentAnonymizer{
+		key:  key,
+		kept: map[string]bool{strings.ToLower(journal): true, strings.ToLower(config.username): true},
+	}
+}
+
+func (a *commentAnonymizer) user(user string) string {
+	if user == "" || a.kept[strings.ToLower(user)] {
+		return user
+	}
+	return a.pseudonym(user)
+}
+
+// Read the pseudonym key of the dump directory creating it when missing
+func readAnonymizeKey(config *Config) ([]byte, *Report) {
+	path := filepath.Join(config.accountDataDir, anonymizeKeyFileName)
+	data, err := archiveStore.ReadFile(path)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) == 0 {
+			return nil, ReportMsg("invalid pseudonym key in %s, remove it to generate a new one", path)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, WrapErr(err, "failed to read pseudonym key %s", path)
+	}
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, WrapErr(err, "failed to generate pseudonym key")
+	}
+	if err := mkdirArchive(config.accountDataDir); err != nil {
+		return nil, WrapErr(err, "failed to create directory for account data %s", config.accountDataDir)
+	}
+	if err := writeFileTempRename(path, []byte(hex.EncodeToString(key)+"\n")); err != nil {
+		return nil, WrapErr(err, "failed to write pseudonym key %s", path)
+	}
+	log("Generated the key for commenter pseudonyms in %s", path)
+	return key, nil
+}
+
+func (a *commentAnonymizer) pseudonym(user string) string {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(strings.ToLower(user)))
+	return "user-" + hex.EncodeToString(mac.Sum(nil))[:10]
+}
+
+func (a *commentAnonymizer) text(s string) string {
+	s = emailAddressPattern.ReplaceAllString(s, anonymizedText)
+	s = ipv4AddressPattern.ReplaceAllString(s, anonymizedText)
+	s = ipv6AddressPattern.ReplaceAllString(s, anonymizedText)
+	return ljUserTagPattern.ReplaceAllStringFunc(s, func(tag string) string {
+		m := ljUserTagPattern.FindStringSubmatch(tag)
+		return m[1] + a.user(m[2])
+	})
+}
+
+// Get the comments that an anonymized export may show
+func (a *commentAnonymizer) comments(comments []CommentRecord) []CommentRecord {
+	kept := make([]CommentRecord, 0, len(comments))
+	for _, c := range comments {
+		if c.State == "S" {
+			continue
+		}
+		c.User = a.user(c.User)
+		c.Subject = a.text(c.Subject)
+		c.Body = a.text(c.Body)
+		c.History = nil
+		c.PosterProps = nil
+		kept = append(kept, c)
+	}
+	return kept
+}
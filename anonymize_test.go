@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_commentAnonymizer(t *testing.T) {
+	config := &Config{username: "bob"}
+	a := newCommentAnonymizer(config, "club", []byte("key"))
+	comments := []CommentRecord{
+		{Id: 1, State: "A", User: "alice", Body: `Ask <lj user="Alice"> or <lj user="bob">, alice@example.com, 192.168.1.20`},
+		{Id: 2, State: "S", User: "carol", Body: "screened"},
+		{Id: 3, State: "A", User: "bob", Body: "reply", History: []CommentVersion{{Body: "old"}}},
+		{Id: 4, State: "A", Body: "anonymous"},
+	}
+	kept := a.comments(comments)
+	if len(kept) != 3 {
+		t.Fatalf("Expected 3 comments, got %d", len(kept))
+	}
+	alice := a.pseudonym("alice")
+	if !strings.HasPrefix(alice, "user-") || kept[0].User != alice {
+		t.Errorf("Unexpected pseudonym %s for %s", kept[0].User, alice)
+	}
+	expected := `Ask <lj user="` + alice + `"> or <lj user="bob">, [removed], [removed]`
+	if kept[0].Body != expected {
+		t.Errorf("Expected %s, got %s", expected, kept[0].Body)
+	}
+	if kept[1].User != "bob" || kept[1].History != nil {
+		t.Errorf("Unexpected account comment %+v", kept[1])
+	}
+	if kept[2].User != "" {
+		t.Errorf("Anonymous comment got user %s", kept[2].User)
+	}
+	if other := newCommentAnonymizer(config, "club", []byte("other")); other.pseudonym("alice") == alice {
+		t.Errorf("Pseudonym does not depend on the key")
+	}
+	if comments[0].User != "alice" {
+		t.Errorf("Archived comments were modified")
+	}
+}
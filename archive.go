@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ibukanov/ljdump-go/ljarchive"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Entry as stored by writeLJEventDump in L-<itemid> file. Only the fields
+// that the archive consumers need are typed, props keeps all entry
+// properties as strings.
+type archivedEntry struct {
+	itemId   int64
+	fileName string
+
+	// The directory with the entry and comment files, see layout.go
+	dir       string
+	eventTime string
+
+	// The time with the UTC offset if known, see timezone.go
+	eventTimeRfc3339 string
+
+	subject   string
+	event     string
+	security  string
+	allowMask int64
+	anum      int64
+	url       string
+	poster    string
+
+	// See permalink.go
+	ditemid   int64
+	permalink string
+
+	// See content_flags.go
+	adultContent string
+	screening    string
+
+	props map[string]string
+}
+
+// Get the year and month from the eventtime in the "2006-01-02 15:04:05"
+// form or zeros if the time is malformed.
+func eventYearMonth(eventTime string) (int, int) {
+	if len(eventTime) < 7 || eventTime[4] != '-' {
+		return 0, 0
+	}
+	year, err := strconv.Atoi(eventTime[0:4])
+	if err != nil {
+		return 0, 0
+	}
+	month, err := strconv.Atoi(eventTime[5:7])
+	if err != nil {
+		return year, 0
+	}
+	return year, month
+}
+
+func (entry *archivedEntry) yearMonth() (int, int) {
+	return eventYearMonth(entry.eventTime)
+}
+
+// Get tags from the comma-separated taglist prop.
+func (entry *archivedEntry) tags() []string {
+	var tags []string
+	for _, tag := range strings.Split(entry.props["taglist"], ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// Parse the entry file with the parser shared with other tools, see
+// ljarchive
+func parseArchivedEntry(data []byte) (*archivedEntry, error) {
+	e, err := ljarchive.ParseEntry(data)
+	if err != nil {
+		return nil, err
+	}
+	return &archivedEntry{
+		itemId:           e.ItemId,
+		eventTime:        e.EventTime,
+		eventTimeRfc3339: e.EventTimeRfc3339,
+		subject:          e.Subject,
+		event:            e.Event,
+		security:         e.Security,
+		allowMask:        e.AllowMask,
+		anum:             e.Anum,
+		url:              e.Url,
+		ditemid:          e.Ditemid,
+		permalink:        e.Permalink,
+		poster:           e.Poster,
+		adultContent:     e.AdultContent,
+		screening:        e.Screening,
+		props:            e.Props,
+	}, nil
+}
+
+func readArchivedEntry(path string) (*archivedEntry, error) {
+	data, err := archiveStore.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseArchivedEntryFile(path, data)
+}
+
+// Parse the data of the entry file at path
+func parseArchivedEntryFile(path string, data []byte) (*archivedEntry, error) {
+	entry, err := parseArchivedEntry(data)
+	if err != nil {
+		return nil, err
+	}
+	entry.fileName = filepath.Base(path)
+	entry.dir = filepath.Dir(path)
+	if entry.itemId == 0 && len(entry.fileName) > 2 {
+		entry.itemId, _ = strconv.ParseInt(entry.fileName[2:], 10, 64)
+	}
+	return entry, nil
+}
+
+type sortEntriesByItemId []*archivedEntry
+
+func (a sortEntriesByItemId) Len() int           { return len(a) }
+func (a sortEntriesByItemId) Less(i, j int) bool { return a[i].itemId < a[j].itemId }
+func (a sortEntriesByItemId) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// Read all entries of the journal archive in dir in any layout sorted by
+// itemid. Files that cannot be parsed are reported as warnings and skipped.
+func readJournalEntries(dir string) ([]*archivedEntry, *Report) {
+	files, err := listDumpFiles(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, WrapErr(err, "failed to read journal directory %s", dir)
+	}
+	var entries []*archivedEntry
+	for _, file := range files {
+		if !strings.HasPrefix(filepath.Base(file), "L-") {
+			continue
+		}
+		path := filepath.Join(dir, file)
+		entry, err := readArchivedEntry(path)
+		if err != nil {
+			log("WARNING: skipping unreadable entry %s - %s", path, err.Error())
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sort.Sort(sortEntriesByItemId(entries))
+	return entries, nil
+}
+
+func commentFilePath(dir string, itemId int64) string {
+	return filepath.Join(dir, fmt.Sprintf("C-%d", itemId))
+}
+
+// Read comments to the entry with the given itemid from the directory of
+// the entry sorted by comment id.
+// Return nil when the entry has no archived comments.
+func readEntryComments(dir string, itemId int64) ([]CommentRecord, *Report) {
+	path := commentFilePath(dir, itemId)
+	data, err := archiveStore.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, WrapErr(err, "error while reading comments from %s", path)
+	}
+	comments, err := ljarchive.ParseComments(data)
+	if err != nil {
+		return nil, WrapErr(err, "failed to parse comments from %s", path)
+	}
+	return comments, nil
+}
+
+// Get the jitemids of all comments in the comment files of the journal by
+// comment id
+func archivedCommentItems(dir string) (map[CommentId]int64, *Report) {
+	files, err := listDumpFiles(dir)
+	if err != nil {
+		return nil, WrapErr(err, "failed to read journal directory %s", dir)
+	}
+	items := make(map[CommentId]int64)
+	for _, file := range files {
+		name := filepath.Base(file)
+		if !commentFileNamePattern.MatchString(name) {
+			continue
+		}
+		itemId, _ := strconv.ParseInt(name[2:], 10, 64)
+		path := filepath.Join(dir, file)
+		data, err := archiveStore.ReadFile(path)
+		if err != nil {
+			return nil, WrapErr(err, "error while reading comments from %s", path)
+		}
+		comments, err := ljarchive.ParseComments(data)
+		if err != nil {
+			return nil, WrapErr(err, "failed to parse comments from %s", path)
+		}
+		for i := range comments {
+			items[comments[i].Id] = itemId
+		}
+	}
+	return items, nil
+}
+
+// Comment with its replies for rendering of comment threads
+type commentThread struct {
+	comment *CommentRecord
+	depth   int
+}
+
+// Order comments depth-first so each comment follows its parent. Comments
+// with unknown parents are treated as top-level.
+func threadComments(comments []CommentRecord) []commentThread {
+	known := make(map[string]bool, len(comments))
+	for i := range comments {
+		known[strconv.FormatInt(int64(comments[i].Id), 10)] = true
+	}
+	children := make(map[string][]*CommentRecord)
+	for i := range comments {
+		c := &comments[i]
+		parent := c.ParentId
+		if !known[parent] {
+			parent = ""
+		}
+		children[parent] = append(children[parent], c)
+	}
+	threads := make([]commentThread, 0, len(comments))
+	var walk func(parent string, depth int)
+	walk = func(parent string, depth int) {
+		for _, c := range children[parent] {
+			threads = append(threads, commentThread{c, depth})
+			walk(strconv.FormatInt(int64(c.Id), 10), depth+1)
+		}
+	}
+	walk("", 0)
+	return threads
+}
+
+// Order entries by eventtime and then by itemid. The eventtime strings in
+// the "2006-01-02 15:04:05" form sort chronologically.
+type sortEntriesByTime []*archivedEntry
+
+func (a sortEntriesByTime) Len() int { return len(a) }
+func (a sortEntriesByTime) Less(i, j int) bool {
+	// Entries posted from different time zones are ordered only by the
+	// absolute time
+	ti, tj := a[i].absoluteTime(), a[j].absoluteTime()
+	if !ti.IsZero() && !tj.IsZero() && !ti.Equal(tj) {
+		return ti.Before(tj)
+	}
+	if a[i].eventTime != a[j].eventTime {
+		return a[i].eventTime < a[j].eventTime
+	}
+	return a[i].itemId < a[j].itemId
+}
+func (a sortEntriesByTime) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"linedb"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Ways to establish the LJ session. The password and the API key, called
+// an app password on some servers, use the challenge-response login of the
+// flat interface that gives the session cookie. The API key replaces the
+// password so the account password does not need to be stored. With OAuth
+// each request carries the access token in the Authorization header. When
+// the config has a refresh token and the token endpoint, the login gets a
+// new access token with it. Servers may rotate the refresh token, so the
+// latest one is kept in account.data/oauth.linedb readable only by the
+// owner.
+//
+// When the server decides that a login is suspicious, it asks for a CAPTCHA
+// or a second factor that the protocol cannot pass. The login then tells
+// how to proceed. On a terminal it offers to paste the ljsession cookie from
+// a browser where the verification was completed. Unattended runs can use
+// such a cookie with -session-cookie-file.
+
+type authenticator interface {
+	login(session *ljSession) *Report
+
+	// The auth_method parameter of protocol calls
+	authMethod() string
+}
+
+type oauthConfig struct {
+	accessToken  string
+	refreshToken string
+	tokenUrl     string
+	clientId     string
+	clientSecret string
+}
+
+const oauthDBFileName = "oauth.linedb"
+
+func (config *Config) authenticator() authenticator {
+	if config.sessionCookie != "" {
+		return &cookieAuthenticator{config.sessionCookie}
+	}
+	if config.oauth != nil {
+		return &oauthAuthenticator{config}
+	}
+	if config.apiKey != "" {
+		return &challengeAuthenticator{config.apiKey, "API key"}
+	}
+	return &challengeAuthenticator{config.password, "password"}
+}
+
+type challengeAuthenticator struct {
+	secret string
+
+	// Name of the secret for messages
+	kind string
+}
+
+func (a *challengeAuthenticator) authMethod() string {
+	return "cookie"
+}
+
+func (a *challengeAuthenticator) login(session *ljSession) *Report {
+	config := session.config
+	calculateChallengeResponse := func(challenge string) string {
+		var passhash = fmt.Sprintf("%x", md5.Sum([]byte(a.secret)))
+		return fmt.Sprintf("%x", md5.Sum([]byte(challenge+passhash)))
+	}
+
+	v := url.Values{}
+	v.Set("mode", "getchallenge")
+	responseMap, r := callLJFlatInterface(session, v)
+	if r != nil {
+		return r
+	}
+	challenge := responseMap["challenge"]
+	if challenge == "" {
+		return ReportMsg("no challenge is resposne")
+	}
+	v = url.Values{}
+	v.Set("mode", "sessiongenerate")
+	v.Set("user", config.username)
+	v.Set("auth_method", "challenge")
+	v.Set("auth_challenge", challenge)
+	v.Set("auth_response", calculateChallengeResponse(challenge))
+	v.Set("ipfixed", "1")
+
+	log("Logging in to %s", config.server)
+	responseMap, firstLine, r := postLJFlatForm(session, v)
+	if r != nil {
+		return r
+	}
+	if responseMap["success"] != "OK" {
+		errmsg := responseMap["errmsg"]
+		if errmsg == "" {
+			errmsg = firstLine
+		}
+		if verification := loginVerificationKind(errmsg); verification != "" {
+			return completeLoginVerification(session, verification, errmsg)
+		}
+		return ReportMsg("failed to login to %s - %s", config.server, errmsg).withCategory(errorCategoryAuth)
+	}
+
+	session.setLoginCookie(responseMap["ljsession"])
+	if session.loginCookie == "" {
+		return ReportMsg("failed to login to %s, perhaps the %s was invalid", config.server, a.kind).withCategory(errorCategoryAuth)
+	}
+	return nil
+}
+
+var captchaPattern = regexp.MustCompile(`(?i)captcha`)
+var secondFactorPattern = regexp.MustCompile(`(?i)two[- ]factor|\b2fa\b|one[- ]time|verification code|security code|\botp\b`)
+
+// Get the kind of extra verification that the login error asks for or an
+// empty string for other errors
+func loginVerificationKind(errmsg string) string {
+	if captchaPattern.MatchString(errmsg) {
+		return "a CAPTCHA"
+	}
+	if secondFactorPattern.MatchString(errmsg) {
+		return "a two-factor code"
+	}
+	return ""
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(info, null)
+}
+
+// Explain the verification that the server demands and on a terminal let
+// the user paste the session cookie of a browser login.
+func completeLoginVerification(session *ljSession, verification string, errmsg string) *Report {
+	config := session.config
+	hint := fmt.Sprintf(
+		"%s requires %s to log in (%s). Log in at %s with a browser and pass the value of its ljsession cookie with -session-cookie-file, or create an app password and put it into <apiKey> in the config if the server supports them",
+		config.server, verification, errmsg, config.server,
+	)
+	if !isTerminal(os.Stdin) {
+		return ReportMsg("%s", hint).withCategory(errorCategoryAuth)
+	}
+	fmt.Println(hint)
+	fmt.Print("Paste the ljsession cookie or press Enter to give up (it will be echoed): ")
+	cookie, err := readFileFirstLine("-")
+	if err != nil {
+		return WrapErr(err, "")
+	}
+	if len(cookie) == 0 {
+		return ReportMsg("login to %s was not completed", config.server).withCategory(errorCategoryAuth)
+	}
+	return (&cookieAuthenticator{string(cookie)}).login(session)
+}
+
+// Use the ljsession cookie of a browser login
+type cookieAuthenticator struct {
+	cookie string
+}
+
+func (a *cookieAuthenticator) authMethod() string {
+	return "cookie"
+}
+
+func (a *cookieAuthenticator) login(session *ljSession) *Report {
+	session.setLoginCookie(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(a.cookie), "ljsession=")))
+	session.auth = a
+	if _, r := callLJFlatMathod("login", session); r != nil {
+		rejected := ReportMsg(
+			"%s rejected the session cookie, log in with a browser again and copy the new ljsession cookie", session.config.server,
+		)
+		if r.errCategory() == "" {
+			rejected.withCategory(errorCategoryAuth)
+		}
+		return CombineReports(r, rejected)
+	}
+	log("Logged in to %s with the session cookie", session.config.server)
+	return nil
+}
+
+type oauthAuthenticator struct {
+	config *Config
+}
+
+func (a *oauthAuthenticator) authMethod() string {
+	return "oauth"
+}
+
+func (a *oauthAuthenticator) login(session *ljSession) *Report {
+	oauth := a.config.oauth
+	session.bearerToken = oauth.accessToken
+	if oauth.refreshToken != "" && oauth.tokenUrl != "" {
+		refreshToken, r := readOAuthRefreshToken(a.config)
+		if r != nil {
+			return r
+		}
+		log("Refreshing the OAuth access token at %s", oauth.tokenUrl)
+		accessToken, newRefreshToken, r := refreshOAuthToken(session, refreshToken)
+		if r != nil {
+			return r
+		}
+		session.bearerToken = accessToken
+		if newRefreshToken != "" && newRefreshToken != refreshToken {
+			a.config.errorLog.addSecret(newRefreshToken)
+			if r := writeOAuthRefreshToken(a.config, newRefreshToken); r != nil {
+				return r
+			}
+		}
+	}
+	if session.bearerToken == "" {
+		return ReportMsg("no OAuth access token for %s, add <accessToken> or <refreshToken> with <tokenUrl> to <oauth> in the config", a.config.server).withCategory(errorCategoryAuth)
+	}
+	a.config.errorLog.addSecret(session.bearerToken)
+	log("Using OAuth access token for %s", a.config.server)
+	return nil
+}
+
+// Get the latest refresh token. The stored token is used only while the
+// config still has the token it was obtained from, so replacing the token
+// in the config after a new authorization takes effect.
+func readOAuthRefreshToken(config *Config) (string, *Report) {
+	refreshToken := config.oauth.refreshToken
+	path := filepath.Join(config.accountDataDir, oauthDBFileName)
+	data, err := archiveStore.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return refreshToken, nil
+		}
+		return "", WrapErr(err, "")
+	}
+	var stored, origin string
+	d := newLinedbDecoder(data)
+	for d.NextItem() {
+		if d.ItemKind == linedb.ScalarItem {
+			switch d.ItemName {
+			case "refreshToken":
+				stored = d.GetString()
+			case "configRefreshToken":
+				origin = d.GetString()
+			}
+		}
+	}
+	if err := d.GetError(); err != nil {
+		config.warn("ignoring %s that cannot be parsed - %s", path, err.Error())
+		return refreshToken, nil
+	}
+	if stored != "" && origin == refreshToken {
+		config.errorLog.addSecret(stored)
+		refreshToken = stored
+	}
+	return refreshToken, nil
+}
+
+func writeOAuthRefreshToken(config *Config, refreshToken string) *Report {
+	if err := mkdirArchive(config.accountDataDir); err != nil {
+		return WrapErr(err, "")
+	}
+	e := linedb.NewByteEncoder()
+	e.Scalar("refreshToken").AddString(refreshToken)
+	e.Scalar("configRefreshToken").AddString(config.oauth.refreshToken)
+	path := filepath.Join(config.accountDataDir, oauthDBFileName)
+	if err := archiveStore.WriteFile(path, e.GetBytes(), 0600); err != nil {
+		return WrapErr(err, "")
+	}
+	return nil
+}
+
+// Get a new access token and possibly a new refresh token with the
+// refresh_token grant of RFC 6749
+func refreshOAuthToken(session *ljSession, refreshToken string) (string, string, *Report) {
+	oauth := session.config.oauth
+	v := url.Values{}
+	v.Set("grant_type", "refresh_token")
+	v.Set("refresh_token", refreshToken)
+	if oauth.clientId != "" {
+		v.Set("client_id", oauth.clientId)
+	}
+	if oauth.clientSecret != "" {
+		v.Set("client_secret", oauth.clientSecret)
+	}
+
+	// The token request must not carry the old access token
+	client := http.Client{Transport: session.transport}
+	resp, err := client.PostForm(oauth.tokenUrl, v)
+	var data []byte
+	if err == nil {
+		data, err = ioutil.ReadAll(resp.Body)
+		err = fuseErr(err, resp.Body.Close())
+	}
+	if err != nil {
+		return "", "", WrapErr(err, "failed to refresh the OAuth access token")
+	}
+	var result struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		Error        string `json:"error"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return "", "", WrapErr(err, "failed to parse the OAuth token response, %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK || result.AccessToken == "" {
+		return "", "", ReportMsg("failed to refresh the OAuth access token, %s %s", resp.Status, result.Error).withCategory(errorCategoryAuth)
+	}
+	return result.AccessToken, result.RefreshToken, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func Test_oauthLogin(t *testing.T) {
+	var refreshTokens []string
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/token":
+			if req.Header.Get("Authorization") != "" {
+				t.Errorf("Unexpected Authorization header in the token request")
+			}
+			req.ParseForm()
+			refreshTokens = append(refreshTokens, req.PostForm.Get("refresh_token"))
+			fmt.Fprintf(w, `{"access_token":"access-%d","refresh_token":"refresh-%d","token_type":"bearer"}`,
+				len(refreshTokens), len(refreshTokens))
+		default:
+			authorization = req.Header.Get("Authorization")
+		}
+	}))
+	defer server.Close()
+
+	accountDataDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(accountDataDir)
+	config := &Config{
+		server:         server.URL,
+		username:       "bob",
+		accountDataDir: accountDataDir,
+		oauth: &oauthConfig{
+			refreshToken: "initial",
+			tokenUrl:     server.URL + "/token",
+		},
+	}
+	for run := 0; run < 2; run++ {
+		session, r := openLJSession(config)
+		if r != nil {
+			t.Fatal(r.AsText())
+		}
+		if _, err := session.client.Get(server.URL + "/interface/flat"); err != nil {
+			t.Fatal(err)
+		}
+		if expected := fmt.Sprintf("Bearer access-%d", run+1); authorization != expected {
+			t.Errorf("Expected %q, got %q", expected, authorization)
+		}
+		if session.auth.authMethod() != "oauth" {
+			t.Errorf("Unexpected auth method %s", session.auth.authMethod())
+		}
+	}
+	if len(refreshTokens) != 2 || refreshTokens[0] != "initial" || refreshTokens[1] != "refresh-1" {
+		t.Errorf("Expected the rotated refresh token on the second login, got %q", refreshTokens)
+	}
+
+	// A new token in the config replaces the stored one
+	config.oauth.refreshToken = "reauthorized"
+	if _, r := openLJSession(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if refreshTokens[2] != "reauthorized" {
+		t.Errorf("Expected the token from the config, got %q", refreshTokens[2])
+	}
+}
+
+func Test_loginVerification(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		switch req.Form.Get("mode") {
+		case "getchallenge":
+			fmt.Fprint(w, "success\nOK\nchallenge\nc0ffee\n")
+		case "sessiongenerate":
+			fmt.Fprint(w, "success\nFAIL\nerrmsg\nLogin requires solving a CAPTCHA\n")
+		case "login":
+			if req.Header.Get("Cookie") != "ljsession=v1:u1:s1:browser" || req.Form.Get("auth_method") != "cookie" {
+				fmt.Fprint(w, "success\nFAIL\nerrmsg\nInvalid session\n")
+				return
+			}
+			fmt.Fprint(w, "success\nOK\nname\nBob\n")
+		}
+	}))
+	defer server.Close()
+
+	config := &Config{server: server.URL, username: "bob", password: "password"}
+	_, r := openLJSession(config)
+	if r == nil || !strings.Contains(r.AsText(), "requires a CAPTCHA") || !strings.Contains(r.AsText(), "-session-cookie-file") {
+		t.Errorf("Expected CAPTCHA hint, got %v", r)
+	}
+
+	config.sessionCookie = "ljsession=v1:u1:s1:browser\n"
+	session, r := openLJSession(config)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if session.loginCookie != "v1:u1:s1:browser" {
+		t.Errorf("Unexpected cookie %q", session.loginCookie)
+	}
+
+	config.sessionCookie = "v1:u1:s1:expired"
+	if _, r := openLJSession(config); r == nil || !strings.Contains(r.AsText(), "rejected the session cookie") {
+		t.Errorf("Expected rejected cookie, got %v", r)
+	}
+}
+
+func Test_loginVerificationKind(t *testing.T) {
+	cases := map[string]string{
+		"Please solve the CAPTCHA":        "a CAPTCHA",
+		"Enter your two-factor auth code": "a two-factor code",
+		"2FA required":                    "a two-factor code",
+		"Invalid password":                "",
+		"Account is suspended":            "",
+	}
+	for errmsg, expected := range cases {
+		if kind := loginVerificationKind(errmsg); kind != expected {
+			t.Errorf("Expected %q for %q, got %q", expected, errmsg, kind)
+		}
+	}
+}
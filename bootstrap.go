@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// With -bootstrap the first dump of a journal gets the entries from the
+// monthly export of export_do.bml, one request per month with entries,
+// instead of one getevents call per entry. The months come from
+// getdaycounts and the exported ones are recorded in the journal DB, so an
+// interrupted bootstrap continues with the next month. The export has no
+// tags and other properties except the mood and music, so each entry is
+// recorded with the server time of its export. The following syncitems
+// pass reports every entry of the journal and keeps the exported file when
+// the entry did not change after the export, otherwise the entry is
+// fetched with getevents as usual. The kept entries are queued in the
+// journal DB and fetched in full after the pass, so the archive has the
+// exported text early and the complete entries eventually. An entry leaves
+// the queue only after its fetch is committed.
+
+// The time in the form of syncitems times from the Date header of the
+// response or from the local clock
+func serverResponseTime(resp *http.Response) string {
+	t, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		t = time.Now()
+	}
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+type exportedEntry struct {
+	ItemId       int64  `xml:"itemid"`
+	EventTime    string `xml:"eventtime"`
+	LogTime      string `xml:"logtime"`
+	Subject      string `xml:"subject"`
+	Event        string `xml:"event"`
+	Security     string `xml:"security"`
+	AllowMask    int64  `xml:"allowmask"`
+	CurrentMood  string `xml:"current_mood"`
+	CurrentMusic string `xml:"current_music"`
+}
+
+// Convert into the struct of getevents
+func (entry *exportedEntry) event() map[string]interface{} {
+	event := map[string]interface{}{
+		"itemid":    entry.ItemId,
+		"eventtime": entry.EventTime,
+		"logtime":   entry.LogTime,
+		"subject":   entry.Subject,
+		"event":     entry.Event,
+	}
+
+	// getevents omits the security of public entries
+	if entry.Security != "" && entry.Security != "public" {
+		event["security"] = entry.Security
+		if entry.Security == "usemask" {
+			event["allowmask"] = entry.AllowMask
+		}
+	}
+	props := make(map[string]interface{})
+	if entry.CurrentMood != "" {
+		props["current_mood"] = entry.CurrentMood
+	}
+	if entry.CurrentMusic != "" {
+		props["current_music"] = entry.CurrentMusic
+	}
+	if len(props) != 0 {
+		event["props"] = props
+	}
+	return event
+}
+
+func fetchMonthExport(session *ljSession, journal string, year, month int) ([]exportedEntry, string, *Report) {
+	values := url.Values{
+		"what":            {"journal"},
+		"year":            {strconv.Itoa(year)},
+		"month":           {fmt.Sprintf("%02d", month)},
+		"format":          {"xml"},
+		"header":          {"on"},
+		"notranslation":   {"on"},
+		"field_itemid":    {"on"},
+		"field_eventtime": {"on"},
+		"field_logtime":   {"on"},
+		"field_subject":   {"on"},
+		"field_event":     {"on"},
+		"field_security":  {"on"},
+		"field_allowmask": {"on"},
+		"field_currents":  {"on"},
+	}
+	if journal != session.config.username {
+		values.Set("authas", journal)
+	}
+	posturl := session.config.server + "/export_do.bml"
+	resp, err := session.client.PostForm(posturl, values)
+	if err != nil {
+		return nil, "", WrapErr(err, "failed to post %s", posturl)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	err = fuseErr(err, resp.Body.Close())
+	if err != nil {
+		return nil, "", WrapErr(err, "failed to read %s", posturl)
+	}
+	if resp.StatusCode != 200 {
+		return nil, "", ReportMsg("unexpected status %s for %s", resp.Status, posturl)
+	}
+	var export struct {
+		XMLName xml.Name        `xml:"livejournal"`
+		Entries []exportedEntry `xml:"entry"`
+	}
+	if err := xml.Unmarshal(data, &export); err != nil {
+		return nil, "", WrapErr(err, "failed to parse the export of %d-%02d of %s", year, month, journal)
+	}
+	return export.Entries, serverResponseTime(resp), nil
+}
+
+var dayCountDatePattern = regexp.MustCompile(`^([0-9]{4})-([0-9]{2})-[0-9]{2}$`)
+
+// Archive the entries of the months with entries that were not exported
+// yet. The caller stops when jcx.suspended is set.
+func bootstrapJournalPosts(jcx *journalContext) *Report {
+	dayCounts, r := fetchServerDayCounts(jcx.session, jcx.name)
+	if r != nil {
+		return r
+	}
+	monthSet := make(map[string]bool)
+	for _, dayCount := range dayCounts {
+		if dayCount.Count == 0 {
+			continue
+		}
+		if m := dayCountDatePattern.FindStringSubmatch(dayCount.Date); m != nil {
+			monthSet[m[1]+"-"+m[2]] = true
+		} else {
+			jcx.config.warn("unexpected date %s in getdaycounts response", dayCount.Date)
+		}
+	}
+	months := make([]string, 0, len(monthSet))
+	for month := range monthSet {
+		if !jcx.db.bootstrapMonths[month] {
+			months = append(months, month)
+		}
+	}
+	sort.Strings(months)
+	if len(months) != 0 {
+		log("Fetching entries of %d months of %s from the monthly export", len(months), jcx.name)
+	}
+	if jcx.db.bootstrapItems == nil {
+		jcx.db.bootstrapItems = make(map[int64]string)
+	}
+	if jcx.db.bootstrapMonths == nil {
+		jcx.db.bootstrapMonths = make(map[string]bool)
+	}
+	for _, month := range months {
+		if jcx.sliceExpired() {
+			return nil
+		}
+		year, _ := strconv.Atoi(month[0:4])
+		monthNumber, _ := strconv.Atoi(month[5:7])
+		entries, exportTime, r := fetchMonthExport(jcx.session, jcx.name, year, monthNumber)
+		if r != nil {
+			return r
+		}
+		log("Exported %d entries of %s", len(entries), month)
+		for i := range entries {
+			entry := &entries[i]
+			if entry.ItemId <= 0 {
+				return ReportMsg("invalid itemid %d in the export of %s of %s", entry.ItemId, month, jcx.name)
+			}
+			if r := jcx.stageEntry(entry.ItemId, entry.event()); r != nil {
+				return r
+			}
+			jcx.db.bootstrapItems[entry.ItemId] = exportTime
+			jcx.newEntryIds = append(jcx.newEntryIds, entry.ItemId)
+		}
+		jcx.db.bootstrapMonths[month] = true
+		jcx.shouldWriteDB = true
+		if r := jcx.commitPendingWrites(); r != nil {
+			return r
+		}
+		jcx.newEntries += len(entries)
+		jcx.config.budget.addNewEntries(len(entries))
+	}
+	return nil
+}
+
+// Check if the synced item is an entry from the export that did not change
+// after it. Such an entry is queued for the fetch in full.
+func (jcx *journalContext) keepBootstrappedEntry(itemId int64, action, syncTime string) bool {
+	exportTime, ok := jcx.db.bootstrapItems[itemId]
+	if !ok {
+		return false
+	}
+	delete(jcx.db.bootstrapItems, itemId)
+	jcx.shouldWriteDB = true
+	if action == "del" || syncTime >= exportTime {
+		return false
+	}
+	jcx.db.bootstrapRefetch = append(jcx.db.bootstrapRefetch, itemId)
+	return true
+}
+
+// Fetch the kept exported entries with getevents to get their tags and
+// other properties. The caller stops when the time slice is over.
+func refetchBootstrappedEntries(jcx *journalContext) *Report {
+	if len(jcx.db.bootstrapRefetch) == 0 {
+		return nil
+	}
+	log("Fetching %d entries of %s from the monthly export in full", len(jcx.db.bootstrapRefetch), jcx.name)
+	for len(jcx.db.bootstrapRefetch) != 0 {
+		if jcx.sliceExpired() {
+			return nil
+		}
+		itemId := jcx.db.bootstrapRefetch[0]
+		params := usejournalParams(jcx.config, jcx.name, map[string]interface{}{
+			"selecttype":  "one",
+			"itemid":      itemId,
+			"lineendings": "unix",
+		})
+		var result struct {
+			Events []map[string]interface{} `xmlrpc:"events"`
+		}
+		if r := callLJXmlRpcMethod(jcx.session, "getevents", params, &result); r != nil {
+			// The exported entry is still archived, so the dump goes on
+			jcx.config.warn("failed to fetch L-%d of %s in full, the next dump tries again - %s", itemId, jcx.name, r.AsText())
+			return nil
+		}
+		if len(result.Events) == 0 {
+			// Deleted on the server since, the next syncitems reports it
+			log("Entry L-%d from the monthly export is no longer on the server", itemId)
+		} else if r := jcx.stageEntry(itemId, result.Events[0]); r != nil {
+			return r
+		}
+		jcx.db.bootstrapRefetch = jcx.db.bootstrapRefetch[1:]
+		jcx.shouldWriteDB = true
+		if r := jcx.commitPendingWrites(); r != nil {
+			return r
+		}
+	}
+	return nil
+}
+
+// Called when syncitems reported all items. An exported entry that
+// syncitems never reported was deleted after the export.
+func finishBootstrap(jcx *journalContext) {
+	if len(jcx.db.bootstrapItems) == 0 && len(jcx.db.bootstrapMonths) == 0 {
+		return
+	}
+	if len(jcx.db.bootstrapItems) != 0 {
+		ids := make(sortIds, 0, len(jcx.db.bootstrapItems))
+		for itemId := range jcx.db.bootstrapItems {
+			ids = append(ids, itemId)
+		}
+		sort.Sort(ids)
+		jcx.config.warn("%d entries of %s from the monthly export were not reported by syncitems and may have been deleted, like L-%d",
+			len(ids), jcx.name, ids[0])
+	}
+	jcx.db.bootstrapItems = nil
+	jcx.db.bootstrapMonths = nil
+	jcx.shouldWriteDB = true
+}
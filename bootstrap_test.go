@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_bootstrapJournalPosts(t *testing.T) {
+	server := newFakeLJServer(t)
+	defer server.Close()
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	config := &Config{
+		server:         server.URL,
+		username:       "con",
+		password:       "password",
+		journals:       []string{"con"},
+		dumpDir:        dumpDir,
+		accountDataDir: filepath.Join(dumpDir, accountDataDirName),
+		journalAliases: make(map[string]string),
+		bootstrap:      true,
+	}
+
+	// The entry from the export stays queued until its fetch succeeds
+	server.geteventsFault = true
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	server.geteventsFault = false
+	if server.geteventsCalls != 0 {
+		t.Errorf("Expected the entry from the export, got %d getevents calls", server.geteventsCalls)
+	}
+	journalDir := filepath.Join(dumpDir, "con_")
+	entry, err := readArchivedEntry(filepath.Join(journalDir, "L-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.subject != "First" || entry.event != "Hello" || entry.security != "public" || entry.props["current_mood"] != "happy" {
+		t.Errorf("Unexpected entry %+v", entry)
+	}
+	jcx := &journalContext{config: config, name: "con", dir: journalDir}
+	if r := readJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if jcx.db.lastSync != "2020-01-01 10:00:00" || len(jcx.db.bootstrapItems) != 0 || len(jcx.db.bootstrapMonths) != 0 ||
+		len(jcx.db.bootstrapRefetch) != 1 || jcx.db.bootstrapRefetch[0] != 1 {
+		t.Errorf("Unexpected journal DB after the bootstrap %+v", jcx.db)
+	}
+
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if server.geteventsCalls != 1 {
+		t.Errorf("Expected the exported entry to be fetched in full, got %d getevents calls", server.geteventsCalls)
+	}
+	if r := readJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(jcx.db.bootstrapRefetch) != 0 {
+		t.Errorf("Expected the fetched entry removed from the queue, got %v", jcx.db.bootstrapRefetch)
+	}
+
+	// An entry edited after the export is fetched again
+	jcx.db.lastSync = ""
+	jcx.db.bootstrapItems = map[int64]string{1: "2019-12-31 00:00:00"}
+	jcx.db.bootstrapMonths = map[string]bool{"2020-01": true}
+	if r := writeJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if server.geteventsCalls != 2 {
+		t.Errorf("Expected the edited entry to be fetched, got %d getevents calls", server.geteventsCalls)
+	}
+}
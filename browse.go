@@ -0,0 +1,546 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Terminal browser for the archive. To avoid extra dependencies it switches
+// the terminal into the non-canonical mode with stty and draws the screen
+// with ANSI escape sequences.
+
+type browseItem struct {
+	journal string
+	dir     string
+	entry   *archivedEntry
+
+	// Lower-cased subject, tags and text for the search, computed on demand
+	searchText string
+}
+
+type sortBrowseItems []*browseItem
+
+func (a sortBrowseItems) Len() int { return len(a) }
+func (a sortBrowseItems) Less(i, j int) bool {
+	return sortEntriesByTime{a[i].entry, a[j].entry}.Less(0, 1)
+}
+func (a sortBrowseItems) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+
+func (item *browseItem) matches(query string) bool {
+	if item.searchText == "" {
+		item.searchText = strings.ToLower(
+			item.entry.subject + "\n" + strings.Join(item.entry.tags(), "\n") + "\n" + htmlToText(item.entry.event),
+		)
+	}
+	return strings.Contains(item.searchText, query)
+}
+
+type browser struct {
+	config  *Config
+	friends *friendsData
+	items   []*browseItem
+
+	// Indexes of items that match the query
+	visible []int
+	query   string
+
+	// Position in visible and the first shown line of the list
+	cursor int
+	top    int
+
+	rows int
+	cols int
+
+	in      *bufio.Reader
+	out     *bufio.Writer
+	message string
+}
+
+func runStty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+func (b *browser) updateSize() {
+	b.rows, b.cols = 24, 80
+	size, err := runStty("size")
+	if err != nil {
+		return
+	}
+	parts := strings.Fields(size)
+	if len(parts) != 2 {
+		return
+	}
+	rows, err1 := strconv.Atoi(parts[0])
+	cols, err2 := strconv.Atoi(parts[1])
+	if err1 == nil && err2 == nil && rows >= 8 && cols >= 20 {
+		b.rows, b.cols = rows, cols
+	}
+}
+
+// Cut the string to at most width runes and pad it with spaces to width.
+func fitLine(s string, width int) string {
+	s = strings.Map(func(r rune) rune {
+		if r < ' ' {
+			return ' '
+		}
+		return r
+	}, s)
+	n := utf8.RuneCountInString(s)
+	if n > width {
+		runes := []rune(s)
+		return string(runes[:width])
+	}
+	return s + strings.Repeat(" ", width-n)
+}
+
+// Split the text into lines of at most width runes breaking at spaces when
+// possible.
+func wrapText(text string, width int) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		runes := []rune(strings.TrimRight(paragraph, " \t\r"))
+		if len(runes) == 0 {
+			lines = append(lines, "")
+			continue
+		}
+		for len(runes) > width {
+			cut := width
+			for i := width; i > width/2; i-- {
+				if runes[i] == ' ' {
+					cut = i
+					break
+				}
+			}
+			lines = append(lines, string(runes[:cut]))
+			runes = runes[cut:]
+			for len(runes) != 0 && runes[0] == ' ' {
+				runes = runes[1:]
+			}
+		}
+		lines = append(lines, string(runes))
+	}
+	return lines
+}
+
+const (
+	keyUp        = "up"
+	keyDown      = "down"
+	keyPageUp    = "pgup"
+	keyPageDown  = "pgdn"
+	keyHome      = "home"
+	keyEnd       = "end"
+	keyEnter     = "enter"
+	keyEscape    = "esc"
+	keyBackspace = "backspace"
+	keyQuit      = "quit"
+)
+
+// Read a key press and return either the name of a special key or the
+// typed character.
+func (b *browser) readKey() (string, error) {
+	r, _, err := b.in.ReadRune()
+	if err != nil {
+		return "", err
+	}
+	switch r {
+	case '\r', '\n':
+		return keyEnter, nil
+	case 127, 8:
+		return keyBackspace, nil
+	case 3, 4:
+		return keyQuit, nil
+	case 27:
+		if b.in.Buffered() == 0 {
+			return keyEscape, nil
+		}
+		seq := ""
+		for b.in.Buffered() != 0 {
+			c, err := b.in.ReadByte()
+			if err != nil {
+				return "", err
+			}
+			seq += string(c)
+			if len(seq) > 1 && (c == '~' || c >= 'A' && c <= 'Z') {
+				break
+			}
+		}
+		switch seq {
+		case "[A", "OA":
+			return keyUp, nil
+		case "[B", "OB":
+			return keyDown, nil
+		case "[5~":
+			return keyPageUp, nil
+		case "[6~":
+			return keyPageDown, nil
+		case "[H", "OH", "[1~":
+			return keyHome, nil
+		case "[F", "OF", "[4~":
+			return keyEnd, nil
+		}
+		return keyEscape, nil
+	}
+	return string(r), nil
+}
+
+func (b *browser) applyQuery(query string) {
+	b.query = strings.ToLower(query)
+	b.visible = b.visible[:0]
+	for i, item := range b.items {
+		if b.query == "" || item.matches(b.query) {
+			b.visible = append(b.visible, i)
+		}
+	}
+	b.cursor = len(b.visible) - 1
+	if b.cursor < 0 {
+		b.cursor = 0
+	}
+	b.top = 0
+}
+
+func (b *browser) current() *browseItem {
+	if len(b.visible) == 0 {
+		return nil
+	}
+	return b.items[b.visible[b.cursor]]
+}
+
+func (b *browser) moveCursor(delta int) {
+	b.cursor += delta
+	if b.cursor >= len(b.visible) {
+		b.cursor = len(b.visible) - 1
+	}
+	if b.cursor < 0 {
+		b.cursor = 0
+	}
+}
+
+func (b *browser) writeLine(s string, inverse bool) {
+	if inverse {
+		b.out.WriteString("\x1b[7m")
+	}
+	b.out.WriteString(fitLine(s, b.cols))
+	if inverse {
+		b.out.WriteString("\x1b[0m")
+	}
+	b.out.WriteString("\r\n")
+}
+
+func (b *browser) writeStatus(s string) {
+	b.out.WriteString("\x1b[7m")
+	b.out.WriteString(fitLine(s, b.cols))
+	b.out.WriteString("\x1b[0m")
+}
+
+func (b *browser) listRows() int {
+	n := (b.rows - 3) / 2
+	if n < 3 {
+		n = 3
+	}
+	return n
+}
+
+func itemListLine(item *browseItem) string {
+	date := item.entry.eventTime
+	if len(date) > 10 {
+		date = date[:10]
+	}
+	label := ""
+	if level := item.entry.securityLevel(); level != securityPublic {
+		label = " [" + level.label() + "]"
+	}
+	return fmt.Sprintf(" %s  %-12s %s%s", date, item.journal, entryDisplaySubject(item.entry), label)
+}
+
+func (b *browser) drawList() {
+	b.updateSize()
+	listRows := b.listRows()
+	if b.cursor < b.top {
+		b.top = b.cursor
+	} else if b.cursor >= b.top+listRows {
+		b.top = b.cursor - listRows + 1
+	}
+
+	b.out.WriteString("\x1b[H\x1b[2J")
+	title := fmt.Sprintf(" ljdumpgo browse - %d entries", len(b.visible))
+	if b.query != "" {
+		title += fmt.Sprintf(" matching \"%s\"", b.query)
+	}
+	b.writeLine(title, true)
+	for i := b.top; i < b.top+listRows; i++ {
+		if i < len(b.visible) {
+			b.writeLine(itemListLine(b.items[b.visible[i]]), i == b.cursor)
+		} else {
+			b.writeLine("", false)
+		}
+	}
+	b.writeLine(strings.Repeat("-", b.cols), false)
+
+	previewRows := b.rows - listRows - 3
+	var preview []string
+	if item := b.current(); item != nil {
+		preview = wrapText(htmlToText(item.entry.event), b.cols)
+	} else {
+		preview = []string{"No entries"}
+	}
+	for i := 0; i < previewRows; i++ {
+		if i < len(preview) {
+			b.writeLine(preview[i], false)
+		} else {
+			b.writeLine("", false)
+		}
+	}
+	status := " j/k: move  Enter: read  /: search  q: quit"
+	if b.message != "" {
+		status = " " + b.message
+		b.message = ""
+	}
+	b.writeStatus(status)
+	b.out.Flush()
+}
+
+// Format the entry with its comment threads as lines of the screen width.
+func (b *browser) entryLines(item *browseItem) []string {
+	entry := item.entry
+	var lines []string
+	lines = append(lines, wrapText(entryDisplaySubject(entry), b.cols)...)
+	meta := item.journal + "  " + entry.eventTime
+	if level := entry.securityLevel(); level != securityPublic {
+		meta += "  [" + level.label() + "]"
+	}
+	if tags := entry.tags(); len(tags) != 0 {
+		meta += "  Tags: " + strings.Join(tags, ", ")
+	}
+	if mood := entry.props["current_mood"]; mood != "" {
+		meta += "  Mood: " + mood
+	}
+	lines = append(lines, wrapText(meta, b.cols)...)
+	lines = append(lines, "")
+	lines = append(lines, wrapText(htmlToText(entry.event), b.cols)...)
+
+	comments, r := readEntryComments(entry.dir, entry.itemId)
+	if r != nil {
+		lines = append(lines, "", "Failed to read comments: "+r.AsText())
+		return lines
+	}
+	if len(comments) != 0 {
+		lines = append(lines, "", fmt.Sprintf("--- %d comments ---", len(comments)))
+	}
+	for _, thread := range threadComments(comments) {
+		c := thread.comment
+		depth := thread.depth
+		if depth > b.cols/8 {
+			depth = b.cols / 8
+		}
+		indent := strings.Repeat("  ", depth)
+		width := b.cols - len(indent) - 2
+		header := commenterLabel(b.config, b.friends, c) + "  " + c.Date
+		if c.Subject != "" {
+			header += "  " + c.Subject
+		}
+		if deleted := commentDeletedLabel(b.config, c); deleted != "" {
+			header += "  (" + deleted + ")"
+		} else if c.State != "" && c.State != "A" {
+			header += "  (" + c.State + ")"
+		}
+		lines = append(lines, "")
+		for _, line := range wrapText(header, width) {
+			lines = append(lines, indent+"| "+line)
+		}
+		for _, line := range wrapText(htmlToText(c.Body), width) {
+			lines = append(lines, indent+"| "+line)
+		}
+	}
+	return lines
+}
+
+// Show the current entry with comments until the user returns to the list.
+func (b *browser) viewEntry() error {
+	var lines []string
+	offset, cols := 0, 0
+	for {
+		item := b.current()
+		if item == nil {
+			return nil
+		}
+		b.updateSize()
+		if lines == nil || cols != b.cols {
+			lines, cols = b.entryLines(item), b.cols
+		}
+		textRows := b.rows - 1
+		if offset > len(lines)-textRows {
+			offset = len(lines) - textRows
+		}
+		if offset < 0 {
+			offset = 0
+		}
+		b.out.WriteString("\x1b[H\x1b[2J")
+		for i := offset; i < offset+textRows; i++ {
+			if i < len(lines) {
+				b.writeLine(lines[i], i == 0)
+			} else {
+				b.writeLine("", false)
+			}
+		}
+		b.writeStatus(fmt.Sprintf(" %d/%d  j/k: scroll  space/b: page  n/p: next/previous entry  q: back",
+			b.cursor+1, len(b.visible)))
+		b.out.Flush()
+
+		key, err := b.readKey()
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "j", keyDown, keyEnter:
+			offset++
+		case "k", keyUp:
+			offset--
+		case " ", keyPageDown:
+			offset += textRows - 1
+		case "b", keyPageUp:
+			offset -= textRows - 1
+		case "g", keyHome:
+			offset = 0
+		case "G", keyEnd:
+			offset = len(lines)
+		case "n":
+			b.moveCursor(1)
+			lines, offset = nil, 0
+		case "p":
+			b.moveCursor(-1)
+			lines, offset = nil, 0
+		case "q", keyEscape, keyBackspace, keyQuit:
+			return nil
+		}
+	}
+}
+
+// Read the search query in the status line. Return false if the user
+// cancelled the search.
+func (b *browser) readQuery() (string, bool, error) {
+	query := []rune(b.query)
+	for {
+		b.out.WriteString("\r")
+		b.writeStatus(" Search: " + string(query))
+		b.out.Flush()
+		key, err := b.readKey()
+		if err != nil {
+			return "", false, err
+		}
+		switch key {
+		case keyEnter:
+			return string(query), true, nil
+		case keyEscape, keyQuit:
+			return "", false, nil
+		case keyBackspace:
+			if len(query) != 0 {
+				query = query[:len(query)-1]
+			}
+		default:
+			if utf8.RuneCountInString(key) == 1 {
+				query = append(query, []rune(key)...)
+			}
+		}
+	}
+}
+
+func (b *browser) run() error {
+	for {
+		b.drawList()
+		key, err := b.readKey()
+		if err != nil {
+			return err
+		}
+		switch key {
+		case "j", keyDown:
+			b.moveCursor(1)
+		case "k", keyUp:
+			b.moveCursor(-1)
+		case " ", keyPageDown:
+			b.moveCursor(b.listRows())
+		case "b", keyPageUp:
+			b.moveCursor(-b.listRows())
+		case "g", keyHome:
+			b.cursor = 0
+		case "G", keyEnd:
+			b.moveCursor(len(b.visible))
+		case keyEnter:
+			if err := b.viewEntry(); err != nil {
+				return err
+			}
+		case "/":
+			query, ok, err := b.readQuery()
+			if err != nil {
+				return err
+			}
+			if ok {
+				b.applyQuery(query)
+				if len(b.visible) == 0 {
+					b.message = fmt.Sprintf("Nothing matches \"%s\"", query)
+				}
+			}
+		case keyEscape:
+			if b.query != "" {
+				b.applyQuery("")
+			}
+		case "q", keyQuit:
+			return nil
+		}
+	}
+}
+
+func runBrowse(config *Config) *Report {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return ReportMsg("browse requires an interactive terminal")
+	}
+	friends, r := readFriendsData(config)
+	if r != nil {
+		return r
+	}
+	b := &browser{
+		config:  config,
+		friends: friends,
+		in:      bufio.NewReader(os.Stdin),
+		out:     bufio.NewWriter(os.Stdout),
+	}
+	for _, journal := range config.journals {
+		dir := config.journalDir(journal)
+		entries, r := readJournalEntries(dir)
+		if r != nil {
+			return r
+		}
+		for _, entry := range entries {
+			b.items = append(b.items, &browseItem{journal: journal, dir: dir, entry: entry})
+		}
+	}
+	sort.Sort(sortBrowseItems(b.items))
+	b.applyQuery("")
+
+	savedMode, err := runStty("-g")
+	if err != nil {
+		return WrapErr(err, "failed to query the terminal mode with stty")
+	}
+	if _, err := runStty("-icanon", "-echo", "-isig", "min", "1"); err != nil {
+		return WrapErr(err, "failed to switch the terminal mode with stty")
+	}
+	b.out.WriteString("\x1b[?1049h\x1b[?25l")
+	err = b.run()
+	b.out.WriteString("\x1b[?25h\x1b[?1049l")
+	b.out.Flush()
+	if _, err2 := runStty(savedMode); err == nil && err2 != nil {
+		err = err2
+	}
+	if err != nil {
+		return WrapErr(err, "terminal error")
+	}
+	return nil
+}
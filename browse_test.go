@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func Test_browseText(t *testing.T) {
+	if s := fitLine("a\tb", 5); s != "a b  " {
+		t.Errorf("Expected a padded line with control characters replaced, got %q", s)
+	}
+	if s := fitLine("привет мир", 6); s != "привет" {
+		t.Errorf("Expected the line cut at 6 runes, got %q", s)
+	}
+	lines := wrapText("one two three four\n\nfive", 9)
+	expected := []string{"one two", "three", "four", "", "five"}
+	if strings.Join(lines, "|") != strings.Join(expected, "|") {
+		t.Errorf("Expected %q, got %q", expected, lines)
+	}
+	if lines := wrapText("abcdefghij", 4); strings.Join(lines, "|") != "abcd|efgh|ij" {
+		t.Errorf("Expected a word without spaces cut at the width, got %q", lines)
+	}
+}
+
+func Test_browseKeys(t *testing.T) {
+	b := &browser{in: bufio.NewReader(strings.NewReader("j\x1b[A\x1b[6~\x1bOH\r\x7f\x03/"))}
+	for _, expected := range []string{"j", keyUp, keyPageDown, keyHome, keyEnter, keyBackspace, keyQuit, "/"} {
+		key, err := b.readKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != expected {
+			t.Errorf("Expected %s, got %q", expected, key)
+		}
+	}
+}
+
+func Test_browseQuery(t *testing.T) {
+	b := &browser{}
+	for i, subject := range []string{"Trip to Paris", "Work", "Lunch"} {
+		entry := &archivedEntry{
+			itemId:    int64(i + 1),
+			eventTime: fmt.Sprintf("2010-05-0%d 10:00:00", i+1),
+			subject:   subject,
+			event:     "<b>Text</b> of " + subject,
+			props:     map[string]string{},
+		}
+		if i == 1 {
+			entry.props["taglist"] = "paris, office"
+		}
+		b.items = append(b.items, &browseItem{journal: "bob", entry: entry})
+	}
+
+	// The cursor starts at the newest entry
+	b.applyQuery("")
+	if len(b.visible) != 3 || b.current().entry.itemId != 3 {
+		t.Errorf("Unexpected visible items %v", b.visible)
+	}
+	b.moveCursor(-10)
+	if b.current().entry.itemId != 1 {
+		t.Errorf("Expected the cursor at the first entry, got %d", b.current().entry.itemId)
+	}
+	b.applyQuery("PARIS")
+	if len(b.visible) != 2 || b.visible[0] != 0 || b.visible[1] != 1 {
+		t.Errorf("Expected matches in the subject and the tags, got %v", b.visible)
+	}
+	b.applyQuery("text of lunch")
+	if len(b.visible) != 1 || b.current().entry.itemId != 3 {
+		t.Errorf("Expected a match in the text without markup, got %v", b.visible)
+	}
+	b.applyQuery("nothing")
+	if len(b.visible) != 0 || b.current() != nil {
+		t.Errorf("Expected no matches, got %v", b.visible)
+	}
+}
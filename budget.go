@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// -max-requests and -max-new-entries limit the work of a single run, so
+// the first dump of a big journal over a flaky connection or under strict
+// rate limits of the server can be spread over several days. A used up
+// budget stops the run like -max-runtime: the fetch loops stop after the
+// current item, the journal DBs are written and the next run continues
+// from there. Requests over the budget fail without reaching the server.
+
+type runBudget struct {
+	// The zero values mean no limit
+	maxRequests   int64
+	maxNewEntries int64
+
+	// Updated atomically as downloads run in parallel
+	requests   int64
+	newEntries int64
+}
+
+func (b *runBudget) exhausted() bool {
+	if b == nil {
+		return false
+	}
+	return b.maxRequests != 0 && atomic.LoadInt64(&b.requests) >= b.maxRequests ||
+		b.maxNewEntries != 0 && atomic.LoadInt64(&b.newEntries) >= b.maxNewEntries
+}
+
+// Count a request or fail if the budget does not allow it
+func (b *runBudget) takeRequest() error {
+	if b == nil || b.maxRequests == 0 {
+		return nil
+	}
+	if atomic.AddInt64(&b.requests, 1) > b.maxRequests {
+		return fmt.Errorf("maximum of %d requests reached", b.maxRequests)
+	}
+	return nil
+}
+
+func (b *runBudget) addNewEntries(n int) {
+	if b != nil {
+		atomic.AddInt64(&b.newEntries, int64(n))
+	}
+}
+
+func (b *runBudget) describe() string {
+	if b.maxRequests != 0 && atomic.LoadInt64(&b.requests) >= b.maxRequests {
+		return fmt.Sprintf("maximum of %d requests", b.maxRequests)
+	}
+	return fmt.Sprintf("maximum of %d new entries", b.maxNewEntries)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_runBudget(t *testing.T) {
+	server := newFakeLJServer(t)
+	defer server.Close()
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	config := &Config{
+		server:         server.URL,
+		username:       "con",
+		password:       "password",
+		journals:       []string{"con"},
+		dumpDir:        dumpDir,
+		accountDataDir: filepath.Join(dumpDir, accountDataDirName),
+		journalAliases: make(map[string]string),
+		budget:         &runBudget{maxRequests: 2},
+	}
+	r := runDump(config)
+	if r == nil || !strings.Contains(r.AsText(), "maximum of 2 requests") {
+		t.Fatalf("Expected the request budget to stop the dump, got %v", r)
+	}
+	if !config.runtimeExceeded() {
+		t.Errorf("Expected runtimeExceeded with the used up budget")
+	}
+
+	config.budget = &runBudget{maxNewEntries: 1}
+	r = runDump(config)
+	if r == nil || !strings.Contains(r.AsText(), "maximum of 1 new entries") {
+		t.Fatalf("Expected the entry budget to stop the dump, got %v", r)
+	}
+	journalDir := filepath.Join(dumpDir, "con_")
+	if _, err := os.Stat(filepath.Join(journalDir, "L-1")); err != nil {
+		t.Error(err)
+	}
+
+	config.budget = nil
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	comments, r := readEntryComments(journalDir, 1)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(comments) != 1 {
+		t.Errorf("Expected the next run to continue with comments, got %+v", comments)
+	}
+}
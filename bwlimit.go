@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bandwidth limit for media downloads set with -bwlimit. All downloads of
+// the run share one token bucket, so the limit holds for the whole run
+// independently of the request rate limit of the LJ session.
+
+// Parse the rate like 500k or 2M in bytes per second. The suffixes are
+// powers of 1024. Zero means no limit.
+func parseByteRate(s string) (int64, error) {
+	n, ok := parseByteSize(s)
+	if !ok {
+		return 0, fmt.Errorf("invalid rate %q, expected bytes per second with optional k, M or G suffix", s)
+	}
+	return n, nil
+}
+
+// Parse the number of bytes with an optional k, M or G suffix for powers of
+// 1024
+func parseByteSize(s string) (int64, bool) {
+	s = strings.TrimSpace(s)
+	multiplier := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k', 'K':
+			multiplier = 1 << 10
+		case 'm', 'M':
+			multiplier = 1 << 20
+		case 'g', 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier != 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n * multiplier, true
+}
+
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(bytesPerSecond int64) *tokenBucket {
+	rate := float64(bytesPerSecond)
+	return &tokenBucket{rate: rate, burst: rate, tokens: rate, last: time.Now()}
+}
+
+// Largest read that does not exceed the burst
+func (b *tokenBucket) maxRead() int {
+	if b.burst < 1 {
+		return 1
+	}
+	return int(b.burst)
+}
+
+// Take n bytes from the bucket and return how long to wait before the
+// bytes may be used.
+func (b *tokenBucket) take(n int) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	bucket *tokenBucket
+}
+
+func (body *limitedBody) Read(p []byte) (int, error) {
+	if max := body.bucket.maxRead(); len(p) > max {
+		p = p[:max]
+	}
+	n, err := body.ReadCloser.Read(p)
+	if n > 0 {
+		if wait := body.bucket.take(n); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-shutdownContext.Done():
+			}
+		}
+	}
+	return n, err
+}
+
+type limitedTransport struct {
+	bucket *tokenBucket
+	base   http.RoundTripper
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body = &limitedBody{res.Body, t.bucket}
+	return res, nil
+}
+
+// Client for media downloads with the -bwlimit limit.
+func (config *Config) mediaHttpClient() *http.Client {
+	client := config.httpClient()
+	if config.mediaBandwidth != nil {
+		client.Transport = &limitedTransport{config.mediaBandwidth, client.Transport}
+	}
+	return client
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_parseByteRate(t *testing.T) {
+	tests := []struct {
+		s    string
+		rate int64
+	}{
+		{"0", 0},
+		{"1000", 1000},
+		{"500k", 500 << 10},
+		{"2M", 2 << 20},
+		{"1g", 1 << 30},
+	}
+	for _, test := range tests {
+		rate, err := parseByteRate(test.s)
+		if err != nil {
+			t.Errorf("parseByteRate(%q) failed - %s", test.s, err.Error())
+		} else if rate != test.rate {
+			t.Errorf("parseByteRate(%q) = %d, expected %d", test.s, rate, test.rate)
+		}
+	}
+	for _, s := range []string{"", "k", "-1", "10x", "1.5M"} {
+		if _, err := parseByteRate(s); err == nil {
+			t.Errorf("parseByteRate(%q) succeeded", s)
+		}
+	}
+}
+
+func Test_mediaBandwidthLimit(t *testing.T) {
+	data := make([]byte, 30000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	// The first 10000 bytes are the initial burst, the rest takes two
+	// seconds
+	config := &Config{mediaBandwidth: newTokenBucket(10000)}
+	start := time.Now()
+	resp, err := config.mediaHttpClient().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) != len(data) {
+		t.Errorf("read %d bytes, expected %d", len(body), len(data))
+	}
+	if elapsed := time.Since(start); elapsed < 1500*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("download took %s, expected about 2s", elapsed)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+)
+
+// Before dumping a journal other than the own one the dump probes what the
+// account may do with it, see impersonation.go: reading entries needs
+// posting access and is probed with getdaycounts, exporting comments and
+// the community management pages need a maintainer and are probed with an
+// empty comment export. The dump logs the result, skips the steps that
+// the account cannot do with a warning and fails early for a journal it
+// can do nothing with, instead of failing in the middle of the journal on
+// a response that the server returns in place of the refused data. The own
+// journal is not probed. The doctor command prints the same summary.
+
+type journalCapabilities struct {
+	entries  bool
+	comments bool
+
+	// Community management pages and the moderation queue
+	communityData bool
+}
+
+var ownJournalCapabilities = journalCapabilities{entries: true, comments: true}
+
+func probeJournalCapabilities(session *ljSession, journal string) (journalCapabilities, *Report) {
+	if journal == session.config.username {
+		return ownJournalCapabilities, nil
+	}
+	var caps journalCapabilities
+	if _, r := fetchServerDayCounts(session, journal); r == nil {
+		caps.entries = true
+	} else if !isJournalAccessError(r) {
+		return caps, r
+	}
+	caps.comments = canExportComments(session, journal)
+	caps.communityData = caps.comments
+	return caps, nil
+}
+
+func (caps journalCapabilities) summary() string {
+	yesNo := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+	return strings.Join([]string{
+		"entries " + yesNo(caps.entries),
+		"comments " + yesNo(caps.comments),
+		"community data " + yesNo(caps.communityData),
+	}, ", ")
+}
+
+// Probe the journal and warn about the steps that the dump skips. Fail
+// when the account can archive nothing of the journal.
+func (jcx *journalContext) probeCapabilities() *Report {
+	if jcx.session.auth == nil || jcx.name == jcx.config.username {
+		return nil
+	}
+	caps, r := probeJournalCapabilities(jcx.session, jcx.name)
+	if r != nil {
+		return r
+	}
+	jcx.caps = caps
+	log("Access of %s to %s: %s", jcx.config.username, jcx.name, caps.summary())
+	if !caps.entries && !caps.comments {
+		return journalAccessReport(jcx.config, jcx.name, "entries or comments", "a member with posting access or a maintainer", nil)
+	}
+	if !caps.entries {
+		jcx.config.warn("skipping entries of %s as %s is not a member with posting access", jcx.name, jcx.config.username)
+	}
+	if !caps.comments {
+		jcx.config.warn("skipping comments of %s as %s is not its maintainer", jcx.name, jcx.config.username)
+	}
+	return nil
+}
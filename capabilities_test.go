@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_probeJournalCapabilities(t *testing.T) {
+	server := newFakeLJServer(t)
+	defer server.Close()
+
+	config := &Config{
+		server:         server.URL,
+		username:       "con",
+		password:       "password",
+		journalAliases: make(map[string]string),
+	}
+	session, r := openLJSession(config)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	probe := func() journalCapabilities {
+		caps, r := probeJournalCapabilities(session, "team")
+		if r != nil {
+			t.Fatal(r.AsText())
+		}
+		return caps
+	}
+	if caps := probe(); caps != (journalCapabilities{true, true, true}) {
+		t.Errorf("Expected full access, got %s", caps.summary())
+	}
+	server.usejournalFault = true
+	if caps := probe(); caps.summary() != "entries no, comments yes, community data yes" {
+		t.Errorf("Unexpected access of a maintainer that is not a member %s", caps.summary())
+	}
+	server.authasForbidden = true
+	if caps := probe(); caps.entries || caps.comments || caps.communityData {
+		t.Errorf("Expected no access, got %s", caps.summary())
+	}
+	if caps, r := probeJournalCapabilities(session, "con"); r != nil || caps != ownJournalCapabilities {
+		t.Errorf("Expected the own journal not probed, got %+v", caps)
+	}
+}
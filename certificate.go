@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"linedb"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// When verify finds no problems in a journal it writes verified.linedb into
+// the journal directory. The file records what was verified so stats can
+// show how long ago the archive was last known to be complete.
+const verifiedFileName = "verified.linedb"
+
+type idRange struct {
+	count int
+	first int64
+	last  int64
+}
+
+func (ir *idRange) add(id int64) {
+	if ir.count == 0 || id < ir.first {
+		ir.first = id
+	}
+	if ir.count == 0 || id > ir.last {
+		ir.last = id
+	}
+	ir.count++
+}
+
+func (ir *idRange) String() string {
+	if ir.count == 0 {
+		return "none"
+	}
+	return fmt.Sprintf("%d, ids %d-%d", ir.count, ir.first, ir.last)
+}
+
+type verifiedCheck struct {
+	name   string
+	result string
+}
+
+type verificationCertificate struct {
+	verified time.Time
+	lastSync string
+	entries  idRange
+	comments idRange
+	checks   []verifiedCheck
+}
+
+func readVerificationCertificate(dir string) (*verificationCertificate, *Report) {
+	dbpath := filepath.Join(dir, verifiedFileName)
+	dbdata, err := archiveStore.ReadFile(dbpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, WrapErr(err, "")
+	}
+	cert := &verificationCertificate{}
+	d := newLinedbDecoder(dbdata)
+	for d.NextItem() {
+		switch d.ItemKind {
+		case linedb.ScalarItem:
+			switch d.ItemName {
+			case "verified":
+				cert.verified, err = time.Parse(time.RFC3339, d.GetString())
+				if err != nil {
+					return nil, WrapErr(err, "bad verification time in %s", dbpath)
+				}
+			case "lastSync":
+				cert.lastSync = d.GetString()
+			}
+		case linedb.TableItem:
+			for d.NextRow() {
+				switch d.ItemName {
+				case "ranges":
+					kind, ir := d.GetString(), idRange{d.GetInt(), d.GetInt64(), d.GetInt64()}
+					switch kind {
+					case "entries":
+						cert.entries = ir
+					case "comments":
+						cert.comments = ir
+					}
+				case "checks":
+					cert.checks = append(cert.checks, verifiedCheck{d.GetString(), d.GetString()})
+				}
+			}
+		}
+	}
+	if err := d.GetError(); err != nil {
+		return nil, WrapErr(err, "error while parsing verification certificate %s as linedb", dbpath)
+	}
+	return cert, nil
+}
+
+func writeVerificationCertificate(dir string, cert *verificationCertificate) *Report {
+	e := linedb.NewByteEncoder()
+	e.Scalar("verified").AddString(cert.verified.UTC().Format(time.RFC3339))
+	e.Scalar("lastSync").AddString(cert.lastSync)
+	e.Comment("kind count first-id last-id")
+	e.Table("ranges")
+	e.AddString("entries").AddInt(cert.entries.count).AddInt64(cert.entries.first).AddInt64(cert.entries.last).EndRow()
+	e.AddString("comments").AddInt(cert.comments.count).AddInt64(cert.comments.first).AddInt64(cert.comments.last).EndRow()
+	e.EndTable()
+	e.Comment("check result")
+	e.Table("checks")
+	for _, check := range cert.checks {
+		e.AddString(check.name).AddString(check.result).EndRow()
+	}
+	e.EndTable()
+	dbpath := filepath.Join(dir, verifiedFileName)
+	if err := writeFileTempRename(dbpath, e.GetBytes()); err != nil {
+		return WrapErr(err, "failed to write verification certificate %s", dbpath)
+	}
+	return nil
+}
+
+// Write the certificate after verifyJournal found no problems.
+func certifyJournal(config *Config, journal string, checkedFiles int, counts *serverCounts) *Report {
+	dir := config.journalDir(journal)
+	entries, r := readJournalEntries(dir)
+	if r != nil {
+		return r
+	}
+	jcx := &journalContext{config: config, name: journal, dir: dir}
+	if r := readJournalDB(jcx); r != nil {
+		return r
+	}
+	cert := &verificationCertificate{verified: time.Now(), lastSync: jcx.db.lastSync}
+	for _, entry := range entries {
+		cert.entries.add(entry.itemId)
+	}
+	for id := range jcx.db.commentMap {
+		cert.comments.add(int64(id))
+	}
+	cert.checks = append(cert.checks, verifiedCheck{
+		"well-formed-xml", fmt.Sprintf("%d files", checkedFiles),
+	})
+	if counts != nil {
+		cert.checks = append(cert.checks, verifiedCheck{
+			"year-gaps", "none against server counts fetched " + counts.fetched,
+		})
+	} else {
+		cert.checks = append(cert.checks, verifiedCheck{
+			"year-gaps", "skipped, no server counts",
+		})
+	}
+	return writeVerificationCertificate(dir, cert)
+}
+
+// Describe the age of the certificate like "3 days ago".
+func verifiedAgo(verified time.Time, now time.Time) string {
+	age := now.Sub(verified)
+	switch {
+	case age < time.Hour:
+		return "less than an hour ago"
+	case age < 48*time.Hour:
+		return fmt.Sprintf("%d hours ago", int(age/time.Hour))
+	default:
+		return fmt.Sprintf("%d days ago", int(age/(24*time.Hour)))
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func Test_transcodeLegacyString(t *testing.T) {
+	cases := []struct {
+		from    string
+		to      string
+		charset string
+	}{
+		{"\xcf\xf0\xe8\xe2\xe5\xf2, \xea\xe0\xea \xe4\xe5\xeb\xe0?", "Привет, как дела?", "windows-1251"},
+		{"\xf0\xd2\xc9\xd7\xc5\xd4, \xcb\xc1\xcb \xc4\xc5\xcc\xc1?", "Привет, как дела?", "koi8-r"},
+		{"Caf\xe9 au lait \xe0 Paris", "Café au lait à Paris", "iso-8859-1"},
+	}
+	for _, c := range cases {
+		to, attrs, charset := transcodeLegacyString(c.from)
+		if to != c.to || charset != c.charset {
+			t.Errorf("Expected %q from %s, got %q from %s", c.to, c.charset, to, charset)
+		}
+		raw := base64.StdEncoding.EncodeToString([]byte(c.from))
+		if !strings.Contains(attrs, `original-charset="`+c.charset+`"`) || !strings.Contains(attrs, `raw="`+raw+`"`) {
+			t.Errorf("Unexpected attributes %s", attrs)
+		}
+	}
+}
+
+func Test_encodeLJStructLegacyCharset(t *testing.T) {
+	event := map[string]interface{}{
+		"subject": "\xcf\xf0\xe8\xe2\xe5\xf2",
+		"event":   "plain <b>text</b>",
+	}
+	data, fixes, r := encodeLJStruct("event", event)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if err := checkWellFormedXml(data); err != nil {
+		t.Fatalf("Invalid XML %s - %s", data, err.Error())
+	}
+	if fixes.charsets["windows-1251"] != 1 {
+		t.Errorf("Unexpected conversions %v", fixes.charsets)
+	}
+	entry, err := parseArchivedEntry(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.subject != "Привет" || entry.event != "plain <b>text</b>" {
+		t.Errorf("Unexpected entry %q %q", entry.subject, entry.event)
+	}
+}
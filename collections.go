@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"linedb"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Saved searches over entry properties that are materialized after each
+// sync in collections.linedb of the journal directory.
+const collectionsDBFileName = "collections.linedb"
+
+type searchCondition struct {
+	key   string
+	op    string
+	value string
+}
+
+type savedSearch struct {
+	name       string
+	query      string
+	conditions []searchCondition
+}
+
+// Longer operators go first so <= is not parsed as <
+var searchOperators = []string{"!=", "<=", ">=", "=", "<", ">", "~"}
+
+// Short names for common entry props
+var searchPropAliases = map[string]string{
+	"mood":     "current_mood",
+	"music":    "current_music",
+	"location": "current_location",
+}
+
+// Parse the query like "mood=nostalgic tag=music year<=2006". All
+// conditions must hold for an entry to belong to the collection.
+func parseSavedSearch(name, query string) (*savedSearch, error) {
+	search := &savedSearch{name: name, query: query}
+	for _, term := range strings.Fields(query) {
+		found := false
+		for _, op := range searchOperators {
+			i := strings.Index(term, op)
+			if i <= 0 {
+				continue
+			}
+			search.conditions = append(search.conditions, searchCondition{
+				key:   strings.ToLower(term[0:i]),
+				op:    op,
+				value: term[i+len(op):],
+			})
+			found = true
+			break
+		}
+		if !found {
+			return nil, fmt.Errorf("search term '%s' is not in key-operator-value form", term)
+		}
+	}
+	if len(search.conditions) == 0 {
+		return nil, fmt.Errorf("search query is empty")
+	}
+	return search, nil
+}
+
+func compareSearchValues(op string, cmp int) bool {
+	switch op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+func (c *searchCondition) matchesString(s string) bool {
+	if c.op == "~" {
+		return strings.Contains(strings.ToLower(s), strings.ToLower(c.value))
+	}
+	if c.op == "=" || c.op == "!=" {
+		return compareSearchValues(c.op, boolToCmp(strings.EqualFold(s, c.value)))
+	}
+	return compareSearchValues(c.op, strings.Compare(s, c.value))
+}
+
+func (c *searchCondition) matchesInt(i int) bool {
+	if c.op == "~" {
+		return c.matchesString(strconv.Itoa(i))
+	}
+	v, err := strconv.Atoi(c.value)
+	if err != nil {
+		return false
+	}
+	cmp := 0
+	if i < v {
+		cmp = -1
+	} else if i > v {
+		cmp = 1
+	}
+	return compareSearchValues(c.op, cmp)
+}
+
+// Convert equality to the comparison result, 0 for equal values.
+func boolToCmp(equal bool) int {
+	if equal {
+		return 0
+	}
+	return 1
+}
+
+func (c *searchCondition) matches(entry *archivedEntry) bool {
+	switch c.key {
+	case "tag":
+		// For tags = and ~ check any tag, != checks that no tag matches
+		op := c.op
+		if op == "!=" {
+			op = "="
+		}
+		anyTag := searchCondition{c.key, op, c.value}
+		found := false
+		for _, tag := range entry.tags() {
+			if anyTag.matchesString(tag) {
+				found = true
+				break
+			}
+		}
+		return found != (c.op == "!=")
+	case "year":
+		year, _ := entry.yearMonth()
+		return c.matchesInt(year)
+	case "month":
+		_, month := entry.yearMonth()
+		return c.matchesInt(month)
+	case "date":
+		return c.matchesString(entry.eventTime)
+	case "security":
+		return c.matchesString(entry.security)
+	case "subject":
+		return c.matchesString(entry.subject)
+	case "text":
+		return c.matchesString(entry.event)
+	case "poster":
+		return c.matchesString(entry.poster)
+	}
+	prop := c.key
+	if alias, ok := searchPropAliases[prop]; ok {
+		prop = alias
+	}
+	return c.matchesString(entry.props[prop])
+}
+
+func (search *savedSearch) matches(entry *archivedEntry) bool {
+	for i := range search.conditions {
+		if !search.conditions[i].matches(entry) {
+			return false
+		}
+	}
+	return true
+}
+
+// Recompute the collections of the journal and store them in the journal
+// directory. When no collections are configured, remove the stale file.
+func updateJournalCollections(config *Config, journal, dir string) *Report {
+	dbpath := filepath.Join(dir, collectionsDBFileName)
+	if len(config.collections) == 0 {
+		if err := archiveStore.Remove(dbpath); err != nil && !os.IsNotExist(err) {
+			return WrapErr(err, "")
+		}
+		return nil
+	}
+
+	entries, r := readJournalEntries(dir)
+	if r != nil {
+		return r
+	}
+	if entries == nil {
+		if _, err := archiveStore.Stat(dir); os.IsNotExist(err) {
+			return nil
+		}
+	}
+
+	members := make([][]*archivedEntry, len(config.collections))
+	for _, entry := range entries {
+		for i, search := range config.collections {
+			if search.matches(entry) {
+				members[i] = append(members[i], entry)
+			}
+		}
+	}
+
+	e := linedb.NewByteEncoder()
+	e.Comment("generated from the collection definitions in " + defaultConfigFile)
+	e.Comment("collection query size")
+	e.Table("collections")
+	for i, search := range config.collections {
+		e.AddString(search.name).AddString(search.query).AddInt(len(members[i])).EndRow()
+		log("Collection %s of %s has %d entries", search.name, journal, len(members[i]))
+	}
+	e.EndTable()
+	e.EmptyLine()
+	e.Comment("collection itemid file")
+	e.Table("members")
+	for i, search := range config.collections {
+		for _, entry := range members[i] {
+			e.AddString(search.name).AddInt64(entry.itemId).AddString(entry.fileName).EndRow()
+		}
+	}
+	e.EndTable()
+
+	if err := writeFileTempRename(dbpath, e.GetBytes()); err != nil {
+		return WrapErr(err, "failed to write collections file %s", dbpath)
+	}
+	return nil
+}
+
+func runCollections(config *Config) *Report {
+	if len(config.collections) == 0 {
+		log("No collections are defined in %s", defaultConfigFile)
+	}
+	for _, journal := range config.journals {
+		if r := updateJournalCollections(config, journal, config.journalDir(journal)); r != nil {
+			return r
+		}
+	}
+	return nil
+}
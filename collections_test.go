@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func Test_savedSearchMatches(t *testing.T) {
+	entry := &archivedEntry{
+		eventTime: "2005-03-05 14:22:00",
+		subject:   "Concert",
+		security:  "public",
+		props: map[string]string{
+			"current_mood": "nostalgic",
+			"taglist":      "music, friends",
+		},
+	}
+	cases := []struct {
+		query   string
+		matches bool
+	}{
+		{"mood=nostalgic", true},
+		{"mood=Nostalgic tag=music year<=2006", true},
+		{"tag=music year<2005", false},
+		{"tag!=music", false},
+		{"tag!=travel month=3", true},
+		{"subject~cert security=public", true},
+		{"date>=2005-04", false},
+		{"current_music=anything", false},
+	}
+	for _, c := range cases {
+		search, err := parseSavedSearch("test", c.query)
+		if err != nil {
+			t.Errorf("Failed to parse %s - %s", c.query, err.Error())
+			continue
+		}
+		if search.matches(entry) != c.matches {
+			t.Errorf("Expected match result %v for %s", c.matches, c.query)
+		}
+	}
+
+	for _, query := range []string{"", "mood", "=value"} {
+		if _, err := parseSavedSearch("test", query); err == nil {
+			t.Errorf("Expected parse error for '%s'", query)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"regexp"
+)
+
+// Anonymous comments have posterid 0 and no user name. Commenters who
+// logged in with OpenID get synthetic local accounts like ext_123 whose
+// names mean nothing outside the server. The comment export of LJ does not
+// give their identity URLs, so exports mark such commenters as OpenID and
+// show anonymous commenters as (anonymous) rather than an empty name.
+
+var syntheticUserPattern = regexp.MustCompile(`^ext_\d+$`)
+
+// Check if the user name is a local account that the server created for
+// an external identity
+func isSyntheticUser(user string) bool {
+	return syntheticUserPattern.MatchString(user)
+}
+
+// Get the displayed name of the comment author
+func commentAuthor(c *CommentRecord) string {
+	switch {
+	case c.User == "":
+		return "(anonymous)"
+	case isSyntheticUser(c.User):
+		return c.User + " (OpenID)"
+	}
+	return c.User
+}
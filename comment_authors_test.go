@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_commentAuthor(t *testing.T) {
+	cases := []struct {
+		comment  CommentRecord
+		expected string
+	}{
+		{CommentRecord{}, "(anonymous)"},
+		{CommentRecord{User: "alice"}, "alice"},
+		{CommentRecord{User: "ext_15"}, "ext_15 (OpenID)"},
+	}
+	for _, c := range cases {
+		if author := commentAuthor(&c.comment); author != c.expected {
+			t.Errorf("%+v: expected %s, got %s", c.comment, c.expected, author)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"os"
+)
+
+// Comment bodies arrive ordered by comment id, so a popular entry gets new
+// comments in every chunk. Rather than reading, parsing and rewriting its
+// C-<jitemid> file for each comment, the dump keeps the parsed files of the
+// comments fetched since the last commit in a commentBatch and writes each
+// changed file once when the batch is flushed. The batch is flushed after
+// commentBatchLimit stored comments, at the end of comments and when the
+// dump is suspended, so memory stays bounded and an interrupted dump loses
+// at most one batch. The files keep the XML format shared with ljarchive;
+// the rewrites per batch make an append-only format unnecessary.
+
+const commentBatchLimit = 5000
+
+type batchedCommentFile struct {
+	path string
+	file CommentFile
+
+	// Position of comments in file.Comments
+	positions map[CommentId]int
+
+	added int
+	dirty bool
+}
+
+type commentBatch struct {
+	jcx   *journalContext
+	files map[int64]*batchedCommentFile
+
+	// Comments added or changed since the last flush
+	stored int
+}
+
+func newCommentBatch(jcx *journalContext) *commentBatch {
+	return &commentBatch{jcx: jcx, files: make(map[int64]*batchedCommentFile)}
+}
+
+func (b *commentBatch) load(itemId int64) (*batchedCommentFile, *Report) {
+	f := b.files[itemId]
+	if f != nil {
+		return f, nil
+	}
+	f = &batchedCommentFile{path: b.jcx.commentFilePath(itemId)}
+	data, err := b.jcx.readStagedFile(f.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, WrapErr(err, "error while reading old comments from %s", f.path)
+		}
+	} else if err := xml.Unmarshal(data, &f.file); err != nil {
+		return nil, WrapErr(err, "failed to parse old comments from %s", f.path)
+	}
+	if !b.jcx.config.storePosterProps && stripPosterProps(f.file.Comments) != 0 {
+		f.dirty = true
+	}
+	f.positions = make(map[CommentId]int, len(f.file.Comments))
+	for i := range f.file.Comments {
+		f.positions[f.file.Comments[i].Id] = i
+	}
+	b.files[itemId] = f
+	return f, nil
+}
+
+// Add the comment to the file of the entry or merge it with the archived
+// version. Report whether the archived version changed and log duplicates
+// unless refetched is set. A comment archived with another entry is kept
+// there.
+func (b *commentBatch) store(itemId int64, record CommentRecord, refetched bool) (bool, *Report) {
+	if !b.jcx.recordCommentItem(record.Id, itemId) {
+		return false, nil
+	}
+	f, r := b.load(itemId)
+	if r != nil {
+		return false, r
+	}
+	i, present := f.positions[record.Id]
+	if !present {
+		f.positions[record.Id] = len(f.file.Comments)
+		f.file.Comments = append(f.file.Comments, record)
+		f.added++
+		f.dirty = true
+		b.stored++
+		return false, nil
+	}
+	if !mergeCommentVersion(&f.file.Comments[i], record, commentDeletionTime()) {
+		if !refetched {
+			log("comment id %d was already downloaded in %s", record.Id, f.path)
+		}
+		return false, nil
+	}
+	if f.file.Comments[i].Deleted != "" {
+		log("Comment id %d was deleted on the server, keeping the archived text in %s", record.Id, f.path)
+	} else {
+		log("Comment id %d changed on the server, keeping the previous version in %s", record.Id, f.path)
+	}
+	f.dirty = true
+	b.stored++
+	return true, nil
+}
+
+func (b *commentBatch) full() bool {
+	return b.stored >= commentBatchLimit
+}
+
+// Stage the writes of the changed files, empty the batch and return the
+// number of new comments
+func (b *commentBatch) flush() int {
+	added := 0
+	for itemId, f := range b.files {
+		if !f.dirty {
+			continue
+		}
+		buf := bytes.NewBufferString(xml.Header)
+		enc := xml.NewEncoder(buf)
+		enc.Indent("", " ")
+		if err := enc.Encode(&f.file); err != nil {
+			panic(err)
+		}
+		buf.WriteByte('\n')
+		b.jcx.stageWrite(f.path, buf.Bytes())
+		b.jcx.index.setCommentCount(itemId, len(f.file.Comments))
+		added += f.added
+	}
+	b.files = make(map[int64]*batchedCommentFile)
+	b.stored = 0
+	return added
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test_commentBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	jcx := &journalContext{config: &Config{}, name: "con", dir: dir, index: newEntriesIndex()}
+	jcx.db.commentItems = make(map[CommentId]int64)
+	batch := newCommentBatch(jcx)
+	for id := CommentId(1); id <= 3; id++ {
+		if _, r := batch.store(7, CommentRecord{Id: id, Body: "first"}, false); r != nil {
+			t.Fatal(r.AsText())
+		}
+	}
+	if _, r := batch.store(8, CommentRecord{Id: 4, Body: "other"}, false); r != nil {
+		t.Fatal(r.AsText())
+	}
+	edited, r := batch.store(7, CommentRecord{Id: 2, Body: "edited"}, true)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if !edited {
+		t.Errorf("Expected a changed body to be an edit")
+	}
+	if added := batch.flush(); added != 4 {
+		t.Errorf("Expected 4 new comments, got %d", added)
+	}
+	// The first recorded comment also removes the stale comment-items.linedb
+	if len(jcx.pending.writes) != 3 {
+		t.Fatalf("Expected one write per comment file, got %d", len(jcx.pending.writes))
+	}
+	if jcx.index.rows[7].comments != 3 || jcx.index.rows[8].comments != 1 {
+		t.Errorf("Unexpected comment counts in the index")
+	}
+
+	data, err := jcx.readStagedFile(jcx.commentFilePath(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file CommentFile
+	if err := xml.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Comments) != 3 || file.Comments[1].Body != "edited" || len(file.Comments[1].History) != 1 {
+		t.Errorf("Unexpected comments %+v", file.Comments)
+	}
+
+	// The next batch starts from the staged file
+	if _, r := batch.store(7, CommentRecord{Id: 3, Body: "first"}, false); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if added := batch.flush(); added != 0 || len(jcx.pending.writes) != 3 {
+		t.Errorf("Expected no writes for a duplicate, got %d new comments and %d writes", added, len(jcx.pending.writes))
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+// LJ lets commenters edit their comments and maintainers screen and delete
+// them. The dump normally fetches only comments newer than the archived
+// ones, so such changes are missed. Bodies are requested with their
+// properties, and the edit_time property of edited comments is kept in the
+// comment file and in the commentEdits table of journal.linedb. With
+// -recheck-comments the dump fetches the meta data of all comments and
+// refetches the bodies of the archived comments whose state changed or
+// whose edit time in the meta data differs from the archived one. Servers
+// that do not report edit times in the meta data give no way to tell an
+// edited comment, so then all bodies are refetched. The body export returns
+// comments starting from an id, so the refetch skips from one changed
+// comment to the next. The previous version of a changed comment is kept in
+// its history. Deleted comments are kept as tombstones, see
+// comment_tombstones.go.
+
+// Return true when the archived comment may differ from the one with the
+// given state and edit time on the server
+func (db *journalDB) commentChanged(id CommentId, state string, editTime string) bool {
+	old, present := db.commentMap[id]
+	if !present || old.state != state {
+		return true
+	}
+	return editTime == "" || editTime != db.commentEditTimes[id]
+}
+
+// Get the smallest id in changed that is greater than after or -1 if none
+func nextChangedComment(changed map[CommentId]bool, after CommentId) CommentId {
+	next := CommentId(-1)
+	for id := range changed {
+		if id > after && (next < 0 || id < next) {
+			next = id
+		}
+	}
+	return next
+}
+
+// Replace the archived comment with the fetched one keeping the previous
+// subject and body in the history. now is the time to record when the
+// fetched comment is deleted. Return false when nothing changed.
+func mergeCommentVersion(stored *CommentRecord, fetched CommentRecord, now string) bool {
+	if changed, handled := mergeDeletedComment(stored, fetched, now); handled {
+		return changed
+	}
+	if stored.Id == fetched.Id && stored.State == fetched.State && stored.User == fetched.User &&
+		stored.ParentId == fetched.ParentId && stored.Date == fetched.Date && stored.Subject == fetched.Subject &&
+		stored.Body == fetched.Body && stored.EditTime == fetched.EditTime &&
+		posterPropsEqual(stored.PosterProps, fetched.PosterProps) {
+		return false
+	}
+	history := stored.History
+	if stored.Subject != fetched.Subject || stored.Body != fetched.Body {
+		history = append(history, CommentVersion{
+			EditTime: stored.EditTime, State: stored.State, Subject: stored.Subject, Body: stored.Body,
+		})
+	}
+	*stored = fetched
+	stored.History = history
+	return true
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"linedb"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// comment-items.linedb in the journal directory maps the id of each
+// archived comment to the jitemid of the C-<jitemid> file with its body.
+// The dump records the comments as it stores them, so repairs and checks
+// can find a comment without reading every comment file, and a comment
+// that the server moves to another entry is noticed rather than archived
+// twice.
+//
+// The table grows with every comment, so it is kept out of journal.linedb
+// that each dump step rewrites. The dump writes the file once at the end
+// of its pass over the journal. The first step that changes the table
+// removes the file in its commit, so after a run that stops in the middle
+// the file is missing rather than stale and the next read builds it from
+// the comment files. Journal DBs from before the file have the table in
+// journal.linedb or not at all, which the first read converts.
+
+const commentItemsFileName = "comment-items.linedb"
+
+// Read comment-items.linedb into the journal DB. Return false when the
+// file does not exist.
+func readCommentItems(jcx *journalContext) (bool, *Report) {
+	path := filepath.Join(jcx.dir, commentItemsFileName)
+	data, err := archiveStore.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, WrapErr(err, "")
+	}
+	items := make(map[CommentId]int64)
+	d := newLinedbDecoder(data)
+	for d.NextItem() {
+		if d.ItemKind == linedb.TableItem && d.ItemName == "commentItems" {
+			for d.NextRow() {
+				items[CommentId(d.GetInt64())] = d.GetInt64()
+			}
+		}
+	}
+	if err := d.GetError(); err != nil {
+		return false, WrapErr(err, "failed to parse %s", path)
+	}
+	jcx.db.commentItems = items
+	return true, nil
+}
+
+func encodeCommentItems(items map[CommentId]int64) []byte {
+	commentIds := make(sortIds, 0, len(items))
+	for commentId := range items {
+		commentIds = append(commentIds, int64(commentId))
+	}
+	sort.Sort(commentIds)
+	e := linedb.NewByteEncoder()
+	e.Comment("map from comment-id to jitemid of its comment file")
+	e.Table("commentItems")
+	for _, commentId := range commentIds {
+		e.AddInt64(commentId).AddInt64(items[CommentId(commentId)]).EndRow()
+	}
+	e.EndTable()
+	return e.GetBytes()
+}
+
+// Write comment-items.linedb if the table changed since it was read
+func writeCommentItems(jcx *journalContext) *Report {
+	if !jcx.commentItemsChanged {
+		return nil
+	}
+	path := filepath.Join(jcx.dir, commentItemsFileName)
+	if !jcx.config.writesArchive() {
+		return ReportMsg("%s cannot write %s without the lock", jcx.config.command.name, path)
+	}
+	if err := writeFileTempRename(path, encodeCommentItems(jcx.db.commentItems)); err != nil {
+		return WrapErr(err, "failed to write %s", path)
+	}
+	jcx.commentItemsChanged = false
+	jcx.commentItemsRemoved = false
+	return nil
+}
+
+// Mark the table as changed by the current step. The first change removes
+// the file with the commit of the step and the end of the pass writes it
+// again.
+func (jcx *journalContext) stageCommentItemsChange() {
+	jcx.commentItemsChanged = true
+	if !jcx.commentItemsRemoved {
+		jcx.commentItemsRemoved = true
+		jcx.stageRemove(filepath.Join(jcx.dir, commentItemsFileName))
+	}
+}
+
+// Build commentItems from the archived comment files
+func rebuildCommentItems(jcx *journalContext) *Report {
+	items, r := archivedCommentItems(jcx.dir)
+	if r != nil {
+		return r
+	}
+	if len(items) != 0 {
+		log("Indexed %d archived comments of %s", len(items), jcx.name)
+	}
+	jcx.db.commentItems = items
+	jcx.db.rebuildCommentItems = false
+	jcx.commentItemsChanged = true
+	return nil
+}
+
+func (db *journalDB) hasCommentBody(id CommentId) bool {
+	_, present := db.commentItems[id]
+	return present
+}
+
+// Record that the body of the comment is stored in the file of the entry.
+// Return false when the comment is already archived with another entry.
+func (jcx *journalContext) recordCommentItem(id CommentId, itemId int64) bool {
+	archived, present := jcx.db.commentItems[id]
+	if present && archived != itemId {
+		jcx.config.warn("comment id %d is archived with entry %d, the server now lists it with entry %d",
+			id, archived, itemId)
+		return false
+	}
+	if !present {
+		jcx.db.commentItems[id] = itemId
+		jcx.stageCommentItemsChange()
+	}
+	return true
+}
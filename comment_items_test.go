@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_commentItems(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	comments := `<?xml version="1.0" encoding="UTF-8"?>
+<comments>
+<comment><id>1</id><body>One</body></comment>
+<comment><id>2</id><body>Two</body></comment>
+</comments>
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "C-5"), []byte(comments), 0666); err != nil {
+		t.Fatal(err)
+	}
+	db := "schemaVersion 2\nlastSync \"2010-01-01 00:00:00\"\nlayout flat\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, journalDBFileName), []byte(db), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	jcx := &journalContext{config: &Config{}, name: "con", dir: dir}
+	if r := readJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(jcx.db.commentItems) != 2 || jcx.db.commentItems[1] != 5 || jcx.db.commentItems[2] != 5 || !jcx.commentItemsChanged {
+		t.Fatalf("Unexpected comment items after the upgrade %v", jcx.db.commentItems)
+	}
+	if !jcx.db.hasCommentBody(2) || jcx.db.hasCommentBody(3) {
+		t.Errorf("Unexpected bodies in the index")
+	}
+	if jcx.recordCommentItem(1, 6) {
+		t.Errorf("Expected a comment archived with another entry to be refused")
+	}
+	if !jcx.recordCommentItem(3, 6) || jcx.db.commentItems[3] != 6 {
+		t.Errorf("Expected a new comment to be recorded")
+	}
+	if len(jcx.pending.writes) != 1 || jcx.pending.writes[0].action != pendingRemove {
+		t.Errorf("Expected the step to remove the stale file, got %+v", jcx.pending.writes)
+	}
+	jcx.pending = pendingWrites{}
+
+	if r := writeJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, journalDBFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "commentItems") {
+		t.Errorf("Expected no comment items in the journal DB:\n%s", data)
+	}
+	if r := readJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(jcx.db.commentItems) != 3 || jcx.db.commentItems[3] != 6 || jcx.commentItemsChanged {
+		t.Errorf("Unexpected comment items after the write %v", jcx.db.commentItems)
+	}
+
+	// The table of an older DB moves into its own file
+	db = "schemaVersion 6\nlastSync \"2010-01-01 00:00:00\"\nlayout flat\n" +
+		string(encodeCommentItems(map[CommentId]int64{1: 5, 2: 5, 7: 8}))
+	if err := ioutil.WriteFile(filepath.Join(dir, journalDBFileName), []byte(db), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if r := readJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(jcx.db.commentItems) != 3 || jcx.db.commentItems[7] != 8 || !jcx.commentItemsChanged {
+		t.Errorf("Unexpected comment items of the older DB %v", jcx.db.commentItems)
+	}
+
+	// A missing file is built from the comment files
+	if err := os.Remove(filepath.Join(dir, commentItemsFileName)); err != nil {
+		t.Fatal(err)
+	}
+	if r := writeJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if err := os.Remove(filepath.Join(dir, commentItemsFileName)); err != nil {
+		t.Fatal(err)
+	}
+	if r := readJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(jcx.db.commentItems) != 2 || jcx.db.commentItems[1] != 5 || !jcx.commentItemsChanged {
+		t.Errorf("Unexpected comment items built from the files %v", jcx.db.commentItems)
+	}
+}
+
+// Read-only commands run without the lock, so they must not upgrade the
+// journal DB or write the comment items they build in memory
+func Test_readOnlyCommandsWriteNoDB(t *testing.T) {
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	dir := filepath.Join(dumpDir, "bob")
+	files := map[string]string{
+		"L-5": `<?xml version="1.0"?><event><itemid>5</itemid><eventtime>2010-05-01 10:00:00</eventtime><subject>One</subject><event>text</event></event>`,
+		"C-5": `<?xml version="1.0"?><comments><comment><id>1</id><user>alice</user><body>One</body></comment></comments>`,
+
+		// A DB of an older version without comment-items.linedb
+		journalDBFileName: "schemaVersion 2\nlastSync \"2010-01-01 00:00:00\"\nlayout flat\n",
+	}
+	if err := os.Mkdir(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"stats", "export"} {
+		config := &Config{
+			dumpDir:        dumpDir,
+			journals:       []string{"bob"},
+			journalAliases: make(map[string]string),
+			command:        findCommand(name),
+			exportFormat:   "markdown",
+			exportDir:      filepath.Join(dumpDir, "export"),
+			maxSecurity:    securityPrivate,
+		}
+		if !config.command.readOnly {
+			t.Fatalf("%s is not a read-only command", name)
+		}
+		if r := config.command.run(config); r != nil {
+			t.Fatalf("%s failed - %s", name, r.AsText())
+		}
+		if data, _ := ioutil.ReadFile(filepath.Join(dir, journalDBFileName)); string(data) != files[journalDBFileName] {
+			t.Errorf("%s rewrote %s:\n%s", name, journalDBFileName, data)
+		}
+		if _, err := os.Stat(filepath.Join(dir, commentItemsFileName)); !os.IsNotExist(err) {
+			t.Errorf("%s wrote %s, %v", name, commentItemsFileName, err)
+		}
+	}
+
+	jcx := &journalContext{config: &Config{command: findCommand("stats")}, name: "bob", dir: dir}
+	if r := readJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if jcx.db.commentItems[1] != 5 {
+		t.Errorf("Comment items were not built in memory %v", jcx.db.commentItems)
+	}
+	if r := writeCommentItems(jcx); r == nil {
+		t.Errorf("A read-only command could write %s", commentItemsFileName)
+	}
+}
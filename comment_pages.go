@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// export_comments.bml returns comments with ids from startid in chunks of
+// a size that the server chooses, and the next request starts after the
+// largest id of the chunk. Some LJ clones return tiny chunks, overlapping
+// ranges or the same chunk again, which without checks would loop forever
+// or silently skip comments. Each chunk must advance past the previous
+// position. A server may give the next id to ask for in <nextid>, which is
+// followed when the chunk itself does not advance. Otherwise the dump
+// stops with the range that the server failed to page through.
+
+// Get the position after the chunk of kind, meta or body, that was
+// requested after start and returned comments up to last. Comments were
+// empty when the chunk had none.
+func nextCommentPage(kind string, start CommentId, last CommentId, empty bool, nextId CommentId) (CommentId, *Report) {
+	if empty || last > start {
+		return last, nil
+	}
+	if nextId > start+1 {
+		log("WARNING: comment_%s chunk after %d did not advance, continuing from the server hint %d", kind, start, nextId)
+		return nextId - 1, nil
+	}
+	return start, ReportMsg(
+		"server returned comment_%s chunk with ids up to %d when asked for ids from %d, stopping to avoid a loop",
+		kind, last, start+1,
+	)
+}
+
+// Format sorted ids as ranges like 3-5, 9
+func formatIdRanges(ids []CommentId) string {
+	var ranges []string
+	for i := 0; i < len(ids); {
+		j := i
+		for j+1 < len(ids) && ids[j+1] == ids[j]+1 {
+			j++
+		}
+		if j == i {
+			ranges = append(ranges, fmt.Sprintf("%d", ids[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", ids[i], ids[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ", ")
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_nextCommentPage(t *testing.T) {
+	if next, r := nextCommentPage("meta", 10, 25, false, 0); r != nil || next != 25 {
+		t.Errorf("Expected 25 for an advancing chunk, got %d %v", next, r)
+	}
+	if next, r := nextCommentPage("body", 10, 10, true, 0); r != nil || next != 10 {
+		t.Errorf("Expected 10 for an empty chunk, got %d %v", next, r)
+	}
+	if next, r := nextCommentPage("body", 10, 8, false, 40); r != nil || next != 39 {
+		t.Errorf("Expected 39 from the server hint, got %d %v", next, r)
+	}
+	if _, r := nextCommentPage("body", 10, 10, false, 11); r == nil {
+		t.Errorf("Expected an error for a chunk that does not advance")
+	}
+	if _, r := nextCommentPage("meta", 10, 5, false, 0); r == nil {
+		t.Errorf("Expected an error for a chunk that goes back")
+	}
+}
+
+func Test_formatIdRanges(t *testing.T) {
+	tests := []struct {
+		ids      []CommentId
+		expected string
+	}{
+		{nil, ""},
+		{[]CommentId{7}, "7"},
+		{[]CommentId{3, 4, 5, 9}, "3-5, 9"},
+		{[]CommentId{1, 3, 4, 10, 11, 12}, "1, 3-4, 10-12"},
+	}
+	for _, test := range tests {
+		if s := formatIdRanges(test.ids); s != test.expected {
+			t.Errorf("Expected %q for %v, got %q", test.expected, test.ids, s)
+		}
+	}
+}
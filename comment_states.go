@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The comment meta data gives the current state of each comment: A for
+// active, S for screened, D for deleted and F for frozen. The commentMeta
+// table of journal.linedb keeps only the latest state, so when a dump
+// finds an archived comment in another state, usually with
+// -recheck-comments, it also appends the change with the time of the dump
+// to the commentStates table. Maintainers of a community can then see when
+// comments were screened, unscreened or deleted. Changes that happened
+// between two dumps are seen as one change at the time of the later dump.
+
+type commentStateChange struct {
+	id   CommentId
+	from string
+	to   string
+
+	// Time of the dump that found the change in RFC 3339
+	time string
+}
+
+// Update the meta data of the comment and log the change of the state of
+// an archived comment. Return true when the DB changed.
+func (db *journalDB) updateCommentMeta(id CommentId, meta commentMeta, now string) bool {
+	old, present := db.commentMap[id]
+	if present && old == meta {
+		return false
+	}
+	if present && old.state != meta.state {
+		db.commentStates = append(db.commentStates, commentStateChange{id, old.state, meta.state, now})
+	}
+	db.commentMap[id] = meta
+	return true
+}
+
+var commentStateNames = map[string]string{
+	"A": "active",
+	"S": "screened",
+	"D": "deleted",
+	"F": "frozen",
+}
+
+func commentStateName(state string) string {
+	if state == "" {
+		state = "A"
+	}
+	if name := commentStateNames[state]; name != "" {
+		return name
+	}
+	return state
+}
+
+// Summarize the logged state changes like "2 screened->active, 1
+// active->deleted"
+func summarizeCommentStates(changes []commentStateChange) string {
+	counts := make(map[string]int)
+	for _, c := range changes {
+		counts[commentStateName(c.from)+"->"+commentStateName(c.to)]++
+	}
+	kinds := make([]string, 0, len(counts))
+	for kind := range counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	parts := make([]string, len(kinds))
+	for i, kind := range kinds {
+		parts[i] = fmt.Sprintf("%d %s", counts[kind], kind)
+	}
+	return strings.Join(parts, ", ")
+}
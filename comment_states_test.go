@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_commentStateLog(t *testing.T) {
+	server := newFakeLJServer(t)
+	defer server.Close()
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	config := &Config{
+		server:          server.URL,
+		username:        "con",
+		password:        "password",
+		journals:        []string{"con"},
+		dumpDir:         dumpDir,
+		accountDataDir:  filepath.Join(dumpDir, accountDataDirName),
+		journalAliases:  make(map[string]string),
+		recheckComments: true,
+	}
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	server.commentDeleted = true
+	for run := 0; run < 2; run++ {
+		if r := runDump(config); r != nil {
+			t.Fatal(r.AsText())
+		}
+	}
+	jcx := &journalContext{config: config, name: "con", dir: filepath.Join(dumpDir, "con_")}
+	if r := readJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	changes := jcx.db.commentStates
+	if len(changes) != 1 || changes[0].id != 5 || changes[0].from != "A" || changes[0].to != "D" || changes[0].time == "" {
+		t.Fatalf("Unexpected state log %+v", changes)
+	}
+	if jcx.db.commentMap[5].state != "D" {
+		t.Errorf("Expected the current state D, got %+v", jcx.db.commentMap[5])
+	}
+
+	changes = append(changes,
+		commentStateChange{6, "S", "A", "2026-01-01T00:00:00Z"},
+		commentStateChange{7, "S", "A", "2026-01-01T00:00:00Z"},
+	)
+	if summary := summarizeCommentStates(changes); summary != "1 active->deleted, 2 screened->active" {
+		t.Errorf("Unexpected summary %s", summary)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+)
+
+// Responses of export_comments.bml for journals with many comments can be
+// hundreds of megabytes, so they are decoded while they are read rather
+// than unmarshaled in one piece. A response looks like
+//
+//	<livejournal>
+//	  <maxid>...</maxid>
+//	  <comments><comment .../>...</comments>
+//	  <usermaps><usermap .../>...</usermaps>
+//	</livejournal>
+//
+// decodeCommentChunk calls handle for each element inside <comments> and
+// <usermaps> with parent set to the name of the list and for other
+// children of <livejournal> with an empty parent. handle must consume the
+// element with DecodeElement or Skip, so only one comment is in memory at
+// a time. Processing that fails stops reading the response.
+
+type commentChunkHandler func(d *xml.Decoder, parent string, start *xml.StartElement) *Report
+
+func commentChunkError(kind string, err error) *Report {
+	return WrapErr(err, "failed to process comment_%s response", kind)
+}
+
+// Decode into v the element that a handler of comment_kind chunk got
+func decodeChunkElement(kind string, d *xml.Decoder, start *xml.StartElement, v interface{}) *Report {
+	if err := d.DecodeElement(v, start); err != nil {
+		return commentChunkError(kind, err)
+	}
+	return nil
+}
+
+func decodeCommentChunk(r io.Reader, kind string, handle commentChunkHandler) *Report {
+	d := xml.NewDecoder(r)
+	root, err := nextStartElement(d)
+	if err == nil && (root == nil || root.Name.Local != "livejournal") {
+		err = errors.New("the response is not a livejournal document")
+	}
+	if err != nil {
+		return commentChunkError(kind, err)
+	}
+	for {
+		start, err := nextStartElement(d)
+		if err != nil {
+			return commentChunkError(kind, err)
+		}
+		if start == nil {
+			return nil
+		}
+		switch start.Name.Local {
+		case "comments", "usermaps":
+			parent := start.Name.Local
+			for {
+				item, err := nextStartElement(d)
+				if err != nil {
+					return commentChunkError(kind, err)
+				}
+				if item == nil {
+					break
+				}
+				if r := handle(d, parent, item); r != nil {
+					return r
+				}
+			}
+		default:
+			if r := handle(d, "", start); r != nil {
+				return r
+			}
+		}
+	}
+}
+
+// Get the next child element of the current one or nil after its end
+func nextStartElement(d *xml.Decoder) (*xml.StartElement, error) {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			return &t, nil
+		case xml.EndElement:
+			return nil, nil
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func Test_decodeCommentChunk(t *testing.T) {
+	const response = `<?xml version="1.0" encoding="utf-8"?>
+<livejournal>
+<maxid>12</maxid>
+<comments>
+<comment id="10" posterid="3" state="A"/>
+<comment id="12" posterid="4"><body>text</body></comment>
+</comments>
+<usermaps><usermap id="3" user="alice"/></usermaps>
+<extra><nested/></extra>
+</livejournal>`
+
+	var maxId CommentId
+	var ids []CommentId
+	users := make(map[UserId]string)
+	r := decodeCommentChunk(strings.NewReader(response), "meta", func(d *xml.Decoder, parent string, start *xml.StartElement) *Report {
+		switch {
+		case parent == "comments":
+			var c struct {
+				Id CommentId `xml:"id,attr"`
+			}
+			if r := decodeChunkElement("meta", d, start, &c); r != nil {
+				return r
+			}
+			ids = append(ids, c.Id)
+			return nil
+		case parent == "usermaps":
+			var u struct {
+				Id   UserId `xml:"id,attr"`
+				User string `xml:"user,attr"`
+			}
+			if r := decodeChunkElement("meta", d, start, &u); r != nil {
+				return r
+			}
+			users[u.Id] = u.User
+			return nil
+		case start.Name.Local == "maxid":
+			return decodeChunkElement("meta", d, start, &maxId)
+		}
+		return decodeChunkElement("meta", d, start, &struct{}{})
+	})
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if maxId != 12 || len(ids) != 2 || ids[0] != 10 || ids[1] != 12 || users[3] != "alice" {
+		t.Errorf("Unexpected chunk maxid %d, ids %v, users %v", maxId, ids, users)
+	}
+}
+
+func Test_decodeCommentChunkErrors(t *testing.T) {
+	skip := func(d *xml.Decoder, parent string, start *xml.StartElement) *Report {
+		return decodeChunkElement("body", d, start, &struct{}{})
+	}
+	bad := []string{
+		"<html><body>Not a maintainer</body></html>",
+		"<livejournal><comments><comment id=\"1\">",
+		"",
+	}
+	for _, response := range bad {
+		if r := decodeCommentChunk(strings.NewReader(response), "body", skip); r == nil {
+			t.Errorf("Expected an error for %q", response)
+		}
+	}
+
+	stop := ReportMsg("stop")
+	calls := 0
+	r := decodeCommentChunk(strings.NewReader(`<livejournal><comments><comment id="1"/><comment id="2"/></comments></livejournal>`), "body",
+		func(d *xml.Decoder, parent string, start *xml.StartElement) *Report {
+			calls++
+			return stop
+		})
+	if r != stop || calls != 1 {
+		t.Errorf("Expected the handler report to stop decoding after 1 call, got %d calls", calls)
+	}
+}
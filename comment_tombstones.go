@@ -0,0 +1,48 @@
+package main
+
+import (
+	"time"
+)
+
+// When a commenter or a maintainer deletes a comment, the server keeps
+// only its id with state D and returns it without the subject and body.
+// If the comment was archived before the deletion, a refetch with
+// -recheck-comments would replace the archived text with the empty one.
+// Instead the dump keeps the archived subject and body, sets the state to
+// D and records in the deleted element of the comment the time when the
+// dump first saw the deletion. Such a tombstone is never updated again.
+// Exports show the deleted comments with the time of the deletion, see
+// commentDeletedLabel, except the blogger export and exports below private
+// security that skip them.
+
+// Turn the archived comment into a tombstone when the fetched one is
+// deleted. Return true when handled with changed reporting whether the
+// archived comment changed.
+func mergeDeletedComment(stored *CommentRecord, fetched CommentRecord, now string) (changed bool, handled bool) {
+	if fetched.State != "D" {
+		return false, false
+	}
+	if stored.Deleted != "" {
+		return false, true
+	}
+	if stored.State == "D" || stored.Subject == "" && stored.Body == "" {
+		// Nothing archived to keep
+		return false, false
+	}
+	stored.State = fetched.State
+	stored.Deleted = now
+	return true, true
+}
+
+func commentDeletionTime() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// Describe a comment deleted on the server after it was archived or
+// return an empty string
+func commentDeletedLabel(config *Config, c *CommentRecord) string {
+	if c.Deleted == "" {
+		return ""
+	}
+	return "deleted on the server, noticed " + config.formatDate(c.Deleted)
+}
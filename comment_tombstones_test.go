@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_deletedCommentTombstone(t *testing.T) {
+	server := newFakeLJServer(t)
+	defer server.Close()
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	config := &Config{
+		server:          server.URL,
+		username:        "con",
+		password:        "password",
+		journals:        []string{"con"},
+		dumpDir:         dumpDir,
+		accountDataDir:  filepath.Join(dumpDir, accountDataDirName),
+		journalAliases:  make(map[string]string),
+		recheckComments: true,
+	}
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+
+	server.commentDeleted = true
+	journalDir := filepath.Join(dumpDir, "con_")
+	deleted := ""
+	for run := 0; run < 2; run++ {
+		if r := runDump(config); r != nil {
+			t.Fatal(r.AsText())
+		}
+		comments, r := readEntryComments(journalDir, 1)
+		if r != nil {
+			t.Fatal(r.AsText())
+		}
+		if len(comments) != 1 || comments[0].State != "D" || comments[0].Body != "Nice" || comments[0].Deleted == "" ||
+			len(comments[0].History) != 0 {
+			t.Fatalf("Unexpected comments after deletion %d %+v", run, comments)
+		}
+		if run == 0 {
+			deleted = comments[0].Deleted
+		} else if comments[0].Deleted != deleted {
+			t.Errorf("Expected the deletion time %s to stay, got %s", deleted, comments[0].Deleted)
+		}
+	}
+
+	config.exportDir = filepath.Join(dumpDir, "export")
+	config.exportFormat = "html"
+	config.maxSecurity = securityPrivate
+	if r := runExport(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	page, err := ioutil.ReadFile(filepath.Join(config.exportDir, "html", "con_", "entries", "L-1.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(page), "deleted on the server, noticed "+deleted) || !strings.Contains(string(page), "Nice") {
+		t.Errorf("Expected the deleted comment marked in the export, got %s", page)
+	}
+}
+
+func Test_mergeDeletedComment(t *testing.T) {
+	// A comment archived after the deletion has no text to keep
+	stored := CommentRecord{Id: 1, State: "D"}
+	if mergeCommentVersion(&stored, CommentRecord{Id: 1, State: "D"}, "2026-01-01T00:00:00Z") || stored.Deleted != "" {
+		t.Errorf("Unexpected tombstone %+v", stored)
+	}
+}
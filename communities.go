@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+)
+
+// Check if the user can export comments of the journal. The server allows
+// that only for the own journal of the user and for the communities where
+// the user is a maintainer. The start id is beyond any real comment id so
+// the server returns just the empty comment list.
+func canExportComments(session *ljSession, journal string) bool {
+	geturl := authasPath(session.config, fmt.Sprintf(
+		"%s/export_comments.bml?get=comment_meta&startid=%d",
+		session.config.server,
+		int64(1)<<53,
+	), journal)
+	resp, err := session.client.Get(geturl)
+	if err != nil {
+		return false
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	err = fuseErr(err, resp.Body.Close())
+	if err != nil || resp.StatusCode != 200 {
+		return false
+	}
+	var probe struct {
+		XMLName xml.Name `xml:"livejournal"`
+	}
+	return xml.Unmarshal(data, &probe) == nil
+}
+
+// Append to config.journals the communities that the user maintains and
+// that no journal group of the config lists. The login response lists all
+// journals the user has access to. The maintainers are those where the
+// server allows to export comments.
+func addMaintainedCommunities(session *ljSession) *Report {
+	config := session.config
+	if session.loginResponse["access_count"] == "" {
+		log("The user %s has no access to any community", config.username)
+		return nil
+	}
+	accessJournals, r := getLJFlatArray("access", session.loginResponse)
+	if r != nil {
+		return r
+	}
+
+	// The server ignores case and treats - as _ in names
+	known := make(map[string]bool, len(config.allJournals)+len(config.journals))
+	for _, journal := range append(config.allJournals, config.journals...) {
+		known[canonicalJournalName(journal)] = true
+	}
+	added := 0
+	for _, journal := range accessJournals {
+		if journal == "" || known[canonicalJournalName(journal)] {
+			continue
+		}
+		known[canonicalJournalName(journal)] = true
+		if err := checkJournalName(journal); err != nil {
+			session.config.warn("skipping community with %s", err.Error())
+			continue
+		}
+		if !canExportComments(session, journal) {
+			log("Skipping community %s as %s is not its maintainer", journal, config.username)
+			continue
+		}
+		log("Adding maintained community %s", journal)
+		config.journals = append(config.journals, journal)
+		added++
+	}
+	log("Found %d maintained communities not listed in the configuration", added)
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_addMaintainedCommunities(t *testing.T) {
+	maintained := map[string]bool{"bob": true, "some_comm": true, "new_comm": true, "rare_comm": true}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		journal := req.URL.Query().Get("authas")
+		if journal == "" {
+			journal = "bob"
+		}
+		if !maintained[journal] {
+			http.Error(w, "not a maintainer", http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, "<livejournal></livejournal>")
+	}))
+	defer ts.Close()
+
+	// rare_comm is in a group that is not due
+	config := &Config{
+		server:      ts.URL,
+		username:    "bob",
+		journals:    []string{"bob", "Some-Comm"},
+		allJournals: []string{"bob", "Some-Comm", "rare_comm"},
+	}
+	session := &ljSession{config: config, loginResponse: map[string]string{
+		"access_count": "5",
+		"access_1":     "some_comm",
+		"access_2":     "new_comm",
+		"access_3":     "other_comm",
+		"access_4":     "New-Comm",
+		"access_5":     "rare_comm",
+	}}
+	if r := addMaintainedCommunities(session); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(config.journals) != 3 || config.journals[2] != "new_comm" {
+		t.Errorf("Unexpected journals %v", config.journals)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"linedb"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// With -community-info the dump archives for each maintained community
+// the member list, the membership and posting access settings, the ids of
+// submissions waiting in the moderation queue and the banned users. None
+// of these are in the protocol and all of them are lost when a community
+// is purged. The community management pages are fetched with the session
+// like the style pages, see style.go, and stored with their form fields in
+// the community subdirectory of the journal directory. The banned users
+// come from the ban_list command of the admin console. A part that cannot
+// be fetched keeps its previously archived values.
+
+const communityDBFileName = "community.linedb"
+const communityDirName = "community"
+
+var communityPages = []stylePage{
+	{"members", "/community/members.bml"},
+	{"settings", "/community/settings.bml"},
+	{"moderation", "/community/moderate.bml"},
+}
+
+// The server marks user names on its pages with the lj:user attribute
+var communityUserPattern = regexp.MustCompile(`\blj:user=["']([\w-]+)["']`)
+var communityModIdPattern = regexp.MustCompile(`[?&;]modid=(\d+)`)
+var consoleUserPattern = regexp.MustCompile(`^[\w-]+$`)
+
+type communityInfo struct {
+	members []string
+	fields  []styleField
+
+	// Ids of submissions in the moderation queue
+	queue  []int64
+	banned []string
+}
+
+// Get names marked as users on the page other than the community itself
+// sorted and without duplicates
+func parseCommunityUsers(community string, content string) []string {
+	seen := map[string]bool{community: true}
+	var users []string
+	for _, m := range communityUserPattern.FindAllStringSubmatch(content, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			users = append(users, m[1])
+		}
+	}
+	sort.Strings(users)
+	return users
+}
+
+func parseCommunityModIds(content string) []int64 {
+	seen := make(map[int64]bool)
+	var ids []int64
+	for _, m := range communityModIdPattern.FindAllStringSubmatch(content, -1) {
+		id, err := strconv.ParseInt(m[1], 10, 64)
+		if err == nil && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(sortIds(ids))
+	return ids
+}
+
+// Run the command of the admin console and return its output lines. The
+// console answers each command with the success flag and the lines as
+// pairs of the line type and the text.
+func runConsoleCommand(session *ljSession, command string) ([]string, *Report) {
+	type LJConsoleResult struct {
+		Results []struct {
+			Success int        `xmlrpc:"success"`
+			Output  [][]string `xmlrpc:"output"`
+		} `xmlrpc:"results"`
+	}
+	var result LJConsoleResult
+	input := map[string]interface{}{"commands": []interface{}{command}}
+	if r := callLJXmlRpcMethod(session, "consolecommand", input, &result); r != nil {
+		return nil, r
+	}
+	if len(result.Results) == 0 {
+		return nil, ReportMsg("no result for the console command %s", command)
+	}
+	var lines []string
+	var errors []string
+	for _, line := range result.Results[0].Output {
+		if len(line) != 2 {
+			continue
+		}
+		if line[0] == "error" {
+			errors = append(errors, line[1])
+		} else {
+			lines = append(lines, line[1])
+		}
+	}
+	if result.Results[0].Success == 0 {
+		return nil, ReportMsg("console command %s failed - %s", command, strings.Join(errors, "; "))
+	}
+	return lines, nil
+}
+
+func fetchCommunityBanList(session *ljSession, community string) ([]string, *Report) {
+	lines, r := runConsoleCommand(session, "ban_list from "+community)
+	if r != nil {
+		return nil, r
+	}
+	var banned []string
+	for _, line := range lines {
+		if name := strings.TrimSpace(line); consoleUserPattern.MatchString(name) {
+			banned = append(banned, name)
+		}
+	}
+	sort.Strings(banned)
+	return banned, nil
+}
+
+// Archive the management data of the community. The caller checks that
+// the user maintains it.
+func dumpCommunityInfo(session *ljSession, community string, dir string) *Report {
+	old, r := readCommunityInfo(dir)
+	if r != nil {
+		return r
+	}
+	pageDir := filepath.Join(dir, communityDirName)
+	if err := mkdirArchive(pageDir); err != nil {
+		return WrapErr(err, "")
+	}
+	log("Archiving members and moderation data of community %s", community)
+	info := &communityInfo{}
+	for _, page := range communityPages {
+		if shutdownRequested() {
+			return interruptedReport()
+		}
+		page.path = authasPath(session.config, page.path, community)
+		data, r := fetchStylePage(session, page)
+		if r != nil {
+			session.config.warn("failed to archive %s of community %s - %s", page.name, community, r.AsText())
+			for _, f := range old.fields {
+				if f.page == page.name {
+					info.fields = append(info.fields, f)
+				}
+			}
+			switch page.name {
+			case "members":
+				info.members = old.members
+			case "moderation":
+				info.queue = old.queue
+			}
+			continue
+		}
+		content := string(data)
+		info.fields = append(info.fields, parseStyleFormFields(page.name, content)...)
+		switch page.name {
+		case "members":
+			info.members = parseCommunityUsers(community, content)
+		case "moderation":
+			info.queue = parseCommunityModIds(content)
+		}
+		path := filepath.Join(pageDir, page.name+".html")
+		if err := writeFileTempRename(path, data); err != nil {
+			return WrapErr(err, "failed to write %s", path)
+		}
+	}
+	banned, r := fetchCommunityBanList(session, community)
+	if r != nil {
+		session.config.warn("failed to archive banned users of community %s - %s", community, r.AsText())
+		banned = old.banned
+	}
+	info.banned = banned
+	log("Community %s has %d members, %d banned users and %d submissions waiting for moderation",
+		community, len(info.members), len(info.banned), len(info.queue))
+	return writeCommunityInfo(dir, info)
+}
+
+func writeCommunityInfo(dir string, info *communityInfo) *Report {
+	e := linedb.NewByteEncoder()
+	e.Scalar("fetched").AddString(time.Now().UTC().Format("2006-01-02 15:04:05"))
+	e.EmptyLine()
+	e.Comment("user")
+	e.Table("members")
+	for _, user := range info.members {
+		e.AddString(user).EndRow()
+	}
+	e.EndTable()
+	e.EmptyLine()
+	e.Comment("user")
+	e.Table("banned")
+	for _, user := range info.banned {
+		e.AddString(user).EndRow()
+	}
+	e.EndTable()
+	e.EmptyLine()
+	e.Comment("modid")
+	e.Table("queue")
+	for _, id := range info.queue {
+		e.AddInt64(id).EndRow()
+	}
+	e.EndTable()
+	e.EmptyLine()
+	e.Comment("page field value")
+	e.Table("fields")
+	for _, f := range info.fields {
+		e.AddString(f.page).AddString(f.name).AddString(f.value).EndRow()
+	}
+	e.EndTable()
+	dbpath := filepath.Join(dir, communityDBFileName)
+	if err := writeFileTempRename(dbpath, e.GetBytes()); err != nil {
+		return WrapErr(err, "failed to write community file %s", dbpath)
+	}
+	return nil
+}
+
+// Return empty info without error if the community was never archived
+func readCommunityInfo(dir string) (*communityInfo, *Report) {
+	info := &communityInfo{}
+	dbpath := filepath.Join(dir, communityDBFileName)
+	dbdata, err := archiveStore.ReadFile(dbpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return info, nil
+		}
+		return nil, WrapErr(err, "")
+	}
+	d := newLinedbDecoder(dbdata)
+	for d.NextItem() {
+		if d.ItemKind == linedb.ScalarItem {
+			d.GetString()
+		} else if d.ItemKind == linedb.TableItem {
+			for d.NextRow() {
+				switch d.ItemName {
+				case "members":
+					info.members = append(info.members, d.GetString())
+				case "banned":
+					info.banned = append(info.banned, d.GetString())
+				case "queue":
+					info.queue = append(info.queue, d.GetInt64())
+				case "fields":
+					info.fields = append(info.fields, styleField{d.GetString(), d.GetString(), d.GetString()})
+				}
+			}
+		}
+	}
+	if err := d.GetError(); err != nil {
+		return nil, WrapErr(err, "error while parsing community file %s as linedb", dbpath)
+	}
+	return info, nil
+}
+
+// Archive management data and the moderation queue of the journals that
+// are communities the user maintains as the options say
+func dumpMaintainedCommunityInfo(session *ljSession, journals []*journalContext) *Report {
+	config := session.config
+	for _, jcx := range journals {
+		if !jcx.caps.communityData {
+			continue
+		}
+		journal, dir := jcx.name, jcx.dir
+		if config.archiveCommunityInfo {
+			if r := dumpCommunityInfo(session, journal, dir); r != nil {
+				return r
+			}
+		}
+		if config.archiveModerationQueue {
+			if r := dumpModerationQueue(session, journal, dir); r != nil {
+				return r
+			}
+		}
+	}
+	return nil
+}
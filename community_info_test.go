@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_dumpCommunityInfo(t *testing.T) {
+	server := newFakeLJServer(t)
+	defer server.Close()
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	config := &Config{
+		server:         server.URL,
+		username:       "owner",
+		password:       "password",
+		journals:       []string{"con"},
+		dumpDir:        dumpDir,
+		accountDataDir: filepath.Join(dumpDir, accountDataDirName),
+		journalAliases: make(map[string]string),
+	}
+	session, r := openLJSession(config)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	journalDir := config.journalDir("con")
+	if r := dumpCommunityInfo(session, "con", journalDir); r != nil {
+		t.Fatal(r.AsText())
+	}
+	info, r := readCommunityInfo(journalDir)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if !reflect.DeepEqual(info.members, []string{"alice", "bob"}) {
+		t.Errorf("Unexpected members %v", info.members)
+	}
+	if !reflect.DeepEqual(info.banned, []string{"troll"}) {
+		t.Errorf("Unexpected banned users %v", info.banned)
+	}
+	if !reflect.DeepEqual(info.queue, []int64{12}) {
+		t.Errorf("Unexpected moderation queue %v", info.queue)
+	}
+	if len(info.fields) != 1 || info.fields[0] != (styleField{"members", "post_7", "on"}) {
+		t.Errorf("Unexpected fields %+v", info.fields)
+	}
+
+	// The settings page is missing on the fake server, the other parts
+	// are archived again
+	if r := dumpCommunityInfo(session, "con", journalDir); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if info, _ := readCommunityInfo(journalDir); len(info.members) != 2 {
+		t.Errorf("Members lost after the second dump %v", info.members)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+// Entries can be marked as adult content with the adult_content prop and
+// can screen comments with the opt_screening prop. Both are recorded in
+// the entries index. Exports show adult entries behind a notice that the
+// reader has to open, state how comments to the entry are screened and
+// show screened comments collapsed. An empty value means the journal
+// default, which the archive does not know.
+
+// Get the notice for the adult_content value or empty for none
+func adultContentLabel(adultContent string) string {
+	switch adultContent {
+	case "concepts":
+		return "Adult concepts"
+	case "explicit":
+		return "Explicit adult content"
+	}
+	return ""
+}
+
+// Get the description of the opt_screening value or empty when comments
+// are not screened or the entry uses the journal default
+func screeningLabel(screening string) string {
+	switch screening {
+	case "A":
+		return "All comments are screened"
+	case "F":
+		return "Comments from non-friends are screened"
+	case "R":
+		return "Anonymous comments are screened"
+	case "L":
+		return "Comments with links are screened"
+	}
+	return ""
+}
+
+func isScreenedComment(c *CommentRecord) bool {
+	return c.State == "S"
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+)
+
+// Each session keeps the cookies of the server in a jar. Besides the
+// ljsession cookie of the login, LJ sets luid and ljloggedin and some
+// mirrors set anti-bot cookies that they expect back on the following
+// requests. The jar is applied in ljSession.RoundTrip rather than in
+// http.Client, so the XML-RPC client that uses the session as its
+// transport sends the cookies as well. Userpic and media downloads use
+// config.httpClient() without the jar and never carry the login.
+
+func newSessionJar() http.CookieJar {
+	// cookiejar.New returns an error only for a broken public suffix list
+	jar, _ := cookiejar.New(nil)
+	return jar
+}
+
+// URLs of the hosts that receive the login cookie
+func sessionCookieUrls(config *Config) []*url.URL {
+	var urls []*url.URL
+	for _, s := range []string{config.server, config.apiUrl, config.commentImportUrl} {
+		if s == "" {
+			continue
+		}
+		if u, err := url.Parse(s); err == nil && u.Host != "" {
+			u.Path = "/"
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// Get the domain of the site for the cookies of the login. LJ sets them
+// for the domain of the site without www, so they reach journal
+// subdomains like bob.livejournal.com too. IP addresses and single-label
+// hosts get host-only cookies.
+func siteCookieDomain(u *url.URL) string {
+	host := u.Hostname()
+	if net.ParseIP(host) != nil || !strings.Contains(host, ".") {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(host), "www.")
+}
+
+// Put the ljsession cookie of the login into the jar
+func (session *ljSession) setLoginCookie(value string) {
+	session.loginCookie = value
+	session.config.errorLog.addSecret(value)
+	if value == "" {
+		return
+	}
+	for _, u := range sessionCookieUrls(session.config) {
+		cookie := &http.Cookie{Name: "ljsession", Value: value, Path: "/", Domain: siteCookieDomain(u)}
+		session.jar.SetCookies(u, []*http.Cookie{cookie})
+	}
+}
+
+// Replace the cookies of the request with those from the jar
+func (session *ljSession) addJarCookies(req *http.Request) {
+	// http.Client copies the Cookie header of the original request to
+	// redirects
+	req.Header.Del("Cookie")
+	for _, c := range session.jar.Cookies(req.URL) {
+		req.AddCookie(c)
+	}
+}
+
+func (session *ljSession) storeJarCookies(req *http.Request, res *http.Response) {
+	if cookies := res.Cookies(); len(cookies) != 0 {
+		session.jar.SetCookies(req.URL, cookies)
+	}
+}
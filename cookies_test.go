@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func Test_sessionCookieJar(t *testing.T) {
+	var cookies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		switch req.Form.Get("mode") {
+		case "getchallenge":
+			http.SetCookie(w, &http.Cookie{Name: "luid", Value: "visitor", Path: "/"})
+			fmt.Fprint(w, "success\nOK\nchallenge\nc0ffee\n")
+			return
+		case "sessiongenerate":
+			http.SetCookie(w, &http.Cookie{Name: "ljloggedin", Value: "u1:s1", Path: "/"})
+			fmt.Fprint(w, "success\nOK\nljsession\nv1:u1:s1:login\n")
+			return
+		}
+		if req.URL.Path == "/redirect" {
+			http.Redirect(w, req, "/page", http.StatusFound)
+			return
+		}
+		cookies = append(cookies, req.Header.Get("Cookie"))
+	}))
+	defer server.Close()
+
+	config := &Config{server: server.URL, username: "bob", password: "password"}
+	session, r := openLJSession(config)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if _, err := session.client.Get(server.URL + "/redirect"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.httpClient().Get(server.URL + "/userpic"); err != nil {
+		t.Fatal(err)
+	}
+	expected := "luid=visitor; ljloggedin=u1:s1; ljsession=v1:u1:s1:login"
+	if len(cookies) != 2 || cookies[0] != expected {
+		t.Fatalf("Expected %q after the redirect, got %q", expected, cookies)
+	}
+	if cookies[1] != "" {
+		t.Errorf("Expected no cookies for the userpic download, got %q", cookies[1])
+	}
+}
+
+func Test_loginCookieDomain(t *testing.T) {
+	for _, server := range []string{"https://www.livejournal.com", "https://livejournal.com:443"} {
+		session := &ljSession{config: &Config{server: server}, jar: newSessionJar()}
+		session.setLoginCookie("v1:u1:s1:login")
+		for _, host := range []string{"livejournal.com", "www.livejournal.com", "bob.livejournal.com"} {
+			cookies := session.jar.Cookies(&url.URL{Scheme: "https", Host: host, Path: "/"})
+			if len(cookies) != 1 || cookies[0].Value != "v1:u1:s1:login" {
+				t.Errorf("Expected the login cookie for %s with the server %s, got %v", host, server, cookies)
+			}
+		}
+		if cookies := session.jar.Cookies(&url.URL{Scheme: "https", Host: "example.com", Path: "/"}); len(cookies) != 0 {
+			t.Errorf("Unexpected cookies for another site %v", cookies)
+		}
+	}
+}
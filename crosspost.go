@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"linedb"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The crosspost command posts public entries from the archive to another
+// platform. Posted entries are recorded in crosspost.linedb of the journal
+// directory so repeated runs post only new entries. Non-public entries are
+// never posted whatever the selection says.
+
+const crosspostDBFileName = "crosspost.linedb"
+
+var crosspostTargets = []string{"tumblr", "bluesky"}
+
+// Tumblr API v2 access with OAuth 1.0a credentials from the config. The
+// token does not expire, unlike OAuth 2 tokens from Tumblr.
+const defaultTumblrApiUrl = "https://api.tumblr.com/v2"
+
+type tumblrConfig struct {
+	apiUrl         string
+	blog           string
+	consumerKey    string
+	consumerSecret string
+	token          string
+	tokenSecret    string
+}
+
+type crosspostRecord struct {
+	target string
+	blog   string
+	itemId int64
+	postId string
+}
+
+type crosspostDB struct {
+	path    string
+	records []crosspostRecord
+}
+
+func readCrosspostDB(dir string) (*crosspostDB, *Report) {
+	db := &crosspostDB{path: filepath.Join(dir, crosspostDBFileName)}
+	dbdata, err := archiveStore.ReadFile(db.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return db, nil
+		}
+		return nil, WrapErr(err, "")
+	}
+	d := newLinedbDecoder(dbdata)
+	for d.NextItem() {
+		if d.ItemKind == linedb.TableItem && d.ItemName == "posts" {
+			for d.NextRow() {
+				db.records = append(db.records, crosspostRecord{d.GetString(), d.GetString(), d.GetInt64(), d.GetString()})
+			}
+		}
+	}
+	if err := d.GetError(); err != nil {
+		return nil, WrapErr(err, "failed to parse %s", db.path)
+	}
+	return db, nil
+}
+
+func (db *crosspostDB) write() *Report {
+	e := linedb.NewByteEncoder()
+	e.Comment("target blog itemid postid")
+	e.Table("posts")
+	for _, record := range db.records {
+		e.AddString(record.target).AddString(record.blog).AddInt64(record.itemId).AddString(record.postId).EndRow()
+	}
+	e.EndTable()
+	if err := writeFileTempRename(db.path, e.GetBytes()); err != nil {
+		return WrapErr(err, "")
+	}
+	return nil
+}
+
+func (db *crosspostDB) posted(target, blog string, itemId int64) bool {
+	for _, record := range db.records {
+		if record.target == target && record.blog == blog && record.itemId == itemId {
+			return true
+		}
+	}
+	return false
+}
+
+// Escape per RFC 3986 as OAuth requires, url.QueryEscape turns space into +
+func oauthEscape(s string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '.' || c == '_' || c == '~' {
+			buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+// Compute the HMAC-SHA1 signature over the request method, the URL without
+// the query and all oauth and request parameters.
+func oauthSignature(method, baseUrl string, params url.Values, consumerSecret, tokenSecret string) string {
+	var pairs []string
+	for key, values := range params {
+		for _, value := range values {
+			pairs = append(pairs, oauthEscape(key)+"="+oauthEscape(value))
+		}
+	}
+	sort.Strings(pairs)
+	base := method + "&" + oauthEscape(baseUrl) + "&" + oauthEscape(strings.Join(pairs, "&"))
+	mac := hmac.New(sha1.New, []byte(oauthEscape(consumerSecret)+"&"+oauthEscape(tokenSecret)))
+	mac.Write([]byte(base))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func oauthNonce() string {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Get the Authorization header value for a form POST
+func (tumblr *tumblrConfig) oauthHeader(postUrl string, form url.Values) string {
+	oauth := map[string]string{
+		"oauth_consumer_key":     tumblr.consumerKey,
+		"oauth_nonce":            oauthNonce(),
+		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_timestamp":        strconv.FormatInt(time.Now().Unix(), 10),
+		"oauth_token":            tumblr.token,
+		"oauth_version":          "1.0",
+	}
+	params := url.Values{}
+	for key, values := range form {
+		params[key] = values
+	}
+	for key, value := range oauth {
+		params.Set(key, value)
+	}
+	oauth["oauth_signature"] = oauthSignature("POST", postUrl, params, tumblr.consumerSecret, tumblr.tokenSecret)
+
+	keys := make([]string, 0, len(oauth))
+	for key := range oauth {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = oauthEscape(key) + "=\"" + oauthEscape(oauth[key]) + "\""
+	}
+	return "OAuth " + strings.Join(parts, ", ")
+}
+
+// Tumblr takes the post date in GMT. Entry times without the UTC offset are
+// taken as GMT.
+func tumblrPostDate(entry *archivedEntry) string {
+	t := entry.absoluteTime()
+	if t.IsZero() {
+		var err error
+		if t, err = time.Parse(ljEventTimeLayout, entry.eventTime); err != nil {
+			return ""
+		}
+	}
+	return t.UTC().Format("2006-01-02 15:04:05") + " GMT"
+}
+
+// Create a text post with the entry HTML and return the post id. The
+// legacy post endpoint is used as it keeps the HTML while the Neue Post
+// Format would need converting the markup into content blocks.
+func postToTumblr(client *http.Client, config *Config, entry *archivedEntry) (string, *Report) {
+	tumblr := config.tumblr
+	postUrl := tumblr.apiUrl + "/blog/" + url.PathEscape(tumblr.blog) + "/post"
+	form := url.Values{}
+	form.Set("type", "text")
+	form.Set("format", "html")
+	form.Set("state", "published")
+	form.Set("title", entry.subject)
+	form.Set("body", entryHtml(config, entry))
+	if date := tumblrPostDate(entry); date != "" {
+		form.Set("date", date)
+	}
+	if tags := entry.tags(); len(tags) != 0 {
+		form.Set("tags", strings.Join(tags, ","))
+	}
+	if entry.url != "" {
+		form.Set("source_url", entry.url)
+	}
+
+	req, err := http.NewRequest("POST", postUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", WrapErr(err, "failed to create request to %s", postUrl)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", tumblr.oauthHeader(postUrl, form))
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", WrapErr(err, "failed to post to %s", postUrl)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", WrapErr(err, "failed to read the response from %s", postUrl)
+	}
+	var result struct {
+		Meta struct {
+			Status int    `json:"status"`
+			Msg    string `json:"msg"`
+		} `json:"meta"`
+		Response struct {
+			IdString string `json:"id_string"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", WrapErr(err, "unexpected response %s from %s", resp.Status, postUrl)
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		config.errorLog.record("http", "%d POST %s", resp.StatusCode, postUrl)
+		return "", ReportMsg("Tumblr refused the post with %s - %s", resp.Status, result.Meta.Msg)
+	}
+	if result.Response.IdString == "" {
+		return "", ReportMsg("no post id in the response from %s", postUrl)
+	}
+	return result.Response.IdString, nil
+}
+
+// Get the entries of the journal to post to the blog of the target in
+// chronological order
+func selectCrosspostEntries(config *Config, dir string, db *crosspostDB, target, blog string) ([]*archivedEntry, *Report) {
+	entries, r := readJournalEntries(dir)
+	if r != nil {
+		return nil, r
+	}
+	var selected []*archivedEntry
+	for _, entry := range entries {
+		if entry.securityLevel() != securityPublic {
+			continue
+		}
+		if config.selectEntries != nil && !config.selectEntries.matches(entry) {
+			continue
+		}
+		if db.posted(target, blog, entry.itemId) {
+			continue
+		}
+		selected = append(selected, entry)
+	}
+	config.normalizeEntryTimes(selected)
+	sort.Sort(sortEntriesByTime(selected))
+	return selected, nil
+}
+
+func runCrosspost(config *Config) *Report {
+	target := config.commandArg
+	var blog, platform string
+	var post func(entry *archivedEntry) (string, *Report)
+	client := config.httpClient()
+	switch target {
+	case "tumblr":
+		if config.tumblr == nil {
+			return ReportMsg("crossposting to Tumblr needs the <tumblr> element with the blog and OAuth credentials in the config")
+		}
+		blog, platform = config.tumblr.blog, "Tumblr"
+		post = func(entry *archivedEntry) (string, *Report) {
+			return postToTumblr(client, config, entry)
+		}
+	case "bluesky":
+		if config.bluesky == nil {
+			return ReportMsg("crossposting to Bluesky needs the <bluesky> element with the handle and the app password in the config")
+		}
+		blog, platform = config.bluesky.handle, "Bluesky"
+		// Log in only when there is something to post
+		var session *blueskySession
+		post = func(entry *archivedEntry) (string, *Report) {
+			if session == nil {
+				var r *Report
+				if session, r = openBlueskySession(config, client); r != nil {
+					return "", r
+				}
+			}
+			return postToBluesky(session, config, entry)
+		}
+	default:
+		return ReportMsg("unknown crosspost target %s, supported targets are %s", target, strings.Join(crosspostTargets, ", "))
+	}
+	startShutdownHandling()
+	for _, journal := range config.journals {
+		dir := config.journalDir(journal)
+		db, r := readCrosspostDB(dir)
+		if r != nil {
+			return r
+		}
+		entries, r := selectCrosspostEntries(config, dir, db, target, blog)
+		if r != nil {
+			return r
+		}
+		log("Posting %d public entries of %s to %s account %s", len(entries), journal, platform, blog)
+		for _, entry := range entries {
+			if shutdownRequested() {
+				return interruptedReport()
+			}
+			if config.dryRun {
+				log("Would post %s %s %s", entry.fileName, entry.eventTime, entryDisplaySubject(entry))
+				continue
+			}
+			postId, r := post(entry)
+			if r != nil {
+				return CombineReports(r, ReportMsg("stopped posting %s after %s, the next run continues from it", journal, entry.fileName))
+			}
+			db.records = append(db.records, crosspostRecord{target, blog, entry.itemId, postId})
+			if r := db.write(); r != nil {
+				return r
+			}
+			log("Posted %s as %s post %s", entry.fileName, platform, postId)
+		}
+	}
+	return nil
+}
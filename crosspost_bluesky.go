@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Bluesky posts are created through the AT Protocol XRPC API of the PDS
+// of the account with a session opened with an app password. A post holds
+// at most 300 graphemes of plain text, so the entry HTML is converted to
+// text that starts with the date of the entry and its subject. Long
+// entries are cut with a link to the original, or with <thread> in the
+// config continue in replies that form a thread. The post time is the time
+// of crossposting as Bluesky labels backdated posts, so the original date
+// is kept only in the text. The uri of the first post is recorded. A
+// thread that fails in the middle is deleted, so the next run posts it
+// again in full.
+
+const defaultBlueskyService = "https://bsky.social"
+
+// Runes count at least as much as graphemes
+const blueskyPostLimit = 300
+
+var blueskyUrlPattern = regexp.MustCompile(`https?://[^\s]*[^\s.,;:!?)"']`)
+
+type blueskyConfig struct {
+	service     string
+	handle      string
+	appPassword string
+	thread      bool
+}
+
+type blueskySession struct {
+	config    *Config
+	client    *http.Client
+	service   string
+	did       string
+	accessJwt string
+}
+
+type blueskyRef struct {
+	Uri string `json:"uri"`
+	Cid string `json:"cid"`
+}
+
+// Call the XRPC procedure with the JSON input and decode the output
+func (s *blueskySession) call(method string, input interface{}, output interface{}) *Report {
+	callUrl := strings.TrimSuffix(s.service, "/") + "/xrpc/" + method
+	body, err := json.Marshal(input)
+	if err != nil {
+		return WrapErr(err, "")
+	}
+	req, err := http.NewRequest("POST", callUrl, bytes.NewReader(body))
+	if err != nil {
+		return WrapErr(err, "failed to create request to %s", callUrl)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.accessJwt != "" {
+		req.Header.Set("Authorization", "Bearer "+s.accessJwt)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return WrapErr(err, "failed to call %s", callUrl)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return WrapErr(err, "failed to read the response from %s", callUrl)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var failure struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(data, &failure)
+		s.config.errorLog.record("http", "%d POST %s", resp.StatusCode, callUrl)
+		return ReportMsg("Bluesky refused %s with %s - %s %s", method, resp.Status, failure.Error, failure.Message)
+	}
+	if output == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, output); err != nil {
+		return WrapErr(err, "unexpected response from %s", callUrl)
+	}
+	return nil
+}
+
+func openBlueskySession(config *Config, client *http.Client) (*blueskySession, *Report) {
+	bluesky := config.bluesky
+	s := &blueskySession{config: config, client: client, service: bluesky.service}
+	var result struct {
+		Did       string `json:"did"`
+		AccessJwt string `json:"accessJwt"`
+	}
+	input := map[string]string{"identifier": bluesky.handle, "password": bluesky.appPassword}
+	if r := s.call("com.atproto.server.createSession", input, &result); r != nil {
+		return nil, r
+	}
+	if result.Did == "" || result.AccessJwt == "" {
+		return nil, ReportMsg("no session in the response from %s", bluesky.service)
+	}
+	config.errorLog.addSecret(result.AccessJwt)
+	s.did, s.accessJwt = result.Did, result.AccessJwt
+	return s, nil
+}
+
+// Split the text into parts of at most limit runes preferring paragraph,
+// line and word boundaries that do not make the part shorter than a half
+func blueskySplitText(text string, limit int) []string {
+	var parts []string
+	runes := []rune(strings.TrimSpace(text))
+	for len(runes) > limit {
+		head := string(runes[:limit])
+		cut := -1
+		for _, sep := range []string{"\n\n", "\n", " "} {
+			if i := strings.LastIndex(head, sep); i > 0 {
+				if n := utf8.RuneCountInString(head[:i]); n >= limit/2 || sep == " " {
+					cut = n
+					break
+				}
+			}
+		}
+		if cut <= 0 {
+			cut = limit
+		}
+		parts = append(parts, strings.TrimSpace(string(runes[:cut])))
+		runes = []rune(strings.TrimSpace(string(runes[cut:])))
+	}
+	if len(runes) != 0 {
+		parts = append(parts, string(runes))
+	}
+	return parts
+}
+
+// Get the texts of the posts for the entry, one without thread
+func blueskyPostTexts(config *Config, entry *archivedEntry) []string {
+	text := entry.eventTime
+	if len(text) > 10 {
+		text = text[:10]
+	}
+	if entry.subject != "" {
+		text += ": " + entry.subject
+	}
+	text += "\n\n" + htmlToText(entryHtml(config, entry))
+	link := entry.url
+	if link == "" {
+		link = entry.permalink
+	}
+	if !config.bluesky.thread {
+		if utf8.RuneCountInString(text) <= blueskyPostLimit-utf8.RuneCountInString(link)-1 {
+			return []string{strings.TrimSpace(text + "\n" + link)}
+		}
+		first := blueskySplitText(text, blueskyPostLimit-utf8.RuneCountInString(link)-2)[0]
+		return []string{strings.TrimSpace(first + "…\n" + link)}
+	}
+	parts := blueskySplitText(text, blueskyPostLimit)
+	if link != "" {
+		last := parts[len(parts)-1]
+		if utf8.RuneCountInString(last)+1+utf8.RuneCountInString(link) <= blueskyPostLimit {
+			parts[len(parts)-1] = last + "\n" + link
+		} else {
+			parts = append(parts, link)
+		}
+	}
+	return parts
+}
+
+// Make link facets for the URLs in the text, which Bluesky does not detect
+// itself. The indexes are UTF-8 byte offsets.
+func blueskyLinkFacets(text string) []interface{} {
+	var facets []interface{}
+	for _, loc := range blueskyUrlPattern.FindAllStringIndex(text, -1) {
+		facets = append(facets, map[string]interface{}{
+			"index": map[string]int{"byteStart": loc[0], "byteEnd": loc[1]},
+			"features": []interface{}{map[string]string{
+				"$type": "app.bsky.richtext.facet#link",
+				"uri":   text[loc[0]:loc[1]],
+			}},
+		})
+	}
+	return facets
+}
+
+// Delete the post with the uri at://<did>/<collection>/<rkey>
+func (s *blueskySession) deletePost(uri string) *Report {
+	parts := strings.Split(strings.TrimPrefix(uri, "at://"), "/")
+	if len(parts) != 3 {
+		return ReportMsg("unexpected post uri %s", uri)
+	}
+	input := map[string]string{"repo": parts[0], "collection": parts[1], "rkey": parts[2]}
+	return s.call("com.atproto.repo.deleteRecord", input, nil)
+}
+
+// Post the entry as one post or a thread and return the uri of the first
+// post. When a reply of the thread fails, the posts made so far are
+// deleted so the next run does not leave a second copy of the thread.
+func postToBluesky(s *blueskySession, config *Config, entry *archivedEntry) (string, *Report) {
+	var root, parent *blueskyRef
+	var posted []string
+	for _, text := range blueskyPostTexts(config, entry) {
+		record := map[string]interface{}{
+			"$type":     "app.bsky.feed.post",
+			"text":      text,
+			"createdAt": time.Now().UTC().Format(time.RFC3339),
+		}
+		if facets := blueskyLinkFacets(text); len(facets) != 0 {
+			record["facets"] = facets
+		}
+		if root != nil {
+			record["reply"] = map[string]interface{}{"root": root, "parent": parent}
+		}
+		input := map[string]interface{}{"repo": s.did, "collection": "app.bsky.feed.post", "record": record}
+		var ref blueskyRef
+		if r := s.call("com.atproto.repo.createRecord", input, &ref); r != nil {
+			for i := len(posted) - 1; i >= 0; i-- {
+				if deleteReport := s.deletePost(posted[i]); deleteReport != nil {
+					r = CombineReports(r, CombineReports(deleteReport,
+						ReportMsg("delete the incomplete thread starting with %s before the next run", posted[0])))
+					break
+				}
+			}
+			return "", r
+		}
+		posted = append(posted, ref.Uri)
+		if root == nil {
+			root = &ref
+		}
+		parent = &ref
+	}
+	return root.Uri, nil
+}
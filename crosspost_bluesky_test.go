@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func Test_blueskySplitText(t *testing.T) {
+	text := strings.Repeat("word ", 30) + "\n\n" + strings.Repeat("слово ", 20)
+	parts := blueskySplitText(text, 160)
+	if len(parts) != 2 || parts[0] != strings.TrimSpace(strings.Repeat("word ", 30)) {
+		t.Fatalf("Unexpected split %q", parts)
+	}
+	parts = blueskySplitText(strings.Repeat("x", 250), 100)
+	if len(parts) != 3 || len(parts[2]) != 50 {
+		t.Errorf("Unexpected split of a long word %q", parts)
+	}
+}
+
+func Test_crosspostBluesky(t *testing.T) {
+	var records []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var input map[string]interface{}
+		if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+			t.Error(err)
+		}
+		switch req.URL.Path {
+		case "/xrpc/com.atproto.server.createSession":
+			if input["identifier"] != "bob.example.com" || input["password"] != "app-pass" {
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprint(w, `{"error":"AuthenticationRequired","message":"Invalid identifier or password"}`)
+				return
+			}
+			fmt.Fprint(w, `{"did":"did:plc:bob","accessJwt":"jwt"}`)
+		case "/xrpc/com.atproto.repo.createRecord":
+			if req.Header.Get("Authorization") != "Bearer jwt" || input["repo"] != "did:plc:bob" {
+				t.Errorf("Unexpected record request %v %s", input, req.Header.Get("Authorization"))
+			}
+			records = append(records, input["record"].(map[string]interface{}))
+			fmt.Fprintf(w, `{"uri":"at://did:plc:bob/app.bsky.feed.post/%d","cid":"c%d"}`, len(records), len(records))
+		default:
+			t.Errorf("Unexpected request %s", req.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	journalDir := filepath.Join(dumpDir, "bob")
+	if err := os.Mkdir(journalDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	long := strings.Repeat("Long story. ", 30)
+	entries := []string{
+		`<event><itemid>1</itemid><eventtime>2010-05-01 10:00:00</eventtime><subject>Trip</subject><event>Went &lt;b&gt;there&lt;/b&gt;</event><url>https://bob.example.com/257.html</url></event>`,
+		`<event><itemid>2</itemid><eventtime>2010-05-02 10:00:00</eventtime><subject>Secret</subject><event>x</event><security>private</security></event>`,
+		`<event><itemid>3</itemid><eventtime>2010-05-03 10:00:00</eventtime><subject>Story</subject><event>` + long + `</event><url>https://bob.example.com/513.html</url></event>`,
+	}
+	for i, entry := range entries {
+		path := filepath.Join(journalDir, fmt.Sprintf("L-%d", i+1))
+		if err := ioutil.WriteFile(path, []byte(`<?xml version="1.0"?>`+entry), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := &Config{
+		dumpDir:        dumpDir,
+		journals:       []string{"bob"},
+		journalAliases: make(map[string]string),
+		commandArg:     "bluesky",
+		bluesky: &blueskyConfig{
+			service:     server.URL,
+			handle:      "bob.example.com",
+			appPassword: "app-pass",
+			thread:      true,
+		},
+	}
+	for run := 0; run < 2; run++ {
+		if r := runCrosspost(config); r != nil {
+			t.Fatal(r.AsText())
+		}
+	}
+	if len(records) != 3 {
+		t.Fatalf("Expected three posts, got %d", len(records))
+	}
+	first := records[0]["text"].(string)
+	if first != "2010-05-01: Trip\n\nWent there\nhttps://bob.example.com/257.html" {
+		t.Errorf("Unexpected text %q", first)
+	}
+	facets, _ := records[0]["facets"].([]interface{})
+	if len(facets) != 1 || !strings.Contains(fmt.Sprint(facets[0]), "byteStart:29") {
+		t.Errorf("Unexpected facets %v", facets)
+	}
+	reply, _ := records[2]["reply"].(map[string]interface{})
+	if reply == nil || fmt.Sprint(reply["root"]) != "map[cid:c2 uri:at://did:plc:bob/app.bsky.feed.post/2]" {
+		t.Errorf("Unexpected reply %v", records[2]["reply"])
+	}
+	for _, record := range records {
+		if n := utf8.RuneCountInString(record["text"].(string)); n > blueskyPostLimit {
+			t.Errorf("Post has %d characters", n)
+		}
+	}
+	db, r := readCrosspostDB(journalDir)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(db.records) != 2 || db.records[1] != (crosspostRecord{"bluesky", "bob.example.com", 3, "at://did:plc:bob/app.bsky.feed.post/2"}) {
+		t.Errorf("Unexpected records %+v", db.records)
+	}
+}
+
+func Test_crosspostBlueskyFailedThread(t *testing.T) {
+	var posts, deleted []string
+	failed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var input map[string]interface{}
+		if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+			t.Error(err)
+		}
+		switch req.URL.Path {
+		case "/xrpc/com.atproto.server.createSession":
+			fmt.Fprint(w, `{"did":"did:plc:bob","accessJwt":"jwt"}`)
+		case "/xrpc/com.atproto.repo.createRecord":
+			if len(posts) == 1 && !failed {
+				failed = true
+				w.WriteHeader(http.StatusInternalServerError)
+				fmt.Fprint(w, `{"error":"InternalServerError","message":"try later"}`)
+				return
+			}
+			posts = append(posts, fmt.Sprintf("at://did:plc:bob/app.bsky.feed.post/%d", len(posts)+len(deleted)+1))
+			fmt.Fprintf(w, `{"uri":"%s","cid":"c"}`, posts[len(posts)-1])
+		case "/xrpc/com.atproto.repo.deleteRecord":
+			uri := fmt.Sprintf("at://%s/%s/%s", input["repo"], input["collection"], input["rkey"])
+			for i, post := range posts {
+				if post == uri {
+					posts = append(posts[:i], posts[i+1:]...)
+					deleted = append(deleted, uri)
+				}
+			}
+			fmt.Fprint(w, `{}`)
+		default:
+			t.Errorf("Unexpected request %s", req.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	journalDir := filepath.Join(dumpDir, "bob")
+	if err := os.Mkdir(journalDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	entry := `<?xml version="1.0"?><event><itemid>1</itemid><eventtime>2010-05-01 10:00:00</eventtime><subject>Story</subject><event>` +
+		strings.Repeat("Long story. ", 30) + `</event></event>`
+	if err := ioutil.WriteFile(filepath.Join(journalDir, "L-1"), []byte(entry), 0666); err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{
+		dumpDir:        dumpDir,
+		journals:       []string{"bob"},
+		journalAliases: make(map[string]string),
+		commandArg:     "bluesky",
+		bluesky:        &blueskyConfig{service: server.URL, handle: "bob.example.com", appPassword: "app-pass", thread: true},
+	}
+	if r := runCrosspost(config); r == nil {
+		t.Fatal("Expected the failed reply to fail the run")
+	}
+	if len(posts) != 0 || len(deleted) != 1 {
+		t.Fatalf("Expected the first post deleted, got posts %v deleted %v", posts, deleted)
+	}
+	if r := runCrosspost(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(posts) != 2 {
+		t.Errorf("Expected one thread of two posts, got %v", posts)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_oauthSignature(t *testing.T) {
+	// The example from the Twitter documentation on signing requests
+	params := url.Values{}
+	params.Set("status", "Hello Ladies + Gentlemen, a signed OAuth request!")
+	params.Set("include_entities", "true")
+	params.Set("oauth_consumer_key", "xvz1evFS4wEEPTGEFPHBog")
+	params.Set("oauth_nonce", "kYjzVBB8Y0ZFabxSWbWovY3uYSQ2pTgmZeNu2VS4cg")
+	params.Set("oauth_signature_method", "HMAC-SHA1")
+	params.Set("oauth_timestamp", "1318622958")
+	params.Set("oauth_token", "370773112-GmHxMAgYyLbNEtIKZeRNFsMKPR9EyMZeS9weJAEb")
+	params.Set("oauth_version", "1.0")
+	signature := oauthSignature(
+		"POST", "https://api.twitter.com/1.1/statuses/update.json", params,
+		"kAcSOqF21Fu85e7zjz7ZN2U4ZRhfV3WpwPAoE3Z7kBw", "LswwdoUaIvS8ltyTt5jkRh4J50vUPVVHtR2YPi5kE",
+	)
+	if expected := "hCtSmYh+iHYCEqBWrE7C7hYmtUk="; signature != expected {
+		t.Errorf("Expected signature %s, got %s", expected, signature)
+	}
+}
+
+func Test_crosspostTumblr(t *testing.T) {
+	var posted []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v2/blog/example.tumblr.com/post" || !strings.HasPrefix(req.Header.Get("Authorization"), "OAuth ") {
+			t.Errorf("Unexpected request %s %s", req.URL.Path, req.Header.Get("Authorization"))
+		}
+		req.ParseForm()
+		posted = append(posted, req.PostForm)
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"meta":{"status":201,"msg":"Created"},"response":{"id":%d,"id_string":"%d"}}`, 100+len(posted), 100+len(posted))
+	}))
+	defer server.Close()
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	journalDir := filepath.Join(dumpDir, "bob")
+	if err := os.Mkdir(journalDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	entries := []string{
+		`<event><itemid>1</itemid><eventtime>2010-05-01 10:00:00</eventtime><subject>Trip</subject><event>Went &lt;b&gt;there&lt;/b&gt;</event><props><taglist>travel, photo</taglist></props></event>`,
+		`<event><itemid>2</itemid><eventtime>2010-05-02 10:00:00</eventtime><subject>Secret trip</subject><event>x</event><security>private</security><props><taglist>travel</taglist></props></event>`,
+		`<event><itemid>3</itemid><eventtime>2010-05-03 10:00:00</eventtime><subject>Other</subject><event>y</event></event>`,
+	}
+	for i, entry := range entries {
+		path := filepath.Join(journalDir, fmt.Sprintf("L-%d", i+1))
+		if err := ioutil.WriteFile(path, []byte(`<?xml version="1.0"?>`+entry), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	search, err := parseSavedSearch("select", "tag=travel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{
+		dumpDir:        dumpDir,
+		journals:       []string{"bob"},
+		journalAliases: make(map[string]string),
+		commandArg:     "tumblr",
+		selectEntries:  search,
+		tumblr: &tumblrConfig{
+			apiUrl: server.URL + "/v2",
+			blog:   "example.tumblr.com",
+		},
+	}
+	for run := 0; run < 2; run++ {
+		if r := runCrosspost(config); r != nil {
+			t.Fatal(r.AsText())
+		}
+	}
+	if len(posted) != 1 {
+		t.Fatalf("Expected one post, got %d", len(posted))
+	}
+	form := posted[0]
+	if form.Get("title") != "Trip" || form.Get("body") != "Went <b>there</b>" ||
+		form.Get("tags") != "travel,photo" || form.Get("date") != "2010-05-01 10:00:00 GMT" {
+		t.Errorf("Unexpected post %v", form)
+	}
+	db, r := readCrosspostDB(journalDir)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(db.records) != 1 || db.records[0] != (crosspostRecord{"tumblr", "example.tumblr.com", 1, "101"}) {
+		t.Errorf("Unexpected records %+v", db.records)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The doctor command checks the things that usually break a dump before
+// any journal is touched: the server must be reachable, the credentials
+// must work for a protocol call, the account must have access to the
+// configured journals, the dump directory must be writable and
+// no leftovers of interrupted runs or of ljdump.py should be around. Each
+// problem is printed with the way to fix it. The command only reads the
+// archive apart from a probe file in each checked directory.
+
+type doctorResult struct {
+	problems int
+}
+
+func (dr *doctorResult) ok(format string, args ...interface{}) {
+	fmt.Printf("ok:      %s\n", fmt.Sprintf(format, args...))
+}
+
+func (dr *doctorResult) problem(fix string, format string, args ...interface{}) {
+	dr.problems++
+	fmt.Printf("PROBLEM: %s\n", fmt.Sprintf(format, args...))
+	fmt.Printf("  fix:   %s\n", fix)
+}
+
+func (dr *doctorResult) note(format string, args ...interface{}) {
+	fmt.Printf("note:    %s\n", fmt.Sprintf(format, args...))
+}
+
+// Describe why a request failed and how to fix it
+func connectionFix(config *Config, err error) string {
+	var dnsErr *net.DNSError
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalidCert x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &dnsErr):
+		return fmt.Sprintf("the host name in %s cannot be resolved, check <server> in the config or -server and the DNS settings", config.server)
+	case errors.As(err, &unknownAuthority) || errors.As(err, &invalidCert) || errors.As(err, &hostnameErr):
+		return "the TLS certificate of the server is not accepted, check that the system clock is correct and the CA certificates are installed"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "the server did not answer in time, check the network connection, set HTTPS_PROXY when a proxy is required or raise -request-timeout"
+	}
+	return "check the network connection and the server URL, set HTTPS_PROXY when a proxy is required"
+}
+
+func doctorCheckServer(config *Config, dr *doctorResult) bool {
+	urls := []string{config.server + "/interface/flat"}
+	if config.api == jsonrpcAPI {
+		urls = append(urls, config.apiUrl)
+	}
+	reachable := true
+	client := config.httpClient()
+	for _, u := range urls {
+		resp, err := client.Get(u)
+		if err != nil {
+			var urlErr *url.Error
+			if errors.As(err, &urlErr) {
+				err = urlErr.Err
+			}
+			dr.problem(connectionFix(config, err), "cannot connect to %s - %s", u, err.Error())
+			reachable = false
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			dr.problem("the server has problems, try again later", "%s answered %s", u, resp.Status)
+			reachable = false
+			continue
+		}
+		dr.ok("%s is reachable", u)
+	}
+	return reachable
+}
+
+func doctorCheckLogin(config *Config, dr *doctorResult) {
+	session, r := openLJSession(config)
+	if r != nil {
+		dr.problem(
+			"check the username and the password, API key or OAuth tokens in the config, see Authentication in README.md",
+			"login as %s failed - %s", config.username, r.AsText(),
+		)
+		return
+	}
+	dr.ok("logged in as %s with %s", config.username, session.auth.authMethod())
+	if _, r := fetchFriendsData(session); r != nil {
+		fix := "the login works but protocol calls fail, check -api and -api-url"
+		if config.api != jsonrpcAPI {
+			fix = "the login works but protocol calls fail, the server may not support the XML-RPC protocol"
+		}
+		dr.problem(fix, "getfriends call failed - %s", r.AsText())
+		return
+	}
+	dr.ok("protocol calls with the %s API work", config.api)
+	doctorCheckJournalAccess(session, dr)
+}
+
+// Print what the account may archive of each configured journal, see
+// capabilities.go
+func doctorCheckJournalAccess(session *ljSession, dr *doctorResult) {
+	config := session.config
+	for _, journal := range config.journals {
+		if journal == config.username {
+			continue
+		}
+		caps, r := probeJournalCapabilities(session, journal)
+		switch {
+		case r != nil:
+			dr.problem("check the journal name in the config", "cannot check access to %s - %s", journal, r.AsText())
+		case !caps.entries && !caps.comments:
+			dr.problem(
+				"join "+journal+" with posting access or ask to become a maintainer, or remove it from the config",
+				"%s can archive nothing of %s", config.username, journal,
+			)
+		case !caps.entries || !caps.comments:
+			dr.note("%s can archive only part of %s: %s", config.username, journal, caps.summary())
+		default:
+			dr.ok("%s can archive %s: %s", config.username, journal, caps.summary())
+		}
+	}
+}
+
+// Create and remove a probe file in the directory if it exists
+func doctorCheckWritable(dir string, dr *doctorResult) {
+	if _, err := archiveStore.Stat(dir); os.IsNotExist(err) {
+		return
+	}
+	name := filepath.Join(dir, "doctor-probe")
+	err := archiveStore.WriteFile(name, nil, archiveFileMode)
+	if err == nil {
+		err = archiveStore.Remove(name)
+	}
+	if err != nil {
+		dr.problem(
+			"make the directory writable by the user that runs ljdumpgo and check the free disk space",
+			"cannot write into %s - %s", dir, err.Error(),
+		)
+		return
+	}
+	dr.ok("%s is writable", dir)
+}
+
+// Return true when another run holds the lock of the dump directory
+func doctorDumpLocked(config *Config) bool {
+	f, err := os.OpenFile(filepath.Join(config.dumpDir, lockFileName), os.O_RDWR, 0)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	locked, err := tryLockFile(f)
+	return err == nil && !locked
+}
+
+func doctorCheckLeftovers(config *Config, dr *doctorResult) *Report {
+	var tmpFiles, pendingDirs []string
+	err := walkStore(config.dumpDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		switch {
+		case strings.HasSuffix(path, ".tmp"):
+			tmpFiles = append(tmpFiles, path)
+		case info.Name() == pendingWritesFileName:
+			pendingDirs = append(pendingDirs, filepath.Dir(path))
+		}
+		return nil
+	})
+	if err != nil {
+		return WrapErr(err, "")
+	}
+	for _, dir := range pendingDirs {
+		dr.problem(
+			"run the dump again to finish them, do not edit files in the directory before that",
+			"%s has writes that an interrupted run did not finish", dir,
+		)
+	}
+	if len(tmpFiles) != 0 {
+		shown := tmpFiles
+		if len(shown) > 5 {
+			shown = shown[:5]
+		}
+		dr.problem(
+			"these are left by writes that were interrupted and can be deleted",
+			"%d stale .tmp files like %s", len(tmpFiles), strings.Join(shown, ", "),
+		)
+	}
+	if len(tmpFiles) == 0 && len(pendingDirs) == 0 {
+		dr.ok("no leftovers of interrupted runs")
+	}
+
+	for _, journal := range config.journals {
+		dir := config.journalDir(journal)
+		var pythonFiles []string
+		for _, name := range append([]string{"userpics.xml"}, pythonJournalFileNames...) {
+			if _, err := archiveStore.Stat(filepath.Join(dir, name)); err == nil {
+				pythonFiles = append(pythonFiles, name)
+			}
+		}
+		if len(pythonFiles) == 0 {
+			continue
+		}
+		if _, err := archiveStore.Stat(filepath.Join(dir, journalDBFileName)); os.IsNotExist(err) {
+			dr.note("%s has ljdump.py files %s that the next dump converts", dir, strings.Join(pythonFiles, ", "))
+			continue
+		}
+		dr.problem(
+			"they were converted into "+journalDBFileName+" and account.data and can be moved into "+legacyDirName+" unless ljdump.py is still used",
+			"%s has ljdump.py files %s that ljdumpgo no longer reads", dir, strings.Join(pythonFiles, ", "),
+		)
+	}
+	return nil
+}
+
+func runDoctor(config *Config) *Report {
+	var dr doctorResult
+	fmt.Printf("Checking %s as %s with the dump directory %s\n", config.server, config.username, config.dumpDir)
+
+	if doctorCheckServer(config, &dr) {
+		doctorCheckLogin(config, &dr)
+	}
+
+	doctorCheckWritable(config.dumpDir, &dr)
+	doctorCheckWritable(config.accountDataDir, &dr)
+	for _, journal := range config.journals {
+		doctorCheckWritable(config.journalDir(journal), &dr)
+	}
+
+	if doctorDumpLocked(config) {
+		dr.note("another ljdumpgo run uses the dump directory, files it writes now may look like leftovers")
+	} else if r := doctorCheckLeftovers(config, &dr); r != nil {
+		return r
+	}
+
+	if dr.problems != 0 {
+		return ReportMsg("found %d problems", dr.problems)
+	}
+	log("No problems found")
+	return nil
+}
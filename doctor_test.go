@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_runDoctor(t *testing.T) {
+	server := newFakeLJServer(t)
+	defer server.Close()
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	config := &Config{
+		server:         server.URL,
+		username:       "con",
+		password:       "password",
+		journals:       []string{"con"},
+		dumpDir:        dumpDir,
+		accountDataDir: filepath.Join(dumpDir, accountDataDirName),
+		journalAliases: make(map[string]string),
+		api:            xmlrpcAPI,
+	}
+	if r := runDoctor(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+
+	journalDir := config.journalDir("con")
+	for _, name := range []string{"L-1.tmp", journalDBFileName, ".last"} {
+		if err := os.MkdirAll(journalDir, 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(journalDir, name), nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := runDoctor(config)
+	if r == nil || !strings.Contains(r.AsText(), "found 2 problems") {
+		t.Errorf("Expected stale .tmp and ljdump.py problems, got %v", r)
+	}
+
+	config.server = "http://127.0.0.1:1"
+	if r := runDoctor(config); r == nil {
+		t.Errorf("Expected connection problem")
+	}
+}
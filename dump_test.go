@@ -0,0 +1,571 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Minimal LJ server with one entry and one comment in the journal con.
+// The name is reserved on Windows so the test covers the directory name
+// conversion. Entries posted with postevent are collected in postedEvents
+// and files posted to /admin/import_comments in importedComments. Tests
+// edit the comment through commentBody and commentEditTime, delete it with
+// commentDeleted and set its poster_ip property with commentPosterIp.
+// geteventsCalls counts fetched entries and snapshotRequests the requests
+// of the entry page. With usejournalFault protocol calls for other journals
+// fail as for a non-member and with authasForbidden so do the pages for
+// them as for a non-maintainer. getfriendsFault and geteventsFault fail
+// getfriends and getevents. With
+// eventUrl getevents returns it as the url of the entry, which is served
+// as the entry page for the login cookie or, with snapshotProtected, as
+// the notice of a protected entry.
+type fakeLJServer struct {
+	*httptest.Server
+	postedEvents     []string
+	importedComments []string
+	commentBody      string
+	commentEditTime  string
+	commentPosterIp  string
+	commentDeleted   bool
+	geteventsCalls   int
+	snapshotRequests int
+	usejournalFault  bool
+	authasForbidden  bool
+	getfriendsFault  bool
+	geteventsFault   bool
+	eventText        string
+	eventSyncTime    string
+	eventSecurity    string
+	eventUrl         string
+
+	snapshotProtected bool
+}
+
+func newFakeLJServer(t *testing.T) *fakeLJServer {
+	server := &fakeLJServer{commentBody: "Nice", eventText: "Hello", eventSyncTime: "2020-01-01 10:00:00"}
+	methodPattern := regexp.MustCompile(`<methodName>LJ\.XMLRPC\.(\w+)</methodName>`)
+	xmlrpcResponse := func(w http.ResponseWriter, value string) {
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprintf(w, `<?xml version="1.0"?><methodResponse><params><param><value>%s</value></param></params></methodResponse>`, value)
+	}
+	member := func(name, value string) string {
+		return "<member><name>" + name + "</name><value>" + value + "</value></member>"
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/interface/flat", func(w http.ResponseWriter, req *http.Request) {
+		req.ParseForm()
+		switch req.Form.Get("mode") {
+		case "getchallenge":
+			fmt.Fprint(w, "success\nOK\nchallenge\nc0ffee\n")
+		case "sessiongenerate":
+			fmt.Fprint(w, "success\nOK\nljsession\nv1:u1:s1:secret\n")
+		case "login":
+			fmt.Fprint(w, "success\nOK\npickw_count\n0\npickwurl_count\n0\n")
+		default:
+			fmt.Fprint(w, "success\nFAIL\nerrmsg\nunknown mode\n")
+		}
+	})
+	mux.HandleFunc("/interface/xmlrpc", func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		m := methodPattern.FindSubmatch(body)
+		if m == nil {
+			t.Errorf("Unexpected XML-RPC request %s", body)
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if server.usejournalFault && strings.Contains(string(body), "<name>usejournal</name>") {
+			w.Header().Set("Content-Type", "text/xml")
+			fmt.Fprint(w, `<?xml version="1.0"?><methodResponse><fault><value><struct>`+
+				member("faultCode", "<int>300</int>")+
+				member("faultString", "<string>Don't have access to requested journal</string>")+
+				`</struct></value></fault></methodResponse>`)
+			return
+		}
+		if server.getfriendsFault && string(m[1]) == "getfriends" || server.geteventsFault && string(m[1]) == "getevents" {
+			w.Header().Set("Content-Type", "text/xml")
+			fmt.Fprint(w, `<?xml version="1.0"?><methodResponse><fault><value><struct>`+
+				member("faultCode", "<int>500</int>")+
+				member("faultString", "<string>Server error</string>")+
+				`</struct></value></fault></methodResponse>`)
+			return
+		}
+		switch string(m[1]) {
+		case "getfriends":
+			xmlrpcResponse(w, "<struct>"+
+				member("friends", "<array><data></data></array>")+
+				member("friendofs", "<array><data></data></array>")+
+				member("friendgroups", "<array><data></data></array>")+
+				"</struct>")
+		case "syncitems":
+			items := ""
+			if !strings.Contains(string(body), server.eventSyncTime) {
+				items = "<value><struct>" +
+					member("item", "<string>L-1</string>") +
+					member("action", "<string>create</string>") +
+					member("time", "<string>"+server.eventSyncTime+"</string>") +
+					"</struct></value>"
+			}
+			xmlrpcResponse(w, "<struct>"+member("syncitems", "<array><data>"+items+"</data></array>")+"</struct>")
+		case "getevents":
+			server.geteventsCalls++
+			eventUrl := ""
+			if server.eventUrl != "" {
+				eventUrl = member("url", "<string>"+server.eventUrl+"</string>")
+			}
+			security := ""
+			if server.eventSecurity != "" {
+				security = member("security", "<string>"+server.eventSecurity+"</string>")
+			}
+			xmlrpcResponse(w, "<struct>"+member("events", "<array><data><value><struct>"+
+				member("itemid", "<int>1</int>")+
+				member("anum", "<int>42</int>")+
+				member("eventtime", "<string>2020-01-01 09:00:00</string>")+
+				member("subject", "<string>First</string>")+
+				member("event", "<string>"+server.eventText+"</string>")+
+				eventUrl+
+				security+
+				"</struct></value></data></array>")+"</struct>")
+		case "getdaycounts":
+			xmlrpcResponse(w, "<struct>"+member("daycounts", "<array><data><value><struct>"+
+				member("date", "<string>2020-01-01</string>")+
+				member("count", "<int>1</int>")+
+				"</struct></value></data></array>")+"</struct>")
+		case "postevent":
+			server.postedEvents = append(server.postedEvents, string(body))
+			itemId := strconv.Itoa(100 + len(server.postedEvents))
+			xmlrpcResponse(w, "<struct>"+
+				member("itemid", "<int>"+itemId+"</int>")+
+				member("anum", "<int>1</int>")+
+				member("url", "<string>https://example.com/"+itemId+".html</string>")+
+				"</struct>")
+		case "getinbox":
+			items := ""
+			if !strings.Contains(string(body), "lastsync") {
+				items = "<value><struct>" +
+					member("qid", "<int>3</int>") +
+					member("when", "<int>1577872800</int>") +
+					member("typename", "<string>UserMessageRecvd</string>") +
+					member("extended", "<struct>"+
+						member("from", "<string>alice</string>")+
+						member("body", "<string>Private hello</string>")+
+						"</struct>") +
+					"</struct></value>"
+			}
+			xmlrpcResponse(w, "<struct>"+member("items", "<array><data>"+items+"</data></array>")+"</struct>")
+		case "consolecommand":
+			line := func(kind, text string) string {
+				return "<value><array><data><value><string>" + kind + "</string></value><value><string>" + text + "</string></value></data></array></value>"
+			}
+			xmlrpcResponse(w, "<struct>"+member("results", "<array><data><value><struct>"+
+				member("success", "<int>1</int>")+
+				member("output", "<array><data>"+line("info", "Banned users:")+line("", "  troll")+"</data></array>")+
+				"</struct></value></data></array>")+"</struct>")
+		default:
+			t.Errorf("Unexpected XML-RPC method %s", m[1])
+			http.Error(w, "bad request", http.StatusBadRequest)
+		}
+	})
+	mux.HandleFunc("/users/con/298.html", func(w http.ResponseWriter, req *http.Request) {
+		server.snapshotRequests++
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, "<html><body><div class=\"entry\">Hello</div><div class=\"comment\">%s</div></body></html>", server.commentBody)
+	})
+	mux.HandleFunc("/298.html", func(w http.ResponseWriter, req *http.Request) {
+		server.snapshotRequests++
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if cookie, err := req.Cookie("ljsession"); err != nil || cookie.Value == "" || !strings.HasPrefix(req.Host, "con.") {
+			fmt.Fprint(w, `<html class="s-logged-out"><body><form action="https://www.lj.test/login.bml"></form></body></html>`)
+		} else if server.snapshotProtected {
+			fmt.Fprint(w, "<html><head><title>Protected Entry</title></head><body>Log in as a friend</body></html>")
+		} else {
+			fmt.Fprintf(w, "<html><body><div class=\"entry\">Hello</div><div class=\"comment\">%s</div></body></html>", server.commentBody)
+		}
+	})
+	mux.HandleFunc("/users/con/data/foaf", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/rdf+xml")
+		fmt.Fprint(w, `<?xml version="1.0"?><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns:foaf="http://xmlns.com/foaf/0.1/" xmlns:ya="http://blogs.yandex.ru/schema/foaf/"><foaf:Person><foaf:nick>con</foaf:nick><ya:userid>77</ya:userid></foaf:Person></rdf:RDF>`)
+	})
+	mux.HandleFunc("/export_comments.bml", func(w http.ResponseWriter, req *http.Request) {
+		if server.authasForbidden && req.FormValue("authas") != "" {
+			http.Error(w, "not a maintainer", http.StatusForbidden)
+			return
+		}
+		startId, _ := strconv.Atoi(req.FormValue("startid"))
+		w.Header().Set("Content-Type", "text/xml")
+		switch req.FormValue("get") {
+		case "comment_meta":
+			comments := ""
+			if startId <= 5 {
+				comments = `<comment id="5" posterid="7" state="A"/>`
+				if server.commentDeleted {
+					comments = `<comment id="5" posterid="7" state="D"/>`
+				}
+			}
+			fmt.Fprintf(w, `<livejournal><maxid>5</maxid><comments>%s</comments><usermaps><usermap id="7" user="alice"/></usermaps></livejournal>`, comments)
+		case "comment_body":
+			comments := ""
+			if startId <= 5 {
+				props := ""
+				if server.commentEditTime != "" {
+					props = `<property name="edit_time">` + server.commentEditTime + `</property>`
+				}
+				if server.commentPosterIp != "" {
+					props += `<property name="poster_ip">` + server.commentPosterIp + `</property>`
+				}
+				comments = `<comment id="5" posterid="7" jitemid="1"><body>` + server.commentBody +
+					`</body><date>2020-01-01T11:00:00Z</date>` + props + `</comment>`
+				if server.commentDeleted {
+					comments = `<comment id="5" posterid="7" jitemid="1" state="D"><date>2020-01-01T11:00:00Z</date></comment>`
+				}
+			}
+			fmt.Fprintf(w, `<livejournal><comments>%s</comments></livejournal>`, comments)
+		}
+	})
+	mux.HandleFunc("/export_do.bml", func(w http.ResponseWriter, req *http.Request) {
+		entries := ""
+		if req.FormValue("year") == "2020" && req.FormValue("month") == "01" {
+			entries = `<entry><itemid>1</itemid><eventtime>2020-01-01 09:00:00</eventtime><subject>First</subject>` +
+				`<event>Hello</event><security>public</security><allowmask>0</allowmask><current_mood>happy</current_mood></entry>`
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><livejournal>%s</livejournal>`, entries)
+	})
+	mux.HandleFunc("/community/", func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/community/members.bml":
+			fmt.Fprint(w, `<span class="ljuser" lj:user="con">con</span><span lj:user="alice">alice</span>
+<input type="checkbox" name="post_7" checked><span lj:user="bob">bob</span>`)
+		case "/community/moderate.bml":
+			if req.FormValue("modid") == "12" {
+				fmt.Fprint(w, `<span lj:user="owner">owner</span> Posted by <span lj:user="bob">bob</span>: Pending text`)
+				return
+			}
+			fmt.Fprint(w, `<a href="/community/moderate.bml?authas=con&amp;modid=12">Subject</a>`)
+		default:
+			http.NotFound(w, req)
+		}
+	})
+	mux.HandleFunc("/admin/import_comments", func(w http.ResponseWriter, req *http.Request) {
+		if !strings.Contains(req.Header.Get("Cookie"), "ljsession=") {
+			http.Error(w, "not logged in", http.StatusForbidden)
+			return
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		server.importedComments = append(server.importedComments, string(body))
+	})
+	server.Server = httptest.NewServer(mux)
+	return server
+}
+
+func Test_runDumpFakeServer(t *testing.T) {
+	server := newFakeLJServer(t)
+	defer server.Close()
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	config := &Config{
+		server:         server.URL,
+		username:       "con",
+		password:       "password",
+		journals:       []string{"con"},
+		dumpDir:        dumpDir,
+		accountDataDir: filepath.Join(dumpDir, accountDataDirName),
+		journalAliases: make(map[string]string),
+	}
+	for run := 0; run < 2; run++ {
+		if r := runDump(config); r != nil {
+			t.Fatalf("Dump %d failed - %s", run, r.AsText())
+		}
+	}
+
+	journalDir := filepath.Join(dumpDir, "con_")
+	entry, err := readArchivedEntry(filepath.Join(journalDir, "L-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.subject != "First" || entry.event != "Hello" {
+		t.Errorf("Unexpected entry %q %q", entry.subject, entry.event)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(journalDir, "L-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "<ditemid>298</ditemid>") ||
+		!strings.Contains(string(data), "<permalink>"+server.URL+"/users/con/298.html</permalink>") {
+		t.Errorf("The entry file does not store ditemid and permalink:\n%s", data)
+	}
+	comments, r := readEntryComments(journalDir, 1)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(comments) != 1 || comments[0].User != "alice" || comments[0].Body != "Nice" {
+		t.Errorf("Unexpected comments %+v", comments)
+	}
+	jcx := &journalContext{config: config, name: "con", dir: journalDir}
+	if r := readJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if jcx.db.lastSync != "2020-01-01 10:00:00" || len(jcx.db.commentMap) != 1 {
+		t.Errorf("Unexpected journal DB, lastSync %q, %d comments", jcx.db.lastSync, len(jcx.db.commentMap))
+	}
+	if len(jcx.db.syncActions) != 1 || jcx.db.syncActions[0] != (syncAction{"L-1", "create", "2020-01-01 10:00:00"}) {
+		t.Errorf("Unexpected sync log %+v", jcx.db.syncActions)
+	}
+	index, r := readEntriesIndex(journalDir)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if index == nil || len(index.rows) != 1 ||
+		*index.rows[1] != (entryIndexRow{1, "2020-01-01 09:00:00", "First", "public", "", 1, "L-1", "", "", 298, server.URL + "/users/con/298.html"}) {
+		t.Errorf("Unexpected entries index %+v", index)
+	}
+}
+
+func Test_dumpWithoutFriends(t *testing.T) {
+	server := newFakeLJServer(t)
+	defer server.Close()
+	server.getfriendsFault = true
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	config := &Config{
+		server:         server.URL,
+		username:       "con",
+		password:       "password",
+		journals:       []string{"con"},
+		dumpDir:        dumpDir,
+		accountDataDir: filepath.Join(dumpDir, accountDataDirName),
+		journalAliases: make(map[string]string),
+		errorLog:       &errorLog{},
+	}
+	if r := runDump(config); r != nil {
+		t.Fatalf("Dump failed - %s", r.AsText())
+	}
+	if _, err := os.Stat(filepath.Join(dumpDir, "con_", "L-1")); err != nil {
+		t.Errorf("Entry was not archived - %s", err)
+	}
+	if n := config.errorLog.count(); n == 0 || config.errorLog.records[0].kind != "warning" {
+		t.Errorf("Expected a recorded warning, got %+v", config.errorLog.records)
+	}
+}
+
+func Test_recheckComments(t *testing.T) {
+	server := newFakeLJServer(t)
+	defer server.Close()
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	config := &Config{
+		server:         server.URL,
+		username:       "con",
+		password:       "password",
+		journals:       []string{"con"},
+		dumpDir:        dumpDir,
+		accountDataDir: filepath.Join(dumpDir, accountDataDirName),
+		journalAliases: make(map[string]string),
+	}
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+
+	// Without -recheck-comments the edit is not seen
+	server.commentBody = "Nice, edited"
+	server.commentEditTime = "1600000000"
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	journalDir := filepath.Join(dumpDir, "con_")
+	comments, r := readEntryComments(journalDir, 1)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(comments) != 1 || comments[0].Body != "Nice" {
+		t.Fatalf("Unexpected comments %+v", comments)
+	}
+
+	config.recheckComments = true
+	for run := 0; run < 2; run++ {
+		if r := runDump(config); r != nil {
+			t.Fatal(r.AsText())
+		}
+		comments, r = readEntryComments(journalDir, 1)
+		if r != nil {
+			t.Fatal(r.AsText())
+		}
+		if len(comments) != 1 || comments[0].Body != "Nice, edited" || comments[0].EditTime != "1600000000" ||
+			len(comments[0].History) != 1 || comments[0].History[0] != (CommentVersion{State: "A", Body: "Nice"}) {
+			t.Errorf("Unexpected comments after recheck %d %+v", run, comments)
+		}
+	}
+	jcx := &journalContext{config: config, name: "con", dir: journalDir}
+	if r := readJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if jcx.db.commentEditTimes[5] != "1600000000" {
+		t.Errorf("Unexpected edit times %v", jcx.db.commentEditTimes)
+	}
+}
+
+func Test_refetchMissingComments(t *testing.T) {
+	server := newFakeLJServer(t)
+	defer server.Close()
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	config := &Config{
+		server:         server.URL,
+		username:       "con",
+		password:       "password",
+		journals:       []string{"con"},
+		dumpDir:        dumpDir,
+		accountDataDir: filepath.Join(dumpDir, accountDataDirName),
+		journalAliases: make(map[string]string),
+	}
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	journalDir := filepath.Join(dumpDir, "con_")
+	entry := `<?xml version="1.0" encoding="UTF-8"?>
+<event>
+<event>Hello</event>
+<eventtime>2020-01-01 09:00:00</eventtime>
+<itemid>1</itemid>
+<props>
+<reply_count>1</reply_count>
+</props>
+<subject>First</subject>
+</event>
+`
+	if err := ioutil.WriteFile(filepath.Join(journalDir, "L-1"), []byte(entry), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if r := runVerify(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if err := os.Remove(filepath.Join(journalDir, "C-1")); err != nil {
+		t.Fatal(err)
+	}
+	if r := runVerify(config); r == nil || !strings.Contains(r.AsText(), "1 problems") {
+		t.Fatalf("Expected missing comments, got %v", r)
+	}
+	jcx := &journalContext{config: config, name: "con", dir: journalDir}
+	if r := readJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(jcx.db.commentRefetch) != 1 || jcx.db.commentRefetch[0] != 1 {
+		t.Errorf("Unexpected queue %v", jcx.db.commentRefetch)
+	}
+
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	comments, r := readEntryComments(journalDir, 1)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(comments) != 1 || comments[0].Body != "Nice" {
+		t.Errorf("Unexpected comments %+v", comments)
+	}
+	if r := runVerify(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if r := readJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(jcx.db.commentRefetch) != 0 {
+		t.Errorf("Unexpected queue after the dump %v", jcx.db.commentRefetch)
+	}
+}
+
+func Test_portableFileName(t *testing.T) {
+	casePairs := [...]string{
+		"bob", "bob",
+		"some_user-1", "some_user-1",
+		"con", "con_",
+		"LPT1", "LPT1_",
+		"aux.txt", "aux.txt_",
+		"a:b*c?", "a_b_c_",
+		"dots..", "dots__",
+		"", "_",
+	}
+	for i := 0; i < len(casePairs); i += 2 {
+		if to := portableFileName(casePairs[i]); to != casePairs[i+1] {
+			t.Errorf("Expected %q, got %q while converting %q", casePairs[i+1], to, casePairs[i])
+		}
+	}
+
+	config := &Config{dumpDir: ".", journals: []string{"Bob", "bob"}}
+	if r := checkJournalDirCollisions(config); r == nil {
+		t.Errorf("Expected collision between Bob and bob")
+	}
+}
+
+func Test_checkJournalName(t *testing.T) {
+	for _, name := range []string{"bob", "some_user", "some-user", "ext_12345", "_a_"} {
+		if err := checkJournalName(name); err != nil {
+			t.Errorf("Unexpected error for %q: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "../../etc", "a/b", `a\b`, ".", "..", "bob.", "a b", "\u0431\u043e\u0431", strings.Repeat("a", 51)} {
+		if err := checkJournalName(name); err == nil {
+			t.Errorf("Expected error for %q", name)
+		}
+	}
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	config := &Config{
+		dumpDir:        dumpDir,
+		journals:       []string{"bob", "Some-User", "con"},
+		journalAliases: make(map[string]string),
+	}
+	if r := recordJournalDirNames(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	aliases, r := readJournalAliases(dumpDir)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(aliases) != 2 || aliases["Some-User"] != "some_user" || aliases["con"] != "con_" {
+		t.Errorf("Unexpected aliases %v", aliases)
+	}
+	if dir := config.journalDir("Some-User"); dir != filepath.Join(dumpDir, "some_user") {
+		t.Errorf("Unexpected directory %s", dir)
+	}
+
+	bad := "@table aliases\n\"bob\" \"../etc\"\n@end\n"
+	if err := ioutil.WriteFile(filepath.Join(dumpDir, journalAliasesFileName), []byte(bad), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, r := readJournalAliases(dumpDir); r == nil {
+		t.Errorf("Expected error for an alias outside the dump directory")
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A post crossposted to a community and to the personal journal is archived
+// in both, so exports of both journals contain it twice. After a dump of
+// several journals, and with the duplicates command, entries of the
+// configured journals are compared by the hash of their text with markup,
+// case and spacing normalized. Entries with the same text in different
+// journals posted within duplicateDateWindow are copies of one post. The
+// copy in the personal journal of the poster is the original, otherwise
+// the earliest one. The other copies are recorded in the crossposts table
+// of entries-index.linedb of their journals with the original entry, and
+// export with -collapse-duplicates skips them when the journal of the
+// original is exported too.
+
+// Maximum difference between the times of copies of one post
+const duplicateDateWindow = 48 * time.Hour
+
+// Shorter texts like "test" or a single link match unrelated entries
+const minDuplicateTextLength = 40
+
+type duplicateCandidate struct {
+	journal string
+
+	// Index of the journal in the config for a stable order
+	journalIndex int
+	entry        *archivedEntry
+	time         time.Time
+}
+
+// The entry is in the journal of its poster and not in a community
+func (c *duplicateCandidate) personal() bool {
+	return c.entry.poster == "" || c.entry.poster == c.journal
+}
+
+type sortDuplicateCandidates []*duplicateCandidate
+
+func (a sortDuplicateCandidates) Len() int { return len(a) }
+func (a sortDuplicateCandidates) Less(i, j int) bool {
+	if a[i].personal() != a[j].personal() {
+		return a[i].personal()
+	}
+	if !a[i].time.Equal(a[j].time) {
+		return a[i].time.Before(a[j].time)
+	}
+	if a[i].journalIndex != a[j].journalIndex {
+		return a[i].journalIndex < a[j].journalIndex
+	}
+	return a[i].entry.itemId < a[j].entry.itemId
+}
+func (a sortDuplicateCandidates) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+
+// Get the hash of the normalized entry text or an empty string when the
+// text is too short to compare
+func entryTextHash(entry *archivedEntry) string {
+	text := strings.ToLower(strings.Join(strings.Fields(htmlToText(entry.event)), " "))
+	if len(text) < minDuplicateTextLength {
+		return ""
+	}
+	sum := sha1.Sum([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// Find copies of entries among the configured journals. Return for each
+// journal the map from the itemid of a copy to its original.
+func findCrossposts(config *Config) (map[string]map[int64]entryRef, *Report) {
+	groups := make(map[string][]*duplicateCandidate)
+	for i, journal := range config.journals {
+		entries, r := readJournalEntries(config.journalDir(journal))
+		if r != nil {
+			return nil, r
+		}
+		for _, entry := range entries {
+			hash := entryTextHash(entry)
+			if hash == "" {
+				continue
+			}
+			t, err := time.Parse(ljEventTimeLayout, entry.eventTime)
+			if err != nil {
+				continue
+			}
+			groups[hash] = append(groups[hash], &duplicateCandidate{journal, i, entry, t})
+		}
+	}
+	crossposts := make(map[string]map[int64]entryRef)
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Sort(sortDuplicateCandidates(group))
+
+		// Each candidate becomes a copy of the first original in another
+		// journal within the window or an original itself
+		var originals []*duplicateCandidate
+		for _, c := range group {
+			var orig *duplicateCandidate
+			for _, o := range originals {
+				diff := c.time.Sub(o.time)
+				if diff < 0 {
+					diff = -diff
+				}
+				if o.journal != c.journal && diff <= duplicateDateWindow {
+					orig = o
+					break
+				}
+			}
+			if orig == nil {
+				originals = append(originals, c)
+				continue
+			}
+			if crossposts[c.journal] == nil {
+				crossposts[c.journal] = make(map[int64]entryRef)
+			}
+			crossposts[c.journal][c.entry.itemId] = entryRef{orig.journal, orig.entry.itemId}
+		}
+	}
+	return crossposts, nil
+}
+
+// Record the crossposts among the configured journals in their indexes.
+// Crossposts of journals outside the config, for example with -group, are
+// kept. Return the number of recorded copies.
+func updateCrossposts(config *Config) (int, *Report) {
+	crossposts, r := findCrossposts(config)
+	if r != nil {
+		return 0, r
+	}
+	configured := make(map[string]bool)
+	for _, journal := range config.journals {
+		configured[journal] = true
+	}
+	count := 0
+	for _, journal := range config.journals {
+		dir := config.journalDir(journal)
+		index, r := loadEntriesIndex(dir)
+		if r != nil {
+			return 0, r
+		}
+		found := crossposts[journal]
+		for itemId, orig := range index.crossposts {
+			if configured[orig.journal] && found[itemId] != orig {
+				delete(index.crossposts, itemId)
+				index.changed = true
+			}
+		}
+		for itemId, orig := range found {
+			if index.crossposts[itemId] != orig {
+				index.crossposts[itemId] = orig
+				index.changed = true
+			}
+		}
+		count += len(found)
+		if index.changed {
+			if r := writeEntriesIndex(dir, index); r != nil {
+				return 0, r
+			}
+		}
+	}
+	return count, nil
+}
+
+// Get the entries of the journal to skip in the export as copies of entries
+// of other exported journals
+func collapsedCrossposts(config *Config, dir string) (map[int64]bool, *Report) {
+	index, r := readEntriesIndex(dir)
+	if r != nil || index == nil {
+		return nil, r
+	}
+	collapsed := make(map[int64]bool)
+	for itemId, orig := range index.crossposts {
+		for _, journal := range config.journals {
+			if journal == orig.journal {
+				collapsed[itemId] = true
+				break
+			}
+		}
+	}
+	return collapsed, nil
+}
+
+func runDuplicates(config *Config) *Report {
+	if len(config.journals) < 2 {
+		return ReportMsg("finding duplicates requires several journals, add them with -journal or in the config")
+	}
+	count, r := updateCrossposts(config)
+	if r != nil {
+		return r
+	}
+	for _, journal := range config.journals {
+		index, r := readEntriesIndex(config.journalDir(journal))
+		if r != nil {
+			return r
+		}
+		if index == nil {
+			continue
+		}
+		itemIds := make([]int64, 0, len(index.crossposts))
+		for itemId := range index.crossposts {
+			itemIds = append(itemIds, itemId)
+		}
+		sort.Sort(sortIds(itemIds))
+		for _, itemId := range itemIds {
+			orig := index.crossposts[itemId]
+			row := index.rows[itemId]
+			subject := ""
+			if row != nil {
+				subject = fmt.Sprintf(" %s %s", row.date, row.subject)
+			}
+			fmt.Printf("%s/L-%d is a copy of %s/L-%d%s\n", journal, itemId, orig.journal, orig.itemId, subject)
+		}
+	}
+	log("Found %d crossposted entries", count)
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_updateCrossposts(t *testing.T) {
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	config := &Config{
+		dumpDir:        dumpDir,
+		journals:       []string{"travelers", "bob"},
+		journalAliases: make(map[string]string),
+	}
+	text := "We walked along the river &lt;b&gt;for hours&lt;/b&gt; and saw the old bridge."
+	files := map[string]map[string]string{
+		"bob": {
+			"L-1": `<event><itemid>1</itemid><eventtime>2010-05-01 10:00:00</eventtime><event>` + text + `</event></event>`,
+			"L-2": `<event><itemid>2</itemid><eventtime>2010-06-01 10:00:00</eventtime><event>test</event></event>`,
+		},
+		"travelers": {
+			// The same post in the community with different spacing and case
+			"L-7": `<event><itemid>7</itemid><eventtime>2010-05-01 10:05:00</eventtime><poster>bob</poster><event>We walked along the river  for hours and saw the OLD bridge.</event></event>`,
+			"L-8": `<event><itemid>8</itemid><eventtime>2010-06-01 10:00:00</eventtime><poster>bob</poster><event>test</event></event>`,
+
+			// A repost a year later is a separate entry
+			"L-9": `<event><itemid>9</itemid><eventtime>2011-05-01 10:00:00</eventtime><poster>bob</poster><event>` + text + `</event></event>`,
+		},
+	}
+	for journal, entries := range files {
+		dir := config.journalDir(journal)
+		if err := os.Mkdir(dir, 0777); err != nil {
+			t.Fatal(err)
+		}
+		for name, data := range entries {
+			if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	count, r := updateCrossposts(config)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 crosspost, got %d", count)
+	}
+	index, r := readEntriesIndex(config.journalDir("travelers"))
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(index.crossposts) != 1 || index.crossposts[7] != (entryRef{"bob", 1}) {
+		t.Errorf("Unexpected crossposts %v", index.crossposts)
+	}
+	if len(index.rows) != 3 {
+		t.Errorf("Expected 3 index rows, got %d", len(index.rows))
+	}
+
+	collapsed, r := collapsedCrossposts(config, config.journalDir("travelers"))
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if !collapsed[7] || len(collapsed) != 1 {
+		t.Errorf("Unexpected collapsed entries %v", collapsed)
+	}
+	config.journals = []string{"travelers"}
+	if collapsed, _ := collapsedCrossposts(config, config.journalDir("travelers")); len(collapsed) != 0 {
+		t.Errorf("Entry was collapsed without exporting its original")
+	}
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"linedb"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// entries-index.linedb in the journal directory lists every archived entry
+// with the metadata needed for listings so other tools do not have to parse
+// all L-* files. The dump updates it as it stores entries and comments. An
+// archive from before the index gets it built from the archived files on
+// the next dump. The contentFlags table lists entries with the adult
+// content or comment screening props, see content_flags.go. An index
+// written before the table existed gets it from the entry files when
+// loaded. The links table lists the ditemid and the URL of each entry,
+// see permalink.go, and is filled the same way for older indexes.
+
+const entriesIndexFileName = "entries-index.linedb"
+
+type entryIndexRow struct {
+	itemId   int64
+	date     string
+	subject  string
+	security string
+
+	// Tags separated by commas
+	tags     string
+	comments int
+
+	// Path of the entry file relative to the journal directory with /
+	// as the separator, empty when only comments are archived
+	file string
+
+	// The adult_content and opt_screening props
+	adultContent string
+	screening    string
+
+	// The number and the URL of the entry page, see permalink.go
+	ditemid   int64
+	permalink string
+}
+
+// Entry of a journal
+type entryRef struct {
+	journal string
+	itemId  int64
+}
+
+type entriesIndex struct {
+	rows map[int64]*entryIndexRow
+
+	// Entries that are copies of entries of other journals, see
+	// duplicates.go
+	crossposts map[int64]entryRef
+	changed    bool
+
+	// False for an index read from a file without the contentFlags or
+	// links table
+	hasContentFlags bool
+	hasLinks        bool
+}
+
+func newEntriesIndex() *entriesIndex {
+	return &entriesIndex{rows: make(map[int64]*entryIndexRow), crossposts: make(map[int64]entryRef), hasContentFlags: true, hasLinks: true}
+}
+
+func readEntriesIndex(dir string) (*entriesIndex, *Report) {
+	path := filepath.Join(dir, entriesIndexFileName)
+	data, err := archiveStore.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, WrapErr(err, "")
+	}
+	index := newEntriesIndex()
+	index.hasContentFlags = false
+	index.hasLinks = false
+	d := newLinedbDecoder(data)
+	for d.NextItem() {
+		if d.ItemKind != linedb.TableItem {
+			continue
+		}
+		switch d.ItemName {
+		case "contentFlags":
+			index.hasContentFlags = true
+		case "links":
+			index.hasLinks = true
+		}
+		for d.NextRow() {
+			switch d.ItemName {
+			case "entries":
+				row := &entryIndexRow{
+					d.GetInt64(), d.GetString(), d.GetString(), d.GetString(), d.GetString(), d.GetInt(), d.GetString(), "", "", 0, "",
+				}
+				index.rows[row.itemId] = row
+			case "crossposts":
+				itemId := d.GetInt64()
+				index.crossposts[itemId] = entryRef{d.GetString(), d.GetInt64()}
+			case "contentFlags":
+				itemId, adultContent, screening := d.GetInt64(), d.GetString(), d.GetString()
+				if row := index.rows[itemId]; row != nil {
+					row.adultContent, row.screening = adultContent, screening
+				}
+			case "links":
+				itemId, ditemid, permalink := d.GetInt64(), d.GetInt64(), d.GetString()
+				if row := index.rows[itemId]; row != nil {
+					row.ditemid, row.permalink = ditemid, permalink
+				}
+			}
+		}
+	}
+	if err := d.GetError(); err != nil {
+		return nil, WrapErr(err, "failed to parse %s", path)
+	}
+	return index, nil
+}
+
+func encodeEntriesIndex(index *entriesIndex) []byte {
+	itemIds := make([]int64, 0, len(index.rows))
+	for itemId := range index.rows {
+		itemIds = append(itemIds, itemId)
+	}
+	sort.Sort(sortIds(itemIds))
+	e := linedb.NewByteEncoder()
+	e.Comment("itemid date subject security tags comments file")
+	e.Table("entries")
+	for _, itemId := range itemIds {
+		row := index.rows[itemId]
+		e.AddInt64(row.itemId).AddString(row.date).AddString(row.subject).AddString(row.security)
+		e.AddString(row.tags).AddInt(row.comments).AddString(row.file).EndRow()
+	}
+	e.EndTable()
+	e.EmptyLine()
+	e.Comment("itemid adult-content screening")
+	e.Table("contentFlags")
+	for _, itemId := range itemIds {
+		if row := index.rows[itemId]; row.adultContent != "" || row.screening != "" {
+			e.AddInt64(itemId).AddString(row.adultContent).AddString(row.screening).EndRow()
+		}
+	}
+	e.EndTable()
+	e.EmptyLine()
+	e.Comment("itemid ditemid permalink")
+	e.Table("links")
+	for _, itemId := range itemIds {
+		if row := index.rows[itemId]; row.ditemid != 0 || row.permalink != "" {
+			e.AddInt64(itemId).AddInt64(row.ditemid).AddString(row.permalink).EndRow()
+		}
+	}
+	e.EndTable()
+	if len(index.crossposts) != 0 {
+		itemIds = itemIds[:0]
+		for itemId := range index.crossposts {
+			itemIds = append(itemIds, itemId)
+		}
+		sort.Sort(sortIds(itemIds))
+		e.EmptyLine()
+		e.Comment("itemid journal origitemid")
+		e.Table("crossposts")
+		for _, itemId := range itemIds {
+			orig := index.crossposts[itemId]
+			e.AddInt64(itemId).AddString(orig.journal).AddInt64(orig.itemId).EndRow()
+		}
+		e.EndTable()
+	}
+	return e.GetBytes()
+}
+
+func writeEntriesIndex(dir string, index *entriesIndex) *Report {
+	if err := writeFileTempRename(filepath.Join(dir, entriesIndexFileName), encodeEntriesIndex(index)); err != nil {
+		return WrapErr(err, "")
+	}
+	index.changed = false
+	return nil
+}
+
+// Set the row of the entry stored at the path relative to the journal
+// directory
+func (index *entriesIndex) setEntry(entry *archivedEntry, file string) {
+	row := index.rows[entry.itemId]
+	if row == nil {
+		row = &entryIndexRow{itemId: entry.itemId}
+		index.rows[entry.itemId] = row
+	}
+	row.date = entry.eventTime
+	row.subject = entry.subject
+	row.security = entry.securityLevel().String()
+	row.tags = strings.Join(entry.tags(), ",")
+	row.file = file
+	row.adultContent = entry.adultContent
+	row.screening = entry.screening
+	row.ditemid = entry.ditemid
+	row.permalink = entry.permalink
+	index.changed = true
+}
+
+// Record the number of archived comments. Comments can be fetched before
+// the entry when the time slice stops the entry fetch, so this adds a row
+// that the entry fills later.
+func (index *entriesIndex) setCommentCount(itemId int64, count int) {
+	row := index.rows[itemId]
+	if row == nil {
+		row = &entryIndexRow{itemId: itemId}
+		index.rows[itemId] = row
+	}
+	if row.comments != count {
+		row.comments = count
+		index.changed = true
+	}
+}
+
+// Read the index of the journal or build it from the archived files if it
+// does not exist yet.
+func loadEntriesIndex(dir string) (*entriesIndex, *Report) {
+	index, r := readEntriesIndex(dir)
+	if r != nil || index != nil && index.hasContentFlags && index.hasLinks {
+		return index, r
+	}
+	if index != nil {
+		for _, row := range index.rows {
+			if row.file == "" {
+				continue
+			}
+			entry, err := row.readEntry(dir)
+			if err != nil {
+				log("WARNING: cannot read %s to index its flags and link - %s", row.file, err.Error())
+				continue
+			}
+			row.adultContent, row.screening = entry.adultContent, entry.screening
+			row.ditemid, row.permalink = entry.ditemid, entry.permalink
+		}
+		index.hasContentFlags = true
+		index.hasLinks = true
+		index.changed = true
+		return index, nil
+	}
+	entries, r := readJournalEntries(dir)
+	if r != nil {
+		return nil, r
+	}
+	index = newEntriesIndex()
+	index.changed = true
+	for _, entry := range entries {
+		index.setEntry(entry, entryRelPath(dir, entry))
+		comments, r := readEntryComments(entry.dir, entry.itemId)
+		if r != nil {
+			return nil, r
+		}
+		index.rows[entry.itemId].comments = len(comments)
+	}
+	if len(entries) != 0 {
+		log("Built %s for %d archived entries", entriesIndexFileName, len(entries))
+	}
+	return index, nil
+}
+
+type sortIndexRowsByDate []*entryIndexRow
+
+func (a sortIndexRowsByDate) Len() int           { return len(a) }
+func (a sortIndexRowsByDate) Less(i, j int) bool { return a[i].date < a[j].date }
+func (a sortIndexRowsByDate) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// Get the archived entries that match in the order of their dates. Rows
+// with only comments archived are skipped.
+func (index *entriesIndex) selectEntries(match func(row *entryIndexRow) bool) []*entryIndexRow {
+	var rows []*entryIndexRow
+	for _, row := range index.rows {
+		if row.file != "" && match(row) {
+			rows = append(rows, row)
+		}
+	}
+	sort.Sort(sortIndexRowsByDate(rows))
+	return rows
+}
+
+// Get the month and the day of the entry date or zeros if the date is
+// malformed
+func (row *entryIndexRow) monthDay() (int, int) {
+	if len(row.date) < 10 || row.date[7] != '-' {
+		return 0, 0
+	}
+	_, month := eventYearMonth(row.date)
+	day, err := strconv.Atoi(row.date[8:10])
+	if err != nil {
+		return month, 0
+	}
+	return month, day
+}
+
+// Read the entry of the row from the journal directory
+func (row *entryIndexRow) readEntry(dir string) (*archivedEntry, error) {
+	return readArchivedEntry(filepath.Join(dir, filepath.FromSlash(row.file)))
+}
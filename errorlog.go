@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"linedb"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Errors, warnings and unexpected HTTP statuses are recorded in this file
+// of the dump directory so the user can later package them with the
+// export-errors command for a bug report. Nothing is sent anywhere.
+const errorLogFileName = "error-log.linedb"
+const maxErrorLogRecords = 500
+
+type errorLogRecord struct {
+	time    string
+	kind    string
+	message string
+}
+
+// Records of the current run and the strings that must never appear in
+// the log. The run keeps it in Config. A nil log records nothing, which
+// tests with a bare Config rely on.
+type errorLog struct {
+	records []errorLogRecord
+	secrets []string
+}
+
+var secretParamPattern = regexp.MustCompile(
+	`(?i)(ljsession|auth_response|auth_challenge|password|authas|usejournal|user)=[^&\s"]*`,
+)
+
+func (l *errorLog) addSecret(secret string) {
+	// Short strings would damage too much unrelated text
+	if l != nil && len(secret) >= 3 {
+		l.secrets = append(l.secrets, secret)
+	}
+}
+
+func (l *errorLog) redact(s string) string {
+	s = secretParamPattern.ReplaceAllString(s, "$1=<redacted>")
+	if l != nil {
+		for _, secret := range l.secrets {
+			s = strings.Replace(s, secret, "<redacted>", -1)
+		}
+	}
+	return s
+}
+
+func (l *errorLog) record(kind, format string, a ...interface{}) {
+	if l == nil {
+		return
+	}
+	l.records = append(l.records, errorLogRecord{
+		time:    time.Now().UTC().Format(time.RFC3339),
+		kind:    kind,
+		message: l.redact(strings.TrimSpace(fmt.Sprintf(format, a...))),
+	})
+}
+
+func (l *errorLog) count() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.records)
+}
+
+// Print the warning and record it in the error log
+func (config *Config) warn(format string, a ...interface{}) {
+	log("WARNING: "+format, a...)
+	config.errorLog.record("warning", format, a...)
+}
+
+// Kind for grouping records in summaries. HTTP records are grouped by the
+// status code.
+func (record *errorLogRecord) summaryKind() string {
+	if record.kind == "http" {
+		return record.kind + " " + strings.SplitN(record.message, " ", 2)[0]
+	}
+	return record.kind
+}
+
+func readErrorLog(config *Config) ([]errorLogRecord, *Report) {
+	dbpath := filepath.Join(config.dumpDir, errorLogFileName)
+	dbdata, err := archiveStore.ReadFile(dbpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, WrapErr(err, "")
+	}
+	var records []errorLogRecord
+	d := newLinedbDecoder(dbdata)
+	for d.NextItem() {
+		if d.ItemKind == linedb.TableItem {
+			for d.NextRow() {
+				switch d.ItemName {
+				case "records":
+					records = append(records, errorLogRecord{
+						time:    d.GetString(),
+						kind:    d.GetString(),
+						message: d.GetString(),
+					})
+				}
+			}
+		}
+	}
+	if err := d.GetError(); err != nil {
+		return nil, WrapErr(err, "error while parsing error log %s as linedb", dbpath)
+	}
+	return records, nil
+}
+
+// Append the records of the current run to the error log keeping only the
+// most recent ones.
+func flushErrorLog(config *Config) *Report {
+	errorLog := config.errorLog
+	if errorLog == nil || len(errorLog.records) == 0 {
+		return nil
+	}
+	records, r := readErrorLog(config)
+	if r != nil {
+		return r
+	}
+	records = append(records, errorLog.records...)
+	errorLog.records = nil
+	if len(records) > maxErrorLogRecords {
+		records = records[len(records)-maxErrorLogRecords:]
+	}
+
+	e := linedb.NewByteEncoder()
+	e.Comment("time kind message")
+	e.Table("records")
+	for _, record := range records {
+		e.AddString(record.time).AddString(record.kind).AddString(record.message).EndRow()
+	}
+	e.EndTable()
+	dbpath := filepath.Join(config.dumpDir, errorLogFileName)
+	if err := writeFileTempRename(dbpath, e.GetBytes()); err != nil {
+		return WrapErr(err, "failed to write error log %s", dbpath)
+	}
+	return nil
+}
+
+// Write a plain text file with the redacted error log suitable for
+// attaching to a bug report.
+func runExportErrors(config *Config) *Report {
+	records, r := readErrorLog(config)
+	if r != nil {
+		return r
+	}
+	if len(records) == 0 {
+		log("The error log %s is empty, nothing to export", errorLogFileName)
+		return nil
+	}
+
+	kindCounts := make(map[string]int)
+	for i := range records {
+		kindCounts[records[i].summaryKind()]++
+	}
+	kinds := make([]string, 0, len(kindCounts))
+	for kind := range kindCounts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "ljdumpgo error report created %s\n", time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(&buf, "Go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&buf, "Server: %s\n", config.server)
+	fmt.Fprintf(&buf, "Records: %d from %s to %s\n\n", len(records), records[0].time, records[len(records)-1].time)
+	buf.WriteString("Summary:\n")
+	for _, kind := range kinds {
+		fmt.Fprintf(&buf, "  %-20s %d\n", kind, kindCounts[kind])
+	}
+	buf.WriteString("\nRecords:\n")
+	for _, record := range records {
+		// Redact again in case the configuration changed since the record
+		// was written
+		message := strings.Replace(config.errorLog.redact(record.message), "\n", "\n    ", -1)
+		fmt.Fprintf(&buf, "%s %s: %s\n", record.time, record.kind, message)
+	}
+
+	outPath := filepath.Join(config.dumpDir, fmt.Sprintf("ljdump-errors-%s.txt", time.Now().Format("20060102")))
+	if err := writeFileTempRename(outPath, buf.Bytes()); err != nil {
+		return WrapErr(err, "")
+	}
+	log("Wrote %d redacted records to %s. Review the file before attaching it to a bug report", len(records), outPath)
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The exit status tells wrapper scripts and systemd units what kind of
+// failure stopped the run:
+//
+//	0    success
+//	1    other errors including invalid options and config
+//	3    authentication failed, the password, token or cookie needs
+//	     attention
+//	4    network failure, the server could not be reached or the
+//	     connection broke
+//	5    the server answered with an error
+//	6    reading or writing the local files failed
+//	7    partial success, the run stopped at -max-runtime or the request
+//	     budget and the next run continues from the saved progress
+//	130  interrupted, see shutdown.go
+//
+// The category name is printed to stderr as the last line after the error
+// and shown in the run report.
+
+type errorCategory string
+
+const (
+	errorCategoryOther   errorCategory = "error"
+	errorCategoryAuth    errorCategory = "auth"
+	errorCategoryNetwork errorCategory = "network"
+	errorCategoryServer  errorCategory = "server"
+	errorCategoryDisk    errorCategory = "disk"
+	errorCategoryPartial errorCategory = "partial"
+
+	errorCategoryInterrupted errorCategory = "interrupted"
+)
+
+var errorCategoryExitCodes = map[errorCategory]int{
+	errorCategoryOther:   1,
+	errorCategoryAuth:    3,
+	errorCategoryNetwork: 4,
+	errorCategoryServer:  5,
+	errorCategoryDisk:    6,
+	errorCategoryPartial: 7,
+
+	errorCategoryInterrupted: interruptedExitCode,
+}
+
+// Mark the report with the category when the error kind is known where it
+// happens
+func (r *Report) withCategory(category errorCategory) *Report {
+	r.category = category
+	return r
+}
+
+// Get the category of the report. Explicit categories take precedence,
+// then the type of the wrapped errors and at last the network and HTTP
+// failures recorded in the error log during the run, which may be nil.
+func (r *Report) errorCategory(errorLog *errorLog) errorCategory {
+	if shutdownRequested() {
+		return errorCategoryInterrupted
+	}
+	if category := r.explicitCategory(); category != "" {
+		return category
+	}
+	if category := r.errCategory(); category != "" {
+		return category
+	}
+	if errorLog == nil {
+		return errorCategoryOther
+	}
+	for i := len(errorLog.records) - 1; i >= 0; i-- {
+		record := &errorLog.records[i]
+		switch record.kind {
+		case "network":
+			return errorCategoryNetwork
+		case "http":
+			if status, err := strconv.Atoi(strings.SplitN(record.message, " ", 2)[0]); err == nil && status >= 500 {
+				return errorCategoryServer
+			}
+		}
+	}
+	return errorCategoryOther
+}
+
+func (r *Report) explicitCategory() errorCategory {
+	if r.category != "" {
+		return r.category
+	}
+	for _, r2 := range r.combined {
+		if category := r2.explicitCategory(); category != "" {
+			return category
+		}
+	}
+	return ""
+}
+
+func (r *Report) errCategory() errorCategory {
+	if r.err != nil {
+		if category := classifyError(r.err); category != "" {
+			return category
+		}
+	}
+	for _, r2 := range r.combined {
+		if category := r2.errCategory(); category != "" {
+			return category
+		}
+	}
+	return ""
+}
+
+func classifyError(err error) errorCategory {
+	var urlErr *url.Error
+	var netErr net.Error
+	var pathErr *os.PathError
+	var linkErr *os.LinkError
+	var syscallErr *os.SyscallError
+	// os.PathError has the Timeout method of net.Error, so check the file
+	// errors first
+	switch {
+	case errors.As(err, &urlErr):
+		return errorCategoryNetwork
+	case errors.As(err, &pathErr) || errors.As(err, &linkErr) || errors.As(err, &syscallErr):
+		return errorCategoryDisk
+	case errors.As(err, &netErr):
+		return errorCategoryNetwork
+	}
+	return ""
+}
+
+func (r *Report) exitCode() int {
+	return errorCategoryExitCodes[r.errorCategory(nil)]
+}
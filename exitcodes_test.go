@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func Test_reportExitCode(t *testing.T) {
+	_, statErr := os.Stat("/nonexistent/ljdump")
+	netErr := &url.Error{Op: "Get", URL: "https://example.com/", Err: errors.New("connection refused")}
+	cases := []struct {
+		r        *Report
+		category errorCategory
+		code     int
+	}{
+		{ReportMsg("bad option"), errorCategoryOther, 1},
+		{ReportMsg("failed to login").withCategory(errorCategoryAuth), errorCategoryAuth, 3},
+		{WrapErr(netErr, ""), errorCategoryNetwork, 4},
+		{WrapErr(statErr, "failed to read"), errorCategoryDisk, 6},
+		{CombineReports(WrapErr(statErr, ""), ReportMsg("stopped").withCategory(errorCategoryPartial)), errorCategoryPartial, 7},
+	}
+	for i, c := range cases {
+		if category := c.r.errorCategory(nil); category != c.category {
+			t.Errorf("%d: expected %s, got %s", i, c.category, category)
+		}
+		if code := c.r.exitCode(); code != c.code {
+			t.Errorf("%d: expected exit code %d, got %d", i, c.code, code)
+		}
+	}
+
+	// Unexpected responses fail later than the request
+	runLog := &errorLog{}
+	runLog.record("http", "404 GET /userpic?")
+	runLog.record("http", "503 POST /interface/xmlrpc?")
+	if code := errorCategoryExitCodes[ReportMsg("unexpected response").errorCategory(runLog)]; code != 5 {
+		t.Errorf("Expected the server error exit code, got %d", code)
+	}
+}
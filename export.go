@@ -0,0 +1,311 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Visibility of an entry ordered from the most to the least visible
+type securityLevel int
+
+const (
+	securityPublic securityLevel = iota
+	securityFriends
+	securityCustom
+	securityPrivate
+)
+
+var securityLevelNames = [...]string{"public", "friends", "custom", "private"}
+
+func parseSecurityLevel(s string) (securityLevel, error) {
+	for i, name := range securityLevelNames {
+		if s == name {
+			return securityLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown security level '%s', expected one of %s",
+		s, strings.Join(securityLevelNames[:], ", "))
+}
+
+func (level securityLevel) String() string {
+	return securityLevelNames[level]
+}
+
+// Get the human-readable label for exports
+func (level securityLevel) label() string {
+	switch level {
+	case securityFriends:
+		return "Friends only"
+	case securityCustom:
+		return "Custom friend groups"
+	case securityPrivate:
+		return "Private"
+	}
+	return "Public"
+}
+
+// LJ marks friends-only entries as usemask with the first bit of allowmask,
+// other bits select custom friend groups.
+func (entry *archivedEntry) securityLevel() securityLevel {
+	switch entry.security {
+	case "private":
+		return securityPrivate
+	case "usemask":
+		if entry.allowMask == 1 {
+			return securityFriends
+		}
+		return securityCustom
+	}
+	return securityPublic
+}
+
+type exportFormat struct {
+	name    string
+	summary string
+	export  func(ex *exportJournal) *Report
+}
+
+var exportFormats = []*exportFormat{
+	{"html", "static HTML pages", exportHtml},
+	{"markdown", "Markdown files with front matter", exportMarkdown},
+	{"epub", "EPUB 3 book", exportEpub},
+	{"comments-jsonl", "all comments as JSON Lines", exportCommentsJsonl},
+
+	// Other formats are added from their files with registerExporter or
+	// registerExportFunc, see exporter.go
+}
+
+func exportFormatNames() string {
+	names := make([]string, len(exportFormats))
+	for i, format := range exportFormats {
+		names[i] = format.name
+	}
+	return strings.Join(names, ", ")
+}
+
+func findExportFormat(name string) *exportFormat {
+	for _, format := range exportFormats {
+		if format.name == name {
+			return format
+		}
+	}
+	return nil
+}
+
+// Journal data passed to an export format
+type exportJournal struct {
+	config *Config
+	name   string
+
+	// The archive directory of the journal
+	dir string
+
+	// The directory where the format should write its files
+	outDir string
+
+	// Entries allowed by the security filter in chronological order
+	entries []*archivedEntry
+
+	// Archived friends of the account or nil
+	friends *friendsData
+
+	// Index of archived images loaded on demand and files from it that
+	// the export refers to
+	media     map[string]*mediaItem
+	usedMedia map[string]bool
+
+	// Entries with files older than this modification time are unchanged
+	// since the previous export or 0 to write all, see export_state.go
+	since     int64
+	unchanged int
+
+	// Replaces commenter names with -anonymize or nil, see anonymize.go
+	anonymizer *commentAnonymizer
+}
+
+// Get the name of the comment author annotated with the relationship to
+// the account owner at the time of the last dump.
+func (ex *exportJournal) commenter(c *CommentRecord) string {
+	if ex.anonymizer != nil {
+		// Relationships would tell who the pseudonyms are
+		return commentAuthor(c)
+	}
+	return commenterLabel(ex.config, ex.friends, c)
+}
+
+func commenterLabel(config *Config, friends *friendsData, c *CommentRecord) string {
+	if c.User == "" || friends == nil {
+		return commentAuthor(c)
+	}
+	return commentAuthor(c) + " (" + friends.relationship(config, c.User) + ")"
+}
+
+func (ex *exportJournal) comments(entry *archivedEntry) ([]CommentRecord, *Report) {
+	comments, r := readEntryComments(entry.dir, entry.itemId)
+	if r != nil {
+		return nil, r
+	}
+	if ex.config.exportGroup != nil || ex.config.maxSecurity != securityPrivate {
+		// An export for others shows only what they can read on the
+		// server. Only the journal owner can read screened comments and
+		// deleted comments are gone even if the archive keeps their text.
+		visible := comments[:0]
+		for _, c := range comments {
+			if !isScreenedComment(&c) && c.State != "D" && c.Deleted == "" {
+				visible = append(visible, c)
+			}
+		}
+		comments = visible
+	}
+	if ex.anonymizer == nil {
+		return comments, nil
+	}
+	return ex.anonymizer.comments(comments), nil
+}
+
+// Get the path of the archived copy of the image relative to the journal
+// directory or the original URL if the image was not archived.
+func (ex *exportJournal) imageSrc(url string) (string, *Report) {
+	if ex.media == nil {
+		media, r := readMediaIndex(ex.dir)
+		if r != nil {
+			return "", r
+		}
+		ex.media = media
+		ex.usedMedia = make(map[string]bool)
+	}
+	if item := ex.media[url]; item != nil && item.file != "" {
+		ex.usedMedia[item.file] = true
+		return mediaDirName + "/" + item.file, nil
+	}
+	return url, nil
+}
+
+func (ex *exportJournal) mkdirOut(subdir string) (string, *Report) {
+	dir := filepath.Join(ex.outDir, subdir)
+	if err := mkdirArchive(dir); err != nil {
+		return "", WrapErr(err, "failed to create export directory %s", dir)
+	}
+	return dir, nil
+}
+
+var htmlBreakPattern = regexp.MustCompile(`(?i)<\s*(br|/p|p|/div|/li|/h[1-6])\b[^>]*>`)
+var htmlTagPattern = regexp.MustCompile(`<[^>]*>`)
+var manyNewLinesPattern = regexp.MustCompile(`\n{3,}`)
+
+// Convert LJ entry HTML into plain text with paragraphs separated by empty
+// lines.
+func htmlToText(s string) string {
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	s = htmlBreakPattern.ReplaceAllString(s, "\n")
+	s = htmlTagPattern.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+	s = manyNewLinesPattern.ReplaceAllString(s, "\n\n")
+	return strings.TrimSpace(s)
+}
+
+func runExport(config *Config) *Report {
+	if config.exportPerGroup {
+		return runGroupExports(config)
+	}
+	format := findExportFormat(config.exportFormat)
+	if format == nil {
+		return ReportMsg("unknown export format %s, supported formats are %s", config.exportFormat, exportFormatNames())
+	}
+	friends, r := readFriendsData(config)
+	if r != nil {
+		return r
+	}
+	var anonymizeKey []byte
+	if config.anonymizeComments {
+		if anonymizeKey, r = readAnonymizeKey(config); r != nil {
+			return r
+		}
+	}
+	for _, journal := range config.journals {
+		ex := &exportJournal{
+			config:  config,
+			name:    journal,
+			dir:     config.journalDir(journal),
+			outDir:  filepath.Join(config.exportDir, format.name, portableFileName(journal)),
+			friends: friends,
+		}
+		if anonymizeKey != nil {
+			ex.anonymizer = newCommentAnonymizer(config, journal, anonymizeKey)
+		}
+		modTimes, r := archivedModTimes(ex.dir)
+		if r != nil {
+			return r
+		}
+		entries, r := readJournalEntries(ex.dir)
+		if r != nil {
+			return r
+		}
+		var collapsed map[int64]bool
+		if config.collapseDuplicates {
+			if collapsed, r = collapsedCrossposts(config, ex.dir); r != nil {
+				return r
+			}
+		}
+		skipped := 0
+		duplicates := 0
+		for _, entry := range entries {
+			if config.exportGroup != nil && !entry.visibleToGroup(config.exportGroup.id) ||
+				entry.securityLevel() > config.maxSecurity {
+				skipped++
+				continue
+			}
+			if collapsed[entry.itemId] {
+				duplicates++
+				continue
+			}
+			ex.entries = append(ex.entries, entry)
+		}
+		if duplicates != 0 {
+			log("Skipping %d entries of %s crossposted from other exported journals", duplicates, journal)
+		}
+		config.normalizeEntryTimes(ex.entries)
+		sort.Sort(sortEntriesByTime(ex.entries))
+		if config.exportGroup != nil {
+			log("Exporting %d entries of %s as %s, %d entries not visible to friend group %s or above %s security skipped",
+				len(ex.entries), journal, format.name, skipped, config.exportGroup.name, config.maxSecurity)
+		} else {
+			log("Exporting %d entries of %s as %s, %d entries above %s security skipped",
+				len(ex.entries), journal, format.name, skipped, config.maxSecurity)
+		}
+		if err := mkdirArchive(ex.outDir); err != nil {
+			return WrapErr(err, "failed to create export directory %s", ex.outDir)
+		}
+		state, r := readExportState(ex.outDir)
+		if r != nil {
+			return r
+		}
+		options := exportOptionsKey(config)
+		if state != nil && state.options == options && !config.fullExport {
+			ex.since = state.highWater
+		}
+		if r := format.export(ex); r != nil {
+			return r
+		}
+		if ex.unchanged != 0 {
+			log("Kept %d unchanged entries from the previous export", ex.unchanged)
+		}
+		newState := &exportState{options: options}
+		if highWater, ok, r := ex.exportedHighWater(modTimes); r != nil {
+			return r
+		} else if ok {
+			newState.highWater = highWater
+		} else {
+			log("Files of %s changed during the export, the next export checks them again", journal)
+			newState.highWater = ex.since
+		}
+		if r := writeExportState(ex.outDir, newState); r != nil {
+			return r
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"path/filepath"
+	"time"
+)
+
+// Blogger imports an Atom feed in the format of its own export. Each post
+// and comment is an entry with a kind category and comments refer to their
+// post with thr:in-reply-to. Blogger has no friends-only posts, so
+// non-public entries are imported as drafts. Blogger comments are flat, so
+// replies lose their nesting. Deleted and screened comments are skipped.
+
+const bloggerKindScheme = "http://schemas.google.com/g/2005#kind"
+const bloggerKindPost = "http://schemas.google.com/blogger/2008/kind#post"
+const bloggerKindComment = "http://schemas.google.com/blogger/2008/kind#comment"
+const bloggerLabelScheme = "http://www.blogger.com/atom/ns#"
+
+// Format the archived entry or comment time for the feed. Times without the
+// UTC offset are taken as UTC.
+func bloggerTime(s string) string {
+	for _, l := range archiveTimeLayouts {
+		if t, err := time.Parse(l.layout, s); err == nil {
+			return t.UTC().Format(time.RFC3339)
+		}
+	}
+	return ""
+}
+
+func bloggerEntryTime(entry *archivedEntry) string {
+	if t := entry.absoluteTime(); !t.IsZero() {
+		return t.UTC().Format(time.RFC3339)
+	}
+	return bloggerTime(entry.eventTime)
+}
+
+func bloggerWriteEntryStart(buf *bytes.Buffer, id string, published string, kind string) {
+	buf.WriteString("<entry>\n")
+	fmt.Fprintf(buf, "<id>%s</id>\n", html.EscapeString(id))
+	if published != "" {
+		fmt.Fprintf(buf, "<published>%s</published>\n<updated>%s</updated>\n", published, published)
+	}
+	fmt.Fprintf(buf, "<category scheme=\"%s\" term=\"%s\"/>\n", bloggerKindScheme, kind)
+}
+
+func bloggerWriteText(buf *bytes.Buffer, element string, kind string, text string) {
+	fmt.Fprintf(buf, "<%s type=\"%s\">%s</%s>\n", element, kind, html.EscapeString(text), element)
+}
+
+func bloggerWriteAuthor(buf *bytes.Buffer, name string) {
+	fmt.Fprintf(buf, "<author><name>%s</name></author>\n", html.EscapeString(name))
+}
+
+func init() {
+	registerExporter("blogger", "Blogger import Atom feed", func() Exporter { return &bloggerExporter{} })
+}
+
+type bloggerExporter struct {
+	ex     *exportJournal
+	blogId string
+	buf    bytes.Buffer
+
+	// The id of the current post that its comments refer to
+	postId string
+
+	postCount    int
+	commentCount int
+}
+
+func (b *bloggerExporter) Begin(ex *exportJournal) *Report {
+	b.ex = ex
+	b.blogId = "tag:blogger.com,1999:blog-" + ex.name
+	b.buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom" xmlns:app="http://www.w3.org/2007/app" xmlns:thr="http://purl.org/syndication/thread/1.0">
+`)
+	fmt.Fprintf(&b.buf, "<id>%s.archive</id>\n", html.EscapeString(b.blogId))
+	fmt.Fprintf(&b.buf, "<updated>%s</updated>\n", time.Now().UTC().Format(time.RFC3339))
+	bloggerWriteText(&b.buf, "title", "text", ex.name)
+	b.buf.WriteString("<generator version=\"7.00\" uri=\"https://www.blogger.com\">Blogger</generator>\n")
+	return nil
+}
+
+func (b *bloggerExporter) Entry(entry *archivedEntry) *Report {
+	b.postId = fmt.Sprintf("%s.post-%d", b.blogId, entry.itemId)
+	bloggerWriteEntryStart(&b.buf, b.postId, bloggerEntryTime(entry), bloggerKindPost)
+	for _, tag := range entry.tags() {
+		fmt.Fprintf(&b.buf, "<category scheme=\"%s\" term=\"%s\"/>\n", bloggerLabelScheme, html.EscapeString(tag))
+	}
+	bloggerWriteText(&b.buf, "title", "text", entry.subject)
+	bloggerWriteText(&b.buf, "content", "html", entryHtml(b.ex.config, entry))
+	poster := entry.poster
+	if poster == "" {
+		poster = b.ex.name
+	}
+	bloggerWriteAuthor(&b.buf, poster)
+	if entry.securityLevel() != securityPublic {
+		b.buf.WriteString("<app:control><app:draft>yes</app:draft></app:control>\n")
+	}
+	b.buf.WriteString("</entry>\n")
+	b.postCount++
+	return nil
+}
+
+// Blogger imports images only from their URLs, so the posts keep them
+func (b *bloggerExporter) Media(entry *archivedEntry, url string, path string) *Report {
+	return nil
+}
+
+func (b *bloggerExporter) Comment(entry *archivedEntry, c *CommentRecord) *Report {
+	if c.State == "D" || c.State == "S" {
+		return nil
+	}
+	commentId := fmt.Sprintf("%s.comment-%d", b.postId, c.Id)
+	bloggerWriteEntryStart(&b.buf, commentId, bloggerTime(c.Date), bloggerKindComment)
+	bloggerWriteText(&b.buf, "title", "text", c.Subject)
+	bloggerWriteText(&b.buf, "content", "html", commentHtml(b.ex.config, c))
+	commenter := commentAuthor(c)
+	if c.User == "" {
+		commenter = "Anonymous"
+	}
+	bloggerWriteAuthor(&b.buf, commenter)
+	fmt.Fprintf(&b.buf, "<thr:in-reply-to ref=\"%s\" type=\"text/html\"/>\n", html.EscapeString(b.postId))
+	b.buf.WriteString("</entry>\n")
+	b.commentCount++
+	return nil
+}
+
+func (b *bloggerExporter) End() *Report {
+	b.buf.WriteString("</feed>\n")
+	path := filepath.Join(b.ex.outDir, "blogger.xml")
+	if err := writeFileTempRename(path, b.buf.Bytes()); err != nil {
+		return WrapErr(err, "")
+	}
+	log("Wrote %d posts and %d comments to %s", b.postCount, b.commentCount, path)
+	return nil
+}
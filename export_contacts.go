@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The contacts format writes the people around the journal as contacts.csv
+// and contacts.vcf with a vCard 4.0 for each. They are the friends and
+// friend-ofs of the account as of the last dump and everyone who posted an
+// exported entry in the journal or commented on one. Each contact has the
+// user name, the profile URL on the server, the journal title from the
+// friend list, the relationship to the account and the number of interactions, which counts the posted
+// entries and comments. Anonymous comments are not counted.
+
+type exportContact struct {
+	user         string
+	url          string
+	title        string
+	relationship string
+	interactions int
+}
+
+// Order contacts by the number of interactions, most active first
+type sortContacts []*exportContact
+
+func (a sortContacts) Len() int      { return len(a) }
+func (a sortContacts) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a sortContacts) Less(i, j int) bool {
+	if a[i].interactions != a[j].interactions {
+		return a[i].interactions > a[j].interactions
+	}
+	return a[i].user < a[j].user
+}
+
+// Escape a vCard text value
+var vcardEscapes = strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\n", `\n`, "\r", "")
+
+func exportContacts(ex *exportJournal) *Report {
+	if ex.anonymizer != nil {
+		return ReportMsg("the contacts export lists commenters by name and cannot be anonymized")
+	}
+	contacts := make(map[string]*exportContact)
+	contact := func(user string) *exportContact {
+		c := contacts[user]
+		if c == nil {
+			c = &exportContact{user: user, url: openIdIdentity(ex.config.server, user)}
+			if ex.friends != nil {
+				c.relationship = ex.friends.relationship(ex.config, user)
+			}
+			contacts[user] = c
+		}
+		return c
+	}
+	if ex.friends != nil {
+		for _, infos := range []map[string]*friendInfo{ex.friends.friends, ex.friends.friendOfs} {
+			for _, f := range infos {
+				if c := contact(f.user); f.fullName != "" {
+					c.title = f.fullName
+				}
+			}
+		}
+	}
+	for _, entry := range ex.entries {
+		if entry.poster != "" && entry.poster != ex.name {
+			contact(entry.poster).interactions++
+		}
+		comments, r := ex.comments(entry)
+		if r != nil {
+			return r
+		}
+		for i := range comments {
+			c := &comments[i]
+			if c.User == "" {
+				continue
+			}
+			contact(c.User).interactions++
+		}
+	}
+	delete(contacts, ex.config.username)
+
+	sorted := make([]*exportContact, 0, len(contacts))
+	for _, c := range contacts {
+		sorted = append(sorted, c)
+	}
+	sort.Sort(sortContacts(sorted))
+
+	var table bytes.Buffer
+	w := csv.NewWriter(&table)
+	w.Write([]string{"username", "profile_url", "journal_title", "relationship", "interactions"})
+	var cards bytes.Buffer
+	for _, c := range sorted {
+		w.Write([]string{c.user, c.url, c.title, c.relationship, fmt.Sprint(c.interactions)})
+
+		name := c.title
+		if name == "" {
+			name = c.user
+		}
+		note := fmt.Sprintf("%d interactions in %s", c.interactions, ex.name)
+		if c.relationship != "" {
+			note = c.relationship + ", " + note
+		}
+		cards.WriteString("BEGIN:VCARD\r\nVERSION:4.0\r\n")
+		fmt.Fprintf(&cards, "FN:%s\r\nNICKNAME:%s\r\nURL:%s\r\nNOTE:%s\r\n",
+			vcardEscapes.Replace(name), vcardEscapes.Replace(c.user), c.url, vcardEscapes.Replace(note))
+		cards.WriteString("END:VCARD\r\n")
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return WrapErr(err, "")
+	}
+	if err := writeFileTempRename(filepath.Join(ex.outDir, "contacts.csv"), table.Bytes()); err != nil {
+		return WrapErr(err, "")
+	}
+	if err := writeFileTempRename(filepath.Join(ex.outDir, "contacts.vcf"), cards.Bytes()); err != nil {
+		return WrapErr(err, "")
+	}
+	log("Wrote %d contacts of %s", len(sorted), ex.name)
+	return nil
+}
+
+func init() {
+	registerExportFunc("contacts", "friends and commenters as CSV and vCard", exportContacts)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_exportContacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	comments := `<comments><comment><id>5</id><user>alice</user><body>a</body></comment>` +
+		`<comment><id>6</id><user>carol</user><body>b</body></comment>` +
+		`<comment><id>7</id><user>alice</user><body>c</body></comment>` +
+		`<comment><id>8</id><body>anonymous</body></comment>` +
+		`<comment><id>9</id><user>bob</user><body>own</body></comment>` +
+		`<comment><id>10</id><user>ext_15</user><body>d</body></comment></comments>`
+	if err := ioutil.WriteFile(filepath.Join(dir, "C-1"), []byte(comments), 0666); err != nil {
+		t.Fatal(err)
+	}
+	ex := &exportJournal{
+		config: &Config{server: "https://lj.example.com", username: "bob"},
+		name:   "bob",
+		dir:    dir,
+		outDir: dir,
+		friends: &friendsData{
+			friends:   map[string]*friendInfo{"carol": {user: "carol", fullName: "Carol; notes, etc"}, "erin": {user: "erin"}},
+			friendOfs: map[string]*friendInfo{"carol": {user: "carol"}},
+		},
+		entries: []*archivedEntry{{itemId: 1, dir: dir, fileName: "L-1"}},
+	}
+	if r := exportContacts(ex); r != nil {
+		t.Fatal(r.AsText())
+	}
+	table, err := ioutil.ReadFile(filepath.Join(dir, "contacts.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `username,profile_url,journal_title,relationship,interactions
+alice,https://lj.example.com/users/alice/,,stranger,2
+carol,https://lj.example.com/users/carol/,"Carol; notes, etc",mutual friend,1
+ext_15,https://lj.example.com/users/ext_15/,,stranger,1
+erin,https://lj.example.com/users/erin/,,friend,0
+`
+	if string(table) != expected {
+		t.Errorf("Unexpected contacts:\n%s", table)
+	}
+	cards, err := ioutil.ReadFile(filepath.Join(dir, "contacts.vcf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	card := "BEGIN:VCARD\r\nVERSION:4.0\r\nFN:Carol\\; notes\\, etc\r\nNICKNAME:carol\r\nURL:https://lj.example.com/users/carol/\r\n" +
+		"NOTE:mutual friend\\, 1 interactions in bob\r\nEND:VCARD\r\n"
+	if !bytes.Contains(cards, []byte(card)) {
+		t.Errorf("Unexpected vCards:\n%s", cards)
+	}
+}
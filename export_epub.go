@@ -0,0 +1,179 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"html"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const epubContainerXml = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles>
+<rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+</rootfiles>
+</container>
+`
+
+const epubStyle = `body { font-family: serif; }
+.meta { font-size: small; color: #555; }
+.security { font-weight: bold; }
+.comment { font-size: small; border-left: 1px solid #999; padding-left: 0.5em; }
+`
+
+func epubXhtmlStart(buf *bytes.Buffer, title string) {
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><meta charset="UTF-8"/><title>`)
+	buf.WriteString(html.EscapeString(title))
+	buf.WriteString("</title><link rel=\"stylesheet\" href=\"style.css\"/></head>\n<body>\n")
+}
+
+// Write the text as XHTML paragraphs. EPUB requires well-formed XHTML so
+// the raw entry HTML is converted to text.
+func epubWriteParagraphs(buf *bytes.Buffer, htmlText string) {
+	for _, paragraph := range strings.Split(htmlToText(htmlText), "\n\n") {
+		if paragraph = strings.TrimSpace(paragraph); paragraph == "" {
+			continue
+		}
+		buf.WriteString("<p>")
+		lines := strings.Split(paragraph, "\n")
+		for i, line := range lines {
+			if i != 0 {
+				buf.WriteString("<br/>")
+			}
+			buf.WriteString(html.EscapeString(line))
+		}
+		buf.WriteString("</p>\n")
+	}
+}
+
+type epubChapter struct {
+	id    string
+	file  string
+	title string
+}
+
+func exportEpub(ex *exportJournal) *Report {
+	var chapters []epubChapter
+	var chapterData [][]byte
+	var buf *bytes.Buffer
+	lastYear := -1
+	finishChapter := func() {
+		if buf != nil {
+			buf.WriteString("</body>\n</html>\n")
+			chapterData = append(chapterData, buf.Bytes())
+		}
+	}
+	for _, entry := range ex.entries {
+		year, _ := entry.yearMonth()
+		if buf == nil || year != lastYear {
+			finishChapter()
+			lastYear = year
+			chapter := epubChapter{
+				id:    fmt.Sprintf("year%d", year),
+				file:  fmt.Sprintf("year-%d.xhtml", year),
+				title: fmt.Sprintf("%d", year),
+			}
+			chapters = append(chapters, chapter)
+			buf = new(bytes.Buffer)
+			epubXhtmlStart(buf, chapter.title)
+			fmt.Fprintf(buf, "<h1>%s</h1>\n", chapter.title)
+		}
+		fmt.Fprintf(buf, "<h2>%s</h2>\n<p class=\"meta\">%s", html.EscapeString(entryDisplaySubject(entry)), html.EscapeString(ex.config.formatDate(entry.eventTime)))
+		if level := entry.securityLevel(); level != securityPublic {
+			fmt.Fprintf(buf, " <span class=\"security\">%s</span>", html.EscapeString(level.label()))
+		}
+		if adult := adultContentLabel(entry.adultContent); adult != "" {
+			fmt.Fprintf(buf, " <span class=\"security\">%s</span>", html.EscapeString(adult))
+		}
+		buf.WriteString("</p>\n")
+		epubWriteParagraphs(buf, entryHtml(ex.config, entry))
+
+		comments, r := ex.comments(entry)
+		if r != nil {
+			return r
+		}
+		for _, thread := range threadComments(comments) {
+			c := thread.comment
+			screened := ""
+			if isScreenedComment(c) {
+				screened = " (screened)"
+			}
+			fmt.Fprintf(buf, "<div class=\"comment\" style=\"margin-left: %dem\">\n<p class=\"meta\">%s %s%s</p>\n",
+				thread.depth, html.EscapeString(ex.commenter(c)), html.EscapeString(ex.config.formatDate(c.Date)), screened)
+			epubWriteParagraphs(buf, commentHtml(ex.config, c))
+			buf.WriteString("</div>\n")
+		}
+	}
+	finishChapter()
+
+	var out bytes.Buffer
+	z := zip.NewWriter(&out)
+
+	// The mimetype file must be the first and stored without compression
+	w, err := z.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err == nil {
+		_, err = w.Write([]byte("application/epub+zip"))
+	}
+	addFile := func(name string, data []byte) {
+		if err != nil {
+			return
+		}
+		if w, err = z.Create(name); err == nil {
+			_, err = w.Write(data)
+		}
+	}
+	addFile("META-INF/container.xml", []byte(epubContainerXml))
+	addFile("OEBPS/style.css", []byte(epubStyle))
+
+	var nav bytes.Buffer
+	epubXhtmlStart(&nav, ex.name)
+	nav.WriteString("<nav epub:type=\"toc\" id=\"toc\"><h1>Contents</h1><ol>\n")
+	for _, chapter := range chapters {
+		fmt.Fprintf(&nav, "<li><a href=\"%s\">%s</a></li>\n", chapter.file, chapter.title)
+	}
+	if len(chapters) == 0 {
+		nav.WriteString("<li><a href=\"nav.xhtml\">No entries</a></li>\n")
+	}
+	nav.WriteString("</ol></nav>\n</body>\n</html>\n")
+	addFile("OEBPS/nav.xhtml", nav.Bytes())
+
+	var opf bytes.Buffer
+	fmt.Fprintf(&opf, `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="bookid">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:identifier id="bookid">urn:ljdump:%s</dc:identifier>
+<dc:title>%s</dc:title>
+<dc:language>%s</dc:language>
+<meta property="dcterms:modified">%s</meta>
+</metadata>
+<manifest>
+<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+<item id="style" href="style.css" media-type="text/css"/>
+`, html.EscapeString(ex.name), html.EscapeString(ex.name), ex.config.documentLanguage(), time.Now().UTC().Format("2006-01-02T15:04:05Z"))
+	for i, chapter := range chapters {
+		fmt.Fprintf(&opf, "<item id=\"%s\" href=\"%s\" media-type=\"application/xhtml+xml\"/>\n", chapter.id, chapter.file)
+		addFile("OEBPS/"+chapter.file, chapterData[i])
+	}
+	opf.WriteString("</manifest>\n<spine>\n<itemref idref=\"nav\"/>\n")
+	for _, chapter := range chapters {
+		fmt.Fprintf(&opf, "<itemref idref=\"%s\"/>\n", chapter.id)
+	}
+	opf.WriteString("</spine>\n</package>\n")
+	addFile("OEBPS/content.opf", opf.Bytes())
+
+	err = fuseErr(err, z.Close())
+	if err != nil {
+		return WrapErr(err, "failed to create EPUB for %s", ex.name)
+	}
+	epubPath := filepath.Join(ex.outDir, portableFileName(ex.name)+".epub")
+	if err := writeFileTempRename(epubPath, out.Bytes()); err != nil {
+		return WrapErr(err, "")
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// The graph format writes the social graph of the journal for network
+// analysis tools as graph.graphml for GraphML readers like yEd, NetworkX or
+// igraph and graph.gexf for Gephi. The nodes are the account, the journal,
+// its friends and friend-ofs as of the last dump and everyone who posted or
+// commented on an exported entry. The directed edges have a kind and a
+// weight: friend from a user to each user on their friend list, comment
+// from a commenter to the author of the entry with the number of comments,
+// and reply from a commenter to the author of the comment they answer with
+// the number of replies. With -anonymize the names are pseudonyms, and the
+// friend edges, profile URLs and relationships are left out as they would
+// tell who the pseudonyms are.
+
+const (
+	graphEdgeFriend  = "friend"
+	graphEdgeComment = "comment"
+	graphEdgeReply   = "reply"
+)
+
+type graphNode struct {
+	id           string
+	url          string
+	relationship string
+}
+
+type graphEdge struct {
+	source string
+	target string
+	kind   string
+	weight int
+}
+
+type socialGraph struct {
+	nodes map[string]*graphNode
+	edges map[graphEdge]int
+}
+
+func (g *socialGraph) node(ex *exportJournal, user string) *graphNode {
+	n := g.nodes[user]
+	if n == nil {
+		n = &graphNode{id: user}
+		if ex.anonymizer == nil {
+			n.url = openIdIdentity(ex.config.server, user)
+			if ex.friends != nil {
+				n.relationship = ex.friends.relationship(ex.config, user)
+			}
+		}
+		g.nodes[user] = n
+	}
+	return n
+}
+
+func (g *socialGraph) addEdge(ex *exportJournal, source string, target string, kind string) {
+	g.node(ex, source)
+	g.node(ex, target)
+	if source != target {
+		g.edges[graphEdge{source: source, target: target, kind: kind}]++
+	}
+}
+
+func buildSocialGraph(ex *exportJournal) (*socialGraph, *Report) {
+	g := &socialGraph{nodes: make(map[string]*graphNode), edges: make(map[graphEdge]int)}
+	g.node(ex, ex.name)
+	if ex.config.username != "" {
+		g.node(ex, ex.config.username)
+	}
+	if ex.friends != nil && ex.anonymizer == nil && ex.config.username != "" {
+		for user := range ex.friends.friends {
+			g.addEdge(ex, ex.config.username, user, graphEdgeFriend)
+		}
+		for user := range ex.friends.friendOfs {
+			g.addEdge(ex, user, ex.config.username, graphEdgeFriend)
+		}
+	}
+	for _, entry := range ex.entries {
+		author := entry.poster
+		if author == "" {
+			author = ex.name
+		} else if ex.anonymizer != nil {
+			// Comments come with pseudonyms already
+			author = ex.anonymizer.user(author)
+		}
+		g.node(ex, author)
+		comments, r := ex.comments(entry)
+		if r != nil {
+			return nil, r
+		}
+		commenters := make(map[string]string, len(comments))
+		for i := range comments {
+			commenters[strconv.FormatInt(int64(comments[i].Id), 10)] = comments[i].User
+		}
+		for i := range comments {
+			c := &comments[i]
+			if c.User == "" {
+				continue
+			}
+			if parent := commenters[c.ParentId]; parent != "" {
+				g.addEdge(ex, c.User, parent, graphEdgeReply)
+			} else {
+				g.addEdge(ex, c.User, author, graphEdgeComment)
+			}
+		}
+	}
+	return g, nil
+}
+
+func (g *socialGraph) sortedNodes() []*graphNode {
+	nodes := make([]*graphNode, 0, len(g.nodes))
+	for _, n := range g.nodes {
+		nodes = append(nodes, n)
+	}
+	sort.Sort(sortGraphNodes(nodes))
+	return nodes
+}
+
+func (g *socialGraph) sortedEdges() []graphEdge {
+	edges := make([]graphEdge, 0, len(g.edges))
+	for e, weight := range g.edges {
+		e.weight = weight
+		edges = append(edges, e)
+	}
+	sort.Sort(sortGraphEdges(edges))
+	return edges
+}
+
+type sortGraphNodes []*graphNode
+
+func (a sortGraphNodes) Len() int           { return len(a) }
+func (a sortGraphNodes) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a sortGraphNodes) Less(i, j int) bool { return a[i].id < a[j].id }
+
+type sortGraphEdges []graphEdge
+
+func (a sortGraphEdges) Len() int      { return len(a) }
+func (a sortGraphEdges) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a sortGraphEdges) Less(i, j int) bool {
+	if a[i].source != a[j].source {
+		return a[i].source < a[j].source
+	}
+	if a[i].target != a[j].target {
+		return a[i].target < a[j].target
+	}
+	return a[i].kind < a[j].kind
+}
+
+func (g *socialGraph) graphML(journal string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<graphml xmlns="http://graphml.graphdrawing.org/xmlns">
+<key id="label" for="node" attr.name="label" attr.type="string"/>
+<key id="url" for="node" attr.name="url" attr.type="string"/>
+<key id="relationship" for="node" attr.name="relationship" attr.type="string"/>
+<key id="kind" for="edge" attr.name="kind" attr.type="string"/>
+<key id="weight" for="edge" attr.name="weight" attr.type="double"/>
+`)
+	fmt.Fprintf(&buf, "<graph id=\"%s\" edgedefault=\"directed\">\n", html.EscapeString(journal))
+	for _, n := range g.sortedNodes() {
+		id := html.EscapeString(n.id)
+		fmt.Fprintf(&buf, "<node id=\"%s\"><data key=\"label\">%s</data>", id, id)
+		if n.url != "" {
+			fmt.Fprintf(&buf, "<data key=\"url\">%s</data>", html.EscapeString(n.url))
+		}
+		if n.relationship != "" {
+			fmt.Fprintf(&buf, "<data key=\"relationship\">%s</data>", n.relationship)
+		}
+		buf.WriteString("</node>\n")
+	}
+	for i, e := range g.sortedEdges() {
+		fmt.Fprintf(&buf, "<edge id=\"e%d\" source=\"%s\" target=\"%s\"><data key=\"kind\">%s</data><data key=\"weight\">%d</data></edge>\n",
+			i, html.EscapeString(e.source), html.EscapeString(e.target), e.kind, e.weight)
+	}
+	buf.WriteString("</graph>\n</graphml>\n")
+	return buf.Bytes()
+}
+
+func (g *socialGraph) gexf(journal string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<gexf xmlns="http://gexf.net/1.3" version="1.3">
+`)
+	fmt.Fprintf(&buf, "<meta><creator>ljdumpgo</creator><description>%s</description></meta>\n", html.EscapeString(journal))
+	buf.WriteString(`<graph defaultedgetype="directed">
+<attributes class="node">
+<attribute id="url" title="url" type="string"/>
+<attribute id="relationship" title="relationship" type="string"/>
+</attributes>
+<attributes class="edge">
+<attribute id="kind" title="kind" type="string"/>
+</attributes>
+<nodes>
+`)
+	for _, n := range g.sortedNodes() {
+		id := html.EscapeString(n.id)
+		fmt.Fprintf(&buf, "<node id=\"%s\" label=\"%s\">", id, id)
+		if n.url != "" || n.relationship != "" {
+			buf.WriteString("<attvalues>")
+			if n.url != "" {
+				fmt.Fprintf(&buf, "<attvalue for=\"url\" value=\"%s\"/>", html.EscapeString(n.url))
+			}
+			if n.relationship != "" {
+				fmt.Fprintf(&buf, "<attvalue for=\"relationship\" value=\"%s\"/>", n.relationship)
+			}
+			buf.WriteString("</attvalues>")
+		}
+		buf.WriteString("</node>\n")
+	}
+	buf.WriteString("</nodes>\n<edges>\n")
+	for i, e := range g.sortedEdges() {
+		fmt.Fprintf(&buf, "<edge id=\"%d\" source=\"%s\" target=\"%s\" weight=\"%d\" label=\"%s\"><attvalues><attvalue for=\"kind\" value=\"%s\"/></attvalues></edge>\n",
+			i, html.EscapeString(e.source), html.EscapeString(e.target), e.weight, e.kind, e.kind)
+	}
+	buf.WriteString("</edges>\n</graph>\n</gexf>\n")
+	return buf.Bytes()
+}
+
+func exportGraph(ex *exportJournal) *Report {
+	g, r := buildSocialGraph(ex)
+	if r != nil {
+		return r
+	}
+	if err := writeFileTempRename(filepath.Join(ex.outDir, "graph.graphml"), g.graphML(ex.name)); err != nil {
+		return WrapErr(err, "")
+	}
+	if err := writeFileTempRename(filepath.Join(ex.outDir, "graph.gexf"), g.gexf(ex.name)); err != nil {
+		return WrapErr(err, "")
+	}
+	log("Wrote a graph of %d users and %d links of %s", len(g.nodes), len(g.edges), ex.name)
+	return nil
+}
+
+func init() {
+	registerExportFunc("graph", "social graph as GraphML and GEXF", exportGraph)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_buildSocialGraph(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	comments := `<comments><comment><id>5</id><user>alice</user><body>a</body></comment>` +
+		`<comment><id>6</id><parentid>5</parentid><user>bob</user><body>b</body></comment>` +
+		`<comment><id>7</id><parentid>6</parentid><user>alice</user><body>c</body></comment>` +
+		`<comment><id>8</id><user>alice</user><body>d</body></comment>` +
+		`<comment><id>9</id><body>anonymous</body></comment></comments>`
+	if err := ioutil.WriteFile(filepath.Join(dir, "C-1"), []byte(comments), 0666); err != nil {
+		t.Fatal(err)
+	}
+	ex := &exportJournal{
+		config: &Config{server: "https://lj.example.com", username: "bob"},
+		name:   "bob",
+		dir:    dir,
+		outDir: dir,
+		friends: &friendsData{
+			friends:   map[string]*friendInfo{"carol": {user: "carol"}},
+			friendOfs: map[string]*friendInfo{"carol": {user: "carol"}, "alice": {user: "alice"}},
+		},
+		entries: []*archivedEntry{{itemId: 1, dir: dir, fileName: "L-1"}},
+	}
+	if r := exportGraph(ex); r != nil {
+		t.Fatal(r.AsText())
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "graph.graphml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`<node id="alice"><data key="label">alice</data><data key="url">https://lj.example.com/users/alice/</data><data key="relationship">friend-of</data></node>`,
+		`<edge id="e0" source="alice" target="bob"><data key="kind">comment</data><data key="weight">2</data></edge>`,
+		`<edge id="e1" source="alice" target="bob"><data key="kind">friend</data><data key="weight">1</data></edge>`,
+		`<edge id="e2" source="alice" target="bob"><data key="kind">reply</data><data key="weight">1</data></edge>`,
+		`<edge id="e3" source="bob" target="alice"><data key="kind">reply</data><data key="weight">1</data></edge>`,
+		`<edge id="e4" source="bob" target="carol"><data key="kind">friend</data><data key="weight">1</data></edge>`,
+		`<edge id="e5" source="carol" target="bob"><data key="kind">friend</data><data key="weight">1</data></edge>`,
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("GraphML does not contain %s:\n%s", expected, data)
+		}
+	}
+	if strings.Contains(string(data), "e6") {
+		t.Errorf("Unexpected edges:\n%s", data)
+	}
+	data, err = ioutil.ReadFile(filepath.Join(dir, "graph.gexf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `<edge id="0" source="alice" target="bob" weight="2" label="comment">`) {
+		t.Errorf("Unexpected GEXF:\n%s", data)
+	}
+
+	// Pseudonyms without the friend list
+	ex.anonymizer = newCommentAnonymizer(ex.config, "bob", []byte("key"))
+	g, r := buildSocialGraph(ex)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	alice := ex.anonymizer.user("alice")
+	if len(g.nodes) != 2 || g.nodes[alice] == nil || g.nodes[alice].url != "" || g.nodes["carol"] != nil {
+		t.Errorf("Unexpected anonymized nodes %v", g.nodes)
+	}
+	if g.edges[graphEdge{source: alice, target: "bob", kind: graphEdgeComment}] != 2 {
+		t.Errorf("Unexpected anonymized edges %v", g.edges)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// With -per-group the export writes a separate bundle for each friend group
+// of the account into groups/<group-name> under the export directory. A
+// bundle has the entries of the account journal that members of the group
+// could always read: public and friends-only entries and custom entries
+// whose allowmask includes the group, limited further by -max-security.
+// Private entries and screened comments are left out. members.txt in the bundle lists the friends in
+// the group as of the last dump. Friend groups belong to the account, so
+// other journals from the config are not exported.
+
+const groupExportsDirName = "groups"
+
+// Check if members of the friend group can read the entry
+func (entry *archivedEntry) visibleToGroup(groupId int) bool {
+	switch entry.securityLevel() {
+	case securityPublic, securityFriends:
+		return true
+	case securityCustom:
+		return entry.allowMask&(1<<uint(groupId)) != 0
+	}
+	return false
+}
+
+// Get the sorted names of friends in the group
+func (fd *friendsData) groupMembers(groupId int) []string {
+	var members []string
+	for user, info := range fd.friends {
+		if info.groupMask&(1<<uint(groupId)) != 0 {
+			members = append(members, user)
+		}
+	}
+	sort.Strings(members)
+	return members
+}
+
+func runGroupExports(config *Config) *Report {
+	friends, r := readFriendsData(config)
+	if r != nil {
+		return r
+	}
+	if friends == nil || len(friends.groups) == 0 {
+		return ReportMsg("no archived friend groups for -per-group, run dump first")
+	}
+	for _, journal := range config.journals {
+		if journal != config.username {
+			log("Skipping %s as friend groups apply only to the journal of %s", journal, config.username)
+		}
+	}
+	for i := range friends.groups {
+		group := &friends.groups[i]
+		bundle := *config
+		bundle.journals = []string{config.username}
+		bundle.exportGroup = group
+		bundle.exportPerGroup = false
+		bundle.exportDir = filepath.Join(config.exportDir, groupExportsDirName, portableFileName(group.name))
+		log("Exporting the bundle for friend group %s", group.name)
+		if r := runExport(&bundle); r != nil {
+			return r
+		}
+		members := friends.groupMembers(group.id)
+		text := "Friend group " + group.name + "\n\n" + strings.Join(members, "\n")
+		if len(members) != 0 {
+			text += "\n"
+		}
+		path := filepath.Join(bundle.exportDir, "members.txt")
+		if err := writeFileTempRename(path, []byte(text)); err != nil {
+			return WrapErr(err, "failed to write %s", path)
+		}
+	}
+	return nil
+}
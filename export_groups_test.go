@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func Test_groupExports(t *testing.T) {
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	journalDir := filepath.Join(dumpDir, "bob")
+	accountDataDir := filepath.Join(dumpDir, accountDataDirName)
+	for _, dir := range []string{journalDir, accountDataDir} {
+		if err := os.Mkdir(dir, 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeEntry := func(itemId int, security string, allowMask int64) {
+		entry := `<?xml version="1.0"?><event><itemid>` + strconv.Itoa(itemId) + `</itemid>` +
+			`<eventtime>2010-05-0` + strconv.Itoa(itemId) + ` 10:00:00</eventtime><subject>Entry</subject><event>text</event>` +
+			`<security>` + security + `</security><allowmask>` + strconv.FormatInt(allowMask, 10) + `</allowmask></event>`
+		if err := ioutil.WriteFile(filepath.Join(journalDir, "L-"+strconv.Itoa(itemId)), []byte(entry), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeEntry(1, "public", 0)
+	writeEntry(2, "usemask", 1)
+	writeEntry(3, "usemask", 1<<2)
+	writeEntry(4, "usemask", 1<<3)
+	writeEntry(5, "private", 0)
+
+	config := &Config{
+		username:       "bob",
+		dumpDir:        dumpDir,
+		accountDataDir: accountDataDir,
+		journals:       []string{"bob", "club"},
+		journalAliases: make(map[string]string),
+		exportFormat:   "markdown",
+		exportDir:      filepath.Join(dumpDir, "export"),
+		maxSecurity:    securityPrivate,
+		exportPerGroup: true,
+	}
+	if r := runExport(config); r == nil {
+		t.Errorf("Expected an error without archived friend groups")
+	}
+
+	friends := &friendsData{
+		friends: map[string]*friendInfo{
+			"alice": {"alice", "", "", 1 | 1<<2},
+			"carol": {"carol", "", "", 1 | 1<<3},
+		},
+		friendOfs: make(map[string]*friendInfo),
+		groups:    []friendGroup{{2, "Close", false}, {3, "Work", false}},
+	}
+	if r := writeFriendsData(config, friends); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if r := runExport(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+
+	check := func(group string, visible []int, member string) {
+		bundle := filepath.Join(config.exportDir, groupExportsDirName, group)
+		for itemId := 1; itemId <= 5; itemId++ {
+			expected := false
+			for _, id := range visible {
+				expected = expected || id == itemId
+			}
+			_, err := os.Stat(filepath.Join(bundle, "markdown", "bob", "L-"+strconv.Itoa(itemId)+".md"))
+			if expected != (err == nil) {
+				t.Errorf("Unexpected presence %v of L-%d in the bundle of %s", err == nil, itemId, group)
+			}
+		}
+		members, err := ioutil.ReadFile(filepath.Join(bundle, "members.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(members), member) {
+			t.Errorf("Expected %s in the members of %s, got %q", member, group, members)
+		}
+		if _, err := os.Stat(filepath.Join(bundle, "markdown", "club")); err == nil {
+			t.Errorf("Unexpected export of another journal in the bundle of %s", group)
+		}
+	}
+	check("Close", []int{1, 2, 3}, "alice")
+	check("Work", []int{1, 2, 4}, "carol")
+
+	// -max-security applies on top of the groups
+	if err := os.RemoveAll(config.exportDir); err != nil {
+		t.Fatal(err)
+	}
+	config.maxSecurity = securityFriends
+	if r := runExport(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	check("Close", []int{1, 2}, "alice")
+	check("Work", []int{1, 2}, "carol")
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"path/filepath"
+)
+
+const exportHtmlStyle = `body { font-family: serif; max-width: 50em; margin: auto; padding: 1em; }
+.security { font-family: sans-serif; font-size: small; padding: 0.1em 0.4em; border-radius: 0.3em; }
+.security-friends { background: #fde9b0; }
+.security-custom { background: #fcd1b0; }
+.security-private { background: #f6b4b4; }
+.meta { color: #555; font-size: small; }
+.userpic { max-height: 100px; vertical-align: middle; }
+.gallery img { height: 120px; margin: 2px; }
+.lightbox { display: none; position: fixed; z-index: 10; top: 0; left: 0; right: 0; bottom: 0; background: rgba(0, 0, 0, 0.85); }
+.lightbox:target { display: flex; align-items: center; justify-content: center; }
+.lightbox img { max-width: 95%; max-height: 95%; }
+.comment { border-left: 2px solid #ccc; padding-left: 0.7em; margin: 0.7em 0; }
+.adult { background: #e0c4f0; }
+.heatmap { max-width: 100%; }
+.adult-content > summary { font-family: sans-serif; padding: 1em; background: #eee; cursor: pointer; }
+`
+
+var exportHtmlTemplates = template.Must(template.New("").Parse(`
+{{define "security"}}{{if .Level}}<span class="security security-{{.Level}}">{{.Level.Label}}</span>{{end}}{{if .Adult}} <span class="security adult">{{.Adult}}</span>{{end}}{{end}}
+
+{{define "index"}}<!DOCTYPE html>
+<html lang="{{.Lang}}"><head><meta charset="utf-8"><title>{{.Journal}}</title>
+<link rel="stylesheet" href="style.css"></head>
+<body><h1>{{.Journal}}</h1>
+{{if .HasImages}}<p><a href="images.html">All images</a></p>{{end}}
+{{range .Years}}<h2>{{.Year}}</h2>
+{{if .Heatmap}}<p><img class="heatmap" src="{{.Heatmap}}" alt="Entries and comments per day in {{.Year}}"></p>
+{{end}}<ul>{{range .Entries}}
+<li><span class="meta">{{.Date}}</span> <a href="{{.File}}">{{.Subject}}</a> {{template "security" .}}</li>{{end}}
+</ul>{{end}}
+</body></html>
+{{end}}
+
+{{define "images"}}<!DOCTYPE html>
+<html lang="{{.Lang}}"><head><meta charset="utf-8"><title>{{.Journal}}</title>
+<link rel="stylesheet" href="style.css"></head>
+<body><p><a href="index.html">{{.Journal}}</a></p>
+<h1>Images</h1>
+<div class="gallery">{{range .Images}}<a href="{{.Entry}}" title="{{.Subject}}"><img src="{{.Src}}" alt="" loading="lazy"></a>{{end}}</div>
+</body></html>
+{{end}}
+
+{{define "entry"}}<!DOCTYPE html>
+<html lang="{{.Lang}}"><head><meta charset="utf-8"><title>{{.Subject}}</title>
+<link rel="stylesheet" href="../style.css"></head>
+<body><p><a href="../index.html">{{.Journal}}</a></p>
+<h1>{{if .Userpic}}<img class="userpic" src="{{.Userpic}}" alt=""{{if .UserpicTitle}} title="{{.UserpicTitle}}"{{end}}> {{end}}{{.Subject}}</h1>
+<p class="meta">{{.Date}} {{template "security" .}}{{if .Tags}} Tags: {{.Tags}}{{end}}{{if .Mood}} Mood: {{.Mood}}{{end}}{{if .Permalink}} <a href="{{.Permalink}}">Original</a>{{end}}</p>
+{{if .Adult}}<details class="adult-content"><summary>{{.Adult}}: open to read the entry</summary>
+{{end}}<div class="entry">{{.Body}}</div>
+{{if .Gallery}}<h2>Gallery</h2>
+<div class="gallery">{{range .Gallery}}<a href="#{{.Id}}"><img src="{{.Src}}" alt=""></a>{{end}}</div>
+{{range .Gallery}}<a href="#_" class="lightbox" id="{{.Id}}"><img src="{{.Src}}" alt=""></a>{{end}}{{end}}
+{{if .Adult}}</details>
+{{end}}{{if .Comments}}<h2>Comments</h2>{{if .Screening}}
+<p class="meta">{{.Screening}}</p>{{end}}{{range .Comments}}
+<div class="comment" style="margin-left: {{.Indent}}em">{{if .Screened}}<details><summary class="meta">Screened comment by {{.User}} {{.Date}}</summary>{{end}}
+<p class="meta">{{.User}} {{.Date}}{{if .Subject}} <b>{{.Subject}}</b>{{end}}{{if .Deleted}} <em class="deleted">({{.Deleted}})</em>{{else if .State}} ({{.State}}){{end}}</p>
+<div>{{.Body}}</div>{{if .Screened}}</details>{{end}}</div>{{end}}{{end}}
+</body></html>
+{{end}}
+`))
+
+// Security level with template-friendly accessors
+type htmlSecurityLevel securityLevel
+
+func (level htmlSecurityLevel) String() string {
+	return securityLevel(level).String()
+}
+
+func (level htmlSecurityLevel) Label() string {
+	return securityLevel(level).label()
+}
+
+type htmlEntryLink struct {
+	Date    string
+	Subject string
+	File    string
+	Level   htmlSecurityLevel
+
+	// Notice of adult content or empty, see content_flags.go
+	Adult string
+}
+
+type htmlYear struct {
+	Year    int
+	Entries []htmlEntryLink
+
+	// Calendar heatmap of the year, see heatmap.go
+	Heatmap string
+}
+
+type htmlComment struct {
+	Indent  int
+	User    string
+	Date    string
+	Subject string
+	State   string
+	Body    template.HTML
+
+	// Screened comments are shown collapsed
+	Screened bool
+
+	// Label of comments deleted after archiving
+	Deleted string
+}
+
+type htmlImage struct {
+	Id      string
+	Src     string
+	Entry   string
+	Subject string
+}
+
+// Entries with at least this number of images get a gallery
+const minGalleryImages = 2
+
+type htmlEntryPage struct {
+	Lang     string
+	Journal  string
+	Date     string
+	Subject  string
+	Level    htmlSecurityLevel
+	Tags     string
+	Mood     string
+	Body     template.HTML
+	Comments []htmlComment
+	Gallery  []htmlImage
+
+	// Adult content notice and how comments are screened or empty, see
+	// content_flags.go
+	Adult     string
+	Screening string
+
+	// URL of the entry on the server, see permalink.go
+	Permalink string
+
+	// URL and description of the userpic, only set by the serve command
+	Userpic      string
+	UserpicTitle string
+}
+
+func entryDisplaySubject(entry *archivedEntry) string {
+	if entry.subject != "" {
+		return entry.subject
+	}
+	return fmt.Sprintf("(no subject, %s)", entry.fileName)
+}
+
+func makeHtmlEntryPage(ex *exportJournal, entry *archivedEntry) (*htmlEntryPage, *Report) {
+	page := &htmlEntryPage{
+		Lang:    ex.config.documentLanguage(),
+		Journal: ex.name,
+		Date:    ex.config.formatDate(entry.eventTime),
+		Subject: entryDisplaySubject(entry),
+		Level:   htmlSecurityLevel(entry.securityLevel()),
+		Tags:    entry.props["taglist"],
+		Mood:    entry.props["current_mood"],
+		Body:    template.HTML(entryHtml(ex.config, entry)),
+
+		Adult:     adultContentLabel(entry.adultContent),
+		Screening: screeningLabel(entry.screening),
+		Permalink: entry.permalink,
+	}
+	if urls := htmlImageUrls(entry.event); len(urls) >= minGalleryImages {
+		for i, url := range urls {
+			src, r := ex.imageSrc(url)
+			if r != nil {
+				return nil, r
+			}
+			if src != url {
+				src = "../" + src
+			}
+			page.Gallery = append(page.Gallery, htmlImage{Id: fmt.Sprintf("image-%d", i+1), Src: src})
+		}
+	}
+	comments, r := ex.comments(entry)
+	if r != nil {
+		return nil, r
+	}
+	for _, thread := range threadComments(comments) {
+		c := thread.comment
+		page.Comments = append(page.Comments, htmlComment{
+			Indent:  2 * thread.depth,
+			User:    ex.commenter(c),
+			Date:    ex.config.formatDate(c.Date),
+			Subject: c.Subject,
+			State:   c.State,
+			Body:    template.HTML(commentHtml(ex.config, c)),
+
+			Screened: isScreenedComment(c),
+			Deleted:  commentDeletedLabel(ex.config, c),
+		})
+	}
+	return page, nil
+}
+
+// Copy archived images that the export refers to unless already copied.
+func copyExportMedia(ex *exportJournal) *Report {
+	if len(ex.usedMedia) == 0 {
+		return nil
+	}
+	outMediaDir, r := ex.mkdirOut(mediaDirName)
+	if r != nil {
+		return r
+	}
+	for file := range ex.usedMedia {
+		src := filepath.Join(ex.dir, mediaDirName, file)
+		dst := filepath.Join(outMediaDir, file)
+		srcInfo, err := archiveStore.Stat(src)
+		if err != nil {
+			return WrapErr(err, "")
+		}
+		if dstInfo, err := archiveStore.Stat(dst); err == nil && dstInfo.Size() == srcInfo.Size() {
+			continue
+		}
+		data, err := archiveStore.ReadFile(src)
+		if err != nil {
+			return WrapErr(err, "")
+		}
+		if err := writeFileTempRename(dst, data); err != nil {
+			return WrapErr(err, "")
+		}
+	}
+	return nil
+}
+
+func exportHtml(ex *exportJournal) *Report {
+	if err := writeFileTempRename(filepath.Join(ex.outDir, "style.css"), []byte(exportHtmlStyle)); err != nil {
+		return WrapErr(err, "")
+	}
+	entriesDir, r := ex.mkdirOut("entries")
+	if r != nil {
+		return r
+	}
+
+	var years []htmlYear
+	var images []htmlImage
+	for _, entry := range ex.entries {
+		year, _ := entry.yearMonth()
+		if len(years) == 0 || years[len(years)-1].Year != year {
+			years = append(years, htmlYear{Year: year})
+		}
+		fileName := entry.fileName + ".html"
+		level := htmlSecurityLevel(entry.securityLevel())
+		years[len(years)-1].Entries = append(years[len(years)-1].Entries, htmlEntryLink{
+			Date:    ex.config.formatDate(entry.eventTime),
+			Subject: entryDisplaySubject(entry),
+			File:    "entries/" + fileName,
+			Level:   level,
+			Adult:   adultContentLabel(entry.adultContent),
+		})
+
+		for _, url := range htmlImageUrls(entry.event) {
+			src, r := ex.imageSrc(url)
+			if r != nil {
+				return r
+			}
+			images = append(images, htmlImage{Src: src, Entry: "entries/" + fileName, Subject: entryDisplaySubject(entry)})
+		}
+		outPath := filepath.Join(entriesDir, fileName)
+		if ex.entryUnchanged(entry, outPath) {
+			continue
+		}
+		page, r := makeHtmlEntryPage(ex, entry)
+		if r != nil {
+			return r
+		}
+		var buf bytes.Buffer
+		if err := exportHtmlTemplates.ExecuteTemplate(&buf, "entry", page); err != nil {
+			return WrapErr(err, "failed to render %s", entry.fileName)
+		}
+		if err := writeFileTempRename(outPath, buf.Bytes()); err != nil {
+			return WrapErr(err, "")
+		}
+	}
+
+	if r := copyExportMedia(ex); r != nil {
+		return r
+	}
+	if r := exportHtmlHeatmaps(ex, years); r != nil {
+		return r
+	}
+	var buf bytes.Buffer
+	if len(images) != 0 {
+		data := struct {
+			Lang    string
+			Journal string
+			Images  []htmlImage
+		}{ex.config.documentLanguage(), ex.name, images}
+		if err := exportHtmlTemplates.ExecuteTemplate(&buf, "images", &data); err != nil {
+			return WrapErr(err, "failed to render images of %s", ex.name)
+		}
+		if err := writeFileTempRename(filepath.Join(ex.outDir, "images.html"), buf.Bytes()); err != nil {
+			return WrapErr(err, "")
+		}
+		buf.Reset()
+	}
+	index := struct {
+		Lang      string
+		Journal   string
+		HasImages bool
+		Years     []htmlYear
+	}{ex.config.documentLanguage(), ex.name, len(images) != 0, years}
+	if err := exportHtmlTemplates.ExecuteTemplate(&buf, "index", &index); err != nil {
+		return WrapErr(err, "failed to render index of %s", ex.name)
+	}
+	if err := writeFileTempRename(filepath.Join(ex.outDir, "index.html"), buf.Bytes()); err != nil {
+		return WrapErr(err, "")
+	}
+	return nil
+}
+
+// Write calendar heatmaps of the exported entries for the index
+func exportHtmlHeatmaps(ex *exportJournal, years []htmlYear) *Report {
+	index, r := loadEntriesIndex(ex.dir)
+	if r != nil {
+		return r
+	}
+	exported := make(map[int64]bool, len(ex.entries))
+	for _, entry := range ex.entries {
+		exported[entry.itemId] = true
+	}
+	activity, r := indexActivity(ex.dir, index, func(itemId int64) bool { return exported[itemId] })
+	if r != nil {
+		return r
+	}
+	written, r := writeActivityHeatmaps(ex.outDir, ex.name, activity)
+	if r != nil {
+		return r
+	}
+	for _, year := range written {
+		for i := range years {
+			if years[i].Year == year {
+				years[i].Heatmap = heatmapFileName(year)
+			}
+		}
+	}
+	return nil
+}
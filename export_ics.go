@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// The ics format writes <journal>.ics, an iCalendar file with an all-day
+// event for each entry on the day it was posted, so calendar apps can show
+// the journal history next to other events. The event has the subject as
+// its title and the permalink as the URL and in the description, since not
+// all apps show the URL. The day is the local date of the entry time as the
+// poster saw it. Non-public entries are marked with CLASS, friends-only and
+// custom entries as CONFIDENTIAL and private ones as PRIVATE.
+
+var icsEscapes = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// Write the content line folded into lines of at most 75 octets as RFC 5545
+// requires
+func icsWriteLine(buf *bytes.Buffer, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		buf.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		// The leading space of continuation lines counts
+		limit = 74
+	}
+	buf.WriteString(line + "\r\n")
+}
+
+func icsClass(level securityLevel) string {
+	switch level {
+	case securityFriends, securityCustom:
+		return "CONFIDENTIAL"
+	case securityPrivate:
+		return "PRIVATE"
+	}
+	return ""
+}
+
+func exportIcs(ex *exportJournal) *Report {
+	var buf bytes.Buffer
+	icsWriteLine(&buf, "BEGIN:VCALENDAR")
+	icsWriteLine(&buf, "VERSION:2.0")
+	icsWriteLine(&buf, "PRODID:-//ljdumpgo//journal history//EN")
+	icsWriteLine(&buf, "CALSCALE:GREGORIAN")
+	icsWriteLine(&buf, "X-WR-CALNAME:"+icsEscapes.Replace(ex.name))
+	stamp := time.Now().UTC().Format("20060102T150405Z")
+	events := 0
+	for _, entry := range ex.entries {
+		day, err := time.Parse("2006-01-02", strings.SplitN(entry.eventTime, " ", 2)[0])
+		if err != nil {
+			ex.config.warn("skipping %s of %s with the bad time %s", entry.fileName, ex.name, entry.eventTime)
+			continue
+		}
+		icsWriteLine(&buf, "BEGIN:VEVENT")
+		icsWriteLine(&buf, fmt.Sprintf("UID:%s-%s@ljdumpgo", portableFileName(ex.name), entry.fileName))
+		icsWriteLine(&buf, "DTSTAMP:"+stamp)
+		icsWriteLine(&buf, "DTSTART;VALUE=DATE:"+day.Format("20060102"))
+		icsWriteLine(&buf, "DTEND;VALUE=DATE:"+day.AddDate(0, 0, 1).Format("20060102"))
+		icsWriteLine(&buf, "SUMMARY:"+icsEscapes.Replace(entryDisplaySubject(entry)))
+		if entry.permalink != "" {
+			icsWriteLine(&buf, "URL:"+entry.permalink)
+			icsWriteLine(&buf, "DESCRIPTION:"+icsEscapes.Replace(entry.permalink))
+		}
+		if class := icsClass(entry.securityLevel()); class != "" {
+			icsWriteLine(&buf, "CLASS:"+class)
+		}
+		if tags := entry.tags(); len(tags) != 0 {
+			escaped := make([]string, len(tags))
+			for i, tag := range tags {
+				escaped[i] = icsEscapes.Replace(tag)
+			}
+			icsWriteLine(&buf, "CATEGORIES:"+strings.Join(escaped, ","))
+		}
+		icsWriteLine(&buf, "TRANSP:TRANSPARENT")
+		icsWriteLine(&buf, "END:VEVENT")
+		events++
+	}
+	icsWriteLine(&buf, "END:VCALENDAR")
+	path := filepath.Join(ex.outDir, portableFileName(ex.name)+".ics")
+	if err := writeFileTempRename(path, buf.Bytes()); err != nil {
+		return WrapErr(err, "")
+	}
+	log("Wrote %d events to %s", events, path)
+	return nil
+}
+
+func init() {
+	registerExportFunc("ics", "iCalendar with an event for each entry", exportIcs)
+}
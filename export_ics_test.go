@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_icsWriteLine(t *testing.T) {
+	var buf bytes.Buffer
+	icsWriteLine(&buf, "SUMMARY:"+strings.Repeat("я", 40))
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n")
+	if len(lines) != 2 || len(lines[0]) != 74 || !strings.HasPrefix(lines[1], " я") {
+		t.Errorf("Unexpected folding %q", lines)
+	}
+	for _, line := range lines {
+		if len(line) > 75 {
+			t.Errorf("Line is too long: %q", line)
+		}
+	}
+}
+
+func Test_exportIcs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ex := &exportJournal{
+		config: &Config{},
+		name:   "bob",
+		outDir: dir,
+		entries: []*archivedEntry{
+			{itemId: 1, fileName: "L-1", eventTime: "2009-12-31 23:30:00", subject: "Party; food, fun",
+				permalink: "https://bob.example.com/257.html", security: "private", props: map[string]string{"taglist": "new year"}},
+		},
+	}
+	if r := exportIcs(ex); r != nil {
+		t.Fatal(r.AsText())
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "bob.ics"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"BEGIN:VCALENDAR\r\nVERSION:2.0\r\n",
+		"UID:bob-L-1@ljdumpgo\r\n",
+		"DTSTART;VALUE=DATE:20091231\r\nDTEND;VALUE=DATE:20100101\r\n",
+		"SUMMARY:Party\\; food\\, fun\r\n",
+		"URL:https://bob.example.com/257.html\r\n",
+		"CLASS:PRIVATE\r\nCATEGORIES:new year\r\n",
+		"END:VEVENT\r\nEND:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("Calendar does not contain %q:\n%s", expected, data)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// The jsonfeed format writes the entries as a JSON Feed 1.1 for feed
+// readers and static site generators. The newest entries are in feed.json
+// and older ones follow in feed-2.json, feed-3.json and so on with
+// jsonFeedPageSize entries per page linked with next_url. Items have the
+// rendered HTML, the permalink, the time and the tags, and the _ljdump
+// extension object carries the itemid and the security of the entry. The
+// feed and page URLs are relative to the feed directory unless -feed-url
+// gives the base URL where the files are published.
+
+const jsonFeedVersion = "https://jsonfeed.org/version/1.1"
+
+const jsonFeedPageSize = 100
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+	Url  string `json:"url,omitempty"`
+}
+
+type jsonFeedExtension struct {
+	ItemId   int64  `json:"itemid"`
+	Security string `json:"security"`
+}
+
+type jsonFeedItem struct {
+	Id            string             `json:"id"`
+	Url           string             `json:"url,omitempty"`
+	Title         string             `json:"title,omitempty"`
+	ContentHtml   string             `json:"content_html"`
+	DatePublished string             `json:"date_published,omitempty"`
+	Tags          []string           `json:"tags,omitempty"`
+	Authors       []jsonFeedAuthor   `json:"authors,omitempty"`
+	LJDump        *jsonFeedExtension `json:"_ljdump"`
+}
+
+type jsonFeed struct {
+	Version     string           `json:"version"`
+	Title       string           `json:"title"`
+	HomePageUrl string           `json:"home_page_url,omitempty"`
+	FeedUrl     string           `json:"feed_url"`
+	NextUrl     string           `json:"next_url,omitempty"`
+	Language    string           `json:"language,omitempty"`
+	Authors     []jsonFeedAuthor `json:"authors,omitempty"`
+	Items       []jsonFeedItem   `json:"items"`
+}
+
+func jsonFeedPageName(page int) string {
+	if page == 1 {
+		return "feed.json"
+	}
+	return fmt.Sprintf("feed-%d.json", page)
+}
+
+func jsonFeedItemOf(ex *exportJournal, entry *archivedEntry) jsonFeedItem {
+	item := jsonFeedItem{
+		Id:            entry.permalink,
+		Url:           entry.permalink,
+		Title:         entry.subject,
+		ContentHtml:   entryHtml(ex.config, entry),
+		DatePublished: bloggerEntryTime(entry),
+		Tags:          entry.tags(),
+		LJDump:        &jsonFeedExtension{entry.itemId, entry.securityLevel().String()},
+	}
+	if item.Id == "" {
+		item.Id = ex.name + "/" + entry.fileName
+	}
+	if entry.poster != "" && entry.poster != ex.name {
+		item.Authors = []jsonFeedAuthor{{entry.poster, openIdIdentity(ex.config.server, entry.poster)}}
+	}
+	return item
+}
+
+func writeJsonFeedPages(ex *exportJournal, pageSize int) *Report {
+	base := ex.config.feedUrl
+	if base != "" && !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	pages := (len(ex.entries) + pageSize - 1) / pageSize
+	if pages == 0 {
+		pages = 1
+	}
+	// The entries are in chronological order and the feed starts with the
+	// newest
+	next := len(ex.entries) - 1
+	for page := 1; page <= pages; page++ {
+		feed := jsonFeed{
+			Version:  jsonFeedVersion,
+			Title:    ex.name,
+			FeedUrl:  base + jsonFeedPageName(1),
+			Language: ex.config.documentLanguage(),
+			Authors:  []jsonFeedAuthor{{ex.name, openIdIdentity(ex.config.server, ex.name)}},
+			Items:    []jsonFeedItem{},
+		}
+		if ex.config.server != "" {
+			feed.HomePageUrl = openIdIdentity(ex.config.server, ex.name)
+		}
+		if feed.Language == "und" {
+			feed.Language = ""
+		}
+		if page < pages {
+			feed.NextUrl = base + jsonFeedPageName(page+1)
+		}
+		for ; next >= 0 && len(feed.Items) < pageSize; next-- {
+			feed.Items = append(feed.Items, jsonFeedItemOf(ex, ex.entries[next]))
+		}
+		var buf bytes.Buffer
+		encoder := json.NewEncoder(&buf)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(&feed); err != nil {
+			return WrapErr(err, "")
+		}
+		if err := writeFileTempRename(filepath.Join(ex.outDir, jsonFeedPageName(page)), buf.Bytes()); err != nil {
+			return WrapErr(err, "")
+		}
+	}
+	// Remove pages left from an export with more entries
+	for page := pages + 1; ; page++ {
+		if err := archiveStore.Remove(filepath.Join(ex.outDir, jsonFeedPageName(page))); err != nil {
+			break
+		}
+	}
+	log("Wrote %d entries of %s to %d feed pages", len(ex.entries), ex.name, pages)
+	return nil
+}
+
+func exportJsonFeed(ex *exportJournal) *Report {
+	return writeJsonFeedPages(ex, jsonFeedPageSize)
+}
+
+func init() {
+	registerExportFunc("jsonfeed", "JSON Feed 1.1 pages", exportJsonFeed)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_writeJsonFeedPages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ex := &exportJournal{
+		config: &Config{server: "https://lj.example.com", feedUrl: "https://example.org/bob"},
+		name:   "bob",
+		outDir: dir,
+		entries: []*archivedEntry{
+			{itemId: 1, fileName: "L-1", eventTime: "2009-03-05 14:22:00", subject: "First", event: "a <b>b</b>"},
+			{itemId: 2, fileName: "L-2", eventTime: "2009-03-06 10:00:00", subject: "Second", security: "private"},
+			{itemId: 3, fileName: "L-3", eventTime: "2009-03-07 10:00:00", permalink: "https://lj.example.com/users/bob/770.html",
+				poster: "alice", props: map[string]string{"taglist": "x, y"}},
+		},
+	}
+	// A page left from an export with more entries
+	if err := ioutil.WriteFile(filepath.Join(dir, "feed-3.json"), []byte("{}"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if r := writeJsonFeedPages(ex, 2); r != nil {
+		t.Fatal(r.AsText())
+	}
+	read := func(name string) *jsonFeed {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var feed jsonFeed
+		if err := json.Unmarshal(data, &feed); err != nil {
+			t.Fatal(err)
+		}
+		return &feed
+	}
+	first := read("feed.json")
+	if first.Version != jsonFeedVersion || first.FeedUrl != "https://example.org/bob/feed.json" ||
+		first.NextUrl != "https://example.org/bob/feed-2.json" || first.HomePageUrl != "https://lj.example.com/users/bob/" {
+		t.Errorf("Unexpected feed %+v", first)
+	}
+	if len(first.Items) != 2 {
+		t.Fatalf("Unexpected items %+v", first.Items)
+	}
+	newest := first.Items[0]
+	if newest.Id != "https://lj.example.com/users/bob/770.html" || newest.Url != newest.Id || len(newest.Tags) != 2 ||
+		len(newest.Authors) != 1 || newest.Authors[0].Name != "alice" || newest.DatePublished != "2009-03-07T10:00:00Z" {
+		t.Errorf("Unexpected item %+v", newest)
+	}
+	if item := first.Items[1]; item.Id != "bob/L-2" || item.LJDump.Security != "private" || item.LJDump.ItemId != 2 {
+		t.Errorf("Unexpected item %+v", item)
+	}
+	second := read("feed-2.json")
+	if second.NextUrl != "" || len(second.Items) != 1 || second.Items[0].ContentHtml != "a <b>b</b>" {
+		t.Errorf("Unexpected last page %+v", second)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "feed-3.json")); !os.IsNotExist(err) {
+		t.Errorf("Stale page was not removed")
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strconv"
+)
+
+// One line of comments.jsonl. ParentId is null for top-level comments.
+type jsonlComment struct {
+	Id       CommentId `json:"id"`
+	JItemId  int64     `json:"jitemid"`
+	ParentId *int64    `json:"parentid"`
+	User     string    `json:"user"`
+	Date     string    `json:"date"`
+	State    string    `json:"state"`
+	Subject  string    `json:"subject"`
+	Body     string    `json:"body"`
+
+	// Time when the archived comment was found deleted on the server
+	Deleted string `json:"deleted,omitempty"`
+}
+
+// Write comments to all exported entries into one JSON Lines file for
+// loading into data analysis tools. Bodies are kept as the original HTML.
+func exportCommentsJsonl(ex *exportJournal) *Report {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	count := 0
+	for _, entry := range ex.entries {
+		comments, r := ex.comments(entry)
+		if r != nil {
+			return r
+		}
+		for i := range comments {
+			c := &comments[i]
+			line := jsonlComment{
+				Id:      c.Id,
+				JItemId: entry.itemId,
+				User:    c.User,
+				Date:    c.Date,
+				State:   c.State,
+				Subject: c.Subject,
+				Body:    c.Body,
+				Deleted: c.Deleted,
+			}
+			if parentId, err := strconv.ParseInt(c.ParentId, 10, 64); err == nil && parentId != 0 {
+				line.ParentId = &parentId
+			}
+			if err := enc.Encode(&line); err != nil {
+				return WrapErr(err, "failed to encode comment %d", c.Id)
+			}
+			count++
+		}
+	}
+	path := filepath.Join(ex.outDir, "comments.jsonl")
+	if err := writeFileTempRename(path, buf.Bytes()); err != nil {
+		return WrapErr(err, "")
+	}
+	log("Wrote %d comments to %s", count, path)
+	return nil
+}
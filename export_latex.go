@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The latex format writes a book project for the memoir class that compiles
+// with pdflatex or xelatex. <journal>.tex is the main file with the title
+// page and the table of contents. It includes year-<year>.tex with a
+// chapter for each year, where each entry is a section and its comments are
+// footnotes to the names of the commenters under the entry. Archived JPEG
+// and PNG images are copied into media and included after the text of
+// their entry, other images are mentioned by their URL. preamble.tex sets
+// the paper, fonts and packages and is written only when missing, so
+// changes to it survive the next export.
+
+const latexPreamble = `% Preamble of the book. Exports do not overwrite this file, so change the
+% paper size, the fonts and the languages here.
+\usepackage{iftex}
+\ifPDFTeX
+  % T2A for Cyrillic needs the cyrillic fonts of the TeX distribution
+  \usepackage[T2A,T1]{fontenc}
+  \usepackage[utf8]{inputenc}
+\else
+  % xelatex and lualatex need a font with all scripts of the journal, like
+  % \setmainfont{DejaVu Serif}
+  \usepackage{fontspec}
+\fi
+\usepackage{graphicx}
+\usepackage[hidelinks]{hyperref}
+\setstocksize{210mm}{148mm}
+\settrimmedsize{\stockheight}{\stockwidth}{*}
+\setlrmarginsandblock{20mm}{15mm}{*}
+\setulmarginsandblock{20mm}{20mm}{*}
+\checkandfixthelayout
+\setsecnumdepth{none}
+\settocdepth{chapter}
+\setlength{\parindent}{0pt}
+\setlength{\parskip}{0.5\baselineskip}
+`
+
+// Images that both pdflatex and xelatex can include
+var latexImageExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true}
+
+var latexEscapes = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	"{", `\{`,
+	"}", `\}`,
+	"$", `\$`,
+	"&", `\&`,
+	"#", `\#`,
+	"%", `\%`,
+	"_", `\_`,
+	"^", `\textasciicircum{}`,
+	"~", `\textasciitilde{}`,
+	"\t", " ",
+)
+
+func latexEscape(s string) string {
+	return latexEscapes.Replace(s)
+}
+
+// Write the HTML text as paragraphs separated by empty lines
+func latexWriteParagraphs(buf *bytes.Buffer, htmlText string) {
+	for _, paragraph := range strings.Split(htmlToText(htmlText), "\n\n") {
+		if paragraph = strings.TrimSpace(paragraph); paragraph == "" {
+			continue
+		}
+		lines := strings.Split(paragraph, "\n")
+		for i, line := range lines {
+			if i != 0 {
+				buf.WriteString(`\newline` + "\n")
+			}
+			buf.WriteString(latexEscape(strings.TrimSpace(line)))
+		}
+		buf.WriteString("\n\n")
+	}
+}
+
+func init() {
+	registerExporter("latex", "LaTeX book for the memoir class", func() Exporter { return &latexExporter{} })
+}
+
+type latexExporter struct {
+	ex *exportJournal
+
+	// The chapter of the current year
+	year    int
+	chapter *bytes.Buffer
+	years   []int
+
+	// Comments of the current entry written as footnotes when the entry
+	// ends
+	comments []CommentRecord
+
+	entryCount   int
+	commentCount int
+}
+
+func latexChapterName(year int) string {
+	return fmt.Sprintf("year-%d", year)
+}
+
+func (l *latexExporter) Begin(ex *exportJournal) *Report {
+	l.ex = ex
+	return nil
+}
+
+func (l *latexExporter) Entry(entry *archivedEntry) *Report {
+	l.finishEntry()
+	year, _ := entry.yearMonth()
+	if l.chapter == nil || year != l.year {
+		if r := l.finishChapter(); r != nil {
+			return r
+		}
+		l.year = year
+		l.years = append(l.years, year)
+		l.chapter = new(bytes.Buffer)
+		fmt.Fprintf(l.chapter, "\\chapter{%d}\n\n", year)
+	}
+	buf := l.chapter
+	fmt.Fprintf(buf, "\\section{%s}\n\n", latexEscape(entryDisplaySubject(entry)))
+	fmt.Fprintf(buf, "\\textit{%s}", latexEscape(l.ex.config.formatDate(entry.eventTime)))
+	if level := entry.securityLevel(); level != securityPublic {
+		fmt.Fprintf(buf, " \\textbf{%s}", latexEscape(level.label()))
+	}
+	if adult := adultContentLabel(entry.adultContent); adult != "" {
+		fmt.Fprintf(buf, " \\textbf{%s}", latexEscape(adult))
+	}
+	if tags := entry.tags(); len(tags) != 0 {
+		fmt.Fprintf(buf, " \\hfill \\textit{%s}", latexEscape(strings.Join(tags, ", ")))
+	}
+	buf.WriteString("\n\n")
+	latexWriteParagraphs(buf, entryHtml(l.ex.config, entry))
+	l.entryCount++
+	return nil
+}
+
+func (l *latexExporter) Media(entry *archivedEntry, url string, path string) *Report {
+	file := filepath.Base(path)
+	if !latexImageExtensions[strings.ToLower(filepath.Ext(file))] {
+		fmt.Fprintf(l.chapter, "\\textit{Image %s}\n\n", latexEscape(url))
+		return nil
+	}
+	fmt.Fprintf(l.chapter, "\\begin{center}\n\\includegraphics[width=\\linewidth,height=0.45\\textheight,keepaspectratio]{%s/%s}\n\\end{center}\n\n",
+		mediaDirName, file)
+	return nil
+}
+
+func (l *latexExporter) Comment(entry *archivedEntry, c *CommentRecord) *Report {
+	l.comments = append(l.comments, *c)
+	return nil
+}
+
+// Write the names of the commenters of the current entry with the comments
+// as footnotes
+func (l *latexExporter) finishEntry() {
+	if len(l.comments) == 0 {
+		return
+	}
+	buf := l.chapter
+	buf.WriteString(`\noindent\textit{Comments:}`)
+	authors := make(map[string]string, len(l.comments))
+	for i, thread := range threadComments(l.comments) {
+		c := thread.comment
+		author := l.ex.commenter(c)
+		authors[strconv.FormatInt(int64(c.Id), 10)] = author
+		if i != 0 {
+			buf.WriteString(",")
+		}
+		fmt.Fprintf(buf, " %s\\footnote{\\textbf{%s} %s", latexEscape(author), latexEscape(author), latexEscape(l.ex.config.formatDate(c.Date)))
+		if parent, ok := authors[c.ParentId]; ok && thread.depth != 0 {
+			fmt.Fprintf(buf, ", in reply to %s", latexEscape(parent))
+		}
+		if isScreenedComment(c) {
+			buf.WriteString(" (screened)")
+		}
+		if deleted := commentDeletedLabel(l.ex.config, c); deleted != "" {
+			fmt.Fprintf(buf, " (%s)", latexEscape(deleted))
+		}
+		if c.Subject != "" {
+			fmt.Fprintf(buf, " \\textit{%s}", latexEscape(c.Subject))
+		}
+		buf.WriteString("\n\n")
+		latexWriteParagraphs(buf, commentHtml(l.ex.config, c))
+		buf.WriteString("}")
+		l.commentCount++
+	}
+	buf.WriteString("\n\n")
+	l.comments = l.comments[:0]
+}
+
+func (l *latexExporter) finishChapter() *Report {
+	if l.chapter == nil {
+		return nil
+	}
+	path := filepath.Join(l.ex.outDir, latexChapterName(l.year)+".tex")
+	if err := writeFileTempRename(path, l.chapter.Bytes()); err != nil {
+		return WrapErr(err, "")
+	}
+	l.chapter = nil
+	return nil
+}
+
+func (l *latexExporter) End() *Report {
+	l.finishEntry()
+	if r := l.finishChapter(); r != nil {
+		return r
+	}
+	preamblePath := filepath.Join(l.ex.outDir, "preamble.tex")
+	if _, err := archiveStore.Stat(preamblePath); err != nil {
+		if err := writeFileTempRename(preamblePath, []byte(latexPreamble)); err != nil {
+			return WrapErr(err, "")
+		}
+	}
+
+	var book bytes.Buffer
+	book.WriteString("\\documentclass[11pt,openany]{memoir}\n\\input{preamble}\n\n")
+	fmt.Fprintf(&book, "\\title{%s}\n\\author{}\n", latexEscape(l.ex.name))
+	if len(l.years) != 0 {
+		first, last := l.years[0], l.years[len(l.years)-1]
+		if first == last {
+			fmt.Fprintf(&book, "\\date{%d}\n", first)
+		} else {
+			fmt.Fprintf(&book, "\\date{%d--%d}\n", first, last)
+		}
+	}
+	book.WriteString("\n\\begin{document}\n\\frontmatter\n\\maketitle\n\\tableofcontents*\n\\mainmatter\n\n")
+	for _, year := range l.years {
+		fmt.Fprintf(&book, "\\include{%s}\n", latexChapterName(year))
+	}
+	if len(l.years) == 0 {
+		book.WriteString("No entries.\n")
+	}
+	book.WriteString("\n\\end{document}\n")
+	path := filepath.Join(l.ex.outDir, portableFileName(l.ex.name)+".tex")
+	if err := writeFileTempRename(path, book.Bytes()); err != nil {
+		return WrapErr(err, "")
+	}
+	if r := copyExportMedia(l.ex); r != nil {
+		return r
+	}
+	log("Wrote %d entries and %d comments to %s, compile it with pdflatex or xelatex", l.entryCount, l.commentCount, path)
+	return nil
+}
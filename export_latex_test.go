@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_latexEscape(t *testing.T) {
+	if s := latexEscape(`50% of {x_1} & \n ~ #1 $`); s != `50\% of \{x\_1\} \& \textbackslash{}n \textasciitilde{} \#1 \$` {
+		t.Errorf("Unexpected escaped text %s", s)
+	}
+}
+
+func Test_exportLatex(t *testing.T) {
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	journalDir := filepath.Join(dumpDir, "bob")
+	if err := os.MkdirAll(filepath.Join(journalDir, mediaDirName), 0777); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"L-1": `<?xml version="1.0"?><event><itemid>1</itemid><eventtime>2009-03-05 14:22:00</eventtime><subject>Costs &amp; 100%</subject>` +
+			`<event>First line&lt;br&gt;second &lt;img src="http://pics.example.net/a.jpg"&gt;&lt;img src="http://pics.example.net/b.gif"&gt;</event>` +
+			`<security>private</security></event>`,
+		"C-1": `<?xml version="1.0"?><comments><comment><id>5</id><user>alice</user><date>2009-03-05T15:00:00Z</date><body>Nice_one</body></comment>` +
+			`<comment><id>6</id><parentid>5</parentid><user>bob</user><date>2009-03-05T16:00:00Z</date><body>Thanks</body></comment></comments>`,
+		"L-2":                   `<?xml version="1.0"?><event><itemid>2</itemid><eventtime>2010-01-01 00:00:00</eventtime><subject>New year</subject><event>Hi</event></event>`,
+		mediaDirName + "/a.jpg": "jpeg",
+		mediaDirName + "/b.gif": "gif",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(journalDir, filepath.FromSlash(name)), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	media := map[string]*mediaItem{
+		"http://pics.example.net/a.jpg": {url: "http://pics.example.net/a.jpg", file: "a.jpg"},
+		"http://pics.example.net/b.gif": {url: "http://pics.example.net/b.gif", file: "b.gif"},
+	}
+	if r := writeMediaIndex(journalDir, media); r != nil {
+		t.Fatal(r.AsText())
+	}
+
+	config := &Config{
+		dumpDir:        dumpDir,
+		journals:       []string{"bob"},
+		journalAliases: make(map[string]string),
+		exportFormat:   "latex",
+		exportDir:      filepath.Join(dumpDir, "export"),
+		maxSecurity:    securityPrivate,
+	}
+	if r := runExport(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	outDir := filepath.Join(config.exportDir, "latex", "bob")
+	read := func(name string) string {
+		data, err := ioutil.ReadFile(filepath.Join(outDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	book := read("bob.tex")
+	for _, expected := range []string{`\documentclass[11pt,openany]{memoir}`, `\date{2009--2010}`, `\include{year-2009}`, `\include{year-2010}`} {
+		if !strings.Contains(book, expected) {
+			t.Errorf("Book does not contain %q:\n%s", expected, book)
+		}
+	}
+	chapter := read("year-2009.tex")
+	for _, expected := range []string{
+		`\chapter{2009}`,
+		`\section{Costs \& 100\%}`,
+		`\textbf{Private}`,
+		"First line\\newline\nsecond",
+		`{media/a.jpg}`,
+		`\textit{Image http://pics.example.net/b.gif}`,
+		`\textit{Comments:} alice\footnote{\textbf{alice}`,
+		`Nice\_one`,
+		`, bob\footnote{\textbf{bob} 2009-03-05T16:00:00Z, in reply to alice`,
+	} {
+		if !strings.Contains(chapter, expected) {
+			t.Errorf("Chapter does not contain %q:\n%s", expected, chapter)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outDir, mediaDirName, "a.jpg")); err != nil {
+		t.Errorf("Image was not copied: %s", err)
+	}
+
+	// The preamble is kept for the user to change
+	if err := ioutil.WriteFile(filepath.Join(outDir, "preamble.tex"), []byte("% mine\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if r := runExport(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if preamble := read("preamble.tex"); preamble != "% mine\n" {
+		t.Errorf("Preamble was overwritten with %s", preamble)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Quote the string for YAML front matter
+func yamlQuote(s string) string {
+	return strconv.Quote(s)
+}
+
+func writeMarkdownEntry(ex *exportJournal, entry *archivedEntry, buf *bytes.Buffer) *Report {
+	level := entry.securityLevel()
+	buf.WriteString("---\n")
+	fmt.Fprintf(buf, "title: %s\n", yamlQuote(entry.subject))
+	fmt.Fprintf(buf, "date: %s\n", yamlQuote(entry.eventTime))
+	if entry.eventTimeRfc3339 != "" {
+		fmt.Fprintf(buf, "date_rfc3339: %s\n", yamlQuote(entry.eventTimeRfc3339))
+	}
+	fmt.Fprintf(buf, "itemid: %d\n", entry.itemId)
+	if entry.ditemid != 0 {
+		fmt.Fprintf(buf, "ditemid: %d\n", entry.ditemid)
+	}
+	if entry.permalink != "" {
+		fmt.Fprintf(buf, "url: %s\n", yamlQuote(entry.permalink))
+	}
+	fmt.Fprintf(buf, "security: %s\n", level)
+	if tags := entry.tags(); len(tags) != 0 {
+		quoted := make([]string, len(tags))
+		for i, tag := range tags {
+			quoted[i] = yamlQuote(tag)
+		}
+		fmt.Fprintf(buf, "tags: [%s]\n", strings.Join(quoted, ", "))
+	}
+	if mood := entry.props["current_mood"]; mood != "" {
+		fmt.Fprintf(buf, "mood: %s\n", yamlQuote(mood))
+	}
+	if entry.adultContent != "" {
+		fmt.Fprintf(buf, "adult_content: %s\n", yamlQuote(entry.adultContent))
+	}
+	if entry.screening != "" {
+		fmt.Fprintf(buf, "comment_screening: %s\n", yamlQuote(entry.screening))
+	}
+	buf.WriteString("---\n\n")
+
+	fmt.Fprintf(buf, "# %s\n\n", entryDisplaySubject(entry))
+	if level != securityPublic {
+		fmt.Fprintf(buf, "> **%s** entry\n\n", level.label())
+	}
+	if adult := adultContentLabel(entry.adultContent); adult != "" {
+		fmt.Fprintf(buf, "> **%s**\n\n", adult)
+	}
+	buf.WriteString(strings.TrimSpace(entryHtml(ex.config, entry)))
+	buf.WriteString("\n")
+
+	comments, r := ex.comments(entry)
+	if r != nil {
+		return r
+	}
+	if len(comments) != 0 {
+		buf.WriteString("\n## Comments\n")
+		if screening := screeningLabel(entry.screening); screening != "" {
+			buf.WriteString("\n*" + screening + "*\n")
+		}
+		for _, thread := range threadComments(comments) {
+			c := thread.comment
+			quote := strings.Repeat(">", thread.depth+1) + " "
+			buf.WriteString("\n")
+			header := fmt.Sprintf("**%s** %s", ex.commenter(c), ex.config.formatDate(c.Date))
+			if c.Subject != "" {
+				header += " - " + c.Subject
+			}
+			if isScreenedComment(c) {
+				header += " *(screened)*"
+			}
+			if deleted := commentDeletedLabel(ex.config, c); deleted != "" {
+				header += " *(" + deleted + ")*"
+			}
+			buf.WriteString(quote + header + "\n" + strings.TrimRight(quote, " ") + "\n")
+			for _, line := range strings.Split(htmlToText(commentHtml(ex.config, c)), "\n") {
+				buf.WriteString(strings.TrimRight(quote+line, " ") + "\n")
+			}
+		}
+	}
+	return nil
+}
+
+func exportMarkdown(ex *exportJournal) *Report {
+	var index bytes.Buffer
+	fmt.Fprintf(&index, "# %s\n", ex.name)
+	lastYear := -1
+	for _, entry := range ex.entries {
+		year, _ := entry.yearMonth()
+		if year != lastYear {
+			fmt.Fprintf(&index, "\n## %d\n\n", year)
+			lastYear = year
+		}
+		fileName := entry.fileName + ".md"
+		label := ""
+		if level := entry.securityLevel(); level != securityPublic {
+			label = " *(" + level.label() + ")*"
+		}
+		fmt.Fprintf(&index, "- %s [%s](%s)%s\n", ex.config.formatDate(entry.eventTime), entryDisplaySubject(entry), fileName, label)
+
+		outPath := filepath.Join(ex.outDir, fileName)
+		if ex.entryUnchanged(entry, outPath) {
+			continue
+		}
+		var buf bytes.Buffer
+		if r := writeMarkdownEntry(ex, entry, &buf); r != nil {
+			return r
+		}
+		if err := writeFileTempRename(outPath, buf.Bytes()); err != nil {
+			return WrapErr(err, "")
+		}
+	}
+	if err := writeFileTempRename(filepath.Join(ex.outDir, "index.md"), index.Bytes()); err != nil {
+		return WrapErr(err, "")
+	}
+	return nil
+}
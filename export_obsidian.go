@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// The obsidian format writes the journal as a vault of Markdown notes that
+// Obsidian opens as is and Logseq reads as a graph folder. Each entry is a
+// note in entries/ named by its date and subject, with the subject as an
+// alias and the tags as Obsidian tags in the front matter. The entry HTML
+// is converted to Markdown where <lj user> and <lj comm> references and
+// comment authors become [[wiki-links]] to notes in users/, so the backlinks
+// of a user note list the entries that mention the user or have comments
+// from them. <journal>.md links all entries by year. The export rewrites
+// all notes and removes notes it no longer writes, like those of entries
+// whose subject changed, so edits belong in other folders of the vault.
+
+const obsidianEntriesDir = "entries"
+const obsidianUsersDir = "users"
+
+// Characters that Obsidian does not allow in note names or that break
+// wiki-links
+var obsidianNoteNameChars = regexp.MustCompile(`[<>:"/\\|?*#^\[\]\x00-\x1f]+`)
+
+var obsidianManyNewLines = regexp.MustCompile(`\n[ \t]*\n(?:[ \t]*\n)+`)
+
+// Get the note name from the date and the subject of the entry
+func obsidianEntryNoteName(entry *archivedEntry) string {
+	date := entry.eventTime
+	if len(date) > 10 {
+		date = date[:10]
+	}
+	subject := strings.Join(strings.Fields(obsidianNoteNameChars.ReplaceAllString(htmlToText(entry.subject), " ")), " ")
+	if runes := []rune(subject); len(runes) > 80 {
+		subject = strings.TrimSpace(string(runes[:80]))
+	}
+	if subject == "" {
+		subject = entry.fileName
+	}
+	return portableFileName(date + " " + subject)
+}
+
+func obsidianUserNoteName(user string) string {
+	return portableFileName(obsidianNoteNameChars.ReplaceAllString(user, "_"))
+}
+
+// Convert the tag into an Obsidian tag that may contain only letters,
+// digits, _, - and / and must not be only digits
+func obsidianTag(tag string) string {
+	var buf strings.Builder
+	for _, c := range strings.TrimSpace(tag) {
+		switch {
+		case unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '/':
+			buf.WriteRune(c)
+		case c == '-' || unicode.IsSpace(c) || unicode.IsPunct(c):
+			if s := buf.String(); s != "" && !strings.HasSuffix(s, "-") {
+				buf.WriteRune('-')
+			}
+		}
+	}
+	s := strings.Trim(buf.String(), "-/")
+	if strings.IndexFunc(s, func(c rune) bool { return !unicode.IsDigit(c) }) < 0 {
+		s = "_" + s
+	}
+	return s
+}
+
+// Write the Markdown link target, URLs with spaces or parentheses need the
+// angle brackets
+func obsidianLinkTarget(url string) string {
+	if strings.ContainsAny(url, " ()<>") {
+		return "<" + strings.Replace(url, ">", "%3E", -1) + ">"
+	}
+	return url
+}
+
+type obsidianVault struct {
+	ex *exportJournal
+
+	// Users and communities that the written notes link to
+	users map[string]bool
+}
+
+func (v *obsidianVault) userLink(user string) string {
+	v.users[user] = true
+	name := obsidianUserNoteName(user)
+	if name == user {
+		return "[[" + name + "]]"
+	}
+	return "[[" + name + "|" + user + "]]"
+}
+
+// Convert the normalized HTML into Markdown for the vault
+func (v *obsidianVault) markdown(body string) (string, *Report) {
+	var out strings.Builder
+	var linkUrls []string
+	tokens := tokenizeHtml(body)
+	for i := 0; i < len(tokens); i++ {
+		t := &tokens[i]
+		if t.name == "" {
+			if !strings.HasPrefix(t.text, "<!--") {
+				out.WriteString(html.UnescapeString(strings.Replace(t.text, "\n", " ", -1)))
+			}
+			continue
+		}
+		switch t.name {
+		case "br":
+			out.WriteString("\n")
+		case "p", "div", "blockquote", "pre", "table", "tr", "ul", "ol":
+			out.WriteString("\n\n")
+		case "h1", "h2", "h3", "h4", "h5", "h6":
+			out.WriteString("\n\n")
+			if !t.closing {
+				out.WriteString(strings.Repeat("#", int(t.name[1]-'0')) + " ")
+			}
+		case "li":
+			if !t.closing {
+				out.WriteString("\n- ")
+			}
+		case "hr":
+			out.WriteString("\n\n---\n\n")
+		case "b", "strong":
+			out.WriteString("**")
+		case "i", "em":
+			out.WriteString("*")
+		case "s", "strike", "del":
+			out.WriteString("~~")
+		case "img":
+			if src := htmlAttr(t.attrs, "src"); src != "" {
+				local, r := v.ex.imageSrc(src)
+				if r != nil {
+					return "", r
+				}
+				if local != src {
+					local = "../" + local
+				}
+				fmt.Fprintf(&out, "![%s](%s)", htmlAttr(t.attrs, "alt"), obsidianLinkTarget(local))
+			}
+		case "a":
+			if t.closing {
+				if n := len(linkUrls); n != 0 {
+					if url := linkUrls[n-1]; url != "" {
+						fmt.Fprintf(&out, "](%s)", obsidianLinkTarget(url))
+					}
+					linkUrls = linkUrls[:n-1]
+				}
+				continue
+			}
+			if htmlAttr(t.attrs, "class") == "lj-user" && i+2 < len(tokens) && tokens[i+1].name == "" &&
+				tokens[i+2].name == "a" && tokens[i+2].closing {
+				out.WriteString(v.userLink(html.UnescapeString(tokens[i+1].text)))
+				i += 2
+				continue
+			}
+			url := htmlAttr(t.attrs, "href")
+			if url != "" {
+				out.WriteString("[")
+			}
+			linkUrls = append(linkUrls, url)
+		}
+	}
+	s := obsidianManyNewLines.ReplaceAllString(out.String(), "\n\n")
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimSpace(lines[i])
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func (v *obsidianVault) writeEntry(entry *archivedEntry, buf *bytes.Buffer) *Report {
+	ex := v.ex
+	level := entry.securityLevel()
+	buf.WriteString("---\n")
+	fmt.Fprintf(buf, "title: %s\n", yamlQuote(entry.subject))
+	if entry.subject != "" {
+		fmt.Fprintf(buf, "aliases: [%s]\n", yamlQuote(entry.subject))
+	}
+	fmt.Fprintf(buf, "date: %s\n", yamlQuote(entry.eventTime))
+	fmt.Fprintf(buf, "itemid: %d\n", entry.itemId)
+	if entry.permalink != "" {
+		fmt.Fprintf(buf, "url: %s\n", yamlQuote(entry.permalink))
+	}
+	fmt.Fprintf(buf, "security: %s\n", level)
+	var tags []string
+	for _, tag := range entry.tags() {
+		if tag = obsidianTag(tag); tag != "_" {
+			tags = append(tags, yamlQuote(tag))
+		}
+	}
+	if len(tags) != 0 {
+		fmt.Fprintf(buf, "tags: [%s]\n", strings.Join(tags, ", "))
+	}
+	if mood := entry.props["current_mood"]; mood != "" {
+		fmt.Fprintf(buf, "mood: %s\n", yamlQuote(mood))
+	}
+	buf.WriteString("---\n\n")
+
+	fmt.Fprintf(buf, "# %s\n\n", entryDisplaySubject(entry))
+	if entry.poster != "" && entry.poster != ex.name {
+		fmt.Fprintf(buf, "Posted by %s\n\n", v.userLink(entry.poster))
+	}
+	if level != securityPublic {
+		fmt.Fprintf(buf, "> **%s** entry\n\n", level.label())
+	}
+	if adult := adultContentLabel(entry.adultContent); adult != "" {
+		fmt.Fprintf(buf, "> **%s**\n\n", adult)
+	}
+	body, r := v.markdown(entryHtml(ex.config, entry))
+	if r != nil {
+		return r
+	}
+	buf.WriteString(body)
+	buf.WriteString("\n")
+
+	comments, r := ex.comments(entry)
+	if r != nil {
+		return r
+	}
+	if len(comments) == 0 {
+		return nil
+	}
+	buf.WriteString("\n## Comments\n")
+	if screening := screeningLabel(entry.screening); screening != "" {
+		buf.WriteString("\n*" + screening + "*\n")
+	}
+	for _, thread := range threadComments(comments) {
+		c := thread.comment
+		quote := strings.Repeat(">", thread.depth+1) + " "
+		author := ex.commenter(c)
+		if c.User != "" && !isSyntheticUser(c.User) {
+			author = v.userLink(c.User) + strings.TrimPrefix(author, c.User)
+		} else {
+			author = "**" + author + "**"
+		}
+		header := author + " " + ex.config.formatDate(c.Date)
+		if c.Subject != "" {
+			header += " - " + c.Subject
+		}
+		if isScreenedComment(c) {
+			header += " *(screened)*"
+		}
+		if deleted := commentDeletedLabel(ex.config, c); deleted != "" {
+			header += " *(" + deleted + ")*"
+		}
+		body, r := v.markdown(commentHtml(ex.config, c))
+		if r != nil {
+			return r
+		}
+		buf.WriteString("\n" + quote + header + "\n" + strings.TrimRight(quote, " ") + "\n")
+		for _, line := range strings.Split(body, "\n") {
+			buf.WriteString(strings.TrimRight(quote+line, " ") + "\n")
+		}
+	}
+	return nil
+}
+
+func (v *obsidianVault) writeUser(dir string, user string) *Report {
+	var buf bytes.Buffer
+	buf.WriteString("---\n")
+	fmt.Fprintf(&buf, "title: %s\n", yamlQuote(user))
+	if v.ex.anonymizer == nil && v.ex.config.server != "" {
+		fmt.Fprintf(&buf, "url: %s\n", yamlQuote(openIdIdentity(v.ex.config.server, user)))
+	}
+	buf.WriteString("---\n\n")
+	fmt.Fprintf(&buf, "# %s\n", user)
+	if v.ex.anonymizer == nil && v.ex.friends != nil {
+		fmt.Fprintf(&buf, "\nRelationship: %s\n", v.ex.friends.relationship(v.ex.config, user))
+	}
+	path := filepath.Join(dir, obsidianUserNoteName(user)+".md")
+	if err := writeFileTempRename(path, buf.Bytes()); err != nil {
+		return WrapErr(err, "")
+	}
+	return nil
+}
+
+// Remove the notes in the directory that the export did not write
+func removeStaleNotes(dir string, written map[string]bool) *Report {
+	infos, err := archiveStore.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return WrapErr(err, "")
+	}
+	for _, info := range infos {
+		if name := info.Name(); strings.HasSuffix(name, ".md") && !written[name] {
+			if err := archiveStore.Remove(filepath.Join(dir, name)); err != nil {
+				return WrapErr(err, "")
+			}
+		}
+	}
+	return nil
+}
+
+func exportObsidian(ex *exportJournal) *Report {
+	entriesDir, r := ex.mkdirOut(obsidianEntriesDir)
+	if r != nil {
+		return r
+	}
+	usersDir, r := ex.mkdirOut(obsidianUsersDir)
+	if r != nil {
+		return r
+	}
+	v := &obsidianVault{ex: ex, users: make(map[string]bool)}
+	written := make(map[string]bool)
+	var index bytes.Buffer
+	fmt.Fprintf(&index, "# %s\n", ex.name)
+	lastYear := -1
+	for _, entry := range ex.entries {
+		name := obsidianEntryNoteName(entry)
+		if written[name+".md"] {
+			name += " " + entry.fileName
+		}
+		written[name+".md"] = true
+		year, _ := entry.yearMonth()
+		if year != lastYear {
+			fmt.Fprintf(&index, "\n## %d\n\n", year)
+			lastYear = year
+		}
+		label := ""
+		if level := entry.securityLevel(); level != securityPublic {
+			label = " *(" + level.label() + ")*"
+		}
+		fmt.Fprintf(&index, "- [[%s]]%s\n", name, label)
+
+		var buf bytes.Buffer
+		if r := v.writeEntry(entry, &buf); r != nil {
+			return r
+		}
+		if err := writeFileTempRename(filepath.Join(entriesDir, name+".md"), buf.Bytes()); err != nil {
+			return WrapErr(err, "")
+		}
+	}
+	if r := removeStaleNotes(entriesDir, written); r != nil {
+		return r
+	}
+
+	users := make([]string, 0, len(v.users))
+	for user := range v.users {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	writtenUsers := make(map[string]bool)
+	for _, user := range users {
+		if r := v.writeUser(usersDir, user); r != nil {
+			return r
+		}
+		writtenUsers[obsidianUserNoteName(user)+".md"] = true
+	}
+	if r := removeStaleNotes(usersDir, writtenUsers); r != nil {
+		return r
+	}
+	if err := writeFileTempRename(filepath.Join(ex.outDir, portableFileName(ex.name)+".md"), index.Bytes()); err != nil {
+		return WrapErr(err, "")
+	}
+	if r := copyExportMedia(ex); r != nil {
+		return r
+	}
+	log("Wrote %d entry notes and %d user notes of %s", len(ex.entries), len(users), ex.name)
+	return nil
+}
+
+func init() {
+	registerExportFunc("obsidian", "Markdown vault with wiki-links for Obsidian and Logseq", exportObsidian)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_obsidianTag(t *testing.T) {
+	for tag, expected := range map[string]string{
+		"new york":      "new-york",
+		"books/fiction": "books/fiction",
+		"2010":          "_2010",
+		"rock & roll":   "rock-roll",
+		"музыка":        "музыка",
+	} {
+		if got := obsidianTag(tag); got != expected {
+			t.Errorf("obsidianTag(%q) = %q, expected %q", tag, got, expected)
+		}
+	}
+}
+
+func Test_exportObsidian(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	comments := `<comments><comment><id>5</id><user>alice</user><date>2009-03-05T15:00:00Z</date><body>Nice</body></comment></comments>`
+	if err := ioutil.WriteFile(filepath.Join(dir, "C-1"), []byte(comments), 0666); err != nil {
+		t.Fatal(err)
+	}
+	outDir := filepath.Join(dir, "vault")
+	if err := os.Mkdir(outDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	ex := &exportJournal{
+		config: &Config{server: "https://www.livejournal.com"},
+		name:   "bob",
+		dir:    dir,
+		outDir: outDir,
+		entries: []*archivedEntry{{
+			itemId: 1, dir: dir, fileName: "L-1", eventTime: "2009-03-05 14:22:00", subject: "Spring: day 1?",
+			event: `Met <lj user="carol"> in <a href="https://example.com/park">the <b>park</b></a>` + "\nHome",
+			props: map[string]string{"taglist": "new york, 2009"},
+		}},
+	}
+	stale := filepath.Join(outDir, obsidianEntriesDir, "2009-03-05 Old subject.md")
+	if err := os.MkdirAll(filepath.Dir(stale), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(stale, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if r := exportObsidian(ex); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("Stale note was not removed")
+	}
+	data, err := ioutil.ReadFile(filepath.Join(outDir, obsidianEntriesDir, "2009-03-05 Spring day 1.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"aliases: [\"Spring: day 1?\"]\n",
+		"tags: [\"new-york\", \"_2009\"]\n",
+		"Met [[carol]] in [the **park**](https://example.com/park)\nHome\n",
+		"> [[alice]] 2009-03-05T15:00:00Z\n>\n> Nice\n",
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("Note does not contain %q:\n%s", expected, data)
+		}
+	}
+	for _, user := range []string{"alice", "carol"} {
+		data, err := ioutil.ReadFile(filepath.Join(outDir, obsidianUsersDir, user+".md"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "url: \"https://"+user+".livejournal.com/\"") {
+			t.Errorf("Unexpected user note:\n%s", data)
+		}
+	}
+	index, err := ioutil.ReadFile(filepath.Join(outDir, "bob.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(index) != "# bob\n\n## 2009\n\n- [[2009-03-05 Spring day 1]]\n" {
+		t.Errorf("Unexpected index:\n%s", index)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"path/filepath"
+	"time"
+)
+
+// The opml format writes <journal>.opml, an OPML 2.0 outline of the
+// entry titles nested in years and months with the number of entries in
+// each. Outliners and mind map tools import it, and for a journal with
+// thousands of entries it is a table of contents that stays small. Each
+// entry links to its page of the html export, written into the sibling html
+// directory of the same -output, and carries the permalink on the server
+// when it is known. Comments are not read, so the export is fast.
+
+// Format the entry time for the created attribute, RFC 822 as OPML requires
+func opmlEntryTime(entry *archivedEntry) string {
+	t := entry.absoluteTime()
+	if t.IsZero() {
+		for _, l := range archiveTimeLayouts {
+			var err error
+			if t, err = time.Parse(l.layout, entry.eventTime); err == nil {
+				break
+			}
+		}
+	}
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC1123Z)
+}
+
+func exportOpml(ex *exportJournal) *Report {
+	type month struct {
+		year    int
+		month   int
+		entries []*archivedEntry
+	}
+	var months []*month
+	yearCounts := make(map[int]int)
+	for _, entry := range ex.entries {
+		year, m := entry.yearMonth()
+		if len(months) == 0 || months[len(months)-1].year != year || months[len(months)-1].month != m {
+			months = append(months, &month{year: year, month: m})
+		}
+		last := months[len(months)-1]
+		last.entries = append(last.entries, entry)
+		yearCounts[year]++
+	}
+
+	htmlDir := "../../html/" + portableFileName(ex.name) + "/entries/"
+	var buf bytes.Buffer
+	buf.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<opml version=\"2.0\">\n<head>\n")
+	fmt.Fprintf(&buf, "<title>%s</title>\n", html.EscapeString(ex.name))
+	fmt.Fprintf(&buf, "<dateCreated>%s</dateCreated>\n", time.Now().UTC().Format(time.RFC1123Z))
+	buf.WriteString("</head>\n<body>\n")
+	for i, m := range months {
+		if i == 0 || months[i-1].year != m.year {
+			if i != 0 {
+				buf.WriteString("</outline>\n")
+			}
+			fmt.Fprintf(&buf, "<outline text=\"%d (%d)\">\n", m.year, yearCounts[m.year])
+		}
+		fmt.Fprintf(&buf, "<outline text=\"%s (%d)\">\n", html.EscapeString(ex.config.monthTitle(m.month)), len(m.entries))
+		for _, entry := range m.entries {
+			fmt.Fprintf(&buf, "<outline text=\"%s\" type=\"link\" url=\"%s\"",
+				html.EscapeString(entryDisplaySubject(entry)), html.EscapeString(htmlDir+entry.fileName+".html"))
+			if created := opmlEntryTime(entry); created != "" {
+				fmt.Fprintf(&buf, " created=\"%s\"", created)
+			}
+			if entry.permalink != "" {
+				fmt.Fprintf(&buf, " permalink=\"%s\"", html.EscapeString(entry.permalink))
+			}
+			if level := entry.securityLevel(); level != securityPublic {
+				fmt.Fprintf(&buf, " security=\"%s\"", level)
+			}
+			buf.WriteString("/>\n")
+		}
+		buf.WriteString("</outline>\n")
+	}
+	if len(months) != 0 {
+		buf.WriteString("</outline>\n")
+	}
+	buf.WriteString("</body>\n</opml>\n")
+	path := filepath.Join(ex.outDir, portableFileName(ex.name)+".opml")
+	if err := writeFileTempRename(path, buf.Bytes()); err != nil {
+		return WrapErr(err, "")
+	}
+	return nil
+}
+
+func init() {
+	registerExportFunc("opml", "OPML outline of entry titles", exportOpml)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_exportOpml(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ex := &exportJournal{
+		config: &Config{},
+		name:   "bob",
+		outDir: dir,
+		entries: []*archivedEntry{
+			{itemId: 1, fileName: "L-1", eventTime: "2009-03-05 14:22:00", subject: "Tom & Jerry", permalink: "https://bob.example.com/257.html"},
+			{itemId: 2, fileName: "L-2", eventTime: "2009-03-07 10:00:00", subject: "Second", security: "private"},
+			{itemId: 3, fileName: "L-3", eventTime: "2010-01-01 00:00:00"},
+		},
+	}
+	if r := exportOpml(ex); r != nil {
+		t.Fatal(r.AsText())
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "bob.opml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<body>
+<outline text="2009 (2)">
+<outline text="March (2)">
+<outline text="Tom &amp; Jerry" type="link" url="../../html/bob/entries/L-1.html" created="Thu, 05 Mar 2009 14:22:00 +0000" permalink="https://bob.example.com/257.html"/>
+<outline text="Second" type="link" url="../../html/bob/entries/L-2.html" created="Sat, 07 Mar 2009 10:00:00 +0000" security="private"/>
+</outline>
+</outline>
+<outline text="2010 (1)">
+<outline text="January (1)">
+<outline text="(no subject, L-3)" type="link" url="../../html/bob/entries/L-3.html" created="Fri, 01 Jan 2010 00:00:00 +0000"/>
+</outline>
+</outline>
+</body>
+</opml>
+`
+	if !strings.HasSuffix(string(data), expected) {
+		t.Errorf("Unexpected outline:\n%s", data)
+	}
+}
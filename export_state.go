@@ -0,0 +1,176 @@
+package main
+
+import (
+	"linedb"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Exports into a directory that already has an export of the same format
+// rewrite only the files of entries whose entry or comment files changed
+// since the last export. export-state.linedb in the output directory keeps
+// the latest modification time of the archived files seen by the previous
+// export and the options that affect the output. When the options differ,
+// or with -full, all files are written again. Index files are always
+// rewritten.
+//
+// An export can run while a dump stores files. The modification times are
+// taken before the files are read and the latest time advances only when
+// no exported file changed during the export and no commit of a dump step
+// is in progress at its end. Otherwise the state keeps the previous time,
+// so the next export checks the files again. See transaction.go for the
+// commits.
+
+const exportStateFileName = "export-state.linedb"
+
+// Bump when the output of the formats changes so the next export after an
+// upgrade writes everything
+const exportOutputVersion = "4"
+
+type exportState struct {
+	// Modification time in nanoseconds since the epoch in whole seconds,
+	// see exportModTime
+	highWater int64
+	options   string
+}
+
+func exportOptionsKey(config *Config) string {
+	zone := ""
+	if config.timeZone != nil {
+		zone = config.timeZone.String()
+	}
+	key := exportOutputVersion + " " + config.documentLanguage() + " " + zone + " " + config.maxSecurity.String()
+	if config.collapseDuplicates {
+		key += " collapse-duplicates"
+	}
+	if config.anonymizeComments {
+		key += " anonymize"
+	}
+	if config.exportGroup != nil {
+		key += " group " + strconv.Itoa(config.exportGroup.id)
+	}
+	return key
+}
+
+func readExportState(outDir string) (*exportState, *Report) {
+	path := filepath.Join(outDir, exportStateFileName)
+	data, err := archiveStore.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, WrapErr(err, "")
+	}
+	state := &exportState{}
+	d := newLinedbDecoder(data)
+	for d.NextItem() {
+		if d.ItemKind == linedb.ScalarItem {
+			switch d.ItemName {
+			case "highWater":
+				// Files from before the truncation have nanoseconds
+				state.highWater = d.GetInt64() / int64(time.Second) * int64(time.Second)
+			case "options":
+				state.options = d.GetString()
+			}
+		}
+	}
+	if err := d.GetError(); err != nil {
+		log("WARNING: ignoring %s that cannot be parsed - %s", path, err.Error())
+		return nil, nil
+	}
+	return state, nil
+}
+
+func writeExportState(outDir string, state *exportState) *Report {
+	e := linedb.NewByteEncoder()
+	e.Scalar("highWater").AddInt64(state.highWater)
+	e.Scalar("options").AddString(state.options)
+	if err := writeFileTempRename(filepath.Join(outDir, exportStateFileName), e.GetBytes()); err != nil {
+		return WrapErr(err, "")
+	}
+	return nil
+}
+
+// Get the modification times of the entry and comment files of the journal
+// by their paths
+func archivedModTimes(dir string) (map[string]int64, *Report) {
+	files, err := listDumpFiles(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, WrapErr(err, "failed to read journal directory %s", dir)
+	}
+	modTimes := make(map[string]int64, len(files))
+	for _, file := range files {
+		path := filepath.Join(dir, file)
+		if info, err := archiveStore.Stat(path); err == nil {
+			modTimes[path] = exportModTime(info)
+		}
+	}
+	return modTimes, nil
+}
+
+// Get the latest time of the files of the exported entries in modTimes.
+// Return false when a file changed since modTimes was taken or a dump
+// commit is in progress.
+func (ex *exportJournal) exportedHighWater(modTimes map[string]int64) (int64, bool, *Report) {
+	if committing, r := commitInProgress(ex.dir); r != nil || committing {
+		return 0, false, r
+	}
+	var highWater int64
+	for _, entry := range ex.entries {
+		for _, path := range entryFilePaths(entry) {
+			listed := modTimes[path]
+			current := int64(0)
+			if info, err := archiveStore.Stat(path); err == nil {
+				current = exportModTime(info)
+			}
+			if current != listed {
+				return 0, false, nil
+			}
+			if listed > highWater {
+				highWater = listed
+			}
+		}
+	}
+	return highWater, true, nil
+}
+
+func entryFilePaths(entry *archivedEntry) []string {
+	return []string{filepath.Join(entry.dir, entry.fileName), commentFilePath(entry.dir, entry.itemId)}
+}
+
+// Get the modification time of the file truncated to seconds. WebDAV
+// servers report times in seconds, so a file rewritten in the same second
+// as the recorded time has the same time and is written again, see
+// entryUnchanged.
+func exportModTime(info os.FileInfo) int64 {
+	return info.ModTime().Truncate(time.Second).UnixNano()
+}
+
+// Get the latest modification time of the entry and its comments
+func (ex *exportJournal) entryModTime(entry *archivedEntry) int64 {
+	var latest int64
+	for _, path := range entryFilePaths(entry) {
+		if info, err := archiveStore.Stat(path); err == nil && exportModTime(info) > latest {
+			latest = exportModTime(info)
+		}
+	}
+	return latest
+}
+
+// Check if the output file of the entry from the previous export is still
+// current. Formats call this before rendering a file for the entry.
+func (ex *exportJournal) entryUnchanged(entry *archivedEntry, outPath string) bool {
+	if ex.since == 0 || ex.entryModTime(entry) >= ex.since {
+		return false
+	}
+	if _, err := archiveStore.Stat(outPath); err != nil {
+		return false
+	}
+	ex.unchanged++
+	return true
+}
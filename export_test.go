@@ -0,0 +1,565 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_incrementalExport(t *testing.T) {
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	journalDir := filepath.Join(dumpDir, "bob")
+	if err := os.Mkdir(journalDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	writeEntry := func(name, subject string) {
+		entry := `<?xml version="1.0"?><event><eventtime>2010-05-01 10:00:00</eventtime><subject>` + subject + `</subject><event>text</event></event>`
+		if err := ioutil.WriteFile(filepath.Join(journalDir, name), []byte(entry), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeEntry("L-1", "One")
+	writeEntry("L-2", "Two")
+
+	config := &Config{
+		dumpDir:        dumpDir,
+		journals:       []string{"bob"},
+		journalAliases: make(map[string]string),
+		exportFormat:   "markdown",
+		exportDir:      filepath.Join(dumpDir, "export"),
+		maxSecurity:    securityPrivate,
+	}
+	outDir := filepath.Join(config.exportDir, "markdown", "bob")
+	export := func() {
+		if r := runExport(config); r != nil {
+			t.Fatal(r.AsText())
+		}
+	}
+	export()
+
+	// Make the export older than the archive update that follows. L-2 is
+	// older than the recorded time as files of the same second are
+	// written again.
+	old := time.Now().Add(-time.Hour)
+	for i, name := range []string{"L-1", "L-2"} {
+		modTime := old.Add(time.Duration(-2*i) * time.Second)
+		if err := os.Chtimes(filepath.Join(journalDir, name), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	export()
+	if err := os.Remove(filepath.Join(outDir, "L-1.md")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(outDir, "L-2.md"), []byte("stale"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	export()
+	if _, err := os.Stat(filepath.Join(outDir, "L-1.md")); err != nil {
+		t.Errorf("Missing output file was not written again - %s", err.Error())
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(outDir, "L-2.md")); string(data) != "stale" {
+		t.Errorf("Unchanged entry was written again")
+	}
+
+	writeEntry("L-2", "Two updated")
+	export()
+	if data, _ := ioutil.ReadFile(filepath.Join(outDir, "L-2.md")); string(data) == "stale" {
+		t.Errorf("Changed entry was not written")
+	}
+
+	// The time does not advance while a dump commits files
+	readHighWater := func() int64 {
+		state, r := readExportState(outDir)
+		if r != nil || state == nil {
+			t.Fatalf("Missing export state %v", r)
+		}
+		return state.highWater
+	}
+	highWater := readHighWater()
+	pendingList := filepath.Join(journalDir, pendingWritesFileName)
+	if err := ioutil.WriteFile(pendingList, nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(journalDir, "L-1"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	export()
+	if readHighWater() != highWater {
+		t.Errorf("Expected the time to stay during a commit")
+	}
+	if err := os.Remove(pendingList); err != nil {
+		t.Fatal(err)
+	}
+	export()
+	if readHighWater() != future.Truncate(time.Second).UnixNano() {
+		t.Errorf("Expected the time of the updated entry after the commit")
+	}
+
+	// An entry rewritten in the same second as the recorded time is
+	// written again as WebDAV servers report times in seconds
+	if err := ioutil.WriteFile(filepath.Join(outDir, "L-1.md"), []byte("stale"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	export()
+	if data, _ := ioutil.ReadFile(filepath.Join(outDir, "L-1.md")); string(data) == "stale" {
+		t.Errorf("Entry with the recorded time was not written again")
+	}
+}
+
+// Records the calls as lines
+type recordingExporter struct {
+	calls []string
+}
+
+func (e *recordingExporter) Begin(ex *exportJournal) *Report {
+	e.calls = append(e.calls, "begin "+ex.name)
+	return nil
+}
+
+func (e *recordingExporter) Entry(entry *archivedEntry) *Report {
+	e.calls = append(e.calls, fmt.Sprintf("entry %d", entry.itemId))
+	return nil
+}
+
+func (e *recordingExporter) Media(entry *archivedEntry, url string, path string) *Report {
+	e.calls = append(e.calls, fmt.Sprintf("media %d %s %s", entry.itemId, url, filepath.Base(path)))
+	return nil
+}
+
+func (e *recordingExporter) Comment(entry *archivedEntry, c *CommentRecord) *Report {
+	e.calls = append(e.calls, fmt.Sprintf("comment %d %d", entry.itemId, c.Id))
+	return nil
+}
+
+func (e *recordingExporter) End() *Report {
+	e.calls = append(e.calls, "end")
+	return nil
+}
+
+func Test_runExporter(t *testing.T) {
+	if findExportFormat("blogger") == nil {
+		t.Errorf("blogger format is not registered")
+	}
+
+	dir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	comments := `<comments><comment><id>7</id><body>b</body></comment><comment><id>3</id><body>a</body></comment></comments>`
+	if err := ioutil.WriteFile(filepath.Join(dir, "C-1"), []byte(comments), 0666); err != nil {
+		t.Fatal(err)
+	}
+	ex := &exportJournal{
+		config: &Config{},
+		name:   "bob",
+		dir:    dir,
+		entries: []*archivedEntry{
+			{itemId: 1, dir: dir, event: `<img src="http://a/x.jpg"><img src="http://a/y.jpg">`},
+			{itemId: 2, dir: dir},
+		},
+		media:     map[string]*mediaItem{"http://a/x.jpg": {url: "http://a/x.jpg", file: "x.jpg"}},
+		usedMedia: make(map[string]bool),
+	}
+	exporter := &recordingExporter{}
+	if r := runExporter(ex, exporter); r != nil {
+		t.Fatal(r.AsText())
+	}
+	expected := "begin bob|entry 1|media 1 http://a/x.jpg x.jpg|comment 1 3|comment 1 7|entry 2|end"
+	if got := strings.Join(exporter.calls, "|"); got != expected {
+		t.Errorf("Unexpected calls %s", got)
+	}
+}
+
+func Test_exportContentFlags(t *testing.T) {
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	journalDir := filepath.Join(dumpDir, "bob")
+	if err := os.Mkdir(journalDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"L-1": `<?xml version="1.0"?><event><itemid>1</itemid><eventtime>2010-05-01 10:00:00</eventtime><subject>Night</subject><event>text</event>` +
+			`<props><adult_content>explicit</adult_content><opt_screening>A</opt_screening></props></event>`,
+		"C-1": `<?xml version="1.0"?><comments><comment><id>5</id><state>S</state><user>alice</user><body>Hidden</body></comment></comments>`,
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(journalDir, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An index written before the flags were recorded
+	index := newEntriesIndex()
+	index.rows[1] = &entryIndexRow{itemId: 1, date: "2010-05-01 10:00:00", file: "L-1"}
+	data := strings.Replace(string(encodeEntriesIndex(index)), "contentFlags", "unknownTable", -1)
+	if err := ioutil.WriteFile(filepath.Join(journalDir, entriesIndexFileName), []byte(data), 0666); err != nil {
+		t.Fatal(err)
+	}
+	index, r := loadEntriesIndex(journalDir)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if row := index.rows[1]; row.adultContent != "explicit" || row.screening != "A" || !index.changed {
+		t.Errorf("Flags of the entry were not indexed %+v", row)
+	}
+	if r := writeEntriesIndex(journalDir, index); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if index, r = readEntriesIndex(journalDir); r != nil || !index.hasContentFlags || index.rows[1].adultContent != "explicit" {
+		t.Errorf("Flags were not read back from the index")
+	}
+
+	config := &Config{
+		dumpDir:        dumpDir,
+		journals:       []string{"bob"},
+		journalAliases: make(map[string]string),
+		exportFormat:   "html",
+		exportDir:      filepath.Join(dumpDir, "export"),
+		maxSecurity:    securityPrivate,
+	}
+	if r := runExport(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	page, err := ioutil.ReadFile(filepath.Join(config.exportDir, "html", "bob", "entries", "L-1.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`<details class="adult-content"><summary>Explicit adult content: open to read the entry</summary>`,
+		"All comments are screened",
+		"<details><summary class=\"meta\">Screened comment by alice",
+	} {
+		if !strings.Contains(string(page), expected) {
+			t.Errorf("Entry page does not contain %q:\n%s", expected, page)
+		}
+	}
+}
+
+func Test_exportCommentsForOthers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	comments := `<comments>` +
+		`<comment><id>1</id><body>a</body></comment>` +
+		`<comment><id>2</id><state>S</state><body>screened</body></comment>` +
+		`<comment><id>3</id><state>D</state><deleted>2020-01-01T00:00:00Z</deleted><body>gone</body></comment>` +
+		`<comment><id>4</id><state>D</state></comment>` +
+		`</comments>`
+	if err := ioutil.WriteFile(filepath.Join(dir, "C-1"), []byte(comments), 0666); err != nil {
+		t.Fatal(err)
+	}
+	entry := &archivedEntry{itemId: 1, dir: dir}
+	for _, level := range []securityLevel{securityPrivate, securityFriends, securityPublic} {
+		ex := &exportJournal{config: &Config{maxSecurity: level}, name: "bob", dir: dir}
+		got, r := ex.comments(entry)
+		if r != nil {
+			t.Fatal(r.AsText())
+		}
+		expected := 1
+		if level == securityPrivate {
+			expected = 4
+		}
+		if len(got) != expected {
+			t.Errorf("Expected %d comments with -max-security %s, got %+v", expected, level.label(), got)
+		}
+	}
+}
+
+func Test_securityLevel(t *testing.T) {
+	cases := []struct {
+		security  string
+		allowMask int64
+		level     securityLevel
+		label     string
+	}{
+		{"", 0, securityPublic, "Public"},
+		{"public", 0, securityPublic, "Public"},
+		{"usemask", 1, securityFriends, "Friends only"},
+		{"usemask", 1 << 3, securityCustom, "Custom friend groups"},
+		{"usemask", 1 | 1<<3, securityCustom, "Custom friend groups"},
+		{"private", 0, securityPrivate, "Private"},
+	}
+	for _, c := range cases {
+		entry := archivedEntry{security: c.security, allowMask: c.allowMask}
+		level := entry.securityLevel()
+		if level != c.level || level.label() != c.label {
+			t.Errorf("%s %d: expected %s %q, got %s %q", c.security, c.allowMask, c.level, c.label, level, level.label())
+		}
+		if parsed, err := parseSecurityLevel(level.String()); err != nil || parsed != level {
+			t.Errorf("%s does not parse back, got %v %v", level, parsed, err)
+		}
+	}
+	if _, err := parseSecurityLevel("friends-only"); err == nil {
+		t.Errorf("Expected an error for an unknown level")
+	}
+}
+
+func Test_exportSecurityFilters(t *testing.T) {
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	journalDir := filepath.Join(dumpDir, "bob")
+	if err := os.Mkdir(journalDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	entries := []struct {
+		security  string
+		allowMask int
+	}{
+		{"public", 0},
+		{"usemask", 1},
+		{"usemask", 1 << 2},
+		{"usemask", 1 << 3},
+		{"private", 0},
+	}
+	for i, e := range entries {
+		entry := fmt.Sprintf(`<?xml version="1.0"?><event><itemid>%d</itemid><eventtime>2010-05-0%d 10:00:00</eventtime>`+
+			`<subject>Entry %d</subject><event>text</event><security>%s</security><allowmask>%d</allowmask></event>`,
+			i+1, i+1, i+1, e.security, e.allowMask)
+		if err := ioutil.WriteFile(filepath.Join(journalDir, fmt.Sprintf("L-%d", i+1)), []byte(entry), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// -public-only is -max-security public. A friend group limits the
+	// export to entries visible to the group.
+	closeGroup := &friendGroup{2, "Close", false}
+	cases := []struct {
+		name        string
+		maxSecurity securityLevel
+		group       *friendGroup
+		exported    []int
+	}{
+		{"all", securityPrivate, nil, []int{1, 2, 3, 4, 5}},
+		{"public-only", securityPublic, nil, []int{1}},
+		{"max-security friends", securityFriends, nil, []int{1, 2}},
+		{"max-security custom", securityCustom, nil, []int{1, 2, 3, 4}},
+		{"group", securityPrivate, closeGroup, []int{1, 2, 3}},
+		{"group and max-security friends", securityFriends, closeGroup, []int{1, 2}},
+		{"group and public-only", securityPublic, closeGroup, []int{1}},
+	}
+	for _, c := range cases {
+		for _, format := range []string{"html", "markdown"} {
+			config := &Config{
+				dumpDir:        dumpDir,
+				journals:       []string{"bob"},
+				journalAliases: make(map[string]string),
+				exportFormat:   format,
+				exportDir:      filepath.Join(dumpDir, "export-"+strings.Replace(c.name, " ", "-", -1)),
+				maxSecurity:    c.maxSecurity,
+				exportGroup:    c.group,
+			}
+			if r := runExport(config); r != nil {
+				t.Fatal(r.AsText())
+			}
+			for itemId := 1; itemId <= len(entries); itemId++ {
+				expected := false
+				for _, id := range c.exported {
+					expected = expected || id == itemId
+				}
+				path := filepath.Join(config.exportDir, "markdown", "bob", fmt.Sprintf("L-%d.md", itemId))
+				if format == "html" {
+					path = filepath.Join(config.exportDir, "html", "bob", "entries", fmt.Sprintf("L-%d.html", itemId))
+				}
+				data, err := ioutil.ReadFile(path)
+				if expected != (err == nil) {
+					t.Errorf("%s %s: unexpected presence %v of L-%d", c.name, format, err == nil, itemId)
+				}
+				if err != nil {
+					continue
+				}
+				level := (&archivedEntry{security: entries[itemId-1].security, allowMask: int64(entries[itemId-1].allowMask)}).securityLevel()
+				// Only the front matter labels public entries
+				label := level.label()
+				if format == "markdown" {
+					label = "security: " + level.String()
+				} else if level == securityPublic {
+					continue
+				}
+				if !strings.Contains(string(data), label) {
+					t.Errorf("%s %s: L-%d is not labeled as %q:\n%s", c.name, format, itemId, label, data)
+				}
+			}
+		}
+	}
+}
+
+func Test_commenterLabel(t *testing.T) {
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	config := &Config{username: "bob", accountDataDir: dumpDir}
+	if friends, r := readFriendsData(config); r != nil || friends != nil {
+		t.Fatalf("Expected no friends before they were archived, got %+v %v", friends, r)
+	}
+	if label := commenterLabel(config, nil, &CommentRecord{User: "alice"}); label != "alice" {
+		t.Errorf("Expected no relationship without archived friends, got %s", label)
+	}
+	friends := &friendsData{
+		fetched: "2020-01-01T10:00:00Z",
+		friends: map[string]*friendInfo{
+			"alice": {"alice", "Alice", "", 1},
+			"carol": {"carol", "", "", 1},
+		},
+		friendOfs: map[string]*friendInfo{
+			"alice": {"alice", "Alice", "", 0},
+			"dave":  {"dave", "", "", 0},
+		},
+	}
+	if r := writeFriendsData(config, friends); r != nil {
+		t.Fatal(r.AsText())
+	}
+	friends, r := readFriendsData(config)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	cases := map[string]string{
+		"":        "(anonymous)",
+		"bob":     "bob (self)",
+		"alice":   "alice (mutual friend)",
+		"carol":   "carol (friend)",
+		"dave":    "dave (friend-of)",
+		"eve":     "eve (stranger)",
+		"ext_123": "ext_123 (OpenID) (stranger)",
+	}
+	for user, expected := range cases {
+		if label := commenterLabel(config, friends, &CommentRecord{User: user}); label != expected {
+			t.Errorf("%q: expected %q, got %q", user, expected, label)
+		}
+	}
+}
+
+func Test_exportCommentsJsonl(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"C-1": `<comments>` +
+			`<comment><id>2</id><user>alice</user><date>2010-05-01T10:00:00Z</date><subject>Re</subject><body>&lt;b&gt;Hi&lt;/b&gt; &amp; bye</body></comment>` +
+			`<comment><id>3</id><parentid>2</parentid><date>2010-05-01T11:00:00Z</date><body>Reply</body></comment>` +
+			`</comments>`,
+		"C-4": `<comments><comment><id>9</id><parentid>0</parentid><user>bob</user><state>D</state><deleted>2020-01-01T00:00:00Z</deleted><body>gone</body></comment></comments>`,
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ex := &exportJournal{
+		config:  &Config{maxSecurity: securityPrivate},
+		name:    "bob",
+		dir:     dir,
+		outDir:  dir,
+		entries: []*archivedEntry{{itemId: 1, dir: dir}, {itemId: 4, dir: dir}, {itemId: 5, dir: dir}},
+	}
+	if r := exportCommentsJsonl(ex); r != nil {
+		t.Fatal(r.AsText())
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "comments.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		`{"id":2,"jitemid":1,"parentid":null,"user":"alice","date":"2010-05-01T10:00:00Z","state":"","subject":"Re","body":"<b>Hi</b> & bye"}`,
+		`{"id":3,"jitemid":1,"parentid":2,"user":"","date":"2010-05-01T11:00:00Z","state":"","subject":"","body":"Reply"}`,
+		`{"id":9,"jitemid":4,"parentid":null,"user":"bob","date":"","state":"D","subject":"","body":"gone","deleted":"2020-01-01T00:00:00Z"}`,
+	}
+	if got := strings.TrimSuffix(string(data), "\n"); got != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected comments.jsonl:\n%s", got)
+	}
+}
+
+func Test_bloggerExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	comments := `<comments>` +
+		`<comment><id>2</id><user>alice</user><date>2010-05-01T11:00:00Z</date><body>Nice &amp; fine</body></comment>` +
+		`<comment><id>3</id><parentid>2</parentid><date>2010-05-01 12:00:00</date><body>Anonymous</body></comment>` +
+		`<comment><id>4</id><state>S</state><user>carol</user><body>screened</body></comment>` +
+		`<comment><id>5</id><state>D</state><body>deleted</body></comment>` +
+		`</comments>`
+	if err := ioutil.WriteFile(filepath.Join(dir, "C-1"), []byte(comments), 0666); err != nil {
+		t.Fatal(err)
+	}
+	ex := &exportJournal{
+		config: &Config{maxSecurity: securityPrivate},
+		name:   "bob",
+		dir:    dir,
+		outDir: dir,
+		entries: []*archivedEntry{
+			{itemId: 1, dir: dir, eventTime: "2010-05-01 10:00:00", subject: "A <b>trip</b>", event: "Text", props: map[string]string{"taglist": "travel, fun"}},
+			{itemId: 2, dir: dir, eventTime: "2010-05-02 10:00:00", subject: "Secret", event: "Text", security: "private"},
+		},
+		usedMedia: make(map[string]bool),
+	}
+	if r := runExporter(ex, &bloggerExporter{}); r != nil {
+		t.Fatal(r.AsText())
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "blogger.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var feed struct {
+		Entries []struct {
+			Id        string `xml:"id"`
+			Published string `xml:"published"`
+			Title     string `xml:"title"`
+			Author    string `xml:"author>name"`
+			Draft     string `xml:"control>draft"`
+			InReplyTo struct {
+				Ref string `xml:"ref,attr"`
+			} `xml:"in-reply-to"`
+			Categories []struct {
+				Term string `xml:"term,attr"`
+			} `xml:"category"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal(data, &feed); err != nil {
+		t.Fatalf("The feed is not well-formed - %s\n%s", err.Error(), data)
+	}
+	if len(feed.Entries) != 4 {
+		t.Fatalf("Expected 2 posts and 2 comments, got %d entries:\n%s", len(feed.Entries), data)
+	}
+	post, comment, reply, private := feed.Entries[0], feed.Entries[1], feed.Entries[2], feed.Entries[3]
+	if post.Id != "tag:blogger.com,1999:blog-bob.post-1" || post.Title != "A <b>trip</b>" || post.Author != "bob" ||
+		post.Published != "2010-05-01T10:00:00Z" || post.Draft != "" || len(post.Categories) != 3 ||
+		post.Categories[0].Term != bloggerKindPost || post.Categories[1].Term != "travel" {
+		t.Errorf("Unexpected post %+v", post)
+	}
+	if comment.Author != "alice" || comment.InReplyTo.Ref != post.Id || comment.Published != "2010-05-01T11:00:00Z" ||
+		comment.Categories[0].Term != bloggerKindComment {
+		t.Errorf("Unexpected comment %+v", comment)
+	}
+	if reply.Author != "Anonymous" || reply.InReplyTo.Ref != post.Id || reply.Published != "2010-05-01T12:00:00Z" {
+		t.Errorf("Unexpected reply %+v", reply)
+	}
+	if private.Draft != "yes" {
+		t.Errorf("Private entry is not a draft %+v", private)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// The text format writes each entry into <file>.txt as UTF-8 plain text
+// without any markup: a header with the subject, date, security, tags and
+// permalink, the text with HTML removed and then the comments with replies
+// indented under the comment they answer. index.txt lists the entries with
+// their dates and files. The files are meant for grep and for reading
+// without any software decades later.
+
+const textIndent = "    "
+
+func writeTextEntry(ex *exportJournal, entry *archivedEntry, buf *bytes.Buffer) *Report {
+	subject := entryDisplaySubject(entry)
+	buf.WriteString(subject + "\n" + strings.Repeat("=", len([]rune(subject))) + "\n\n")
+	fmt.Fprintf(buf, "Date: %s\n", ex.config.formatDate(entry.eventTime))
+	if level := entry.securityLevel(); level != securityPublic {
+		fmt.Fprintf(buf, "Security: %s\n", level.label())
+	}
+	if tags := entry.tags(); len(tags) != 0 {
+		fmt.Fprintf(buf, "Tags: %s\n", strings.Join(tags, ", "))
+	}
+	if mood := entry.props["current_mood"]; mood != "" {
+		fmt.Fprintf(buf, "Mood: %s\n", mood)
+	}
+	if adult := adultContentLabel(entry.adultContent); adult != "" {
+		fmt.Fprintf(buf, "Content: %s\n", adult)
+	}
+	if entry.permalink != "" {
+		fmt.Fprintf(buf, "URL: %s\n", entry.permalink)
+	}
+	buf.WriteString("\n" + htmlToText(entryHtml(ex.config, entry)) + "\n")
+
+	comments, r := ex.comments(entry)
+	if r != nil {
+		return r
+	}
+	if len(comments) == 0 {
+		return nil
+	}
+	buf.WriteString("\n\nComments\n--------\n")
+	if screening := screeningLabel(entry.screening); screening != "" {
+		buf.WriteString("\n" + screening + "\n")
+	}
+	for _, thread := range threadComments(comments) {
+		c := thread.comment
+		indent := strings.Repeat(textIndent, thread.depth)
+		header := ex.commenter(c) + ", " + ex.config.formatDate(c.Date)
+		if c.Subject != "" {
+			header += " - " + c.Subject
+		}
+		if isScreenedComment(c) {
+			header += " (screened)"
+		}
+		if deleted := commentDeletedLabel(ex.config, c); deleted != "" {
+			header += " (" + deleted + ")"
+		}
+		buf.WriteString("\n" + indent + header + "\n")
+		for _, line := range strings.Split(htmlToText(commentHtml(ex.config, c)), "\n") {
+			buf.WriteString(strings.TrimRight(indent+textIndent+line, " ") + "\n")
+		}
+	}
+	return nil
+}
+
+func exportText(ex *exportJournal) *Report {
+	var index bytes.Buffer
+	fmt.Fprintf(&index, "%s\n", ex.name)
+	lastYear := -1
+	for _, entry := range ex.entries {
+		year, _ := entry.yearMonth()
+		if year != lastYear {
+			fmt.Fprintf(&index, "\n%d\n\n", year)
+			lastYear = year
+		}
+		fileName := entry.fileName + ".txt"
+		label := ""
+		if level := entry.securityLevel(); level != securityPublic {
+			label = " (" + level.label() + ")"
+		}
+		fmt.Fprintf(&index, "%s  %s  %s%s\n", ex.config.formatDate(entry.eventTime), fileName, entryDisplaySubject(entry), label)
+
+		outPath := filepath.Join(ex.outDir, fileName)
+		if ex.entryUnchanged(entry, outPath) {
+			continue
+		}
+		var buf bytes.Buffer
+		if r := writeTextEntry(ex, entry, &buf); r != nil {
+			return r
+		}
+		if err := writeFileTempRename(outPath, buf.Bytes()); err != nil {
+			return WrapErr(err, "")
+		}
+	}
+	if err := writeFileTempRename(filepath.Join(ex.outDir, "index.txt"), index.Bytes()); err != nil {
+		return WrapErr(err, "")
+	}
+	return nil
+}
+
+func init() {
+	registerExportFunc("text", "plain text files", exportText)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_exportText(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	comments := `<comments><comment><id>5</id><user>alice</user><date>2009-03-05T15:00:00Z</date><body>Nice&lt;br&gt;one</body></comment>` +
+		`<comment><id>6</id><parentid>5</parentid><user>bob</user><date>2009-03-05T16:00:00Z</date><subject>Re</subject><body>Thanks</body></comment></comments>`
+	if err := ioutil.WriteFile(filepath.Join(dir, "C-1"), []byte(comments), 0666); err != nil {
+		t.Fatal(err)
+	}
+	ex := &exportJournal{
+		config: &Config{},
+		name:   "bob",
+		dir:    dir,
+		outDir: dir,
+		entries: []*archivedEntry{{
+			itemId: 1, dir: dir, fileName: "L-1", eventTime: "2009-03-05 14:22:00", subject: "Spring",
+			security: "private", event: "<p>First <b>bold</b></p><p>Second &amp; last</p>",
+			props: map[string]string{"taglist": "a, b"},
+		}},
+	}
+	if r := exportText(ex); r != nil {
+		t.Fatal(r.AsText())
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "L-1.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `Spring
+======
+
+Date: 2009-03-05 14:22:00
+Security: Private
+Tags: a, b
+
+First bold
+
+Second & last
+
+
+Comments
+--------
+
+alice, 2009-03-05T15:00:00Z
+    Nice
+    one
+
+    bob, 2009-03-05T16:00:00Z - Re
+        Thanks
+`
+	if string(data) != expected {
+		t.Errorf("Unexpected text:\n%s", data)
+	}
+	index, err := ioutil.ReadFile(filepath.Join(dir, "index.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(index) != "bob\n\n2009\n\n2009-03-05 14:22:00  L-1.txt  Spring (Private)\n" {
+		t.Errorf("Unexpected index:\n%s", index)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"path/filepath"
+)
+
+// Exporter writes an export format from the journal data that runExporter
+// passes to it in order: Begin once, then for each entry in chronological
+// order Entry, Media for each archived image of the entry and Comment for
+// each comment sorted by id, and finally End. A new format implements it
+// in its own file and registers itself from an init function with
+// registerExporter, which makes it available to export -format. Formats
+// that need the whole journal at once register their function with
+// registerExportFunc instead.
+type Exporter interface {
+	Begin(ex *exportJournal) *Report
+	Entry(entry *archivedEntry) *Report
+
+	// The url is the original image URL in the entry and path the archived
+	// copy
+	Media(entry *archivedEntry, url string, path string) *Report
+	Comment(entry *archivedEntry, c *CommentRecord) *Report
+	End() *Report
+}
+
+// Add the export format. newExporter is called for each exported journal.
+func registerExporter(name string, summary string, newExporter func() Exporter) {
+	registerExportFunc(name, summary, func(ex *exportJournal) *Report {
+		return runExporter(ex, newExporter())
+	})
+}
+
+// Add the export format that writes the whole journal in one call, for
+// formats that do not follow the order of Exporter
+func registerExportFunc(name string, summary string, export func(ex *exportJournal) *Report) {
+	if findExportFormat(name) != nil {
+		panic("duplicated export format " + name)
+	}
+	exportFormats = append(exportFormats, &exportFormat{name, summary, export})
+}
+
+func runExporter(ex *exportJournal, exporter Exporter) *Report {
+	if r := exporter.Begin(ex); r != nil {
+		return r
+	}
+	for _, entry := range ex.entries {
+		if r := exporter.Entry(entry); r != nil {
+			return r
+		}
+		for _, url := range htmlImageUrls(entry.event) {
+			src, r := ex.imageSrc(url)
+			if r != nil {
+				return r
+			}
+			if src == url {
+				continue
+			}
+			if r := exporter.Media(entry, url, filepath.Join(ex.dir, filepath.FromSlash(src))); r != nil {
+				return r
+			}
+		}
+		comments, r := ex.comments(entry)
+		if r != nil {
+			return r
+		}
+		for i := range comments {
+			if r := exporter.Comment(entry, &comments[i]); r != nil {
+				return r
+			}
+		}
+	}
+	return exporter.End()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Journal names become directory names. LJ names are safe on Unix, but
+// Windows rejects some characters and device names like con or aux that
+// are valid LJ names, and Windows and macOS compare names ignoring case.
+
+var windowsInvalidFileNameChars = regexp.MustCompile(`[<>:"/\\|?*\x00-\x1f]`)
+var windowsReservedFileName = regexp.MustCompile(`(?i)^(con|prn|aux|nul|com[1-9]|lpt[1-9])(\..*)?$`)
+
+// LJ names consist of ASCII letters, digits and underscores. Servers
+// accept a dash in place of an underscore, so names of renamed users and
+// communities can be given either way, and Dreamwidth names accounts of
+// OpenID users ext_<number>. Names are checked before they are joined into
+// file paths, so a name like ../../etc never leaves the dump directory.
+var journalNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,50}$`)
+
+func checkJournalName(name string) error {
+	if !journalNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid journal name '%s', it must be 1-50 ASCII letters, digits, _ or -", name)
+	}
+	return nil
+}
+
+// The server ignores case in journal names and treats - as _
+func canonicalJournalName(name string) string {
+	return strings.ToLower(strings.Replace(name, "-", "_", -1))
+}
+
+// Convert a name from the server into a file name that is valid on all
+// platforms. Names that are already valid are returned as is.
+func portableFileName(name string) string {
+	s := windowsInvalidFileNameChars.ReplaceAllString(name, "_")
+
+	// Windows drops trailing dots and spaces
+	if trimmed := strings.TrimRight(s, ". "); trimmed != s {
+		s = trimmed + strings.Repeat("_", len(s)-len(trimmed))
+	}
+	if s == "" {
+		return "_"
+	}
+	if windowsReservedFileName.MatchString(s) {
+		s += "_"
+	}
+	return s
+}
+
+// Name of the archive directory of the journal without aliases.
+func (config *Config) journalDirName(journal string) string {
+	name := portableFileName(canonicalJournalName(journal))
+	if name != journal {
+		// Keep using the archive created before the names were converted
+		if info, err := archiveStore.Stat(filepath.Join(config.dumpDir, journal)); err == nil && info.IsDir() {
+			return journal
+		}
+	}
+	return name
+}
+
+// Fail when two journals would share the archive directory on a file
+// system that ignores case.
+func checkJournalDirCollisions(config *Config) *Report {
+	seen := make(map[string]string, len(config.journals))
+	for _, journal := range config.journals {
+		dir := config.journalDir(journal)
+		key := strings.ToLower(dir)
+		if other, present := seen[key]; present && other != journal {
+			return ReportMsg(
+				"journals %s and %s would share the archive directory %s on Windows and macOS where file names ignore case",
+				other, journal, dir,
+			)
+		}
+		seen[key] = journal
+	}
+	return nil
+}
+
+// Record the directories of journals that are not named as the journal in
+// the journal aliases file, so the mapping is visible to other tools and
+// does not change with the conversion rules.
+func recordJournalDirNames(config *Config) *Report {
+	changed := false
+	for _, journal := range config.journals {
+		if config.journalAliases[journal] != "" {
+			continue
+		}
+		if dir := config.journalDirName(journal); dir != journal {
+			log("Archiving journal %s in the directory %s", journal, dir)
+			config.journalAliases[journal] = dir
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return writeJournalAliases(config)
+}
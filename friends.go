@@ -0,0 +1,205 @@
+package main
+
+import (
+	"linedb"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Friends, friend-of lists and friend groups of the account as of the last
+// dump, stored in the account data directory.
+const friendsDBFileName = "friends.linedb"
+
+type friendInfo struct {
+	user      string
+	fullName  string
+	kind      string
+	groupMask int64
+}
+
+type friendGroup struct {
+	id     int
+	name   string
+	public bool
+}
+
+type friendsData struct {
+	fetched   string
+	friends   map[string]*friendInfo
+	friendOfs map[string]*friendInfo
+	groups    []friendGroup
+}
+
+// Relationship of another user to the account owner
+const (
+	relationSelf      = "self"
+	relationMutual    = "mutual friend"
+	relationFriend    = "friend"
+	relationFriendOf  = "friend-of"
+	relationStranger  = "stranger"
+	relationAnonymous = "anonymous"
+)
+
+func (fd *friendsData) relationship(config *Config, user string) string {
+	if user == "" {
+		return relationAnonymous
+	}
+	if user == config.username {
+		return relationSelf
+	}
+	_, isFriend := fd.friends[user]
+	_, isFriendOf := fd.friendOfs[user]
+	switch {
+	case isFriend && isFriendOf:
+		return relationMutual
+	case isFriend:
+		return relationFriend
+	case isFriendOf:
+		return relationFriendOf
+	}
+	return relationStranger
+}
+
+func fetchFriendsData(session *ljSession) (*friendsData, *Report) {
+	type LJFriend struct {
+		Username  string `xmlrpc:"username"`
+		FullName  string `xmlrpc:"fullname"`
+		Type      string `xmlrpc:"type"`
+		GroupMask int64  `xmlrpc:"groupmask"`
+	}
+	type LJFriendGroup struct {
+		Id     int    `xmlrpc:"id"`
+		Name   string `xmlrpc:"name"`
+		Public int    `xmlrpc:"public"`
+	}
+	type LJGetFriendsResult struct {
+		Friends      []LJFriend      `xmlrpc:"friends"`
+		FriendOfs    []LJFriend      `xmlrpc:"friendofs"`
+		FriendGroups []LJFriendGroup `xmlrpc:"friendgroups"`
+	}
+	var result LJGetFriendsResult
+	params := map[string]interface{}{
+		"includefriendof": 1,
+		"includegroups":   1,
+	}
+	if r := callLJXmlRpcMethod(session, "getfriends", params, &result); r != nil {
+		return nil, r
+	}
+
+	fd := &friendsData{
+		fetched:   time.Now().UTC().Format(time.RFC3339),
+		friends:   make(map[string]*friendInfo),
+		friendOfs: make(map[string]*friendInfo),
+	}
+	for _, f := range result.Friends {
+		fd.friends[f.Username] = &friendInfo{f.Username, f.FullName, f.Type, f.GroupMask}
+	}
+	for _, f := range result.FriendOfs {
+		fd.friendOfs[f.Username] = &friendInfo{f.Username, f.FullName, f.Type, 0}
+	}
+	for _, g := range result.FriendGroups {
+		fd.groups = append(fd.groups, friendGroup{g.Id, g.Name, g.Public != 0})
+	}
+	return fd, nil
+}
+
+func addFriendsTable(e *linedb.Encoder, tableName string, m map[string]*friendInfo) {
+	users := make([]string, 0, len(m))
+	for user := range m {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	e.Table(tableName)
+	for _, user := range users {
+		f := m[user]
+		e.AddString(f.user).AddString(f.fullName).AddString(f.kind).AddInt64(f.groupMask).EndRow()
+	}
+	e.EndTable()
+}
+
+func writeFriendsData(config *Config, fd *friendsData) *Report {
+	e := linedb.NewByteEncoder()
+	e.Scalar("fetched").AddString(fd.fetched)
+	e.EmptyLine()
+	e.Comment("user full-name type group-mask")
+	addFriendsTable(e, "friends", fd.friends)
+	e.EmptyLine()
+	e.Comment("user full-name type unused")
+	addFriendsTable(e, "friendOfs", fd.friendOfs)
+	e.EmptyLine()
+	e.Comment("group-id name public")
+	e.Table("groups")
+	for _, g := range fd.groups {
+		public := 0
+		if g.public {
+			public = 1
+		}
+		e.AddInt(g.id).AddString(g.name).AddInt(public).EndRow()
+	}
+	e.EndTable()
+
+	dbpath := filepath.Join(config.accountDataDir, friendsDBFileName)
+	if err := writeFileTempRename(dbpath, e.GetBytes()); err != nil {
+		return WrapErr(err, "failed to write friends file %s", dbpath)
+	}
+	return nil
+}
+
+// Return nil without error if friends were never archived.
+func readFriendsData(config *Config) (*friendsData, *Report) {
+	dbpath := filepath.Join(config.accountDataDir, friendsDBFileName)
+	dbdata, err := archiveStore.ReadFile(dbpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, WrapErr(err, "")
+	}
+	fd := &friendsData{
+		friends:   make(map[string]*friendInfo),
+		friendOfs: make(map[string]*friendInfo),
+	}
+	d := newLinedbDecoder(dbdata)
+	for d.NextItem() {
+		switch d.ItemKind {
+		case linedb.ScalarItem:
+			switch d.ItemName {
+			case "fetched":
+				fd.fetched = d.GetString()
+			}
+		case linedb.TableItem:
+			for d.NextRow() {
+				switch d.ItemName {
+				case "friends", "friendOfs":
+					f := &friendInfo{d.GetString(), d.GetString(), d.GetString(), d.GetInt64()}
+					if d.ItemName == "friends" {
+						fd.friends[f.user] = f
+					} else {
+						fd.friendOfs[f.user] = f
+					}
+				case "groups":
+					fd.groups = append(fd.groups, friendGroup{d.GetInt(), d.GetString(), d.GetInt() != 0})
+				}
+			}
+		}
+	}
+	if err := d.GetError(); err != nil {
+		return nil, WrapErr(err, "error while parsing friends file %s as linedb", dbpath)
+	}
+	return fd, nil
+}
+
+func dumpFriends(session *ljSession) *Report {
+	log("Fetching friends of: %s", session.config.username)
+	fd, r := fetchFriendsData(session)
+	if r != nil {
+		// Relationships are optional for the archive, keep the list from
+		// the previous dump
+		session.config.warn("failed to fetch friends, keeping the previously archived list - %s", r.AsText())
+		return nil
+	}
+	log("%d friends, %d friend-ofs, %d friend groups", len(fd.friends), len(fd.friendOfs), len(fd.groups))
+	return writeFriendsData(session.config, fd)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"linedb"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Journals listed directly under <ljdump> in the config form the group with
+// this name.
+const defaultJournalGroup = "default"
+
+// Named group of journals from the config. The watch command dumps the
+// group each interval.
+type journalGroup struct {
+	name     string
+	journals []string
+	interval time.Duration
+}
+
+func (config *Config) findJournalGroup(name string) *journalGroup {
+	for _, g := range config.journalGroups {
+		if g.name == name {
+			return g
+		}
+	}
+	return nil
+}
+
+func journalGroupNames(groups []*journalGroup) string {
+	names := make([]string, len(groups))
+	for i, g := range groups {
+		names[i] = g.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// Time of the last dump of each group for the watch command so a restart
+// does not dump rarely scheduled groups again.
+const watchStateFileName = "watch-state.linedb"
+
+func readWatchState(config *Config) (map[string]time.Time, *Report) {
+	state := make(map[string]time.Time)
+	dbpath := filepath.Join(config.dumpDir, watchStateFileName)
+	dbdata, err := archiveStore.ReadFile(dbpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, WrapErr(err, "")
+	}
+	d := newLinedbDecoder(dbdata)
+	for d.NextItem() {
+		if d.ItemKind == linedb.TableItem {
+			for d.NextRow() {
+				switch d.ItemName {
+				case "lastDump":
+					name, when := d.GetString(), d.GetString()
+					if t, err := time.Parse(time.RFC3339, when); err == nil {
+						state[name] = t
+					}
+				}
+			}
+		}
+	}
+	if err := d.GetError(); err != nil {
+		return nil, WrapErr(err, "error while parsing watch state file %s as linedb", dbpath)
+	}
+	return state, nil
+}
+
+func writeWatchState(config *Config, state map[string]time.Time) *Report {
+	names := make([]string, 0, len(state))
+	for name := range state {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	e := linedb.NewByteEncoder()
+	e.Comment("group-name last-dump-time")
+	e.Table("lastDump")
+	for _, name := range names {
+		e.AddString(name).AddString(state[name].UTC().Format(time.RFC3339)).EndRow()
+	}
+	e.EndTable()
+	dbpath := filepath.Join(config.dumpDir, watchStateFileName)
+	if err := writeFileTempRename(dbpath, e.GetBytes()); err != nil {
+		return WrapErr(err, "failed to write watch state file %s", dbpath)
+	}
+	return nil
+}
+
+// Get groups whose interval since the last dump has passed by now and the
+// earliest time when one of the remaining groups becomes due. A group that
+// was never dumped is due immediately.
+func dueJournalGroups(groups []*journalGroup, state map[string]time.Time, now time.Time) ([]*journalGroup, time.Time) {
+	var due []*journalGroup
+	var next time.Time
+	for _, g := range groups {
+		at := state[g.name].Add(g.interval)
+		if !at.After(now) {
+			due = append(due, g)
+		} else if next.IsZero() || at.Before(next) {
+			next = at
+		}
+	}
+	return due, next
+}
+
+// Dump the selected journal groups forever, each group when its interval
+// since the last dump passes. A failed dump is reported and retried at the
+// next interval.
+func runWatch(config *Config) *Report {
+	startShutdownHandling()
+	state, r := readWatchState(config)
+	if r != nil {
+		return r
+	}
+	for {
+		now := time.Now()
+		due, next := dueJournalGroups(config.journalGroups, state, now)
+		if len(due) == 0 {
+			log("Next dump of %s at %s", journalGroupNames(config.journalGroups), next.Format("2006-01-02 15:04:05"))
+			wait := next.Sub(now)
+			if !config.runDeadline.IsZero() && config.runDeadline.Before(next) {
+				wait = config.runDeadline.Sub(now)
+			}
+			select {
+			case <-time.After(wait):
+			case <-shutdownContext.Done():
+				return nil
+			}
+			if config.runtimeExceeded() {
+				return config.runtimeExceededReport()
+			}
+			continue
+		}
+
+		groupConfig := *config
+		groupConfig.journals = nil
+		groupConfig.allCommunities = false
+		for _, g := range due {
+			groupConfig.journals = append(groupConfig.journals, g.journals...)
+			if g.name == defaultJournalGroup {
+				groupConfig.allCommunities = config.allCommunities
+			}
+		}
+		log("Dumping journal groups: %s", journalGroupNames(due))
+		if r := runDump(&groupConfig); r != nil {
+			if shutdownRequested() || config.runtimeExceeded() {
+				return r
+			}
+			config.warn("dump of %s failed - %s", journalGroupNames(due), r.AsText())
+		}
+		for _, g := range due {
+			state[g.name] = now
+		}
+		if r := writeWatchState(config, state); r != nil {
+			return r
+		}
+		if r := flushErrorLog(config); r != nil {
+			return r
+		}
+	}
+}
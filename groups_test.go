@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_dueJournalGroups(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	hourly := &journalGroup{"hourly", []string{"a"}, time.Hour}
+	daily := &journalGroup{"daily", []string{"b"}, 24 * time.Hour}
+	weekly := &journalGroup{"weekly", []string{"c"}, 7 * 24 * time.Hour}
+	groups := []*journalGroup{hourly, daily, weekly}
+
+	due, next := dueJournalGroups(groups, map[string]time.Time{}, now)
+	if journalGroupNames(due) != "hourly, daily, weekly" || !next.IsZero() {
+		t.Errorf("Expected all never dumped groups due, got %q %s", journalGroupNames(due), next)
+	}
+
+	state := map[string]time.Time{
+		"hourly": now.Add(-time.Hour),
+		"daily":  now.Add(-2 * time.Hour),
+		"weekly": now.Add(-24 * time.Hour),
+	}
+	due, next = dueJournalGroups(groups, state, now)
+	if journalGroupNames(due) != "hourly" || !next.Equal(now.Add(22*time.Hour)) {
+		t.Errorf("Unexpected due groups %q and next time %s", journalGroupNames(due), next)
+	}
+
+	state["hourly"] = now
+	due, next = dueJournalGroups(groups, state, now)
+	if len(due) != 0 || !next.Equal(now.Add(time.Hour)) {
+		t.Errorf("Unexpected due groups %q and next time %s", journalGroupNames(due), next)
+	}
+}
+
+func Test_watchState(t *testing.T) {
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	config := &Config{dumpDir: dumpDir}
+
+	state, r := readWatchState(config)
+	if r != nil || len(state) != 0 {
+		t.Errorf("Expected empty state without the file")
+	}
+	when := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	state = map[string]time.Time{"default": when, "rare": when.Add(-time.Hour)}
+	if r := writeWatchState(config, state); r != nil {
+		t.Fatal(r.AsText())
+	}
+	read, r := readWatchState(config)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(read) != 2 || !read["default"].Equal(when) || !read["rare"].Equal(when.Add(-time.Hour)) {
+		t.Errorf("Unexpected watch state %v", read)
+	}
+}
+
+func Test_runWatch(t *testing.T) {
+	server := newFakeLJServer(t)
+	defer server.Close()
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	config := &Config{
+		server:         server.URL,
+		username:       "con",
+		password:       "password",
+		dumpDir:        dumpDir,
+		accountDataDir: filepath.Join(dumpDir, accountDataDirName),
+		journalAliases: make(map[string]string),
+		journalGroups: []*journalGroup{
+			{defaultJournalGroup, []string{"con"}, time.Hour},
+			{"rare", []string{"other"}, 24 * time.Hour},
+		},
+		maxRuntime:  2 * time.Second,
+		runDeadline: time.Now().Add(2 * time.Second),
+	}
+	rareDump := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	if r := writeWatchState(config, map[string]time.Time{"rare": rareDump}); r != nil {
+		t.Fatal(r.AsText())
+	}
+
+	start := time.Now().Truncate(time.Second)
+	r := runWatch(config)
+	if r == nil || r.errorCategory(nil) != errorCategoryPartial {
+		t.Errorf("Expected the watch to stop at the runtime deadline")
+	}
+	if time.Now().Before(config.runDeadline) {
+		t.Errorf("Watch returned before the deadline")
+	}
+	if _, err := os.Stat(filepath.Join(dumpDir, "con_", "L-1")); err != nil {
+		t.Errorf("The due group was not dumped - %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(dumpDir, "other")); err == nil {
+		t.Errorf("Unexpected dump of the group that is not due")
+	}
+	if server.geteventsCalls == 0 {
+		t.Errorf("Expected the dump to fetch entries")
+	}
+
+	state, r := readWatchState(config)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if state[defaultJournalGroup].Before(start) {
+		t.Errorf("Unexpected last dump time of the due group %s", state[defaultJournalGroup])
+	}
+	if !state["rare"].Equal(rareDump) {
+		t.Errorf("Unexpected last dump time of the group that is not due %s", state["rare"])
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"path/filepath"
+	"time"
+)
+
+// Calendar heatmaps show the posting activity of a year as SVG images with
+// a square for each day in columns of weeks starting on Monday, one grid
+// for entries and one for comments. Entries are counted from the entries
+// index and comments from the comment files by their own dates, comments
+// without a date on the day of their entry. The color of a day grows with
+// its count relative to the busiest day of the year and the title of each
+// square gives the exact numbers. The html export puts activity-<year>.svg next to index.html and
+// shows it under each year. With -heatmap the stats command writes them
+// for all archived entries into <journal>/activity-<year>.svg of the given
+// directory.
+
+type dayActivity struct {
+	entries  int
+	comments int
+}
+
+const (
+	heatmapCell  = 11
+	heatmapStep  = heatmapCell + 2
+	heatmapLeft  = 30
+	heatmapTitle = 18
+	heatmapTop   = heatmapTitle + 14
+	heatmapGrid  = heatmapTop + 7*heatmapStep + 8
+)
+
+var (
+	heatmapEmptyColor    = "#ebedf0"
+	heatmapEntryColors   = []string{"#9be9a8", "#40c463", "#30a14e", "#216e39"}
+	heatmapCommentColors = []string{"#c6dbef", "#6baed6", "#2171b5", "#08306b"}
+)
+
+// Count entries and comments per day in the 2006-01-02 form for the
+// journal in dir. include selects the entries to count or is nil to count
+// all.
+func indexActivity(dir string, index *entriesIndex, include func(itemId int64) bool) (map[string]*dayActivity, *Report) {
+	activity := make(map[string]*dayActivity)
+	dayActivityOf := func(date string) *dayActivity {
+		day := activity[date]
+		if day == nil {
+			day = &dayActivity{}
+			activity[date] = day
+		}
+		return day
+	}
+	for _, row := range index.rows {
+		if len(row.date) < 10 || row.file == "" || include != nil && !include(row.itemId) {
+			continue
+		}
+		dayActivityOf(row.date[:10]).entries++
+		if row.comments == 0 {
+			continue
+		}
+		entryDir := filepath.Join(dir, filepath.Dir(filepath.FromSlash(row.file)))
+		comments, r := readEntryComments(entryDir, row.itemId)
+		if r != nil {
+			return nil, r
+		}
+		for i := range comments {
+			date := comments[i].Date
+			if len(date) < 10 || !isActivityDay(date[:10]) {
+				date = row.date
+			}
+			dayActivityOf(date[:10]).comments++
+		}
+	}
+	return activity, nil
+}
+
+func isActivityDay(s string) bool {
+	_, err := time.Parse("2006-01-02", s)
+	return err == nil
+}
+
+func activityYears(activity map[string]*dayActivity) []int {
+	years := make(map[int]int)
+	for day := range activity {
+		if t, err := time.Parse("2006-01-02", day); err == nil {
+			years[t.Year()]++
+		}
+	}
+	return sortedYears(years)
+}
+
+// Pick the color of the count with four levels up to the maximum
+func heatmapColor(count int, max int, colors []string) string {
+	if count <= 0 || max <= 0 {
+		return heatmapEmptyColor
+	}
+	level := (count*len(colors)+max-1)/max - 1
+	if level >= len(colors) {
+		level = len(colors) - 1
+	}
+	return colors[level]
+}
+
+func renderActivityHeatmap(journal string, year int, activity map[string]*dayActivity) []byte {
+	jan1 := time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+	offset := (int(jan1.Weekday()) + 6) % 7
+	days := jan1.AddDate(1, 0, 0).Sub(jan1).Hours() / 24
+	weeks := (int(days) + offset + 6) / 7
+	width := heatmapLeft + weeks*heatmapStep
+
+	totalEntries, totalComments, maxEntries, maxComments := 0, 0, 0, 0
+	for d := jan1; d.Year() == year; d = d.AddDate(0, 0, 1) {
+		if a := activity[d.Format("2006-01-02")]; a != nil {
+			totalEntries += a.entries
+			totalComments += a.comments
+			if a.entries > maxEntries {
+				maxEntries = a.entries
+			}
+			if a.comments > maxComments {
+				maxComments = a.comments
+			}
+		}
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="10">`+"\n",
+		width, 2*heatmapGrid)
+	fmt.Fprintf(&b, "<title>%s %d</title>\n", html.EscapeString(journal), year)
+	grid := func(top int, label string, total int, max int, colors []string, count func(a *dayActivity) int) {
+		fmt.Fprintf(&b, `<text x="0" y="%d" font-size="12">%s %d: %d %s</text>`+"\n", top+12, html.EscapeString(journal), year, total, label)
+		for month := time.January; month <= time.December; month++ {
+			first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+			week := (first.YearDay() - 1 + offset) / 7
+			fmt.Fprintf(&b, `<text x="%d" y="%d">%s</text>`+"\n", heatmapLeft+week*heatmapStep, top+heatmapTop-4, first.Format("Jan"))
+		}
+		for i, name := range []string{"Mon", "Wed", "Fri"} {
+			fmt.Fprintf(&b, `<text x="0" y="%d">%s</text>`+"\n", top+heatmapTop+2*i*heatmapStep+heatmapCell-1, name)
+		}
+		for d := jan1; d.Year() == year; d = d.AddDate(0, 0, 1) {
+			n := 0
+			if a := activity[d.Format("2006-01-02")]; a != nil {
+				n = count(a)
+			}
+			week := (d.YearDay() - 1 + offset) / 7
+			weekday := (int(d.Weekday()) + 6) % 7
+			fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" rx="2" fill="%s"><title>%s: %d %s</title></rect>`+"\n",
+				heatmapLeft+week*heatmapStep, top+heatmapTop+weekday*heatmapStep, heatmapCell, heatmapCell,
+				heatmapColor(n, max, colors), d.Format("2006-01-02"), n, label)
+		}
+	}
+	grid(0, "entries", totalEntries, maxEntries, heatmapEntryColors, func(a *dayActivity) int { return a.entries })
+	grid(heatmapGrid, "comments", totalComments, maxComments, heatmapCommentColors, func(a *dayActivity) int { return a.comments })
+	b.WriteString("</svg>\n")
+	return b.Bytes()
+}
+
+func heatmapFileName(year int) string {
+	return fmt.Sprintf("activity-%d.svg", year)
+}
+
+// Write the heatmap of each year with activity into dir and return the
+// years
+func writeActivityHeatmaps(dir string, journal string, activity map[string]*dayActivity) ([]int, *Report) {
+	years := activityYears(activity)
+	if len(years) == 0 {
+		return nil, nil
+	}
+	if err := mkdirArchive(dir); err != nil {
+		return nil, WrapErr(err, "failed to create heatmap directory %s", dir)
+	}
+	for _, year := range years {
+		path := filepath.Join(dir, heatmapFileName(year))
+		if err := writeFileTempRename(path, renderActivityHeatmap(journal, year, activity)); err != nil {
+			return nil, WrapErr(err, "")
+		}
+	}
+	return years, nil
+}
+
+// Write heatmaps of all archived entries of the journal for the stats
+// command
+func writeJournalHeatmaps(config *Config, journal string) *Report {
+	dir := config.journalDir(journal)
+	index, r := loadEntriesIndex(dir)
+	if r != nil {
+		return r
+	}
+	outDir := filepath.Join(config.heatmapDir, filepath.Base(dir))
+	activity, r := indexActivity(dir, index, nil)
+	if r != nil {
+		return r
+	}
+	years, r := writeActivityHeatmaps(outDir, journal, activity)
+	if r != nil {
+		return r
+	}
+	if len(years) != 0 {
+		fmt.Printf("  heatmaps:           %d-%d in %s\n", years[0], years[len(years)-1], outDir)
+	}
+	return nil
+}
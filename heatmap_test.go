@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_indexActivity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	comments := map[string]string{
+		"C-1": `<comments><comment><id>1</id><date>2024-03-05T11:00:00Z</date></comment>` +
+			`<comment><id>2</id><date>2024-03-07T09:00:00Z</date></comment><comment><id>3</id></comment></comments>`,
+		"2024/03/C-2": `<comments><comment><id>4</id><date>2024-03-06T01:00:00Z</date></comment></comments>`,
+	}
+	for name, data := range comments {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	index := &entriesIndex{rows: map[int64]*entryIndexRow{
+		1: {itemId: 1, date: "2024-03-05 10:00:00", comments: 3, file: "L-1"},
+		2: {itemId: 2, date: "2024-03-05 22:00:00", comments: 1, file: "2024/03/L-2"},
+		3: {itemId: 3, date: "2023-12-31 08:00:00", file: "L-3"},
+		4: {itemId: 4, comments: 5},
+	}}
+	activity, r := indexActivity(dir, index, nil)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	expected := map[string]dayActivity{
+		"2024-03-05": {entries: 2, comments: 2},
+		"2024-03-06": {comments: 1},
+		"2024-03-07": {comments: 1},
+		"2023-12-31": {entries: 1},
+	}
+	if len(activity) != len(expected) {
+		t.Errorf("Unexpected days %v", activity)
+	}
+	for day, a := range expected {
+		if activity[day] == nil || *activity[day] != a {
+			t.Errorf("Unexpected activity of %s %+v", day, activity[day])
+		}
+	}
+	if years := activityYears(activity); len(years) != 2 || years[0] != 2023 || years[1] != 2024 {
+		t.Errorf("Unexpected years %v", years)
+	}
+	activity, r = indexActivity(dir, index, func(itemId int64) bool { return itemId != 2 })
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if a := activity["2024-03-05"]; a == nil || *a != (dayActivity{entries: 1, comments: 2}) {
+		t.Errorf("Expected only the included entry, got %+v", a)
+	}
+	if activity["2024-03-06"] != nil {
+		t.Errorf("Expected no comments of the excluded entry, got %+v", activity["2024-03-06"])
+	}
+}
+
+func Test_renderActivityHeatmap(t *testing.T) {
+	activity := map[string]*dayActivity{
+		"2024-03-05": {entries: 2, comments: 4},
+		"2024-03-06": {entries: 1},
+	}
+	svg := string(renderActivityHeatmap("bob", 2024, activity))
+	if n := strings.Count(svg, "<rect "); n != 2*366 {
+		t.Errorf("Expected a square per day of the leap year in both grids, got %d", n)
+	}
+	for _, s := range []string{
+		"bob 2024: 3 entries",
+		"bob 2024: 4 comments",
+		`fill="#216e39"><title>2024-03-05: 2 entries</title>`,
+		`fill="#40c463"><title>2024-03-06: 1 entries</title>`,
+		`fill="#ebedf0"><title>2024-03-06: 0 comments</title>`,
+	} {
+		if !strings.Contains(svg, s) {
+			t.Errorf("Expected %s in %s", s, svg)
+		}
+	}
+	// 2024-01-01 is a Monday, so it starts the first column
+	if !strings.Contains(svg, `<rect x="30" y="32" width="11" height="11" rx="2" fill="#ebedf0"><title>2024-01-01: 0 entries`) {
+		t.Errorf("Unexpected position of the first day")
+	}
+}
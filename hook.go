@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// A post-dump hook is a shell command from <hook> in the config or
+// -post-hook that runs in the dump directory after each successful dump.
+// It gets the environment of ljdumpgo with these variables describing the
+// run, so it can commit the archive to git, sync it elsewhere or send a
+// notification:
+//
+//	LJDUMP_DUMP_DIR          absolute path of the dump directory
+//	LJDUMP_JOURNALS          space-separated names of the dumped journals
+//	LJDUMP_CHANGED_JOURNALS  the journals with new entries or comments
+//	LJDUMP_NEW_ENTRIES       the number of new or updated entries
+//	LJDUMP_NEW_COMMENTS      the number of new comments
+//	LJDUMP_RUN_REPORT        path of the run report, see runreport.go
+
+func postHookEnv(config *Config, rr *runReport) []string {
+	dumpDir, err := filepath.Abs(config.dumpDir)
+	if err != nil {
+		dumpDir = config.dumpDir
+	}
+	var journals, changed []string
+	newEntries, newComments := 0, 0
+	for _, jcx := range rr.journals {
+		journals = append(journals, jcx.name)
+		if jcx.newEntries != 0 || jcx.newComments != 0 {
+			changed = append(changed, jcx.name)
+		}
+		newEntries += jcx.newEntries
+		newComments += jcx.newComments
+	}
+	return []string{
+		"LJDUMP_DUMP_DIR=" + dumpDir,
+		"LJDUMP_JOURNALS=" + strings.Join(journals, " "),
+		"LJDUMP_CHANGED_JOURNALS=" + strings.Join(changed, " "),
+		"LJDUMP_NEW_ENTRIES=" + strconv.Itoa(newEntries),
+		"LJDUMP_NEW_COMMENTS=" + strconv.Itoa(newComments),
+		"LJDUMP_RUN_REPORT=" + filepath.Join(dumpDir, runReportDirName, latestRunReportFileName),
+	}
+}
+
+func runPostHook(config *Config, rr *runReport) *Report {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", config.postHook)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", config.postHook)
+	}
+	cmd.Dir = config.dumpDir
+	cmd.Env = append(os.Environ(), postHookEnv(config, rr)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	log("Running post-dump hook")
+	if err := cmd.Run(); err != nil {
+		return WrapErr(err, "post-dump hook failed")
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_postHookEnv(t *testing.T) {
+	config := &Config{dumpDir: "/archive"}
+	rr := &runReport{}
+	rr.journals = []*journalContext{
+		{name: "bob", newEntries: 2, newComments: 1},
+		{name: "quiet"},
+		{name: "club", newComments: 4},
+	}
+	env := postHookEnv(config, rr)
+	expected := []string{
+		"LJDUMP_DUMP_DIR=/archive",
+		"LJDUMP_JOURNALS=bob quiet club",
+		"LJDUMP_CHANGED_JOURNALS=bob club",
+		"LJDUMP_NEW_ENTRIES=2",
+		"LJDUMP_NEW_COMMENTS=5",
+		"LJDUMP_RUN_REPORT=/archive/reports/run-report.html",
+	}
+	if len(env) != len(expected) {
+		t.Fatalf("Unexpected hook environment %q", env)
+	}
+	for i := range expected {
+		if env[i] != expected[i] {
+			t.Errorf("Expected %s, got %s", expected[i], env[i])
+		}
+	}
+}
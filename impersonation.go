@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// The account acts on journals other than its own in two ways. Protocol
+// methods like syncitems, getevents and getdaycounts take the usejournal
+// parameter and the server allows it for communities where the account
+// can post, that is for members with posting access. Pages like the
+// comment export, the monthly export and the community management pages
+// take the authas query parameter and the server allows it for
+// maintainers of the community. So a maintainer that is not a member can
+// archive comments, the community info and the moderation queue but not
+// the entries. The helpers below add the parameters only for other
+// journals so every fetch names the journal the same way, and
+// journalAccessReport turns the access errors of the server into errors
+// telling which right the account lacks.
+
+// LJ protocol fault of a method called with usejournal for a journal that
+// the account cannot use
+const ljNoJournalAccessFault = "300"
+
+// Add usejournal to the protocol parameters when the journal is not the
+// own journal of the account
+func usejournalParams(config *Config, journal string, params map[string]interface{}) map[string]interface{} {
+	if journal != config.username {
+		params["usejournal"] = journal
+	}
+	return params
+}
+
+// Add authas to the path or URL when the journal is not the own journal
+// of the account
+func authasPath(config *Config, path string, journal string) string {
+	if journal == config.username {
+		return path
+	}
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return path + separator + "authas=" + url.QueryEscape(journal)
+}
+
+// Check if the report comes from the protocol fault for a journal without
+// access. The XML-RPC client includes the code in the error text, the
+// JSON-RPC one in the report message.
+func isJournalAccessError(r *Report) bool {
+	text := r.AsText()
+	return strings.Contains(text, "code: "+ljNoJournalAccessFault) ||
+		strings.Contains(text, "(code "+ljNoJournalAccessFault+")")
+}
+
+// Explain an access error of the server for the journal. what names the
+// archived data and right the needed right of the account.
+func journalAccessReport(config *Config, journal string, what string, right string, r *Report) *Report {
+	if journal == config.username {
+		return r
+	}
+	return CombineReports(
+		ReportMsg("the account %s cannot archive %s of %s, it must be %s", config.username, what, journal, right),
+		r,
+	).withCategory(errorCategoryAuth)
+}
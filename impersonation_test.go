@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_authasPath(t *testing.T) {
+	config := &Config{username: "bob"}
+	if path := authasPath(config, "/community/moderate.bml", "bob"); path != "/community/moderate.bml" {
+		t.Errorf("Expected no authas for the own journal, got %s", path)
+	}
+	if path := authasPath(config, "/export_comments.bml?get=comment_meta", "a team"); path != "/export_comments.bml?get=comment_meta&authas=a+team" {
+		t.Errorf("Unexpected path %s", path)
+	}
+	if params := usejournalParams(config, "bob", map[string]interface{}{}); len(params) != 0 {
+		t.Errorf("Expected no usejournal for the own journal, got %v", params)
+	}
+}
+
+func Test_maintainerWithoutPostingAccess(t *testing.T) {
+	server := newFakeLJServer(t)
+	defer server.Close()
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	config := &Config{
+		server:         server.URL,
+		username:       "con",
+		password:       "password",
+		journals:       []string{"team"},
+		dumpDir:        dumpDir,
+		accountDataDir: filepath.Join(dumpDir, accountDataDirName),
+		journalAliases: make(map[string]string),
+	}
+	server.usejournalFault = true
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	comments, r := readEntryComments(filepath.Join(dumpDir, "team"), 1)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(comments) != 1 {
+		t.Errorf("Expected comments archived by the maintainer, got %+v", comments)
+	}
+
+	// Neither a member nor a maintainer
+	server.authasForbidden = true
+	r = runDump(config)
+	if r == nil {
+		t.Fatal("Expected an access error")
+	}
+	if text := r.AsText(); !strings.Contains(text, "must be a member with posting access") {
+		t.Errorf("Unexpected error %s", text)
+	}
+	if r.errorCategory(nil) != errorCategoryAuth {
+		t.Errorf("Expected auth category, got %s", r.errorCategory(nil))
+	}
+}
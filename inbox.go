@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"linedb"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Private messages and notifications of the account live only in the
+// inbox on the server and no export covers them. With -inbox the dump
+// pages through the inbox with the getinbox method and stores each item
+// as it came from the server, in the XML form of the entry files, in the
+// inbox subdirectory of account.data as <qid>.xml. inbox.linedb there
+// lists archived items with the type, the date and the sender and keeps
+// the time of the latest item so the next dump asks only for newer ones.
+// Items deleted on the server stay in the archive.
+
+const inboxDBFileName = "inbox.linedb"
+const inboxDirName = "inbox"
+
+// The maximum number of items the server returns per call
+const inboxPageSize = 100
+
+type inboxRecord struct {
+	qid      int64
+	when     int64
+	typeName string
+	sender   string
+}
+
+type inboxDB struct {
+	lastSync int64
+	records  []inboxRecord
+}
+
+func readInboxDB(config *Config) (*inboxDB, *Report) {
+	db := &inboxDB{}
+	dbpath := filepath.Join(config.accountDataDir, inboxDirName, inboxDBFileName)
+	dbdata, err := archiveStore.ReadFile(dbpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return db, nil
+		}
+		return nil, WrapErr(err, "")
+	}
+	d := newLinedbDecoder(dbdata)
+	for d.NextItem() {
+		switch d.ItemKind {
+		case linedb.ScalarItem:
+			if d.ItemName == "lastSync" {
+				db.lastSync = d.GetInt64()
+			} else {
+				d.GetString()
+			}
+		case linedb.TableItem:
+			for d.NextRow() {
+				if d.ItemName == "messages" {
+					db.records = append(db.records, inboxRecord{d.GetInt64(), d.GetInt64(), d.GetString(), d.GetString()})
+				}
+			}
+		}
+	}
+	if err := d.GetError(); err != nil {
+		return nil, WrapErr(err, "failed to parse %s", dbpath)
+	}
+	return db, nil
+}
+
+func writeInboxDB(config *Config, db *inboxDB) *Report {
+	e := linedb.NewByteEncoder()
+	e.Scalar("lastSync").AddInt64(db.lastSync)
+	e.EmptyLine()
+	e.Comment("qid when type sender")
+	e.Table("messages")
+	for _, record := range db.records {
+		e.AddInt64(record.qid).AddInt64(record.when).AddString(record.typeName).AddString(record.sender).EndRow()
+	}
+	e.EndTable()
+	dbpath := filepath.Join(config.accountDataDir, inboxDirName, inboxDBFileName)
+	if err := writeFileTempRename(dbpath, e.GetBytes()); err != nil {
+		return WrapErr(err, "")
+	}
+	return nil
+}
+
+// Get the integer value of the item field that the server may send as a
+// number or a string
+func inboxInt(item map[string]interface{}, key string) int64 {
+	switch v := item[key].(type) {
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	case string:
+		i, _ := strconv.ParseInt(v, 10, 64)
+		return i
+	}
+	return 0
+}
+
+// Find the sender among the fields that servers use for it. Messages keep
+// details in the extended struct.
+func inboxSender(item map[string]interface{}) string {
+	for _, m := range []interface{}{item, item["extended"]} {
+		fields, ok := m.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, key := range []string{"from", "poster", "sender", "journal"} {
+			if s, ok := fields[key].(string); ok && s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+func dumpInbox(session *ljSession) *Report {
+	config := session.config
+	db, r := readInboxDB(config)
+	if r != nil {
+		return r
+	}
+	archived := make(map[int64]bool, len(db.records))
+	for _, record := range db.records {
+		archived[record.qid] = true
+	}
+	dir := filepath.Join(config.accountDataDir, inboxDirName)
+	if err := mkdirArchive(dir); err != nil {
+		return WrapErr(err, "")
+	}
+	log("Fetching inbox of %s", config.username)
+
+	type LJInboxResult struct {
+		Items []map[string]interface{} `xmlrpc:"items"`
+	}
+	lastSync := db.lastSync
+	added := 0
+	for skip := 0; ; skip += inboxPageSize {
+		if shutdownRequested() {
+			return interruptedReport()
+		}
+		input := map[string]interface{}{
+			"itemshow": inboxPageSize,
+			"skip":     skip,
+		}
+		if db.lastSync != 0 {
+			input["lastsync"] = db.lastSync
+		}
+		var result LJInboxResult
+		if r := callLJXmlRpcMethod(session, "getinbox", input, &result); r != nil {
+			return r
+		}
+		for _, item := range result.Items {
+			qid := inboxInt(item, "qid")
+			if qid <= 0 {
+				session.config.warn("skipping inbox item without qid")
+				continue
+			}
+			when := inboxInt(item, "when")
+			if when > lastSync {
+				lastSync = when
+			}
+			if archived[qid] {
+				continue
+			}
+			data, fixes, r := encodeLJStruct("message", item)
+			if r != nil {
+				return r
+			}
+			fixes.logWarnings(session.config, fmt.Sprintf("inbox item %d", qid))
+			path := filepath.Join(dir, fmt.Sprintf("%d.xml", qid))
+			if err := writeFileTempRename(path, data); err != nil {
+				return WrapErr(err, "failed to write %s", path)
+			}
+			typeName, _ := item["typename"].(string)
+			db.records = append(db.records, inboxRecord{qid, when, typeName, inboxSender(item)})
+			archived[qid] = true
+			added++
+		}
+		if len(result.Items) < inboxPageSize {
+			break
+		}
+	}
+	db.lastSync = lastSync
+	if r := writeInboxDB(config, db); r != nil {
+		return r
+	}
+	if lastSync != 0 {
+		log("%d new inbox items up to %s", added, time.Unix(lastSync, 0).UTC().Format(ljEventTimeLayout))
+	} else {
+		log("%d new inbox items", added)
+	}
+	return nil
+}
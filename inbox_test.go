@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_dumpInbox(t *testing.T) {
+	server := newFakeLJServer(t)
+	defer server.Close()
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	config := &Config{
+		server:         server.URL,
+		username:       "con",
+		password:       "password",
+		dumpDir:        dumpDir,
+		accountDataDir: filepath.Join(dumpDir, accountDataDirName),
+	}
+	session, r := openLJSession(config)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	for run := 0; run < 2; run++ {
+		if r := dumpInbox(session); r != nil {
+			t.Fatal(r.AsText())
+		}
+	}
+	db, r := readInboxDB(config)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if db.lastSync != 1577872800 || len(db.records) != 1 ||
+		db.records[0] != (inboxRecord{3, 1577872800, "UserMessageRecvd", "alice"}) {
+		t.Errorf("Unexpected inbox DB %+v", db)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(config.accountDataDir, inboxDirName, "3.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "<body>Private hello</body>") {
+		t.Errorf("Unexpected message file %s", data)
+	}
+}
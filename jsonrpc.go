@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/kolo/xmlrpc"
+)
+
+// With -api=jsonrpc the protocol calls go to a JSON-RPC 2.0 endpoint like
+// the one the LJ mobile applications use instead of /interface/xmlrpc. The
+// calls use the same method names and parameters as the XML-RPC protocol.
+// The JSON result is converted to an XML-RPC value and decoded by the
+// XML-RPC library so callers get the same result types from both backends.
+// The session cookie and the comment export pages stay the same.
+
+const xmlrpcAPI = "xmlrpc"
+const jsonrpcAPI = "jsonrpc"
+
+const defaultJsonRpcUrl = "https://api.livejournal.com/"
+
+type jsonRpcRequest struct {
+	JsonRpc string                 `json:"jsonrpc"`
+	Method  string                 `json:"method"`
+	Params  map[string]interface{} `json:"params"`
+	Id      int                    `json:"id"`
+}
+
+type jsonRpcResponse struct {
+	JsonRpc string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result"`
+	Id      json.RawMessage `json:"id"`
+	Error   *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func callLJJsonRpcMethod(
+	session *ljSession, method string, input map[string]interface{}, result interface{},
+) *Report {
+	session.jsonrpcId++
+	body, err := json.Marshal(&jsonRpcRequest{"2.0", method, input, session.jsonrpcId})
+	if err != nil {
+		return WrapErr(err, "")
+	}
+	resp, err := session.client.Post(session.config.apiUrl, "application/json", bytes.NewReader(body))
+	var data []byte
+	if err == nil {
+		data, err = ioutil.ReadAll(resp.Body)
+		err = fuseErr(err, resp.Body.Close())
+	}
+	if err != nil {
+		return WrapErr(err, "failed to call %s at %s", method, session.config.apiUrl)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ReportMsg("failed to call %s at %s - %s", method, session.config.apiUrl, resp.Status)
+	}
+	var response jsonRpcResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		return WrapErr(err, "failed to parse the response to %s", method)
+	}
+	if response.JsonRpc != "2.0" {
+		return ReportMsg("the response to %s is not a JSON-RPC 2.0 response", method)
+	}
+	if response.Error != nil {
+		return ReportMsg("%s failed - %s (code %d)", method, response.Error.Message, response.Error.Code)
+	}
+	if string(response.Id) != strconv.Itoa(session.jsonrpcId) {
+		return ReportMsg("the response to %s has id %s instead of %d", method, response.Id, session.jsonrpcId)
+	}
+	if len(response.Result) == 0 {
+		return ReportMsg("the response to %s has neither result nor error", method)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(response.Result))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return WrapErr(err, "failed to parse the result of %s", method)
+	}
+	buf := bytes.NewBufferString(`<?xml version="1.0"?><methodResponse><params><param>`)
+	if err := writeXmlRpcValue(buf, value); err != nil {
+		return WrapErr(err, "unsupported result of %s", method)
+	}
+	buf.WriteString(`</param></params></methodResponse>`)
+	if err := xmlrpc.NewResponse(buf.Bytes()).Unmarshal(result); err != nil {
+		return WrapErr(err, "failed to decode the result of %s", method)
+	}
+	return nil
+}
+
+// Write the value decoded from JSON with UseNumber as an XML-RPC value.
+// Integers become int so entry fields keep the types of the XML-RPC
+// backend. Null struct members are omitted, other nulls become empty
+// structs.
+func writeXmlRpcValue(buf *bytes.Buffer, value interface{}) error {
+	buf.WriteString("<value>")
+	switch v := value.(type) {
+	case nil:
+		// A null result of a call without data
+		buf.WriteString("<struct></struct>")
+	case string:
+		buf.WriteString("<string>")
+		xml.EscapeText(buf, []byte(v))
+		buf.WriteString("</string>")
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			fmt.Fprintf(buf, "<int>%d</int>", i)
+		} else {
+			fmt.Fprintf(buf, "<double>%s</double>", v)
+		}
+	case bool:
+		b := 0
+		if v {
+			b = 1
+		}
+		fmt.Fprintf(buf, "<boolean>%d</boolean>", b)
+	case []interface{}:
+		buf.WriteString("<array><data>")
+		for _, item := range v {
+			if err := writeXmlRpcValue(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("</data></array>")
+	case map[string]interface{}:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		buf.WriteString("<struct>")
+		for _, name := range names {
+			if v[name] == nil {
+				continue
+			}
+			buf.WriteString("<member><name>")
+			xml.EscapeText(buf, []byte(name))
+			buf.WriteString("</name>")
+			if err := writeXmlRpcValue(buf, v[name]); err != nil {
+				return err
+			}
+			buf.WriteString("</member>")
+		}
+		buf.WriteString("</struct>")
+	default:
+		return fmt.Errorf("unexpected %T value", value)
+	}
+	buf.WriteString("</value>")
+	return nil
+}
+
+// Get the account information with the login method of the protocol in
+// the form of the flat login response that the XML-RPC backend uses.
+func jsonRpcLoginResponse(session *ljSession) (map[string]string, *Report) {
+	type loginResult struct {
+		Pickws        []string `xmlrpc:"pickws"`
+		Pickwurls     []string `xmlrpc:"pickwurls"`
+		Defaultpicurl string   `xmlrpc:"defaultpicurl"`
+		Usejournals   []string `xmlrpc:"usejournals"`
+	}
+	var result loginResult
+	params := map[string]interface{}{
+		"getpickws":    1,
+		"getpickwurls": 1,
+	}
+	if r := callLJXmlRpcMethod(session, "login", params, &result); r != nil {
+		return nil, r
+	}
+	responseMap := map[string]string{"defaultpicurl": result.Defaultpicurl}
+	addArray := func(name string, values []string) {
+		responseMap[name+"_count"] = strconv.Itoa(len(values))
+		for i, value := range values {
+			responseMap[fmt.Sprintf("%s_%d", name, i+1)] = value
+		}
+	}
+	addArray("pickw", result.Pickws)
+	addArray("pickwurl", result.Pickwurls)
+	addArray("access", result.Usejournals)
+	return responseMap, nil
+}
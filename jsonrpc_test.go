@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_runDumpJsonRpc(t *testing.T) {
+	server := newFakeLJServer(t)
+	defer server.Close()
+	var methods []string
+	server.Config.Handler.(*http.ServeMux).HandleFunc("/jsonrpc", func(w http.ResponseWriter, req *http.Request) {
+		var call struct {
+			Method string                 `json:"method"`
+			Params map[string]interface{} `json:"params"`
+			Id     int                    `json:"id"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&call); err != nil {
+			t.Error(err)
+		}
+		methods = append(methods, call.Method)
+		result := "null"
+		switch call.Method {
+		case "login":
+			result = `{"pickws":["cat"],"pickwurls":["` + server.URL + `/pic"],"defaultpicurl":"","usejournals":[]}`
+		case "getfriends":
+			result = `{"friends":[],"friendofs":[],"friendgroups":[]}`
+		case "syncitems":
+			result = `{"syncitems":[]}`
+			if call.Params["lastsync"] == "" {
+				result = `{"syncitems":[{"item":"L-1","action":"create","time":"2020-01-01 10:00:00"}]}`
+			}
+		case "getevents":
+			result = `{"events":[{"itemid":1,"anum":42,"eventtime":"2020-01-01 09:00:00","subject":"First","event":"Hello <b>JSON</b>","props":{"taglist":"a, b","current_mood":null}}]}`
+		default:
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","error":{"code":-32601,"message":"no %s"},"id":%d}`, call.Method, call.Id)
+			return
+		}
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","result":%s,"id":%d}`, result, call.Id)
+	})
+	server.Config.Handler.(*http.ServeMux).HandleFunc("/pic", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	})
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	config := &Config{
+		server:         server.URL,
+		username:       "con",
+		password:       "password",
+		journals:       []string{"con"},
+		dumpDir:        dumpDir,
+		accountDataDir: filepath.Join(dumpDir, accountDataDirName),
+		journalAliases: make(map[string]string),
+		api:            jsonrpcAPI,
+		apiUrl:         server.URL + "/jsonrpc",
+	}
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	entry, err := readArchivedEntry(filepath.Join(dumpDir, "con_", "L-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.itemId != 1 || entry.event != "Hello <b>JSON</b>" || entry.props["taglist"] != "a, b" {
+		t.Errorf("Unexpected entry %+v", entry)
+	}
+	accountData, r := readAccountData(config)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if accountData.pictureKeywordUrlMap["cat"] != server.URL+"/pic" {
+		t.Errorf("Unexpected userpics %v", accountData.pictureKeywordUrlMap)
+	}
+	if len(methods) == 0 || methods[0] != "login" {
+		t.Errorf("Unexpected JSON-RPC calls %v", methods)
+	}
+}
+
+func Test_callLJJsonRpcMethod(t *testing.T) {
+	type getEventsResult struct {
+		Events []struct {
+			ItemId  int                    `xmlrpc:"itemid"`
+			Subject string                 `xmlrpc:"subject"`
+			Props   map[string]interface{} `xmlrpc:"props"`
+		} `xmlrpc:"events"`
+	}
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		err      string
+		access   bool
+	}{
+		{"result", http.StatusOK,
+			`{"jsonrpc":"2.0","result":{"events":[{"itemid":7,"subject":"A & B","props":{"opt_nocomments":true,"current_mood":null}}]},"id":1}`,
+			"", false},
+		{"no access", http.StatusOK,
+			`{"jsonrpc":"2.0","error":{"code":300,"message":"Don't have access to requested journal"},"id":1}`,
+			"getevents failed - Don't have access to requested journal (code 300)", true},
+		{"other id", http.StatusOK, `{"jsonrpc":"2.0","result":{"events":[]},"id":2}`,
+			"the response to getevents has id 2 instead of 1", false},
+		{"no result", http.StatusOK, `{"jsonrpc":"2.0","id":1}`,
+			"the response to getevents has neither result nor error", false},
+		{"not JSON-RPC", http.StatusOK, `{"result":{"events":[]},"id":1}`,
+			"the response to getevents is not a JSON-RPC 2.0 response", false},
+		{"http error", http.StatusBadGateway, "", "502 Bad Gateway", false},
+	}
+	for _, test := range tests {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(test.status)
+			io.WriteString(w, test.response)
+		}))
+		session := &ljSession{config: &Config{apiUrl: ts.URL}}
+		var result getEventsResult
+		r := callLJJsonRpcMethod(session, "getevents", map[string]interface{}{"selecttype": "one"}, &result)
+		ts.Close()
+		if test.err == "" {
+			if r != nil {
+				t.Errorf("%s: unexpected error %s", test.name, r.AsText())
+			} else if len(result.Events) != 1 || result.Events[0].ItemId != 7 || result.Events[0].Subject != "A & B" ||
+				result.Events[0].Props["opt_nocomments"] != true {
+				t.Errorf("%s: unexpected result %+v", test.name, result)
+			} else if _, present := result.Events[0].Props["current_mood"]; present {
+				t.Errorf("%s: null prop is present in %+v", test.name, result)
+			}
+			continue
+		}
+		if r == nil || !strings.Contains(r.AsText(), test.err) {
+			t.Errorf("%s: expected error %q, got %v", test.name, test.err, r)
+		} else if isJournalAccessError(r) != test.access {
+			t.Errorf("%s: isJournalAccessError() is not %t for %s", test.name, test.access, r.AsText())
+		}
+	}
+}
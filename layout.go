@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ibukanov/ljdump-go/ljarchive"
+	"os"
+	"path/filepath"
+)
+
+// Layouts of entry and comment files in the journal directory. The flat
+// layout keeps all L-* and C-* files in the journal directory. Some file
+// systems get slow with tens of thousands of files in one directory, so the
+// year-month layout puts them into YYYY/MM subdirectories by the entry time
+// with the comment file next to its entry. Comments fetched before their
+// entry stay in the journal directory until the entry arrives.
+//
+// The journal DB records the layout. Readers accept files in both layouts,
+// so an archive stays readable while migrate-layout moves the files.
+
+const flatLayout = "flat"
+const yearMonthLayout = "year-month"
+
+var journalLayouts = []string{flatLayout, yearMonthLayout}
+
+func isJournalLayout(layout string) bool {
+	for _, known := range journalLayouts {
+		if layout == known {
+			return true
+		}
+	}
+	return false
+}
+
+// List entry and comment files of the journal as paths relative to dir
+func listDumpFiles(dir string) ([]string, error) {
+	return ljarchive.ListDumpFiles(archiveStore, dir)
+}
+
+// Get the directory relative to the journal directory for the entry with
+// the given eventtime.
+func layoutSubdir(layout string, eventTime string) string {
+	if layout != yearMonthLayout {
+		return ""
+	}
+	year, month := eventYearMonth(eventTime)
+	return filepath.Join(fmt.Sprintf("%04d", year), fmt.Sprintf("%02d", month))
+}
+
+// Get the path of the entry relative to the journal directory in the form
+// stored in the entries index
+func entryRelPath(journalDir string, entry *archivedEntry) string {
+	rel, err := filepath.Rel(journalDir, filepath.Join(entry.dir, entry.fileName))
+	if err != nil {
+		return entry.fileName
+	}
+	return filepath.ToSlash(rel)
+}
+
+// Get the directory with the archived files of the entry or the journal
+// directory for an entry that is not archived yet.
+func (jcx *journalContext) entryDir(itemId int64) string {
+	if jcx.index == nil {
+		return jcx.dir
+	}
+	if row := jcx.index.rows[itemId]; row != nil && row.file != "" {
+		return filepath.Join(jcx.dir, filepath.Dir(filepath.FromSlash(row.file)))
+	}
+	return jcx.dir
+}
+
+// Move the entry and comment files of the item into dir if they exist
+// elsewhere.
+func moveEntryFiles(fromDir, toDir string, itemId int64) error {
+	if fromDir == toDir {
+		return nil
+	}
+	if err := mkdirArchive(toDir); err != nil {
+		return err
+	}
+	for _, name := range []string{fmt.Sprintf("L-%d", itemId), fmt.Sprintf("C-%d", itemId)} {
+		err := archiveStore.Rename(filepath.Join(fromDir, name), filepath.Join(toDir, name))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get the path for writing the entry. When the entry time moved the entry
+// to another directory, the move of its old files is staged with the entry.
+func (jcx *journalContext) prepareEntryPath(itemId int64, eventTime string) (string, *Report) {
+	toDir := filepath.Join(jcx.dir, layoutSubdir(jcx.db.layout, eventTime))
+	if err := mkdirArchive(toDir); err != nil {
+		return "", WrapErr(err, "")
+	}
+	fromDir := jcx.entryDir(itemId)
+	if fromDir != toDir {
+		commentPath := commentFilePath(fromDir, itemId)
+		data, err := archiveStore.ReadFile(commentPath)
+		if err == nil {
+			jcx.stageWrite(commentFilePath(toDir, itemId), data)
+			jcx.stageRemove(commentPath)
+		} else if !os.IsNotExist(err) {
+			return "", WrapErr(err, "")
+		}
+		jcx.stageRemove(filepath.Join(fromDir, fmt.Sprintf("L-%d", itemId)))
+	}
+	return filepath.Join(toDir, fmt.Sprintf("L-%d", itemId)), nil
+}
+
+func (jcx *journalContext) commentFilePath(itemId int64) string {
+	return commentFilePath(jcx.entryDir(itemId), itemId)
+}
+
+// Remove empty YYYY/MM directories left after moving files out of them
+func removeEmptyShardDirs(dir string) {
+	years, _ := archiveStore.ReadDir(dir)
+	for _, year := range years {
+		if !year.IsDir() || !ljarchive.IsShardDir(year.Name(), 0) {
+			continue
+		}
+		yearDir := filepath.Join(dir, year.Name())
+		months, _ := archiveStore.ReadDir(yearDir)
+		for _, month := range months {
+			if month.IsDir() && ljarchive.IsShardDir(month.Name(), 1) {
+				archiveStore.Remove(filepath.Join(yearDir, month.Name()))
+			}
+		}
+		archiveStore.Remove(yearDir)
+	}
+}
+
+// migrate-layout is migrate with the required -layout, see migrate.go
+func runMigrateLayout(config *Config) *Report {
+	return runMigrate(config)
+}
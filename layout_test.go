@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_yearMonthLayout(t *testing.T) {
+	server := newFakeLJServer(t)
+	defer server.Close()
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	config := &Config{
+		server:         server.URL,
+		username:       "con",
+		password:       "password",
+		journals:       []string{"con"},
+		dumpDir:        dumpDir,
+		accountDataDir: filepath.Join(dumpDir, accountDataDirName),
+		journalAliases: make(map[string]string),
+		journalLayout:  yearMonthLayout,
+	}
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+
+	journalDir := filepath.Join(dumpDir, "con_")
+	checkLayout := func(layout string, entryFile string) {
+		jcx := &journalContext{config: config, name: "con", dir: journalDir}
+		if r := readJournalDB(jcx); r != nil {
+			t.Fatal(r.AsText())
+		}
+		if jcx.db.layout != layout {
+			t.Errorf("Expected layout %s, got %s", layout, jcx.db.layout)
+		}
+		entries, r := readJournalEntries(journalDir)
+		if r != nil {
+			t.Fatal(r.AsText())
+		}
+		if len(entries) != 1 || entries[0].dir != filepath.Join(journalDir, filepath.Dir(entryFile)) {
+			t.Fatalf("Unexpected entries %+v", entries)
+		}
+		comments, r := readEntryComments(entries[0].dir, 1)
+		if r != nil {
+			t.Fatal(r.AsText())
+		}
+		if len(comments) != 1 {
+			t.Errorf("Expected one comment next to %s, got %d", entryFile, len(comments))
+		}
+		index, r := readEntriesIndex(journalDir)
+		if r != nil {
+			t.Fatal(r.AsText())
+		}
+		if row := index.rows[1]; row == nil || row.file != entryFile || row.comments != 1 {
+			t.Errorf("Unexpected index row %+v", row)
+		}
+	}
+	checkLayout(yearMonthLayout, "2020/01/L-1")
+
+	config.journalLayout = flatLayout
+	if r := runMigrateLayout(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	checkLayout(flatLayout, "L-1")
+	if _, err := os.Stat(filepath.Join(journalDir, "2020")); !os.IsNotExist(err) {
+		t.Errorf("Expected removed year directory, got %v", err)
+	}
+
+	config.journalLayout = yearMonthLayout
+	if r := runMigrateLayout(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	checkLayout(yearMonthLayout, "2020/01/L-1")
+
+	// A dump with the flat layout configured keeps the layout of the
+	// existing archive
+	config.journalLayout = flatLayout
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	checkLayout(yearMonthLayout, "2020/01/L-1")
+}
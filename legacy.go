@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/hydrogen18/stalecucumber"
+	"github.com/ibukanov/ljdump-go/ljarchive"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ljdump.py keeps the state of each journal in files in the journal
+// directory. .last has the server time of the last sync on the first line
+// and the largest comment id with a fetched body on the second one, which
+// early versions kept in .lastid instead. comment.meta and user.map are
+// pickled dictionaries with the poster and the state of each comment and
+// with the names of commenters by userid. Python 2 pickles the ids as
+// strings while Python 3 ports of the script pickle them as numbers.
+//
+// When a journal has no journal.linedb the dump converts these files into
+// it and moves the originals into the legacy subdirectory, so the journal
+// directory keeps only files that ljdumpgo reads. Other pickled files of
+// versions that ljdumpgo does not know are moved there unconverted.
+
+const legacyDirName = "legacy"
+
+// Files of ljdump.py in the journal directory that the conversion reads
+var pythonJournalFileNames = []string{".last", ".lastid", "comment.meta", "user.map"}
+
+type pythonConversion struct {
+	// Names of all found files including the unknown ones
+	files []string
+
+	// Pickled files that were not converted
+	unknown []string
+
+	// What was converted for the log
+	converted []string
+}
+
+func isPythonJournalFile(name string) bool {
+	for _, s := range pythonJournalFileNames {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Check the start of the file for a pickled dictionary of protocol 0, 1
+// or 2 and later
+func looksPickled(head []byte) bool {
+	return bytes.HasPrefix(head, []byte("(dp")) || bytes.HasPrefix(head, []byte("}q")) ||
+		len(head) >= 2 && head[0] == 0x80 && head[1] >= 2
+}
+
+func readFileHead(path string, n int) ([]byte, error) {
+	if !isLocalStore() {
+		data, err := archiveStore.ReadFile(path)
+		if len(data) > n {
+			data = data[:n]
+		}
+		return data, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, n)
+	n, err = f.Read(head)
+	if n > 0 {
+		err = nil
+	}
+	return head[:n], fuseErr(err, f.Close())
+}
+
+// Read the files of ljdump.py into jcx.db
+func readPythonJournalFiles(jcx *journalContext) (*pythonConversion, error) {
+	conversion := &pythonConversion{}
+	jcx.db.lastSync = ""
+	var lastMaxId CommentId
+	for _, name := range pythonJournalFileNames {
+		path := filepath.Join(jcx.dir, name)
+		data, err := archiveStore.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		conversion.files = append(conversion.files, name)
+		var maxId CommentId
+		switch name {
+		case ".last":
+			lines := strings.SplitN(string(data), "\n", 3)
+			jcx.db.lastSync = strings.TrimSpace(lines[0])
+			if len(lines) > 1 {
+				maxId, err = parsePythonMaxId(lines[1])
+			}
+		case ".lastid":
+			maxId, err = parsePythonMaxId(string(data))
+		case "comment.meta":
+			err = readPythonCommentMeta(jcx, data)
+		case "user.map":
+			err = readPythonUserMap(jcx, data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert %s - %s", path, err.Error())
+		}
+		if maxId > lastMaxId {
+			lastMaxId = maxId
+		}
+	}
+
+	infos, err := archiveStore.ReadDir(jcx.dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if !info.Mode().IsRegular() || isPythonJournalFile(name) || ljarchive.IsDumpFileName(name) {
+			continue
+		}
+		head, err := readFileHead(filepath.Join(jcx.dir, name), 3)
+		if err != nil {
+			return nil, err
+		}
+		if looksPickled(head) {
+			conversion.files = append(conversion.files, name)
+			conversion.unknown = append(conversion.unknown, name)
+		}
+	}
+
+	if jcx.db.lastSync != "" {
+		conversion.converted = append(conversion.converted, "last sync "+jcx.db.lastSync)
+	}
+	if jcx.db.commentMap != nil {
+		conversion.converted = append(conversion.converted, fmt.Sprintf("%d comments", len(jcx.db.commentMap)))
+	} else if lastMaxId != 0 {
+		// ljdumpgo finds the comments to fetch from their meta data
+		jcx.config.warn("%s has no comment meta data of ljdump.py, comments up to id %d will be fetched again", jcx.dir, lastMaxId)
+	}
+	if jcx.db.userMap != nil {
+		conversion.converted = append(conversion.converted, fmt.Sprintf("%d user names", len(jcx.db.userMap)))
+	}
+	if len(conversion.converted) == 0 {
+		conversion.converted = append(conversion.converted, "only unknown files")
+	}
+	return conversion, nil
+}
+
+func parsePythonMaxId(s string) (CommentId, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid comment id '%s'", s)
+	}
+	return CommentId(id), nil
+}
+
+func pickledId(v interface{}) (int64, error) {
+	switch v := v.(type) {
+	case int64:
+		return v, nil
+	case string:
+		id, err := parseUserId(v)
+		return int64(id), err
+	case stalecucumber.PickleNone:
+		return 0, nil
+	}
+	return 0, fmt.Errorf("unexpected id type %T", v)
+}
+
+func pickledString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case stalecucumber.PickleNone:
+		return "", nil
+	}
+	return "", fmt.Errorf("unexpected string type %T", v)
+}
+
+func readPythonCommentMeta(jcx *journalContext, data []byte) error {
+	pythonData, err := stalecucumber.Dict(stalecucumber.Unpickle(bytes.NewReader(data)))
+	if err != nil {
+		return err
+	}
+	jcx.db.commentMap = make(map[CommentId]commentMeta, len(pythonData))
+	for key, value := range pythonData {
+		id, err := pickledId(key)
+		if err != nil {
+			return err
+		}
+		structValue, ok := value.(map[interface{}]interface{})
+		if !ok {
+			return fmt.Errorf("unexpected value type %T", value)
+		}
+		posterId, err := pickledId(structValue["posterid"])
+		if err != nil {
+			return err
+		}
+		state, err := pickledString(structValue["state"])
+		if err != nil {
+			return err
+		}
+		jcx.db.commentMap[CommentId(id)] = commentMeta{UserId(posterId), state}
+	}
+	return nil
+}
+
+func readPythonUserMap(jcx *journalContext, data []byte) error {
+	pythonData, err := stalecucumber.Dict(stalecucumber.Unpickle(bytes.NewReader(data)))
+	if err != nil {
+		return err
+	}
+	jcx.db.userMap = make(map[UserId]string, len(pythonData))
+	for key, value := range pythonData {
+		userId, err := pickledId(key)
+		if err != nil {
+			return err
+		}
+		user, err := pickledString(value)
+		if err != nil {
+			return err
+		}
+		jcx.db.userMap[UserId(userId)] = user
+	}
+	return nil
+}
+
+// Move the converted files into the legacy subdirectory after
+// journal.linedb was written
+func archivePythonJournalFiles(jcx *journalContext, conversion *pythonConversion) *Report {
+	dir := filepath.Join(jcx.dir, legacyDirName)
+	if err := archiveStore.MkdirAll(dir); err != nil {
+		return WrapErr(err, "failed to create %s", dir)
+	}
+	for _, name := range conversion.files {
+		if err := archiveStore.Rename(filepath.Join(jcx.dir, name), filepath.Join(dir, name)); err != nil {
+			return WrapErr(err, "failed to move %s into %s", name, dir)
+		}
+	}
+	if len(conversion.unknown) != 0 {
+		jcx.config.warn("unknown ljdump.py files %s of journal %s were moved into %s unconverted",
+			strings.Join(conversion.unknown, ", "), jcx.name, dir)
+	}
+	log("Moved ljdump.py files %s of journal %s into %s", strings.Join(conversion.files, ", "), jcx.name, dir)
+	return nil
+}
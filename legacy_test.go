@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"github.com/hydrogen18/stalecucumber"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writePickle(t *testing.T, path string, v interface{}) {
+	var buf bytes.Buffer
+	if _, err := stalecucumber.NewPickler(&buf).Pickle(v); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_readPythonJournalFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ljdump-legacy-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// An early version with .lastid, user ids as numbers like Python 3
+	// ports and an anonymous comment
+	if err := ioutil.WriteFile(filepath.Join(dir, ".last"), []byte("2005-01-01 00:00:00\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ".lastid"), []byte("12\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	writePickle(t, filepath.Join(dir, "comment.meta"), map[interface{}]interface{}{
+		int64(11): map[interface{}]interface{}{"posterid": "7", "state": "A"},
+		int64(12): map[interface{}]interface{}{"posterid": "", "state": "S"},
+	})
+	writePickle(t, filepath.Join(dir, "user.map"), map[interface{}]interface{}{int64(7): "alice"})
+	writePickle(t, filepath.Join(dir, "tags.cache"), map[interface{}]interface{}{"a": int64(1)})
+	if err := ioutil.WriteFile(filepath.Join(dir, "L-1"), []byte("<event></event>"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read-only commands convert in memory and write nothing
+	readOnly := &Config{dumpDir: dir, journalAliases: make(map[string]string), command: &command{name: "stats", readOnly: true}}
+	jcx := &journalContext{config: readOnly, name: "bob", dir: dir}
+	if r := readJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if jcx.db.lastSync != "2005-01-01 00:00:00" || jcx.db.userMap[7] != "alice" {
+		t.Errorf("Unexpected read-only DB %+v", jcx.db)
+	}
+	if _, err := os.Stat(filepath.Join(dir, journalDBFileName)); !os.IsNotExist(err) {
+		t.Errorf("A read-only command wrote the DB, %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "comment.meta")); err != nil {
+		t.Error(err)
+	}
+	if r := writeJournalDB(jcx); r == nil {
+		t.Error("A read-only command could write the DB")
+	}
+
+	// Other commands leave the conversion to dump and migrate
+	config := &Config{dumpDir: dir, journalAliases: make(map[string]string)}
+	jcx = &journalContext{config: config, name: "bob", dir: dir}
+	if r := readJournalDB(jcx); r == nil {
+		t.Error("Read the files of ljdump.py without the conversion")
+	}
+
+	jcx = &journalContext{config: config, name: "bob", dir: dir, convertPythonFiles: true}
+	if r := readJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if jcx.db.lastSync != "2005-01-01 00:00:00" || len(jcx.db.commentMap) != 2 ||
+		jcx.db.commentMap[11] != (commentMeta{7, "A"}) || jcx.db.commentMap[12] != (commentMeta{0, "S"}) ||
+		jcx.db.userMap[7] != "alice" {
+		t.Errorf("Unexpected converted DB %+v", jcx.db)
+	}
+	for _, name := range []string{".last", ".lastid", "comment.meta", "user.map", "tags.cache"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s was not moved, %v", name, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, legacyDirName, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "L-1")); err != nil {
+		t.Error(err)
+	}
+
+	jcx = &journalContext{config: config, name: "bob", dir: dir}
+	if r := readJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if jcx.db.lastSync != "2005-01-01 00:00:00" || len(jcx.db.commentMap) != 2 || jcx.db.userMap[7] != "alice" {
+		t.Errorf("Unexpected DB after the conversion %+v", jcx.db)
+	}
+}
@@ -0,0 +1,71 @@
+package ljarchive
+
+import (
+	"encoding/xml"
+	"sort"
+)
+
+type CommentId int64
+
+// Comment is a comment in the C-<itemid> file of its entry
+type Comment struct {
+	Id    CommentId `xml:"id"`
+	State string    `xml:"state"`
+	User  string    `xml:"user"`
+
+	// Use string, not CommentId, as this can be empty
+	ParentId string `xml:"parentid"`
+	Date     string `xml:"date"`
+	Subject  string `xml:"subject"`
+	Body     string `xml:"body"`
+
+	// The edit_time property of edited comments
+	EditTime string `xml:"edittime,omitempty"`
+
+	// Time in RFC 3339 when the dump found the archived comment deleted on
+	// the server. Subject and Body keep the text from before the deletion.
+	Deleted string `xml:"deleted,omitempty"`
+
+	// Properties identifying the poster, like poster_ip, that the server
+	// gives to maintainers. They are present only when the archive was
+	// configured to keep them.
+	PosterProps []CommentProp `xml:"posterprops>prop,omitempty"`
+
+	// Earlier versions of an edited comment, the oldest first
+	History []CommentVersion `xml:"history>version,omitempty"`
+}
+
+type CommentProp struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:",chardata"`
+}
+
+type CommentVersion struct {
+	EditTime string `xml:"edittime,omitempty"`
+	State    string `xml:"state"`
+	Subject  string `xml:"subject"`
+	Body     string `xml:"body"`
+}
+
+// CommentFile is the content of a comment file
+type CommentFile struct {
+	XMLName  xml.Name  `xml:"comments"`
+	Comments []Comment `xml:"comment"`
+}
+
+type sortCommentsById []Comment
+
+func (a sortCommentsById) Len() int           { return len(a) }
+func (a sortCommentsById) Less(i, j int) bool { return a[i].Id < a[j].Id }
+func (a sortCommentsById) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// ParseComments parses the data of a comment file and returns the comments
+// sorted by id
+func ParseComments(data []byte) ([]Comment, error) {
+	var stored CommentFile
+	if err := xml.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	sort.Sort(sortCommentsById(stored.Comments))
+	return stored.Comments, nil
+}
@@ -0,0 +1,172 @@
+package ljarchive
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Name and value of the attribute of elements with the value in base64.
+// ljdumpgo stores so values with characters that XML does not allow.
+const ValueEncodingAttr = "encoding"
+const Base64ValueEncoding = "base64"
+
+// Entry is an entry as the server returned it. Only the fields that
+// archive consumers need are typed, Props keeps all entry properties as
+// strings.
+type Entry struct {
+	ItemId int64
+
+	// The name and the directory of the entry file when read from a file
+	FileName string
+	Dir      string
+
+	// The local time of the poster in the "2006-01-02 15:04:05" form
+	EventTime string
+
+	// The time with the UTC offset if known
+	EventTimeRfc3339 string
+
+	Subject string
+
+	// The body with LJ markup
+	Event string
+
+	// public, private or usemask with AllowMask selecting friend groups
+	Security  string
+	AllowMask int64
+	Anum      int64
+	Url       string
+
+	// The number in the entry URL, ItemId*256+Anum, and the URL of the
+	// entry page. Ditemid is 0 when the file has no anum. Permalink is
+	// the URL from the server or the one ljdumpgo computed, empty for
+	// files stored before ljdumpgo recorded it when the server sent no
+	// URL.
+	Ditemid   int64
+	Permalink string
+
+	// The poster of a community entry or empty for an own entry
+	Poster string
+
+	// The adult_content prop, none, concepts or explicit, and the
+	// opt_screening prop, A, F, R, L or N, empty when the entry uses the
+	// journal default
+	AdultContent string
+	Screening    string
+
+	Props map[string]string
+}
+
+func isBase64Element(start xml.StartElement) bool {
+	for _, attr := range start.Attr {
+		if attr.Name.Local == ValueEncodingAttr && attr.Value == Base64ValueEncoding {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseEntry parses the data of an entry file
+func ParseEntry(data []byte) (*Entry, error) {
+	entry := &Entry{Props: make(map[string]string)}
+	hasAnum := false
+	d := xml.NewDecoder(bytes.NewReader(data))
+	var path []string
+	var text []byte
+
+	// Elements on the path with the value in base64
+	var encoded []bool
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			path = append(path, t.Name.Local)
+			encoded = append(encoded, isBase64Element(t))
+			text = text[:0]
+		case xml.CharData:
+			text = append(text, t...)
+		case xml.EndElement:
+			value := string(text)
+			text = text[:0]
+			if encoded[len(encoded)-1] {
+				decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+				if err != nil {
+					return nil, fmt.Errorf("invalid base64 value of %s - %s", t.Name.Local, err.Error())
+				}
+				value = string(decoded)
+			}
+			encoded = encoded[:len(encoded)-1]
+			if len(path) == 2 {
+				switch path[1] {
+				case "itemid":
+					entry.ItemId, _ = strconv.ParseInt(value, 10, 64)
+				case "eventtime":
+					entry.EventTime = value
+				case "eventtime_rfc3339":
+					entry.EventTimeRfc3339 = value
+				case "subject":
+					entry.Subject = value
+				case "event":
+					entry.Event = value
+				case "security":
+					entry.Security = value
+				case "allowmask":
+					entry.AllowMask, _ = strconv.ParseInt(value, 10, 64)
+				case "anum":
+					entry.Anum, _ = strconv.ParseInt(value, 10, 64)
+					hasAnum = true
+				case "url":
+					entry.Url = value
+				case "ditemid":
+					entry.Ditemid, _ = strconv.ParseInt(value, 10, 64)
+				case "permalink":
+					entry.Permalink = value
+				case "poster":
+					entry.Poster = value
+				}
+			} else if len(path) == 3 && path[1] == "props" {
+				entry.Props[path[2]] = value
+			}
+			path = path[:len(path)-1]
+		}
+	}
+	if entry.Security == "" {
+		entry.Security = "public"
+	}
+	if entry.Ditemid == 0 && hasAnum && entry.ItemId != 0 {
+		entry.Ditemid = entry.ItemId*256 + entry.Anum
+	}
+	if entry.Permalink == "" {
+		entry.Permalink = entry.Url
+	}
+	entry.AdultContent = entry.Props["adult_content"]
+	entry.Screening = entry.Props["opt_screening"]
+	return entry, nil
+}
+
+// ParseEntryFile parses the data of the entry file at path. The itemid
+// comes from the file name when the file has none.
+func ParseEntryFile(path string, data []byte) (*Entry, error) {
+	entry, err := ParseEntry(data)
+	if err != nil {
+		return nil, err
+	}
+	entry.FileName = filepath.Base(path)
+	entry.Dir = filepath.Dir(path)
+	if entry.ItemId == 0 {
+		entry.ItemId = parseItemId(entry.FileName)
+	}
+	return entry, nil
+}
@@ -0,0 +1,263 @@
+// Package ljarchive gives typed read access to journal archives made by
+// ljdumpgo and by ljdump.py. A journal archive is a directory with the
+// entry files L-<itemid> and the comment files C-<itemid> in the flat or
+// the year-month layout, the journal DB journal.linedb and, in the dump
+// directory next to it, account.data with userpics of the account. For
+// archives of ljdump.py the last sync time comes from .last and userpics
+// from userpics.xml in the journal directory.
+//
+// ljdumpgo parses entry and comment files with ParseEntry and
+// ParseComments of this package, so other Go tools see their content
+// exactly as the exports do. It walks the archive with its own code
+// though, which skips unreadable entries with a warning where
+// Journal.Entries fails.
+package ljarchive
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"linedb"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+const JournalDBFileName = "journal.linedb"
+const AccountDataDirName = "account.data"
+const AccountDataDBFileName = "account.linedb"
+
+// Files gives read access to the archive. The paths use the separator of
+// the operating system.
+type Files interface {
+	ReadFile(path string) ([]byte, error)
+
+	// List the directory sorted by name
+	ReadDir(path string) ([]os.FileInfo, error)
+}
+
+// LocalFiles reads the archive from the local file system
+type LocalFiles struct{}
+
+func (LocalFiles) ReadFile(path string) ([]byte, error) {
+	return ioutil.ReadFile(path)
+}
+
+func (LocalFiles) ReadDir(path string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(path)
+}
+
+// The linedb decoder checks the name of the previous item when it reads a
+// table, so files that start with a table need a valid name from the start
+func newDecoder(data []byte) *linedb.Decoder {
+	d := linedb.NewByteDecoder(data)
+	d.ItemName = "_"
+	return d
+}
+
+var dumpFileNamePattern = regexp.MustCompile(`^[A-Z]-[0-9]+$`)
+var shardYearPattern = regexp.MustCompile(`^[0-9]{4}$`)
+var shardMonthPattern = regexp.MustCompile(`^[0-9]{2}$`)
+
+// IsDumpFileName checks that the name is of an entry or comment file
+func IsDumpFileName(name string) bool {
+	return dumpFileNamePattern.MatchString(name)
+}
+
+// IsShardDir checks that the name at the depth below the journal
+// directory, 0 for years and 1 for months, is of a year-month layout
+// directory
+func IsShardDir(name string, depth int) bool {
+	return depth == 0 && shardYearPattern.MatchString(name) || depth == 1 && shardMonthPattern.MatchString(name)
+}
+
+// ListDumpFiles lists entry and comment files of the journal in any layout
+// as paths relative to dir
+func ListDumpFiles(files Files, dir string) ([]string, error) {
+	var list []string
+	var scan func(rel string, depth int) error
+	scan = func(rel string, depth int) error {
+		fileInfos, err := files.ReadDir(filepath.Join(dir, rel))
+		if err != nil {
+			return err
+		}
+		for _, fileInfo := range fileInfos {
+			name := fileInfo.Name()
+			if fileInfo.Mode().IsRegular() && IsDumpFileName(name) {
+				list = append(list, filepath.Join(rel, name))
+			} else if fileInfo.IsDir() && IsShardDir(name, depth) {
+				if err := scan(filepath.Join(rel, name), depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := scan("", 0); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Journal is an opened journal archive
+type Journal struct {
+	files Files
+	dir   string
+
+	// Paths of entry and comment files relative to dir by file name
+	dumpFiles map[string]string
+}
+
+// OpenJournal opens the journal archive in the local directory
+func OpenJournal(dir string) (*Journal, error) {
+	return OpenJournalFiles(LocalFiles{}, dir)
+}
+
+// OpenJournalFiles opens the journal archive in the directory of files
+func OpenJournalFiles(files Files, dir string) (*Journal, error) {
+	list, err := ListDumpFiles(files, dir)
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{files: files, dir: dir, dumpFiles: make(map[string]string, len(list))}
+	for _, rel := range list {
+		name := filepath.Base(rel)
+
+		// A comment file fetched before its entry can stay in the journal
+		// directory, the one next to the entry is the current one
+		if old := j.dumpFiles[name]; old == "" || filepath.Dir(old) == "." {
+			j.dumpFiles[name] = rel
+		}
+	}
+	return j, nil
+}
+
+// Dir gets the directory of the journal
+func (j *Journal) Dir() string {
+	return j.dir
+}
+
+// Name gets the journal name that the directory is named after
+func (j *Journal) Name() string {
+	return filepath.Base(j.dir)
+}
+
+type sortEntriesByItemId []*Entry
+
+func (a sortEntriesByItemId) Len() int           { return len(a) }
+func (a sortEntriesByItemId) Less(i, j int) bool { return a[i].ItemId < a[j].ItemId }
+func (a sortEntriesByItemId) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// Entries reads all entries of the journal sorted by itemid. It fails on
+// the first file that cannot be read or parsed.
+func (j *Journal) Entries() ([]*Entry, error) {
+	var entries []*Entry
+	for name, rel := range j.dumpFiles {
+		if name[0] != 'L' {
+			continue
+		}
+		entry, err := j.readEntryFile(rel)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	sort.Sort(sortEntriesByItemId(entries))
+	return entries, nil
+}
+
+// Entry reads the entry with the itemid or returns nil if it is not
+// archived
+func (j *Journal) Entry(itemId int64) (*Entry, error) {
+	rel := j.dumpFiles[fmt.Sprintf("L-%d", itemId)]
+	if rel == "" {
+		return nil, nil
+	}
+	return j.readEntryFile(rel)
+}
+
+func (j *Journal) readEntryFile(rel string) (*Entry, error) {
+	path := filepath.Join(j.dir, rel)
+	data, err := j.files.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := ParseEntryFile(path, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s - %s", path, err.Error())
+	}
+	return entry, nil
+}
+
+// Comments reads the archived comments to the entry with the itemid sorted
+// by comment id. It returns nil when the entry has no archived comments.
+func (j *Journal) Comments(itemId int64) ([]Comment, error) {
+	rel := j.dumpFiles[fmt.Sprintf("C-%d", itemId)]
+	if rel == "" {
+		return nil, nil
+	}
+	path := filepath.Join(j.dir, rel)
+	data, err := j.files.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	comments, err := ParseComments(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse comments from %s - %s", path, err.Error())
+	}
+	return comments, nil
+}
+
+// LastSync gets the server time of the last synced change in the
+// "2006-01-02 15:04:05" form or an empty string if the journal was never
+// synced
+func (j *Journal) LastSync() (string, error) {
+	path := filepath.Join(j.dir, JournalDBFileName)
+	data, err := j.files.ReadFile(path)
+	if err == nil {
+		// Other values are not read, so stop at the scalar
+		lastSync := ""
+		d := newDecoder(data)
+		for d.NextItem() {
+			if d.ItemKind == linedb.ScalarItem && d.ItemName == "lastSync" {
+				lastSync = d.GetString()
+				break
+			} else if d.ItemKind == linedb.TableItem {
+				for d.NextRow() {
+				}
+			}
+		}
+		if err := d.GetError(); err != nil {
+			return "", fmt.Errorf("failed to parse %s - %s", path, err.Error())
+		}
+		return lastSync, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	// ljdump.py keeps the time on the first line of .last
+	data, err = j.files.ReadFile(filepath.Join(j.dir, ".last"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	if s.Scan() {
+		return s.Text(), nil
+	}
+	return "", s.Err()
+}
+
+func parseItemId(name string) int64 {
+	if len(name) < 3 {
+		return 0
+	}
+	itemId, _ := strconv.ParseInt(name[2:], 10, 64)
+	return itemId
+}
@@ -0,0 +1,93 @@
+package ljarchive
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestFiles(t *testing.T, root string, files map[string]string) {
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestJournal(t *testing.T) {
+	root, err := ioutil.TempDir("", "ljarchive-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	writeTestFiles(t, root, map[string]string{
+		"bob/L-1": `<?xml version="1.0"?><event><itemid>1</itemid><eventtime>2009-03-05 14:22:00</eventtime>` +
+			`<subject>Hi</subject><event encoding="base64">YmVsbAc=</event><props><taglist>a, b</taglist></props></event>`,
+		"bob/2010/05/L-2": `<event><eventtime>2010-05-01 10:00:00</eventtime><security>private</security></event>`,
+		"bob/2010/05/C-2": `<comments><comment><id>9</id><user>ann</user><body>second</body></comment>` +
+			`<comment><id>3</id><parentid></parentid><body>first</body></comment></comments>`,
+		"bob/C-2":                     `<comments></comments>`,
+		"bob/notes.txt":               "not an entry",
+		"bob/journal.linedb":          "schemaVersion 2\nlastSync \"2010-05-01 10:00:00\"\n",
+		"account.data/account.linedb": "schemaVersion 1\nfileCounter 1\npictureDefaultUrl \"http://pic/1\"\n@table pictureUrlFileMap\n\"http://pic/1\" \"user-picture-1.png\"\n@end\n@table pictureKeywordUrlMap\n\"smile\" \"http://pic/1\"\n@end\n",
+		"python/old/userpics.xml":     `<?xml version="1.0"?><userpics><userpic keyword="*" url="http://pic/2" /><userpic keyword="a/b" url="http://pic/3" /></userpics>`,
+		"python/old/a_b.jpg":          "jpeg",
+		"python/old/.last":            "2005-01-01 00:00:00\n42\n",
+	})
+
+	j, err := OpenJournal(filepath.Join(root, "bob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := j.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].ItemId != 1 || entries[1].ItemId != 2 {
+		t.Fatalf("Unexpected entries %v", entries)
+	}
+	if entries[0].Event != "bell\x07" || entries[0].Props["taglist"] != "a, b" || entries[0].Security != "public" {
+		t.Errorf("Unexpected entry %v", entries[0])
+	}
+	if entries[1].Security != "private" || entries[1].Dir != filepath.Join(root, "bob", "2010", "05") {
+		t.Errorf("Unexpected entry %v", entries[1])
+	}
+	comments, err := j.Comments(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comments) != 2 || comments[0].Id != 3 || comments[1].User != "ann" {
+		t.Errorf("Unexpected comments %v", comments)
+	}
+	if lastSync, err := j.LastSync(); err != nil || lastSync != "2010-05-01 10:00:00" {
+		t.Errorf("Unexpected last sync %q %v", lastSync, err)
+	}
+	pics, err := j.Userpics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pics) != 1 || !pics[0].Default || len(pics[0].Keywords) != 1 || pics[0].File != filepath.Join(root, "account.data", "user-picture-1.png") {
+		t.Errorf("Unexpected userpics %v", pics)
+	}
+
+	old, err := OpenJournal(filepath.Join(root, "python", "old"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastSync, err := old.LastSync(); err != nil || lastSync != "2005-01-01 00:00:00" {
+		t.Errorf("Unexpected last sync of ljdump.py archive %q %v", lastSync, err)
+	}
+	pics, err = old.Userpics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pics) != 2 || !pics[0].Default || pics[1].Keywords[0] != "a/b" || pics[1].File != filepath.Join(root, "python", "old", "a_b.jpg") {
+		t.Errorf("Unexpected userpics of ljdump.py archive %v", pics)
+	}
+}
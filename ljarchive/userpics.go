@@ -0,0 +1,157 @@
+package ljarchive
+
+import (
+	"encoding/xml"
+	"linedb"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// Userpic is an archived picture of the account
+type Userpic struct {
+	Url string
+
+	// Path of the picture file or empty if it was not downloaded
+	File string
+
+	// Keywords currently assigned to the picture
+	Keywords []string
+	Default  bool
+
+	// From the allpics page of the account, ljdump.py did not archive it
+	Description string
+}
+
+type sortUserpicsByUrl []*Userpic
+
+func (a sortUserpicsByUrl) Len() int           { return len(a) }
+func (a sortUserpicsByUrl) Less(i, j int) bool { return a[i].Url < a[j].Url }
+func (a sortUserpicsByUrl) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+// Userpics reads the userpics of the account that made the archive from
+// account.data in the dump directory of the journal or, for an archive of
+// ljdump.py, from userpics.xml in the journal directory. It returns nil
+// when neither exists.
+func (j *Journal) Userpics() ([]*Userpic, error) {
+	accountDir := filepath.Join(filepath.Dir(j.dir), AccountDataDirName)
+	path := filepath.Join(accountDir, AccountDataDBFileName)
+	data, err := j.files.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return j.pythonUserpics()
+		}
+		return nil, err
+	}
+	byUrl := make(map[string]*Userpic)
+	get := func(url string) *Userpic {
+		pic := byUrl[url]
+		if pic == nil {
+			pic = &Userpic{Url: url}
+			byUrl[url] = pic
+		}
+		return pic
+	}
+	d := newDecoder(data)
+	for d.NextItem() {
+		switch d.ItemKind {
+		case linedb.ScalarItem:
+			switch d.ItemName {
+			case "schemaVersion", "fileCounter":
+				d.GetInt64()
+			case "pictureDefaultUrl":
+				if url := d.GetString(); url != "" {
+					get(url).Default = true
+				}
+			}
+		case linedb.TableItem:
+			for d.NextRow() {
+				switch d.ItemName {
+				case "pictureUrlFileMap":
+					pic := get(d.GetString())
+					pic.File = filepath.Join(accountDir, d.GetString())
+				case "pictureKeywordUrlMap":
+					keyword := d.GetString()
+					pic := get(d.GetString())
+					pic.Keywords = append(pic.Keywords, keyword)
+				case "pictureInfo":
+					pic := get(d.GetString())
+					pic.Description = d.GetString()
+					d.GetString()
+					d.GetInt()
+				}
+			}
+		}
+	}
+	if err := d.GetError(); err != nil {
+		return nil, err
+	}
+	pics := make([]*Userpic, 0, len(byUrl))
+	for _, pic := range byUrl {
+		sort.Strings(pic.Keywords)
+		pics = append(pics, pic)
+	}
+	sort.Sort(sortUserpicsByUrl(pics))
+	return pics, nil
+}
+
+// ljdump.py writes the picture of a keyword into the journal directory
+// named after the keyword with these characters replaced by _ and the
+// extension of the content type. The default picture has the keyword *.
+var pythonPictureNameChars = regexp.MustCompile(`[*?\\/:<>"|]`)
+var pythonPictureExtensions = []string{".gif", ".jpg", ".png", ""}
+
+func (j *Journal) pythonUserpics() ([]*Userpic, error) {
+	data, err := j.files.ReadFile(filepath.Join(j.dir, "userpics.xml"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var stored struct {
+		Userpics []struct {
+			Keyword string `xml:"keyword,attr"`
+			Url     string `xml:"url,attr"`
+		} `xml:"userpic"`
+	}
+	if err := xml.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	if infos, err := j.files.ReadDir(j.dir); err == nil {
+		for _, info := range infos {
+			names[info.Name()] = true
+		}
+	}
+	byUrl := make(map[string]*Userpic)
+	var pics []*Userpic
+	for _, p := range stored.Userpics {
+		pic := byUrl[p.Url]
+		if pic == nil {
+			pic = &Userpic{Url: p.Url}
+			byUrl[p.Url] = pic
+			pics = append(pics, pic)
+		}
+		if p.Keyword == "*" {
+			pic.Default = true
+		} else {
+			pic.Keywords = append(pic.Keywords, p.Keyword)
+		}
+		if pic.File == "" {
+			base := pythonPictureNameChars.ReplaceAllString(p.Keyword, "_")
+			for _, ext := range pythonPictureExtensions {
+				if names[base+ext] {
+					pic.File = filepath.Join(j.dir, base+ext)
+					break
+				}
+			}
+		}
+	}
+	for _, pic := range pics {
+		sort.Strings(pic.Keywords)
+	}
+	sort.Sort(sortUserpicsByUrl(pics))
+	return pics, nil
+}
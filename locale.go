@@ -0,0 +1,161 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Locale for dates in exports and served pages. Without a locale the raw
+// LJ time strings are shown.
+type dateLocale struct {
+	name string
+
+	// Month names as used inside a date, in the genitive case for
+	// languages that need it
+	months [12]string
+
+	// Standalone month names for headings
+	monthTitles [12]string
+
+	// Layout with {day}, {month}, {year} and {time} placeholders
+	dateLayout string
+}
+
+var dateLocales = []*dateLocale{
+	{
+		name: "en",
+		months: [12]string{
+			"January", "February", "March", "April", "May", "June",
+			"July", "August", "September", "October", "November", "December",
+		},
+		monthTitles: [12]string{
+			"January", "February", "March", "April", "May", "June",
+			"July", "August", "September", "October", "November", "December",
+		},
+		dateLayout: "{month} {day}, {year}",
+	},
+	{
+		name: "ru",
+		months: [12]string{
+			"января", "февраля", "марта", "апреля", "мая", "июня",
+			"июля", "августа", "сентября", "октября", "ноября", "декабря",
+		},
+		monthTitles: [12]string{
+			"Январь", "Февраль", "Март", "Апрель", "Май", "Июнь",
+			"Июль", "Август", "Сентябрь", "Октябрь", "Ноябрь", "Декабрь",
+		},
+		dateLayout: "{day} {month} {year}",
+	},
+	{
+		name: "uk",
+		months: [12]string{
+			"січня", "лютого", "березня", "квітня", "травня", "червня",
+			"липня", "серпня", "вересня", "жовтня", "листопада", "грудня",
+		},
+		monthTitles: [12]string{
+			"Січень", "Лютий", "Березень", "Квітень", "Травень", "Червень",
+			"Липень", "Серпень", "Вересень", "Жовтень", "Листопад", "Грудень",
+		},
+		dateLayout: "{day} {month} {year}",
+	},
+	{
+		name: "de",
+		months: [12]string{
+			"Januar", "Februar", "März", "April", "Mai", "Juni",
+			"Juli", "August", "September", "Oktober", "November", "Dezember",
+		},
+		monthTitles: [12]string{
+			"Januar", "Februar", "März", "April", "Mai", "Juni",
+			"Juli", "August", "September", "Oktober", "November", "Dezember",
+		},
+		dateLayout: "{day}. {month} {year}",
+	},
+	{
+		name: "fr",
+		months: [12]string{
+			"janvier", "février", "mars", "avril", "mai", "juin",
+			"juillet", "août", "septembre", "octobre", "novembre", "décembre",
+		},
+		monthTitles: [12]string{
+			"Janvier", "Février", "Mars", "Avril", "Mai", "Juin",
+			"Juillet", "Août", "Septembre", "Octobre", "Novembre", "Décembre",
+		},
+		dateLayout: "{day} {month} {year}",
+	},
+}
+
+func findDateLocale(name string) *dateLocale {
+	for _, locale := range dateLocales {
+		if locale.name == name {
+			return locale
+		}
+	}
+	return nil
+}
+
+func dateLocaleNames() string {
+	names := make([]string, len(dateLocales))
+	for i, locale := range dateLocales {
+		names[i] = locale.name
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Time layouts of entries and comments in the archive. The flag tells if
+// the layout has the time of day.
+var archiveTimeLayouts = []struct {
+	layout  string
+	hasTime bool
+}{
+	{"2006-01-02 15:04:05", true},
+	{"2006-01-02T15:04:05Z07:00", true},
+	{"2006-01-02 15:04", true},
+	{"2006-01-02", false},
+}
+
+// Format the entry or comment time for display. Return the string as is
+// without a locale or when it cannot be parsed.
+func (config *Config) formatDate(s string) string {
+	locale := config.dateLocale
+	if locale == nil {
+		return s
+	}
+	for _, l := range archiveTimeLayouts {
+		t, err := time.Parse(l.layout, s)
+		if err != nil {
+			continue
+		}
+		date := strings.NewReplacer(
+			"{day}", strconv.Itoa(t.Day()),
+			"{month}", locale.months[t.Month()-1],
+			"{year}", strconv.Itoa(t.Year()),
+		).Replace(locale.dateLayout)
+		if l.hasTime {
+			date += ", " + t.Format("15:04")
+		}
+		return date
+	}
+	return s
+}
+
+// Get the standalone name of the month 1-12 for headings.
+func (config *Config) monthTitle(month int) string {
+	if month < 1 || month > 12 {
+		return "?"
+	}
+	if config.dateLocale == nil {
+		return time.Month(month).String()
+	}
+	return config.dateLocale.monthTitles[month-1]
+}
+
+// Get the language code for the document metadata.
+func (config *Config) documentLanguage() string {
+	if config.dateLocale == nil {
+		return "und"
+	}
+	return config.dateLocale.name
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func Test_formatDate(t *testing.T) {
+	cases := []struct {
+		locale string
+		date   string
+		result string
+	}{
+		{"", "2009-03-05 14:22:00", "2009-03-05 14:22:00"},
+		{"ru", "2009-03-05 14:22:00", "5 марта 2009, 14:22"},
+		{"en", "2009-03-05 14:22:00", "March 5, 2009, 14:22"},
+		{"de", "2009-03-05", "5. März 2009"},
+		{"ru", "2005-03-06T10:15:00Z", "6 марта 2005, 10:15"},
+		{"ru", "not a date", "not a date"},
+	}
+	for _, c := range cases {
+		config := &Config{dateLocale: findDateLocale(c.locale)}
+		if result := config.formatDate(c.date); result != c.result {
+			t.Errorf("Expected %q for %s in locale %q, got %q", c.result, c.date, c.locale, result)
+		}
+	}
+}
+
+func Test_normalizeEventTime(t *testing.T) {
+	zone, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+	config := &Config{timeZone: zone}
+	if s := config.normalizeEventTime("2009-03-05 14:22:00"); s != "2009-03-05T14:22:00+03:00" {
+		t.Errorf("Unexpected normalized time %s", s)
+	}
+	if s := (&Config{}).normalizeEventTime("2009-03-05 14:22:00"); s != "" {
+		t.Errorf("Expected no normalized time without a zone, got %s", s)
+	}
+
+	// An entry posted later but from a zone further east sorts first
+	entries := []*archivedEntry{
+		{itemId: 1, eventTime: "2009-03-05 12:00:00", eventTimeRfc3339: "2009-03-05T12:00:00Z"},
+		{itemId: 2, eventTime: "2009-03-05 14:00:00", eventTimeRfc3339: "2009-03-05T14:00:00+03:00"},
+	}
+	sort.Sort(sortEntriesByTime(entries))
+	if entries[0].itemId != 2 {
+		t.Errorf("Expected the entry with the earlier absolute time first")
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Commands that write into the dump directory hold an advisory lock on
+// this file so overlapping runs, for example from cron, cannot rewrite the
+// same DB files at the same time. The lock is released when the process
+// exits, so a crash never leaves a stale lock. The file itself is never
+// removed as that would race with a process that just opened it.
+const lockFileName = "ljdump.lock"
+
+const lockPollInterval = time.Second
+
+func (config *Config) needsDumpLock() bool {
+	return !config.command.readOnly || config.recoverCorruptDBs
+}
+
+// Check if the run may change the journal directories. Read-only commands
+// run without the lock, so they must not write there even when the data
+// they read is outdated.
+func (config *Config) writesArchive() bool {
+	return config.command == nil || config.needsDumpLock()
+}
+
+// Take the lock for the rest of the process lifetime. With -wait-lock wait
+// until the other run finishes, otherwise fail with the details of the
+// holder.
+func acquireDumpLock(config *Config) (*os.File, *Report) {
+	path := filepath.Join(config.dumpDir, lockFileName)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, archiveFileMode)
+	if err != nil {
+		return nil, WrapErr(err, "failed to open lock file")
+	}
+	waiting := false
+	for {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, WrapErr(err, "failed to lock %s", path)
+		}
+		if locked {
+			break
+		}
+		holder := "another ljdumpgo"
+		if data, err := ioutil.ReadFile(path); err == nil && len(data) != 0 {
+			holder = strings.TrimSpace(string(data))
+		}
+		if !config.waitLock {
+			f.Close()
+			return nil, ReportMsg(
+				"the dump directory is in use by %s, wait for it to finish or pass -wait-lock to wait automatically",
+				holder,
+			)
+		}
+		if !waiting {
+			log("Waiting for %s to finish", holder)
+			waiting = true
+		}
+		time.Sleep(lockPollInterval)
+	}
+
+	// Describe the holder for the error message of other runs
+	info := fmt.Sprintf("ljdumpgo %s with pid %d started %s", config.command.name, os.Getpid(), time.Now().Format("2006-01-02 15:04:05"))
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(info+"\n"), 0)
+	}
+	return f, nil
+}
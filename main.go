@@ -3,11 +3,12 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"crypto/md5"
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/xml"
 	"flag"
 	"fmt"
-	"github.com/hydrogen18/stalecucumber"
+	"github.com/ibukanov/ljdump-go/ljarchive"
 	"github.com/kolo/xmlrpc"
 	"io/ioutil"
 	"linedb"
@@ -22,6 +23,7 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 func log(format string, a ...interface{}) {
@@ -58,6 +60,9 @@ type Report struct {
 	message  string
 	err      error
 	combined []*Report
+
+	// Kind of the failure for the exit status, see exitcodes.go
+	category errorCategory
 }
 
 func ReportMsg(format string, a ...interface{}) *Report {
@@ -65,6 +70,7 @@ func ReportMsg(format string, a ...interface{}) *Report {
 		fmt.Sprintf(format, a...),
 		nil,
 		nil,
+		"",
 	}
 }
 
@@ -76,6 +82,7 @@ func WrapErr(err error, format string, a ...interface{}) *Report {
 		fmt.Sprintf(format, a...),
 		err,
 		nil,
+		"",
 	}
 }
 
@@ -90,6 +97,7 @@ func CombineReports(r1, r2 *Report) *Report {
 		"",
 		nil,
 		[]*Report{r1, r2},
+		"",
 	}
 }
 
@@ -108,14 +116,7 @@ func (r *Report) AsText() string {
 }
 
 func writeFileTempRename(filePath string, data []byte) error {
-	tmp := filePath + ".tmp"
-	if err := ioutil.WriteFile(tmp, data, 0666); err != nil {
-		return err
-	}
-	if err := os.Rename(tmp, filePath); err != nil {
-		return err
-	}
-	return nil
+	return archiveStore.WriteFile(filePath, data, archiveFileMode)
 }
 
 const defaultConfigFile = "ljdump.config"
@@ -126,14 +127,390 @@ const accountDataDBFileName = "account.linedb"
 
 const serverUrlCompabilitySuffix = "/interface/xmlrpc"
 const defaultLJServer = "https://livejournal.com"
+const defaultJournalTimeSlice = 10 * time.Minute
+const defaultWatchInterval = 24 * time.Hour
 
 type Config struct {
-	server         string
-	username       string
-	journals       []string
-	password       string
+	command    *command
+	commandArg string
+	server     string
+	username   string
+	journals   []string
+	password   string
+
+	// Groups of journals from the config limited to the group selected
+	// with -group. journals contains the journals of these groups and
+	// allJournals the journals of all groups.
+	journalGroups  []*journalGroup
+	allJournals    []string
 	dumpDir        string
 	accountDataDir string
+
+	// When archiving several journals, switch to the next journal after
+	// this time and continue with the rest later in round-robin order.
+	journalTimeSlice time.Duration
+
+	// Wait for another run holding the dump directory lock instead of
+	// failing
+	waitLock bool
+
+	// Errors and warnings of the run for the error log, see errorlog.go
+	errorLog *errorLog
+
+	// Limits for a single HTTP request and for the whole run. The zero
+	// values mean no limit.
+	requestTimeout time.Duration
+	maxRuntime     time.Duration
+	runDeadline    time.Time
+
+	// Limits of requests and new entries of the run, see budget.go. Nil
+	// means no limit.
+	budget *runBudget
+
+	// Map from journal name to archive directory for renamed journals
+	journalAliases    map[string]string
+	renameJournalDirs bool
+
+	// Archive all communities that the user maintains in addition to
+	// journals
+	allCommunities bool
+
+	// Archive images referenced by entries
+	downloadMedia bool
+
+	// Revalidate archived userpics and images with conditional requests
+	refreshMedia bool
+
+	// Check archived comments for edits and state changes, see
+	// comment_edits.go
+	recheckComments bool
+
+	// Fetch entries of journals never synced before from the monthly
+	// export, see bootstrap.go
+	bootstrap bool
+
+	// Archive the customization pages of the account, see style.go
+	archiveStyle bool
+
+	// Archive members and moderation data of maintained communities, see
+	// community_info.go, and the submissions in their moderation queues,
+	// see moderation.go
+	archiveCommunityInfo   bool
+	archiveModerationQueue bool
+
+	// Archive private messages and notifications, see inbox.go
+	archiveInbox bool
+
+	// Save the rendered pages of entries, see snapshot.go
+	archiveSnapshots bool
+
+	// Modes of created files and directories, see perms.go
+	fileMode os.FileMode
+	dirMode  os.FileMode
+
+	// WebDAV server that holds the archive or nil for the local
+	// directory, see store.go
+	webdav *webdavConfig
+
+	// API key used in place of the password, OAuth tokens and the
+	// ljsession cookie of a browser login, see auth.go
+	apiKey        string
+	oauth         *oauthConfig
+	sessionCookie string
+
+	// Protocol backend, xmlrpc or jsonrpc, and the JSON-RPC endpoint, see
+	// jsonrpc.go
+	api    string
+	apiUrl string
+
+	// Layout of new journal archives and the target of migrate-layout or
+	// empty for the default flat layout
+	journalLayout string
+
+	// The most restricted security of entries that the dump archives or
+	// nil for all entries, see security_filter.go
+	dumpSecurity *securityLevel
+
+	// Rules that change fetched entries before they are written, see
+	// transforms.go
+	transforms []transformRule
+
+	// Limit for media downloads set with -bwlimit or nil
+	mediaBandwidth *tokenBucket
+
+	// Shared transport of all HTTP clients and the limit of response
+	// sizes or 0 for none, see transport.go
+	transport       http.RoundTripper
+	maxResponseSize int64
+
+	// TLS settings for self-hosted servers or nil for the defaults, see
+	// tls.go
+	tlsConfig *tls.Config
+
+	// The config and the files with the password, the API key or the
+	// session cookie, empty or - for those not given, see perms.go
+	secretFiles []string
+
+	// Saved searches materialized as collections after each sync
+	collections []*savedSearch
+
+	// Options for the export command
+	exportFormat string
+	exportDir    string
+	maxSecurity  securityLevel
+	fullExport   bool
+
+	// Base URL of the published jsonfeed export, see export_jsonfeed.go
+	feedUrl string
+
+	// Skip copies of entries of other exported journals, see duplicates.go
+	collapseDuplicates bool
+
+	// Pseudonymize commenters and drop screened comments, see anonymize.go
+	anonymizeComments bool
+
+	// Export a bundle per friend group, see export_groups.go. exportGroup
+	// is the group of the bundle being exported or nil.
+	exportPerGroup bool
+	exportGroup    *friendGroup
+
+	// Locale for dates in exports and served pages or nil for raw dates
+	dateLocale *dateLocale
+
+	// Time zone of entry times or nil if unknown, see timezone.go
+	timeZone *time.Location
+
+	// Options for the crosspost and restore commands, selectEntries is
+	// nil to take all entries
+	selectEntries *savedSearch
+	dryRun        bool
+	tumblr        *tumblrConfig
+	bluesky       *blueskyConfig
+
+	// Day in MM-DD form or empty for today and the output format for the
+	// onthisday command
+	digestDate   string
+	digestFormat string
+
+	// Directory for the calendar heatmaps of the stats command or empty,
+	// see heatmap.go
+	heatmapDir string
+
+	// IPFS node for the publish command or nil for the default local node
+	ipfs *ipfsConfig
+
+	// The server, user and password of the account to restore into
+	restoreServer   string
+	restoreUsername string
+	restorePassword string
+
+	// Also prepare comments for the importer of the target server and
+	// post them to commentImportUrl if not empty, see restore.go
+	restoreComments  bool
+	commentImportUrl string
+
+	// Options for the serve command
+	serveListen   string
+	serveAuthFile string
+
+	// Quarantine and rebuild journal and account DB files that fail to
+	// parse instead of stopping
+	recoverCorruptDBs bool
+
+	// Undo the recorded migration instead of migrating, see migrate.go
+	migrateRollback bool
+
+	// Directory for the pack command, and with packRotate remove old
+	// packs beyond packRetention, see pack.go
+	packDir       string
+	packRotate    bool
+	packRetention packRetention
+
+	// Shell command to run after a successful dump or empty, see hook.go
+	postHook string
+
+	// Directory or host:port for profiling data of the run or empty, see
+	// profiling.go
+	pprof string
+
+	// Keep poster IP addresses and similar comment properties, see
+	// poster_props.go
+	storePosterProps bool
+}
+
+type command struct {
+	name    string
+	summary string
+
+	// When true, the command talks to the server and the password must
+	// be available.
+	needsLogin bool
+
+	// When true, the command does not write into the dump directory and
+	// runs without the lock, see lock.go
+	readOnly bool
+
+	// When not empty, the command takes one argument described by this
+	// name after the command name
+	argName string
+	run     func(config *Config) *Report
+}
+
+// The first entry is the default command used when the command line
+// does not start with a command name.
+var commands = []*command{
+	{
+		name:       "dump",
+		summary:    "archive journals from the server (default)",
+		needsLogin: true,
+		run:        runDump,
+	},
+	{
+		name:    "dump-public",
+		summary: "archive recent public entries of -journal journals from their feeds without login",
+		run:     runDumpPublic,
+	},
+	{
+		name:    "verify",
+		summary: "check that archived files are well-formed",
+		run:     runVerify,
+	},
+	{
+		name:       "analyze",
+		summary:    "compare entry counts per year on the server with the archive",
+		needsLogin: true,
+		run:        runAnalyze,
+	},
+	{
+		name:       "doctor",
+		summary:    "check the connection, login and dump directory and suggest fixes",
+		needsLogin: true,
+		readOnly:   true,
+		run:        runDoctor,
+	},
+	{
+		name:     "stats",
+		summary:  "print statistics about archived journals",
+		readOnly: true,
+		run:      runStats,
+	},
+	{
+		name:     "export",
+		summary:  "export archived journals into other formats",
+		readOnly: true,
+		run:      runExport,
+	},
+	{
+		name:     "takeout",
+		summary:  "pack all archived journals, comments, userpics and friends with a viewer into one zip file",
+		readOnly: true,
+		run:      runTakeout,
+	},
+	{
+		name:    "pack",
+		summary: "write the dump directory into a full or differential zip in -pack-dir for backups, with -rotate remove old packs",
+		run:     runPack,
+	},
+	{
+		name:     "export-errors",
+		summary:  "write recent errors with private data removed for a bug report",
+		readOnly: true,
+		run:      runExportErrors,
+	},
+	{
+		name:    "collections",
+		summary: "recompute collections of entries defined in the config",
+		run:     runCollections,
+	},
+	{
+		name:       "watch",
+		summary:    "keep dumping journal groups on their schedules",
+		needsLogin: true,
+		run:        runWatch,
+	},
+	{
+		name:     "serve",
+		summary:  "serve archived journals on a local web server",
+		readOnly: true,
+		run:      runServe,
+	},
+	{
+		name:     "browse",
+		summary:  "browse archived entries and comments in the terminal",
+		readOnly: true,
+		run:      runBrowse,
+	},
+	{
+		name:    "migrate",
+		summary: "upgrade archived journals to the current storage format and the -layout with -dry-run and -rollback",
+		run:     runMigrate,
+	},
+	{
+		name:    "migrate-layout",
+		summary: "move entry and comment files of archived journals into the -layout",
+		run:     runMigrateLayout,
+	},
+	{
+		name:    "fix-perms",
+		summary: "set the permissions from the config on all files of the dump directory",
+		run:     runFixPerms,
+	},
+	{
+		name:    "restore",
+		summary: "post archived entries into a journal on another LJ-compatible server",
+		run:     runRestore,
+	},
+	{
+		name:    "crosspost",
+		summary: "post public entries to another platform, the target is tumblr or bluesky",
+		argName: "target",
+		run:     runCrosspost,
+	},
+	{
+		name:    "publish",
+		summary: "render public entries as a static site and add it to IPFS, the target is ipfs",
+		argName: "target",
+		run:     runPublish,
+	},
+	{
+		name:    "transform",
+		summary: "apply the -transforms rules to archived entries and their revisions, with -dry-run only list the changes",
+		run:     runTransform,
+	},
+	{
+		name:    "duplicates",
+		summary: "find entries crossposted between archived journals and record them in the entries index",
+		run:     runDuplicates,
+	},
+	{
+		name:     "onthisday",
+		summary:  "print a digest of archived entries posted on this day in past years",
+		readOnly: true,
+		run:      runOnThisDay,
+	},
+	{
+		name:     "diff",
+		summary:  "print word-level differences between archived revisions of the entry like L-123",
+		readOnly: true,
+		argName:  "entry",
+		run:      runDiff,
+	},
+	{
+		name:     "query",
+		summary:  "print archived entries matching the query like \"tag:travel before:2010 commenter:alice\"",
+		readOnly: true,
+		argName:  "query",
+		run:      runQuery,
+	},
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
 }
 
 type commandOptionStringArray []string
@@ -157,15 +534,94 @@ func loadConfig() (*Config, *Report) {
 		username     string
 		journals     commandOptionStringArray
 		passwordFile string
+		cookieFile   string
+		timeSlice    time.Duration
+		renameDirs   bool
+		allComms     bool
+		format       string
+		outputDir    string
+		feedUrl      string
+		publicOnly   bool
+		fullExport   bool
+		collapseDups bool
+		anonymize    bool
+		perGroup     bool
+		maxSecurity  string
+		recover      bool
+		rollback     bool
+		packDir      string
+		rotate       bool
+		pprof        string
+		group        string
+		reqTimeout   time.Duration
+		maxRuntime   time.Duration
+		maxRequests  int64
+		maxEntries   int64
+		media        bool
+		refreshMedia bool
+		style        bool
+		commInfo     bool
+		modQueue     bool
+		inbox        bool
+		snapshots    bool
+		recheck      bool
+		bootstrap    bool
+		layout       string
+		dumpSecurity string
+		transforms   string
+		api          string
+		apiUrl       string
+		jsonl        bool
+		latex        bool
+		graph        bool
+		bwlimit      string
+		maxResponse  string
+		caCert       string
+		tlsMin       string
+		skipVerify   bool
+		locale       string
+		timeZone     string
+		listen       string
+		authFile     string
+		interval     time.Duration
+		waitLock     bool
+		selectQuery  string
+		dryRun       bool
+		restoreTo    string
+		restoreUser  string
+		restorePass  string
+		restoreComm  bool
+		importUrl    string
+		digestDate   string
+		digestFormat string
+		heatmapDir   string
+		postHook     string
+		posterProps  string
+	}
+
+	cmd := commands[0]
+	args := os.Args[1:]
+	if len(args) != 0 && !strings.HasPrefix(args[0], "-") {
+		cmd = findCommand(args[0])
+		if cmd == nil {
+			return nil, ReportMsg("Unknown command %s", args[0])
+		}
+		args = args[1:]
+	}
+
+	// The argument may come before or after the options
+	commandArg := ""
+	if cmd.argName != "" && len(args) != 0 && !strings.HasPrefix(args[0], "-") {
+		commandArg = args[0]
+		args = args[1:]
 	}
-
 	parseCommandLine := func() *Report {
 		programName := filepath.Base(os.Args[0])
 		flags := flag.NewFlagSet(programName, flag.ContinueOnError)
 		flags.SetOutput(os.Stderr)
 
 		// Avoid printing full usage on command line errors
-		flags.Usage = func() { }
+		flags.Usage = func() {}
 
 		// Extract `` from the long option usage to construct short usage
 		findUsageTypeRe := regexp.MustCompile("`[^`]+`")
@@ -191,19 +647,236 @@ func loadConfig() (*Config, *Report) {
 			&commandOptions.passwordFile, 'p', "password-file", "",
 			"`path` to file with LJ user password, use '-' to read from stdin (password will be echoed)",
 		)
+		flags.StringVar(
+			&commandOptions.cookieFile, "session-cookie-file", "",
+			"`path` to file with the ljsession cookie of a browser login to use instead of logging in, '-' reads it from stdin",
+		)
 		addValueOpt(&commandOptions.journals, 'j', "journal", "add `journal` to the list of journals to archive. If none are given, use LJ username")
+		flags.DurationVar(
+			&commandOptions.timeSlice, "journal-time-slice", defaultJournalTimeSlice,
+			"with several journals switch to the next one after this `duration` and continue the rest later, 0 disables",
+		)
+		flags.DurationVar(
+			&commandOptions.reqTimeout, "request-timeout", defaultRequestTimeout,
+			"abort a single HTTP request after this `duration`, 0 disables",
+		)
+		flags.DurationVar(
+			&commandOptions.maxRuntime, "max-runtime", 0,
+			"stop the dump after this `duration` saving the progress, 0 disables",
+		)
+		flags.Int64Var(
+			&commandOptions.maxRequests, "max-requests", 0,
+			"stop the dump after this `number` of HTTP requests saving the progress, 0 disables",
+		)
+		flags.Int64Var(
+			&commandOptions.maxEntries, "max-new-entries", 0,
+			"stop the dump after archiving this `number` of new or updated entries saving the progress, 0 disables",
+		)
+		flags.BoolVar(
+			&commandOptions.renameDirs, "rename-journal-dirs", false,
+			"rename the archive directory of a journal renamed on the server instead of recording an alias",
+		)
+		flags.BoolVar(
+			&commandOptions.allComms, "all-communities", false,
+			"archive also all communities that the user maintains",
+		)
+		flags.BoolVar(&commandOptions.media, "download-media", false, "archive also images referenced by entries")
+		flags.BoolVar(
+			&commandOptions.recheck, "recheck-comments", false,
+			"check archived comments for edits and state changes and refetch the changed ones",
+		)
+		flags.BoolVar(
+			&commandOptions.bootstrap, "bootstrap", false,
+			"fetch entries of journals never dumped before from the monthly export, one request per month",
+		)
+		flags.BoolVar(
+			&commandOptions.style, "style", false,
+			"archive also the journal style, custom CSS and link list of the account",
+		)
+		flags.BoolVar(
+			&commandOptions.commInfo, "community-info", false,
+			"archive also members, posting access, moderation queue and banned users of maintained communities",
+		)
+		flags.BoolVar(&commandOptions.inbox, "inbox", false, "archive also private messages and notifications of the account inbox")
+		flags.BoolVar(
+			&commandOptions.snapshots, "snapshots", false,
+			"save also the entry pages as the server renders them with the journal style and comments",
+		)
+		flags.BoolVar(
+			&commandOptions.modQueue, "moderation-queue", false,
+			"archive also submissions waiting in the moderation queue of maintained communities",
+		)
+		flags.BoolVar(
+			&commandOptions.refreshMedia, "refresh-media", false,
+			"revalidate all archived userpics and images and download the changed ones",
+		)
+		flags.StringVar(
+			&commandOptions.api, "api", "",
+			"protocol `backend` for fetching entries and userpics, xmlrpc (default) or jsonrpc",
+		)
+		flags.StringVar(&commandOptions.apiUrl, "api-url", "", "`URL` of the JSON-RPC endpoint for -api=jsonrpc")
+		flags.StringVar(
+			&commandOptions.layout, "layout", "",
+			"`layout` of entry files in new journal archives and for migrate-layout, flat or year-month",
+		)
+		flags.StringVar(
+			&commandOptions.dumpSecurity, "security", "",
+			"dump: archive only entries up to this security `level`, public, friends, custom, private or all (default all)",
+		)
+		flags.StringVar(
+			&commandOptions.transforms, "transforms", "",
+			"dump: change fetched entries before writing them with the rules from the file at `path`, transform: the rules to apply",
+		)
+		flags.StringVar(
+			&commandOptions.bwlimit, "bwlimit", "",
+			"limit media downloads to this `rate` in bytes per second with optional k, M or G suffix",
+		)
+		flags.StringVar(&commandOptions.caCert, "ca-cert", "", "trust also the PEM CA certificates from the file at `path`")
+		flags.StringVar(
+			&commandOptions.tlsMin, "tls-min-version", "",
+			"oldest accepted TLS `version`, one of "+tlsVersionNames(),
+		)
+		flags.BoolVar(
+			&commandOptions.skipVerify, "insecure-skip-verify", false,
+			"accept any TLS certificate of servers, the connection can be intercepted",
+		)
+		flags.StringVar(
+			&commandOptions.maxResponse, "max-response-size", "1G",
+			"fail requests with responses larger than this `size` in bytes with optional k, M or G suffix, 0 disables",
+		)
+		flags.StringVar(
+			&commandOptions.format, "format", "html",
+			"export `format`, one of "+exportFormatNames(),
+		)
+		flags.BoolVar(&commandOptions.jsonl, "comments-jsonl", false, "export all comments as JSON Lines, same as -format comments-jsonl")
+		flags.BoolVar(&commandOptions.latex, "latex", false, "export a LaTeX book of each journal, same as -format latex")
+		flags.BoolVar(&commandOptions.graph, "graph", false, "export the social graph of each journal as GraphML and GEXF, same as -format graph")
+		flags.StringVar(&commandOptions.outputDir, "output", "export", "export output `directory`")
+		flags.StringVar(
+			&commandOptions.feedUrl, "feed-url", "",
+			"export: base `URL` where the files of -format jsonfeed are published for the feed and page links",
+		)
+		flags.BoolVar(
+			&commandOptions.fullExport, "full", false,
+			"export: rewrite the files of all entries, not only of entries changed since the previous export, pack: write a full pack",
+		)
+		flags.BoolVar(
+			&commandOptions.collapseDups, "collapse-duplicates", false,
+			"export: skip entries crossposted from another exported journal",
+		)
+		flags.BoolVar(
+			&commandOptions.anonymize, "anonymize", false,
+			"export: replace commenter names with stable pseudonyms, remove e-mail and IP addresses from comments and skip screened comments",
+		)
+		flags.BoolVar(
+			&commandOptions.perGroup, "per-group", false,
+			"export: write a separate bundle for each friend group with the entries its members can read",
+		)
+		flags.BoolVar(&commandOptions.publicOnly, "public-only", false, "export only public entries, same as -max-security public")
+		flags.StringVar(
+			&commandOptions.maxSecurity, "max-security", "private",
+			"export only entries with at most this security `level`: public, friends, custom or private",
+		)
+
+		flags.StringVar(&commandOptions.group, "group", "", "use only journals from the config journal `group`")
+		flags.DurationVar(
+			&commandOptions.interval, "watch-interval", defaultWatchInterval,
+			"watch: dump journal groups without an interval in the config each `duration`",
+		)
+		flags.StringVar(
+			&commandOptions.locale, "locale", "",
+			"format dates in exports and served pages per `locale`, one of "+dateLocaleNames(),
+		)
+		flags.StringVar(
+			&commandOptions.timeZone, "time-zone", "",
+			"interpret entry times in this IANA time `zone` like Europe/Moscow and record them with the offset",
+		)
+		flags.StringVar(
+			&commandOptions.selectQuery, "select", "",
+			"crosspost and restore: post only entries matching the collection `query` or the name of a config collection",
+		)
+		flags.BoolVar(&commandOptions.dryRun, "dry-run", false, "crosspost and restore: list the entries to post without posting, publish: render without adding to IPFS, migrate and transform: print the changes without making them")
+		flags.StringVar(&commandOptions.restoreTo, "to", "", "restore: post entries to this LJ-compatible `server`")
+		flags.StringVar(&commandOptions.restoreUser, "to-username", "", "restore: `username` on the target server, defaults to -username")
+		flags.StringVar(
+			&commandOptions.restorePass, "to-password-file", "",
+			"restore: `path` to file with the password on the target server, use '-' to read from stdin",
+		)
+		flags.BoolVar(
+			&commandOptions.restoreComm, "restore-comments", false,
+			"restore: also write comments to restored entries for the comment importer of the target server",
+		)
+		flags.StringVar(
+			&commandOptions.importUrl, "comment-import-url", "",
+			"restore: post the comments to this importer `URL` of the target server, implies -restore-comments",
+		)
+		flags.StringVar(&commandOptions.digestDate, "date", "", "onthisday: list entries posted on this `MM-DD` instead of today")
+		flags.StringVar(&commandOptions.digestFormat, "digest", "text", "onthisday: digest `format`, text, html or email")
+		flags.StringVar(
+			&commandOptions.heatmapDir, "heatmap", "",
+			"stats: also write SVG calendar heatmaps of entries and comments per year of each journal into `dir`",
+		)
+		flags.StringVar(&commandOptions.listen, "listen", "127.0.0.1:8080", "serve: listen on this `address`")
+		flags.StringVar(
+			&commandOptions.authFile, "auth-file", "",
+			"serve: require HTTP basic auth with user:password from the first line of the file at `path`",
+		)
+		flags.BoolVar(
+			&commandOptions.waitLock, "wait-lock", false,
+			"wait for another run using the dump directory to finish instead of failing",
+		)
+		flags.StringVar(
+			&commandOptions.postHook, "post-hook", "",
+			"run this shell `command` in the dump directory after a successful dump, overrides <hook> in the config",
+		)
+		flags.StringVar(
+			&commandOptions.posterProps, "poster-props", "",
+			"`mode` for poster IP addresses and similar comment properties, strip (default) or store",
+		)
+		flags.BoolVar(
+			&commandOptions.rollback, "rollback", false,
+			"migrate: undo the recorded migration of the journals",
+		)
+		flags.StringVar(
+			&commandOptions.packDir, "pack-dir", "",
+			"pack: write packs into this `directory`, overrides <packs><dir> in the config, defaults to packs",
+		)
+		flags.BoolVar(
+			&commandOptions.rotate, "rotate", false,
+			"pack: remove old packs keeping the daily, weekly and monthly packs from <packs> in the config",
+		)
+		flags.StringVar(
+			&commandOptions.pprof, "pprof", "",
+			"write CPU, memory and trace profiles of the run into this `directory` or serve them on this host:port",
+		)
+		flags.BoolVar(
+			&commandOptions.recover, "recover", false,
+			"move aside journal and account DB files that cannot be parsed and rebuild them from archived files",
+		)
 
-		if err := flags.Parse(os.Args[1:]); err != nil {
+		if err := flags.Parse(args); err != nil {
 			log("Try '%s --help' for more information", programName)
 			os.Exit(1)
 		} else if commandOptions.showUsage {
 			flags.SetOutput(os.Stdout)
-			fmt.Printf("Usage: %s [OPTION]...\n\nOption summary:\n", programName)
+			fmt.Printf("Usage: %s [COMMAND] [OPTION]...\n\nCommand summary:\n", programName)
+			for _, cmd := range commands {
+				fmt.Printf("  %-10s %s\n", cmd.name, cmd.summary)
+			}
+			fmt.Printf("\nOption summary:\n")
 			flags.PrintDefaults()
 			os.Exit(0)
 		}
-		if flags.NArg() != 0 {
-			return ReportMsg("Unexpected command line argument %s", flags.Arg(0))
+		rest := flags.Args()
+		if cmd.argName != "" && commandArg == "" {
+			if len(rest) == 0 {
+				return ReportMsg("%s command requires the %s argument", cmd.name, cmd.argName)
+			}
+			commandArg = rest[0]
+			rest = rest[1:]
+		}
+		if len(rest) != 0 {
+			return ReportMsg("Unexpected command line argument %s", rest[0])
 		}
 		return nil
 	}
@@ -226,6 +899,82 @@ func loadConfig() (*Config, *Report) {
 		Journals     []string `xml:"journal"`
 		Password     string   `xml:"password"`
 		PasswordFile string   `xml:"passwordFile"`
+		ApiKey       string   `xml:"apiKey"`
+		ApiKeyFile   string   `xml:"apiKeyFile"`
+		OAuth        *struct {
+			AccessToken  string `xml:"accessToken"`
+			RefreshToken string `xml:"refreshToken"`
+			TokenUrl     string `xml:"tokenUrl"`
+			ClientId     string `xml:"clientId"`
+			ClientSecret string `xml:"clientSecret"`
+		} `xml:"oauth"`
+
+		AllCommunities bool   `xml:"allCommunities"`
+		Locale         string `xml:"locale"`
+		TimeZone       string `xml:"timeZone"`
+		DownloadMedia  bool   `xml:"downloadMedia"`
+		ArchiveStyle   bool   `xml:"archiveStyle"`
+		CommunityInfo  bool   `xml:"archiveCommunityInfo"`
+		ModQueue       bool   `xml:"archiveModerationQueue"`
+		ArchiveInbox   bool   `xml:"archiveInbox"`
+		Snapshots      bool   `xml:"archiveSnapshots"`
+		FileMode       string `xml:"fileMode"`
+		DirMode        string `xml:"dirMode"`
+		Layout         string `xml:"layout"`
+		DumpSecurity   string `xml:"dumpSecurity"`
+		Transforms     string `xml:"transforms"`
+		Api            string `xml:"api"`
+		ApiUrl         string `xml:"apiUrl"`
+		Hook           string `xml:"hook"`
+		CaCert         string `xml:"caCert"`
+		TlsMinVersion  string `xml:"tlsMinVersion"`
+		SkipVerify     bool   `xml:"insecureSkipVerify"`
+		PosterProps    string `xml:"posterProps"`
+
+		Groups []struct {
+			Name     string   `xml:"name,attr"`
+			Interval string   `xml:"interval,attr"`
+			Journals []string `xml:"journal"`
+		} `xml:"group"`
+
+		Collections []struct {
+			Name  string `xml:"name,attr"`
+			Query string `xml:",chardata"`
+		} `xml:"collection"`
+
+		Tumblr *struct {
+			Blog           string `xml:"blog"`
+			ConsumerKey    string `xml:"consumerKey"`
+			ConsumerSecret string `xml:"consumerSecret"`
+			Token          string `xml:"token"`
+			TokenSecret    string `xml:"tokenSecret"`
+		} `xml:"tumblr"`
+
+		Bluesky *struct {
+			Service     string `xml:"service"`
+			Handle      string `xml:"handle"`
+			AppPassword string `xml:"appPassword"`
+			Thread      bool   `xml:"thread"`
+		} `xml:"bluesky"`
+
+		Ipfs *struct {
+			ApiUrl  string `xml:"apiUrl"`
+			IpnsKey string `xml:"ipnsKey"`
+		} `xml:"ipfs"`
+
+		Webdav *struct {
+			Url          string `xml:"url"`
+			Username     string `xml:"username"`
+			Password     string `xml:"password"`
+			PasswordFile string `xml:"passwordFile"`
+		} `xml:"webdav"`
+
+		Packs *struct {
+			Dir     string `xml:"dir"`
+			Daily   *int   `xml:"daily"`
+			Weekly  *int   `xml:"weekly"`
+			Monthly *int   `xml:"monthly"`
+		} `xml:"packs"`
 	}
 	if len(configBytes) != 0 {
 		if err = xml.Unmarshal(configBytes, &storedConfig); err != nil {
@@ -237,9 +986,18 @@ func loadConfig() (*Config, *Report) {
 				configFile,
 			)
 		}
+		if storedConfig.ApiKey != "" && storedConfig.ApiKeyFile != "" {
+			return nil, ReportMsg(
+				"Only one of <apiKey>, <apiKeyFile> can be specified in %s",
+				configFile,
+			)
+		}
 	}
 
 	var config = new(Config)
+	config.errorLog = &errorLog{}
+	config.command = cmd
+	config.commandArg = commandArg
 
 	config.server = commandOptions.server
 	if config.server == "" {
@@ -253,26 +1011,151 @@ func loadConfig() (*Config, *Report) {
 		config.server = defaultLJServer
 	}
 
+	config.api = commandOptions.api
+	if config.api == "" {
+		config.api = storedConfig.Api
+	}
+	if config.api == "" {
+		config.api = xmlrpcAPI
+	} else if config.api != xmlrpcAPI && config.api != jsonrpcAPI {
+		return nil, ReportMsg("unknown API %s, supported APIs are %s and %s", config.api, xmlrpcAPI, jsonrpcAPI)
+	}
+	config.apiUrl = commandOptions.apiUrl
+	if config.apiUrl == "" {
+		config.apiUrl = storedConfig.ApiUrl
+	}
+	if config.apiUrl == "" {
+		config.apiUrl = defaultJsonRpcUrl
+	}
+
 	config.username = commandOptions.username
 	if config.username == "" {
 		config.username = storedConfig.Username
 	}
-	if config.username == "" {
+	if config.username == "" && (cmd.name != "dump-public" || len(commandOptions.journals) == 0) {
 		return nil, ReportMsg("username must be specified either on command line or in %s", configFile)
 	}
 
+	if commandOptions.interval <= 0 {
+		return nil, ReportMsg("watch-interval must be positive")
+	}
 	if len(commandOptions.journals) != 0 {
-		config.journals = commandOptions.journals
+		if commandOptions.group != "" {
+			return nil, ReportMsg("-journal and -group cannot be used together")
+		}
+		config.journalGroups = []*journalGroup{
+			{defaultJournalGroup, commandOptions.journals, commandOptions.interval},
+		}
+		config.allJournals = commandOptions.journals
 	} else {
-		config.journals = storedConfig.Journals
+		if len(storedConfig.Journals) != 0 || len(storedConfig.Groups) == 0 {
+			config.journalGroups = []*journalGroup{
+				{defaultJournalGroup, storedConfig.Journals, commandOptions.interval},
+			}
+		}
+		groupedJournals := make(map[string]string)
+		for _, journal := range storedConfig.Journals {
+			groupedJournals[journal] = defaultJournalGroup
+		}
+		for i, stored := range storedConfig.Groups {
+			if stored.Name == "" {
+				return nil, ReportMsg("group %d in %s has no name attribute", i+1, configFile)
+			}
+			if config.findJournalGroup(stored.Name) != nil {
+				return nil, ReportMsg("duplicated or reserved group name %s in %s", stored.Name, configFile)
+			}
+			g := &journalGroup{stored.Name, stored.Journals, commandOptions.interval}
+			if stored.Interval != "" {
+				if g.interval, err = time.ParseDuration(stored.Interval); err != nil || g.interval <= 0 {
+					return nil, ReportMsg("invalid interval %s of group %s in %s", stored.Interval, g.name, configFile)
+				}
+			}
+			for _, journal := range g.journals {
+				if other := groupedJournals[journal]; other != "" {
+					return nil, ReportMsg("journal %s is listed in both %s and %s groups in %s", journal, other, g.name, configFile)
+				}
+				groupedJournals[journal] = g.name
+			}
+			config.journalGroups = append(config.journalGroups, g)
+		}
+		for _, g := range config.journalGroups {
+			config.allJournals = append(config.allJournals, g.journals...)
+		}
+		if commandOptions.group != "" {
+			g := config.findJournalGroup(commandOptions.group)
+			if g == nil {
+				return nil, ReportMsg("unknown journal group %s", commandOptions.group)
+			}
+			config.journalGroups = []*journalGroup{g}
+		}
 	}
-	if len(config.journals) == 0 {
-		config.journals = []string{config.username}
-	} else {
-		for i, journal := range config.journals {
+	if len(config.journalGroups) == 1 && config.journalGroups[0].name == defaultJournalGroup &&
+		len(config.journalGroups[0].journals) == 0 {
+		config.journalGroups[0].journals = []string{config.username}
+	}
+	for _, g := range config.journalGroups {
+		for i, journal := range g.journals {
 			if journal == "" {
-				return nil, ReportMsg("journal %d is empty string", i+1)
+				return nil, ReportMsg("journal %d of group %s is empty string", i+1, g.name)
 			}
+			if err := checkJournalName(journal); err != nil {
+				return nil, ReportMsg("%s in group %s", err.Error(), g.name)
+			}
+		}
+		config.journals = append(config.journals, g.journals...)
+	}
+
+	// Files with credentials, used or not in this run, for fix-perms
+	config.secretFiles = []string{configFile, commandOptions.passwordFile, os.Getenv("LJDUMP_PASSWORD_FILE"), commandOptions.cookieFile}
+	for _, path := range []string{storedConfig.PasswordFile, storedConfig.ApiKeyFile} {
+		if path != "" && !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(configFile), path)
+		}
+		config.secretFiles = append(config.secretFiles, path)
+	}
+
+	if commandOptions.cookieFile != "" && cmd.needsLogin {
+		if commandOptions.cookieFile == "-" {
+			fmt.Print("Paste the ljsession cookie (it will be echoed): ")
+		}
+		cookieBytes, err := readFileFirstLine(commandOptions.cookieFile)
+		if err != nil {
+			return nil, WrapErr(err, "failed to read the session cookie from %s", commandOptions.cookieFile)
+		}
+		if len(cookieBytes) == 0 {
+			return nil, ReportMsg("first line with the session cookie in %s was empty", commandOptions.cookieFile)
+		}
+		config.sessionCookie = string(cookieBytes)
+	}
+
+	// An API key or OAuth tokens in the config replace the password
+	// unless the password file is given on the command line
+	if stored := storedConfig.OAuth; stored != nil && commandOptions.passwordFile == "" {
+		if stored.AccessToken == "" && (stored.RefreshToken == "" || stored.TokenUrl == "") {
+			return nil, ReportMsg("<oauth> in %s must contain <accessToken> or <refreshToken> with <tokenUrl>", configFile)
+		}
+		config.oauth = &oauthConfig{
+			accessToken:  stored.AccessToken,
+			refreshToken: stored.RefreshToken,
+			tokenUrl:     stored.TokenUrl,
+			clientId:     stored.ClientId,
+			clientSecret: stored.ClientSecret,
+		}
+	} else if commandOptions.passwordFile == "" {
+		config.apiKey = storedConfig.ApiKey
+		if config.apiKey == "" && storedConfig.ApiKeyFile != "" && cmd.needsLogin {
+			apiKeyFile := storedConfig.ApiKeyFile
+			if !filepath.IsAbs(apiKeyFile) {
+				apiKeyFile = filepath.Join(filepath.Dir(configFile), apiKeyFile)
+			}
+			apiKeyBytes, err := readFileFirstLine(apiKeyFile)
+			if err != nil {
+				return nil, WrapErr(err, "failed to read API key from %s", apiKeyFile)
+			}
+			if len(apiKeyBytes) == 0 {
+				return nil, ReportMsg("first line with API key in %s was empty", apiKeyFile)
+			}
+			config.apiKey = string(apiKeyBytes)
 		}
 	}
 
@@ -282,7 +1165,7 @@ func loadConfig() (*Config, *Report) {
 	if passwordFile == "" {
 		config.password = storedConfig.Password
 	}
-	if config.password == "" {
+	if config.password == "" && cmd.needsLogin && config.apiKey == "" && config.oauth == nil && config.sessionCookie == "" {
 		if passwordFile == "" {
 			passwordFile = os.Getenv("LJDUMP_PASSWORD_FILE")
 			if passwordFile == "" {
@@ -311,9 +1194,327 @@ func loadConfig() (*Config, *Report) {
 		config.password = string(passwordBytes)
 	}
 
+	if commandOptions.timeSlice < 0 {
+		return nil, ReportMsg("journal-time-slice cannot be negative")
+	}
+	config.journalTimeSlice = commandOptions.timeSlice
+
+	if commandOptions.reqTimeout < 0 || commandOptions.maxRuntime < 0 {
+		return nil, ReportMsg("request-timeout and max-runtime cannot be negative")
+	}
+	config.requestTimeout = commandOptions.reqTimeout
+	config.maxRuntime = commandOptions.maxRuntime
+	if config.maxRuntime != 0 {
+		config.runDeadline = time.Now().Add(config.maxRuntime)
+	}
+	if commandOptions.maxRequests < 0 || commandOptions.maxEntries < 0 {
+		return nil, ReportMsg("max-requests and max-new-entries cannot be negative")
+	}
+	if commandOptions.maxRequests != 0 || commandOptions.maxEntries != 0 {
+		config.budget = &runBudget{maxRequests: commandOptions.maxRequests, maxNewEntries: commandOptions.maxEntries}
+	}
+
 	config.dumpDir = "."
 	config.accountDataDir = filepath.Join(config.dumpDir, accountDataDirName)
 
+	config.renameJournalDirs = commandOptions.renameDirs
+	config.waitLock = commandOptions.waitLock
+	config.downloadMedia = commandOptions.media || storedConfig.DownloadMedia
+	config.refreshMedia = commandOptions.refreshMedia
+	config.recheckComments = commandOptions.recheck
+	config.bootstrap = commandOptions.bootstrap
+	config.archiveStyle = commandOptions.style || storedConfig.ArchiveStyle
+	config.archiveCommunityInfo = commandOptions.commInfo || storedConfig.CommunityInfo
+	config.archiveModerationQueue = commandOptions.modQueue || storedConfig.ModQueue
+	config.archiveInbox = commandOptions.inbox || storedConfig.ArchiveInbox
+	config.archiveSnapshots = commandOptions.snapshots || storedConfig.Snapshots
+	if config.fileMode, err = parseFileMode(storedConfig.FileMode, defaultArchiveFileMode); err != nil {
+		return nil, WrapErr(err, "bad <fileMode> in %s", configFile)
+	}
+	if config.dirMode, err = parseFileMode(storedConfig.DirMode, defaultArchiveDirMode); err != nil {
+		return nil, WrapErr(err, "bad <dirMode> in %s", configFile)
+	}
+	caCert := commandOptions.caCert
+	if caCert == "" {
+		caCert = strings.TrimSpace(storedConfig.CaCert)
+	}
+	tlsMin := commandOptions.tlsMin
+	if tlsMin == "" {
+		tlsMin = strings.TrimSpace(storedConfig.TlsMinVersion)
+	}
+	var r *Report
+	if config.tlsConfig, r = loadTLSConfig(caCert, tlsMin, commandOptions.skipVerify || storedConfig.SkipVerify); r != nil {
+		return nil, r
+	}
+	var ok bool
+	if config.maxResponseSize, ok = parseByteSize(commandOptions.maxResponse); !ok {
+		return nil, ReportMsg("bad -max-response-size value %s, expected bytes with optional k, M or G suffix", commandOptions.maxResponse)
+	}
+	if commandOptions.bwlimit != "" {
+		rate, err := parseByteRate(commandOptions.bwlimit)
+		if err != nil {
+			return nil, WrapErr(err, "bad -bwlimit value")
+		}
+		if rate != 0 {
+			config.mediaBandwidth = newTokenBucket(rate)
+		}
+	}
+	config.allCommunities = commandOptions.allComms || storedConfig.AllCommunities
+	config.journalLayout = commandOptions.layout
+	if config.journalLayout == "" {
+		config.journalLayout = storedConfig.Layout
+	}
+	if config.journalLayout != "" && !isJournalLayout(config.journalLayout) {
+		return nil, ReportMsg("unknown layout %s, supported layouts are %s", config.journalLayout, strings.Join(journalLayouts, ", "))
+	}
+	dumpSecurity := commandOptions.dumpSecurity
+	if dumpSecurity == "" {
+		dumpSecurity = storedConfig.DumpSecurity
+	}
+	if config.dumpSecurity, err = parseDumpSecurity(dumpSecurity); err != nil {
+		return nil, WrapErr(err, "bad -security value")
+	}
+	transformsPath := commandOptions.transforms
+	if transformsPath == "" {
+		transformsPath = storedConfig.Transforms
+	}
+	if transformsPath != "" {
+		if config.transforms, r = readTransformRules(transformsPath); r != nil {
+			return nil, r
+		}
+	}
+	if cmd.name == "migrate-layout" && config.journalLayout == "" {
+		return nil, ReportMsg("migrate-layout requires the -layout option")
+	}
+
+	// Maintained communities that are not listed in the config belong
+	// to the default group
+	if config.findJournalGroup(defaultJournalGroup) == nil {
+		config.allCommunities = false
+	}
+
+	config.recoverCorruptDBs = commandOptions.recover
+	config.migrateRollback = commandOptions.rollback
+	config.pprof = commandOptions.pprof
+	config.packDir = commandOptions.packDir
+	config.packRotate = commandOptions.rotate
+	config.packRetention = defaultPackRetention
+	if stored := storedConfig.Packs; stored != nil {
+		if config.packDir == "" {
+			config.packDir = stored.Dir
+		}
+		for _, v := range []struct {
+			stored *int
+			count  *int
+		}{
+			{stored.Daily, &config.packRetention.daily},
+			{stored.Weekly, &config.packRetention.weekly},
+			{stored.Monthly, &config.packRetention.monthly},
+		} {
+			if v.stored == nil {
+				continue
+			}
+			if *v.stored < 0 {
+				return nil, ReportMsg("the number of packs to keep in <packs> in %s must not be negative", configFile)
+			}
+			*v.count = *v.stored
+		}
+	}
+	config.postHook = commandOptions.postHook
+	if config.postHook == "" {
+		config.postHook = strings.TrimSpace(storedConfig.Hook)
+	}
+
+	posterProps := commandOptions.posterProps
+	if posterProps == "" {
+		posterProps = strings.TrimSpace(storedConfig.PosterProps)
+	}
+	switch posterProps {
+	case "", "strip":
+	case "store":
+		config.storePosterProps = true
+	default:
+		return nil, ReportMsg("unknown poster props mode %s, use strip or store", posterProps)
+	}
+
+	config.serveListen = commandOptions.listen
+	config.serveAuthFile = commandOptions.authFile
+
+	config.exportFormat = commandOptions.format
+	if commandOptions.jsonl {
+		config.exportFormat = "comments-jsonl"
+	}
+	if commandOptions.latex {
+		config.exportFormat = "latex"
+	}
+	if commandOptions.graph {
+		config.exportFormat = "graph"
+	}
+	config.exportDir = commandOptions.outputDir
+	config.fullExport = commandOptions.fullExport
+	config.feedUrl = commandOptions.feedUrl
+	config.collapseDuplicates = commandOptions.collapseDups
+	config.anonymizeComments = commandOptions.anonymize
+	config.exportPerGroup = commandOptions.perGroup
+	if config.maxSecurity, err = parseSecurityLevel(commandOptions.maxSecurity); err != nil {
+		return nil, WrapErr(err, "invalid -max-security option")
+	}
+	if locale := commandOptions.locale; locale != "" || storedConfig.Locale != "" {
+		if locale == "" {
+			locale = storedConfig.Locale
+		}
+		if config.dateLocale = findDateLocale(locale); config.dateLocale == nil {
+			return nil, ReportMsg("unknown locale %s, supported locales are %s", locale, dateLocaleNames())
+		}
+	}
+	if zone := commandOptions.timeZone; zone != "" || storedConfig.TimeZone != "" {
+		if zone == "" {
+			zone = storedConfig.TimeZone
+		}
+		if config.timeZone, err = time.LoadLocation(zone); err != nil {
+			return nil, WrapErr(err, "invalid time zone %s", zone)
+		}
+	}
+	if commandOptions.publicOnly {
+		config.maxSecurity = securityPublic
+	}
+
+	collectionNames := make(map[string]bool)
+	for i, stored := range storedConfig.Collections {
+		if stored.Name == "" {
+			return nil, ReportMsg("collection %d in %s has no name attribute", i+1, configFile)
+		}
+		if collectionNames[stored.Name] {
+			return nil, ReportMsg("duplicated collection name %s in %s", stored.Name, configFile)
+		}
+		collectionNames[stored.Name] = true
+		search, err := parseSavedSearch(stored.Name, stored.Query)
+		if err != nil {
+			return nil, WrapErr(err, "invalid query for collection %s in %s", stored.Name, configFile)
+		}
+		config.collections = append(config.collections, search)
+	}
+	if query := commandOptions.selectQuery; query != "" {
+		for _, search := range config.collections {
+			if search.name == query {
+				config.selectEntries = search
+			}
+		}
+		if config.selectEntries == nil {
+			if config.selectEntries, err = parseSavedSearch("select", query); err != nil {
+				return nil, WrapErr(err, "invalid -select query")
+			}
+		}
+	}
+	config.dryRun = commandOptions.dryRun
+	config.digestDate = commandOptions.digestDate
+	config.digestFormat = commandOptions.digestFormat
+	config.heatmapDir = commandOptions.heatmapDir
+	if cmd.name == "restore" {
+		if commandOptions.restoreTo == "" || commandOptions.restorePass == "" {
+			return nil, ReportMsg("restore requires -to and -to-password-file options")
+		}
+		config.restoreServer = strings.TrimSuffix(strings.TrimSuffix(commandOptions.restoreTo, "/"), serverUrlCompabilitySuffix)
+		config.restoreUsername = commandOptions.restoreUser
+		if config.restoreUsername == "" {
+			config.restoreUsername = config.username
+		}
+		if commandOptions.restorePass == "-" {
+			fmt.Print("Enter the target server password (it will be echoed): ")
+		}
+		passwordBytes, err := readFileFirstLine(commandOptions.restorePass)
+		if err != nil {
+			return nil, WrapErr(err, "failed to read password from %s", commandOptions.restorePass)
+		}
+		if len(passwordBytes) == 0 {
+			return nil, ReportMsg("first line with password in %s was empty", commandOptions.restorePass)
+		}
+		config.restorePassword = string(passwordBytes)
+		config.commentImportUrl = commandOptions.importUrl
+		config.restoreComments = commandOptions.restoreComm || config.commentImportUrl != ""
+	}
+	if stored := storedConfig.Tumblr; stored != nil {
+		if stored.Blog == "" || stored.ConsumerKey == "" || stored.ConsumerSecret == "" || stored.Token == "" || stored.TokenSecret == "" {
+			return nil, ReportMsg(
+				"<tumblr> in %s must contain <blog>, <consumerKey>, <consumerSecret>, <token> and <tokenSecret>",
+				configFile,
+			)
+		}
+		config.tumblr = &tumblrConfig{
+			apiUrl:         defaultTumblrApiUrl,
+			blog:           stored.Blog,
+			consumerKey:    stored.ConsumerKey,
+			consumerSecret: stored.ConsumerSecret,
+			token:          stored.Token,
+			tokenSecret:    stored.TokenSecret,
+		}
+	}
+	if stored := storedConfig.Bluesky; stored != nil {
+		if stored.Handle == "" || stored.AppPassword == "" {
+			return nil, ReportMsg("<bluesky> in %s must contain <handle> and <appPassword>", configFile)
+		}
+		config.bluesky = &blueskyConfig{
+			service:     stored.Service,
+			handle:      stored.Handle,
+			appPassword: stored.AppPassword,
+			thread:      stored.Thread,
+		}
+		if config.bluesky.service == "" {
+			config.bluesky.service = defaultBlueskyService
+		}
+	}
+	if stored := storedConfig.Ipfs; stored != nil {
+		config.ipfs = &ipfsConfig{apiUrl: stored.ApiUrl, ipnsKey: stored.IpnsKey}
+		if config.ipfs.apiUrl == "" {
+			config.ipfs.apiUrl = defaultIpfsApiUrl
+		}
+	}
+	if stored := storedConfig.Webdav; stored != nil {
+		if stored.Url == "" {
+			return nil, ReportMsg("<webdav> in %s must contain <url>", configFile)
+		}
+		if stored.Password != "" && stored.PasswordFile != "" {
+			return nil, ReportMsg("Only one of <password>, <passwordFile> can be specified in <webdav> in %s", configFile)
+		}
+		config.webdav = &webdavConfig{
+			url:      stored.Url,
+			username: stored.Username,
+			password: stored.Password,
+		}
+		if stored.PasswordFile != "" {
+			passwordFile := stored.PasswordFile
+			if !filepath.IsAbs(passwordFile) {
+				passwordFile = filepath.Join(filepath.Dir(configFile), passwordFile)
+			}
+			passwordBytes, err := readFileFirstLine(passwordFile)
+			if err != nil {
+				return nil, WrapErr(err, "failed to read WebDAV password from %s", passwordFile)
+			}
+			if len(passwordBytes) == 0 {
+				return nil, ReportMsg("first line with WebDAV password in %s was empty", passwordFile)
+			}
+			config.webdav.password = string(passwordBytes)
+		}
+	}
+
+	// Set the store before the journal aliases are read from the archive
+	archiveStore = localStore{}
+	if config.webdav != nil {
+		s, r := newWebdavStore(config)
+		if r != nil {
+			return nil, r
+		}
+		archiveStore = s
+	}
+	aliases, r := readJournalAliases(config.dumpDir)
+	if r != nil {
+		return nil, r
+	}
+	config.journalAliases = aliases
+	if r := checkJournalDirCollisions(config); r != nil {
+		return nil, r
+	}
+
 	return config, nil
 }
 
@@ -348,26 +1549,68 @@ type journalContext struct {
 	name           string
 	dir            string
 	db             journalDB
+	dbLoaded       bool
 	shouldWriteDB  bool
 	origDbLastSync string
 	newEntries     int
 	newComments    int
+
+	// Entries fetched in this run and the time spent on the journal for
+	// the run report
+	newEntryIds []int64
+	fetchTime   time.Duration
+
+	// Listing of archived entries, see entries_index.go
+	index *entriesIndex
+
+	// Set when commentItems must be written and when the removal of the
+	// stale file is staged, see comment_items.go
+	commentItemsChanged bool
+	commentItemsRemoved bool
+
+	// Files of the current dump step, see transaction.go
+	pending pendingWrites
+
+	// What the account may archive of the journal, see capabilities.go
+	caps journalCapabilities
+
+	// Convert the files of ljdump.py into the DB and move them into
+	// legacy/ when reading the DB. Only dump and migrate do that.
+	convertPythonFiles bool
+
+	// Time slice support. The zero sliceDeadline means no limit.
+	sliceDeadline time.Time
+	postsDone     bool
+	suspended     bool
 }
 
 const journalDBFileName = "journal.linedb"
 
+// Check if the current time slice of the journal is over. In that case
+// mark the journal as suspended so the caller stops after the current
+// item with all progress recorded.
+func (jcx *journalContext) sliceExpired() bool {
+	if shutdownRequested() || jcx.config.runtimeExceeded() {
+		jcx.suspended = true
+	} else if !jcx.suspended && !jcx.sliceDeadline.IsZero() && time.Now().After(jcx.sliceDeadline) {
+		jcx.suspended = true
+	}
+	return jcx.suspended
+}
+
 func newJournalContext(session *ljSession, journalName string) *journalContext {
-	dir := filepath.Join(session.config.dumpDir, journalName)
+	dir := session.config.journalDir(journalName)
 	jcx := &journalContext{
 		config:  session.config,
 		session: session,
 		name:    journalName,
 		dir:     dir,
+		caps:    ownJournalCapabilities,
 	}
 	return jcx
 }
 
-type CommentId int64
+type CommentId = ljarchive.CommentId
 type UserId int64
 
 type commentMeta struct {
@@ -380,12 +1623,86 @@ type accountData struct {
 	pictureDefaultUrl    string
 	pictureUrlFileMap    map[string]string
 	pictureKeywordUrlMap map[string]string
+
+	// All keyword to URL assignments seen so far including superseded
+	// ones, see userpics.go
+	pictureHistory []*pictureAssignment
+
+	// Details from the allpics page by picture URL, see allpics.go
+	pictureInfo map[string]*pictureInfo
+
+	// Validators of downloaded pictures by URL for -refresh-media
+	pictureValidators map[string]httpValidators
+
+	// Set when the file was upgraded from an older schema version and
+	// should be written back
+	upgraded bool
 }
 
 type journalDB struct {
-	lastSync   string
-	userMap    map[UserId]string
-	commentMap map[CommentId]commentMeta
+	// Schema version of the file the data was read from, see schema.go
+	schemaVersion int
+
+	lastSync string
+
+	// Layout of entry and comment files, see layout.go
+	layout string
+
+	// The userid of the journal on the server or 0 if unknown. It does
+	// not change when the journal is renamed.
+	journalUserId UserId
+	userMap       map[UserId]string
+	commentMap    map[CommentId]commentMeta
+
+	// Security levels of entries that -security kept out of the archive,
+	// see security_filter.go
+	securitySkipped map[int64]string
+
+	// The jitemid of the comment file with the body of each archived
+	// comment, see comment_items.go
+	commentItems map[CommentId]int64
+
+	// Set by the schema migration when commentItems must be rebuilt from
+	// the comment files
+	rebuildCommentItems bool
+
+	// Set when the DB has the commentItems table of older versions
+	legacyCommentItems bool
+
+	// The edit_time property of archived comments that were edited
+	commentEditTimes map[CommentId]string
+
+	// Changes of the state of archived comments, the oldest first, see
+	// comment_states.go
+	commentStates []commentStateChange
+
+	// Entries with fewer archived comments than their reply_count that
+	// verify queued for the next dump, see verify.go
+	commentRefetch []int64
+
+	// The actions that syncitems reported in the order of sync. The
+	// server reports only the latest action of an item since the previous
+	// sync, so an entry created and edited between two dumps has only the
+	// update.
+	syncActions []syncAction
+
+	// Entries archived from the monthly export with the server time of the
+	// export and the exported months, see bootstrap.go
+	bootstrapItems  map[int64]string
+	bootstrapMonths map[string]bool
+
+	// Exported entries that syncitems found unchanged and that still need
+	// a getevents call for their tags and other properties
+	bootstrapRefetch []int64
+}
+
+type syncAction struct {
+	// Item in the TypeLetter-Number format like L-12
+	item string
+
+	// create, update or del
+	action string
+	time   string
 }
 
 type sortIds []int64
@@ -422,6 +1739,7 @@ func addSortedMapKeyValue(e *linedb.Encoder, tableName string, m map[string]stri
 
 func writeAccountData(accountData *accountData, config *Config) *Report {
 	e := linedb.NewByteEncoder()
+	e.Scalar("schemaVersion").AddInt(accountDataSchemaVersion)
 	e.Scalar("fileCounter").AddInt(accountData.fileCounter)
 	e.Scalar("pictureDefaultUrl").AddString(accountData.pictureDefaultUrl)
 	e.EmptyLine()
@@ -430,6 +1748,12 @@ func writeAccountData(accountData *accountData, config *Config) *Report {
 	e.EmptyLine()
 	e.Comment("map from picture-keyword to picture-url")
 	addSortedMapKeyValue(e, "pictureKeywordUrlMap", accountData.pictureKeywordUrlMap)
+	e.EmptyLine()
+	addPictureHistoryTable(e, accountData.pictureHistory)
+	e.EmptyLine()
+	addPictureInfoTable(e, accountData.pictureInfo)
+	e.EmptyLine()
+	addPictureValidatorsTable(e, accountData.pictureValidators)
 
 	dbpath := filepath.Join(config.accountDataDir, accountDataDBFileName)
 	if err := writeFileTempRename(dbpath, e.GetBytes()); err != nil {
@@ -438,15 +1762,26 @@ func writeAccountData(accountData *accountData, config *Config) *Report {
 	return nil
 }
 
+// The linedb decoder checks the name of the previous item when it reads a
+// table, so it fails on files that start with a table unless the name is
+// valid from the start
+func newLinedbDecoder(data []byte) *linedb.Decoder {
+	d := linedb.NewByteDecoder(data)
+	d.ItemName = "_"
+	return d
+}
+
 func readAccountData(config *Config) (*accountData, *Report) {
 	accountData := &accountData{}
 
 	// Initialize maps so entries can be added
 	accountData.pictureUrlFileMap = make(map[string]string)
 	accountData.pictureKeywordUrlMap = make(map[string]string)
+	accountData.pictureInfo = make(map[string]*pictureInfo)
+	accountData.pictureValidators = make(map[string]httpValidators)
 
 	dbpath := filepath.Join(config.accountDataDir, accountDataDBFileName)
-	dbdata, err := ioutil.ReadFile(dbpath)
+	dbdata, err := archiveStore.ReadFile(dbpath)
 	if err != nil {
 		if !os.IsNotExist(err) {
 			return nil, WrapErr(err, "")
@@ -454,11 +1789,17 @@ func readAccountData(config *Config) (*accountData, *Report) {
 		return accountData, nil
 	}
 
-	d := linedb.NewByteDecoder(dbdata)
+	schemaVersion := 0
+	d := newLinedbDecoder(dbdata)
 	for d.NextItem() {
 		switch d.ItemKind {
 		case linedb.ScalarItem:
 			switch d.ItemName {
+			case "schemaVersion":
+				schemaVersion = d.GetInt()
+				if err := checkSchemaVersion(schemaVersion, accountDataSchemaVersion); err != nil {
+					return nil, WrapErr(err, "cannot read account data file %s", dbpath)
+				}
 			case "fileCounter":
 				accountData.fileCounter = d.GetInt()
 			case "pictureDefaultUrl":
@@ -471,19 +1812,40 @@ func readAccountData(config *Config) (*accountData, *Report) {
 					accountData.pictureUrlFileMap[d.GetString()] = d.GetString()
 				case "pictureKeywordUrlMap":
 					accountData.pictureKeywordUrlMap[d.GetString()] = d.GetString()
+				case "pictureHistory":
+					accountData.pictureHistory = append(accountData.pictureHistory, &pictureAssignment{
+						d.GetString(), d.GetString(), d.GetString(), d.GetString(),
+					})
+				case "pictureInfo":
+					info := &pictureInfo{d.GetString(), d.GetString(), d.GetString(), d.GetInt()}
+					accountData.pictureInfo[info.url] = info
+				case "pictureValidators":
+					accountData.pictureValidators[d.GetString()] = httpValidators{d.GetString(), d.GetString()}
 				}
 			}
 		}
 	}
 	if err := d.GetError(); err != nil {
-		return nil, WrapErr(err, "error while parsing account data file %s as linedb", dbpath)
+		if !config.recoverCorruptDBs {
+			return nil, WrapErr(err, "error while parsing account data file %s as linedb, use -recover to rebuild it", dbpath)
+		}
+		return recoverAccountData(config, accountData, schemaVersion, dbpath, err)
+	}
+	if schemaVersion < accountDataSchemaVersion {
+		if err := migrateAccountData(accountData, schemaVersion); err != nil {
+			return nil, WrapErr(err, "cannot read account data file %s", dbpath)
+		}
+		accountData.upgraded = true
 	}
 	return accountData, nil
 }
 
-func writeJournalDB(jcx *journalContext) *Report {
+func encodeJournalDB(jcx *journalContext) []byte {
 	e := linedb.NewByteEncoder()
+	e.Scalar("schemaVersion").AddInt(journalDBSchemaVersion)
 	e.Scalar("lastSync").AddString(jcx.db.lastSync)
+	e.Scalar("journalUserId").AddInt64(int64(jcx.db.journalUserId))
+	e.Scalar("layout").AddString(jcx.db.layout)
 
 	e.EmptyLine()
 	e.Comment("map from user-id to user-name")
@@ -512,170 +1874,334 @@ func writeJournalDB(jcx *journalContext) *Report {
 	}
 	e.EndTable()
 
+	e.EmptyLine()
+	e.Comment("map from comment-id to edit-time")
+	editedIds := make(sortIds, 0, len(jcx.db.commentEditTimes))
+	for commentId := range jcx.db.commentEditTimes {
+		editedIds = append(editedIds, int64(commentId))
+	}
+	sort.Sort(editedIds)
+	e.Table("commentEdits")
+	for _, commentId := range editedIds {
+		e.AddInt64(commentId).AddString(jcx.db.commentEditTimes[CommentId(commentId)]).EndRow()
+	}
+	e.EndTable()
+
+	e.EmptyLine()
+	e.Comment("log of comment state changes as (comment-id from to time)")
+	e.Table("commentStates")
+	for _, c := range jcx.db.commentStates {
+		e.AddInt64(int64(c.id)).AddString(c.from).AddString(c.to).AddString(c.time).EndRow()
+	}
+	e.EndTable()
+
+	e.EmptyLine()
+	e.Comment("entries with missing comments as (jitemid)")
+	e.Table("commentRefetch")
+	for _, itemId := range jcx.db.commentRefetch {
+		e.AddInt64(itemId).EndRow()
+	}
+	e.EndTable()
+
+	e.EmptyLine()
+	e.Comment("entries not archived because of -security as (jitemid security)")
+	skippedIds := make(sortIds, 0, len(jcx.db.securitySkipped))
+	for itemId := range jcx.db.securitySkipped {
+		skippedIds = append(skippedIds, itemId)
+	}
+	sort.Sort(skippedIds)
+	e.Table("securitySkipped")
+	for _, itemId := range skippedIds {
+		e.AddInt64(itemId).AddString(jcx.db.securitySkipped[itemId]).EndRow()
+	}
+	e.EndTable()
+
+	e.EmptyLine()
+	e.Comment("log of syncitems as (item action time)")
+	e.Table("syncActions")
+	for _, a := range jcx.db.syncActions {
+		e.AddString(a.item).AddString(a.action).AddString(a.time).EndRow()
+	}
+	e.EndTable()
+
+	if len(jcx.db.bootstrapItems) != 0 || len(jcx.db.bootstrapMonths) != 0 {
+		e.EmptyLine()
+		e.Comment("entries from the monthly export not yet synced as (jitemid export-time)")
+		bootstrapIds := make(sortIds, 0, len(jcx.db.bootstrapItems))
+		for itemId := range jcx.db.bootstrapItems {
+			bootstrapIds = append(bootstrapIds, itemId)
+		}
+		sort.Sort(bootstrapIds)
+		e.Table("bootstrapItems")
+		for _, itemId := range bootstrapIds {
+			e.AddInt64(itemId).AddString(jcx.db.bootstrapItems[itemId]).EndRow()
+		}
+		e.EndTable()
+
+		e.EmptyLine()
+		e.Comment("exported months as (YYYY-MM)")
+		months := make([]string, 0, len(jcx.db.bootstrapMonths))
+		for month := range jcx.db.bootstrapMonths {
+			months = append(months, month)
+		}
+		sort.Strings(months)
+		e.Table("bootstrapMonths")
+		for _, month := range months {
+			e.AddString(month).EndRow()
+		}
+		e.EndTable()
+	}
+	if len(jcx.db.bootstrapRefetch) != 0 {
+		e.EmptyLine()
+		e.Comment("exported entries to fetch in full as (jitemid)")
+		e.Table("bootstrapRefetch")
+		for _, itemId := range jcx.db.bootstrapRefetch {
+			e.AddInt64(itemId).EndRow()
+		}
+		e.EndTable()
+	}
+	return e.GetBytes()
+}
+
+func writeJournalDB(jcx *journalContext) *Report {
 	var dbpath = filepath.Join(jcx.dir, journalDBFileName)
-	if err := writeFileTempRename(dbpath, e.GetBytes()); err != nil {
+	if !jcx.config.writesArchive() {
+		return ReportMsg("%s cannot write %s without the lock", jcx.config.command.name, dbpath)
+	}
+	if err := writeFileTempRename(dbpath, encodeJournalDB(jcx)); err != nil {
 		return WrapErr(err, "failed to write journal db file %s", dbpath)
 	}
+	return writeCommentItems(jcx)
+}
+
+func parseJournalDB(dbdata []byte, db *journalDB) error {
+	db.userMap = make(map[UserId]string)
+	db.securitySkipped = make(map[int64]string)
+	db.commentMap = make(map[CommentId]commentMeta)
+	db.commentItems = make(map[CommentId]int64)
+	db.commentEditTimes = make(map[CommentId]string)
+
+	d := newLinedbDecoder(dbdata)
+	for d.NextItem() {
+		switch d.ItemKind {
+		case linedb.ScalarItem:
+			switch d.ItemName {
+			case "schemaVersion":
+				db.schemaVersion = d.GetInt()
+				if err := checkSchemaVersion(db.schemaVersion, journalDBSchemaVersion); err != nil {
+					return err
+				}
+			case "lastSync":
+				db.lastSync = d.GetString()
+			case "journalUserId":
+				db.journalUserId = UserId(d.GetInt64())
+			case "layout":
+				db.layout = d.GetString()
+			}
+		case linedb.TableItem:
+			for d.NextRow() {
+				switch d.ItemName {
+				case "users":
+					db.userMap[UserId(d.GetInt64())] = d.GetString()
+				case "commentMeta":
+					db.commentMap[CommentId(d.GetInt64())] = commentMeta{
+						posterId: UserId(d.GetInt64()),
+						state:    d.GetString(),
+					}
+				case "commentItems":
+					db.commentItems[CommentId(d.GetInt64())] = d.GetInt64()
+					db.legacyCommentItems = true
+				case "commentEdits":
+					db.commentEditTimes[CommentId(d.GetInt64())] = d.GetString()
+				case "commentStates":
+					db.commentStates = append(db.commentStates, commentStateChange{
+						CommentId(d.GetInt64()), d.GetString(), d.GetString(), d.GetString(),
+					})
+				case "commentRefetch":
+					db.commentRefetch = append(db.commentRefetch, d.GetInt64())
+				case "securitySkipped":
+					db.securitySkipped[d.GetInt64()] = d.GetString()
+				case "syncActions":
+					db.syncActions = append(db.syncActions, syncAction{d.GetString(), d.GetString(), d.GetString()})
+				case "bootstrapItems":
+					if db.bootstrapItems == nil {
+						db.bootstrapItems = make(map[int64]string)
+					}
+					db.bootstrapItems[d.GetInt64()] = d.GetString()
+				case "bootstrapMonths":
+					if db.bootstrapMonths == nil {
+						db.bootstrapMonths = make(map[string]bool)
+					}
+					db.bootstrapMonths[d.GetString()] = true
+				case "bootstrapRefetch":
+					db.bootstrapRefetch = append(db.bootstrapRefetch, d.GetInt64())
+				}
+			}
+		}
+	}
+	if err := d.GetError(); err != nil {
+		return err
+	}
+	if err := migrateJournalDB(db, db.schemaVersion); err != nil {
+		return err
+	}
+	if !isJournalLayout(db.layout) {
+		return fmt.Errorf("unknown layout '%s'", db.layout)
+	}
 	return nil
 }
 
 func readJournalDB(jcx *journalContext) *Report {
+	jcx.db = journalDB{}
+	jcx.commentItemsChanged = false
+	jcx.commentItemsRemoved = false
 	var dbpath = filepath.Join(jcx.dir, journalDBFileName)
-	dbdata, err := ioutil.ReadFile(dbpath)
+	dbdata, err := archiveStore.ReadFile(dbpath)
 	if err != nil {
 		if !os.IsNotExist(err) {
 			return WrapErr(err, "")
 		}
 	}
 	if len(dbdata) == 0 {
-		log("Converting Python Journal DB into %s", dbpath)
-		err = readPythonLastRunFile(jcx)
-		if err == nil {
-			err = readPythonCommentMeta(jcx)
-			if err == nil {
-				err = readPythonUserMap(jcx)
-			}
-		}
+		conversion, err := readPythonJournalFiles(jcx)
 		if err != nil {
 			return WrapErr(err, "error while reading old python-generated DB files for journal %s", jcx.name)
 		}
-		if r := writeJournalDB(jcx); r != nil {
-			return r
+		if jcx.db.userMap == nil {
+			jcx.db.userMap = make(map[UserId]string)
 		}
-	} else {
-		jcx.db.userMap = make(map[UserId]string)
-		jcx.db.commentMap = make(map[CommentId]commentMeta)
+		if jcx.db.commentMap == nil {
+			jcx.db.commentMap = make(map[CommentId]commentMeta)
+		}
+		jcx.db.securitySkipped = make(map[int64]string)
+		jcx.db.commentItems = make(map[CommentId]int64)
+		jcx.db.commentEditTimes = make(map[CommentId]string)
+		jcx.db.rebuildCommentItems = len(conversion.files) != 0
 
-		d := linedb.NewByteDecoder(dbdata)
-		for d.NextItem() {
-			switch d.ItemKind {
-			case linedb.ScalarItem:
-				switch d.ItemName {
-				case "lastSync":
-					jcx.db.lastSync = d.GetString()
-				}
-			case linedb.TableItem:
-				for d.NextRow() {
-					switch d.ItemName {
-					case "users":
-						jcx.db.userMap[UserId(d.GetInt64())] = d.GetString()
-					case "commentMeta":
-						jcx.db.commentMap[CommentId(d.GetInt64())] = commentMeta{
-							posterId: UserId(d.GetInt64()),
-							state:    d.GetString(),
-						}
-					}
-				}
+		// A new archive gets the configured layout, older archives
+		// without the DB are flat
+		jcx.db.layout = flatLayout
+		if files, _ := listDumpFiles(jcx.dir); len(files) == 0 && jcx.config.journalLayout != "" {
+			jcx.db.layout = jcx.config.journalLayout
+		}
+		// Read-only commands use the converted data in memory. Other
+		// commands would write a DB next to the files, so they must wait
+		// for the conversion.
+		if len(conversion.files) != 0 && !jcx.convertPythonFiles && jcx.config.writesArchive() {
+			return ReportMsg("%s has files of ljdump.py, run dump or migrate to convert them first", jcx.dir)
+		}
+		if len(conversion.files) != 0 && jcx.convertPythonFiles {
+			log("Converting Python Journal DB into %s: %s", dbpath, strings.Join(conversion.converted, ", "))
+			if r := rebuildCommentItems(jcx); r != nil {
+				return r
+			}
+			if r := writeJournalDB(jcx); r != nil {
+				return r
+			}
+			if r := archivePythonJournalFiles(jcx, conversion); r != nil {
+				return r
+			}
+		}
+	} else if err := parseJournalDB(dbdata, &jcx.db); err != nil {
+		if isNewerSchemaError(err) {
+			return WrapErr(err, "cannot read journal db file %s", dbpath)
+		}
+		if !jcx.config.recoverCorruptDBs {
+			return WrapErr(err, "error while parsing journal db file %s as linedb, use -recover to rebuild it", dbpath)
+		}
+		if r := recoverJournalDB(jcx, dbpath, err); r != nil {
+			return r
+		}
+	}
+	if len(dbdata) != 0 && !jcx.db.rebuildCommentItems && !jcx.commentItemsChanged {
+		if jcx.db.legacyCommentItems {
+			// Move the table out of journal.linedb
+			jcx.commentItemsChanged = true
+		} else {
+			present, r := readCommentItems(jcx)
+			if r != nil {
+				return r
 			}
+			jcx.db.rebuildCommentItems = !present
+		}
+	}
+	if jcx.db.rebuildCommentItems {
+		if r := rebuildCommentItems(jcx); r != nil {
+			return r
 		}
 	}
+	if jcx.db.schemaVersion < journalDBSchemaVersion {
+		// Store in the current format on the next write
+		jcx.db.schemaVersion = journalDBSchemaVersion
+		jcx.shouldWriteDB = true
+	}
 	jcx.origDbLastSync = jcx.db.lastSync
 	return nil
 }
 
-func readPythonLastRunFile(jcx *journalContext) error {
-	jcx.db.lastSync = ""
+// Name and value of the attribute of elements with the value in base64.
+// Older versions removed characters that XML does not allow and recorded
+// their number in the stripped-control-chars attribute.
+const valueEncodingAttr = ljarchive.ValueEncodingAttr
+const base64ValueEncoding = ljarchive.Base64ValueEncoding
+
+// Check that XML 1.0 allows the character in documents. Other characters
+// cannot appear even as character references.
+func isXmlChar(r rune) bool {
+	return r == '\t' || r == '\n' || r == '\r' ||
+		(0x20 <= r && r <= 0xD7FF) || (0xE000 <= r && r <= 0xFFFD) || (0x10000 <= r && r <= 0x10FFFF)
+}
 
-	var p = filepath.Join(jcx.dir, ".last")
-	var f, err = os.Open(p)
-	if err != nil {
-		if os.IsNotExist(err) {
-			err = nil
+// Check that the valid UTF-8 string can be stored as XML text
+func isXmlText(s string) bool {
+	for _, r := range s {
+		if !isXmlChar(r) {
+			return false
 		}
-		return err
-	}
-
-	// Read only first line with time sync as maxid is derived from comments meta data
-	var s = bufio.NewScanner(f)
-	if s.Scan() {
-		jcx.db.lastSync = s.Text()
 	}
-	return fuseErr(s.Err(), f.Close())
+	return true
 }
 
-func readPythonCommentMeta(jcx *journalContext) error {
-	var p = filepath.Join(jcx.dir, "comment.meta")
-	var file, err = os.Open(p)
-	if err != nil {
-		if os.IsNotExist(err) {
-			err = nil
-		}
-		return err
+func writeLJEventDump(jcx *journalContext, eventPath string, eventType byte, itemId int64, event map[string]interface{}) *Report {
+	data, fixes, r := encodeLJStruct("event", event)
+	if r != nil {
+		return r
 	}
+	fixes.logWarnings(jcx.config, fmt.Sprintf("%c-%d", eventType, itemId))
 
-	var pythonData map[interface{}]interface{}
-	pythonData, err = stalecucumber.Dict(stalecucumber.Unpickle(file))
-	if err == nil {
-		jcx.db.commentMap = make(map[CommentId]commentMeta, len(pythonData))
-		for key, value := range pythonData {
-			var idnum, keyOk = key.(int64)
-			if !keyOk {
-				err = fmt.Errorf("Unexpected key type %T in %s", key, p)
-				break
-			}
-			var structValue, valueOk = value.(map[interface{}]interface{})
-			if !valueOk {
-				err = fmt.Errorf("Unexpected value type %T in %s", value, p)
-				break
-			}
-			var posterIdStr, posterIdOk = structValue["posterid"].(string)
-			if !posterIdOk {
-				err = fmt.Errorf("Unexpected posterid type %T in %s", structValue["posterid"], p)
-				break
-			}
-			var state, stateOk = structValue["state"].(string)
-			if !stateOk {
-				err = fmt.Errorf("Unexpected state type %T in %s", structValue["state"], p)
-				break
-			}
-			var posterId UserId
-			posterId, err = parseUserId(posterIdStr)
-			if err != nil {
-				break
-			}
+	jcx.stageWrite(eventPath, data)
+	return nil
+}
 
-			var id = CommentId(idnum)
-			jcx.db.commentMap[id] = commentMeta{posterId, state}
-		}
-	}
-	return fuseErr(err, file.Close())
+// Changes that encodeLJStruct made to values to keep the XML well-formed
+type xmlValueFixes struct {
+	// The number of values stored in base64
+	base64Values int
+
+	// The number of values converted from each legacy charset, see
+	// charset.go
+	charsets map[string]int
 }
 
-func readPythonUserMap(jcx *journalContext) error {
-	var p = filepath.Join(jcx.dir, "user.map")
-	var file, err = os.Open(p)
-	if err != nil {
-		if os.IsNotExist(err) {
-			err = nil
-		}
-		return err
+func (fixes *xmlValueFixes) logWarnings(config *Config, what string) {
+	if fixes.base64Values != 0 {
+		config.warn("stored %d values of %s with characters that are not allowed in XML in base64", fixes.base64Values, what)
 	}
-
-	var pythonData map[string]interface{}
-	pythonData, err = stalecucumber.DictString(stalecucumber.Unpickle(file))
-	if err == nil {
-		jcx.db.userMap = make(map[UserId]string, len(pythonData))
-		for userIdStr, userValue := range pythonData {
-			var user, userOk = userValue.(string)
-			if !userOk {
-				err = fmt.Errorf("Unexpected user name type %T in %s", userValue, p)
-				break
-			}
-			var userId UserId
-			userId, err = parseUserId(userIdStr)
-			if err != nil {
-				break
-			}
-			jcx.db.userMap[userId] = user
-		}
+	for charset, count := range fixes.charsets {
+		config.warn("converted %d values of %s that are not UTF-8 from %s", count, what, charset)
 	}
-	return fuseErr(err, file.Close())
 }
 
-func writeLJEventDump(jcx *journalContext, eventType byte, itemId int64, event map[string]interface{}) *Report {
-
+// Serialize the struct from the protocol as XML with the root element.
+// Return the data and what was changed in values. The result is always
+// well-formed: strings that are not UTF-8 are converted from the legacy
+// charset, see charset.go, strings with characters that XML does not
+// allow are stored in base64 and carriage returns are written as
+// character references so parsers do not turn them into newlines.
+func encodeLJStruct(root string, m map[string]interface{}) ([]byte, *xmlValueFixes, *Report) {
 	buf := bytes.NewBufferString(xml.Header)
 	var tmparea []byte
+	fixes := &xmlValueFixes{charsets: make(map[string]int)}
 
 	var serializeTagValue func(tag string, v interface{}) *Report
 
@@ -696,8 +2222,9 @@ func writeLJEventDump(jcx *journalContext, eventType byte, itemId int64, event m
 	}
 
 	// xml.EscapeText escapes way too much
-	addEscapeXmlValue := func(s []byte) {
-		for _, b := range s {
+	addEscapeXmlValue := func(s string) {
+		for i := 0; i < len(s); i++ {
+			b := s[i]
 			replace := ""
 			switch b {
 			case '<':
@@ -706,6 +2233,8 @@ func writeLJEventDump(jcx *journalContext, eventType byte, itemId int64, event m
 				replace = "&gt;"
 			case '&':
 				replace = "&amp;"
+			case '\r':
+				replace = "&#13;"
 			default:
 				buf.WriteByte(b)
 				continue
@@ -747,6 +2276,20 @@ func writeLJEventDump(jcx *journalContext, eventType byte, itemId int64, event m
 			buf.WriteString("/>\n")
 			return nil
 		}
+		if v, isString := value.(string); isString {
+			if !utf8.ValidString(v) {
+				var attrs, charset string
+				v, attrs, charset = transcodeLegacyString(v)
+				buf.WriteString(attrs)
+				fixes.charsets[charset]++
+			}
+			if !isXmlText(v) {
+				fmt.Fprintf(buf, " %s=\"%s\"", valueEncodingAttr, base64ValueEncoding)
+				v = base64.StdEncoding.EncodeToString([]byte(v))
+				fixes.base64Values++
+			}
+			value = v
+		}
 		buf.WriteByte('>')
 		switch v := value.(type) {
 		case int:
@@ -756,8 +2299,7 @@ func writeLJEventDump(jcx *journalContext, eventType byte, itemId int64, event m
 			tmparea = strconv.AppendInt(tmparea[0:0], v, 10)
 			buf.Write(tmparea)
 		case string:
-			tmparea = append(tmparea[0:0], v...)
-			addEscapeXmlValue(tmparea)
+			addEscapeXmlValue(v)
 		case map[string]interface{}:
 			buf.WriteByte('\n')
 			if r := serializeMap(v); r != nil {
@@ -772,75 +2314,90 @@ func writeLJEventDump(jcx *journalContext, eventType byte, itemId int64, event m
 		return nil
 	}
 
-	buf.WriteString("<event>\n")
-	if r := serializeMap(event); r != nil {
-		return r
-	}
-	buf.WriteString("</event>\n")
-
-	eventPath := filepath.Join(jcx.dir, fmt.Sprintf("%c-%d", eventType, itemId))
-	if err := writeFileTempRename(eventPath, buf.Bytes()); err != nil {
-		return WrapErr(err, "")
+	buf.WriteString("<" + root + ">\n")
+	if r := serializeMap(m); r != nil {
+		return nil, nil, r
 	}
-	return nil
+	buf.WriteString("</" + root + ">\n")
+	return buf.Bytes(), fixes, nil
 }
 
 type ljSession struct {
 	config          *Config
 	client          http.Client
+	transport       http.RoundTripper
 	lastRequestTime time.Time
 	loginCookie     string
+
+	// Cookies set by the server and the login cookie, see cookies.go
+	jar http.CookieJar
+
+	// The response to the flat login call with account information
+	loginResponse map[string]string
+
+	// Lazily created client for XML-RPC calls
+	xmlrpcClient *xmlrpc.Client
+
+	// Id of the last JSON-RPC request
+	jsonrpcId int
+
+	// How the session was established and the OAuth access token sent
+	// with each request, see auth.go. auth is nil for the anonymous
+	// session of dump-public, see public.go.
+	auth        authenticator
+	bearerToken string
+
+	// Minimal time between requests when larger than the default
+	requestInterval time.Duration
 }
 
-// Get LJ session cookie,
+// Log in with the configured authenticator, see auth.go and
 // http://www.livejournal.com/doc/server/ljp.csp.flat.protocol.html
 func openLJSession(config *Config) (*ljSession, *Report) {
 	session := &ljSession{
-		config: config,
+		config:    config,
+		transport: &timeoutTransport{config, config.baseTransport()},
+		jar:       newSessionJar(),
 	}
 	session.client.Transport = session
 
-	calculateChallengeResponse := func(challenge string) string {
-		var passhash = fmt.Sprintf("%x", md5.Sum([]byte(config.password)))
-		return fmt.Sprintf("%x", md5.Sum([]byte(challenge+passhash)))
-	}
-
-	v := url.Values{}
-	v.Set("mode", "getchallenge")
-	responseMap, r := callLJFlatInterface(session, v)
-	if r != nil {
+	session.auth = config.authenticator()
+	if r := session.auth.login(session); r != nil {
 		return nil, r
 	}
-	challenge := responseMap["challenge"]
-	if challenge == "" {
-		return nil, ReportMsg("no challenge is resposne")
-	}
-	v = url.Values{}
-	v.Set("mode", "sessiongenerate")
-	v.Set("user", config.username)
-	v.Set("auth_method", "challenge")
-	v.Set("auth_challenge", challenge)
-	v.Set("auth_response", calculateChallengeResponse(challenge))
-	v.Set("ipfixed", "1")
+	return session, nil
+}
 
-	log("Logging in to %s", config.server)
-	responseMap, r = callLJFlatInterface(session, v)
+func callLJFlatInterface(session *ljSession, values url.Values) (map[string]string, *Report) {
+	nameValueMap, firstLine, r := postLJFlatForm(session, values)
 	if r != nil {
 		return nil, r
 	}
-
-	session.loginCookie = responseMap["ljsession"]
-	if session.loginCookie == "" {
-		return nil, ReportMsg("failed to login to %s, perhaps the password was invalid", config.server)
+	status := nameValueMap["success"]
+	if status != "OK" {
+		errmsg := nameValueMap["errmsg"]
+		if errmsg == "" {
+			return nil, ReportMsg(
+				"Server Error with flat protocol, try again later. mode=%s status=%s\n\t%s",
+				values.Get("mode"), status, firstLine,
+			)
+		} else {
+			return nil, ReportMsg(
+				"Server reported error with flat protocol mode=%s status=%s\n\t%s",
+				values.Get("mode"), status, errmsg,
+			)
+		}
 	}
-	return session, nil
+	return nameValueMap, nil
 }
 
-func callLJFlatInterface(session *ljSession, values url.Values) (map[string]string, *Report) {
+// Post the flat protocol request and return the name-value pairs of the
+// response and its first line without checking the status
+func postLJFlatForm(session *ljSession, values url.Values) (map[string]string, string, *Report) {
 	posturl := session.config.server + "/interface/flat"
 	resp, err := session.client.PostForm(posturl, values)
 	if err != nil {
-		return nil, WrapErr(err, "")
+		return nil, "", WrapErr(err, "")
 	}
 
 	s := bufio.NewScanner(resp.Body)
@@ -863,25 +2420,9 @@ func callLJFlatInterface(session *ljSession, values url.Values) (map[string]stri
 	}
 	err = fuseErr(s.Err(), resp.Body.Close())
 	if err != nil {
-		return nil, WrapErr(err, "")
-	}
-
-	status := nameValueMap["success"]
-	if status != "OK" {
-		errmsg := nameValueMap["errmsg"]
-		if errmsg == "" {
-			return nil, ReportMsg(
-				"Server Error with flat protocol, try again later. mode=%s status=%s\n\t%s",
-				values.Get("mode"), status, firstLine,
-			)
-		} else {
-			return nil, ReportMsg(
-				"Server reported error with flat protocol mode=%s status=%s\n\t%s",
-				values.Get("mode"), status, errmsg,
-			)
-		}
+		return nil, "", WrapErr(err, "")
 	}
-	return nameValueMap, nil
+	return nameValueMap, firstLine, nil
 }
 
 func callLJFlatMathod(
@@ -891,13 +2432,43 @@ func callLJFlatMathod(
 	v.Set("mode", method)
 	v.Set("ver", "1")
 	v.Set("user", session.config.username)
-	v.Set("auth_method", "cookie")
+	v.Set("auth_method", session.auth.authMethod())
 	for i := 0; i != len(nameValuePairs); i += 2 {
 		v.Set(nameValuePairs[i], nameValuePairs[i+1])
 	}
 	return callLJFlatInterface(session, v)
 }
 
+// Call LJ.XMLRPC.<method> with the session cookie authentication. See
+// http://www.livejournal.com/doc/server/ljp.csp.xml-rpc.protocol.html
+func callLJXmlRpcMethod(
+	session *ljSession, method string, input map[string]interface{}, result interface{},
+) *Report {
+	input["username"] = session.config.username
+	input["ver"] = 1
+	input["auth_method"] = session.auth.authMethod()
+
+	if session.config.api == jsonrpcAPI {
+		return callLJJsonRpcMethod(session, method, input, result)
+	}
+	if session.xmlrpcClient == nil {
+		client, err := xmlrpc.NewClient(
+			session.config.server+"/interface/xmlrpc",
+			session.client.Transport,
+		)
+		if err != nil {
+			return WrapErr(err, "")
+		}
+		session.xmlrpcClient = client
+	}
+
+	err := session.xmlrpcClient.Call("LJ.XMLRPC."+method, input, result)
+	if err != nil {
+		return WrapErr(err, "")
+	}
+	return nil
+}
+
 func getLJFlatArray(arrayName string, m map[string]string) ([]string, *Report) {
 	key := arrayName + "_count"
 	countStr := m[key]
@@ -932,10 +2503,13 @@ func (session *ljSession) RoundTrip(req *http.Request) (*http.Response, error) {
 	// https://github.com/golang/go/issues/4800
 
 	req.Header.Set("User-Agent", "Bot - https://github.com/ibukanov/ljdumpgo; igor@mir2.org")
+	session.addJarCookies(req)
 	if session.loginCookie != "" {
-		req.Header.Set("Cookie", "ljsession="+session.loginCookie)
 		req.Header.Set("X-LJ-Auth", "cookie")
 	}
+	if session.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+session.bearerToken)
+	}
 
 	if false {
 		s, _ := httputil.DumpRequestOut(req, true)
@@ -943,7 +2517,10 @@ func (session *ljSession) RoundTrip(req *http.Request) (*http.Response, error) {
 	}
 
 	// rate-limit number of requests to avoid blacklisting by IP
-	const minimalTimeBetweenRequests = 250 * time.Millisecond
+	minimalTimeBetweenRequests := 250 * time.Millisecond
+	if session.requestInterval > minimalTimeBetweenRequests {
+		minimalTimeBetweenRequests = session.requestInterval
+	}
 	newRequestTime := time.Now()
 	if !session.lastRequestTime.IsZero() {
 		sinceLastRequest := newRequestTime.Sub(session.lastRequestTime)
@@ -953,7 +2530,15 @@ func (session *ljSession) RoundTrip(req *http.Request) (*http.Response, error) {
 	}
 	session.lastRequestTime = newRequestTime
 
-	res, err := http.DefaultTransport.RoundTrip(req)
+	res, err := session.transport.RoundTrip(req)
+	if err != nil {
+		session.config.errorLog.record("network", "%s %s - %s", req.Method, req.URL.Path, err.Error())
+	} else {
+		session.storeJarCookies(req, res)
+	}
+	if err == nil && res.StatusCode >= 400 {
+		session.config.errorLog.record("http", "%d %s %s?%s", res.StatusCode, req.Method, req.URL.Path, req.URL.RawQuery)
+	}
 	if false {
 		s, _ := httputil.DumpResponse(res, true)
 		fmt.Println(string(s))
@@ -973,20 +2558,27 @@ func dumpAccountData(session *ljSession, accountData *accountData) *Report {
 
 	log("Fetching user info for: %s", session.config.username)
 
-	if err := os.MkdirAll(session.config.accountDataDir, 0777); err != nil {
+	if err := mkdirArchive(session.config.accountDataDir); err != nil {
 		return WrapErr(err, "failed to create directory for account data %s", session.config.accountDataDir)
 	}
 
-	updated := false
+	updated := accountData.upgraded
 
-	responseMap, r := callLJFlatMathod(
-		"login", session,
-		"getpickws", "1",
-		"getpickwurls", "1",
-	)
+	var responseMap map[string]string
+	var r *Report
+	if session.config.api == jsonrpcAPI {
+		responseMap, r = jsonRpcLoginResponse(session)
+	} else {
+		responseMap, r = callLJFlatMathod(
+			"login", session,
+			"getpickws", "1",
+			"getpickwurls", "1",
+		)
+	}
 	if r != nil {
 		return r
 	}
+	session.loginResponse = responseMap
 	keywordArrayName, urlsArrayName := "pickw", "pickwurl"
 
 	keywords, r := getLJFlatArray(keywordArrayName, responseMap)
@@ -999,8 +2591,8 @@ func dumpAccountData(session *ljSession, accountData *accountData) *Report {
 	}
 	if len(keywords) != len(urls) {
 		return ReportMsg(
-			"%s and %s arrays in LJ flat response have different lengths, %sd != %d",
-			keywordArrayName, urlsArrayName, len(keywords) != len(urls),
+			"%s and %s arrays in LJ flat response have different lengths, %d != %d",
+			keywordArrayName, urlsArrayName, len(keywords), len(urls),
 		)
 	}
 
@@ -1016,7 +2608,7 @@ func dumpAccountData(session *ljSession, accountData *accountData) *Report {
 		if keywordIndex >= 0 {
 			keyword = keywords[keywordIndex]
 			if keyword == "" {
-				log("WARNING: got empty keyword for user picture %s", url)
+				session.config.warn("got empty keyword for user picture %s", url)
 				return nil
 			}
 		}
@@ -1028,7 +2620,7 @@ func dumpAccountData(session *ljSession, accountData *accountData) *Report {
 
 		// Use default client, not a custom, to avoid appliing cookie etc headers.
 		// Also ignore any download-related errors
-		res, err := http.Get(url)
+		res, err := session.config.httpClient().Get(url)
 		if err == nil {
 			var data []byte
 			data, err = ioutil.ReadAll(res.Body)
@@ -1060,6 +2652,9 @@ func dumpAccountData(session *ljSession, accountData *accountData) *Report {
 					return WrapErr(err, "")
 				}
 				accountData.pictureUrlFileMap[url] = pictureFile
+				if v := responseValidators(res); !v.empty() {
+					accountData.pictureValidators[url] = v
+				}
 				if keyword == "" {
 					accountData.pictureDefaultUrl = url
 				} else {
@@ -1070,20 +2665,46 @@ func dumpAccountData(session *ljSession, accountData *accountData) *Report {
 			}
 		}
 		if err != nil {
-			log("WARNING: failed to download userpic %s", url)
+			session.config.warn("failed to download userpic %s", url)
 		}
 		return nil
 	}
 
+	if session.config.refreshMedia && refreshPictures(session.config, accountData) {
+		updated = true
+	}
 	if r := fetchAnsStorePictureUrl(-1, responseMap["defaultpicurl"]); r != nil {
 		return r
 	}
 	for i, url := range urls {
+		if shutdownRequested() || session.config.runtimeExceeded() {
+			break
+		}
 		if r := fetchAnsStorePictureUrl(i, url); r != nil {
 			return r
 		}
 	}
 
+	current := make(map[string]string, len(keywords)+1)
+	for i, keyword := range keywords {
+		current[keyword] = urls[i]
+	}
+	if url := responseMap["defaultpicurl"]; url != "" {
+		current[""] = url
+	}
+	if updatePictureHistory(accountData, current, time.Now().UTC().Format(time.RFC3339)) {
+		updated = true
+	}
+
+	if len(current) != 0 && !shutdownRequested() {
+		infoUpdated, r := dumpPictureInfo(session, accountData)
+		if r != nil {
+			session.config.warn("failed to archive userpic descriptions - %s", r.AsText())
+		} else if infoUpdated {
+			updated = true
+		}
+	}
+
 	if updated {
 		if r := writeAccountData(accountData, session.config); r != nil {
 			return r
@@ -1093,6 +2714,39 @@ func dumpAccountData(session *ljSession, accountData *accountData) *Report {
 	return nil
 }
 
+// Stage the write of the entry file and update the entries index
+func (jcx *journalContext) stageEntry(itemid int64, event map[string]interface{}) *Report {
+	if jcx.skipEntryBySecurity(itemid, event) {
+		return nil
+	}
+	jcx.transformEntry(itemid, event)
+	eventTime, _ := event["eventtime"].(string)
+	if normalized := jcx.config.normalizeEventTime(eventTime); normalized != "" {
+		event["eventtime_rfc3339"] = normalized
+	}
+	addEventPermalink(jcx.config, jcx.name, itemid, event)
+	previous, _ := jcx.readStagedFile(filepath.Join(jcx.entryDir(itemid), fmt.Sprintf("L-%d", itemid)))
+	eventPath, r := jcx.prepareEntryPath(itemid, eventTime)
+	if r != nil {
+		return r
+	}
+	if r := writeLJEventDump(jcx, eventPath, 'L', itemid, event); r != nil {
+		return r
+	}
+	data, _ := jcx.readStagedFile(eventPath)
+	archived, err := parseArchivedEntryFile(eventPath, data)
+	if err != nil {
+		return WrapErr(err, "failed to read back entry L-%d", itemid)
+	}
+	if previous != nil {
+		if r := jcx.keepEntryRevision(itemid, previous, archived); r != nil {
+			return r
+		}
+	}
+	jcx.index.setEntry(archived, entryRelPath(jcx.dir, archived))
+	return nil
+}
+
 func dumpJournalPosts(jcx *journalContext) *Report {
 
 	log("Fetching journal entries for: %s", jcx.name)
@@ -1134,37 +2788,36 @@ func dumpJournalPosts(jcx *journalContext) *Report {
 		Events []LJEvent `xmlrpc:"events"`
 	}
 
-	var client, err = xmlrpc.NewClient(
-		jcx.config.server+"/interface/xmlrpc",
-		jcx.session.client.Transport,
-	)
-	if err != nil {
-		return WrapErr(err, "")
-	}
-	defer client.Close()
-
 	callWithLogin := func(method string, input map[string]interface{}, result interface{}) *Report {
-		input["username"] = jcx.config.username
-		input["ver"] = 1
-		input["auth_method"] = "cookie"
+		return callLJXmlRpcMethod(jcx.session, method, input, result)
+	}
 
-		err := client.Call("LJ.XMLRPC."+method, input, result)
-		if err != nil {
-			return WrapErr(err, "")
+	if jcx.config.bootstrap && jcx.db.lastSync == "" {
+		if r := bootstrapJournalPosts(jcx); r != nil || jcx.suspended {
+			return r
 		}
-		return nil
 	}
 
 	for {
-		var syncItemsParams = map[string]interface{}{
-			"lastsync":   jcx.db.lastSync,
-			"usejournal": jcx.name,
-		}
+		var syncItemsParams = usejournalParams(jcx.config, jcx.name, map[string]interface{}{
+			"lastsync": jcx.db.lastSync,
+		})
 		var syncItemsResult LJSyncItemsResult
 		if r := callWithLogin("syncitems", syncItemsParams, &syncItemsResult); r != nil {
+			if isJournalAccessError(r) {
+				return journalAccessReport(jcx.config, jcx.name, "entries", "a member with posting access", r)
+			}
 			return r
 		}
 		if len(syncItemsResult.SyncItems) == 0 {
+			finishBootstrap(jcx)
+			if r := fetchAllowedSkippedEntries(jcx); r != nil || jcx.sliceExpired() {
+				return r
+			}
+			if r := refetchBootstrappedEntries(jcx); r != nil || jcx.sliceExpired() {
+				return r
+			}
+			jcx.postsDone = true
 			break
 		}
 
@@ -1175,25 +2828,31 @@ func dumpJournalPosts(jcx *journalContext) *Report {
 		// is very unclear.
 
 		for _, item := range syncItemsResult.SyncItems {
+			if jcx.sliceExpired() {
+				return nil
+			}
+
 			// check that Item is in TypeLetter-Number format as we use that as a file path.
 			if len(item.Item) < 3 || item.Item[1] != '-' {
-				log("WARNING: invalid SyncItems id %s", item.Item[1])
+				jcx.config.warn("invalid SyncItems id %s", item.Item)
 				continue
 			}
 			itemid, err := strconv.ParseInt(item.Item[2:], 10, 64)
 			if err != nil {
-				log("WARNING: invalid SyncItems id %s", item.Item[1])
+				jcx.config.warn("invalid SyncItems id %s", item.Item)
 				continue
 			}
-			if item.Item[0] == 'L' {
+			kept := item.Item[0] == 'L' && jcx.keepBootstrappedEntry(itemid, item.Action, item.Time)
+			if kept {
+				log("Keeping journal entry %s from the monthly export", item.Item)
+			} else if item.Item[0] == 'L' {
 				log("Fetching journal entry %s (%s)", item.Item, item.Action)
 
-				var geteventsParams = map[string]interface{}{
+				var geteventsParams = usejournalParams(jcx.config, jcx.name, map[string]interface{}{
 					"selecttype":  "one",
 					"itemid":      itemid,
-					"usejournal":  jcx.name,
 					"lineendings": "unix",
-				}
+				})
 				var geteventsResult LJGeteventsResult
 				if r := callWithLogin("getevents", geteventsParams, &geteventsResult); r != nil {
 					return r
@@ -1201,31 +2860,48 @@ func dumpJournalPosts(jcx *journalContext) *Report {
 				if len(geteventsResult.Events) == 0 {
 					return ReportMsg("Unexpected empty item %s", item.Item)
 				}
-				if r := writeLJEventDump(jcx, item.Item[0], itemid, geteventsResult.Events[0]); r != nil {
+				if r := jcx.stageEntry(itemid, geteventsResult.Events[0]); r != nil {
 					return r
 				}
-				jcx.newEntries++
 			}
+			jcx.db.syncActions = append(jcx.db.syncActions, syncAction{item.Item, item.Action, item.Time})
 			jcx.db.lastSync = item.Time
 			jcx.shouldWriteDB = true
+			if r := jcx.commitPendingWrites(); r != nil {
+				return r
+			}
+			if item.Item[0] == 'L' && !kept && !jcx.isSkippedBySecurity(itemid) {
+				jcx.newEntries++
+				jcx.config.budget.addNewEntries(1)
+				jcx.newEntryIds = append(jcx.newEntryIds, itemid)
+			}
 		}
 	}
 	return nil
 }
 
+// Format of C-<jitemid> files with all comments to the entry
+// The comment files are shared with other tools, see ljarchive. Edits are
+// recorded as in comment_edits.go.
+type CommentRecord = ljarchive.Comment
+type CommentVersion = ljarchive.CommentVersion
+type CommentProp = ljarchive.CommentProp
+type CommentFile = ljarchive.CommentFile
+
 // See http://www.livejournal.com/doc/server/ljp.csp.export_comments.html
 func dumpJournalComments(jcx *journalContext) *Report {
 	log("Fetching journal comments for: %s", jcx.name)
 
-	var authas = ""
-	if jcx.config.username != jcx.name {
-		authas = fmt.Sprintf("&authas=%s", url.QueryEscape(jcx.name))
-	}
-
 	type LJCommentMeta struct {
 		Id       CommentId `xml:"id,attr"`
 		PosterId UserId    `xml:"posterid,attr"`
 		State    string    `xml:"state,attr"`
+		EditTime string    `xml:"edittime,attr"`
+	}
+
+	type LJCommentProp struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:",chardata"`
 	}
 
 	type LJComment struct {
@@ -1235,10 +2911,11 @@ func dumpJournalComments(jcx *journalContext) *Report {
 		JItemId  int64     `xml:"jitemid,attr"`
 
 		// Use string, not CommentId, as this can be empty
-		ParentId string `xml:"parentid,attr"`
-		Subject  string `xml:"subject"`
-		Body     string `xml:"body"`
-		Date     string `xml:"date"`
+		ParentId string          `xml:"parentid,attr"`
+		Subject  string          `xml:"subject"`
+		Body     string          `xml:"body"`
+		Date     string          `xml:"date"`
+		Props    []LJCommentProp `xml:"property"`
 	}
 
 	type LJUserMap struct {
@@ -1246,35 +2923,6 @@ func dumpJournalComments(jcx *journalContext) *Report {
 		User string `xml:"user,attr"`
 	}
 
-	type LJCommentMetaChunk struct {
-		XMLName  xml.Name        `xml:"livejournal"`
-		MaxId    CommentId       `xml:"maxid"`
-		Comments []LJCommentMeta `xml:"comments>comment"`
-		UserMaps []LJUserMap     `xml:"usermaps>usermap"`
-	}
-
-	type LJCommentChunk struct {
-		XMLName  xml.Name    `xml:"livejournal"`
-		Comments []LJComment `xml:"comments>comment"`
-	}
-
-	type CommentRecord struct {
-		Id    CommentId `xml:"id"`
-		State string    `xml:"state"`
-		User  string    `xml:"user"`
-
-		// Use string, not CommentId, as this can be empty
-		ParentId string `xml:"parentid"`
-		Date     string `xml:"date"`
-		Subject  string `xml:"subject"`
-		Body     string `xml:"body"`
-	}
-
-	type CommentFile struct {
-		XMLName  xml.Name        `xml:"comments"`
-		Comments []CommentRecord `xml:"comment"`
-	}
-
 	newComments := make(map[CommentId]commentMeta)
 	newCommentUsers := make(map[UserId]string)
 
@@ -1289,63 +2937,160 @@ func dumpJournalComments(jcx *journalContext) *Report {
 	// as well rather than assuming that we have everything betwen 1
 	// and maxStoredCommentId.
 
-	fetchCommentData := func(kind string, maxid CommentId, v interface{}) *Report {
-		geturl := fmt.Sprintf(
-			"%s/export_comments.bml?get=comment_%s&startid=%d%s",
+	// Fetch the chunk after maxid passing its elements to handle, see
+	// comment_stream.go
+	fetchCommentData := func(kind string, maxid CommentId, handle commentChunkHandler) *Report {
+		geturl := authasPath(jcx.config, fmt.Sprintf(
+			"%s/export_comments.bml?get=comment_%s&startid=%d&props=1",
 			jcx.config.server,
 			kind,
 			maxid+1,
-			authas,
-		)
+		), jcx.name)
 		resp, err := jcx.session.client.Get(geturl)
-		var data []byte
-		if err == nil {
-			data, err = ioutil.ReadAll(resp.Body)
-			err = fuseErr(err, resp.Body.Close())
-		}
 		if err != nil {
 			return WrapErr(err, "failed to read comment_%s response", kind)
 		}
-
-		err = xml.Unmarshal(data, v)
-		if err != nil {
-			return WrapErr(err, "failed to process comments_%s response, possibly not community maintainer?", kind)
+		r := decodeCommentChunk(resp.Body, kind, handle)
+		if err := resp.Body.Close(); err != nil && r == nil {
+			return WrapErr(err, "failed to read comment_%s response", kind)
 		}
-		return nil
+		return r
+	}
+
+	// With -recheck-comments the meta data of all comments is fetched to
+	// find archived comments that changed
+	changedComments := make(map[CommentId]bool)
+	metaStart := maxStoredCommentId
+	if jcx.config.recheckComments {
+		metaStart = -1
 	}
 
+	// For entries that verify found with missing comments fetch bodies of
+	// all archived meta data without an archived body, see comment_items.go
+	refetchMissing := len(jcx.db.commentRefetch) != 0
+	if refetchMissing {
+		log("Looking for comments missing in %d entries", len(jcx.db.commentRefetch))
+		metaStart = -1
+	}
 	newMaxId := maxStoredCommentId
 	for {
-		var metaChunk LJCommentMetaChunk
-		if r := fetchCommentData("meta", newMaxId, &metaChunk); r != nil {
-			return r
-		}
-
-		for i := range metaChunk.Comments {
-			c := &metaChunk.Comments[i]
-			newComments[c.Id] = commentMeta{posterId: c.PosterId, state: c.State}
-			if newMaxId < c.Id {
-				newMaxId = c.Id
+		var maxId, nextId CommentId
+		received := 0
+		chunkStart := metaStart
+		r := fetchCommentData("meta", metaStart, func(d *xml.Decoder, parent string, start *xml.StartElement) *Report {
+			switch {
+			case parent == "comments":
+				var c LJCommentMeta
+				if r := decodeChunkElement("meta", d, start, &c); r != nil {
+					return r
+				}
+				received++
+				newComments[c.Id] = commentMeta{posterId: c.PosterId, state: c.State}
+				if c.Id <= maxStoredCommentId {
+					if jcx.config.recheckComments && jcx.db.commentChanged(c.Id, c.State, c.EditTime) ||
+						refetchMissing && !jcx.db.hasCommentBody(c.Id) {
+						changedComments[c.Id] = true
+					}
+				}
+				if newMaxId < c.Id {
+					newMaxId = c.Id
+				}
+				if metaStart < c.Id {
+					metaStart = c.Id
+				}
+			case parent == "usermaps":
+				var u LJUserMap
+				if r := decodeChunkElement("meta", d, start, &u); r != nil {
+					return r
+				}
+				newCommentUsers[u.Id] = u.User
+			case start.Name.Local == "maxid":
+				return decodeChunkElement("meta", d, start, &maxId)
+			case start.Name.Local == "nextid":
+				return decodeChunkElement("meta", d, start, &nextId)
+			default:
+				return decodeChunkElement("meta", d, start, &struct{}{})
+			}
+			return nil
+		})
+		if r != nil {
+			if !canExportComments(jcx.session, jcx.name) {
+				return journalAccessReport(jcx.config, jcx.name, "comments", "a maintainer", r)
 			}
+			return r
 		}
-		for _, u := range metaChunk.UserMaps {
-			newCommentUsers[u.Id] = u.User
+		if metaStart, r = nextCommentPage("meta", chunkStart, metaStart, received == 0, nextId); r != nil {
+			return r
 		}
-		if newMaxId >= metaChunk.MaxId {
+		if metaStart >= maxId || received == 0 {
 			// We fetched all comment updates
 			break
 		}
 	}
 
+	// Merge the meta data of the comments with ids up to maxid into the
+	// journal DB.
+	recordFetchedComments := func(maxid CommentId) {
+		now := time.Now().UTC().Format(time.RFC3339)
+		for commentId, commentMeta := range newComments {
+			if commentId <= maxid {
+				if jcx.db.updateCommentMeta(commentId, commentMeta, now) {
+					jcx.shouldWriteDB = true
+				}
+				delete(newComments, commentId)
+			}
+		}
+		for userId, user := range newCommentUsers {
+			jcx.db.userMap[userId] = user
+			jcx.shouldWriteDB = true
+		}
+	}
+
+	// Bodies are committed per batch, see comment_batch.go, together with
+	// the meta data of the comments up to the last stored body so the next
+	// run continues from the first missing body.
 	maxFetchedId := maxStoredCommentId
-	for {
-		var chunk LJCommentChunk
-		if r := fetchCommentData("body", maxFetchedId, &chunk); r != nil {
+	if len(changedComments) != 0 {
+		log("Refetching %d archived comments that may have changed", len(changedComments))
+		maxFetchedId = nextChangedComment(changedComments, -1) - 1
+	}
+	editedComments := 0
+	batch := newCommentBatch(jcx)
+	unflushed := false
+	flushBatch := func() *Report {
+		if !unflushed {
+			return nil
+		}
+		newStored := batch.flush()
+		recordFetchedComments(maxFetchedId)
+		if r := jcx.commitPendingWrites(); r != nil {
 			return r
 		}
-
-		for i := range chunk.Comments {
-			c := &chunk.Comments[i]
+		jcx.newComments += newStored
+		unflushed = false
+		return nil
+	}
+	for {
+		if jcx.sliceExpired() {
+			return flushBatch()
+		}
+
+		var nextId CommentId
+		received := 0
+		chunkStart := maxFetchedId
+		returned := make(map[CommentId]bool)
+		r := fetchCommentData("body", maxFetchedId, func(d *xml.Decoder, parent string, start *xml.StartElement) *Report {
+			if parent != "comments" {
+				if start.Name.Local == "nextid" {
+					return decodeChunkElement("body", d, start, &nextId)
+				}
+				return decodeChunkElement("body", d, start, &struct{}{})
+			}
+			var c LJComment
+			if r := decodeChunkElement("body", d, start, &c); r != nil {
+				return r
+			}
+			received++
 			var record = CommentRecord{
 				Id:       c.Id,
 				ParentId: c.ParentId,
@@ -1354,6 +3099,13 @@ func dumpJournalComments(jcx *journalContext) *Report {
 				Body:     c.Body,
 				State:    c.State,
 			}
+			for _, prop := range c.Props {
+				if prop.Name == "edit_time" {
+					record.EditTime = strings.TrimSpace(prop.Value)
+				} else if jcx.config.storePosterProps && posterPropNames[prop.Name] {
+					record.PosterProps = append(record.PosterProps, CommentProp{Name: prop.Name, Value: prop.Value})
+				}
+			}
 			if record.State == "" {
 				if commentMeta, present := newComments[c.Id]; present {
 					record.State = commentMeta.state
@@ -1368,94 +3120,162 @@ func dumpJournalComments(jcx *journalContext) *Report {
 					record.User = user
 				}
 			}
+			returned[c.Id] = true
 			if maxFetchedId < c.Id {
 				maxFetchedId = c.Id
 			}
+			if jcx.isSkippedBySecurity(c.JItemId) {
+				return nil
+			}
+			if c.Id <= maxStoredCommentId && !changedComments[c.Id] {
+				// A body refetched with a changed one
+				return nil
+			}
 
-			commentFilePath := filepath.Join(jcx.dir, fmt.Sprintf("C-%d", c.JItemId))
-			olddata, err := ioutil.ReadFile(commentFilePath)
-
-			var stored CommentFile
-			if err != nil {
-				if !os.IsNotExist(err) {
-					return WrapErr(err, "error while reading old comments from %s", commentFilePath)
-				}
-			} else {
-				err = xml.Unmarshal(olddata, &stored)
-				if err != nil {
-					return WrapErr(err, "failed to parse old comments from %s", commentFilePath)
-				}
+			edited, r := batch.store(c.JItemId, record, changedComments[c.Id])
+			if r != nil {
+				return r
 			}
-			foundDup := false
-			shouldStore := true
-			for i := range stored.Comments {
-				if stored.Comments[i].Id == record.Id {
-					if stored.Comments[i] == record {
-						log("comment id %d was already downloaded in %s",
-							record.Id, commentFilePath)
-						shouldStore = false
-					} else {
-						log("Warning: downloaded duplicate comment id %d with different content in %s",
-							record.Id, commentFilePath)
-						stored.Comments[i] = record
-					}
-					foundDup = true
-					break
-				}
+			if edited {
+				editedComments++
 			}
-			if !foundDup {
-				stored.Comments = append(stored.Comments, record)
+			if record.EditTime != jcx.db.commentEditTimes[c.Id] {
+				jcx.db.commentEditTimes[c.Id] = record.EditTime
+				if record.EditTime == "" {
+					delete(jcx.db.commentEditTimes, c.Id)
+				}
+				jcx.shouldWriteDB = true
 			}
-			if shouldStore {
-				b := bytes.NewBufferString(xml.Header)
-				enc := xml.NewEncoder(b)
+			return nil
+		})
+		if r != nil {
+			return r
+		}
+		if maxFetchedId, r = nextCommentPage("body", chunkStart, maxFetchedId, received == 0, nextId); r != nil {
+			return r
+		}
 
-				enc.Indent("", " ")
-				if err := enc.Encode(&stored); err != nil {
-					panic(err)
-				}
-				b.WriteByte('\n')
-				if err = writeFileTempRename(commentFilePath, b.Bytes()); err != nil {
-					return WrapErr(err, "")
-				}
-				jcx.newComments++
+		// New comments from the meta data that the chunk range covers
+		// but the server did not return
+		var skipped sortIds
+		for id := range newComments {
+			if id > chunkStart && id <= maxFetchedId && id > maxStoredCommentId && !returned[id] {
+				skipped = append(skipped, int64(id))
+			}
+		}
+		if len(skipped) != 0 {
+			sort.Sort(skipped)
+			ids := make([]CommentId, len(skipped))
+			for i, id := range skipped {
+				ids[i] = CommentId(id)
+			}
+			jcx.config.warn("server skipped bodies of %d comments of %s with ids %s, run verify to queue their entries",
+				len(ids), jcx.name, formatIdRanges(ids))
+		}
+		if maxFetchedId < maxStoredCommentId {
+			// Skip to the next changed comment or to the new ones
+			next := nextChangedComment(changedComments, maxFetchedId)
+			if next < 0 || next > maxStoredCommentId {
+				maxFetchedId = maxStoredCommentId
+			} else {
+				maxFetchedId = next - 1
 			}
 		}
 		if maxFetchedId >= newMaxId {
-			break
+			maxFetchedId = newMaxId
 		}
-	}
-
-	if len(newComments) != 0 || len(newCommentUsers) != 0 {
-		// We succsefully downloaded new comments, update the meta now
-		for commentId, commentMeta := range newComments {
-			jcx.db.commentMap[commentId] = commentMeta
+		unflushed = true
+		if maxFetchedId >= newMaxId || received == 0 {
+			if r := flushBatch(); r != nil {
+				return r
+			}
+			break
 		}
-		for userId, user := range newCommentUsers {
-			jcx.db.userMap[userId] = user
+		if batch.full() {
+			if r := flushBatch(); r != nil {
+				return r
+			}
 		}
+	}
+	if editedComments != 0 {
+		log("%d archived comments changed on the server", editedComments)
+	}
+	if len(jcx.db.commentRefetch) != 0 {
+		// verify queues the entries again if comments are still missing
+		jcx.db.commentRefetch = nil
 		jcx.shouldWriteDB = true
+		if r := jcx.commitPendingWrites(); r != nil {
+			return r
+		}
 	}
 	return nil
 }
 
+// Dump the journal until everything is fetched or the time slice is over.
+// In the latter case jcx.suspended is set and the function should be
+// called again to continue.
 func dumpJournal(jcx *journalContext) *Report {
-	if r := readJournalDB(jcx); r != nil {
-		return r
-	}
+	if !jcx.dbLoaded {
+		if r := recoverPendingWrites(jcx.dir); r != nil {
+			return r
+		}
+		jcx.convertPythonFiles = true
+		if r := readJournalDB(jcx); r != nil {
+			return r
+		}
+		if r := checkJournalRename(jcx); r != nil {
+			return r
+		}
 
-	if err := os.MkdirAll(jcx.dir, 0777); err != nil {
-		return WrapErr(err, "failed to create directory for journal %s", jcx.dir)
+		if err := mkdirArchive(jcx.dir); err != nil {
+			return WrapErr(err, "failed to create directory for journal %s", jcx.dir)
+		}
+		index, r := loadEntriesIndex(jcx.dir)
+		if r != nil {
+			return r
+		}
+		jcx.index = index
+		jcx.dbLoaded = true
+		if jcx.config.journalLayout != "" && jcx.config.journalLayout != jcx.db.layout {
+			log("Journal %s keeps its %s layout, run migrate-layout to change it to %s",
+				jcx.name, jcx.db.layout, jcx.config.journalLayout)
+		}
 	}
+	jcx.suspended = false
+	started := time.Now()
+	defer func() { jcx.fetchTime += time.Since(started) }()
 
-	r := dumpJournalPosts(jcx)
-	if r == nil {
+	var r *Report
+	if !jcx.postsDone && jcx.caps.entries {
+		if jcx.session.auth == nil {
+			r = dumpPublicJournalPosts(jcx)
+		} else {
+			r = dumpJournalPosts(jcx)
+		}
+	}
+	if r == nil && !jcx.suspended && jcx.session.auth != nil && jcx.caps.comments {
 		r = dumpJournalComments(jcx)
 	}
-	if jcx.shouldWriteDB {
-		r = CombineReports(r, writeJournalDB(jcx))
+	if r == nil {
+		r = jcx.flushPendingWrites()
 	}
 	if r == nil {
+		r = writeCommentItems(jcx)
+	}
+	if r != nil {
+		// Drop the step that failed and the rest of the batch so the
+		// next attempt starts from the files on disk
+		jcx.pending = pendingWrites{}
+		jcx.dbLoaded = false
+		jcx.postsDone = false
+	}
+	if r == nil && jcx.suspended && (shutdownRequested() || jcx.config.runtimeExceeded()) {
+		log("Stopped %s, %d new entries and %d new comments so far",
+			jcx.name, jcx.newEntries, jcx.newComments)
+	} else if r == nil && jcx.suspended {
+		log("Time slice for %s is over, %d new entries and %d new comments so far",
+			jcx.name, jcx.newEntries, jcx.newComments)
+	} else if r == nil {
 		if jcx.origDbLastSync != "" {
 			log("%d new entries, %d new comments (since %s)", jcx.newEntries, jcx.newComments, jcx.origDbLastSync)
 		} else {
@@ -1465,12 +3285,19 @@ func dumpJournal(jcx *journalContext) *Report {
 	return r
 }
 
-func mainImpl() *Report {
-	config, r := loadConfig()
-	if r != nil {
-		return r
+func runDump(config *Config) *Report {
+	startShutdownHandling()
+	rr := &runReport{started: time.Now(), firstLogRecord: config.errorLog.count()}
+	r := dumpAll(config, rr)
+	r = CombineReports(r, writeRunReport(config, rr, r))
+	if r == nil && config.postHook != "" {
+		r = runPostHook(config, rr)
 	}
+	return r
+}
 
+func dumpAll(config *Config, rr *runReport) *Report {
+	started := time.Now()
 	accountData, r := readAccountData(config)
 	if r != nil {
 		return r
@@ -1485,18 +3312,185 @@ func mainImpl() *Report {
 		return r
 	}
 
+	if r := dumpFriends(session); r != nil {
+		return r
+	}
+
+	if config.archiveStyle {
+		if r := dumpJournalStyle(session); r != nil {
+			return r
+		}
+	}
+
+	if config.archiveInbox {
+		if r := dumpInbox(session); r != nil {
+			return r
+		}
+	}
+	rr.addPhase("account data", started)
+
+	if config.allCommunities {
+		if r := addMaintainedCommunities(session); r != nil {
+			return r
+		}
+		if r := checkJournalDirCollisions(config); r != nil {
+			return r
+		}
+	}
+	if r := recordJournalDirNames(config); r != nil {
+		return r
+	}
+
+	// Give each journal a time slice in round-robin order so a journal
+	// with a huge backlog does not prevent archiving of others.
+	journals := make([]*journalContext, 0, len(config.journals))
 	for _, journal := range config.journals {
-		if r := dumpJournal(newJournalContext(session, journal)); r != nil {
+		jcx := newJournalContext(session, journal)
+		if r := jcx.probeCapabilities(); r != nil {
+			return r
+		}
+		journals = append(journals, jcx)
+	}
+	rr.journals = journals
+	pending := journals
+	for len(pending) != 0 {
+		var unfinished []*journalContext
+		for _, jcx := range pending {
+			jcx.sliceDeadline = time.Time{}
+			if len(pending) > 1 && config.journalTimeSlice != 0 {
+				jcx.sliceDeadline = time.Now().Add(config.journalTimeSlice)
+			}
+			if r := dumpJournal(jcx); r != nil {
+				return r
+			}
+			if shutdownRequested() {
+				return interruptedReport()
+			}
+			if config.runtimeExceeded() {
+				return config.runtimeExceededReport()
+			}
+			if jcx.suspended {
+				unfinished = append(unfinished, jcx)
+			}
+		}
+		pending = unfinished
+	}
+
+	for _, jcx := range journals {
+		rr.phases = append(rr.phases, runPhase{"fetch " + jcx.name, jcx.fetchTime})
+	}
+
+	if config.archiveCommunityInfo || config.archiveModerationQueue {
+		started := time.Now()
+		r := dumpMaintainedCommunityInfo(session, journals)
+		rr.addPhase("communities", started)
+		if r != nil {
+			return r
+		}
+	}
+
+	if len(config.journals) > 1 {
+		started := time.Now()
+		count, r := updateCrossposts(config)
+		rr.addPhase("duplicates", started)
+		if r != nil {
+			return r
+		}
+		if count != 0 {
+			log("%d entries are crossposts of entries in other journals", count)
+		}
+	}
+
+	for _, jcx := range journals {
+		if r := updateJournalCollections(config, jcx.name, jcx.dir); r != nil {
 			return r
 		}
+		if config.downloadMedia {
+			started := time.Now()
+			r := dumpJournalMedia(config, jcx.name, jcx.dir)
+			rr.addPhase("media "+jcx.name, started)
+			if r != nil {
+				return r
+			}
+		}
+		if config.archiveSnapshots {
+			started := time.Now()
+			r := dumpJournalSnapshots(jcx)
+			rr.addPhase("snapshots "+jcx.name, started)
+			if r != nil {
+				return r
+			}
+		}
 	}
 	return nil
 }
 
+func mainImpl() *Report {
+	config, r := loadConfig()
+	if r != nil {
+		return r
+	}
+	config.errorLog.addSecret(config.password)
+	config.errorLog.addSecret(config.username)
+	for _, journal := range config.journals {
+		config.errorLog.addSecret(journal)
+	}
+	config.errorLog.addSecret(config.restorePassword)
+	config.errorLog.addSecret(config.apiKey)
+	config.errorLog.addSecret(config.sessionCookie)
+	if config.webdav != nil {
+		config.errorLog.addSecret(config.webdav.password)
+	}
+	if config.oauth != nil {
+		config.errorLog.addSecret(config.oauth.accessToken)
+		config.errorLog.addSecret(config.oauth.refreshToken)
+		config.errorLog.addSecret(config.oauth.clientSecret)
+	}
+	if config.tumblr != nil {
+		config.errorLog.addSecret(config.tumblr.consumerSecret)
+		config.errorLog.addSecret(config.tumblr.token)
+		config.errorLog.addSecret(config.tumblr.tokenSecret)
+	}
+	if config.bluesky != nil {
+		config.errorLog.addSecret(config.bluesky.appPassword)
+	}
+
+	archiveFileMode, archiveDirMode = config.fileMode, config.dirMode
+	if config.needsDumpLock() {
+		lockFile, r := acquireDumpLock(config)
+		if r != nil {
+			return r
+		}
+		defer lockFile.Close()
+	}
+
+	if config.pprof != "" {
+		stopProfiling, r := startProfiling(config.pprof)
+		if r != nil {
+			return r
+		}
+		defer func() {
+			if r := stopProfiling(); r != nil {
+				config.warn("%s", r.AsText())
+			}
+		}()
+	}
+
+	r = config.command.run(config)
+	if r != nil {
+		config.errorLog.record("report", "%s", r.AsText())
+
+		// The error log of the run is needed for the category
+		r.withCategory(r.errorCategory(config.errorLog))
+	}
+	return CombineReports(r, flushErrorLog(config))
+}
+
 func main() {
 
 	if r := mainImpl(); r != nil {
 		fmt.Fprintf(os.Stderr, "%s", r.AsText())
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "ERROR CATEGORY: %s\n", r.errorCategory(nil))
+		os.Exit(r.exitCode())
 	}
 }
@@ -27,3 +27,43 @@ func Test_convertPictureKeywordToFilename(t *testing.T) {
 
 	
 }
+
+func Test_encodeLJStructRoundTrip(t *testing.T) {
+	values := []string{
+		"",
+		"plain text\twith\nbreaks",
+		"windows\r\nline ends",
+		"<b>markup</b> & entities &amp; ]]> <![CDATA[",
+		"\x00bell\x07 and\x1b[0m escape",
+		"русский\x0cтекст",
+		"noncharacter \uFFFE here",
+		"良い一日を \U0001F600",
+	}
+	for _, value := range values {
+		event := map[string]interface{}{
+			"itemid":  int64(1),
+			"subject": value,
+			"event":   value,
+			"props":   map[string]interface{}{"current_mood": value},
+		}
+		data, fixes, r := encodeLJStruct("event", event)
+		if r != nil {
+			t.Fatal(r.AsText())
+		}
+		if err := checkWellFormedXml(data); err != nil {
+			t.Errorf("Encoded %q is not valid XML - %s", value, err.Error())
+			continue
+		}
+		if isXmlText(value) == (fixes.base64Values != 0) {
+			t.Errorf("Unexpected %d base64 values for %q", fixes.base64Values, value)
+		}
+		entry, err := parseArchivedEntry(data)
+		if err != nil {
+			t.Errorf("Failed to parse encoded %q - %s", value, err.Error())
+			continue
+		}
+		if entry.subject != value || entry.event != value || entry.props["current_mood"] != value {
+			t.Errorf("Expected %q after the round trip, got %q %q %q", value, entry.subject, entry.event, entry.props["current_mood"])
+		}
+	}
+}
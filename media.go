@@ -0,0 +1,309 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"linedb"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Images referenced by entries are archived with -download-media into the
+// media subdirectory of the journal. media.linedb maps the image URL to the
+// file name. Failed downloads are recorded and not retried. The ETag and
+// Last-Modified headers of downloaded images are kept so -refresh-media can
+// revalidate them with conditional requests and download only changed
+// images.
+const mediaDirName = "media"
+const mediaDBFileName = "media.linedb"
+
+// Limit on a single downloaded file
+const maxMediaFileSize = 50 << 20
+
+type mediaItem struct {
+	url         string
+	file        string
+	contentType string
+	size        int64
+
+	// Error message for failed downloads
+	failure string
+
+	validators httpValidators
+}
+
+// Validators of a downloaded file for conditional requests
+type httpValidators struct {
+	etag         string
+	lastModified string
+}
+
+func (v httpValidators) empty() bool {
+	return v.etag == "" && v.lastModified == ""
+}
+
+func responseValidators(resp *http.Response) httpValidators {
+	return httpValidators{resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")}
+}
+
+// Get the URL with the validators of the archived copy so the server can
+// answer with 304 Not Modified when the file did not change
+func conditionalGet(client *http.Client, url string, v httpValidators) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if v.etag != "" {
+		req.Header.Set("If-None-Match", v.etag)
+	}
+	if v.lastModified != "" {
+		req.Header.Set("If-Modified-Since", v.lastModified)
+	}
+	return client.Do(req)
+}
+
+func readMediaIndex(dir string) (map[string]*mediaItem, *Report) {
+	index := make(map[string]*mediaItem)
+	dbpath := filepath.Join(dir, mediaDBFileName)
+	dbdata, err := archiveStore.ReadFile(dbpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return index, nil
+		}
+		return nil, WrapErr(err, "")
+	}
+	d := newLinedbDecoder(dbdata)
+	for d.NextItem() {
+		if d.ItemKind == linedb.TableItem {
+			for d.NextRow() {
+				switch d.ItemName {
+				case "media":
+					item := &mediaItem{d.GetString(), d.GetString(), d.GetString(), d.GetInt64(), d.GetString(), httpValidators{}}
+					index[item.url] = item
+				case "validators":
+					url, validators := d.GetString(), httpValidators{d.GetString(), d.GetString()}
+					if item := index[url]; item != nil {
+						item.validators = validators
+					}
+				}
+			}
+		}
+	}
+	if err := d.GetError(); err != nil {
+		return nil, WrapErr(err, "error while parsing media index %s as linedb", dbpath)
+	}
+	return index, nil
+}
+
+func writeMediaIndex(dir string, index map[string]*mediaItem) *Report {
+	urls := make([]string, 0, len(index))
+	for url := range index {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	e := linedb.NewByteEncoder()
+	e.Comment("url file content-type size failure")
+	e.Table("media")
+	for _, url := range urls {
+		item := index[url]
+		e.AddString(item.url).AddString(item.file).AddString(item.contentType).AddInt64(item.size).AddString(item.failure).EndRow()
+	}
+	e.EndTable()
+	e.EmptyLine()
+	e.Comment("url etag last-modified")
+	e.Table("validators")
+	for _, url := range urls {
+		if v := index[url].validators; !v.empty() {
+			e.AddString(url).AddString(v.etag).AddString(v.lastModified).EndRow()
+		}
+	}
+	e.EndTable()
+	dbpath := filepath.Join(dir, mediaDBFileName)
+	if err := writeFileTempRename(dbpath, e.GetBytes()); err != nil {
+		return WrapErr(err, "failed to write media index %s", dbpath)
+	}
+	return nil
+}
+
+var imgSrcPattern = regexp.MustCompile(`(?i)<img\b[^>]*?\ssrc\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+
+// Get absolute http(s) URLs of images in the HTML in the document order
+// without duplicates.
+func htmlImageUrls(text string) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, m := range imgSrcPattern.FindAllStringSubmatch(text, -1) {
+		url := html.UnescapeString(strings.TrimSpace(m[1] + m[2] + m[3]))
+		lower := strings.ToLower(url)
+		if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+			continue
+		}
+		if !seen[url] {
+			seen[url] = true
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// The first extension from mime.ExtensionsByType is not always the common
+// one, for example .jfif for JPEG.
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+func mediaFileName(url string, contentType string) string {
+	sum := sha1.Sum([]byte(url))
+	extension := imageExtensions[contentType]
+	if extension == "" {
+		extension = ".bin"
+		if extensions, err := mime.ExtensionsByType(contentType); err == nil && len(extensions) != 0 {
+			extension = extensions[0]
+		}
+	}
+	return hex.EncodeToString(sum[:])[:20] + extension
+}
+
+// Download the image at url. When old is an archived copy, ask the server
+// to send the image only if it changed and return old when it did not.
+func downloadMediaFile(client *http.Client, dir string, url string, old *mediaItem) *mediaItem {
+	item := &mediaItem{url: url}
+	var validators httpValidators
+	if old != nil && old.file != "" {
+		validators = old.validators
+	}
+	resp, err := conditionalGet(client, url, validators)
+	if err != nil {
+		item.failure = err.Error()
+		return item
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && !validators.empty() {
+		return old
+	}
+	if resp.StatusCode != http.StatusOK {
+		item.failure = resp.Status
+		return item
+	}
+	item.contentType, _, _ = mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(item.contentType, "image/") {
+		item.failure = fmt.Sprintf("not an image, content type %q", item.contentType)
+		return item
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxMediaFileSize+1))
+	if err != nil {
+		item.failure = err.Error()
+		return item
+	}
+	if len(data) > maxMediaFileSize {
+		item.failure = "file is too big"
+		return item
+	}
+	item.file = mediaFileName(url, item.contentType)
+	item.size = int64(len(data))
+	item.validators = responseValidators(resp)
+	if err := writeFileTempRename(filepath.Join(dir, mediaDirName, item.file), data); err != nil {
+		item.file, item.size, item.failure = "", 0, err.Error()
+	}
+	return item
+}
+
+// Download images of all archived entries that are not yet in the media
+// index. With -refresh-media also revalidate the archived images and retry
+// the failed ones.
+func dumpJournalMedia(config *Config, journal string, dir string) *Report {
+	entries, r := readJournalEntries(dir)
+	if r != nil {
+		return r
+	}
+	index, r := readMediaIndex(dir)
+	if r != nil {
+		return r
+	}
+	var missing []string
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		for _, url := range htmlImageUrls(entry.event) {
+			if index[url] == nil && !seen[url] {
+				missing = append(missing, url)
+				seen[url] = true
+			}
+		}
+	}
+	refresh := 0
+	if config.refreshMedia {
+		var urls []string
+		for url := range index {
+			urls = append(urls, url)
+		}
+		sort.Strings(urls)
+		missing = append(missing, urls...)
+		refresh = len(urls)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if refresh != 0 {
+		log("Fetching %d new and revalidating %d archived images for: %s", len(missing)-refresh, refresh, journal)
+	} else {
+		log("Fetching %d images for: %s", len(missing), journal)
+	}
+	if err := mkdirArchive(filepath.Join(dir, mediaDirName)); err != nil {
+		return WrapErr(err, "failed to create media directory for %s", journal)
+	}
+	failed, updated := 0, 0
+	client := config.mediaHttpClient()
+	var stopped *Report
+	for i, url := range missing {
+		if shutdownRequested() {
+			stopped = interruptedReport()
+		} else if config.runtimeExceeded() {
+			stopped = config.runtimeExceededReport()
+		}
+		if stopped != nil {
+			break
+		}
+		old := index[url]
+		item := downloadMediaFile(client, dir, url, old)
+		if item.failure != "" {
+			failed++
+			if old != nil && old.file != "" {
+				// Keep the archived copy when the image is gone
+				config.warn("failed to revalidate %s, keeping %s - %s", url, old.file, item.failure)
+				continue
+			}
+		} else if old != nil && old.file != "" && item != old {
+			updated++
+		}
+		index[url] = item
+
+		// Save the progress from time to time for huge journals
+		if (i+1)%50 == 0 {
+			if r := writeMediaIndex(dir, index); r != nil {
+				return r
+			}
+		}
+	}
+	if failed != 0 {
+		config.warn("%d of %d images for %s could not be downloaded, see %s", failed, len(missing), journal, mediaDBFileName)
+	}
+	if updated != 0 {
+		log("Replaced %d archived images of %s with new downloads", updated, journal)
+	}
+	if r := writeMediaIndex(dir, index); r != nil {
+		return r
+	}
+	return stopped
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_htmlImageUrls(t *testing.T) {
+	text := `<p><img src="http://a.com/1.jpg"> <IMG alt="x" SRC='https://b.com/2.png?a=1&amp;b=2'>
+<img src=http://c.com/3.gif/> <img src="/local.jpg"> <img data-src="http://d.com/4.jpg"> <img src="http://a.com/1.jpg">`
+	expected := []string{"http://a.com/1.jpg", "https://b.com/2.png?a=1&b=2", "http://c.com/3.gif/"}
+	if urls := htmlImageUrls(text); !reflect.DeepEqual(urls, expected) {
+		t.Errorf("Expected %q, got %q", expected, urls)
+	}
+}
+
+func Test_downloadMediaFileConditional(t *testing.T) {
+	content, etag := "v1", `"1"`
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		if req.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, mediaDirName), 0777); err != nil {
+		t.Fatal(err)
+	}
+	url := server.URL + "/pic.png"
+	item := downloadMediaFile(http.DefaultClient, dir, url, nil)
+	if item.failure != "" || item.validators.etag != etag {
+		t.Fatalf("Unexpected item %+v", item)
+	}
+	index := map[string]*mediaItem{url: item}
+	if r := writeMediaIndex(dir, index); r != nil {
+		t.Fatal(r.AsText())
+	}
+	index, r := readMediaIndex(dir)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if index[url] == nil || *index[url] != *item {
+		t.Fatalf("Expected %+v after reading the index, got %+v", item, index[url])
+	}
+
+	if again := downloadMediaFile(http.DefaultClient, dir, url, index[url]); again != index[url] {
+		t.Errorf("Expected unchanged item for 304, got %+v", again)
+	}
+
+	content, etag = "v2", `"2"`
+	updated := downloadMediaFile(http.DefaultClient, dir, url, index[url])
+	if updated == index[url] || updated.validators.etag != etag {
+		t.Errorf("Expected new item for changed image, got %+v", updated)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, mediaDirName, updated.file)); string(data) != "v2" {
+		t.Errorf("Expected updated file, got %q", data)
+	}
+	if requests != 3 {
+		t.Errorf("Expected 3 requests, got %d", requests)
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"fmt"
+	"linedb"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The migrate command upgrades archived journals in place when the storage
+// changes: it converts the state files of ljdump.py, rewrites journal.linedb
+// in the current schema and, with -layout, moves the L-* and C-* files into
+// that layout. With -dry-run it only prints what it would do.
+//
+// Before changing a journal the command appends the planned changes to
+// migrate-manifest.linedb in the journal directory and copies the files it
+// rewrites into the migrate-backup subdirectory. migrate -rollback undoes
+// the recorded changes in reverse order and removes the manifest. An
+// interrupted migration can be finished by running the command again or
+// rolled back. Later dumps rewrite journal.linedb, so roll back before the
+// next dump.
+
+const migrateManifestFileName = "migrate-manifest.linedb"
+const migrateBackupDirName = "migrate-backup"
+
+// Report progress after this number of moved entries
+const migrateProgressInterval = 1000
+
+// Kinds of recorded changes
+const (
+	// Move of an archived file, path and other are relative to the journal
+	// directory
+	migrateMove = "move"
+
+	// Move of a local ljdump.py file into the legacy directory
+	migrateLegacyMove = "legacy"
+
+	// Rewrite of the file at path with other as the backup copy, empty
+	// when the file did not exist
+	migrateRewrite = "rewrite"
+)
+
+type migrateOp struct {
+	kind  string
+	path  string
+	other string
+}
+
+type journalMigration struct {
+	jcx *journalContext
+
+	// Files of ljdump.py to convert or nil
+	python *pythonConversion
+
+	// Schema version of journal.linedb or -1 when it does not exist
+	schemaVersion int
+
+	oldLayout string
+	newLayout string
+
+	// Moves of the entry and comment files into the new layout
+	moves []migrateOp
+
+	// Entries with their directories after the moves
+	entries []*archivedEntry
+}
+
+func (m *journalMigration) describe() []string {
+	var steps []string
+	if m.python != nil {
+		steps = append(steps, "convert ljdump.py files "+strings.Join(m.python.files, ", "))
+	}
+	if m.schemaVersion >= 0 && m.schemaVersion < journalDBSchemaVersion {
+		steps = append(steps, fmt.Sprintf(
+			"upgrade %s from schema version %d to %d", journalDBFileName, m.schemaVersion, journalDBSchemaVersion,
+		))
+	}
+	if len(m.moves) != 0 {
+		steps = append(steps, fmt.Sprintf("move %d files from %s to %s layout", len(m.moves), m.oldLayout, m.newLayout))
+	} else if m.oldLayout != m.newLayout && (m.schemaVersion >= 0 || m.python != nil) {
+		// New entries must go into the new layout even with no files to
+		// move yet
+		steps = append(steps, fmt.Sprintf("record %s layout instead of %s, no files to move", m.newLayout, m.oldLayout))
+	}
+	return steps
+}
+
+func slashRel(dir, path string) string {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// Find what the migration of the journal would change without changing
+// anything
+func planJournalMigration(config *Config, journal string) (*journalMigration, *Report) {
+	jcx := &journalContext{config: config, name: journal, dir: config.journalDir(journal)}
+	m := &journalMigration{jcx: jcx, schemaVersion: -1, oldLayout: flatLayout}
+	dbpath := filepath.Join(jcx.dir, journalDBFileName)
+	dbdata, err := archiveStore.ReadFile(dbpath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, WrapErr(err, "")
+	}
+	if len(dbdata) == 0 {
+		scratch := &journalContext{config: config, name: journal, dir: jcx.dir}
+		conversion, err := readPythonJournalFiles(scratch)
+		if err != nil {
+			return nil, WrapErr(err, "error while reading old python-generated DB files for journal %s", journal)
+		}
+		if len(conversion.files) != 0 {
+			m.python = conversion
+		}
+	} else {
+		var db journalDB
+		if err := parseJournalDB(dbdata, &db); err != nil {
+			return nil, WrapErr(err, "error while parsing journal db file %s as linedb, run dump with -recover first", dbpath)
+		}
+		m.schemaVersion = db.schemaVersion
+		m.oldLayout = db.layout
+	}
+
+	m.newLayout = m.oldLayout
+	if config.journalLayout != "" {
+		m.newLayout = config.journalLayout
+	}
+	entries, r := readJournalEntries(jcx.dir)
+	if r != nil {
+		return nil, r
+	}
+	withEntry := make(map[int64]bool, len(entries))
+	for _, entry := range entries {
+		withEntry[entry.itemId] = true
+		toDir := filepath.Join(jcx.dir, layoutSubdir(m.newLayout, entry.eventTime))
+		if toDir != entry.dir {
+			for _, name := range []string{fmt.Sprintf("L-%d", entry.itemId), fmt.Sprintf("C-%d", entry.itemId)} {
+				if _, err := archiveStore.Stat(filepath.Join(entry.dir, name)); err == nil {
+					m.moves = append(m.moves, migrateOp{
+						migrateMove, slashRel(jcx.dir, filepath.Join(entry.dir, name)), slashRel(jcx.dir, filepath.Join(toDir, name)),
+					})
+				}
+			}
+			entry.dir = toDir
+		}
+	}
+	m.entries = entries
+
+	// Comments without archived entries stay in the journal directory
+	files, err := listDumpFiles(jcx.dir)
+	if err != nil {
+		return nil, WrapErr(err, "")
+	}
+	for _, file := range files {
+		name := filepath.Base(file)
+		if strings.HasPrefix(name, "C-") && filepath.Dir(file) != "." {
+			var itemId int64
+			fmt.Sscanf(name, "C-%d", &itemId)
+			if !withEntry[itemId] {
+				m.moves = append(m.moves, migrateOp{migrateMove, filepath.ToSlash(file), name})
+			}
+		}
+	}
+	return m, nil
+}
+
+func readMigrateManifest(dir string) ([]migrateOp, *Report) {
+	path := filepath.Join(dir, migrateManifestFileName)
+	data, err := archiveStore.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, WrapErr(err, "")
+	}
+	var ops []migrateOp
+	d := newLinedbDecoder(data)
+	for d.NextItem() {
+		if d.ItemKind == linedb.TableItem {
+			for d.NextRow() {
+				if d.ItemName == "ops" {
+					ops = append(ops, migrateOp{d.GetString(), d.GetString(), d.GetString()})
+				}
+			}
+		}
+	}
+	if err := d.GetError(); err != nil {
+		return nil, WrapErr(err, "error while parsing migration manifest %s as linedb", path)
+	}
+	return ops, nil
+}
+
+func writeMigrateManifest(dir string, ops []migrateOp) *Report {
+	e := linedb.NewByteEncoder()
+	e.Comment("kind path other")
+	e.Table("ops")
+	for _, op := range ops {
+		e.AddString(op.kind).AddString(op.path).AddString(op.other).EndRow()
+	}
+	e.EndTable()
+	path := filepath.Join(dir, migrateManifestFileName)
+	if err := writeFileTempRename(path, e.GetBytes()); err != nil {
+		return WrapErr(err, "failed to write migration manifest %s", path)
+	}
+	return nil
+}
+
+// Copy the file into the backup directory and record its rewrite
+func backupMigratedFile(dir string, name string, ops []migrateOp) ([]migrateOp, *Report) {
+	data, err := archiveStore.ReadFile(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return append(ops, migrateOp{migrateRewrite, name, ""}), nil
+	} else if err != nil {
+		return nil, WrapErr(err, "")
+	}
+	backupDir := filepath.Join(dir, migrateBackupDirName)
+	if err := mkdirArchive(backupDir); err != nil {
+		return nil, WrapErr(err, "")
+	}
+	backup := fmt.Sprintf("%s/%d-%s", migrateBackupDirName, len(ops), name)
+	if err := writeFileTempRename(filepath.Join(dir, filepath.FromSlash(backup)), data); err != nil {
+		return nil, WrapErr(err, "failed to back up %s", name)
+	}
+	return append(ops, migrateOp{migrateRewrite, name, backup}), nil
+}
+
+func applyJournalMigration(m *journalMigration) *Report {
+	jcx := m.jcx
+	ops, r := readMigrateManifest(jcx.dir)
+	if r != nil {
+		return r
+	}
+	for _, name := range []string{journalDBFileName, entriesIndexFileName, commentItemsFileName} {
+		if ops, r = backupMigratedFile(jcx.dir, name, ops); r != nil {
+			return r
+		}
+	}
+	if m.python != nil {
+		for _, name := range m.python.files {
+			ops = append(ops, migrateOp{migrateLegacyMove, name, legacyDirName + "/" + name})
+		}
+	}
+	ops = append(ops, m.moves...)
+	if r := writeMigrateManifest(jcx.dir, ops); r != nil {
+		return r
+	}
+
+	// Converts the files of ljdump.py
+	jcx.convertPythonFiles = true
+	if r := readJournalDB(jcx); r != nil {
+		return r
+	}
+	for i, op := range m.moves {
+		if shutdownRequested() {
+			return interruptedReport()
+		}
+		from := filepath.Join(jcx.dir, filepath.FromSlash(op.path))
+		to := filepath.Join(jcx.dir, filepath.FromSlash(op.other))
+		err := mkdirArchive(filepath.Dir(to))
+		if err == nil {
+			err = archiveStore.Rename(from, to)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return WrapErr(err, "failed to move %s, run the command again to finish or with -rollback to undo", op.path)
+		}
+		if (i+1)%migrateProgressInterval == 0 {
+			log("Moved %d of %d files of %s", i+1, len(m.moves), jcx.name)
+		}
+	}
+	removeEmptyShardDirs(jcx.dir)
+
+	index := newEntriesIndex()
+
+	// Keep the recorded crossposts as the entries keep their ids
+	if old, r := readEntriesIndex(jcx.dir); r != nil {
+		return r
+	} else if old != nil {
+		index.crossposts = old.crossposts
+	}
+	for _, entry := range m.entries {
+		comments, r := readEntryComments(entry.dir, entry.itemId)
+		if r != nil {
+			return r
+		}
+		index.setEntry(entry, entryRelPath(jcx.dir, entry))
+		index.rows[entry.itemId].comments = len(comments)
+	}
+	if r := writeEntriesIndex(jcx.dir, index); r != nil {
+		return r
+	}
+	jcx.db.layout = m.newLayout
+	jcx.db.schemaVersion = journalDBSchemaVersion
+	return writeJournalDB(jcx)
+}
+
+// Undo the changes recorded in the manifest of the journal
+func rollbackJournalMigration(config *Config, journal string) *Report {
+	dir := config.journalDir(journal)
+	ops, r := readMigrateManifest(dir)
+	if r != nil {
+		return r
+	}
+	if ops == nil {
+		log("No recorded migration of %s to roll back", journal)
+		return nil
+	}
+	for i := len(ops) - 1; i >= 0; i-- {
+		op := ops[i]
+		path := filepath.Join(dir, filepath.FromSlash(op.path))
+		other := filepath.Join(dir, filepath.FromSlash(op.other))
+		var err error
+		switch op.kind {
+		case migrateMove:
+			if _, err = archiveStore.Stat(other); err == nil {
+				if err = mkdirArchive(filepath.Dir(path)); err == nil {
+					err = archiveStore.Rename(other, path)
+				}
+			} else if os.IsNotExist(err) {
+				err = nil
+			}
+		case migrateLegacyMove:
+			if err = archiveStore.Rename(other, path); os.IsNotExist(err) {
+				err = nil
+			}
+		case migrateRewrite:
+			if op.other == "" {
+				if err = archiveStore.Remove(path); os.IsNotExist(err) {
+					err = nil
+				}
+			} else {
+				var data []byte
+				if data, err = archiveStore.ReadFile(other); err == nil {
+					err = writeFileTempRename(path, data)
+				}
+			}
+		default:
+			return ReportMsg("unknown change %s in %s", op.kind, filepath.Join(dir, migrateManifestFileName))
+		}
+		if err != nil {
+			return WrapErr(err, "failed to roll back %s of %s", op.kind, op.path)
+		}
+	}
+	removeEmptyShardDirs(dir)
+	archiveStore.Remove(filepath.Join(dir, legacyDirName))
+	backups, _ := archiveStore.ReadDir(filepath.Join(dir, migrateBackupDirName))
+	for _, info := range backups {
+		archiveStore.Remove(filepath.Join(dir, migrateBackupDirName, info.Name()))
+	}
+	archiveStore.Remove(filepath.Join(dir, migrateBackupDirName))
+	if err := archiveStore.Remove(filepath.Join(dir, migrateManifestFileName)); err != nil {
+		return WrapErr(err, "")
+	}
+	log("Rolled back %d recorded changes of %s", len(ops), journal)
+	return nil
+}
+
+func migrateJournal(config *Config, journal string) *Report {
+	dir := config.journalDir(journal)
+	if _, err := archiveStore.Stat(dir); os.IsNotExist(err) {
+		config.warn("journal %s has no archive directory %s", journal, dir)
+		return nil
+	}
+	if config.migrateRollback {
+		return rollbackJournalMigration(config, journal)
+	}
+	if !config.dryRun {
+		if r := recoverPendingWrites(dir); r != nil {
+			return r
+		}
+	}
+	m, r := planJournalMigration(config, journal)
+	if r != nil {
+		return r
+	}
+	steps := m.describe()
+	if len(steps) == 0 {
+		log("Journal %s is up to date", journal)
+		return nil
+	}
+	if config.dryRun {
+		for _, step := range steps {
+			log("Journal %s: would %s", journal, step)
+		}
+		return nil
+	}
+	log("Migrating %s: %s", journal, strings.Join(steps, ", "))
+	if r := applyJournalMigration(m); r != nil {
+		return r
+	}
+	if len(m.moves) != 0 {
+		log("Moved files of %s from %s to %s layout", journal, m.oldLayout, m.newLayout)
+	}
+	return nil
+}
+
+func runMigrate(config *Config) *Report {
+	startShutdownHandling()
+	for _, journal := range config.journals {
+		if r := migrateJournal(config, journal); r != nil {
+			return r
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_migrateRollback(t *testing.T) {
+	server := newFakeLJServer(t)
+	defer server.Close()
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	config := &Config{
+		server:         server.URL,
+		username:       "con",
+		password:       "password",
+		journals:       []string{"con"},
+		dumpDir:        dumpDir,
+		accountDataDir: filepath.Join(dumpDir, accountDataDirName),
+		journalAliases: make(map[string]string),
+	}
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	journalDir := filepath.Join(dumpDir, "con_")
+	dbBefore, err := ioutil.ReadFile(filepath.Join(journalDir, journalDBFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFiles := func(entryFile string) {
+		for _, name := range []string{entryFile, filepath.Join(filepath.Dir(entryFile), "C-1")} {
+			if _, err := os.Stat(filepath.Join(journalDir, name)); err != nil {
+				t.Errorf("Expected %s - %v", name, err)
+			}
+		}
+	}
+
+	config.journalLayout = yearMonthLayout
+	config.dryRun = true
+	if r := runMigrate(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	checkFiles("L-1")
+	if _, err := os.Stat(filepath.Join(journalDir, migrateManifestFileName)); !os.IsNotExist(err) {
+		t.Errorf("Expected no manifest after a dry run, got %v", err)
+	}
+
+	config.dryRun = false
+	if r := runMigrate(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	checkFiles("2020/01/L-1")
+	ops, r := readMigrateManifest(journalDir)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(ops) != 5 || ops[0].kind != migrateRewrite || ops[3].path != "L-1" || ops[3].other != "2020/01/L-1" {
+		t.Errorf("Unexpected manifest %+v", ops)
+	}
+
+	config.migrateRollback = true
+	if r := runMigrate(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	checkFiles("L-1")
+	for _, name := range []string{"2020", migrateManifestFileName, migrateBackupDirName} {
+		if _, err := os.Stat(filepath.Join(journalDir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected removed %s, got %v", name, err)
+		}
+	}
+	dbAfter, err := ioutil.ReadFile(filepath.Join(journalDir, journalDBFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dbAfter) != string(dbBefore) {
+		t.Errorf("Expected the journal DB from before the migration, got\n%s", dbAfter)
+	}
+}
+
+func Test_migrateLayoutOnly(t *testing.T) {
+	server := newFakeLJServer(t)
+	defer server.Close()
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	config := &Config{
+		server:         server.URL,
+		username:       "con",
+		password:       "password",
+		journals:       []string{"con"},
+		dumpDir:        dumpDir,
+		accountDataDir: filepath.Join(dumpDir, accountDataDirName),
+		journalAliases: make(map[string]string),
+	}
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	journalDir := filepath.Join(dumpDir, "con_")
+	for _, name := range []string{"L-1", "C-1"} {
+		if err := os.Remove(filepath.Join(journalDir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config.journalLayout = yearMonthLayout
+	m, r := planJournalMigration(config, "con")
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if steps := m.describe(); len(steps) != 1 || len(m.moves) != 0 {
+		t.Fatalf("Expected one layout step without moves, got %v", steps)
+	}
+	if r := runMigrate(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	jcx := &journalContext{config: config, name: "con", dir: journalDir}
+	if r := readJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if jcx.db.layout != yearMonthLayout {
+		t.Errorf("Expected the %s layout recorded, got %q", yearMonthLayout, jcx.db.layout)
+	}
+}
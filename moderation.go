@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"linedb"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Submissions to a moderated community are not entries until a moderator
+// approves them, so syncitems never lists them and rejected or forgotten
+// ones are lost. With -moderation-queue the dump fetches the page of each
+// submission in the queue of maintained communities and stores it as
+// moderation/<modid>.html in the journal directory. moderation.linedb
+// there lists the archived submissions with the poster. A submission is
+// archived once and kept after it leaves the queue.
+
+const moderationDBFileName = "moderation.linedb"
+const moderationDirName = "moderation"
+
+type moderatedSubmission struct {
+	modId   int64
+	poster  string
+	fetched string
+}
+
+func readModerationDB(dir string) ([]moderatedSubmission, *Report) {
+	dbpath := filepath.Join(dir, moderationDBFileName)
+	dbdata, err := archiveStore.ReadFile(dbpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, WrapErr(err, "")
+	}
+	var submissions []moderatedSubmission
+	d := newLinedbDecoder(dbdata)
+	for d.NextItem() {
+		if d.ItemKind == linedb.TableItem && d.ItemName == "submissions" {
+			for d.NextRow() {
+				submissions = append(submissions, moderatedSubmission{d.GetInt64(), d.GetString(), d.GetString()})
+			}
+		}
+	}
+	if err := d.GetError(); err != nil {
+		return nil, WrapErr(err, "failed to parse %s", dbpath)
+	}
+	return submissions, nil
+}
+
+func writeModerationDB(dir string, submissions []moderatedSubmission) *Report {
+	e := linedb.NewByteEncoder()
+	e.Comment("modid poster fetched")
+	e.Table("submissions")
+	for _, s := range submissions {
+		e.AddInt64(s.modId).AddString(s.poster).AddString(s.fetched).EndRow()
+	}
+	e.EndTable()
+	dbpath := filepath.Join(dir, moderationDBFileName)
+	if err := writeFileTempRename(dbpath, e.GetBytes()); err != nil {
+		return WrapErr(err, "")
+	}
+	return nil
+}
+
+func moderationPageUrl(config *Config, community string, modId int64) string {
+	path := authasPath(config, "/community/moderate.bml", community)
+	if modId != 0 {
+		path += fmt.Sprintf("&modid=%d", modId)
+	}
+	return path
+}
+
+// Take the first user on the page other than the community and the
+// maintainer as the poster
+func submissionPoster(config *Config, community string, content string) string {
+	for _, m := range communityUserPattern.FindAllStringSubmatch(content, -1) {
+		if m[1] != community && m[1] != config.username {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// Archive submissions in the moderation queue of the community that are
+// not archived yet. The caller checks that the user maintains it.
+func dumpModerationQueue(session *ljSession, community string, dir string) *Report {
+	submissions, r := readModerationDB(dir)
+	if r != nil {
+		return r
+	}
+	archived := make(map[int64]bool, len(submissions))
+	for _, s := range submissions {
+		archived[s.modId] = true
+	}
+	data, r := fetchStylePage(session, stylePage{"moderation", moderationPageUrl(session.config, community, 0)})
+	if r != nil {
+		session.config.warn("failed to get the moderation queue of community %s - %s", community, r.AsText())
+		return nil
+	}
+	queue := parseCommunityModIds(string(data))
+	pageDir := filepath.Join(dir, moderationDirName)
+	added := 0
+	for _, modId := range queue {
+		if archived[modId] {
+			continue
+		}
+		if shutdownRequested() {
+			return interruptedReport()
+		}
+		if added == 0 {
+			if err := mkdirArchive(pageDir); err != nil {
+				return WrapErr(err, "")
+			}
+		}
+		data, r := fetchStylePage(session, stylePage{"submission", moderationPageUrl(session.config, community, modId)})
+		if r != nil {
+			session.config.warn("failed to archive submission %d of community %s - %s", modId, community, r.AsText())
+			continue
+		}
+		path := filepath.Join(pageDir, fmt.Sprintf("%d.html", modId))
+		if err := writeFileTempRename(path, data); err != nil {
+			return WrapErr(err, "failed to write %s", path)
+		}
+		poster := submissionPoster(session.config, community, string(data))
+		submissions = append(submissions, moderatedSubmission{modId, poster, time.Now().UTC().Format("2006-01-02 15:04:05")})
+		if r := writeModerationDB(dir, submissions); r != nil {
+			return r
+		}
+		added++
+	}
+	log("Community %s has %d submissions in the moderation queue, %d newly archived", community, len(queue), added)
+	return nil
+}
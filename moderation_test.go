@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_dumpModerationQueue(t *testing.T) {
+	server := newFakeLJServer(t)
+	defer server.Close()
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	config := &Config{
+		server:         server.URL,
+		username:       "owner",
+		password:       "password",
+		journals:       []string{"con"},
+		dumpDir:        dumpDir,
+		accountDataDir: filepath.Join(dumpDir, accountDataDirName),
+		journalAliases: make(map[string]string),
+	}
+	session, r := openLJSession(config)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	journalDir := config.journalDir("con")
+	for run := 0; run < 2; run++ {
+		if r := dumpModerationQueue(session, "con", journalDir); r != nil {
+			t.Fatal(r.AsText())
+		}
+	}
+	submissions, r := readModerationDB(journalDir)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(submissions) != 1 || submissions[0].modId != 12 || submissions[0].poster != "bob" {
+		t.Errorf("Unexpected submissions %+v", submissions)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(journalDir, moderationDirName, "12.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "Pending text") {
+		t.Errorf("Unexpected submission page %s", data)
+	}
+}
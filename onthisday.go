@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// The onthisday command prints a digest of archived entries posted on the
+// given day of the year in earlier years, by default on today in
+// -time-zone or the local zone. The entries are found with the entries index without
+// reading every entry file. With -digest text, the default, the digest is
+// plain text, html gives a standalone page and email a MIME message with
+// both that can be piped to sendmail, for example from a daily cron job.
+// -max-security and -public-only limit the entries like with export.
+
+// Length of the entry text in the digest in characters
+const onThisDayTextLength = 300
+
+// Width of the plain text digest
+const onThisDayTextWidth = 72
+
+var onThisDayFormats = []string{"text", "html", "email"}
+
+type onThisDayItem struct {
+	Journal  string
+	Year     int
+	YearsAgo int
+	Date     string
+	Subject  string
+	Url      string
+
+	// Label of entries that are not public or empty
+	Level string
+	Text  string
+
+	eventTime string
+}
+
+type sortOnThisDayItems []onThisDayItem
+
+func (a sortOnThisDayItems) Len() int           { return len(a) }
+func (a sortOnThisDayItems) Less(i, j int) bool { return a[i].eventTime < a[j].eventTime }
+func (a sortOnThisDayItems) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+type onThisDayDigest struct {
+	Lang  string
+	Title string
+	Items []onThisDayItem
+}
+
+var onThisDayTemplate = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}"><head><meta charset="utf-8"><title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: auto; padding: 1em; }
+.meta { color: #555; font-size: small; }
+.security { color: #b00; }
+</style></head>
+<body><h1>{{.Title}}</h1>
+{{range .Items}}<h2>{{.Year}}, {{if eq .YearsAgo 1}}1 year{{else}}{{.YearsAgo}} years{{end}} ago</h2>
+<p class="meta">{{.Journal}}, {{.Date}}{{if .Level}} <span class="security">{{.Level}}</span>{{end}}</p>
+<p>{{if .Url}}<a href="{{.Url}}">{{.Subject}}</a>{{else}}{{.Subject}}{{end}}</p>
+{{if .Text}}<p>{{.Text}}</p>{{end}}
+{{else}}<p>No archived entries were posted on this day.</p>
+{{end}}</body></html>
+`))
+
+// Parse the date of the -date option in MM-DD form
+func parseOnThisDayDate(s string) (int, int, *Report) {
+	parts := strings.Split(s, "-")
+	if len(parts) == 2 {
+		month, err1 := strconv.Atoi(parts[0])
+		day, err2 := strconv.Atoi(parts[1])
+
+		// Use a leap year so February 29 is valid
+		if err1 == nil && err2 == nil && month >= 1 && month <= 12 && day >= 1 &&
+			day <= time.Date(2000, time.Month(month)+1, 0, 0, 0, 0, 0, time.UTC).Day() {
+			return month, day, nil
+		}
+	}
+	return 0, 0, ReportMsg("invalid -date %s, expected MM-DD like 03-05", s)
+}
+
+// Shorten the text to the digest length at a word boundary
+func onThisDayText(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) <= onThisDayTextLength {
+		return s
+	}
+	runes := []rune(s)[:onThisDayTextLength]
+	if i := strings.LastIndex(string(runes), " "); i > 0 {
+		return string(runes)[:i] + "..."
+	}
+	return string(runes) + "..."
+}
+
+// Collect the entries of the configured journals posted on the day in
+// years before thisYear in the order of their dates. On February 28 of a
+// non-leap year the days include February 29.
+func collectOnThisDay(config *Config, month int, days []int, thisYear int) ([]onThisDayItem, *Report) {
+	var items []onThisDayItem
+	for _, journal := range config.journals {
+		dir := config.journalDir(journal)
+		index, r := loadEntriesIndex(dir)
+		if r != nil {
+			return nil, r
+		}
+		rows := index.selectEntries(func(row *entryIndexRow) bool {
+			rowMonth, rowDay := row.monthDay()
+			if rowMonth != month {
+				return false
+			}
+			for _, day := range days {
+				if rowDay == day {
+					return true
+				}
+			}
+			return false
+		})
+		for _, row := range rows {
+			if level, err := parseSecurityLevel(row.security); err == nil && level > config.maxSecurity {
+				continue
+			}
+			entry, err := row.readEntry(dir)
+			if err != nil {
+				config.warn("failed to read %s of %s - %s", row.file, journal, err)
+				continue
+			}
+			if entry.securityLevel() > config.maxSecurity {
+				continue
+			}
+			year, _ := entry.yearMonth()
+			if year >= thisYear {
+				continue
+			}
+			item := onThisDayItem{
+				Journal:  journal,
+				Year:     year,
+				YearsAgo: thisYear - year,
+				Date:     config.formatDate(entry.eventTime),
+				Subject:  entryDisplaySubject(entry),
+				Url:      entry.url,
+				Text:     onThisDayText(htmlToText(entryHtml(config, entry))),
+
+				eventTime: entry.eventTime,
+			}
+			if level := entry.securityLevel(); level != securityPublic {
+				item.Level = level.label()
+			}
+			items = append(items, item)
+		}
+	}
+	sort.Stable(sortOnThisDayItems(items))
+	return items, nil
+}
+
+func writeOnThisDayText(w io.Writer, digest *onThisDayDigest) {
+	fmt.Fprintf(w, "%s\n\n", digest.Title)
+	if len(digest.Items) == 0 {
+		fmt.Fprintf(w, "No archived entries were posted on this day.\n")
+	}
+	for _, item := range digest.Items {
+		ago := fmt.Sprintf("%d years ago", item.YearsAgo)
+		if item.YearsAgo == 1 {
+			ago = "1 year ago"
+		}
+		fmt.Fprintf(w, "%d, %s - %s, %s\n", item.Year, ago, item.Journal, item.Date)
+		subject := item.Subject
+		if item.Level != "" {
+			subject += " [" + item.Level + "]"
+		}
+		fmt.Fprintf(w, "%s\n", subject)
+		if item.Url != "" {
+			fmt.Fprintf(w, "%s\n", item.Url)
+		}
+		if item.Text != "" {
+			fmt.Fprintf(w, "\n%s\n", strings.Join(wrapText(item.Text, onThisDayTextWidth), "\n"))
+		}
+		fmt.Fprintf(w, "\n")
+	}
+}
+
+// Write the digest as a message with text and HTML alternatives
+func writeOnThisDayEmail(w io.Writer, digest *onThisDayDigest, now time.Time) *Report {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	parts := []struct {
+		contentType string
+		write       func(w io.Writer) error
+	}{
+		{"text/plain", func(w io.Writer) error {
+			writeOnThisDayText(w, digest)
+			return nil
+		}},
+		{"text/html", func(w io.Writer) error {
+			return onThisDayTemplate.Execute(w, digest)
+		}},
+	}
+	for _, part := range parts {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Type", part.contentType+"; charset=utf-8")
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		pw, err := mw.CreatePart(header)
+		if err != nil {
+			return WrapErr(err, "")
+		}
+		qw := quotedprintable.NewWriter(pw)
+		if err := part.write(qw); err != nil {
+			return WrapErr(err, "failed to write the digest")
+		}
+		if err := qw.Close(); err != nil {
+			return WrapErr(err, "")
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return WrapErr(err, "")
+	}
+	fmt.Fprintf(w, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", digest.Title))
+	fmt.Fprintf(w, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(w, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(w, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+	if _, err := w.Write(body.Bytes()); err != nil {
+		return WrapErr(err, "")
+	}
+	return nil
+}
+
+func runOnThisDay(config *Config) *Report {
+	known := false
+	for _, format := range onThisDayFormats {
+		known = known || format == config.digestFormat
+	}
+	if !known {
+		return ReportMsg("unknown digest format %s, supported formats are %s",
+			config.digestFormat, strings.Join(onThisDayFormats, ", "))
+	}
+	now := time.Now()
+	if config.timeZone != nil {
+		now = now.In(config.timeZone)
+	}
+	month, day := int(now.Month()), now.Day()
+	days := []int{day}
+	if config.digestDate != "" {
+		var r *Report
+		if month, day, r = parseOnThisDayDate(config.digestDate); r != nil {
+			return r
+		}
+		days = []int{day}
+	} else if month == 2 && day == 28 && time.Date(now.Year(), 2, 29, 0, 0, 0, 0, time.UTC).Day() != 29 {
+		days = append(days, 29)
+	}
+	items, r := collectOnThisDay(config, month, days, now.Year())
+	if r != nil {
+		return r
+	}
+	digest := &onThisDayDigest{
+		Lang:  config.documentLanguage(),
+		Title: fmt.Sprintf("On this day, %s %d: %d entries", time.Month(month), day, len(items)),
+		Items: items,
+	}
+	if len(items) == 1 {
+		digest.Title = fmt.Sprintf("On this day, %s %d: 1 entry", time.Month(month), day)
+	}
+	switch config.digestFormat {
+	case "html":
+		if err := onThisDayTemplate.Execute(os.Stdout, digest); err != nil {
+			return WrapErr(err, "failed to write the digest")
+		}
+	case "email":
+		return writeOnThisDayEmail(os.Stdout, digest, now)
+	default:
+		writeOnThisDayText(os.Stdout, digest)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_parseOnThisDayDate(t *testing.T) {
+	if month, day, r := parseOnThisDayDate("02-29"); r != nil || month != 2 || day != 29 {
+		t.Errorf("Expected February 29, got %d %d %v", month, day, r)
+	}
+	for _, s := range []string{"2-30", "13-01", "00-10", "1210", "03-05-2010"} {
+		if _, _, r := parseOnThisDayDate(s); r == nil {
+			t.Errorf("Invalid date %s was accepted", s)
+		}
+	}
+}
+
+func Test_collectOnThisDay(t *testing.T) {
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	config := &Config{
+		dumpDir:        dumpDir,
+		journals:       []string{"bob"},
+		journalAliases: make(map[string]string),
+		maxSecurity:    securityFriends,
+	}
+	dir := config.journalDir("bob")
+	if err := os.Mkdir(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	entries := map[string]string{
+		"L-1": `<event><itemid>1</itemid><eventtime>2012-05-01 09:00:00</eventtime><subject>Later</subject><event>two</event></event>`,
+		"L-2": `<event><itemid>2</itemid><eventtime>2008-05-01 22:00:00</eventtime><subject>First</subject><event>one&lt;br&gt;line</event><url>https://bob.livejournal.com/512.html</url></event>`,
+		"L-3": `<event><itemid>3</itemid><eventtime>2010-05-02 10:00:00</eventtime><subject>Other day</subject><event>x</event></event>`,
+		"L-4": `<event><itemid>4</itemid><eventtime>2011-05-01 10:00:00</eventtime><subject>Secret</subject><event>x</event><security>private</security></event>`,
+		"L-5": `<event><itemid>5</itemid><eventtime>2020-05-01 07:00:00</eventtime><subject>Today</subject><event>x</event></event>`,
+	}
+	for name, data := range entries {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	items, r := collectOnThisDay(config, 5, []int{1}, 2020)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(items) != 2 || items[0].Subject != "First" || items[1].Subject != "Later" {
+		t.Fatalf("Unexpected items %v", items)
+	}
+	if items[0].YearsAgo != 12 || items[0].Text != "one line" || items[0].Url == "" {
+		t.Errorf("Unexpected item %v", items[0])
+	}
+
+	digest := &onThisDayDigest{Title: "On this day, May 1: 2 entries", Items: items}
+	var buf bytes.Buffer
+	if r := writeOnThisDayEmail(&buf, digest, time.Date(2020, 5, 1, 8, 0, 0, 0, time.UTC)); r != nil {
+		t.Fatal(r.AsText())
+	}
+	message := buf.String()
+	for _, s := range []string{"Subject: On this day, May 1: 2 entries\r\n", "multipart/alternative", "text/plain", "text/html", "2008, 12 years ago"} {
+		if !strings.Contains(message, s) {
+			t.Errorf("The message has no %q:\n%s", s, message)
+		}
+	}
+}
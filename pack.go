@@ -0,0 +1,376 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/ioutil"
+	"linedb"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The pack command writes the dump directory into a zip file in the pack
+// directory for backups, named ljdump-<username>-<YYYYMMDD-HHMMSS>-<kind>.zip
+// with the local time of the run. The first pack of each month is a full
+// pack with all files. Other packs are differential: they contain only the
+// files that were added or changed since the latest full pack, so restoring
+// a point in time needs the full pack unzipped first and then the chosen
+// differential pack unzipped over it. -full forces a full pack. Each pack
+// has packManifestFileName with the kind and the size and modification
+// time of every file of the dump directory at the time of the pack, and
+// differential packs are computed against the manifest of the full pack.
+//
+// With -rotate the pack command then removes old packs keeping the newest
+// pack of each of the last daily days, weekly ISO weeks and monthly months
+// that have packs, see packRetention. The full pack that a kept
+// differential pack is based on is always kept. The command takes the
+// dump lock so a pack never sees a half-written dump.
+
+const packManifestFileName = "ljdump-pack.linedb"
+
+const defaultPackDirName = "packs"
+
+const (
+	fullPackKind = "full"
+	diffPackKind = "diff"
+)
+
+// How many daily, weekly and monthly packs -rotate keeps
+type packRetention struct {
+	daily   int
+	weekly  int
+	monthly int
+}
+
+var defaultPackRetention = packRetention{daily: 7, weekly: 4, monthly: 12}
+
+var packFileNamePattern = regexp.MustCompile(`^ljdump-.*-(\d{8}-\d{6})-(full|diff)\.zip$`)
+
+const packTimeLayout = "20060102-150405"
+
+type packFile struct {
+	name    string
+	created time.Time
+	full    bool
+}
+
+type packedFile struct {
+	path    string
+	size    int64
+	modTime int64
+}
+
+// List packs in the directory, the newest first
+func listPacks(dir string) ([]packFile, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var packs []packFile
+	for _, info := range infos {
+		m := packFileNamePattern.FindStringSubmatch(info.Name())
+		if m == nil || info.IsDir() {
+			continue
+		}
+		created, err := time.ParseInLocation(packTimeLayout, m[1], time.Local)
+		if err != nil {
+			continue
+		}
+		packs = append(packs, packFile{info.Name(), created, m[2] == fullPackKind})
+	}
+	sort.Slice(packs, func(i, j int) bool {
+		if !packs[i].created.Equal(packs[j].created) {
+			return packs[i].created.After(packs[j].created)
+		}
+		return packs[i].name > packs[j].name
+	})
+	return packs, nil
+}
+
+func latestFullPack(packs []packFile) *packFile {
+	for i := range packs {
+		if packs[i].full {
+			return &packs[i]
+		}
+	}
+	return nil
+}
+
+// Select packs to keep. packs must be sorted the newest first.
+func keptPacks(packs []packFile, retention packRetention) map[string]bool {
+	kept := make(map[string]bool)
+	if len(packs) == 0 {
+		return kept
+	}
+	kept[packs[0].name] = true
+	keepNewestPerPeriod := func(count int, period func(t time.Time) string) {
+		seen := make(map[string]bool)
+		for _, p := range packs {
+			if len(seen) >= count {
+				break
+			}
+			key := period(p.created)
+			if !seen[key] {
+				seen[key] = true
+				kept[p.name] = true
+			}
+		}
+	}
+	keepNewestPerPeriod(retention.daily, func(t time.Time) string {
+		return t.Format("2006-01-02")
+	})
+	keepNewestPerPeriod(retention.weekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	})
+	keepNewestPerPeriod(retention.monthly, func(t time.Time) string {
+		return t.Format("2006-01")
+	})
+
+	// A differential pack is based on the newest full pack created before
+	// it
+	for i, p := range packs {
+		if p.full || !kept[p.name] {
+			continue
+		}
+		if base := latestFullPack(packs[i+1:]); base != nil {
+			kept[base.name] = true
+		}
+	}
+	return kept
+}
+
+func rotatePacks(dir string, retention packRetention) *Report {
+	packs, err := listPacks(dir)
+	if err != nil {
+		return WrapErr(err, "")
+	}
+	kept := keptPacks(packs, retention)
+	for _, p := range packs {
+		if kept[p.name] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, p.name)); err != nil {
+			return WrapErr(err, "failed to remove old pack")
+		}
+		log("Removed old pack %s", p.name)
+	}
+	return nil
+}
+
+func encodePackManifest(kind string, base string, files []packedFile) []byte {
+	e := linedb.NewByteEncoder()
+	e.Comment("Pack of the ljdump dump directory, base is the full pack of a differential pack")
+	e.Comment("kind base")
+	e.Table("pack")
+	e.AddString(kind).AddString(base).EndRow()
+	e.EndTable()
+	e.EmptyLine()
+	e.Comment("path size modTime")
+	e.Table("files")
+	for _, f := range files {
+		e.AddString(f.path).AddInt64(f.size).AddInt64(f.modTime).EndRow()
+	}
+	e.EndTable()
+	return e.GetBytes()
+}
+
+// Read the files recorded in the manifest of the pack
+func readPackManifest(packPath string) (map[string]packedFile, *Report) {
+	z, err := zip.OpenReader(packPath)
+	if err != nil {
+		return nil, WrapErr(err, "failed to open pack")
+	}
+	defer z.Close()
+	for _, f := range z.File {
+		if f.Name != packManifestFileName {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, WrapErr(err, "failed to read manifest of %s", packPath)
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, WrapErr(err, "failed to read manifest of %s", packPath)
+		}
+		files := make(map[string]packedFile)
+		d := newLinedbDecoder(data)
+		for d.NextItem() {
+			if d.ItemKind == linedb.TableItem {
+				for d.NextRow() {
+					if d.ItemName == "files" {
+						f := packedFile{d.GetString(), d.GetInt64(), d.GetInt64()}
+						files[f.path] = f
+					}
+				}
+			}
+		}
+		if err := d.GetError(); err != nil {
+			return nil, WrapErr(err, "error while parsing manifest of %s as linedb", packPath)
+		}
+		return files, nil
+	}
+	return nil, ReportMsg("pack %s has no %s", packPath, packManifestFileName)
+}
+
+// List the files of the dump directory to pack in the path order. Skip
+// the lock file, unfinished writes and the pack directory when it is
+// inside the dump directory.
+func listPackedFiles(dumpDir string, packDir string) ([]packedFile, error) {
+	// Packs are always written locally, so with remote storage the pack
+	// directory cannot be inside the dump directory
+	absPackDir := ""
+	if isLocalStore() {
+		absPackDir, _ = filepath.Abs(packDir)
+	}
+	var files []packedFile
+	err := walkStore(dumpDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if abs, _ := filepath.Abs(p); absPackDir != "" && abs == absPackDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasSuffix(p, ".tmp") || strings.HasSuffix(p, pendingSuffix) {
+			return nil
+		}
+		rel, err := filepath.Rel(dumpDir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == lockFileName || rel == packManifestFileName {
+			return nil
+		}
+		files = append(files, packedFile{rel, info.Size(), info.ModTime().UnixNano()})
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	return files, err
+}
+
+// Write the zip into a temporary file in the pack directory and rename
+// it when complete so a failed run never leaves a partial pack
+func writePack(config *Config, packPath string, files []packedFile, manifest []byte) *Report {
+	tmp, err := ioutil.TempFile(filepath.Dir(packPath), ".pack-*.tmp")
+	if err != nil {
+		return WrapErr(err, "")
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	w := zip.NewWriter(tmp)
+	for _, f := range files {
+		if shutdownRequested() {
+			return interruptedReport()
+		}
+		out, err := w.CreateHeader(&zip.FileHeader{
+			Name:     f.path,
+			Method:   zip.Deflate,
+			Modified: time.Unix(0, f.modTime),
+		})
+		if err != nil {
+			return WrapErr(err, "failed to write pack %s", packPath)
+		}
+		data, err := archiveStore.ReadFile(filepath.Join(config.dumpDir, filepath.FromSlash(f.path)))
+		if err != nil {
+			return WrapErr(err, "")
+		}
+		if _, err := out.Write(data); err != nil {
+			return WrapErr(err, "failed to write pack %s", packPath)
+		}
+	}
+	out, err := w.Create(packManifestFileName)
+	if err == nil {
+		_, err = out.Write(manifest)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), packPath)
+	}
+	if err != nil {
+		return WrapErr(err, "failed to write pack %s", packPath)
+	}
+	return nil
+}
+
+func runPack(config *Config) *Report {
+	return packDumpDir(config, time.Now())
+}
+
+func packDumpDir(config *Config, created time.Time) *Report {
+	dir := config.packDir
+	if dir == "" {
+		dir = defaultPackDirName
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return WrapErr(err, "failed to create pack directory")
+	}
+	packs, err := listPacks(dir)
+	if err != nil {
+		return WrapErr(err, "")
+	}
+	files, err := listPackedFiles(config.dumpDir, dir)
+	if err != nil {
+		return WrapErr(err, "failed to list files of the dump directory")
+	}
+
+	kind := fullPackKind
+	base := latestFullPack(packs)
+	if base != nil && !config.fullExport && base.created.Format("2006-01") == created.Format("2006-01") {
+		kind = diffPackKind
+	}
+	packed := files
+	baseName := ""
+	if kind == diffPackKind {
+		baseName = base.name
+		baseFiles, r := readPackManifest(filepath.Join(dir, base.name))
+		if r != nil {
+			return r
+		}
+		packed = nil
+		for _, f := range files {
+			if baseFiles[f.path] != f {
+				packed = append(packed, f)
+			}
+		}
+	}
+
+	name := "ljdump-" + portableFileName(config.username) + "-" + created.Format(packTimeLayout) + "-" + kind + ".zip"
+	packPath := filepath.Join(dir, name)
+	if _, err := os.Stat(packPath); err == nil {
+		return ReportMsg("pack %s already exists, run pack again later", packPath)
+	}
+	if r := writePack(config, packPath, packed, encodePackManifest(kind, baseName, files)); r != nil {
+		return r
+	}
+	if kind == diffPackKind {
+		log("Wrote %d changed files of %d since %s to %s", len(packed), len(files), baseName, packPath)
+	} else {
+		log("Wrote %d files to %s", len(packed), packPath)
+	}
+
+	if config.packRotate {
+		return rotatePacks(dir, config.packRetention)
+	}
+	return nil
+}
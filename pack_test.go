@@ -0,0 +1,132 @@
+package main
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func Test_keptPacks(t *testing.T) {
+	day := func(month time.Month, d int) time.Time {
+		return time.Date(2026, month, d, 3, 0, 0, 0, time.Local)
+	}
+	var packs []packFile
+	add := func(created time.Time, full bool) {
+		kind := diffPackKind
+		if full {
+			kind = fullPackKind
+		}
+		packs = append(packs, packFile{"ljdump-bob-" + created.Format(packTimeLayout) + "-" + kind + ".zip", created, full})
+	}
+	add(day(3, 1), true)
+	for d := 2; d <= 31; d++ {
+		add(day(3, d), false)
+	}
+	add(day(4, 1), true)
+	for d := 2; d <= 20; d++ {
+		add(day(4, d), false)
+	}
+	sort.Slice(packs, func(i, j int) bool { return packs[i].created.After(packs[j].created) })
+
+	kept := keptPacks(packs, packRetention{daily: 3, weekly: 2, monthly: 2})
+	var names []string
+	for _, p := range packs {
+		if kept[p.name] {
+			names = append(names, p.created.Format("01-02")+" "+map[bool]string{true: "full", false: "diff"}[p.full])
+		}
+	}
+	// The last three days that also cover the last two ISO weeks as
+	// 04-20 is a Monday, the end of March and the full packs they are
+	// based on
+	expected := []string{"04-20 diff", "04-19 diff", "04-18 diff", "04-01 full", "03-31 diff", "03-01 full"}
+	if len(names) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, names)
+	}
+	for i := range names {
+		if names[i] != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, names)
+		}
+	}
+}
+
+func Test_packDumpDir(t *testing.T) {
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	packDir := filepath.Join(dumpDir, "packs")
+	write := func(name string, data string) {
+		path := filepath.Join(dumpDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("bob_/L-1", "entry")
+	write("bob_/C-1", "comments")
+	write(lockFileName, "")
+
+	config := &Config{
+		username:      "bob",
+		dumpDir:       dumpDir,
+		packDir:       packDir,
+		packRotate:    true,
+		packRetention: packRetention{daily: 1},
+	}
+	start := time.Date(2026, 5, 10, 12, 0, 0, 0, time.Local)
+	if r := packDumpDir(config, start); r != nil {
+		t.Fatal(r.AsText())
+	}
+	write("bob_/L-2", "new entry")
+	if r := packDumpDir(config, start.Add(time.Hour)); r != nil {
+		t.Fatal(r.AsText())
+	}
+
+	packs, err := listPacks(packDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packs) != 2 || packs[0].full || !packs[1].full {
+		t.Fatalf("Expected a full and a differential pack, got %+v", packs)
+	}
+	zipNames := func(p packFile) map[string]bool {
+		z, err := zip.OpenReader(filepath.Join(packDir, p.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer z.Close()
+		names := make(map[string]bool)
+		for _, f := range z.File {
+			names[f.Name] = true
+		}
+		return names
+	}
+	if names := zipNames(packs[1]); len(names) != 3 || !names["bob_/L-1"] || !names["bob_/C-1"] {
+		t.Errorf("Unexpected full pack %v", names)
+	}
+	if names := zipNames(packs[0]); len(names) != 2 || !names["bob_/L-2"] || !names[packManifestFileName] {
+		t.Errorf("Unexpected differential pack %v", names)
+	}
+	files, r := readPackManifest(filepath.Join(packDir, packs[0].name))
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(files) != 3 {
+		t.Errorf("Expected all files in the manifest, got %v", files)
+	}
+
+	// The next day rotation keeps only the newest pack and its full pack
+	if r := packDumpDir(config, start.Add(24*time.Hour)); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if packs, _ = listPacks(packDir); len(packs) != 2 || packs[0].created.Day() != 11 || !packs[1].full {
+		t.Errorf("Unexpected packs after rotation %+v", packs)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// The public URL of an entry ends with the ditemid, the itemid multiplied
+// by 256 plus the anum, a random number that the server assigns to make
+// URLs of private entries hard to guess. The dump stores the ditemid and
+// the URL of the entry page as ditemid and permalink in the entry file and
+// the entries index lists them, so exports can link to the original post
+// and pages saved from the web can be matched to archived entries. The
+// permalink is the url that the server returned or, for servers that do
+// not return it, the URL built from the journal address. Files stored
+// before the dump recorded them get the ditemid from anum and the
+// permalink from url when they are read.
+
+// Entry URLs end with the ditemid
+var entryUrlPattern = regexp.MustCompile(`/(\d+)\.html(?:[?#].*)?$`)
+
+// Split the ditemid into the itemid and the anum
+func splitDitemId(ditemid int64) (int64, int64) {
+	return ditemid / 256, ditemid % 256
+}
+
+// Get the ditemid from an entry URL or 0 when the URL is not one
+func urlDitemId(link string) int64 {
+	m := entryUrlPattern.FindStringSubmatch(link)
+	if m == nil {
+		return 0
+	}
+	ditemid, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil || ditemid < 256 {
+		return 0
+	}
+	return ditemid
+}
+
+func entryPermalink(server string, journal string, ditemid int64) string {
+	return openIdIdentity(server, journal) + fmt.Sprintf("%d.html", ditemid)
+}
+
+func makeDitemId(itemId int64, anum int64) int64 {
+	return itemId*256 + anum
+}
+
+// Convert the protocol value of anum that can be a number or a string
+func eventAnum(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	case string:
+		anum, err := strconv.ParseInt(v, 10, 64)
+		return anum, err == nil
+	}
+	return 0, false
+}
+
+// Add ditemid and permalink to the event from the protocol
+func addEventPermalink(config *Config, journal string, itemId int64, event map[string]interface{}) {
+	anum, ok := eventAnum(event["anum"])
+	if !ok {
+		return
+	}
+	ditemid := makeDitemId(itemId, anum)
+	event["ditemid"] = ditemid
+	if link, _ := event["url"].(string); link != "" {
+		event["permalink"] = link
+	} else {
+		event["permalink"] = entryPermalink(config.server, journal, ditemid)
+	}
+}
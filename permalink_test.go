@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_urlDitemId(t *testing.T) {
+	cases := map[string]int64{
+		"https://bob.livejournal.com/298.html":            298,
+		"https://bob.livejournal.com/298.html?thread=512": 298,
+		"https://example.com/users/bob/298.html#comments": 298,
+		"https://bob.livejournal.com/12.html":             0,
+		"https://bob.livejournal.com/tag/298":             0,
+	}
+	for link, expected := range cases {
+		if ditemid := urlDitemId(link); ditemid != expected {
+			t.Errorf("%s: expected %d, got %d", link, expected, ditemid)
+		}
+	}
+	if itemId, anum := splitDitemId(7*256 + 200); itemId != 7 || anum != 200 {
+		t.Errorf("Unexpected split %d %d", itemId, anum)
+	}
+}
+
+func Test_addEventPermalink(t *testing.T) {
+	config := &Config{server: "https://www.livejournal.com"}
+	event := map[string]interface{}{"anum": "42"}
+	addEventPermalink(config, "bob_smith", 1, event)
+	if event["ditemid"] != int64(298) || event["permalink"] != "https://bob-smith.livejournal.com/298.html" {
+		t.Errorf("Unexpected permalink of %+v", event)
+	}
+
+	event = map[string]interface{}{"anum": 1, "url": "https://mirror.example/bob/257.html"}
+	addEventPermalink(config, "bob", 1, event)
+	if event["permalink"] != "https://mirror.example/bob/257.html" {
+		t.Errorf("Expected the URL from the server, got %+v", event)
+	}
+
+	event = map[string]interface{}{}
+	addEventPermalink(config, "bob", 1, event)
+	if len(event) != 0 {
+		t.Errorf("Expected no permalink without anum, got %+v", event)
+	}
+}
+
+// Entries stored before the dump recorded ditemid and permalink get them
+// from anum and url
+func Test_oldEntryPermalink(t *testing.T) {
+	entry, err := parseArchivedEntry([]byte("<event><itemid>3</itemid><anum>5</anum><url>https://mirror.example/bob/773.html</url></event>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.ditemid != 773 || entry.permalink != "https://mirror.example/bob/773.html" {
+		t.Errorf("Unexpected ditemid %d and permalink %s", entry.ditemid, entry.permalink)
+	}
+
+	entry, err = parseArchivedEntry([]byte("<event><itemid>1</itemid></event>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.ditemid != 0 || entry.permalink != "" {
+		t.Errorf("Expected no permalink without anum, got %d %s", entry.ditemid, entry.permalink)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// The archive holds private entries, so files and directories that
+// ljdumpgo creates are readable only by the owner unless <fileMode> and
+// <dirMode> in the config say otherwise. The umask can only remove
+// permissions, so the modes are set explicitly after creation to get
+// exactly the configured ones. Existing files get the file mode when they
+// are rewritten and the fix-perms command applies the modes to the whole
+// dump directory. The config and the password and other credential files
+// often live in the dump directory, so fix-perms keeps them readable only
+// by the owner whatever the file mode is.
+
+const defaultArchiveFileMode os.FileMode = 0600
+const defaultArchiveDirMode os.FileMode = 0700
+
+// The modes for new files and directories, set from the config
+var archiveFileMode = defaultArchiveFileMode
+var archiveDirMode = defaultArchiveDirMode
+
+func parseFileMode(s string, defaultMode os.FileMode) (os.FileMode, error) {
+	if s == "" {
+		return defaultMode, nil
+	}
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("'%s' is not an octal permission mode like 0600", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// Create the directory with its parents using the archive mode. An
+// existing directory keeps its mode.
+func mkdirArchive(dir string) error {
+	return archiveStore.MkdirAll(dir)
+}
+
+// Check if the file is one of the paths, which may be empty or - for the
+// standard input
+func isSameFile(info os.FileInfo, paths []string) bool {
+	for _, path := range paths {
+		if path == "" || path == "-" {
+			continue
+		}
+		if other, err := os.Stat(path); err == nil && os.SameFile(info, other) {
+			return true
+		}
+	}
+	return false
+}
+
+// Set the archive modes on all files and directories under the dump
+// directory. Files that hold secrets never become readable by others.
+func runFixPerms(config *Config) *Report {
+	if !isLocalStore() {
+		return ReportMsg("fix-perms works only with a local archive, the WebDAV server controls the permissions")
+	}
+	changed := 0
+	err := filepath.Walk(config.dumpDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		mode := archiveFileMode
+		switch {
+		case info.IsDir():
+			mode = archiveDirMode
+		case info.Mode()&os.ModeSymlink != 0 || !info.Mode().IsRegular():
+			return nil
+		case filepath.Base(path) == oauthDBFileName || isSameFile(info, config.secretFiles):
+			mode &= 0700
+		}
+		if info.Mode().Perm() == mode {
+			return nil
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
+		changed++
+		return nil
+	})
+	if err != nil {
+		return WrapErr(err, "failed to change permissions")
+	}
+	log("Changed permissions of %d files and directories in %s to %04o for files and %04o for directories",
+		changed, config.dumpDir, archiveFileMode, archiveDirMode)
+	return nil
+}
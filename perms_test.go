@@ -0,0 +1,69 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func Test_archivePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Windows has no Unix permissions")
+	}
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	journalDir := filepath.Join(dumpDir, "bob")
+	if err := mkdirArchive(journalDir); err != nil {
+		t.Fatal(err)
+	}
+	entryPath := filepath.Join(journalDir, "L-1")
+	if err := writeFileTempRename(entryPath, []byte("entry")); err != nil {
+		t.Fatal(err)
+	}
+	checkMode := func(path string, expected os.FileMode) {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != expected {
+			t.Errorf("Expected mode %04o for %s, got %04o", expected, path, info.Mode().Perm())
+		}
+	}
+	checkMode(journalDir, 0700)
+	checkMode(entryPath, 0600)
+
+	if err := os.Chmod(entryPath, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(journalDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if r := runFixPerms(&Config{dumpDir: dumpDir}); r != nil {
+		t.Fatal(r.AsText())
+	}
+	checkMode(journalDir, 0700)
+	checkMode(entryPath, 0600)
+
+	// Secrets stay private with a file mode readable by others
+	configPath := filepath.Join(dumpDir, defaultConfigFile)
+	passwordPath := filepath.Join(dumpDir, "password.txt")
+	for _, path := range []string{configPath, passwordPath} {
+		if err := ioutil.WriteFile(path, []byte("secret"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archiveFileMode = 0644
+	defer func() { archiveFileMode = defaultArchiveFileMode }()
+	if r := runFixPerms(&Config{dumpDir: dumpDir, secretFiles: []string{configPath, "", "-", passwordPath}}); r != nil {
+		t.Fatal(r.AsText())
+	}
+	checkMode(entryPath, 0644)
+	checkMode(configPath, 0600)
+	checkMode(passwordPath, 0600)
+}
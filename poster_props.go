@@ -0,0 +1,42 @@
+package main
+
+// For maintainers export_comments.bml adds properties that identify the
+// poster of a comment: the IP address for journals that log them, the
+// ljuniq browser cookie and the encoding of comments posted by e-mail.
+// Some users want to keep them to deal with abuse, others must not keep
+// personal data of commenters. By default the dump drops them; with
+// -poster-props store or <posterProps>store</posterProps> in the config
+// they are kept in the posterprops element of the comment. With strip,
+// stored properties are removed from comment files that the dump rewrites.
+
+var posterPropNames = map[string]bool{
+	"poster_ip":      true,
+	"uniq":           true,
+	"ljuniq":         true,
+	"ljmailencoding": true,
+}
+
+func posterPropsEqual(a, b []CommentProp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Remove poster properties from the comments and return the number of
+// comments that had them
+func stripPosterProps(comments []CommentRecord) int {
+	stripped := 0
+	for i := range comments {
+		if len(comments[i].PosterProps) != 0 {
+			comments[i].PosterProps = nil
+			stripped++
+		}
+	}
+	return stripped
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_posterProps(t *testing.T) {
+	server := newFakeLJServer(t)
+	defer server.Close()
+	server.commentPosterIp = "192.0.2.1"
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	config := &Config{
+		server:           server.URL,
+		username:         "con",
+		password:         "password",
+		journals:         []string{"con"},
+		dumpDir:          dumpDir,
+		accountDataDir:   filepath.Join(dumpDir, accountDataDirName),
+		journalAliases:   make(map[string]string),
+		storePosterProps: true,
+	}
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	journalDir := filepath.Join(dumpDir, "con_")
+	comments, r := readEntryComments(journalDir, 1)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(comments) != 1 || len(comments[0].PosterProps) != 1 ||
+		comments[0].PosterProps[0] != (CommentProp{Name: "poster_ip", Value: "192.0.2.1"}) {
+		t.Fatalf("Expected the stored poster_ip, got %+v", comments)
+	}
+
+	// Switching to strip removes the stored properties from the comment
+	// files that the dump touches
+	config.storePosterProps = false
+	config.recheckComments = true
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	comments, r = readEntryComments(journalDir, 1)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(comments) != 1 || len(comments[0].PosterProps) != 0 || len(comments[0].History) != 0 {
+		t.Errorf("Expected comments without poster properties, got %+v", comments)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"runtime/trace"
+	"strings"
+)
+
+// With -pprof the run records performance data to attach to a report about
+// a slow or memory hungry dump of a big journal. When the value is a
+// host:port like localhost:6060, the handlers of net/http/pprof including
+// the runtime trace at /debug/pprof/trace are served there while the
+// command runs, so go tool pprof can sample a running dump. Otherwise the
+// value is a directory where the run writes:
+//
+//	cpu.pprof     CPU profile of the whole run
+//	trace.out     runtime trace for go tool trace
+//	heap.pprof    memory in use at the end of the run
+//	allocs.pprof  all allocations of the run
+//
+// The profiles contain function names and sizes but no archived text, so
+// they can be shared. The trace is big, keep the run short.
+
+const (
+	cpuProfileFileName    = "cpu.pprof"
+	traceFileName         = "trace.out"
+	heapProfileFileName   = "heap.pprof"
+	allocsProfileFileName = "allocs.pprof"
+)
+
+func isProfilingAddress(value string) bool {
+	if strings.ContainsAny(value, `/\`) {
+		return false
+	}
+	_, port, err := net.SplitHostPort(value)
+	return err == nil && port != ""
+}
+
+// Start profiling as the value of -pprof says. The returned function
+// stops it and writes the remaining profiles.
+func startProfiling(value string) (func() *Report, *Report) {
+	if isProfilingAddress(value) {
+		return startProfilingServer(value)
+	}
+	return startProfilingFiles(value)
+}
+
+func startProfilingServer(address string) (func() *Report, *Report) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, WrapErr(err, "failed to listen for -pprof on %s", address)
+	}
+	if host, _, _ := net.SplitHostPort(address); host != "127.0.0.1" && host != "localhost" && host != "::1" {
+		log("WARNING: serving profiling data on %s, anybody who can connect can read it", address)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	log("Serving profiling data on http://%s/debug/pprof/", listener.Addr())
+	return func() *Report {
+		server.Close()
+		return nil
+	}, nil
+}
+
+func startProfilingFiles(dir string) (func() *Report, *Report) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, WrapErr(err, "failed to create -pprof directory")
+	}
+	cpuFile, err := os.Create(filepath.Join(dir, cpuProfileFileName))
+	if err != nil {
+		return nil, WrapErr(err, "")
+	}
+	if err := runtimepprof.StartCPUProfile(cpuFile); err != nil {
+		cpuFile.Close()
+		return nil, WrapErr(err, "failed to start CPU profile")
+	}
+	traceFile, err := os.Create(filepath.Join(dir, traceFileName))
+	if err == nil {
+		if err = trace.Start(traceFile); err != nil {
+			traceFile.Close()
+		}
+	}
+	if err != nil {
+		runtimepprof.StopCPUProfile()
+		cpuFile.Close()
+		return nil, WrapErr(err, "failed to start runtime trace")
+	}
+	return func() *Report {
+		trace.Stop()
+		runtimepprof.StopCPUProfile()
+		err := fuseErr(traceFile.Close(), cpuFile.Close())
+		if err == nil {
+			err = writeProfile(filepath.Join(dir, allocsProfileFileName), "allocs")
+		}
+		if err == nil {
+			// Count only the memory still in use
+			runtime.GC()
+			err = writeProfile(filepath.Join(dir, heapProfileFileName), "heap")
+		}
+		if err != nil {
+			return WrapErr(err, "failed to write profiles to %s", dir)
+		}
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		log("Wrote profiles to %s, the process got %d MB from the system", dir, stats.Sys>>20)
+		return nil
+	}, nil
+}
+
+func writeProfile(path string, name string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	return fuseErr(runtimepprof.Lookup(name).WriteTo(f, 0), f.Close())
+}
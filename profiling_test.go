@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_isProfilingAddress(t *testing.T) {
+	cases := map[string]bool{
+		"localhost:6060": true,
+		":6060":          true,
+		"profiles":       false,
+		"/tmp/profiles":  false,
+		`C:\profiles`:    false,
+		"out:dir/x":      false,
+	}
+	for value, expected := range cases {
+		if isProfilingAddress(value) != expected {
+			t.Errorf("%s: expected %v", value, expected)
+		}
+	}
+}
+
+func Test_profilingFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	profileDir := filepath.Join(dir, "profiles")
+	stop, r := startProfiling(profileDir)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	buffers := make([][]byte, 0, 100)
+	for i := 0; i < 100; i++ {
+		buffers = append(buffers, make([]byte, 1<<10))
+	}
+	if r := stop(); r != nil {
+		t.Fatal(r.AsText())
+	}
+	for _, name := range []string{cpuProfileFileName, traceFileName, heapProfileFileName, allocsProfileFileName} {
+		info, err := os.Stat(filepath.Join(profileDir, name))
+		if err != nil || info.Size() == 0 {
+			t.Errorf("Expected non-empty %s - %v", name, err)
+		}
+	}
+}
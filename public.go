@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The dump-public command archives public journals of other users and
+// communities without login, for example journals of friends who cannot
+// post anymore. Only the RSS feed of the journal is public, and it has
+// only the most recent entries, so this is best effort: run it regularly,
+// for example from cron, to collect entries as they appear. The feed has
+// no comments, security other than public, or most entry properties.
+// Entries go into the usual L-<itemid> files with the source prop set to
+// rss and an entry archived from the protocol by a dump of the owner is
+// never replaced with its feed version. Requests are spaced further apart
+// than with login as the server limits anonymous clients more strictly.
+
+const publicRequestInterval = 2 * time.Second
+
+// Value of the source prop of entries archived from the feed
+const publicEntrySource = "rss"
+
+type publicFeed struct {
+	Items []publicFeedItem `xml:"channel>item"`
+}
+
+// Item of the journal feed. The elements with the lj prefix come from the
+// http://www.livejournal.org/rss/lj/1.0/ namespace and are matched by
+// the local name.
+type publicFeedItem struct {
+	Guid        string   `xml:"guid"`
+	Link        string   `xml:"link"`
+	PubDate     string   `xml:"pubDate"`
+	Title       string   `xml:"title"`
+	Description string   `xml:"description"`
+	Categories  []string `xml:"category"`
+	Security    string   `xml:"security"`
+	Poster      string   `xml:"poster"`
+	Mood        string   `xml:"mood"`
+	Music       string   `xml:"music"`
+}
+
+// Create a session without login for public pages
+func openAnonymousLJSession(config *Config) *ljSession {
+	session := &ljSession{
+		config:          config,
+		transport:       &timeoutTransport{config, config.baseTransport()},
+		requestInterval: publicRequestInterval,
+		jar:             newSessionJar(),
+	}
+	session.client.Transport = session
+	return session
+}
+
+// The identity URL is the journal URL
+func publicFeedUrl(config *Config, journal string) string {
+	return openIdIdentity(config.server, journal) + "data/rss"
+}
+
+func fetchPublicFeed(session *ljSession, journal string) (*publicFeed, *Report) {
+	feedUrl := publicFeedUrl(session.config, journal)
+	resp, err := session.client.Get(feedUrl)
+	if err != nil {
+		return nil, WrapErr(err, "failed to get %s", feedUrl)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, ReportMsg("unexpected status %s for %s, the journal may be deleted, suspended or not public", resp.Status, feedUrl)
+	}
+	var feed publicFeed
+	d := xml.NewDecoder(io.LimitReader(resp.Body, maxMediaFileSize))
+	d.Strict = false
+	if err := d.Decode(&feed); err != nil {
+		return nil, WrapErr(err, "failed to parse the feed %s", feedUrl)
+	}
+	return &feed, nil
+}
+
+// Convert the feed item into the event map of the protocol. Return nil
+// for items that are not public entries of the journal.
+func publicFeedEvent(config *Config, item *publicFeedItem) (int64, map[string]interface{}) {
+	if item.Security != "" && item.Security != "public" {
+		return 0, nil
+	}
+	link := item.Link
+	if link == "" {
+		link = item.Guid
+	}
+	ditemid := urlDitemId(link)
+	if ditemid == 0 {
+		return 0, nil
+	}
+	itemId, anum := splitDitemId(ditemid)
+	event := map[string]interface{}{
+		"itemid":  itemId,
+		"anum":    anum,
+		"url":     link,
+		"subject": item.Title,
+		"event":   item.Description,
+	}
+	if item.Poster != "" {
+		event["poster"] = item.Poster
+	}
+
+	// The feed has the time with the offset, keep it as the local time of
+	// the configured zone like the protocol does
+	for _, layout := range []string{time.RFC1123Z, time.RFC1123} {
+		if t, err := time.Parse(layout, item.PubDate); err == nil {
+			if config.timeZone != nil {
+				t = t.In(config.timeZone)
+			} else {
+				t = t.UTC()
+			}
+			event["eventtime"] = t.Format(ljEventTimeLayout)
+			event["eventtime_rfc3339"] = t.Format(time.RFC3339)
+			break
+		}
+	}
+	props := map[string]interface{}{"source": publicEntrySource}
+	if len(item.Categories) != 0 {
+		props["taglist"] = strings.Join(item.Categories, ", ")
+	}
+	if item.Mood != "" {
+		props["current_mood"] = item.Mood
+	}
+	if item.Music != "" {
+		props["current_music"] = item.Music
+	}
+	event["props"] = props
+	return itemId, event
+}
+
+func dumpPublicJournalPosts(jcx *journalContext) *Report {
+	log("Fetching the public feed of: %s", jcx.name)
+	feed, r := fetchPublicFeed(jcx.session, jcx.name)
+	if r != nil {
+		return r
+	}
+	for i := range feed.Items {
+		if jcx.sliceExpired() {
+			return nil
+		}
+		itemId, event := publicFeedEvent(jcx.config, &feed.Items[i])
+		if event == nil {
+			jcx.config.warn("skipping feed item %s that is not a public entry of %s", feed.Items[i].Link, jcx.name)
+			continue
+		}
+		if row := jcx.index.rows[itemId]; row != nil && row.file != "" {
+			continue
+		}
+		log("Archiving public entry L-%d", itemId)
+		eventTime, _ := event["eventtime"].(string)
+		eventPath, r := jcx.prepareEntryPath(itemId, eventTime)
+		if r != nil {
+			return r
+		}
+		if r := writeLJEventDump(jcx, eventPath, 'L', itemId, event); r != nil {
+			return r
+		}
+		data, _ := jcx.readStagedFile(eventPath)
+		archived, err := parseArchivedEntryFile(eventPath, data)
+		if err != nil {
+			return WrapErr(err, "failed to read back entry L-%d", itemId)
+		}
+		jcx.index.setEntry(archived, entryRelPath(jcx.dir, archived))
+		if r := jcx.commitPendingWrites(); r != nil {
+			return r
+		}
+		jcx.newEntries++
+		jcx.config.budget.addNewEntries(1)
+		jcx.newEntryIds = append(jcx.newEntryIds, itemId)
+	}
+	jcx.postsDone = true
+	return nil
+}
+
+func runDumpPublic(config *Config) *Report {
+	startShutdownHandling()
+	session := openAnonymousLJSession(config)
+	for _, journal := range config.journals {
+		jcx := newJournalContext(session, journal)
+		if r := dumpJournal(jcx); r != nil {
+			return r
+		}
+		if shutdownRequested() {
+			return interruptedReport()
+		}
+		if r := updateJournalCollections(config, jcx.name, jcx.dir); r != nil {
+			return r
+		}
+		if config.downloadMedia {
+			if r := dumpJournalMedia(config, jcx.name, jcx.dir); r != nil {
+				return r
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func Test_publicFeedEvent(t *testing.T) {
+	feedXml := `<?xml version="1.0"?>
+<rss version="2.0" xmlns:lj="http://www.livejournal.org/rss/lj/1.0/">
+<channel>
+<item>
+  <guid isPermaLink="true">https://bob.livejournal.com/1234.html</guid>
+  <pubDate>Sat, 01 May 2010 10:00:00 GMT</pubDate>
+  <title>Spring</title>
+  <link>https://bob.livejournal.com/1234.html</link>
+  <description>&lt;b&gt;text&lt;/b&gt;</description>
+  <category>travel</category>
+  <category>photo</category>
+  <lj:security>public</lj:security>
+  <lj:mood>happy</lj:mood>
+</item>
+<item>
+  <link>https://bob.livejournal.com/profile</link>
+</item>
+</channel>
+</rss>`
+	var feed publicFeed
+	if err := xml.Unmarshal([]byte(feedXml), &feed); err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Items) != 2 {
+		t.Fatalf("Expected 2 items, got %d", len(feed.Items))
+	}
+	itemId, event := publicFeedEvent(&Config{}, &feed.Items[0])
+	if itemId != 4 || event["anum"] != int64(210) {
+		t.Errorf("Expected itemid 4 with anum 210, got %d %v", itemId, event["anum"])
+	}
+	if event["eventtime"] != "2010-05-01 10:00:00" || event["subject"] != "Spring" || event["event"] != "<b>text</b>" {
+		t.Errorf("Unexpected event %v", event)
+	}
+	props := event["props"].(map[string]interface{})
+	if props["taglist"] != "travel, photo" || props["current_mood"] != "happy" || props["source"] != publicEntrySource {
+		t.Errorf("Unexpected props %v", props)
+	}
+	if _, event := publicFeedEvent(&Config{}, &feed.Items[1]); event != nil {
+		t.Errorf("Item without entry URL was accepted")
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"linedb"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The publish command renders public entries of the journals as the html
+// export into the ipfs subdirectory of the export directory and adds the
+// result to a local IPFS node through its HTTP API. The CID of the site
+// is printed and recorded with the time in publish.linedb of account.data.
+// With <ipnsKey> in the <ipfs> element of the config the IPNS name of the
+// key is updated to point to the new CID. Friends-only, custom and private
+// entries are never published.
+
+const publishDBFileName = "publish.linedb"
+
+// The API of a node started with the default config
+const defaultIpfsApiUrl = "http://127.0.0.1:5001"
+
+// Name of the root directory of the site in IPFS
+const ipfsSiteName = "ljdump"
+
+var publishTargets = []string{"ipfs"}
+
+type ipfsConfig struct {
+	apiUrl  string
+	ipnsKey string
+}
+
+type publishRecord struct {
+	target string
+	time   int64
+	cid    string
+	name   string
+}
+
+func readPublishRecords(config *Config) ([]publishRecord, *Report) {
+	dbpath := filepath.Join(config.accountDataDir, publishDBFileName)
+	dbdata, err := archiveStore.ReadFile(dbpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, WrapErr(err, "")
+	}
+	var records []publishRecord
+	d := newLinedbDecoder(dbdata)
+	for d.NextItem() {
+		if d.ItemKind == linedb.TableItem && d.ItemName == "published" {
+			for d.NextRow() {
+				records = append(records, publishRecord{d.GetString(), d.GetInt64(), d.GetString(), d.GetString()})
+			}
+		}
+	}
+	if err := d.GetError(); err != nil {
+		return nil, WrapErr(err, "failed to parse %s", dbpath)
+	}
+	return records, nil
+}
+
+func writePublishRecords(config *Config, records []publishRecord) *Report {
+	if err := mkdirArchive(config.accountDataDir); err != nil {
+		return WrapErr(err, "")
+	}
+	e := linedb.NewByteEncoder()
+	e.Comment("target time cid ipns-name")
+	e.Table("published")
+	for _, record := range records {
+		e.AddString(record.target).AddInt64(record.time).AddString(record.cid).AddString(record.name).EndRow()
+	}
+	e.EndTable()
+	dbpath := filepath.Join(config.accountDataDir, publishDBFileName)
+	if err := writeFileTempRename(dbpath, e.GetBytes()); err != nil {
+		return WrapErr(err, "")
+	}
+	return nil
+}
+
+// Build the multipart body of the add call with all files of the rendered
+// site. IPFS takes the directory structure from the part file names, a
+// directory is a part with the application/x-directory type.
+func ipfsAddBody(siteDir string) (*bytes.Buffer, string, int, *Report) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	files := 0
+	err := walkStore(siteDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(siteDir, path)
+		if err != nil {
+			return err
+		}
+		name := ipfsSiteName
+		if rel != "." {
+			name += "/" + filepath.ToSlash(rel)
+		}
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, url.PathEscape(name)))
+		if info.IsDir() {
+			header.Set("Content-Type", "application/x-directory")
+			_, err := mw.CreatePart(header)
+			return err
+		}
+		if info.Name() == exportStateFileName || strings.HasSuffix(info.Name(), ".tmp") {
+			return nil
+		}
+		data, err := archiveStore.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		header.Set("Content-Type", "application/octet-stream")
+		part, err := mw.CreatePart(header)
+		if err == nil {
+			_, err = part.Write(data)
+		}
+		files++
+		return err
+	})
+	if err == nil {
+		err = mw.Close()
+	}
+	if err != nil {
+		return nil, "", 0, WrapErr(err, "failed to read the rendered site %s", siteDir)
+	}
+	return &body, mw.FormDataContentType(), files, nil
+}
+
+// Call the method of the IPFS HTTP API and return the response body. All
+// calls of the API are POST.
+func ipfsCall(config *Config, client *http.Client, ipfs *ipfsConfig, method string, query url.Values, body io.Reader, contentType string) ([]byte, *Report) {
+	callUrl := strings.TrimSuffix(ipfs.apiUrl, "/") + "/api/v0/" + method + "?" + query.Encode()
+	req, err := http.NewRequest("POST", callUrl, body)
+	if err != nil {
+		return nil, WrapErr(err, "failed to create request to %s", callUrl)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, WrapErr(err, "failed to call IPFS API at %s, is the IPFS daemon running?", ipfs.apiUrl)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<24))
+	if err != nil {
+		return nil, WrapErr(err, "failed to read the response from %s", callUrl)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var result struct {
+			Message string
+		}
+		json.Unmarshal(data, &result)
+		config.errorLog.record("http", "%d POST %s", resp.StatusCode, callUrl)
+		return nil, ReportMsg("IPFS %s failed with %s - %s", method, resp.Status, result.Message)
+	}
+	return data, nil
+}
+
+// Add the site to the node and pin it. The add call answers with a JSON
+// object per added file and directory, the root directory is among them.
+func ipfsAddSite(config *Config, client *http.Client, ipfs *ipfsConfig, siteDir string) (string, *Report) {
+	body, contentType, files, r := ipfsAddBody(siteDir)
+	if r != nil {
+		return "", r
+	}
+	log("Adding %d files of %s to IPFS", files, siteDir)
+	query := url.Values{}
+	query.Set("pin", "true")
+	query.Set("cid-version", "1")
+	query.Set("quieter", "true")
+	data, r := ipfsCall(config, client, ipfs, "add", query, body, contentType)
+	if r != nil {
+		return "", r
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var added struct {
+			Name string
+			Hash string
+		}
+		if err := d.Decode(&added); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return "", WrapErr(err, "failed to parse the response of IPFS add")
+		}
+		if added.Name == ipfsSiteName {
+			return added.Hash, nil
+		}
+	}
+	return "", ReportMsg("no CID of the site in the response of IPFS add")
+}
+
+// Point the IPNS name of the key to the CID and return the name
+func ipfsPublishName(config *Config, client *http.Client, ipfs *ipfsConfig, cid string) (string, *Report) {
+	query := url.Values{}
+	query.Set("arg", "/ipfs/"+cid)
+	query.Set("key", ipfs.ipnsKey)
+	data, r := ipfsCall(config, client, ipfs, "name/publish", query, nil, "")
+	if r != nil {
+		return "", r
+	}
+	var result struct {
+		Name string
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return "", WrapErr(err, "failed to parse the response of IPFS name/publish")
+	}
+	return result.Name, nil
+}
+
+func runPublish(config *Config) *Report {
+	if config.commandArg != "ipfs" {
+		return ReportMsg("unknown publish target %s, supported targets are %s", config.commandArg, strings.Join(publishTargets, ", "))
+	}
+	ipfs := config.ipfs
+	if ipfs == nil {
+		ipfs = &ipfsConfig{apiUrl: defaultIpfsApiUrl}
+	}
+
+	// Render with the html export limited to public entries. The own
+	// directory keeps the export state apart from the html export.
+	render := *config
+	render.exportFormat = "html"
+	render.exportDir = filepath.Join(config.exportDir, "ipfs")
+	render.maxSecurity = securityPublic
+	if r := runExport(&render); r != nil {
+		return r
+	}
+	siteDir := filepath.Join(render.exportDir, "html")
+	if config.dryRun {
+		_, _, files, r := ipfsAddBody(siteDir)
+		if r != nil {
+			return r
+		}
+		log("Would add %d files of %s to IPFS", files, siteDir)
+		return nil
+	}
+
+	startShutdownHandling()
+	client := config.httpClient()
+	cid, r := ipfsAddSite(config, client, ipfs, siteDir)
+	if r != nil {
+		return r
+	}
+	log("Added the site as %s", cid)
+	record := publishRecord{target: "ipfs", time: time.Now().Unix(), cid: cid}
+	if ipfs.ipnsKey != "" {
+		if shutdownRequested() {
+			return interruptedReport()
+		}
+		log("Publishing %s under the IPNS key %s, this can take a minute", cid, ipfs.ipnsKey)
+		if record.name, r = ipfsPublishName(config, client, ipfs, cid); r != nil {
+			return r
+		}
+		log("IPNS name %s points to %s", record.name, cid)
+	}
+	records, r := readPublishRecords(config)
+	if r != nil {
+		return r
+	}
+	if r := writePublishRecords(config, append(records, record)); r != nil {
+		return r
+	}
+	fmt.Println(cid)
+	return nil
+}
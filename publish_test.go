@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_publishIpfs(t *testing.T) {
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	journalDir := filepath.Join(dumpDir, "bob")
+	if err := os.Mkdir(journalDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	writeEntry := func(name, subject, security string) {
+		entry := `<?xml version="1.0"?><event><eventtime>2010-05-01 10:00:00</eventtime><subject>` + subject +
+			`</subject><event>text</event><security>` + security + `</security></event>`
+		if err := ioutil.WriteFile(filepath.Join(journalDir, name), []byte(entry), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeEntry("L-1", "Open", "public")
+	writeEntry("L-2", "Secret", "private")
+
+	var added, published []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/v0/add":
+			mr, err := req.MultipartReader()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for part, err := mr.NextPart(); err == nil; part, err = mr.NextPart() {
+				data, _ := ioutil.ReadAll(part)
+				if strings.Contains(string(data), "Secret") {
+					t.Errorf("Private entry was published in %s", part.FileName())
+				}
+				added = append(added, part.FileName())
+			}
+			fmt.Fprintln(w, `{"Name":"ljdump/bob","Hash":"bafybob"}`)
+			fmt.Fprintln(w, `{"Name":"ljdump","Hash":"bafysite"}`)
+		case "/api/v0/name/publish":
+			published = append(published, req.URL.Query().Get("arg"))
+			fmt.Fprintln(w, `{"Name":"k51name","Value":"/ipfs/bafysite"}`)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer server.Close()
+
+	config := &Config{
+		dumpDir:        dumpDir,
+		accountDataDir: filepath.Join(dumpDir, accountDataDirName),
+		commandArg:     "ipfs",
+		journals:       []string{"bob"},
+		journalAliases: make(map[string]string),
+		exportDir:      filepath.Join(dumpDir, "export"),
+		maxSecurity:    securityPrivate,
+		ipfs:           &ipfsConfig{apiUrl: server.URL, ipnsKey: "ljdump"},
+	}
+	if r := runPublish(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(added) == 0 || added[0] != "ljdump" {
+		t.Errorf("Expected the site root as the first part, got %v", added)
+	}
+	if len(published) != 1 || published[0] != "/ipfs/bafysite" {
+		t.Errorf("Expected IPNS publish of the site, got %v", published)
+	}
+	records, r := readPublishRecords(config)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(records) != 1 || records[0].cid != "bafysite" || records[0].name != "k51name" {
+		t.Errorf("Unexpected publish records %v", records)
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The query command prints archived entries that match all terms of the
+// query, for exploring the archive without other tools. The terms are
+//
+//	tag:<tag>             entries with the tag
+//	before:<date>         entries posted before the date like 2010 or 2010-05-01
+//	after:<date>          entries posted after the date
+//	security:<level>      entries with this security, public, friends, custom or private
+//	commenter:<user>      entries with a comment by the user
+//	<key><op><value>      a condition of the collection syntax like year>=2008 or mood~happy
+//	<word>                entries with the word in the subject or the text
+//	                      without HTML markup
+//
+// Terms with spaces are written as Go quoted strings like "tag:new york".
+// -max-security and -public-only limit the entries like with export.
+// The entries are read from the archive files rather than from a database,
+// so there is no SQLite full-text index and raw SQL is not supported.
+
+type entryQuery struct {
+	conditions []searchCondition
+	before     string
+	after      string
+	security   []securityLevel
+	commenters []string
+	words      []string
+}
+
+var queryTermKeys = map[string]bool{"tag": true, "before": true, "after": true, "security": true, "commenter": true}
+
+func parseEntryQuery(s string) (*entryQuery, error) {
+	if lower := strings.ToLower(strings.TrimSpace(s)); strings.HasPrefix(lower, "select ") && strings.Contains(lower, " from ") {
+		return nil, fmt.Errorf("raw SQL is not supported as the archive has no database, use the query terms")
+	}
+	terms, err := splitRuleWords(s)
+	if err != nil {
+		return nil, err
+	}
+	q := &entryQuery{}
+	for _, term := range terms {
+		key, value := "", term
+		if i := strings.Index(term, ":"); i > 0 {
+			key, value = strings.ToLower(term[:i]), term[i+1:]
+		}
+		if queryTermKeys[key] && value == "" {
+			return nil, fmt.Errorf("query term %s has no value", term)
+		}
+		switch key {
+		case "tag":
+			q.conditions = append(q.conditions, searchCondition{"tag", "=", value})
+		case "before":
+			q.before = value
+		case "after":
+			q.after = value
+		case "security":
+			level, err := parseSecurityLevel(value)
+			if err != nil {
+				return nil, err
+			}
+			q.security = append(q.security, level)
+		case "commenter":
+			q.commenters = append(q.commenters, value)
+		default:
+			if search, err := parseSavedSearch("", term); err == nil {
+				q.conditions = append(q.conditions, search.conditions...)
+			} else {
+				q.words = append(q.words, strings.ToLower(term))
+			}
+		}
+	}
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("query is empty")
+	}
+	return q, nil
+}
+
+// Check the terms that need only the entry itself
+func (q *entryQuery) matchesEntry(entry *archivedEntry) bool {
+	for i := range q.conditions {
+		if !q.conditions[i].matches(entry) {
+			return false
+		}
+	}
+	if q.before != "" && entry.eventTime >= q.before {
+		return false
+	}
+	if q.after != "" && (entry.eventTime <= q.after || strings.HasPrefix(entry.eventTime, q.after)) {
+		return false
+	}
+	if len(q.security) != 0 {
+		found := false
+		for _, level := range q.security {
+			found = found || entry.securityLevel() == level
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(q.words) != 0 {
+		text := strings.ToLower(searchText(entry.subject) + "\n" + searchText(entry.event))
+		for _, word := range q.words {
+			if !strings.Contains(text, word) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (q *entryQuery) matchesComments(comments []CommentRecord) bool {
+	for _, commenter := range q.commenters {
+		found := false
+		for i := range comments {
+			c := &comments[i]
+			if strings.EqualFold(c.User, commenter) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Get the text of the entry HTML for word matches with the markup removed
+// and whitespace collapsed, so quoted words match across lines
+func searchText(s string) string {
+	return strings.Join(strings.Fields(htmlToText(s)), " ")
+}
+
+// Get the text around the first query word in the entry
+func (q *entryQuery) snippet(entry *archivedEntry) string {
+	if len(q.words) == 0 {
+		return ""
+	}
+	text := []rune(searchText(entry.event))
+	lower := []rune(strings.ToLower(string(text)))
+	if len(lower) != len(text) {
+		text = lower
+	}
+	word := []rune(q.words[0])
+	for i := 0; i+len(word) <= len(lower); i++ {
+		if string(lower[i:i+len(word)]) != string(word) {
+			continue
+		}
+		start, end := i-40, i+len(word)+40
+		prefix, suffix := "...", "..."
+		if start <= 0 {
+			start, prefix = 0, ""
+		}
+		if end >= len(text) {
+			end, suffix = len(text), ""
+		}
+		return prefix + string(text[start:end]) + suffix
+	}
+	return ""
+}
+
+// Find the matching entries of the journal in chronological order
+func queryJournal(config *Config, dir string, q *entryQuery) ([]*archivedEntry, *Report) {
+	entries, r := readJournalEntries(dir)
+	if r != nil {
+		return nil, r
+	}
+	var matched []*archivedEntry
+	for _, entry := range entries {
+		if entry.securityLevel() > config.maxSecurity || !q.matchesEntry(entry) {
+			continue
+		}
+		if len(q.commenters) != 0 {
+			comments, r := readEntryComments(entry.dir, entry.itemId)
+			if r != nil {
+				return nil, r
+			}
+			if !q.matchesComments(comments) {
+				continue
+			}
+		}
+		matched = append(matched, entry)
+	}
+	config.normalizeEntryTimes(matched)
+	sort.Sort(sortEntriesByTime(matched))
+	return matched, nil
+}
+
+func runQuery(config *Config) *Report {
+	q, err := parseEntryQuery(config.commandArg)
+	if err != nil {
+		return WrapErr(err, "invalid query")
+	}
+	total := 0
+	for _, journal := range config.journals {
+		matched, r := queryJournal(config, config.journalDir(journal), q)
+		if r != nil {
+			return r
+		}
+		for _, entry := range matched {
+			label := ""
+			if level := entry.securityLevel(); level != securityPublic {
+				label = " (" + level.label() + ")"
+			}
+			fmt.Printf("%s/%s  %s  %s%s\n", journal, entry.fileName, entry.eventTime, entryDisplaySubject(entry), label)
+			if snippet := q.snippet(entry); snippet != "" {
+				fmt.Printf("    %s\n", snippet)
+			}
+		}
+		total += len(matched)
+	}
+	fmt.Printf("%d entries\n", total)
+	return nil
+}
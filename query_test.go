@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_queryJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"L-1": `<?xml version="1.0"?><event><itemid>1</itemid><eventtime>2009-12-31 23:00:00</eventtime><subject>Trip</subject>` +
+			`<event>We went to New York by train</event><props><taglist>travel, usa</taglist></props></event>`,
+		"C-1": `<?xml version="1.0"?><comments><comment><id>5</id><user>alice</user><body>Nice</body></comment></comments>`,
+		"L-2": `<?xml version="1.0"?><event><itemid>2</itemid><eventtime>2010-01-01 10:00:00</eventtime><subject>Home</subject>` +
+			`<event>Back by train</event><security>private</security><props><taglist>travel</taglist></props></event>`,
+		"L-3": `<?xml version="1.0"?><event><itemid>3</itemid><eventtime>2010-06-01 10:00:00</eventtime><subject>Music</subject>` +
+			`<event>Concert</event><props><current_mood>happy</current_mood></props></event>`,
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for query, expected := range map[string]string{
+		"tag:travel":                 "L-1 L-2",
+		"tag:travel before:2010":     "L-1",
+		"after:2009-12-31":           "L-2 L-3",
+		"after:2010-01":              "L-3",
+		"security:private":           "L-2",
+		"commenter:alice":            "L-1",
+		"commenter:bob":              "",
+		"train":                      "L-1 L-2",
+		`"new york" TRAIN`:           "L-1",
+		"mood=happy year>=2010":      "L-3",
+		"tag:travel security:public": "L-1",
+		"security:public security:private tag:travel": "L-1 L-2",
+	} {
+		q, err := parseEntryQuery(query)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %s", query, err)
+		}
+		matched, r := queryJournal(&Config{maxSecurity: securityPrivate}, dir, q)
+		if r != nil {
+			t.Fatal(r.AsText())
+		}
+		got := ""
+		for _, entry := range matched {
+			if got != "" {
+				got += " "
+			}
+			got += entry.fileName
+		}
+		if got != expected {
+			t.Errorf("Query %s matched %q instead of %q", query, got, expected)
+		}
+	}
+	for _, bad := range []string{"", "tag:", "security:secret", "SELECT * FROM entries"} {
+		if _, err := parseEntryQuery(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+
+	q, _ := parseEntryQuery(`york "new york" &`)
+	entry := &archivedEntry{event: "We went to <b>New\nYork</b> by train &amp; bus"}
+	if !q.matchesEntry(entry) {
+		t.Errorf("Expected a match on the text without markup")
+	}
+	if s := q.snippet(entry); s != "We went to New York by train & bus" {
+		t.Errorf("Unexpected snippet %q", s)
+	}
+	q, _ = parseEntryQuery("href")
+	if q.matchesEntry(&archivedEntry{event: `<a href="https://example.com">link</a>`}) {
+		t.Errorf("Expected no match in the markup")
+	}
+}
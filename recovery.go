@@ -0,0 +1,167 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Recovery of corrupt journal and account DB files enabled with -recover.
+// The corrupt file is kept next to the original under a new name, the data
+// read before the parse error are salvaged when they look sane and the rest
+// is rebuilt from the archived files. The dump then continues, possibly
+// downloading again the data that could not be recovered.
+
+// Move the corrupt DB file out of the way keeping it for manual inspection.
+func quarantineCorruptDB(config *Config, dbpath string, parseErr error) *Report {
+	quarantined := dbpath + ".corrupt-" + time.Now().UTC().Format("20060102-150405")
+	if err := archiveStore.Rename(dbpath, quarantined); err != nil {
+		return WrapErr(err, "failed to move corrupt DB file %s", dbpath)
+	}
+	config.warn("%s is corrupt - %s", dbpath, parseErr.Error())
+	config.warn("moved the corrupt file to %s", quarantined)
+	return nil
+}
+
+var commentFileNamePattern = regexp.MustCompile(`^C-[0-9]+$`)
+
+// Rebuild the journal DB after parseJournalDB failed on jcx.db.
+func recoverJournalDB(jcx *journalContext, dbpath string, parseErr error) *Report {
+	if r := quarantineCorruptDB(jcx.config, dbpath, parseErr); r != nil {
+		return r
+	}
+	if err := migrateJournalDB(&jcx.db, jcx.db.schemaVersion); err != nil {
+		return WrapErr(err, "cannot recover journal db file %s", dbpath)
+	}
+
+	// A row cut by the parse error leaves an empty name
+	for userId, user := range jcx.db.userMap {
+		if user == "" {
+			delete(jcx.db.userMap, userId)
+		}
+	}
+	actions := jcx.db.syncActions[:0]
+	for _, a := range jcx.db.syncActions {
+		if a.time != "" {
+			actions = append(actions, a)
+		}
+	}
+	jcx.db.syncActions = actions
+	salvagedUsers, salvagedComments := len(jcx.db.userMap), len(jcx.db.commentMap)
+	if jcx.db.lastSync != "" {
+		if _, err := time.Parse("2006-01-02 15:04:05", jcx.db.lastSync); err != nil {
+			jcx.db.lastSync = ""
+		}
+	}
+
+	// Comments with stored bodies tell where to continue the comment
+	// download. Poster ids are not archived so the users map can only
+	// come from the corrupt file.
+	files, err := listDumpFiles(jcx.dir)
+	if err != nil {
+		return WrapErr(err, "")
+	}
+	if !isJournalLayout(jcx.db.layout) {
+		jcx.db.layout = flatLayout
+	}
+	fromFiles, brokenFiles := 0, 0
+	for _, file := range files {
+		name := filepath.Base(file)
+		if filepath.Dir(file) != "." {
+			// Only the year-month layout has files in subdirectories
+			jcx.db.layout = yearMonthLayout
+		}
+		if !commentFileNamePattern.MatchString(name) {
+			continue
+		}
+		itemId, _ := strconv.ParseInt(name[2:], 10, 64)
+		comments, r := readEntryComments(filepath.Join(jcx.dir, filepath.Dir(file)), itemId)
+		if r != nil {
+			jcx.config.warn("skipping comments that cannot be read - %s", r.AsText())
+			brokenFiles++
+			continue
+		}
+		for _, c := range comments {
+			jcx.db.commentItems[c.Id] = itemId
+			meta, present := jcx.db.commentMap[c.Id]
+			if !present {
+				fromFiles++
+			}
+			if meta.state == "" {
+				meta.state = c.State
+			}
+			jcx.db.commentMap[c.Id] = meta
+		}
+	}
+	jcx.db.rebuildCommentItems = false
+	jcx.commentItemsChanged = true
+
+	jcx.config.warn("recovered %d user names and %d comment records from the corrupt journal DB of %s",
+		salvagedUsers, salvagedComments, jcx.name)
+	jcx.config.warn("restored %d comment records from archived comment files", fromFiles)
+	if brokenFiles != 0 {
+		jcx.config.warn("%d comment files could not be read, run verify to check them", brokenFiles)
+	}
+	if jcx.db.lastSync == "" {
+		jcx.config.warn("the last sync time of %s was lost, all entries will be downloaded again", jcx.name)
+	} else {
+		jcx.config.warn("recovered the last sync time %s", jcx.db.lastSync)
+	}
+	if jcx.db.journalUserId == 0 {
+		jcx.config.warn("the userid of %s was lost and will be fetched again", jcx.name)
+	}
+	return writeJournalDB(jcx)
+}
+
+var userPictureFilePattern = regexp.MustCompile(`^user-picture-([0-9]+)`)
+
+// Rebuild the account data after readAccountData failed to parse it.
+func recoverAccountData(
+	config *Config, accountData *accountData, schemaVersion int, dbpath string, parseErr error,
+) (*accountData, *Report) {
+	if r := quarantineCorruptDB(config, dbpath, parseErr); r != nil {
+		return nil, r
+	}
+	if err := migrateAccountData(accountData, schemaVersion); err != nil {
+		return nil, WrapErr(err, "cannot recover account data file %s", dbpath)
+	}
+
+	// Never reuse names of existing picture files
+	names, err := archiveStore.ReadDir(config.accountDataDir)
+	if err != nil {
+		return nil, WrapErr(err, "")
+	}
+	pictureFiles := make(map[string]bool)
+	for _, info := range names {
+		m := userPictureFilePattern.FindStringSubmatch(info.Name())
+		if m == nil {
+			continue
+		}
+		pictureFiles[info.Name()] = true
+		if n, err := strconv.Atoi(m[1]); err == nil && n > accountData.fileCounter {
+			accountData.fileCounter = n
+		}
+	}
+
+	dropped := 0
+	for url, file := range accountData.pictureUrlFileMap {
+		if !pictureFiles[file] {
+			delete(accountData.pictureUrlFileMap, url)
+			dropped++
+		}
+	}
+	config.warn("recovered %d of %d userpic files, %d keywords and %d keyword history records from the corrupt account DB",
+		len(accountData.pictureUrlFileMap), len(pictureFiles), len(accountData.pictureKeywordUrlMap),
+		len(accountData.pictureHistory))
+	if dropped != 0 {
+		config.warn("dropped %d userpic records with missing or damaged file names", dropped)
+	}
+	if len(accountData.pictureUrlFileMap) < len(pictureFiles) {
+		config.warn("userpics with unknown URLs will be downloaded again into new files")
+	}
+	if r := writeAccountData(accountData, config); r != nil {
+		return nil, r
+	}
+	return accountData, nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"linedb"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The file in the dump directory with the map from journal names to
+// directories for journals that were renamed on the server or whose names
+// are not used as directory names as is.
+const journalAliasesFileName = "journal-aliases.linedb"
+
+func readJournalAliases(dumpDir string) (map[string]string, *Report) {
+	aliases := make(map[string]string)
+	dbpath := filepath.Join(dumpDir, journalAliasesFileName)
+	dbdata, err := archiveStore.ReadFile(dbpath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, WrapErr(err, "")
+		}
+		return aliases, nil
+	}
+	d := newLinedbDecoder(dbdata)
+	for d.NextItem() {
+		if d.ItemKind == linedb.TableItem {
+			for d.NextRow() {
+				switch d.ItemName {
+				case "aliases":
+					journal, dir := d.GetString(), d.GetString()
+
+					// The directory must be a single name inside the dump
+					// directory
+					if err := checkJournalName(journal); err != nil {
+						return nil, ReportMsg("%s in journal aliases file %s", err.Error(), dbpath)
+					}
+					if dir == "" || dir == "." || dir == ".." || strings.ContainsAny(dir, `/\:`) {
+						return nil, ReportMsg("invalid directory '%s' of journal %s in journal aliases file %s", dir, journal, dbpath)
+					}
+					aliases[journal] = dir
+				}
+			}
+		}
+	}
+	if err := d.GetError(); err != nil {
+		return nil, WrapErr(err, "error while parsing journal aliases file %s as linedb", dbpath)
+	}
+	return aliases, nil
+}
+
+func writeJournalAliases(config *Config) *Report {
+	e := linedb.NewByteEncoder()
+	e.Comment("map from journal name to archive directory of renamed or converted journals")
+	addSortedMapKeyValue(e, "aliases", config.journalAliases)
+	dbpath := filepath.Join(config.dumpDir, journalAliasesFileName)
+	if err := writeFileTempRename(dbpath, e.GetBytes()); err != nil {
+		return WrapErr(err, "failed to write journal aliases file %s", dbpath)
+	}
+	return nil
+}
+
+// Get the directory with the archive of the journal taking aliases into
+// account.
+func (config *Config) journalDir(journal string) string {
+	if dir := config.journalAliases[journal]; dir != "" {
+		return filepath.Join(config.dumpDir, dir)
+	}
+	return filepath.Join(config.dumpDir, config.journalDirName(journal))
+}
+
+type journalIdentity struct {
+	userId UserId
+	name   string
+}
+
+// Query the server for the current name and, when the server publishes
+// it, the userid of the journal. The FOAF data is used as it is available
+// for both personal journals and communities and the server redirects
+// the old name of a renamed journal to the new one.
+func fetchJournalIdentity(session *ljSession, journal string) (journalIdentity, *Report) {
+	var identity journalIdentity
+	geturl := session.config.server + "/users/" + journal + "/data/foaf"
+	resp, err := session.client.Get(geturl)
+	if err != nil {
+		return identity, WrapErr(err, "failed to get %s", geturl)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return identity, ReportMsg("unexpected status %s for %s", resp.Status, geturl)
+	}
+
+	// Take the first nick and userid elements as those belong to the
+	// journal, not to its friends listed later.
+	d := xml.NewDecoder(resp.Body)
+	d.Strict = false
+	var text string
+	for identity.name == "" || identity.userId == 0 {
+		token, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return identity, WrapErr(err, "failed to parse FOAF data from %s", geturl)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			text = ""
+		case xml.CharData:
+			text += string(t)
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "nick":
+				if identity.name == "" {
+					identity.name = strings.TrimSpace(text)
+				}
+			case "userid":
+				if identity.userId == 0 {
+					identity.userId, _ = parseUserId(strings.TrimSpace(text))
+				}
+			}
+		}
+	}
+	return identity, nil
+}
+
+// Read the journal userid from the journal DB file or return 0 if the
+// file does not exist or does not record it.
+func readJournalDBUserId(dbpath string) UserId {
+	dbdata, err := archiveStore.ReadFile(dbpath)
+	if err != nil {
+		return 0
+	}
+	var db journalDB
+	if parseJournalDB(dbdata, &db) != nil {
+		return 0
+	}
+	return db.journalUserId
+}
+
+// Find the archive directory of another journal with the given userid.
+func findJournalDirByUserId(config *Config, userId UserId, excludeDir string) string {
+	fileInfos, err := archiveStore.ReadDir(config.dumpDir)
+	if err != nil {
+		return ""
+	}
+	names := make([]string, 0, len(fileInfos))
+	for _, fileInfo := range fileInfos {
+		if fileInfo.IsDir() {
+			names = append(names, fileInfo.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		dir := filepath.Join(config.dumpDir, name)
+		if dir == excludeDir {
+			continue
+		}
+		if readJournalDBUserId(filepath.Join(dir, journalDBFileName)) == userId {
+			return dir
+		}
+	}
+	return ""
+}
+
+// Make the archive in oldDir available under the journal name either by
+// renaming the directory or by recording an alias.
+func moveJournalArchive(jcx *journalContext, oldDir string) *Report {
+	config := jcx.config
+	newDir := filepath.Join(config.dumpDir, config.journalDirName(jcx.name))
+	if config.renameJournalDirs {
+		if _, err := archiveStore.Stat(newDir); err == nil {
+			return ReportMsg("cannot rename %s to %s as the latter already exists", oldDir, newDir)
+		}
+		log("Renaming archive directory %s to %s", oldDir, newDir)
+		if err := archiveStore.Rename(oldDir, newDir); err != nil {
+			return WrapErr(err, "failed to rename %s to %s", oldDir, newDir)
+		}
+		delete(config.journalAliases, jcx.name)
+		jcx.dir = newDir
+	} else {
+		rel, err := filepath.Rel(config.dumpDir, oldDir)
+		if err != nil {
+			return WrapErr(err, "")
+		}
+		log("Recording %s as the archive directory for %s in %s, use -rename-journal-dirs to rename the directory instead",
+			oldDir, jcx.name, journalAliasesFileName)
+		config.journalAliases[jcx.name] = filepath.ToSlash(rel)
+		jcx.dir = oldDir
+	}
+	return writeJournalAliases(config)
+}
+
+// Compare the journal identity on the server with the one recorded in the
+// journal DB. This is called after the journal DB is read and may switch
+// jcx to the new name and directory of a renamed journal.
+func checkJournalRename(jcx *journalContext) *Report {
+	identity, r := fetchJournalIdentity(jcx.session, jcx.name)
+	if r != nil {
+		jcx.config.warn("cannot check if journal %s was renamed\n%s", jcx.name, r.AsText())
+		return nil
+	}
+
+	if jcx.db.journalUserId == 0 && jcx.db.lastSync == "" && identity.userId != 0 {
+		// Nothing archived under this name yet. Check if the journal was
+		// archived under its old name.
+		if oldDir := findJournalDirByUserId(jcx.config, identity.userId, jcx.dir); oldDir != "" {
+			log("Journal %s was archived in %s under its old name", jcx.name, oldDir)
+			if r := moveJournalArchive(jcx, oldDir); r != nil {
+				return r
+			}
+			if r := readJournalDB(jcx); r != nil {
+				return r
+			}
+		}
+	}
+
+	if jcx.db.journalUserId != 0 && identity.userId != 0 && jcx.db.journalUserId != identity.userId {
+		return ReportMsg(
+			"journal %s on the server has userid %d while the archive in %s has userid %d. The name was likely taken by another account after a rename, update the configuration with the new name of the journal",
+			jcx.name, identity.userId, jcx.dir, jcx.db.journalUserId,
+		)
+	}
+
+	// The server ignores case and treats - as _ in names
+	renamed := identity.name != "" && canonicalJournalName(identity.name) != canonicalJournalName(jcx.name)
+	if renamed && checkJournalName(identity.name) != nil {
+		jcx.config.warn("ignoring invalid name %s of journal %s on the server", identity.name, jcx.name)
+	} else if renamed {
+		jcx.config.warn("journal %s was renamed to %s on the server, update the configuration", jcx.name, identity.name)
+		oldDir := jcx.dir
+		jcx.name = identity.name
+		if r := moveJournalArchive(jcx, oldDir); r != nil {
+			return r
+		}
+	}
+
+	if jcx.db.journalUserId == 0 && identity.userId != 0 {
+		jcx.db.journalUserId = identity.userId
+		jcx.shouldWriteDB = true
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type foafIdentity struct {
+	nick   string
+	userId UserId
+}
+
+// Serve FOAF data for the journals in identities
+func newFoafServer(identities map[string]foafIdentity) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		journal := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/users/"), "/data/foaf")
+		identity, present := identities[journal]
+		if !present {
+			http.NotFound(w, req)
+			return
+		}
+		fmt.Fprintf(w, `<?xml version="1.0"?><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns:foaf="http://xmlns.com/foaf/0.1/" xmlns:ya="http://blogs.yandex.ru/schema/foaf/"><foaf:Person><foaf:nick>%s</foaf:nick><ya:userid>%d</ya:userid></foaf:Person></rdf:RDF>`,
+			identity.nick, identity.userId)
+	}))
+}
+
+// Make a journal context for the journal with an archive that records the
+// userid
+func newRenameTestJournal(t *testing.T, config *Config, journal string, userId UserId) *journalContext {
+	session := &ljSession{config: config}
+	jcx := newJournalContext(session, journal)
+	if err := os.MkdirAll(jcx.dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if userId != 0 {
+		jcx.db = journalDB{schemaVersion: journalDBSchemaVersion, layout: flatLayout, lastSync: "2020-01-01 10:00:00", journalUserId: userId}
+		if r := writeJournalDB(jcx); r != nil {
+			t.Fatal(r.AsText())
+		}
+	}
+	if r := readJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	return jcx
+}
+
+func Test_checkJournalRename(t *testing.T) {
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	ts := newFoafServer(map[string]foafIdentity{
+		"bob":     {"robert", 5},
+		"Foo-Bar": {"foo_bar", 6},
+		"alice":   {"../alice", 7},
+	})
+	defer ts.Close()
+	config := &Config{server: ts.URL, dumpDir: dumpDir, journalAliases: make(map[string]string), renameJournalDirs: true}
+
+	jcx := newRenameTestJournal(t, config, "bob", 5)
+	if r := checkJournalRename(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if jcx.name != "robert" || jcx.dir != filepath.Join(dumpDir, "robert") {
+		t.Errorf("Expected the archive under the new name, got %s in %s", jcx.name, jcx.dir)
+	}
+	if _, err := os.Stat(filepath.Join(dumpDir, "robert", journalDBFileName)); err != nil {
+		t.Errorf("Expected the renamed directory - %s", err.Error())
+	}
+
+	// Names that differ only in case or - and _ are the same journal
+	jcx = newRenameTestJournal(t, config, "Foo-Bar", 6)
+	dir := jcx.dir
+	if r := checkJournalRename(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if jcx.name != "Foo-Bar" || jcx.dir != dir || len(config.journalAliases) != 0 {
+		t.Errorf("Unexpected rename to %s in %s with aliases %v", jcx.name, jcx.dir, config.journalAliases)
+	}
+
+	jcx = newRenameTestJournal(t, config, "alice", 7)
+	if r := checkJournalRename(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if jcx.name != "alice" || len(config.journalAliases) != 0 {
+		t.Errorf("Expected the invalid name to be ignored, got %s", jcx.name)
+	}
+}
+
+func Test_checkJournalRenameAlias(t *testing.T) {
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	ts := newFoafServer(map[string]foafIdentity{"bob": {"robert", 5}})
+	defer ts.Close()
+	config := &Config{server: ts.URL, dumpDir: dumpDir, journalAliases: make(map[string]string)}
+
+	jcx := newRenameTestJournal(t, config, "bob", 5)
+	if r := checkJournalRename(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if jcx.name != "robert" || jcx.dir != filepath.Join(dumpDir, "bob") {
+		t.Errorf("Expected the old directory under the new name, got %s in %s", jcx.name, jcx.dir)
+	}
+	aliases, r := readJournalAliases(dumpDir)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if aliases["robert"] != "bob" {
+		t.Errorf("Unexpected aliases %v", aliases)
+	}
+}
+
+func Test_journalUserId(t *testing.T) {
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	identities := map[string]foafIdentity{"bob": {"bob", 5}}
+	ts := newFoafServer(identities)
+	defer ts.Close()
+	config := &Config{server: ts.URL, dumpDir: dumpDir, journalAliases: make(map[string]string)}
+
+	// The first dump records the userid
+	jcx := newRenameTestJournal(t, config, "bob", 0)
+	if r := checkJournalRename(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if jcx.db.journalUserId != 5 || !jcx.shouldWriteDB {
+		t.Fatalf("Expected the userid to be recorded, got %d", jcx.db.journalUserId)
+	}
+	jcx.db.lastSync = "2020-01-01 10:00:00"
+	if r := writeJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+
+	// Later runs compare the server with the recorded userid
+	jcx = newJournalContext(&ljSession{config: config}, "bob")
+	if r := readJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	jcx.shouldWriteDB = false
+	if r := checkJournalRename(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if jcx.db.journalUserId != 5 || jcx.shouldWriteDB {
+		t.Errorf("Expected the recorded userid to be kept, got %d", jcx.db.journalUserId)
+	}
+	identities["bob"] = foafIdentity{"bob", 9}
+	if r := checkJournalRename(jcx); r == nil {
+		t.Errorf("Expected an error for a journal name taken by another account")
+	}
+}
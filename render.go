@@ -0,0 +1,305 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Conversion of archived entry and comment bodies into clean HTML for the
+// exports and serve. The archived files keep the original text. LJ bodies
+// use LJ markup: newlines become line breaks unless the entry has
+// opt_preformatted, <lj user> and <lj comm> refer to journals, <lj-cut>
+// hides the rest of the entry on friend pages and <lj-embed> and <lj-poll>
+// refer to content stored separately on the server. Users also type
+// unclosed and stray tags that would break the page around the body, and
+// the body may come from anyone who commented, so only whitelisted
+// elements and attributes are kept.
+
+var htmlTokenPattern = regexp.MustCompile(
+	`(?s)<!--.*?-->|<(/?)([a-zA-Z][a-zA-Z0-9:_-]*)((?:[^>"']|"[^"]*"|'[^']*')*?)\s*(/?)>`,
+)
+var htmlAttrPattern = regexp.MustCompile(`(?i)([a-z][a-z0-9_:-]*)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+
+var voidHtmlElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// Elements around which newlines are layout of the source, not breaks
+var blockHtmlElements = map[string]bool{
+	"blockquote": true, "dd": true, "div": true, "dl": true, "dt": true, "h1": true, "h2": true,
+	"h3": true, "h4": true, "h5": true, "h6": true, "hr": true, "li": true, "ol": true, "p": true,
+	"pre": true, "table": true, "tbody": true, "td": true, "tfoot": true, "th": true, "thead": true,
+	"tr": true, "ul": true,
+}
+
+// Elements dropped with their content
+var droppedHtmlElements = map[string]bool{
+	"applet": true, "iframe": true, "math": true, "noembed": true, "noframes": true,
+	"noscript": true, "object": true, "script": true, "select": true, "style": true,
+	"svg": true, "template": true, "textarea": true, "title": true, "xmp": true,
+}
+
+// Elements that are kept with the allowed attributes. Tags of other
+// elements are dropped while their content is kept.
+var allowedHtmlElements = map[string]bool{
+	"a": true, "abbr": true, "acronym": true, "address": true, "b": true, "big": true,
+	"blockquote": true, "br": true, "caption": true, "center": true, "cite": true, "code": true,
+	"col": true, "colgroup": true, "dd": true, "del": true, "dfn": true, "div": true, "dl": true,
+	"dt": true, "em": true, "font": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "hr": true, "i": true, "img": true, "ins": true, "kbd": true,
+	"li": true, "ol": true, "p": true, "pre": true, "q": true, "s": true, "samp": true,
+	"small": true, "span": true, "strike": true, "strong": true, "sub": true, "sup": true,
+	"table": true, "tbody": true, "td": true, "tfoot": true, "th": true, "thead": true,
+	"tr": true, "tt": true, "u": true, "ul": true, "var": true,
+}
+
+// Attributes kept on any allowed element
+var allowedHtmlAttrs = map[string]bool{
+	"align": true, "alt": true, "border": true, "cellpadding": true, "cellspacing": true,
+	"color": true, "colspan": true, "dir": true, "face": true, "height": true, "lang": true,
+	"name": true, "rowspan": true, "size": true, "title": true, "valign": true, "width": true,
+}
+
+// Attributes with URLs, kept only for http, https, mailto and relative
+// URLs
+var urlHtmlAttrs = map[string]bool{
+	"cite": true, "href": true, "src": true,
+}
+
+type htmlToken struct {
+	// Text or the whole tag
+	text string
+
+	// Lower case element name, empty for text and comments
+	name        string
+	closing     bool
+	selfClosing bool
+	attrs       string
+}
+
+func tokenizeHtml(s string) []htmlToken {
+	var tokens []htmlToken
+	pos := 0
+	for _, m := range htmlTokenPattern.FindAllStringSubmatchIndex(s, -1) {
+		if m[0] > pos {
+			tokens = append(tokens, htmlToken{text: s[pos:m[0]]})
+		}
+		t := htmlToken{text: s[m[0]:m[1]]}
+		if m[4] >= 0 {
+			t.closing = m[3] > m[2]
+			t.name = strings.ToLower(s[m[4]:m[5]])
+			t.attrs = s[m[6]:m[7]]
+			t.selfClosing = m[9] > m[8]
+		}
+		tokens = append(tokens, t)
+		pos = m[1]
+	}
+	if pos < len(s) {
+		tokens = append(tokens, htmlToken{text: s[pos:]})
+	}
+	return tokens
+}
+
+func htmlAttr(attrs string, name string) string {
+	for _, m := range htmlAttrPattern.FindAllStringSubmatch(attrs, -1) {
+		if strings.EqualFold(m[1], name) {
+			return html.UnescapeString(m[2] + m[3] + m[4])
+		}
+	}
+	return ""
+}
+
+// Check that the attribute value with entities already decoded is a URL
+// that cannot run a script. Browsers ignore tabs and newlines in URLs and
+// leading and trailing spaces and control characters, so those cannot
+// hide the scheme.
+func isSafeHtmlUrl(value string) bool {
+	value = strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return -1
+		}
+		return r
+	}, value)
+	value = strings.TrimFunc(value, func(r rune) bool { return r <= ' ' })
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "", "http", "https", "mailto":
+		return true
+	}
+	return false
+}
+
+// Rebuild the attributes of an allowed element keeping only the allowed
+// ones with re-escaped values
+func sanitizeHtmlAttrs(attrs string) string {
+	var out strings.Builder
+	for _, m := range htmlAttrPattern.FindAllStringSubmatch(attrs, -1) {
+		name := strings.ToLower(m[1])
+		value := html.UnescapeString(m[2] + m[3] + m[4])
+		if urlHtmlAttrs[name] {
+			if !isSafeHtmlUrl(value) {
+				continue
+			}
+		} else if !allowedHtmlAttrs[name] {
+			continue
+		}
+		fmt.Fprintf(&out, ` %s="%s"`, name, html.EscapeString(value))
+	}
+	return out.String()
+}
+
+func isBlockToken(t *htmlToken) bool {
+	return t != nil && blockHtmlElements[t.name]
+}
+
+// Convert the LJ markup of the body into HTML where all tags are closed.
+// The server is used for links to journals.
+func normalizeLJHtml(server string, body string, preformatted bool) string {
+	tokens := tokenizeHtml(strings.Replace(body, "\r\n", "\n", -1))
+	var out strings.Builder
+	var open []string
+	pre, raw, cuts := 0, 0, 0
+	for i := 0; i < len(tokens); i++ {
+		t := &tokens[i]
+		if t.name == "" {
+			if strings.HasPrefix(t.text, "<!--") {
+				// Browsers end comments in more ways than the pattern
+				continue
+			}
+			// A < that did not start a recognized tag could still
+			// start one for the browser
+			t.text = strings.Replace(t.text, "<", "&lt;", -1)
+			if preformatted || pre != 0 || raw != 0 {
+				out.WriteString(t.text)
+				continue
+			}
+			var prev, next *htmlToken
+			if i > 0 {
+				prev = &tokens[i-1]
+			}
+			if i+1 < len(tokens) {
+				next = &tokens[i+1]
+			}
+			// Keep newlines next to block elements as they are
+			text, leading, trailing := t.text, "", ""
+			if isBlockToken(prev) && strings.HasPrefix(text, "\n") {
+				text, leading = text[1:], "\n"
+			}
+			if isBlockToken(next) && strings.HasSuffix(text, "\n") {
+				text, trailing = text[:len(text)-1], "\n"
+			}
+			out.WriteString(leading)
+			out.WriteString(strings.Replace(text, "\n", "<br />\n", -1))
+			out.WriteString(trailing)
+			continue
+		}
+		switch {
+		case droppedHtmlElements[t.name]:
+			if !t.closing && !t.selfClosing {
+				for i+1 < len(tokens) && !(tokens[i+1].closing && tokens[i+1].name == t.name) {
+					i++
+				}
+				i++
+			}
+			continue
+		case t.name == "lj":
+			if user := htmlAttr(t.attrs, "user") + htmlAttr(t.attrs, "comm"); user != "" && !t.closing {
+				fmt.Fprintf(&out, `<a class="lj-user" href="%s">%s</a>`,
+					html.EscapeString(openIdIdentity(server, user)), html.EscapeString(user))
+			}
+			continue
+		case t.name == "lj-cut":
+			if !t.closing {
+				cuts++
+				fmt.Fprintf(&out, `<a name="cutid%d"></a>`, cuts)
+			}
+			continue
+		case t.name == "lj-raw":
+			if t.closing {
+				if raw > 0 {
+					raw--
+				}
+			} else if !t.selfClosing {
+				raw++
+			}
+			continue
+		case t.name == "lj-embed":
+			if !t.closing {
+				fmt.Fprintf(&out, `<div class="lj-embed">[embedded content %s]</div>`, html.EscapeString(htmlAttr(t.attrs, "id")))
+			}
+			continue
+		case strings.HasPrefix(t.name, "lj-poll"):
+			if !t.closing {
+				out.WriteString(`<div class="lj-poll">[poll]</div>`)
+			}
+			continue
+		case strings.HasPrefix(t.name, "lj"):
+			// Other LJ tags like lj-spoiler or lj-template only wrap
+			// the content
+			continue
+		}
+
+		if !allowedHtmlElements[t.name] {
+			continue
+		}
+		if t.closing {
+			found := -1
+			for j := len(open) - 1; j >= 0; j-- {
+				if open[j] == t.name {
+					found = j
+					break
+				}
+			}
+			if found < 0 {
+				// Stray closing tag
+				continue
+			}
+			for j := len(open) - 1; j >= found; j-- {
+				out.WriteString("</" + open[j] + ">")
+			}
+			open = open[:found]
+			if t.name == "pre" && pre > 0 {
+				pre--
+			}
+			continue
+		}
+		out.WriteString("<" + t.name + sanitizeHtmlAttrs(t.attrs))
+		if voidHtmlElements[t.name] {
+			out.WriteString(" />")
+			continue
+		}
+		if t.selfClosing {
+			// Write <b/> or <div/> as an empty element as the slash is
+			// not valid HTML for elements that are not void
+			out.WriteString("></" + t.name + ">")
+			continue
+		}
+		out.WriteString(">")
+		open = append(open, t.name)
+		if t.name == "pre" {
+			pre++
+		}
+	}
+	for j := len(open) - 1; j >= 0; j-- {
+		out.WriteString("</" + open[j] + ">")
+	}
+	return out.String()
+}
+
+// Get the body of the entry as HTML for rendering
+func entryHtml(config *Config, entry *archivedEntry) string {
+	return normalizeLJHtml(config.server, entry.event, entry.props["opt_preformatted"] == "1")
+}
+
+// Get the body of the comment as HTML for rendering. Comments are always
+// autoformatted.
+func commentHtml(config *Config, c *CommentRecord) string {
+	return normalizeLJHtml(config.server, c.Body, false)
+}
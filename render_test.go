@@ -0,0 +1,66 @@
+package main
+
+import "testing"
+
+func Test_normalizeLJHtml(t *testing.T) {
+	cases := []struct {
+		body         string
+		preformatted bool
+		expected     string
+	}{
+		{"line 1\nline 2", false, "line 1<br />\nline 2"},
+		{"line 1\nline 2", true, "line 1\nline 2"},
+		{"<p>para</p>\n<ul>\n<li>a</li>\n</ul>\n", false, "<p>para</p>\n<ul>\n<li>a</li>\n</ul>\n"},
+		{"<pre>a\nb</pre>", false, "<pre>a\nb</pre>"},
+		{"<lj-raw>a\nb</lj-raw>", false, "a\nb"},
+		{`hi <lj user="bob">`, false, `hi <a class="lj-user" href="https://www.example.com/users/bob/">bob</a>`},
+		{`<lj-cut text="more">hidden</lj-cut>`, false, `<a name="cutid1"></a>hidden`},
+		{`<lj-embed id="3" />`, false, `<div class="lj-embed">[embedded content 3]</div>`},
+		{"<b>bold <i>both</b> rest", false, "<b>bold <i>both</i></b> rest"},
+		{"text</div> <em>open", false, "text <em>open</em>"},
+		{"a<br>b<img src=x.png>", false, `a<br />b<img src="x.png" />`},
+		{"a<b/>b<div />c<br/>", false, "a<b></b>b<div></div>c<br />"},
+		{`<script>alert(1)</script><a href="javascript:alert(1)" onclick='x()'>x</a>`, false, "<a>x</a>"},
+		{"<!-- a\nb --><style>p{}</style>", false, ""},
+		{`<A HREF="https://example.com/?a=1&amp;b=2" Title='t"'>x</A>`, false, `<a href="https://example.com/?a=1&amp;b=2" title="t&#34;">x</a>`},
+		{`<a href="/users/bob/">x</a><a href="mailto:bob@example.com">y</a>`, false, `<a href="/users/bob/">x</a><a href="mailto:bob@example.com">y</a>`},
+	}
+	for _, c := range cases {
+		if html := normalizeLJHtml("https://www.example.com", c.body, c.preformatted); html != c.expected {
+			t.Errorf("For %q expected %q, got %q", c.body, c.expected, html)
+		}
+	}
+}
+
+func Test_normalizeLJHtmlBypasses(t *testing.T) {
+	cases := []struct {
+		body     string
+		expected string
+	}{
+		{`<a href="java&#x73;cript:alert(1)">x</a>`, "<a>x</a>"},
+		{`<a href="&#106;avascript:alert(1)">x</a>`, "<a>x</a>"},
+		{"<a href=\" \tjava\tscript:alert(1)\">x</a>", "<a>x</a>"},
+		{`<a href="jav&#x0A;ascript:alert(1)">x</a>`, "<a>x</a>"},
+		{`<a href="JavaScript:alert(1)">x</a>`, "<a>x</a>"},
+		{`<a href="vbscript:msgbox(1)">x</a>`, "<a>x</a>"},
+		{`<img src="data:text/html;base64,PHNjcmlwdD4=">`, "<img />"},
+		{`<a href=data:text/html,x>x</a>`, "<a>x</a>"},
+		{`<svg onload="alert(1)"><circle /></svg>after`, "after"},
+		{`<svg/onload=alert(1)>x</svg>`, ""},
+		{`<iframe srcdoc="&lt;script&gt;alert(1)&lt;/script&gt;"></iframe>x`, "x"},
+		{`<style>body{background:url(javascript:alert(1))}</style>x`, "x"},
+		{`<p style="background:url(javascript:alert(1))">x</p>`, "<p>x</p>"},
+		{`<img src=x onerror=alert(1)>`, `<img src="x" />`},
+		{`<img/src="x"/onerror="alert(1)">`, `<img src="x" />`},
+		{`<div title="a" ONMOUSEOVER="alert(1)">x</div>`, `<div title="a">x</div>`},
+		{`<form action="https://evil.example.com"><input name="p"><button>go</button></form>`, "go"},
+		{`<meta http-equiv="refresh" content="0;url=javascript:alert(1)">x`, "x"},
+		{`<!--x--!><img src=x onerror=alert(1)>-->`, ""},
+		{`<img src="x" alt='>' onerror="alert(1)"`, `&lt;img src="x" alt='>' onerror="alert(1)"`},
+	}
+	for _, c := range cases {
+		if html := normalizeLJHtml("https://www.example.com", c.body, false); html != c.expected {
+			t.Errorf("For %q expected %q, got %q", c.body, c.expected, html)
+		}
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"linedb"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The restore command posts archived entries with LJ.XMLRPC.postevent into
+// a journal on another server running the LJ code like Dreamwidth or a
+// self-hosted clone. Restored entries are recorded in restore.linedb of the
+// journal directory with their new ids so a repeated or interrupted run
+// continues without duplicates.
+//
+// Entries are posted as backdated so the server accepts old dates and does
+// not show them on friends pages. Friend groups do not exist on the target
+// server, so entries limited to custom groups are posted as private.
+//
+// The protocol cannot post comments on behalf of other people. With
+// -restore-comments the comments to restored entries are written in the
+// export_comments.bml format with the new entry ids and with the
+// commenters mapped to their OpenID identities on the original server.
+// Dreamwidth and LJ clones import comments from OpenID users this way.
+// Comments of OpenID users of the original server become anonymous as the
+// archive does not know their identities.
+// With -comment-import-url the file is posted to that importer endpoint of
+// the target server and imported comments are recorded in restore.linedb.
+
+const restoreDBFileName = "restore.linedb"
+
+// Entry props that the author sets and that are meaningful on another
+// server. Others are maintained by the server or refer to its ids.
+var restoredEntryProps = []string{
+	"adult_content",
+	"current_coords",
+	"current_location",
+	"current_mood",
+	"current_music",
+	"opt_nocomments",
+	"opt_noemail",
+	"opt_preformatted",
+	"opt_screening",
+	"picture_keyword",
+	"taglist",
+}
+
+type restoreRecord struct {
+	server  string
+	journal string
+	itemId  int64
+
+	// The entry on the target server
+	newItemId int64
+	newAnum   int64
+	url       string
+}
+
+// Comment sent to the importer of the target server
+type restoredComment struct {
+	server    string
+	journal   string
+	commentId CommentId
+}
+
+type restoreDB struct {
+	path     string
+	records  []restoreRecord
+	comments []restoredComment
+}
+
+func readRestoreDB(dir string) (*restoreDB, *Report) {
+	db := &restoreDB{path: filepath.Join(dir, restoreDBFileName)}
+	dbdata, err := archiveStore.ReadFile(db.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return db, nil
+		}
+		return nil, WrapErr(err, "")
+	}
+	d := newLinedbDecoder(dbdata)
+	for d.NextItem() {
+		if d.ItemKind != linedb.TableItem {
+			continue
+		}
+		switch d.ItemName {
+		case "entries":
+			for d.NextRow() {
+				db.records = append(db.records, restoreRecord{
+					d.GetString(), d.GetString(), d.GetInt64(), d.GetInt64(), d.GetInt64(), d.GetString(),
+				})
+			}
+		case "comments":
+			for d.NextRow() {
+				db.comments = append(db.comments, restoredComment{d.GetString(), d.GetString(), CommentId(d.GetInt64())})
+			}
+		}
+	}
+	if err := d.GetError(); err != nil {
+		return nil, WrapErr(err, "failed to parse %s", db.path)
+	}
+	return db, nil
+}
+
+func (db *restoreDB) write() *Report {
+	e := linedb.NewByteEncoder()
+	e.Comment("server journal itemid new-itemid new-anum url")
+	e.Table("entries")
+	for _, record := range db.records {
+		e.AddString(record.server).AddString(record.journal).AddInt64(record.itemId)
+		e.AddInt64(record.newItemId).AddInt64(record.newAnum).AddString(record.url).EndRow()
+	}
+	e.EndTable()
+	if len(db.comments) != 0 {
+		e.Comment("server journal commentid")
+		e.Table("comments")
+		for _, c := range db.comments {
+			e.AddString(c.server).AddString(c.journal).AddInt64(int64(c.commentId)).EndRow()
+		}
+		e.EndTable()
+	}
+	if err := writeFileTempRename(db.path, e.GetBytes()); err != nil {
+		return WrapErr(err, "")
+	}
+	return nil
+}
+
+func (db *restoreDB) find(server, journal string, itemId int64) *restoreRecord {
+	for i := range db.records {
+		record := &db.records[i]
+		if record.server == server && record.journal == journal && record.itemId == itemId {
+			return record
+		}
+	}
+	return nil
+}
+
+// Get the postevent parameters for the archived entry or nil if the entry
+// time cannot be parsed.
+func restoreEventParams(entry *archivedEntry, targetJournal string, owner string) map[string]interface{} {
+	t, err := time.Parse(ljEventTimeLayout, entry.eventTime)
+	if err != nil {
+		return nil
+	}
+	params := map[string]interface{}{
+		"event":       entry.event,
+		"subject":     entry.subject,
+		"lineendings": "unix",
+		"year":        t.Year(),
+		"mon":         int(t.Month()),
+		"day":         t.Day(),
+		"hour":        t.Hour(),
+		"min":         t.Minute(),
+	}
+	if targetJournal != owner {
+		params["usejournal"] = targetJournal
+	}
+	switch entry.securityLevel() {
+	case securityPublic:
+		params["security"] = "public"
+	case securityFriends:
+		params["security"] = "usemask"
+		params["allowmask"] = 1
+	default:
+		params["security"] = "private"
+	}
+	props := map[string]interface{}{"opt_backdated": 1}
+	for _, name := range restoredEntryProps {
+		if value := entry.props[name]; value != "" {
+			props[name] = value
+		}
+	}
+	params["props"] = props
+	return params
+}
+
+// Get the journal on the target server. The account journal goes into the
+// journal of the target account and communities keep their names.
+func (config *Config) restoreTargetJournal(journal string) string {
+	if journal == config.username {
+		return config.restoreUsername
+	}
+	return journal
+}
+
+func restoreJournal(session *ljSession, config *Config, journal string) *Report {
+	dir := config.journalDir(journal)
+	db, r := readRestoreDB(dir)
+	if r != nil {
+		return r
+	}
+	entries, r := readJournalEntries(dir)
+	if r != nil {
+		return r
+	}
+	target := config.restoreTargetJournal(journal)
+	var selected []*archivedEntry
+	for _, entry := range entries {
+		if config.selectEntries != nil && !config.selectEntries.matches(entry) {
+			continue
+		}
+		if db.find(config.restoreServer, target, entry.itemId) != nil {
+			continue
+		}
+		selected = append(selected, entry)
+	}
+	config.normalizeEntryTimes(selected)
+	sort.Sort(sortEntriesByTime(selected))
+	log("Restoring %d entries of %s into %s on %s", len(selected), journal, target, config.restoreServer)
+
+	type postEventResult struct {
+		ItemId int64  `xmlrpc:"itemid"`
+		Anum   int64  `xmlrpc:"anum"`
+		Url    string `xmlrpc:"url"`
+	}
+	customToPrivate := 0
+	for _, entry := range selected {
+		if shutdownRequested() {
+			return interruptedReport()
+		}
+		params := restoreEventParams(entry, target, config.restoreUsername)
+		if params == nil {
+			config.warn("skipping %s with unparsable time %s", entry.fileName, entry.eventTime)
+			continue
+		}
+		if entry.securityLevel() == securityCustom {
+			customToPrivate++
+		}
+		if config.dryRun {
+			log("Would restore %s %s %s", entry.fileName, entry.eventTime, entryDisplaySubject(entry))
+			continue
+		}
+		var result postEventResult
+		if r := callLJXmlRpcMethod(session, "postevent", params, &result); r != nil {
+			return CombineReports(r, ReportMsg("stopped restoring %s at %s, the next run continues from it", journal, entry.fileName))
+		}
+		db.records = append(db.records, restoreRecord{
+			config.restoreServer, target, entry.itemId, result.ItemId, result.Anum, result.Url,
+		})
+		if r := db.write(); r != nil {
+			return r
+		}
+		log("Restored %s as %s", entry.fileName, result.Url)
+	}
+	if customToPrivate != 0 {
+		log("%d entries limited to custom friend groups were restored as private", customToPrivate)
+	}
+	if config.restoreComments && !config.dryRun {
+		return restoreJournalComments(session, config, journal, entries, db)
+	}
+	return nil
+}
+
+// Get the OpenID identity URL of the user on the original server.
+// LiveJournal serves identities on user subdomains, other LJ code based
+// servers under /users/.
+func openIdIdentity(server, user string) string {
+	u, err := url.Parse(server)
+	if err == nil && (u.Host == "livejournal.com" || u.Host == "www.livejournal.com") {
+		return "https://" + strings.Replace(user, "_", "-", -1) + ".livejournal.com/"
+	}
+	return strings.TrimSuffix(server, "/") + "/users/" + user + "/"
+}
+
+type commentImportFile struct {
+	XMLName  xml.Name               `xml:"livejournal"`
+	Comments []commentImportComment `xml:"comments>comment"`
+	Usermaps []commentImportUser    `xml:"usermaps>usermap"`
+}
+
+type commentImportComment struct {
+	Id       CommentId `xml:"id,attr"`
+	JItemId  int64     `xml:"jitemid,attr"`
+	PosterId int       `xml:"posterid,attr,omitempty"`
+	ParentId string    `xml:"parentid,attr,omitempty"`
+	State    string    `xml:"state,attr,omitempty"`
+	Subject  string    `xml:"subject,omitempty"`
+	Body     string    `xml:"body"`
+	Date     string    `xml:"date"`
+}
+
+type commentImportUser struct {
+	Id       int    `xml:"id,attr"`
+	User     string `xml:"user,attr"`
+	Identity string `xml:"identity,attr"`
+}
+
+// Write the comments to restored entries that were not imported yet into
+// restore-comments.xml and post them to the importer when configured.
+func restoreJournalComments(
+	session *ljSession, config *Config, journal string, entries []*archivedEntry, db *restoreDB,
+) *Report {
+	entryDirs := make(map[int64]string, len(entries))
+	for _, entry := range entries {
+		entryDirs[entry.itemId] = entry.dir
+	}
+	target := config.restoreTargetJournal(journal)
+	imported := make(map[CommentId]bool)
+	for _, c := range db.comments {
+		if c.server == config.restoreServer && c.journal == target {
+			imported[c.commentId] = true
+		}
+	}
+	var file commentImportFile
+	posterIds := make(map[string]int)
+	for _, record := range db.records {
+		if record.server != config.restoreServer || record.journal != target {
+			continue
+		}
+		entryDir, ok := entryDirs[record.itemId]
+		if !ok {
+			continue
+		}
+		comments, r := readEntryComments(entryDir, record.itemId)
+		if r != nil {
+			return r
+		}
+		for i := range comments {
+			c := &comments[i]
+			if imported[c.Id] {
+				continue
+			}
+			ic := commentImportComment{
+				Id:       c.Id,
+				JItemId:  record.newItemId,
+				ParentId: c.ParentId,
+				State:    c.State,
+				Subject:  c.Subject,
+				Body:     c.Body,
+				Date:     c.Date,
+			}
+			if ic.ParentId == "0" {
+				ic.ParentId = ""
+			}
+			// The archive has no identity URLs of OpenID commenters, so
+			// their comments are imported as anonymous
+			if c.User != "" && !isSyntheticUser(c.User) {
+				posterId := posterIds[c.User]
+				if posterId == 0 {
+					posterId = len(posterIds) + 1
+					posterIds[c.User] = posterId
+					identity := openIdIdentity(config.server, c.User)
+					file.Usermaps = append(file.Usermaps, commentImportUser{posterId, c.User, identity})
+				}
+				ic.PosterId = posterId
+			}
+			file.Comments = append(file.Comments, ic)
+		}
+	}
+	if len(file.Comments) == 0 {
+		log("No comments of %s to restore", journal)
+		return nil
+	}
+	data, err := xml.MarshalIndent(&file, "", "  ")
+	if err != nil {
+		return WrapErr(err, "failed to encode comments of %s", journal)
+	}
+	data = append([]byte(xml.Header), data...)
+	path := filepath.Join(config.journalDir(journal), "restore-comments.xml")
+	if err := writeFileTempRename(path, data); err != nil {
+		return WrapErr(err, "")
+	}
+	log("Wrote %d comments from %d commenters to %s", len(file.Comments), len(file.Usermaps), path)
+	if config.commentImportUrl == "" {
+		return nil
+	}
+
+	resp, err := session.client.Post(config.commentImportUrl, "text/xml; charset=utf-8", bytes.NewReader(data))
+	if err != nil {
+		return WrapErr(err, "failed to post comments to %s", config.commentImportUrl)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1000))
+		return ReportMsg("the comment importer at %s failed with %s - %s", config.commentImportUrl, resp.Status, strings.TrimSpace(string(message)))
+	}
+	for _, c := range file.Comments {
+		db.comments = append(db.comments, restoredComment{config.restoreServer, target, c.Id})
+	}
+	if r := db.write(); r != nil {
+		return r
+	}
+	log("Imported %d comments of %s into %s", len(file.Comments), journal, target)
+	return nil
+}
+
+func runRestore(config *Config) *Report {
+	targetConfig := *config
+	targetConfig.server = config.restoreServer
+	targetConfig.username = config.restoreUsername
+	targetConfig.password = config.restorePassword
+	targetConfig.apiKey = ""
+	targetConfig.oauth = nil
+	targetConfig.sessionCookie = ""
+
+	// The JSON-RPC endpoint belongs to the source server
+	targetConfig.api = xmlrpcAPI
+	startShutdownHandling()
+	var session *ljSession
+	if !config.dryRun {
+		var r *Report
+		if session, r = openLJSession(&targetConfig); r != nil {
+			return r
+		}
+	}
+	for _, journal := range config.journals {
+		if r := restoreJournal(session, config, journal); r != nil {
+			return r
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_runRestore(t *testing.T) {
+	server := newFakeLJServer(t)
+	defer server.Close()
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	journalDir := filepath.Join(dumpDir, "bob")
+	if err := os.Mkdir(journalDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	entry := `<?xml version="1.0"?><event><itemid>7</itemid><eventtime>2005-03-05 14:22:00</eventtime><subject>Old</subject><event>text</event><security>usemask</security><allowmask>6</allowmask><props><taglist>life</taglist><revnum>3</revnum></props></event>`
+	if err := ioutil.WriteFile(filepath.Join(journalDir, "L-7"), []byte(entry), 0666); err != nil {
+		t.Fatal(err)
+	}
+	comments := `<?xml version="1.0"?><comments>` +
+		`<comment><id>20</id><user>some_user</user><date>2005-03-06T10:00:00Z</date><body>First</body></comment>` +
+		`<comment><id>21</id><parentid>20</parentid><date>2005-03-06T11:00:00Z</date><body>Anonymous reply</body></comment>` +
+		`<comment><id>22</id><parentid>20</parentid><user>ext_42</user><date>2005-03-06T12:00:00Z</date><body>OpenID reply</body></comment>` +
+		`</comments>`
+	if err := ioutil.WriteFile(commentFilePath(journalDir, 7), []byte(comments), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &Config{
+		username:        "bob",
+		journals:        []string{"bob"},
+		dumpDir:         dumpDir,
+		journalAliases:  make(map[string]string),
+		restoreServer:   server.URL,
+		restoreUsername: "con",
+		restorePassword: "password",
+
+		server:           "https://www.livejournal.com",
+		restoreComments:  true,
+		commentImportUrl: server.URL + "/admin/import_comments",
+	}
+	for run := 0; run < 2; run++ {
+		if r := runRestore(config); r != nil {
+			t.Fatal(r.AsText())
+		}
+	}
+	if len(server.postedEvents) != 1 {
+		t.Fatalf("Expected one postevent call, got %d", len(server.postedEvents))
+	}
+	body := server.postedEvents[0]
+	for _, expected := range []string{
+		"<name>year</name><value><int>2005</int>",
+		"<name>security</name><value><string>private</string>",
+		"<name>opt_backdated</name>",
+		"<name>taglist</name><value><string>life</string>",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("postevent request does not contain %s:\n%s", expected, body)
+		}
+	}
+	if strings.Contains(body, "revnum") || strings.Contains(body, "usejournal") {
+		t.Errorf("Unexpected parameters in postevent request:\n%s", body)
+	}
+	db, r := readRestoreDB(journalDir)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(db.records) != 1 || db.records[0].journal != "con" || db.records[0].newItemId != 101 {
+		t.Errorf("Unexpected records %+v", db.records)
+	}
+
+	if len(server.importedComments) != 1 || len(db.comments) != 3 {
+		t.Fatalf("Expected one import of 3 comments, got %d imports and %d recorded comments", len(server.importedComments), len(db.comments))
+	}
+	imported := server.importedComments[0]
+	for _, expected := range []string{
+		`<comment id="20" jitemid="101" posterid="1">`,
+		`<comment id="21" jitemid="101" parentid="20">`,
+		`<comment id="22" jitemid="101" parentid="20">`,
+		`<usermap id="1" user="some_user" identity="https://some-user.livejournal.com/"></usermap>`,
+	} {
+		if !strings.Contains(imported, expected) {
+			t.Errorf("Imported comments do not contain %s:\n%s", expected, imported)
+		}
+	}
+	if strings.Contains(imported, "ext_42") {
+		t.Errorf("Imported comments map an OpenID user of the original server:\n%s", imported)
+	}
+}
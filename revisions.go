@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// When the server returns an entry with a changed subject or text the dump
+// keeps the previously archived entry file as revisions/L-<itemid>.<n> of
+// the journal directory, where n starts from 1 for the oldest revision.
+// Changes to the properties or the security alone do not make a revision.
+// The diff command prints word-level differences between consecutive
+// revisions and the current entry, with removed words as [-words-] and
+// added words as {+words+}. The stats command summarizes the revisions.
+
+const revisionsDirName = "revisions"
+
+// Longer texts are compared only after trimming the common start and end
+// to keep the quadratic diff bounded
+const maxDiffCells = 4 << 20
+
+func revisionFilePath(dir string, itemId int64, n int) string {
+	return filepath.Join(dir, revisionsDirName, fmt.Sprintf("L-%d.%d", itemId, n))
+}
+
+// List the revision numbers of all entries in the journal directory
+func listEntryRevisions(dir string) (map[int64][]int, error) {
+	infos, err := archiveStore.ReadDir(filepath.Join(dir, revisionsDirName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	revisions := make(map[int64][]int)
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasPrefix(name, "L-") {
+			continue
+		}
+		dot := strings.IndexByte(name, '.')
+		if dot < 0 {
+			continue
+		}
+		itemId, err1 := strconv.ParseInt(name[2:dot], 10, 64)
+		n, err2 := strconv.Atoi(name[dot+1:])
+		if err1 != nil || err2 != nil || n <= 0 {
+			continue
+		}
+		revisions[itemId] = append(revisions[itemId], n)
+	}
+	for _, ns := range revisions {
+		sort.Ints(ns)
+	}
+	return revisions, nil
+}
+
+// Stage the previous entry file as the next revision when the entry text
+// changed
+func (jcx *journalContext) keepEntryRevision(itemId int64, previous []byte, current *archivedEntry) *Report {
+	old, err := parseArchivedEntry(previous)
+	if err != nil {
+		jcx.config.warn("failed to parse the previous version of L-%d, not keeping it - %s", itemId, err.Error())
+		return nil
+	}
+	if old.subject == current.subject && old.event == current.event {
+		return nil
+	}
+	n := 1
+	for {
+		if _, err := jcx.readStagedFile(revisionFilePath(jcx.dir, itemId, n)); err != nil {
+			if !os.IsNotExist(err) {
+				return WrapErr(err, "")
+			}
+			break
+		}
+		n++
+	}
+	if err := mkdirArchive(filepath.Join(jcx.dir, revisionsDirName)); err != nil {
+		return WrapErr(err, "")
+	}
+	jcx.stageWrite(revisionFilePath(jcx.dir, itemId, n), previous)
+	log("Kept revision %d of edited entry L-%d", n, itemId)
+	return nil
+}
+
+type wordDiffOp struct {
+	// -1 for removed, 0 for kept and 1 for added words
+	kind  int
+	words []string
+}
+
+// Compute the word-level difference between two texts as the longest
+// common subsequence of words
+func diffWords(from, to []string) []wordDiffOp {
+	var ops []wordDiffOp
+	add := func(kind int, words ...string) {
+		if len(words) == 0 {
+			return
+		}
+		if n := len(ops); n != 0 && ops[n-1].kind == kind {
+			ops[n-1].words = append(ops[n-1].words, words...)
+			return
+		}
+		ops = append(ops, wordDiffOp{kind, append([]string(nil), words...)})
+	}
+
+	prefix := 0
+	for prefix < len(from) && prefix < len(to) && from[prefix] == to[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(from)-prefix && suffix < len(to)-prefix && from[len(from)-1-suffix] == to[len(to)-1-suffix] {
+		suffix++
+	}
+	add(0, from[:prefix]...)
+	a := from[prefix : len(from)-suffix]
+	b := to[prefix : len(to)-suffix]
+
+	if len(a)*len(b) > maxDiffCells {
+		add(-1, a...)
+		add(1, b...)
+	} else {
+		// lcs[i][j] is the length of the common subsequence of a[i:] and
+		// b[j:]
+		lcs := make([][]int, len(a)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(b)+1)
+		}
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				if a[i] == b[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(a) && j < len(b) {
+			switch {
+			case a[i] == b[j]:
+				add(0, a[i])
+				i++
+				j++
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				add(-1, a[i])
+				i++
+			default:
+				add(1, b[j])
+				j++
+			}
+		}
+		add(-1, a[i:]...)
+		add(1, b[j:]...)
+	}
+	add(0, from[len(from)-suffix:]...)
+	return ops
+}
+
+func formatWordDiff(ops []wordDiffOp) string {
+	parts := make([]string, len(ops))
+	for i, op := range ops {
+		text := strings.Join(op.words, " ")
+		switch op.kind {
+		case -1:
+			text = "[-" + text + "-]"
+		case 1:
+			text = "{+" + text + "+}"
+		}
+		parts[i] = text
+	}
+	return strings.Join(parts, " ")
+}
+
+// Count removed and added words
+func countWordChanges(ops []wordDiffOp) (removed int, added int) {
+	for _, op := range ops {
+		switch op.kind {
+		case -1:
+			removed += len(op.words)
+		case 1:
+			added += len(op.words)
+		}
+	}
+	return removed, added
+}
+
+func entryWords(e *archivedEntry) (subject []string, body []string) {
+	return strings.Fields(htmlToText(e.subject)), strings.Fields(htmlToText(e.event))
+}
+
+// Read the revisions of the entry followed by the current entry when it
+// is still archived
+func readEntryRevisions(dir string, index *entriesIndex, itemId int64, ns []int) ([]*archivedEntry, error) {
+	var versions []*archivedEntry
+	for _, n := range ns {
+		e, err := readArchivedEntry(revisionFilePath(dir, itemId, n))
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, e)
+	}
+	if row := index.rows[itemId]; row != nil && row.file != "" {
+		e, err := readArchivedEntry(filepath.Join(dir, filepath.FromSlash(row.file)))
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, e)
+	}
+	return versions, nil
+}
+
+type revisionsSummary struct {
+	entries   int
+	revisions int
+	removed   int
+	added     int
+}
+
+func summarizeEntryRevisions(dir string) (revisionsSummary, *Report) {
+	var summary revisionsSummary
+	revisions, err := listEntryRevisions(dir)
+	if err != nil {
+		return summary, WrapErr(err, "")
+	}
+	if len(revisions) == 0 {
+		return summary, nil
+	}
+	index, r := loadEntriesIndex(dir)
+	if r != nil {
+		return summary, r
+	}
+	for itemId, ns := range revisions {
+		versions, err := readEntryRevisions(dir, index, itemId, ns)
+		if err != nil {
+			return summary, WrapErr(err, "")
+		}
+		summary.entries++
+		summary.revisions += len(ns)
+		for i := 1; i < len(versions); i++ {
+			fromSubject, fromBody := entryWords(versions[i-1])
+			toSubject, toBody := entryWords(versions[i])
+			removed, added := countWordChanges(diffWords(append(fromSubject, fromBody...), append(toSubject, toBody...)))
+			summary.removed += removed
+			summary.added += added
+		}
+	}
+	return summary, nil
+}
+
+func parseEntryArg(arg string) (int64, error) {
+	itemId, err := strconv.ParseInt(strings.TrimPrefix(arg, "L-"), 10, 64)
+	if err != nil || itemId <= 0 {
+		return 0, fmt.Errorf("%s is not an entry like L-123", arg)
+	}
+	return itemId, nil
+}
+
+func runDiff(config *Config) *Report {
+	itemId, err := parseEntryArg(config.commandArg)
+	if err != nil {
+		return WrapErr(err, "")
+	}
+	found := false
+	for _, journal := range config.journals {
+		dir := config.journalDir(journal)
+		revisions, err := listEntryRevisions(dir)
+		if err != nil {
+			return WrapErr(err, "")
+		}
+		ns := revisions[itemId]
+		if len(ns) == 0 {
+			continue
+		}
+		index, r := loadEntriesIndex(dir)
+		if r != nil {
+			return r
+		}
+		versions, err := readEntryRevisions(dir, index, itemId, ns)
+		if err != nil {
+			return WrapErr(err, "")
+		}
+		found = true
+		fmt.Print(formatEntryRevisionsDiff(journal, itemId, versions, len(ns)))
+	}
+	if !found {
+		return ReportMsg("no archived revisions of L-%d", itemId)
+	}
+	return nil
+}
+
+// Format differences between consecutive versions. The first revisions
+// versions are archived revisions and the rest is the current entry.
+func formatEntryRevisionsDiff(journal string, itemId int64, versions []*archivedEntry, revisions int) string {
+	var b strings.Builder
+	name := func(i int) string {
+		if i < revisions {
+			return fmt.Sprintf("revision %d", i+1)
+		}
+		return "current"
+	}
+	for i := 1; i < len(versions); i++ {
+		fromSubject, fromBody := entryWords(versions[i-1])
+		toSubject, toBody := entryWords(versions[i])
+		subjectOps := diffWords(fromSubject, toSubject)
+		bodyOps := diffWords(fromBody, toBody)
+		removed, added := countWordChanges(append(subjectOps, bodyOps...))
+		fmt.Fprintf(&b, "%s L-%d: %s -> %s, %d words removed, %d added\n",
+			journal, itemId, name(i-1), name(i), removed, added)
+		fmt.Fprintf(&b, "Subject: %s\n\n", formatWordDiff(subjectOps))
+		for _, line := range wrapText(formatWordDiff(bodyOps), 78) {
+			b.WriteString(line)
+			b.WriteByte('\n')
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
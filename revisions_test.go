@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_diffWords(t *testing.T) {
+	from := strings.Fields("the quick brown fox jumps over the dog")
+	to := strings.Fields("the quick red fox jumps over the lazy dog")
+	ops := diffWords(from, to)
+	if text := formatWordDiff(ops); text != "the quick [-brown-] {+red+} fox jumps over the {+lazy+} dog" {
+		t.Errorf("Unexpected diff %q", text)
+	}
+	if removed, added := countWordChanges(ops); removed != 1 || added != 2 {
+		t.Errorf("Expected 1 removed and 2 added words, got %d and %d", removed, added)
+	}
+	if text := formatWordDiff(diffWords(nil, []string{"new"})); text != "{+new+}" {
+		t.Errorf("Unexpected diff of an empty text %q", text)
+	}
+}
+
+func Test_entryRevisions(t *testing.T) {
+	server := newFakeLJServer(t)
+	defer server.Close()
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	config := &Config{
+		server:         server.URL,
+		username:       "con",
+		password:       "password",
+		journals:       []string{"con"},
+		dumpDir:        dumpDir,
+		accountDataDir: filepath.Join(dumpDir, accountDataDirName),
+		journalAliases: make(map[string]string),
+	}
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	journalDir := filepath.Join(dumpDir, "con_")
+	if revisions, _ := listEntryRevisions(journalDir); len(revisions) != 0 {
+		t.Fatalf("Expected no revisions of a new entry, got %v", revisions)
+	}
+
+	server.eventText = "Hello there"
+	server.eventSyncTime = "2020-01-02 10:00:00"
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	data, err := ioutil.ReadFile(revisionFilePath(journalDir, 1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "Hello</event>") {
+		t.Errorf("Expected the original text in the revision, got %s", data)
+	}
+
+	summary, r := summarizeEntryRevisions(journalDir)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if summary != (revisionsSummary{entries: 1, revisions: 1, added: 1}) {
+		t.Errorf("Unexpected summary %+v", summary)
+	}
+
+	index, r := loadEntriesIndex(journalDir)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	versions, err := readEntryRevisions(journalDir, index, 1, []int{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := formatEntryRevisionsDiff("con", 1, versions, 1)
+	if !strings.Contains(text, "revision 1 -> current") || !strings.Contains(text, "Hello {+there+}") {
+		t.Errorf("Unexpected diff %s", text)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Each dump writes an HTML report of what it did into the reports
+// directory of the dump so the result of unattended runs can be reviewed
+// in a browser. run-report.html is the latest report, older ones stay as
+// run-<time>.html.
+const runReportDirName = "reports"
+const latestRunReportFileName = "run-report.html"
+
+// Show at most this number of new entries per journal
+const maxRunReportEntries = 200
+
+type runPhase struct {
+	name     string
+	duration time.Duration
+}
+
+type runReport struct {
+	started time.Time
+
+	// Index of the first errorLog record of the run
+	firstLogRecord int
+
+	journals []*journalContext
+	phases   []runPhase
+}
+
+func (rr *runReport) addPhase(name string, started time.Time) {
+	rr.phases = append(rr.phases, runPhase{name, time.Since(started)})
+}
+
+type runReportEntry struct {
+	Link    string
+	Date    string
+	Subject string
+}
+
+type runReportJournal struct {
+	Anchor      string
+	Name        string
+	Entries     []runReportEntry
+	MoreEntries int
+	NewEntries  int
+	NewComments int
+}
+
+type runReportMessages struct {
+	Kind     string
+	Messages []string
+}
+
+type runReportBar struct {
+	Name     string
+	Duration string
+	Percent  int
+}
+
+type runReportPage struct {
+	Started     string
+	Duration    string
+	Result      string
+	Failed      bool
+	NewEntries  int
+	NewComments int
+	Journals    []runReportJournal
+	Warnings    []runReportMessages
+	Timing      []runReportBar
+}
+
+var runReportTemplate = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>ljdump run {{.Started}}</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: auto; padding: 1em; }
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 0.8em; text-align: left; }
+.failed { color: #b00; font-weight: bold; }
+.meta { color: #555; font-size: small; }
+.bar { background: #8ab4e8; height: 1em; min-width: 1px; }
+</style></head>
+<body><h1>ljdump run {{.Started}}</h1>
+<p>Duration: {{.Duration}}</p>
+<p{{if .Failed}} class="failed"{{end}}>{{.Result}}</p>
+<p>{{.NewEntries}} new or updated entries, {{.NewComments}} new comments</p>
+{{if .Journals}}<table><tr><th>Journal</th><th>Entries</th><th>Comments</th></tr>
+{{range .Journals}}<tr><td>{{if .Entries}}<a href="#{{.Anchor}}">{{.Name}}</a>{{else}}{{.Name}}{{end}}</td><td>{{.NewEntries}}</td><td>{{.NewComments}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Warnings}}<h2>Warnings and errors</h2>
+{{range .Warnings}}<details><summary>{{.Kind}} ({{len .Messages}})</summary><ul>
+{{range .Messages}}<li>{{.}}</li>
+{{end}}</ul></details>
+{{end}}{{end}}
+{{if .Timing}}<h2>Timing</h2><table>
+{{range .Timing}}<tr><td>{{.Name}}</td><td>{{.Duration}}</td><td style="width: 30em"><div class="bar" style="width: {{.Percent}}%"></div></td></tr>
+{{end}}</table>{{end}}
+{{range .Journals}}{{if .Entries}}<h2 id="{{.Anchor}}">{{.Name}}</h2><ul>
+{{range .Entries}}<li><span class="meta">{{.Date}}</span> <a href="{{.Link}}">{{.Subject}}</a></li>
+{{end}}{{if .MoreEntries}}<li>and {{.MoreEntries}} more</li>{{end}}</ul>
+{{end}}{{end}}
+</body></html>
+`))
+
+func makeRunReportJournal(reportDir string, index int, jcx *journalContext) runReportJournal {
+	journal := runReportJournal{
+		Anchor:      fmt.Sprintf("journal-%d", index),
+		Name:        jcx.name,
+		NewEntries:  jcx.newEntries,
+		NewComments: jcx.newComments,
+	}
+	ids := jcx.newEntryIds
+	if len(ids) > maxRunReportEntries {
+		journal.MoreEntries = len(ids) - maxRunReportEntries
+		ids = ids[:maxRunReportEntries]
+	}
+	for _, itemId := range ids {
+		path := filepath.Join(jcx.entryDir(itemId), fmt.Sprintf("L-%d", itemId))
+		link := path
+		if rel, err := filepath.Rel(reportDir, path); err == nil {
+			link = filepath.ToSlash(rel)
+		}
+		item := runReportEntry{Link: link, Subject: fmt.Sprintf("L-%d", itemId)}
+		if entry, err := readArchivedEntry(path); err == nil {
+			item.Date = jcx.config.formatDate(entry.eventTime)
+			item.Subject = entryDisplaySubject(entry)
+		}
+		journal.Entries = append(journal.Entries, item)
+	}
+	return journal
+}
+
+// Write the report of the dump that finished with the result r.
+func writeRunReport(config *Config, rr *runReport, r *Report) *Report {
+	reportDir := filepath.Join(config.dumpDir, runReportDirName)
+	page := &runReportPage{
+		Started:  rr.started.Format("2006-01-02 15:04:05"),
+		Duration: time.Since(rr.started).Round(time.Second).String(),
+		Result:   "Completed",
+	}
+	if r != nil && (shutdownRequested() || config.runtimeExceeded()) {
+		page.Result = "Stopped: " + config.errorLog.redact(r.AsText())
+	} else if r != nil {
+		page.Result = "Failed (" + string(r.errorCategory(config.errorLog)) + "): " + config.errorLog.redact(r.AsText())
+		page.Failed = true
+	}
+
+	for i, jcx := range rr.journals {
+		page.NewEntries += jcx.newEntries
+		page.NewComments += jcx.newComments
+		page.Journals = append(page.Journals, makeRunReportJournal(reportDir, i, jcx))
+	}
+
+	var kinds []string
+	kindMessages := make(map[string][]string)
+	if errorLog := config.errorLog; errorLog != nil && rr.firstLogRecord <= len(errorLog.records) {
+		for _, record := range errorLog.records[rr.firstLogRecord:] {
+			kind := record.summaryKind()
+			if kindMessages[kind] == nil {
+				kinds = append(kinds, kind)
+			}
+			kindMessages[kind] = append(kindMessages[kind], record.message)
+		}
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		page.Warnings = append(page.Warnings, runReportMessages{kind, kindMessages[kind]})
+	}
+
+	var longest time.Duration
+	for _, phase := range rr.phases {
+		if phase.duration > longest {
+			longest = phase.duration
+		}
+	}
+	for _, phase := range rr.phases {
+		percent := 0
+		if longest > 0 {
+			percent = int(phase.duration * 100 / longest)
+		}
+		page.Timing = append(page.Timing, runReportBar{
+			phase.name, phase.duration.Round(time.Millisecond).String(), percent,
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := runReportTemplate.Execute(&buf, page); err != nil {
+		panic(err)
+	}
+	if err := mkdirArchive(reportDir); err != nil {
+		return WrapErr(err, "failed to create run report directory %s", reportDir)
+	}
+	datedPath := filepath.Join(reportDir, "run-"+rr.started.Format("20060102-150405")+".html")
+	for _, path := range []string{datedPath, filepath.Join(reportDir, latestRunReportFileName)} {
+		if err := writeFileTempRename(path, buf.Bytes()); err != nil {
+			return WrapErr(err, "failed to write run report %s", path)
+		}
+	}
+	return nil
+}
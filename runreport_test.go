@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_writeRunReport(t *testing.T) {
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	config := &Config{dumpDir: dumpDir}
+	journalDir := filepath.Join(dumpDir, "bob")
+	if err := os.Mkdir(journalDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	entry := `<?xml version="1.0"?><event><itemid>7</itemid><eventtime>2010-05-01 10:00:00</eventtime><subject>Hello &amp; bye</subject><event>text</event></event>`
+	if err := ioutil.WriteFile(filepath.Join(journalDir, "L-7"), []byte(entry), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	config.errorLog = &errorLog{}
+	rr := &runReport{started: time.Now()}
+	jcx := &journalContext{config: config, name: "bob", dir: journalDir, newEntries: 1, newComments: 3}
+	jcx.newEntryIds = []int64{7}
+	rr.journals = append(rr.journals, jcx)
+	rr.phases = append(rr.phases, runPhase{"fetch bob", time.Second})
+	config.errorLog.record("http", "503 POST /interface/xmlrpc?")
+
+	if r := writeRunReport(config, rr, nil); r != nil {
+		t.Fatal(r.AsText())
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dumpDir, runReportDirName, latestRunReportFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(data)
+	for _, expected := range []string{
+		"1 new or updated entries, 3 new comments",
+		`<a href="../bob/L-7">Hello &amp; bye</a>`,
+		"http 503 (1)",
+		"fetch bob",
+	} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("Run report does not contain %q:\n%s", expected, page)
+		}
+	}
+}
+
+func Test_errorLog(t *testing.T) {
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	config := &Config{dumpDir: dumpDir, server: "https://www.example.com", errorLog: &errorLog{}}
+	config.errorLog.addSecret("hunter2")
+	config.errorLog.addSecret("ab")
+	config.errorLog.record("http", "403 GET /export_comments.bml?ljsession=v2:u1:s2&get=comment_meta")
+	config.errorLog.record("network", "login failed for password hunter2 - reset by ab")
+	for _, record := range config.errorLog.records {
+		if strings.Contains(record.message, "hunter2") || strings.Contains(record.message, "v2:u1") {
+			t.Errorf("Secret in the recorded message %q", record.message)
+		}
+	}
+	if message := config.errorLog.records[1].message; !strings.Contains(message, "reset by ab") {
+		t.Errorf("Short secret was redacted in %q", message)
+	}
+	if r := flushErrorLog(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if config.errorLog.count() != 0 {
+		t.Errorf("Flushed records are kept in the run log")
+	}
+
+	// The file keeps only the most recent records
+	for i := 0; i < maxErrorLogRecords; i++ {
+		config.errorLog.record("warning", "warning %d", i)
+	}
+	if r := flushErrorLog(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	records, r := readErrorLog(config)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(records) != maxErrorLogRecords || records[0].message != "warning 0" ||
+		records[len(records)-1].message != fmt.Sprintf("warning %d", maxErrorLogRecords-1) {
+		t.Errorf("Unexpected %d records from %+v", len(records), records[0])
+	}
+
+	// A secret added after the record was written is redacted on export
+	config.errorLog.record("warning", "cannot read journal secretjournal")
+	if r := flushErrorLog(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	config.errorLog.addSecret("secretjournal")
+	if r := runExportErrors(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	files, _ := filepath.Glob(filepath.Join(dumpDir, "ljdump-errors-*.txt"))
+	if len(files) != 1 {
+		t.Fatalf("Expected one exported file, got %v", files)
+	}
+	data, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"Server: https://www.example.com\n",
+		fmt.Sprintf("  %-20s %d\n", "warning", maxErrorLogRecords),
+		"warning: cannot read journal <redacted>\n",
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("Exported errors do not contain %q:\n%s", expected, data)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Versions of the journal.linedb and account.linedb formats written by this
+// ljdumpgo. Files from before versioning have no schemaVersion scalar and
+// are version 0. When a format changes, bump the version and append the
+// upgrade from the previous version to the migrations list. Older files are
+// upgraded after parsing and written in the new format on the next save.
+// Files from a newer ljdumpgo are refused as they may contain data that
+// this version would silently drop.
+const journalDBSchemaVersion = 7
+const accountDataSchemaVersion = 1
+
+// The function at index i upgrades the parsed data from version i to i+1
+var journalDBMigrations = []func(db *journalDB) error{
+	// 0 -> 1 only added the version scalar
+	func(db *journalDB) error { return nil },
+
+	// 1 -> 2 added the layout, older archives are flat
+	func(db *journalDB) error {
+		db.layout = flatLayout
+		return nil
+	},
+
+	// 2 -> 3 added commentItems that readJournalDB builds from the files
+	func(db *journalDB) error {
+		db.rebuildCommentItems = true
+		return nil
+	},
+
+	// 3 -> 4 added the commentStates log that starts empty
+	func(db *journalDB) error { return nil },
+
+	// 4 -> 5 added userIdentities, no longer read and dropped on the next write
+	func(db *journalDB) error { return nil },
+
+	// 5 -> 6 added securitySkipped that starts empty
+	func(db *journalDB) error { return nil },
+
+	// 6 -> 7 moved commentItems into comment-items.linedb, readJournalDB
+	// moves the table of older DBs
+	func(db *journalDB) error { return nil },
+}
+
+var accountDataMigrations = []func(accountData *accountData) error{
+	// 0 -> 1 only added the version scalar
+	func(accountData *accountData) error { return nil },
+}
+
+type newerSchemaError struct {
+	version   int
+	supported int
+}
+
+func (err *newerSchemaError) Error() string {
+	return fmt.Sprintf(
+		"the file has schema version %d and was written by a newer ljdumpgo, this version supports up to %d, upgrade ljdumpgo",
+		err.version, err.supported,
+	)
+}
+
+func isNewerSchemaError(err error) bool {
+	_, ok := err.(*newerSchemaError)
+	return ok
+}
+
+func checkSchemaVersion(version int, supported int) error {
+	if version > supported {
+		return &newerSchemaError{version, supported}
+	}
+	if version < 0 {
+		return fmt.Errorf("invalid schema version %d", version)
+	}
+	return nil
+}
+
+func migrateJournalDB(db *journalDB, version int) error {
+	for ; version < journalDBSchemaVersion; version++ {
+		if err := journalDBMigrations[version](db); err != nil {
+			return fmt.Errorf("failed to upgrade from schema version %d - %s", version, err.Error())
+		}
+	}
+	return nil
+}
+
+func migrateAccountData(accountData *accountData, version int) error {
+	for ; version < accountDataSchemaVersion; version++ {
+		if err := accountDataMigrations[version](accountData); err != nil {
+			return fmt.Errorf("failed to upgrade from schema version %d - %s", version, err.Error())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_parseJournalDBSchemaVersion(t *testing.T) {
+	var db journalDB
+	old := "lastSync \"2010-01-01 00:00:00\"\n"
+	if err := parseJournalDB([]byte(old), &db); err != nil {
+		t.Fatalf("Failed to parse unversioned db - %s", err.Error())
+	}
+	if db.schemaVersion != 0 || db.lastSync != "2010-01-01 00:00:00" {
+		t.Errorf("Unexpected result for unversioned db: version %d, lastSync %q", db.schemaVersion, db.lastSync)
+	}
+
+	db = journalDB{}
+	newer := "schemaVersion 1000\nlastSync \"2010-01-01 00:00:00\"\n"
+	if err := parseJournalDB([]byte(newer), &db); !isNewerSchemaError(err) {
+		t.Errorf("Expected newer schema error, got %v", err)
+	}
+}
+
+func Test_recoverJournalDB(t *testing.T) {
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	config := &Config{dumpDir: dumpDir, journalAliases: make(map[string]string), errorLog: &errorLog{}}
+	jcx := newJournalContext(&ljSession{config: config}, "bob")
+	if err := os.MkdirAll(jcx.dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file breaks in the middle of the comment table after the first
+	// row. The comment file has that comment and one more.
+	db := "schemaVersion 7\nlastSync \"2020-01-01 10:00:00\"\njournalUserId 9\nlayout flat\n\n" +
+		"@table users\n5 alice\n@end\n\n@table commentMeta\n20 5 A\n21 \"broken\n"
+	dbpath := filepath.Join(jcx.dir, journalDBFileName)
+	if err := ioutil.WriteFile(dbpath, []byte(db), 0666); err != nil {
+		t.Fatal(err)
+	}
+	comments := `<?xml version="1.0"?><comments>` +
+		`<comment><id>20</id><user>alice</user><body>First</body></comment>` +
+		`<comment><id>22</id><state>S</state><body>Screened</body></comment></comments>`
+	if err := ioutil.WriteFile(commentFilePath(jcx.dir, 3), []byte(comments), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if r := readJournalDB(jcx); r == nil || !strings.Contains(r.AsText(), "-recover") {
+		t.Fatalf("Expected an error suggesting -recover, got %v", r)
+	}
+	config.recoverCorruptDBs = true
+	jcx = newJournalContext(&ljSession{config: config}, "bob")
+	if r := readJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if jcx.db.lastSync != "2020-01-01 10:00:00" || jcx.db.journalUserId != 9 || jcx.db.userMap[5] != "alice" {
+		t.Errorf("Salvaged data were lost: %q %d %v", jcx.db.lastSync, jcx.db.journalUserId, jcx.db.userMap)
+	}
+	if jcx.db.commentMap[20] != (commentMeta{5, "A"}) || jcx.db.commentMap[22].state != "S" ||
+		jcx.db.commentItems[20] != 3 || jcx.db.commentItems[22] != 3 {
+		t.Errorf("Unexpected comments %v %v", jcx.db.commentMap, jcx.db.commentItems)
+	}
+	quarantined, _ := filepath.Glob(dbpath + ".corrupt-*")
+	if len(quarantined) != 1 {
+		t.Fatalf("Expected the corrupt file to be kept, got %v", quarantined)
+	}
+	if data, _ := ioutil.ReadFile(quarantined[0]); string(data) != db {
+		t.Errorf("The quarantined file differs from the corrupt one")
+	}
+	if config.errorLog.count() == 0 {
+		t.Errorf("Expected the recovery to be reported as warnings")
+	}
+
+	// The rebuilt file reads without -recover
+	config.recoverCorruptDBs = false
+	jcx = newJournalContext(&ljSession{config: config}, "bob")
+	if r := readJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if jcx.db.commentMap[22].state != "S" || jcx.db.commentItems[22] != 3 || jcx.db.lastSync != "2020-01-01 10:00:00" {
+		t.Errorf("Unexpected rebuilt DB %+v", jcx.db)
+	}
+}
+
+func Test_recoverAccountData(t *testing.T) {
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	config := &Config{accountDataDir: dumpDir, errorLog: &errorLog{}, recoverCorruptDBs: true}
+	for _, name := range []string{"user-picture-1.png", "user-picture-4.jpg"} {
+		if err := ioutil.WriteFile(filepath.Join(dumpDir, name), []byte("pic"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The file of the second picture is missing and the record of the last
+	// one is cut
+	db := "schemaVersion 1\nfileCounter 2\n\n@table pictureUrlFileMap\n" +
+		"https://pics.example/1 user-picture-1.png\nhttps://pics.example/2 user-picture-2.png\nhttps://pics.example/4 \"user-pic"
+	if err := ioutil.WriteFile(filepath.Join(dumpDir, accountDataDBFileName), []byte(db), 0666); err != nil {
+		t.Fatal(err)
+	}
+	accountData, r := readAccountData(config)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(accountData.pictureUrlFileMap) != 1 || accountData.pictureUrlFileMap["https://pics.example/1"] != "user-picture-1.png" {
+		t.Errorf("Unexpected userpic files %v", accountData.pictureUrlFileMap)
+	}
+	if accountData.fileCounter != 4 {
+		t.Errorf("Expected the counter past the existing files, got %d", accountData.fileCounter)
+	}
+	quarantined, _ := filepath.Glob(filepath.Join(dumpDir, accountDataDBFileName+".corrupt-*"))
+	if len(quarantined) != 1 {
+		t.Errorf("Expected the corrupt file to be kept, got %v", quarantined)
+	}
+}
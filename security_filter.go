@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// With -security or <dumpSecurity> in the config the dump archives only
+// entries up to the given security level, like the friends page of a
+// reader with that access shows them. This keeps for example private
+// entries off a work machine that mirrors only public posts. The dump
+// still reads the sync log and the comment meta data of the whole journal,
+// but it does not write entries above the level or the comments to them.
+// Their itemids and levels are kept in the securitySkipped table of
+// journal.linedb, so a later dump with a wider level fetches them one by
+// one and queues their comments like verify does for missing comments.
+// When an archived entry becomes more restricted on the server than the
+// level, the dump removes its file, comments, revisions and snapshot
+// together with the step that records it as skipped.
+
+// Level that archives all entries
+const allSecurityName = "all"
+
+// Parse the level of -security, nil means all entries
+func parseDumpSecurity(s string) (*securityLevel, error) {
+	if s == "" || s == allSecurityName {
+		return nil, nil
+	}
+	level, err := parseSecurityLevel(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s or %s", err.Error(), allSecurityName)
+	}
+	return &level, nil
+}
+
+func (config *Config) dumpAllows(level securityLevel) bool {
+	return config.dumpSecurity == nil || level <= *config.dumpSecurity
+}
+
+// Get the security level of an entry from getevents or the monthly export
+func eventSecurityLevel(event map[string]interface{}) securityLevel {
+	security, _ := event["security"].(string)
+	mask, _ := strconv.ParseInt(fmt.Sprint(event["allowmask"]), 10, 64)
+	entry := archivedEntry{security: security, allowMask: mask}
+	return entry.securityLevel()
+}
+
+// Check the entry against the security filter recording it when skipped.
+// Return true when the entry should not be archived.
+func (jcx *journalContext) skipEntryBySecurity(itemId int64, event map[string]interface{}) bool {
+	level := eventSecurityLevel(event)
+	if jcx.config.dumpAllows(level) {
+		if _, present := jcx.db.securitySkipped[itemId]; present {
+			delete(jcx.db.securitySkipped, itemId)
+			jcx.shouldWriteDB = true
+		}
+		return false
+	}
+	jcx.removeArchivedEntry(itemId, level)
+	if jcx.db.securitySkipped[itemId] != level.String() {
+		jcx.db.securitySkipped[itemId] = level.String()
+		jcx.shouldWriteDB = true
+	}
+	log("Skipping %s entry L-%d above -security %s", level, itemId, *jcx.config.dumpSecurity)
+	return true
+}
+
+// Stage the removal of the files of an archived entry that is now above
+// the level
+func (jcx *journalContext) removeArchivedEntry(itemId int64, level securityLevel) {
+	row := jcx.index.rows[itemId]
+	if row == nil || row.file == "" {
+		return
+	}
+	log("Removing archived L-%d as it is now %s above -security %s", itemId, level, *jcx.config.dumpSecurity)
+	entryPath := filepath.Join(jcx.dir, filepath.FromSlash(row.file))
+	jcx.stageRemove(entryPath)
+	jcx.stageRemove(commentFilePath(filepath.Dir(entryPath), itemId))
+	jcx.stageRemove(snapshotFilePath(jcx.dir, itemId))
+	revisions, err := listEntryRevisions(jcx.dir)
+	if err != nil {
+		jcx.config.warn("failed to list revisions of L-%d to remove them - %s", itemId, err.Error())
+	}
+	for _, n := range revisions[itemId] {
+		jcx.stageRemove(revisionFilePath(jcx.dir, itemId, n))
+	}
+	delete(jcx.index.rows, itemId)
+	jcx.index.changed = true
+	for id, archived := range jcx.db.commentItems {
+		if archived == itemId {
+			delete(jcx.db.commentItems, id)
+			jcx.stageCommentItemsChange()
+		}
+	}
+	jcx.shouldWriteDB = true
+}
+
+func (jcx *journalContext) isSkippedBySecurity(itemId int64) bool {
+	_, present := jcx.db.securitySkipped[itemId]
+	return present
+}
+
+// Fetch entries that an earlier dump skipped and the current level allows
+func fetchAllowedSkippedEntries(jcx *journalContext) *Report {
+	var allowed sortIds
+	for itemId, name := range jcx.db.securitySkipped {
+		if level, err := parseSecurityLevel(name); err != nil || jcx.config.dumpAllows(level) {
+			allowed = append(allowed, itemId)
+		}
+	}
+	if len(allowed) == 0 {
+		return nil
+	}
+	sort.Sort(allowed)
+	log("Fetching %d entries of %s that earlier dumps skipped by security", len(allowed), jcx.name)
+	for _, itemId := range allowed {
+		if jcx.sliceExpired() {
+			return nil
+		}
+		params := usejournalParams(jcx.config, jcx.name, map[string]interface{}{
+			"selecttype":  "one",
+			"itemid":      itemId,
+			"lineendings": "unix",
+		})
+		var result struct {
+			Events []map[string]interface{} `xmlrpc:"events"`
+		}
+		if r := callLJXmlRpcMethod(jcx.session, "getevents", params, &result); r != nil {
+			return r
+		}
+		if len(result.Events) == 0 {
+			// Deleted on the server since
+			delete(jcx.db.securitySkipped, itemId)
+		} else if r := jcx.stageEntry(itemId, result.Events[0]); r != nil {
+			return r
+		} else if !jcx.isSkippedBySecurity(itemId) {
+			jcx.db.commentRefetch = append(jcx.db.commentRefetch, itemId)
+			jcx.newEntries++
+			jcx.newEntryIds = append(jcx.newEntryIds, itemId)
+		}
+		jcx.shouldWriteDB = true
+		if r := jcx.commitPendingWrites(); r != nil {
+			return r
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_eventSecurityLevel(t *testing.T) {
+	cases := []struct {
+		event    map[string]interface{}
+		expected securityLevel
+	}{
+		{map[string]interface{}{}, securityPublic},
+		{map[string]interface{}{"security": "usemask", "allowmask": int64(1)}, securityFriends},
+		{map[string]interface{}{"security": "usemask", "allowmask": "6"}, securityCustom},
+		{map[string]interface{}{"security": "private"}, securityPrivate},
+	}
+	for _, c := range cases {
+		if level := eventSecurityLevel(c.event); level != c.expected {
+			t.Errorf("%v: expected %s, got %s", c.event, c.expected, level)
+		}
+	}
+	if level, err := parseDumpSecurity("all"); level != nil || err != nil {
+		t.Errorf("Expected no limit for all, got %v %v", level, err)
+	}
+	if _, err := parseDumpSecurity("secret"); err == nil {
+		t.Errorf("Expected an error for an unknown level")
+	}
+}
+
+func Test_dumpSecurityFilter(t *testing.T) {
+	server := newFakeLJServer(t)
+	defer server.Close()
+	server.eventSecurity = "private"
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	public := securityPublic
+	config := &Config{
+		server:         server.URL,
+		username:       "con",
+		password:       "password",
+		journals:       []string{"con"},
+		dumpDir:        dumpDir,
+		accountDataDir: filepath.Join(dumpDir, accountDataDirName),
+		journalAliases: make(map[string]string),
+		dumpSecurity:   &public,
+	}
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	journalDir := filepath.Join(dumpDir, "con_")
+	for _, name := range []string{"L-1", "C-1"} {
+		if _, err := os.Stat(filepath.Join(journalDir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected no %s of the private entry, got %v", name, err)
+		}
+	}
+	jcx := &journalContext{config: config, name: "con", dir: journalDir}
+	if r := readJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if jcx.db.securitySkipped[1] != "private" {
+		t.Errorf("Expected the skipped entry recorded, got %v", jcx.db.securitySkipped)
+	}
+
+	// Without the limit the next dump fetches the entry and its comments
+	config.dumpSecurity = nil
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	comments, r := readEntryComments(journalDir, 1)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if _, err := os.Stat(filepath.Join(journalDir, "L-1")); err != nil || len(comments) != 1 {
+		t.Errorf("Expected the entry and its comment archived, got %v and %+v", err, comments)
+	}
+	if r := readJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(jcx.db.securitySkipped) != 0 {
+		t.Errorf("Expected no skipped entries, got %v", jcx.db.securitySkipped)
+	}
+
+	// An archived entry made private later is removed with its comments
+	config.dumpSecurity = &public
+	server.eventSyncTime = "2020-01-02 10:00:00"
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	for _, name := range []string{"L-1", "C-1"} {
+		if _, err := os.Stat(filepath.Join(journalDir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected %s of the now private entry removed, got %v", name, err)
+		}
+	}
+	index, r := readEntriesIndex(journalDir)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if index.rows[1] != nil {
+		t.Errorf("Expected the entry removed from the index, got %+v", index.rows[1])
+	}
+	if r := readJournalDB(jcx); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if jcx.db.securitySkipped[1] != "private" || len(jcx.db.commentItems) != 0 {
+		t.Errorf("Unexpected DB after the removal %v %v", jcx.db.securitySkipped, jcx.db.commentItems)
+	}
+}
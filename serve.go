@@ -0,0 +1,489 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Web server that renders the archive on request. Entry pages use the
+// templates of the html export so both look the same.
+
+var serveTemplates = template.Must(template.Must(exportHtmlTemplates.Clone()).Parse(`
+{{define "serve-head"}}<!DOCTYPE html>
+<html lang="{{.Lang}}"><head><meta charset="utf-8"><title>{{.Title}}</title>
+<link rel="stylesheet" href="/style.css"></head>
+<body>{{if .Journal}}<p><a href="/">Journals</a> / <a href="/j/{{.Journal}}/">{{.Journal}}</a></p>
+<form action="/j/{{.Journal}}/search"><input name="q" value="{{.Query}}"> <input type="submit" value="Search"></form>{{end}}
+<h1>{{.Title}}</h1>
+{{end}}
+
+{{define "serve-journals"}}{{template "serve-head" .}}
+<ul>{{range .Journals}}<li><a href="/j/{{.}}/">{{.}}</a></li>{{end}}</ul>
+<p><a href="/userpics/">Userpics</a></p>
+</body></html>
+{{end}}
+
+{{define "serve-userpics"}}{{template "serve-head" .}}
+{{if not .Userpics}}<p>No archived userpics</p>{{end}}
+{{range .Userpics}}<div class="comment"><img class="userpic" src="/userpics/{{.File}}" alt="">
+<p>{{if .Keywords}}<b>{{.Keywords}}</b>{{else}}<i>not in use</i>{{end}}</p>
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+{{if .Comment}}<p class="meta">{{.Comment}}</p>{{end}}
+{{if .CommentCount}}<p class="meta">{{.CommentCount}} comments</p>{{end}}</div>
+{{end}}
+</body></html>
+{{end}}
+
+{{define "serve-journal"}}{{template "serve-head" .}}
+{{range .Years}}<p><a href="/j/{{$.Journal}}/{{.Year}}/">{{.Year}}</a> ({{.Count}}):{{range .Months}}
+<a href="/j/{{$.Journal}}/{{.Year}}/{{.Month}}/">{{.Name}}</a> ({{.Count}}){{end}}</p>
+{{end}}
+{{if .Tags}}<h2>Tags</h2><p>{{range .Tags}}<a href="/j/{{$.Journal}}/tag/{{.Name}}">{{.Name}}</a> ({{.Count}}) {{end}}</p>{{end}}
+<p><a href="/userpics/">Userpics</a></p>
+</body></html>
+{{end}}
+
+{{define "serve-list"}}{{template "serve-head" .}}
+{{if not .Entries}}<p>No entries</p>{{end}}
+<ul>{{range .Entries}}
+<li><span class="meta">{{.Date}}</span> <a href="{{.File}}">{{.Subject}}</a> {{template "security" .}}</li>{{end}}
+</ul>
+</body></html>
+{{end}}
+`))
+
+var mediaFilePattern = regexp.MustCompile(`^[0-9a-f]+\.[a-z0-9]+$`)
+
+type serveMonth struct {
+	Year  int
+	Month int
+	Name  string
+	Count int
+}
+
+type serveYear struct {
+	Year   int
+	Count  int
+	Months []serveMonth
+}
+
+type serveTag struct {
+	Name  string
+	Count int
+}
+
+type sortServeTags []serveTag
+
+func (a sortServeTags) Len() int           { return len(a) }
+func (a sortServeTags) Less(i, j int) bool { return a[i].Name < a[j].Name }
+func (a sortServeTags) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+type serveUserpic struct {
+	File         string
+	Keywords     string
+	Description  string
+	Comment      string
+	CommentCount int
+}
+
+type servePage struct {
+	Lang     string
+	Title    string
+	Journal  string
+	Query    string
+	Journals []string
+	Years    []serveYear
+	Tags     []serveTag
+	Entries  []htmlEntryLink
+	Userpics []serveUserpic
+}
+
+// Entries of a journal are cached until the journal database or the entries
+// index changes. Entries live in year/month subdirectories so the mtime of the
+// journal directory does not change when they are added.
+type serveJournal struct {
+	modTime time.Time
+	entries []*archivedEntry
+	byFile  map[string]*archivedEntry
+}
+
+type archiveServer struct {
+	config       *Config
+	authUser     string
+	authPassword string
+
+	lock     sync.Mutex
+	journals map[string]*serveJournal
+}
+
+func (s *archiveServer) isJournal(name string) bool {
+	for _, journal := range s.config.journals {
+		if journal == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Get entries allowed by the security filter in chronological order.
+func (s *archiveServer) loadJournal(name string) (*serveJournal, *Report) {
+	dir := s.config.journalDir(name)
+	if _, err := archiveStore.Stat(dir); err != nil {
+		if os.IsNotExist(err) {
+			return &serveJournal{}, nil
+		}
+		return nil, WrapErr(err, "")
+	}
+	modTime := fileModTime(filepath.Join(dir, journalDBFileName))
+	if t := fileModTime(filepath.Join(dir, entriesIndexFileName)); t.After(modTime) {
+		modTime = t
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if sj := s.journals[name]; sj != nil && !modTime.IsZero() && sj.modTime.Equal(modTime) {
+		return sj, nil
+	}
+	entries, r := readJournalEntries(dir)
+	if r != nil {
+		return nil, r
+	}
+	sj := &serveJournal{modTime: modTime, byFile: make(map[string]*archivedEntry)}
+	for _, entry := range entries {
+		if entry.securityLevel() <= s.config.maxSecurity {
+			sj.entries = append(sj.entries, entry)
+			sj.byFile[entry.fileName] = entry
+		}
+	}
+	s.config.normalizeEntryTimes(sj.entries)
+	sort.Sort(sortEntriesByTime(sj.entries))
+	s.journals[name] = sj
+	return sj, nil
+}
+
+func (s *archiveServer) entryLinks(journal string, entries []*archivedEntry) []htmlEntryLink {
+	links := make([]htmlEntryLink, len(entries))
+	for i, entry := range entries {
+		links[i] = htmlEntryLink{
+			Date:    s.config.formatDate(entry.eventTime),
+			Subject: entryDisplaySubject(entry),
+			File:    "/j/" + journal + "/entries/" + entry.fileName + ".html",
+			Level:   htmlSecurityLevel(entry.securityLevel()),
+			Adult:   adultContentLabel(entry.adultContent),
+		}
+	}
+	return links
+}
+
+func (s *archiveServer) render(w http.ResponseWriter, name string, page *servePage) {
+	page.Lang = s.config.documentLanguage()
+	var buf bytes.Buffer
+	if err := serveTemplates.ExecuteTemplate(&buf, name, page); err != nil {
+		s.fail(w, WrapErr(err, "failed to render %s", name))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+func (s *archiveServer) fail(w http.ResponseWriter, r *Report) {
+	// Handlers run concurrently so do not record into the error log
+	fmt.Fprintf(os.Stderr, "ERROR: %s", r.AsText())
+	http.Error(w, "internal error, see the server log", http.StatusInternalServerError)
+}
+
+func (s *archiveServer) journalIndex(w http.ResponseWriter, journal string, sj *serveJournal) {
+	page := &servePage{Title: journal, Journal: journal}
+	tagCounts := make(map[string]int)
+	for _, entry := range sj.entries {
+		year, month := entry.yearMonth()
+		if len(page.Years) == 0 || page.Years[len(page.Years)-1].Year != year {
+			page.Years = append(page.Years, serveYear{Year: year})
+		}
+		y := &page.Years[len(page.Years)-1]
+		y.Count++
+		if len(y.Months) == 0 || y.Months[len(y.Months)-1].Month != month {
+			y.Months = append(y.Months, serveMonth{year, month, s.config.monthTitle(month), 0})
+		}
+		y.Months[len(y.Months)-1].Count++
+		for _, tag := range entry.tags() {
+			tagCounts[tag]++
+		}
+	}
+	for tag, count := range tagCounts {
+		page.Tags = append(page.Tags, serveTag{tag, count})
+	}
+	sort.Sort(sortServeTags(page.Tags))
+	s.render(w, "serve-journal", page)
+}
+
+func (s *archiveServer) entryPage(w http.ResponseWriter, journal string, entry *archivedEntry) {
+	ex := &exportJournal{config: s.config, name: journal, dir: s.config.journalDir(journal)}
+	friends, r := readFriendsData(s.config)
+	if r != nil {
+		s.fail(w, r)
+		return
+	}
+	ex.friends = friends
+	page, r := makeHtmlEntryPage(ex, entry)
+	if r != nil {
+		s.fail(w, r)
+		return
+	}
+
+	// Show the picture that the keyword referred to when the entry was
+	// posted. Userpics are archived only for the account itself.
+	if entry.poster == "" || entry.poster == s.config.username {
+		accountData, r := readAccountData(s.config)
+		if r != nil {
+			s.fail(w, r)
+			return
+		}
+		url := accountData.pictureUrlAt(entry.props["picture_keyword"], entry.eventTime)
+		if file := accountData.pictureUrlFileMap[url]; file != "" {
+			page.Userpic = "/userpics/" + file
+			if info := accountData.pictureInfo[url]; info != nil {
+				page.UserpicTitle = info.description
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := exportHtmlTemplates.ExecuteTemplate(&buf, "entry", page); err != nil {
+		s.fail(w, WrapErr(err, "failed to render %s", entry.fileName))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// List archived userpics with the keywords that currently refer to them and
+// the details from the allpics page.
+func (s *archiveServer) userpicsPage(w http.ResponseWriter) {
+	accountData, r := readAccountData(s.config)
+	if r != nil {
+		s.fail(w, r)
+		return
+	}
+	keywords := make(map[string][]string)
+	for keyword, url := range accountData.pictureKeywordUrlMap {
+		keywords[url] = append(keywords[url], keyword)
+	}
+	if accountData.pictureDefaultUrl != "" {
+		keywords[accountData.pictureDefaultUrl] = append(keywords[accountData.pictureDefaultUrl], "(default)")
+	}
+	urls := make([]string, 0, len(accountData.pictureUrlFileMap))
+	for url := range accountData.pictureUrlFileMap {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	page := &servePage{Title: "Userpics"}
+	for _, url := range urls {
+		sort.Strings(keywords[url])
+		userpic := serveUserpic{
+			File:     accountData.pictureUrlFileMap[url],
+			Keywords: strings.Join(keywords[url], ", "),
+		}
+		if info := accountData.pictureInfo[url]; info != nil {
+			userpic.Description = info.description
+			userpic.Comment = info.comment
+			userpic.CommentCount = info.commentCount
+		}
+		page.Userpics = append(page.Userpics, userpic)
+	}
+	s.render(w, "serve-userpics", page)
+}
+
+func (s *archiveServer) serveJournalPath(w http.ResponseWriter, req *http.Request, journal string, rest string) {
+	sj, r := s.loadJournal(journal)
+	if r != nil {
+		s.fail(w, r)
+		return
+	}
+	parts := strings.Split(strings.Trim(rest, "/"), "/")
+	switch {
+	case rest == "" || rest == "/" || rest == "/index.html":
+		s.journalIndex(w, journal, sj)
+		return
+
+	case rest == "/style.css":
+		w.Header().Set("Content-Type", "text/css")
+		w.Write([]byte(exportHtmlStyle))
+		return
+
+	case len(parts) == 2 && parts[0] == "entries" && strings.HasSuffix(parts[1], ".html"):
+		if entry := sj.byFile[strings.TrimSuffix(parts[1], ".html")]; entry != nil {
+			s.entryPage(w, journal, entry)
+			return
+		}
+
+	case len(parts) == 2 && parts[0] == mediaDirName:
+		if !mediaFilePattern.MatchString(parts[1]) {
+			break
+		}
+		serveStoreFile(w, req, filepath.Join(s.config.journalDir(journal), mediaDirName, parts[1]))
+		return
+
+	case len(parts) == 2 && parts[0] == "tag":
+		var matched []*archivedEntry
+		for _, entry := range sj.entries {
+			for _, tag := range entry.tags() {
+				if tag == parts[1] {
+					matched = append(matched, entry)
+					break
+				}
+			}
+		}
+		s.render(w, "serve-list", &servePage{
+			Title: "Tag " + parts[1], Journal: journal, Entries: s.entryLinks(journal, matched),
+		})
+		return
+
+	case len(parts) == 1 && parts[0] == "search":
+		query := strings.TrimSpace(req.FormValue("q"))
+		var matched []*archivedEntry
+		if query != "" {
+			lower := strings.ToLower(query)
+			for _, entry := range sj.entries {
+				text := entry.subject + "\n" + strings.Join(entry.tags(), "\n") + "\n" + htmlToText(entry.event)
+				if strings.Contains(strings.ToLower(text), lower) {
+					matched = append(matched, entry)
+				}
+			}
+		}
+		s.render(w, "serve-list", &servePage{
+			Title: fmt.Sprintf("Search: %s", query), Journal: journal, Query: query,
+			Entries: s.entryLinks(journal, matched),
+		})
+		return
+
+	case len(parts) == 1 || len(parts) == 2:
+		year, err := strconv.Atoi(parts[0])
+		if err != nil {
+			break
+		}
+		month := 0
+		if len(parts) == 2 {
+			if month, err = strconv.Atoi(parts[1]); err != nil {
+				break
+			}
+		}
+		var matched []*archivedEntry
+		for _, entry := range sj.entries {
+			y, m := entry.yearMonth()
+			if y == year && (month == 0 || m == month) {
+				matched = append(matched, entry)
+			}
+		}
+		title := strconv.Itoa(year)
+		if month != 0 {
+			title = s.config.monthTitle(month) + " " + title
+		}
+		s.render(w, "serve-list", &servePage{
+			Title: title, Journal: journal, Entries: s.entryLinks(journal, matched),
+		})
+		return
+	}
+	http.NotFound(w, req)
+}
+
+func (s *archiveServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if s.authUser != "" {
+		user, password, ok := req.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(s.authUser)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(s.authPassword)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="ljdump archive"`)
+			http.Error(w, "authorization required", http.StatusUnauthorized)
+			return
+		}
+	}
+	p := path.Clean(req.URL.Path)
+	if strings.HasSuffix(req.URL.Path, "/") && p != "/" {
+		p += "/"
+	}
+	switch {
+	case p == "/":
+		if len(s.config.journals) == 1 {
+			http.Redirect(w, req, "/j/"+s.config.journals[0]+"/", http.StatusFound)
+			return
+		}
+		s.render(w, "serve-journals", &servePage{Title: "Journals", Journals: s.config.journals})
+
+	case p == "/style.css":
+		w.Header().Set("Content-Type", "text/css")
+		w.Write([]byte(exportHtmlStyle))
+
+	case p == "/userpics/":
+		s.userpicsPage(w)
+
+	case strings.HasPrefix(p, "/userpics/"):
+		name := strings.TrimPrefix(p, "/userpics/")
+		if !userPictureFilePattern.MatchString(name) || strings.Contains(name, "/") {
+			http.NotFound(w, req)
+			return
+		}
+		serveStoreFile(w, req, filepath.Join(s.config.accountDataDir, name))
+
+	case strings.HasPrefix(p, "/j/"):
+		rest := strings.TrimPrefix(p, "/j/")
+		journal := rest
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			journal, rest = rest[:i], rest[i:]
+		} else {
+			http.Redirect(w, req, "/j/"+journal+"/", http.StatusFound)
+			return
+		}
+		if !s.isJournal(journal) {
+			http.NotFound(w, req)
+			return
+		}
+		s.serveJournalPath(w, req, journal, rest)
+
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func runServe(config *Config) *Report {
+	s := &archiveServer{
+		config:   config,
+		journals: make(map[string]*serveJournal),
+	}
+	if config.serveAuthFile != "" {
+		line, err := readFileFirstLine(config.serveAuthFile)
+		if err != nil {
+			return WrapErr(err, "failed to read basic auth credentials from %s", config.serveAuthFile)
+		}
+		i := bytes.IndexByte(line, ':')
+		if i <= 0 {
+			return ReportMsg("%s must contain user:password on the first line", config.serveAuthFile)
+		}
+		s.authUser, s.authPassword = string(line[:i]), string(line[i+1:])
+		config.errorLog.addSecret(s.authPassword)
+	} else if host, _, _ := net.SplitHostPort(config.serveListen); host != "127.0.0.1" && host != "localhost" && host != "::1" {
+		config.warn("serving %s without -auth-file, anybody who can connect can read the archive", config.serveListen)
+	}
+	log("Serving the archive on http://%s/ with entries up to %s security", config.serveListen, config.maxSecurity)
+	startShutdownHandling()
+	server := &http.Server{Addr: config.serveListen, Handler: s}
+	go func() {
+		<-shutdownContext.Done()
+		server.Shutdown(context.Background())
+	}()
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return WrapErr(err, "web server failed")
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_serveJournalCache(t *testing.T) {
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	journalDir := filepath.Join(dumpDir, "bob")
+	monthDir := filepath.Join(journalDir, "2010", "05")
+	if err := os.MkdirAll(monthDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	writeEntry := func(itemId int) {
+		entry := `<?xml version="1.0"?><event><itemid>` + strconv.Itoa(itemId) + `</itemid>` +
+			`<eventtime>2010-05-0` + strconv.Itoa(itemId) + ` 10:00:00</eventtime><subject>Entry</subject><event>text</event></event>`
+		if err := ioutil.WriteFile(filepath.Join(monthDir, "L-"+strconv.Itoa(itemId)), []byte(entry), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	indexPath := filepath.Join(journalDir, entriesIndexFileName)
+	touchIndex := func(modTime time.Time) {
+		if err := ioutil.WriteFile(indexPath, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(indexPath, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeEntry(1)
+	touchIndex(time.Now().Add(-time.Hour))
+
+	s := &archiveServer{
+		config: &Config{
+			dumpDir:        dumpDir,
+			journals:       []string{"bob"},
+			journalAliases: make(map[string]string),
+			maxSecurity:    securityPrivate,
+		},
+		journals: make(map[string]*serveJournal),
+	}
+	check := func(expected int) {
+		sj, r := s.loadJournal("bob")
+		if r != nil {
+			t.Fatal(r.AsText())
+		}
+		if len(sj.entries) != expected {
+			t.Errorf("Unexpected number of served entries %d, expected %d", len(sj.entries), expected)
+		}
+	}
+	check(1)
+
+	// Adding an entry to the month subdirectory keeps the cache until the
+	// index is rewritten
+	dirInfo, err := os.Stat(journalDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeEntry(2)
+	if err := os.Chtimes(journalDir, dirInfo.ModTime(), dirInfo.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	check(1)
+	touchIndex(time.Now())
+	check(2)
+
+	// A missing journal is served as empty
+	sj, r := s.loadJournal("nobody")
+	if r != nil || len(sj.entries) != 0 {
+		t.Errorf("Unexpected result for a missing journal")
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Graceful shutdown on SIGINT or SIGTERM. The fetch loops check the context
+// between items, so an interrupted dump finishes the current item, writes
+// the journal DB and the account data and exits with interruptedExitCode.
+// A second signal terminates the program immediately.
+
+const interruptedExitCode = 130
+
+var shutdownContext = context.Background()
+var startShutdownHandlingOnce sync.Once
+
+// Start catching the signals. Commands that can stop only between items
+// call this, other commands keep the default signal handling.
+func startShutdownHandling() {
+	startShutdownHandlingOnce.Do(func() {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		shutdownContext = ctx
+		go func() {
+			<-ctx.Done()
+			stop()
+			log("Interrupted, finishing the current item. Interrupt again to exit immediately.")
+		}()
+	})
+}
+
+func shutdownRequested() bool {
+	return shutdownContext.Err() != nil
+}
+
+func interruptedReport() *Report {
+	return ReportMsg("interrupted, the progress so far is saved and the next run continues from it")
+}
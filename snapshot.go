@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// With -snapshots the dump also saves each entry page as the server renders
+// it into snapshots/L-<itemid>.html of the journal directory. The snapshot
+// keeps the journal style and the comments as displayed and anything else
+// that the protocol does not return. Pages are fetched with the session,
+// so private and friends-only entries look as they do for the account.
+// A snapshot is taken again when the entry or its comment file is newer
+// than the snapshot. Entries need a permalink, see permalink.go. To keep
+// the load on the server low a run takes at most maxSnapshotsPerRun
+// snapshots and the next run continues. A page that the server renders
+// for a logged-out visitor or as a protected entry notice would replace a
+// good snapshot with an empty one, so it is never saved. When the session
+// appears logged out, the rest of the snapshots wait for the next run.
+
+const snapshotsDirName = "snapshots"
+
+const maxSnapshotsPerRun = 500
+
+func snapshotFilePath(dir string, itemId int64) string {
+	return filepath.Join(dir, snapshotsDirName, fmt.Sprintf("L-%d.html", itemId))
+}
+
+func fileModTime(path string) time.Time {
+	info, err := archiveStore.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// Check if the snapshot is missing or older than the archived files of
+// the entry
+func snapshotOutdated(dir string, row *entryIndexRow) bool {
+	taken := fileModTime(snapshotFilePath(dir, row.itemId))
+	if taken.IsZero() {
+		return true
+	}
+	entryPath := filepath.Join(dir, filepath.FromSlash(row.file))
+	if fileModTime(entryPath).After(taken) {
+		return true
+	}
+	return fileModTime(commentFilePath(filepath.Dir(entryPath), row.itemId)).After(taken)
+}
+
+// LJ marks pages for logged-out visitors with the s-logged-out class and
+// shows them the login form
+var loggedOutPagePattern = regexp.MustCompile(`(?i)class="[^"]*\bs-logged-out\b|<form[^>]*\baction="[^"]*/login\.bml`)
+
+// The notice that the account cannot read the entry
+var protectedPagePattern = regexp.MustCompile(`(?i)<title>[^<]*\bprotected\b`)
+
+var errSnapshotLoggedOut = errors.New("the server shows the page to a logged-out visitor")
+
+func fetchEntrySnapshot(session *ljSession, link string) ([]byte, error) {
+	resp, err := session.client.Get(link)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" && !strings.Contains(contentType, "html") {
+		return nil, fmt.Errorf("unexpected content type %s", contentType)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxMediaFileSize))
+	if err != nil {
+		return nil, err
+	}
+	if loggedOutPagePattern.Match(data) {
+		return nil, errSnapshotLoggedOut
+	}
+	if protectedPagePattern.Match(data) {
+		return nil, errors.New("the server shows the page as a protected entry")
+	}
+	return data, nil
+}
+
+// Save snapshots of outdated entry pages. Failed pages are reported as
+// warnings and tried again on the next run.
+func dumpJournalSnapshots(jcx *journalContext) *Report {
+	index, r := loadEntriesIndex(jcx.dir)
+	if r != nil {
+		return r
+	}
+	var rows []*entryIndexRow
+	for _, row := range index.rows {
+		if row.file != "" && row.permalink != "" && snapshotOutdated(jcx.dir, row) {
+			rows = append(rows, row)
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	sort.Sort(sortIndexRowsByDate(rows))
+	if len(rows) > maxSnapshotsPerRun {
+		log("Taking snapshots of %d of %d entry pages of %s, the next run continues", maxSnapshotsPerRun, len(rows), jcx.name)
+		rows = rows[len(rows)-maxSnapshotsPerRun:]
+	} else {
+		log("Taking snapshots of %d entry pages of %s", len(rows), jcx.name)
+	}
+	if err := mkdirArchive(filepath.Join(jcx.dir, snapshotsDirName)); err != nil {
+		return WrapErr(err, "")
+	}
+	saved := 0
+	for _, row := range rows {
+		if shutdownRequested() {
+			return interruptedReport()
+		}
+		data, err := fetchEntrySnapshot(jcx.session, row.permalink)
+		if err == errSnapshotLoggedOut {
+			jcx.config.warn("stopped taking snapshots of %s as the page %s is shown logged out, the next run tries again",
+				jcx.name, row.permalink)
+			break
+		}
+		if err != nil {
+			jcx.config.warn("failed to take snapshot of %s - %s", row.permalink, err.Error())
+			continue
+		}
+		path := snapshotFilePath(jcx.dir, row.itemId)
+		if err := writeFileTempRename(path, data); err != nil {
+			return WrapErr(err, "failed to write snapshot %s", path)
+		}
+		saved++
+	}
+	if saved != len(rows) {
+		log("Saved %d snapshots of %s", saved, jcx.name)
+	}
+	return nil
+}
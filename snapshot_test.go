@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_entrySnapshots(t *testing.T) {
+	server := newFakeLJServer(t)
+	defer server.Close()
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	config := &Config{
+		server:           server.URL,
+		username:         "con",
+		password:         "password",
+		journals:         []string{"con"},
+		dumpDir:          dumpDir,
+		accountDataDir:   filepath.Join(dumpDir, accountDataDirName),
+		journalAliases:   make(map[string]string),
+		archiveSnapshots: true,
+	}
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	journalDir := filepath.Join(dumpDir, "con_")
+	snapshot := snapshotFilePath(journalDir, 1)
+	data, err := ioutil.ReadFile(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `<div class="comment">Nice</div>`) {
+		t.Errorf("Unexpected snapshot %s", data)
+	}
+
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if server.snapshotRequests != 1 {
+		t.Errorf("Expected no new snapshot of the unchanged entry, got %d requests", server.snapshotRequests)
+	}
+
+	// A changed comment file makes the snapshot outdated
+	taken := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(snapshot, taken, taken); err != nil {
+		t.Fatal(err)
+	}
+	server.commentBody = "Edited"
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if server.snapshotRequests != 2 {
+		t.Errorf("Expected a new snapshot, got %d requests", server.snapshotRequests)
+	}
+	if data, _ := ioutil.ReadFile(snapshot); !strings.Contains(string(data), "Edited") {
+		t.Errorf("Expected the edited comment in the snapshot, got %s", data)
+	}
+}
+
+func Test_subdomainSnapshots(t *testing.T) {
+	server := newFakeLJServer(t)
+	defer server.Close()
+	addr := server.Listener.Addr().String()
+	_, port, _ := net.SplitHostPort(addr)
+	server.eventUrl = "http://con.lj.test:" + port + "/298.html"
+
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+
+	config := &Config{
+		server:           "http://www.lj.test:" + port,
+		username:         "con",
+		password:         "password",
+		journals:         []string{"con"},
+		dumpDir:          dumpDir,
+		accountDataDir:   filepath.Join(dumpDir, accountDataDirName),
+		journalAliases:   make(map[string]string),
+		archiveSnapshots: true,
+		errorLog:         &errorLog{},
+		// Resolve all hosts of lj.test to the fake server
+		transport: &http.Transport{DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		}},
+	}
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	snapshot := snapshotFilePath(filepath.Join(dumpDir, "con_"), 1)
+	data, err := ioutil.ReadFile(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `<div class="comment">Nice</div>`) {
+		t.Errorf("Unexpected snapshot %s", data)
+	}
+
+	// The protected entry notice does not replace the snapshot
+	taken := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(snapshot, taken, taken); err != nil {
+		t.Fatal(err)
+	}
+	server.commentBody = "Edited"
+	server.snapshotProtected = true
+	warnings := config.errorLog.count()
+	if r := runDump(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if server.snapshotRequests != 2 || config.errorLog.count() == warnings {
+		t.Errorf("Expected a warning about the protected page, got %d requests", server.snapshotRequests)
+	}
+	if data, _ := ioutil.ReadFile(snapshot); !strings.Contains(string(data), "Nice") {
+		t.Errorf("Expected the previous snapshot, got %s", data)
+	}
+}
+
+func Test_fetchEntrySnapshotLoggedOut(t *testing.T) {
+	server := newFakeLJServer(t)
+	defer server.Close()
+	session := &ljSession{config: &Config{}, jar: newSessionJar()}
+	session.client.Transport = http.DefaultTransport
+	if _, err := fetchEntrySnapshot(session, server.URL+"/298.html"); err != errSnapshotLoggedOut {
+		t.Errorf("Expected the logged-out page detected, got %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+func printJournalStats(config *Config, journal string) *Report {
+	dir := config.journalDir(journal)
+	entries, r := readJournalEntries(dir)
+	if r != nil {
+		return r
+	}
+	if entries == nil {
+		fmt.Printf("%s: no archived entries\n\n", journal)
+		return nil
+	}
+
+	commentFiles := 0
+	if files, err := listDumpFiles(dir); err == nil {
+		for _, file := range files {
+			if strings.HasPrefix(filepath.Base(file), "C-") {
+				commentFiles++
+			}
+		}
+	}
+
+	jcx := &journalContext{config: config, name: journal, dir: dir}
+	if r := readJournalDB(jcx); r != nil {
+		return r
+	}
+
+	fmt.Printf("%s:\n", journal)
+	fmt.Printf("  entries:            %d\n", len(entries))
+	fmt.Printf("  comments:           %d in %d files\n", len(jcx.db.commentMap), commentFiles)
+	fmt.Printf("  last sync:          %s\n", jcx.db.lastSync)
+	if len(jcx.db.syncActions) != 0 {
+		actions := make(map[string]int)
+		for _, a := range jcx.db.syncActions {
+			if strings.HasPrefix(a.item, "L-") {
+				actions[a.action]++
+			}
+		}
+		fmt.Printf("  entry sync log:     %d created, %d updated, %d deleted since %s\n",
+			actions["create"], actions["update"], actions["del"], jcx.db.syncActions[0].time)
+	}
+	if len(jcx.db.commentStates) != 0 {
+		fmt.Printf("  comment state log:  %s since %s\n",
+			summarizeCommentStates(jcx.db.commentStates), jcx.db.commentStates[0].time)
+	}
+	if revisions, r := summarizeEntryRevisions(dir); r != nil {
+		return r
+	} else if revisions.entries != 0 {
+		fmt.Printf("  entry revisions:    %d of %d edited entries, %d words removed, %d added\n",
+			revisions.revisions, revisions.entries, revisions.removed, revisions.added)
+	}
+	cert, r := readVerificationCertificate(dir)
+	if r != nil {
+		return r
+	}
+	if cert == nil {
+		fmt.Printf("  last verified:      never, run verify command\n")
+	} else {
+		fmt.Printf("  last verified:      %s (%s)\n", cert.verified.Local().Format("2006-01-02 15:04"), verifiedAgo(cert.verified, time.Now()))
+		fmt.Printf("    entries %s; comments %s\n", &cert.entries, &cert.comments)
+	}
+
+	gaps, counts, r := checkJournalYearGaps(dir)
+	if r != nil {
+		return r
+	}
+	archived := archivedYearCounts(entries)
+	if counts == nil {
+		fmt.Printf("  server counts:      not fetched, run analyze command\n")
+		for _, year := range sortedYears(archived) {
+			fmt.Printf("    %4d %6d\n", year, archived[year])
+		}
+	} else {
+		fmt.Printf("  server counts:      fetched %s\n", counts.fetched)
+		fmt.Printf("    year archived server\n")
+		years := make(map[int]int)
+		for year := range archived {
+			years[year] = 0
+		}
+		for year := range counts.yearCounts {
+			years[year] = 0
+		}
+		for _, year := range sortedYears(years) {
+			mark := ""
+			if archived[year] < counts.yearCounts[year] {
+				mark = "  <-- missing entries"
+			}
+			fmt.Printf("    %4d %8d %6d%s\n", year, archived[year], counts.yearCounts[year], mark)
+		}
+	}
+	if r := printCommenterStats(config, &jcx.db); r != nil {
+		return r
+	}
+	if config.heatmapDir != "" {
+		if r := writeJournalHeatmaps(config, journal); r != nil {
+			return r
+		}
+	}
+	fmt.Println()
+	reportYearGaps(journal, gaps)
+	return nil
+}
+
+type commenterCount struct {
+	user  string
+	count int
+}
+
+type sortCommenterCounts []commenterCount
+
+func (a sortCommenterCounts) Len() int { return len(a) }
+func (a sortCommenterCounts) Less(i, j int) bool {
+	if a[i].count != a[j].count {
+		return a[i].count > a[j].count
+	}
+	return a[i].user < a[j].user
+}
+func (a sortCommenterCounts) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+
+// Print the number of commenters per relationship to the account owner and
+// the most active commenters.
+func printCommenterStats(config *Config, db *journalDB) *Report {
+	friends, r := readFriendsData(config)
+	if r != nil {
+		return r
+	}
+	counts := make(map[string]int)
+	for _, meta := range db.commentMap {
+		counts[db.userMap[meta.posterId]]++
+	}
+	commenters := make(sortCommenterCounts, 0, len(counts))
+	for user, count := range counts {
+		commenters = append(commenters, commenterCount{user, count})
+	}
+	sort.Sort(commenters)
+
+	if friends == nil {
+		fmt.Printf("  commenters:         %d, friends are not archived\n", len(commenters))
+	} else {
+		relationCommenters := make(map[string]int)
+		relationComments := make(map[string]int)
+		for _, c := range commenters {
+			relation := friends.relationship(config, c.user)
+			relationCommenters[relation]++
+			relationComments[relation] += c.count
+		}
+		fmt.Printf("  commenters:         %d, friends as of %s\n", len(commenters), friends.fetched)
+		for _, relation := range []string{
+			relationSelf, relationMutual, relationFriend, relationFriendOf, relationStranger, relationAnonymous,
+		} {
+			if relationCommenters[relation] != 0 {
+				fmt.Printf("    %-14s %5d users %7d comments\n",
+					relation, relationCommenters[relation], relationComments[relation])
+			}
+		}
+	}
+	const topCommenters = 10
+	for i, c := range commenters {
+		if i == topCommenters {
+			break
+		}
+		user, relation := c.user, ""
+		if user == "" {
+			user = "(anonymous)"
+		} else if friends != nil {
+			relation = friends.relationship(config, c.user)
+		}
+		fmt.Printf("    %-20s %7d %s\n", user, c.count, relation)
+	}
+	return nil
+}
+
+func runStats(config *Config) *Report {
+	for _, journal := range config.journals {
+		if r := printJournalStats(config, journal); r != nil {
+			return r
+		}
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+)
+
+// All files of the archive are accessed through archiveStore so the
+// archive can live on a remote server. The paths stay the same as for a
+// local archive, relative to the current directory, and the store maps
+// them to its location. The default store is the local file system. With
+// <webdav> in the config the archive is kept on a WebDAV server, see
+// webdav.go. Each store makes WriteFile atomic in its own way so readers
+// and an interrupted run never see a partially written file. The lock
+// file, the config and files of ljdump.py always stay local.
+
+type store interface {
+	ReadFile(path string) ([]byte, error)
+
+	// Replace the file with data atomically. perm applies where the store
+	// supports permissions.
+	WriteFile(path string, data []byte, perm os.FileMode) error
+
+	// Rename the file replacing the target if it exists
+	Rename(from, to string) error
+	Remove(path string) error
+	Stat(path string) (os.FileInfo, error)
+
+	// List the directory sorted by name
+	ReadDir(path string) ([]os.FileInfo, error)
+
+	// Create the directory with its parents. An existing directory keeps
+	// its permissions.
+	MkdirAll(path string) error
+}
+
+var archiveStore store = localStore{}
+
+type localStore struct{}
+
+func (localStore) ReadFile(path string) ([]byte, error) {
+	return ioutil.ReadFile(path)
+}
+
+// The data reaches the disk before the rename and the rename before the
+// call returns, so after a crash the file has either the old or the new
+// content.
+func (localStore) WriteFile(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func (localStore) Rename(from, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(to))
+}
+
+// Flush the directory entries to the disk. Windows cannot open
+// directories for that and makes renames durable itself.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (localStore) Remove(path string) error {
+	return os.Remove(path)
+}
+
+func (localStore) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(path)
+}
+
+func (localStore) ReadDir(path string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(path)
+}
+
+func (localStore) MkdirAll(dir string) error {
+	if info, err := os.Stat(dir); err == nil && info.IsDir() {
+		return nil
+	}
+	if err := os.MkdirAll(dir, archiveDirMode); err != nil {
+		return err
+	}
+	return os.Chmod(dir, archiveDirMode)
+}
+
+func isLocalStore() bool {
+	_, local := archiveStore.(localStore)
+	return local
+}
+
+// Call fn for root and everything under it like filepath.Walk
+func walkStore(root string, fn filepath.WalkFunc) error {
+	info, err := archiveStore.Stat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	return walkStoreDir(root, info, fn)
+}
+
+func walkStoreDir(path string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if err := fn(path, info, nil); err != nil || !info.IsDir() {
+		if err == filepath.SkipDir {
+			err = nil
+		}
+		return err
+	}
+	infos, err := archiveStore.ReadDir(path)
+	if err != nil {
+		return fn(path, info, err)
+	}
+	for _, child := range infos {
+		if err := walkStoreDir(filepath.Join(path, child.Name()), child, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type sortFileInfos []os.FileInfo
+
+func (a sortFileInfos) Len() int           { return len(a) }
+func (a sortFileInfos) Less(i, j int) bool { return a[i].Name() < a[j].Name() }
+func (a sortFileInfos) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+func sortFileInfosByName(infos []os.FileInfo) {
+	sort.Sort(sortFileInfos(infos))
+}
+
+// Serve an archived file like http.ServeFile
+func serveStoreFile(w http.ResponseWriter, req *http.Request, path string) {
+	info, err := archiveStore.Stat(path)
+	var data []byte
+	if err == nil {
+		data, err = archiveStore.ReadFile(path)
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, req)
+			return
+		}
+		http.Error(w, "failed to read the file", http.StatusInternalServerError)
+		return
+	}
+	http.ServeContent(w, req, filepath.Base(path), info.ModTime(), bytes.NewReader(data))
+}
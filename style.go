@@ -0,0 +1,199 @@
+package main
+
+import (
+	"html"
+	"io"
+	"io/ioutil"
+	"linedb"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The protocol gives no access to the look of the journal. With -style the
+// dump fetches the customization pages of the account with the session and
+// archives the values of their form fields: the S2 layout and theme, the
+// custom CSS, the link list and the journal title and header texts. The
+// field names differ between LJ versions and forks, so all fields are kept
+// as they are and the pages themselves are stored too for a future static
+// rendering to approximate the original look.
+
+const styleDBFileName = "style.linedb"
+const styleDirName = "style"
+
+type stylePage struct {
+	name string
+	path string
+}
+
+var stylePages = []stylePage{
+	{"theme", "/customize/"},
+	{"options", "/customize/options.bml"},
+	{"customcss", "/customize/options.bml?group=customcss"},
+	{"text", "/customize/options.bml?group=text"},
+	{"links", "/manage/links.bml"},
+}
+
+type styleField struct {
+	page  string
+	name  string
+	value string
+}
+
+// An input tag, a textarea with its text or a select with its options
+var styleFormFieldPattern = regexp.MustCompile(`(?is)<input\b[^>]*>|<(textarea|select)\b([^>]*)>(.*?)</(?:textarea|select)>`)
+var styleOptionPattern = regexp.MustCompile(`(?is)<option\b([^>]*)>([^<]*)`)
+var styleSelectedPattern = regexp.MustCompile(`(?i)\s(?:selected|checked)(?:\s*=|[\s/>])`)
+
+// Form fields that carry session tokens rather than settings
+var styleIgnoredInputTypes = map[string]bool{
+	"hidden": true, "submit": true, "button": true, "image": true, "reset": true, "password": true, "file": true,
+}
+
+// Extract the current values of the form fields of the page in the page
+// order
+func parseStyleFormFields(page string, content string) []styleField {
+	var fields []styleField
+	for _, m := range styleFormFieldPattern.FindAllStringSubmatch(content, -1) {
+		switch strings.ToLower(m[1]) {
+		case "":
+			name := htmlAttr(m[0], "name")
+			kind := strings.ToLower(htmlAttr(m[0], "type"))
+			if name == "" || styleIgnoredInputTypes[kind] {
+				continue
+			}
+			if (kind == "checkbox" || kind == "radio") && !styleSelectedPattern.MatchString(m[0]) {
+				continue
+			}
+			value := htmlAttr(m[0], "value")
+			if value == "" && kind == "checkbox" {
+				// What browsers send for a checked box without a value
+				value = "on"
+			}
+			fields = append(fields, styleField{page, name, value})
+		case "textarea":
+			if name := htmlAttr(m[2], "name"); name != "" {
+				fields = append(fields, styleField{page, name, html.UnescapeString(m[3])})
+			}
+		case "select":
+			name := htmlAttr(m[2], "name")
+			if name == "" {
+				continue
+			}
+			for _, option := range styleOptionPattern.FindAllStringSubmatch(m[3], -1) {
+				if styleSelectedPattern.MatchString(option[1] + ">") {
+					value := htmlAttr(option[1], "value")
+					if value == "" {
+						value = strings.TrimSpace(html.UnescapeString(option[2]))
+					}
+					fields = append(fields, styleField{page, name, value})
+				}
+			}
+		}
+	}
+	return fields
+}
+
+func fetchStylePage(session *ljSession, page stylePage) ([]byte, *Report) {
+	geturl := session.config.server + page.path
+	resp, err := session.client.Get(geturl)
+	if err != nil {
+		return nil, WrapErr(err, "failed to get %s", geturl)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, ReportMsg("unexpected status %s for %s", resp.Status, geturl)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxMediaFileSize))
+	if err != nil {
+		return nil, WrapErr(err, "failed to read %s", geturl)
+	}
+	return data, nil
+}
+
+// Archive the customization pages of the account. A page that cannot be
+// fetched or has no form fields keeps its previously archived fields.
+func dumpJournalStyle(session *ljSession) *Report {
+	config := session.config
+	oldFields, r := readStyleFields(config)
+	if r != nil {
+		return r
+	}
+	styleDir := filepath.Join(config.accountDataDir, styleDirName)
+	if err := mkdirArchive(styleDir); err != nil {
+		return WrapErr(err, "")
+	}
+	log("Archiving journal style of %s", config.username)
+	var fields []styleField
+	for _, page := range stylePages {
+		if shutdownRequested() {
+			return interruptedReport()
+		}
+		data, r := fetchStylePage(session, page)
+		var pageFields []styleField
+		if r == nil {
+			pageFields = parseStyleFormFields(page.name, string(data))
+			if len(pageFields) == 0 {
+				r = ReportMsg("no settings found on %s%s, the page layout may have changed", config.server, page.path)
+			}
+		}
+		if r != nil {
+			session.config.warn("failed to archive %s style settings - %s", page.name, r.AsText())
+			for _, f := range oldFields {
+				if f.page == page.name {
+					fields = append(fields, f)
+				}
+			}
+			continue
+		}
+		fields = append(fields, pageFields...)
+		path := filepath.Join(styleDir, page.name+".html")
+		if err := writeFileTempRename(path, data); err != nil {
+			return WrapErr(err, "failed to write %s", path)
+		}
+	}
+
+	e := linedb.NewByteEncoder()
+	e.Scalar("fetched").AddString(time.Now().UTC().Format("2006-01-02 15:04:05"))
+	e.EmptyLine()
+	e.Comment("page field value")
+	e.Table("fields")
+	for _, f := range fields {
+		e.AddString(f.page).AddString(f.name).AddString(f.value).EndRow()
+	}
+	e.EndTable()
+	dbpath := filepath.Join(config.accountDataDir, styleDBFileName)
+	if err := writeFileTempRename(dbpath, e.GetBytes()); err != nil {
+		return WrapErr(err, "failed to write style file %s", dbpath)
+	}
+	return nil
+}
+
+// Return nil without error if the style was never archived.
+func readStyleFields(config *Config) ([]styleField, *Report) {
+	dbpath := filepath.Join(config.accountDataDir, styleDBFileName)
+	dbdata, err := archiveStore.ReadFile(dbpath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, WrapErr(err, "")
+	}
+	var fields []styleField
+	d := newLinedbDecoder(dbdata)
+	for d.NextItem() {
+		if d.ItemKind == linedb.TableItem {
+			for d.NextRow() {
+				if d.ItemName == "fields" {
+					fields = append(fields, styleField{d.GetString(), d.GetString(), d.GetString()})
+				}
+			}
+		}
+	}
+	if err := d.GetError(); err != nil {
+		return nil, WrapErr(err, "error while parsing style file %s as linedb", dbpath)
+	}
+	return fields, nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func Test_parseStyleFormFields(t *testing.T) {
+	page := `<form><input type="hidden" name="lj_form_auth" value="token">
+<select name="s2_layout"><option value="1">Generator</option><option value="7" selected>Flexible Squares</option></select>
+<input type="checkbox" name="show_entrynav" checked> <input type="checkbox" name="use_shared_pic">
+<textarea name="custom_css" rows="10">body { color: &quot;red&quot; }</textarea>
+<input name="text_header" value="My &amp; journal"><input type="submit" value="Save"></form>`
+	expected := []styleField{
+		{"theme", "s2_layout", "7"},
+		{"theme", "show_entrynav", "on"},
+		{"theme", "custom_css", `body { color: "red" }`},
+		{"theme", "text_header", "My & journal"},
+	}
+	fields := parseStyleFormFields("theme", page)
+	if len(fields) != len(expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, fields)
+	}
+	for i := range expected {
+		if fields[i] != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], fields[i])
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The takeout command packs everything archived for the account into one
+// zip file that can be handed to someone who never used ljdumpgo. The zip
+// has a single top directory with:
+//
+//	index.html     start page linking to the journals with the userpics
+//	               and friends of the account
+//	README.txt     description of the bundle, takeoutReadme below
+//	takeout.json   manifest with the format version and the contents
+//	journals/      the html export of each journal including comments and
+//	               images
+//	userpics/      the userpics of the account
+//	archive/       the archived files as they are in the dump directory:
+//	               journal directories and account.data without
+//	               credentials
+//
+// The journal pages are rendered with the html export into the takeout
+// subdirectory of the export directory, so a later takeout renders only
+// the changed entries. Bump takeoutFormatVersion when the layout changes.
+
+const takeoutFormatVersion = 1
+
+const takeoutDirName = "takeout"
+
+// Files of account.data with credentials that never leave the dump
+// directory
+var takeoutSecretFiles = map[string]bool{
+	oauthDBFileName:      true,
+	anonymizeKeyFileName: true,
+}
+
+const takeoutReadme = `This is a copy of LiveJournal journals made with ljdumpgo.
+
+Open index.html in a web browser to read the journals. Everything works
+without an internet connection except images that were not downloaded
+and links to other pages on the internet.
+
+Folders:
+
+journals  the pages of each journal with its comments and images
+userpics  the userpics of the account
+archive   the files exactly as ljdumpgo saved them. Entries are the
+          L-<number> files and comments the C-<number> files, both in
+          XML. Keep this folder: other tools and newer ljdumpgo versions
+          can read it, see https://github.com/ibukanov/ljdump-go
+
+takeout.json lists the contents for programs.
+
+Private and friends-only entries are included and marked on their
+pages. Do not publish this copy without removing them.
+`
+
+type takeoutJournal struct {
+	Name     string `json:"name"`
+	Entries  int    `json:"entries"`
+	Comments int    `json:"comments"`
+
+	// Path of the start page of the journal inside the top directory
+	Index string `json:"index"`
+}
+
+type takeoutUserpic struct {
+	Keyword string `json:"keyword"`
+	File    string `json:"file"`
+}
+
+type takeoutFriend struct {
+	User     string `json:"user"`
+	FullName string `json:"fullName,omitempty"`
+	Relation string `json:"relation"`
+}
+
+type takeoutManifest struct {
+	Version  int              `json:"version"`
+	Created  string           `json:"created"`
+	Server   string           `json:"server"`
+	Username string           `json:"username"`
+	Journals []takeoutJournal `json:"journals"`
+	Userpics []takeoutUserpic `json:"userpics"`
+	Friends  []takeoutFriend  `json:"friends"`
+}
+
+var takeoutIndexTemplate = template.Must(template.New("").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}"><head><meta charset="utf-8"><title>{{.Username}}</title>
+<style>
+body { font-family: serif; max-width: 50em; margin: auto; padding: 1em; }
+.meta { color: #555; font-size: small; }
+.userpics img { max-height: 100px; margin: 4px; }
+</style></head>
+<body><h1>{{.Username}}</h1>
+<p class="meta">Archive of {{.Server}} made on {{.Created}}. See README.txt for what is inside.</p>
+<h2>Journals</h2>
+<ul>{{range .Journals}}
+<li><a href="{{.Index}}">{{.Name}}</a> <span class="meta">{{.Entries}} entries, {{.Comments}} comments</span></li>{{end}}
+</ul>
+{{if .Userpics}}<h2>Userpics</h2>
+<div class="userpics">{{range .Userpics}}<img src="{{.File}}" alt="{{.Keyword}}" title="{{.Keyword}}">{{end}}</div>{{end}}
+{{if .Friends}}<h2>Friends</h2>
+<ul>{{range .Friends}}
+<li>{{.User}}{{if .FullName}} ({{.FullName}}){{end}} <span class="meta">{{.Relation}}</span></li>{{end}}
+</ul>{{end}}
+</body></html>
+`))
+
+type takeoutZip struct {
+	w       *zip.Writer
+	root    string
+	created time.Time
+	files   int
+}
+
+func (t *takeoutZip) add(name string, data []byte) error {
+	f, err := t.w.CreateHeader(&zip.FileHeader{Name: t.root + "/" + name, Method: zip.Deflate, Modified: t.created})
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	t.files++
+	return err
+}
+
+// Add the files under dir as the zip directory name. skip is called with
+// the path of each file relative to dir.
+func (t *takeoutZip) addTree(name string, dir string, skip func(rel string) bool) error {
+	return walkStore(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == dir {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(p, ".tmp") || strings.HasSuffix(p, pendingSuffix) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if skip != nil && skip(rel) {
+			return nil
+		}
+		data, err := archiveStore.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return t.add(path.Join(name, filepath.ToSlash(rel)), data)
+	})
+}
+
+func runTakeout(config *Config) *Report {
+	render := *config
+	render.exportFormat = "html"
+	render.exportDir = filepath.Join(config.exportDir, takeoutDirName)
+	if r := runExport(&render); r != nil {
+		return r
+	}
+	viewerDir := filepath.Join(render.exportDir, "html")
+
+	created := time.Now()
+	manifest := &takeoutManifest{
+		Version:  takeoutFormatVersion,
+		Created:  created.Format(time.RFC3339),
+		Server:   config.server,
+		Username: config.username,
+	}
+	root := "ljdump-takeout-" + portableFileName(config.username) + "-" + created.Format("20060102")
+	zipPath := filepath.Join(config.exportDir, root+".zip")
+
+	// Stream the zip into a temporary file next to the target and rename
+	// it when complete so the media never has to fit into the memory and
+	// a failed run never leaves a partial zip. With remote storage the
+	// temporary file is local and uploaded at the end.
+	tmpDir := filepath.Dir(zipPath)
+	if !isLocalStore() {
+		tmpDir = ""
+	}
+	tmp, err := ioutil.TempFile(tmpDir, ".takeout-*.tmp")
+	if err != nil {
+		return WrapErr(err, "")
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	t := &takeoutZip{
+		w:       zip.NewWriter(tmp),
+		root:    root,
+		created: created,
+	}
+	for _, journal := range config.journals {
+		dir := config.journalDir(journal)
+		index, r := loadEntriesIndex(dir)
+		if r != nil {
+			return r
+		}
+		entry := takeoutJournal{Name: journal, Entries: len(index.rows)}
+		for _, row := range index.rows {
+			entry.Comments += row.comments
+		}
+		zipName := portableFileName(journal)
+		entry.Index = "journals/" + zipName + "/index.html"
+		manifest.Journals = append(manifest.Journals, entry)
+
+		err := t.addTree("journals/"+zipName, filepath.Join(viewerDir, zipName), func(rel string) bool {
+			return rel == exportStateFileName
+		})
+		if err == nil {
+			err = t.addTree("archive/"+filepath.ToSlash(filepath.Base(dir)), dir, nil)
+		}
+		if err != nil {
+			return WrapErr(err, "failed to add journal %s to the takeout", journal)
+		}
+	}
+
+	accountData, r := readAccountData(config)
+	if r != nil {
+		return r
+	}
+	for url, file := range accountData.pictureUrlFileMap {
+		keyword := ""
+		for k, u := range accountData.pictureKeywordUrlMap {
+			if u == url {
+				keyword = k
+			}
+		}
+		if keyword == "" && url == accountData.pictureDefaultUrl {
+			keyword = "default"
+		}
+		data, err := archiveStore.ReadFile(filepath.Join(config.accountDataDir, file))
+		if err != nil {
+			config.warn("skipping userpic %s - %s", file, err.Error())
+			continue
+		}
+		if err := t.add("userpics/"+file, data); err != nil {
+			return WrapErr(err, "")
+		}
+		manifest.Userpics = append(manifest.Userpics, takeoutUserpic{keyword, "userpics/" + file})
+	}
+	sort.Slice(manifest.Userpics, func(i, j int) bool { return manifest.Userpics[i].File < manifest.Userpics[j].File })
+	err = t.addTree("archive/"+accountDataDirName, config.accountDataDir, func(rel string) bool {
+		return takeoutSecretFiles[rel]
+	})
+	if err != nil {
+		return WrapErr(err, "failed to add account data to the takeout")
+	}
+
+	friends, r := readFriendsData(config)
+	if r != nil {
+		return r
+	}
+	if friends != nil {
+		users := make(map[string]*friendInfo)
+		for user, info := range friends.friendOfs {
+			users[user] = info
+		}
+		for user, info := range friends.friends {
+			users[user] = info
+		}
+		for user, info := range users {
+			manifest.Friends = append(manifest.Friends, takeoutFriend{user, info.fullName, friends.relationship(config, user)})
+		}
+		sort.Slice(manifest.Friends, func(i, j int) bool { return manifest.Friends[i].User < manifest.Friends[j].User })
+	}
+
+	var page bytes.Buffer
+	err = takeoutIndexTemplate.Execute(&page, struct {
+		*takeoutManifest
+		Lang string
+	}{manifest, config.documentLanguage()})
+	if err != nil {
+		return WrapErr(err, "failed to render the takeout start page")
+	}
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err == nil {
+		err = t.add("index.html", page.Bytes())
+	}
+	if err == nil {
+		err = t.add("README.txt", []byte(takeoutReadme))
+	}
+	if err == nil {
+		err = t.add("takeout.json", append(manifestData, '\n'))
+	}
+	if err == nil {
+		err = t.w.Close()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err != nil {
+		return WrapErr(err, "failed to create the takeout zip")
+	}
+	if isLocalStore() {
+		err = os.Chmod(tmp.Name(), archiveFileMode)
+		if err == nil {
+			err = os.Rename(tmp.Name(), zipPath)
+		}
+	} else {
+		var data []byte
+		data, err = ioutil.ReadFile(tmp.Name())
+		if err == nil {
+			err = writeFileTempRename(zipPath, data)
+		}
+	}
+	if err != nil {
+		return WrapErr(err, "failed to write %s", zipPath)
+	}
+	log("Wrote %d files to %s", t.files, zipPath)
+	return nil
+}
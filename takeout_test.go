@@ -0,0 +1,102 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_runTakeout(t *testing.T) {
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	journalDir := filepath.Join(dumpDir, "bob")
+	accountDir := filepath.Join(dumpDir, accountDataDirName)
+	for _, dir := range []string{journalDir, accountDir} {
+		if err := os.Mkdir(dir, 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string]string{
+		filepath.Join(journalDir, "L-1"):                `<?xml version="1.0"?><event><itemid>1</itemid><eventtime>2010-05-01 10:00:00</eventtime><subject>One</subject><event>text</event></event>`,
+		filepath.Join(journalDir, "C-1"):                `<?xml version="1.0"?><comments><comment><id>5</id><user>alice</user><body>Hi</body></comment></comments>`,
+		filepath.Join(accountDir, "user-picture-1.png"): "png",
+		filepath.Join(accountDir, oauthDBFileName):      "secret\n",
+	}
+	for path, data := range files {
+		if err := ioutil.WriteFile(path, []byte(data), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := &Config{
+		server:         defaultLJServer,
+		username:       "bob",
+		dumpDir:        dumpDir,
+		accountDataDir: accountDir,
+		journals:       []string{"bob"},
+		journalAliases: make(map[string]string),
+		exportDir:      filepath.Join(dumpDir, "export"),
+		maxSecurity:    securityPrivate,
+	}
+	accountData := &accountData{
+		fileCounter:       1,
+		pictureDefaultUrl: "http://pics/1",
+		pictureUrlFileMap: map[string]string{"http://pics/1": "user-picture-1.png"},
+	}
+	if r := writeAccountData(accountData, config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if r := runTakeout(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	zips, _ := filepath.Glob(filepath.Join(config.exportDir, "ljdump-takeout-bob-*.zip"))
+	if len(zips) != 1 {
+		t.Fatalf("Expected one takeout zip, got %v", zips)
+	}
+	if tmps, _ := filepath.Glob(filepath.Join(config.exportDir, ".takeout-*")); len(tmps) != 0 {
+		t.Errorf("Temporary files left behind %v", tmps)
+	}
+	zr, err := zip.OpenReader(zips[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	names := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		names[f.Name[strings.Index(f.Name, "/")+1:]] = f
+	}
+	for _, name := range []string{
+		"index.html", "README.txt", "takeout.json", "journals/bob/index.html",
+		"journals/bob/entries/L-1.html", "archive/bob/L-1", "archive/bob/C-1",
+		"archive/account.data/" + accountDataDBFileName, "userpics/user-picture-1.png",
+	} {
+		if names[name] == nil {
+			t.Errorf("Takeout has no %s", name)
+		}
+	}
+	if names["archive/account.data/"+oauthDBFileName] != nil {
+		t.Errorf("Takeout contains OAuth tokens")
+	}
+	rc, err := names["takeout.json"].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest takeoutManifest
+	err = json.NewDecoder(rc).Decode(&manifest)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Version != takeoutFormatVersion || len(manifest.Journals) != 1 ||
+		manifest.Journals[0].Entries != 1 || manifest.Journals[0].Comments != 1 ||
+		len(manifest.Userpics) != 1 || manifest.Userpics[0].Keyword != "default" {
+		t.Errorf("Unexpected manifest %+v", manifest)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Per-request timeouts and the overall -max-runtime deadline. All requests
+// go through timeoutTransport that gives each request a context limited by
+// both, so a hung connection cannot stall the run. The fetch loops check
+// runtimeExceeded between items and stop the same way as on interrupt.
+
+const defaultRequestTimeout = 5 * time.Minute
+
+// Check the deadline and the budgets of budget.go
+func (config *Config) runtimeExceeded() bool {
+	return !config.runDeadline.IsZero() && !time.Now().Before(config.runDeadline) || config.budget.exhausted()
+}
+
+func (config *Config) runtimeExceededReport() *Report {
+	if config.budget.exhausted() {
+		return ReportMsg("%s reached, the progress so far is saved and the next run continues from it", config.budget.describe()).withCategory(errorCategoryPartial)
+	}
+	return ReportMsg("maximum runtime %s exceeded, the progress so far is saved and the next run continues from it", config.maxRuntime).withCategory(errorCategoryPartial)
+}
+
+// Close the response body and release the request context
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+type timeoutTransport struct {
+	config *Config
+	base   http.RoundTripper
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.config.budget.takeRequest(); err != nil {
+		return nil, err
+	}
+	timeout := t.config.requestTimeout
+	if !t.config.runDeadline.IsZero() {
+		untilDeadline := time.Until(t.config.runDeadline)
+		if untilDeadline <= 0 {
+			return nil, fmt.Errorf("maximum runtime %s exceeded", t.config.maxRuntime)
+		}
+		if timeout == 0 || untilDeadline < timeout {
+			timeout = untilDeadline
+		}
+	}
+	if timeout == 0 {
+		return t.base.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	res, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	res.Body = &cancelOnCloseBody{res.Body, cancel}
+	return res, nil
+}
+
+// Client for downloads outside the LJ session like userpics and media.
+func (config *Config) httpClient() *http.Client {
+	return &http.Client{Transport: &timeoutTransport{config, config.baseTransport()}}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_timeoutTransport(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("start"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-req.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	config := &Config{requestTimeout: 100 * time.Millisecond}
+	start := time.Now()
+	resp, err := config.httpClient().Get(server.URL)
+	if err == nil {
+		_, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	if err == nil {
+		t.Fatalf("Expected timeout error for a hung response")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Request was not aborted in time, took %s", elapsed)
+	}
+
+	config = &Config{maxRuntime: time.Minute, runDeadline: time.Now().Add(-time.Second)}
+	if _, err := config.httpClient().Get(server.URL); err == nil {
+		t.Errorf("Expected error after the run deadline")
+	}
+	if !config.runtimeExceeded() {
+		t.Errorf("Expected runtimeExceeded after the run deadline")
+	}
+}
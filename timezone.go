@@ -0,0 +1,48 @@
+package main
+
+import (
+	"time"
+)
+
+// LJ stores the entry time as the local time of the poster without the
+// offset and the protocol does not tell the time zone of the account. With
+// -time-zone the dump adds the eventtime_rfc3339 element with the time
+// including the offset to new entry files, keeping eventtime as is, and
+// exports use the zone for entries archived without the element. Comment
+// dates from the server are already in UTC.
+
+const ljEventTimeLayout = "2006-01-02 15:04:05"
+
+// Get the entry time in RFC 3339 format or an empty string when no time
+// zone is configured or the time cannot be parsed.
+func (config *Config) normalizeEventTime(eventTime string) string {
+	if config.timeZone == nil {
+		return ""
+	}
+	t, err := time.ParseInLocation(ljEventTimeLayout, eventTime, config.timeZone)
+	if err != nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// Fill the normalized time of entries archived without it.
+func (config *Config) normalizeEntryTimes(entries []*archivedEntry) {
+	for _, entry := range entries {
+		if entry.eventTimeRfc3339 == "" {
+			entry.eventTimeRfc3339 = config.normalizeEventTime(entry.eventTime)
+		}
+	}
+}
+
+// Get the absolute time of the entry or the zero time if not known.
+func (entry *archivedEntry) absoluteTime() time.Time {
+	if entry.eventTimeRfc3339 == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, entry.eventTimeRfc3339)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
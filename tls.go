@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// Self-hosted servers running the LJ engine often have certificates from
+// their own CA or support only old TLS versions. -ca-cert adds the PEM
+// certificates from a file to the system roots, -tls-min-version lowers or
+// raises the oldest accepted version, and -insecure-skip-verify accepts any
+// certificate. The last one makes the connection open to interception, so
+// each run that uses it warns about it. The config has the same options as
+// <caCert>, <tlsMinVersion> and <insecureSkipVerify>. The settings apply to
+// all HTTPS requests of the run, see transport.go.
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func tlsVersionNames() string {
+	names := make([]string, 0, len(tlsVersions))
+	for name := range tlsVersions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Build the client TLS config or return nil for the defaults
+func loadTLSConfig(caCert string, minVersion string, skipVerify bool) (*tls.Config, *Report) {
+	if caCert == "" && minVersion == "" && !skipVerify {
+		return nil, nil
+	}
+	config := &tls.Config{InsecureSkipVerify: skipVerify}
+	if minVersion != "" {
+		version, ok := tlsVersions[minVersion]
+		if !ok {
+			return nil, ReportMsg("unknown TLS version %s, supported versions are %s", minVersion, tlsVersionNames())
+		}
+		config.MinVersion = version
+	}
+	if caCert != "" {
+		data, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, WrapErr(err, "failed to read CA certificates %s", caCert)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, ReportMsg("no PEM certificates in %s", caCert)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
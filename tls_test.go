@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_tlsConfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, cert, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(caCert string, minVersion string, skipVerify bool) error {
+		tlsConfig, r := loadTLSConfig(caCert, minVersion, skipVerify)
+		if r != nil {
+			t.Fatal(r.AsText())
+		}
+		res, err := (&Config{tlsConfig: tlsConfig}).httpClient().Get(server.URL)
+		if err == nil {
+			res.Body.Close()
+		}
+		return err
+	}
+	if err := get("", "", false); err == nil {
+		t.Errorf("Expected an error for the self-signed certificate")
+	}
+	if err := get(caFile, "1.2", false); err != nil {
+		t.Errorf("Expected the certificate from -ca-cert to be trusted - %s", err.Error())
+	}
+	if err := get("", "", true); err != nil {
+		t.Errorf("Expected -insecure-skip-verify to accept the certificate - %s", err.Error())
+	}
+
+	if tlsConfig, r := loadTLSConfig("", "", false); r != nil || tlsConfig != nil {
+		t.Errorf("Expected the default TLS config without options")
+	}
+	if _, r := loadTLSConfig("", "1.4", false); r == nil {
+		t.Errorf("Expected an error for an unknown TLS version")
+	}
+	empty := filepath.Join(dir, "empty.pem")
+	if err := ioutil.WriteFile(empty, []byte("not a certificate"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, r := loadTLSConfig(empty, "", false); r == nil {
+		t.Errorf("Expected an error for a file without certificates")
+	}
+}
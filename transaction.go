@@ -0,0 +1,251 @@
+package main
+
+import (
+	"github.com/ibukanov/ljdump-go/ljarchive"
+	"linedb"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Each dump step that stores files, an entry together with the lastSync
+// after it or a chunk of comment bodies together with their meta data, is
+// a transaction. A run that fails or is killed in the middle of a step
+// either records it fully or leaves no trace of it.
+//
+// The step stages its files in memory. The commit adds the journal DB and
+// the entries index, writes every file next to its target with the
+// .pending suffix, lists the targets in pending-writes.linedb and then
+// renames the files into place. A run that stops before the list is
+// written leaves only .pending files that the next dump deletes. When the
+// list exists, the next dump finishes the renames before reading the
+// journal DB.
+//
+// Each commit rewrites the journal DB and the entries index, which grow
+// with the archive, and waits for the disk, so the commit of a step is
+// deferred until commitBatchSteps steps or commitBatchInterval passed.
+// A failed or killed run then loses at most that much work, which the next
+// dump fetches again.
+
+const pendingWritesFileName = "pending-writes.linedb"
+const pendingSuffix = ".pending"
+
+const commitBatchSteps = 100
+const commitBatchInterval = 30 * time.Second
+
+const (
+	pendingRename = "rename"
+	pendingRemove = "remove"
+)
+
+type pendingWrite struct {
+	action string
+
+	// Absolute path of the target
+	path string
+	data []byte
+}
+
+type pendingWrites struct {
+	writes []pendingWrite
+
+	// Steps since the last commit and the time when the first of them
+	// ended
+	steps int
+	since time.Time
+}
+
+// Stage the file for writing with the next commit
+func (jcx *journalContext) stageWrite(path string, data []byte) {
+	jcx.pending.writes = append(jcx.pending.writes, pendingWrite{pendingRename, path, data})
+}
+
+// Stage the removal of the file with the next commit
+func (jcx *journalContext) stageRemove(path string) {
+	jcx.pending.writes = append(jcx.pending.writes, pendingWrite{pendingRemove, path, nil})
+}
+
+// Read the file as it will be after the commit
+func (jcx *journalContext) readStagedFile(path string) ([]byte, error) {
+	for i := len(jcx.pending.writes) - 1; i >= 0; i-- {
+		w := &jcx.pending.writes[i]
+		if w.path == path {
+			if w.action == pendingRemove {
+				return nil, &os.PathError{Op: "open", Path: path, Err: os.ErrNotExist}
+			}
+			return w.data, nil
+		}
+	}
+	return archiveStore.ReadFile(path)
+}
+
+// End the step. Its files are written with the next commit of the batch.
+func (jcx *journalContext) commitPendingWrites() *Report {
+	if len(jcx.pending.writes) == 0 && !jcx.shouldWriteDB && !jcx.index.changed {
+		return nil
+	}
+	if jcx.pending.steps == 0 {
+		jcx.pending.since = time.Now()
+	}
+	jcx.pending.steps++
+	if jcx.pending.steps < commitBatchSteps && time.Since(jcx.pending.since) < commitBatchInterval {
+		return nil
+	}
+	return jcx.flushPendingWrites()
+}
+
+// Write the staged files together with the journal DB and the entries
+// index. This must be called only between steps.
+func (jcx *journalContext) flushPendingWrites() *Report {
+	if len(jcx.pending.writes) == 0 && !jcx.shouldWriteDB && !jcx.index.changed {
+		return nil
+	}
+	if !jcx.config.writesArchive() {
+		return ReportMsg("%s cannot write into %s without the lock", jcx.config.command.name, jcx.dir)
+	}
+	writes := jcx.pending.writes
+	jcx.pending.writes = nil
+	writes = append(writes, pendingWrite{pendingRename, filepath.Join(jcx.dir, journalDBFileName), encodeJournalDB(jcx)})
+	if jcx.index.changed {
+		writes = append(writes, pendingWrite{pendingRename, filepath.Join(jcx.dir, entriesIndexFileName), encodeEntriesIndex(jcx.index)})
+	}
+	if r := writePendingWrites(jcx.dir, writes); r != nil {
+		return r
+	}
+	if r := applyPendingWrites(jcx.dir); r != nil {
+		return r
+	}
+	jcx.shouldWriteDB = false
+	jcx.index.changed = false
+	jcx.pending.steps = 0
+	return nil
+}
+
+// Write the .pending files and the list of the writes. After this the
+// writes happen even if the run stops.
+func writePendingWrites(dir string, writes []pendingWrite) *Report {
+	e := linedb.NewByteEncoder()
+	e.Comment("action path")
+	e.Table("writes")
+	for _, w := range writes {
+		if w.action == pendingRename {
+			if err := archiveStore.WriteFile(w.path+pendingSuffix, w.data, archiveFileMode); err != nil {
+				return WrapErr(err, "")
+			}
+		}
+		rel, err := filepath.Rel(dir, w.path)
+		if err != nil {
+			return WrapErr(err, "")
+		}
+		e.AddString(w.action).AddString(filepath.ToSlash(rel)).EndRow()
+	}
+	e.EndTable()
+	if err := writeFileTempRename(filepath.Join(dir, pendingWritesFileName), e.GetBytes()); err != nil {
+		return WrapErr(err, "")
+	}
+	return nil
+}
+
+// Perform the writes listed in pending-writes.linedb and remove the list.
+// The actions can be repeated, so this also finishes a commit that was
+// interrupted in the middle.
+func applyPendingWrites(dir string) *Report {
+	listPath := filepath.Join(dir, pendingWritesFileName)
+	data, err := archiveStore.ReadFile(listPath)
+	if err != nil {
+		return WrapErr(err, "")
+	}
+	d := newLinedbDecoder(data)
+	for d.NextItem() {
+		if d.ItemKind == linedb.TableItem && d.ItemName == "writes" {
+			for d.NextRow() {
+				action, rel := d.GetString(), d.GetString()
+				path := filepath.Join(dir, filepath.FromSlash(rel))
+				var err error
+				switch action {
+				case pendingRename:
+					err = archiveStore.Rename(path+pendingSuffix, path)
+				case pendingRemove:
+					err = archiveStore.Remove(path)
+				}
+				if err != nil && !os.IsNotExist(err) {
+					return WrapErr(err, "")
+				}
+			}
+		}
+	}
+	if err := d.GetError(); err != nil {
+		return WrapErr(err, "failed to parse %s", listPath)
+	}
+	if err := archiveStore.Remove(listPath); err != nil {
+		return WrapErr(err, "")
+	}
+	return nil
+}
+
+// Finish the commit that the previous run did not complete or delete the
+// files of a step that it did not commit.
+func recoverPendingWrites(dir string) *Report {
+	if _, err := archiveStore.Stat(filepath.Join(dir, pendingWritesFileName)); err == nil {
+		log("Finishing writes interrupted in the previous run of %s", dir)
+		if r := applyPendingWrites(dir); r != nil {
+			return r
+		}
+	} else if !os.IsNotExist(err) {
+		return WrapErr(err, "")
+	}
+	paths, err := listPendingFiles(dir)
+	if err != nil {
+		return WrapErr(err, "failed to list files of %s", dir)
+	}
+	for _, path := range paths {
+		if err := archiveStore.Remove(path); err != nil {
+			return WrapErr(err, "")
+		}
+		log("Removed %s left by a step that the previous run did not finish", path)
+	}
+	return nil
+}
+
+// List the .pending files in the journal directory and its year and month
+// subdirectories
+func listPendingFiles(dir string) ([]string, error) {
+	var paths []string
+	var scan func(dir string, depth int) error
+	scan = func(dir string, depth int) error {
+		infos, err := archiveStore.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, info := range infos {
+			path := filepath.Join(dir, info.Name())
+			if !info.IsDir() && strings.HasSuffix(info.Name(), pendingSuffix) {
+				paths = append(paths, path)
+			} else if info.IsDir() && ljarchive.IsShardDir(info.Name(), depth) {
+				if err := scan(path, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := scan(dir, 0); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// Check if a dump is in the middle of a commit in the journal directory
+func commitInProgress(dir string) (bool, *Report) {
+	if _, err := archiveStore.Stat(filepath.Join(dir, pendingWritesFileName)); err == nil {
+		return true, nil
+	} else if !os.IsNotExist(err) {
+		return false, WrapErr(err, "")
+	}
+	paths, err := listPendingFiles(dir)
+	if err != nil {
+		return false, WrapErr(err, "failed to list files of %s", dir)
+	}
+	return len(paths) != 0, nil
+}
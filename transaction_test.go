@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_recoverPendingWrites(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	readFile := func(name string) string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "C-1"), []byte("old"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// A step that stopped before its list was written leaves no trace
+	if err := ioutil.WriteFile(filepath.Join(dir, "L-2"+pendingSuffix), []byte("entry"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if r := recoverPendingWrites(dir); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "L-2"+pendingSuffix)); !os.IsNotExist(err) {
+		t.Errorf("Expected removed pending file, got %v", err)
+	}
+
+	// A commit that stopped after its list was written and the first
+	// rename was done is finished
+	writes := []pendingWrite{
+		{pendingRename, filepath.Join(dir, "L-1"), []byte("entry")},
+		{pendingRemove, filepath.Join(dir, "C-1"), nil},
+		{pendingRename, filepath.Join(dir, journalDBFileName), []byte("db")},
+	}
+	if r := writePendingWrites(dir, writes); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if err := os.Rename(filepath.Join(dir, "L-1"+pendingSuffix), filepath.Join(dir, "L-1")); err != nil {
+		t.Fatal(err)
+	}
+	if r := recoverPendingWrites(dir); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if readFile("L-1") != "entry" || readFile("C-1") != "" || readFile(journalDBFileName) != "db" ||
+		readFile(pendingWritesFileName) != "" {
+		t.Errorf("Unexpected files after recovery %q %q %q", readFile("L-1"), readFile("C-1"), readFile(journalDBFileName))
+	}
+}
+
+func Test_commitPendingWritesBatches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	jcx := &journalContext{config: &Config{}, name: "bob", dir: dir, index: newEntriesIndex()}
+	jcx.db.layout = flatLayout
+	written := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, name))
+		return err == nil
+	}
+	for step := 1; step < commitBatchSteps; step++ {
+		jcx.stageWrite(filepath.Join(dir, "L-"+strconv.Itoa(step)), []byte("entry"))
+		jcx.shouldWriteDB = true
+		if r := jcx.commitPendingWrites(); r != nil {
+			t.Fatal(r.AsText())
+		}
+	}
+	if written("L-1") || written(journalDBFileName) {
+		t.Fatal("Committed before the batch was full")
+	}
+	jcx.stageWrite(filepath.Join(dir, "L-100"), []byte("entry"))
+	if r := jcx.commitPendingWrites(); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if !written("L-1") || !written("L-100") || !written(journalDBFileName) || len(jcx.pending.writes) != 0 {
+		t.Error("The full batch was not committed")
+	}
+
+	jcx.stageWrite(filepath.Join(dir, "L-101"), []byte("entry"))
+	if r := jcx.commitPendingWrites(); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if written("L-101") {
+		t.Error("Committed a step of a new batch")
+	}
+	if r := jcx.flushPendingWrites(); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if !written("L-101") {
+		t.Error("The flush did not write the batch")
+	}
+}
+
+func Test_acquireDumpLock(t *testing.T) {
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	config := &Config{dumpDir: dumpDir, command: findCommand("dump")}
+	if !config.needsDumpLock() {
+		t.Errorf("dump runs without the lock")
+	}
+	stats := &Config{dumpDir: dumpDir, command: findCommand("stats")}
+	if stats.needsDumpLock() || stats.writesArchive() {
+		t.Errorf("stats takes the lock")
+	}
+	stats.recoverCorruptDBs = true
+	if !stats.needsDumpLock() || !stats.writesArchive() {
+		t.Errorf("stats with -recover runs without the lock")
+	}
+
+	lock, r := acquireDumpLock(config)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	second, r := acquireDumpLock(config)
+	if r == nil {
+		second.Close()
+		t.Fatal("The lock was taken twice")
+	}
+	if !strings.Contains(r.AsText(), fmt.Sprintf("in use by ljdumpgo dump with pid %d", os.Getpid())) {
+		t.Errorf("The error does not name the holder - %s", r.AsText())
+	}
+
+	// -wait-lock waits until the holder exits
+	config.waitLock = true
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		lock.Close()
+	}()
+	second, r = acquireDumpLock(config)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	second.Close()
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// EntryTransform changes fetched entries before the dump writes them.
+// Apply gets the event map from getevents or the monthly export with the
+// subject, the text and the props map and returns true if it changed it.
+// The transforms to run come from the rules file given with -transforms or
+// <transforms> in the config. Each line of the file has the name of a
+// transform followed by its arguments separated by spaces. Arguments with
+// spaces are written as Go quoted strings, empty lines and lines starting
+// with # are skipped. The rules run in the order of the file on every
+// entry that the dump fetches. The transform command runs them on the
+// entries archived earlier and their revisions, see runTransform. A new
+// transform implements the interface in its own file and registers its
+// parser from an init function with registerTransform.
+type EntryTransform interface {
+	Apply(event map[string]interface{}) bool
+}
+
+// Create the transform from the arguments of a rule
+type transformParser func(args []string) (EntryTransform, error)
+
+var transformParsers = make(map[string]transformParser)
+
+func registerTransform(name string, parse transformParser) {
+	if transformParsers[name] != nil {
+		panic("duplicated transform " + name)
+	}
+	transformParsers[name] = parse
+}
+
+// A transform with the rule it came from for logging
+type transformRule struct {
+	name      string
+	line      int
+	transform EntryTransform
+}
+
+// Split the rule into words with Go quoted strings as single words
+func splitRuleWords(line string) ([]string, error) {
+	var words []string
+	for {
+		line = strings.TrimLeftFunc(line, unicode.IsSpace)
+		if line == "" {
+			return words, nil
+		}
+		if line[0] == '"' || line[0] == '`' {
+			quoted, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				return nil, fmt.Errorf("bad quoted string %s", line)
+			}
+			word, _ := strconv.Unquote(quoted)
+			words = append(words, word)
+			line = line[len(quoted):]
+			continue
+		}
+		end := strings.IndexFunc(line, unicode.IsSpace)
+		if end < 0 {
+			end = len(line)
+		}
+		words = append(words, line[:end])
+		line = line[end:]
+	}
+}
+
+func parseTransformRules(data []byte) ([]transformRule, error) {
+	var rules []transformRule
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words, err := splitRuleWords(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNumber, err.Error())
+		}
+		parse := transformParsers[words[0]]
+		if parse == nil {
+			names := make([]string, 0, len(transformParsers))
+			for name := range transformParsers {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("line %d: unknown transform %s, expected one of %s",
+				lineNumber, words[0], strings.Join(names, ", "))
+		}
+		transform, err := parse(words[1:])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s - %s", lineNumber, words[0], err.Error())
+		}
+		rules = append(rules, transformRule{words[0], lineNumber, transform})
+	}
+	return rules, scanner.Err()
+}
+
+func readTransformRules(path string) ([]transformRule, *Report) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, WrapErr(err, "failed to read transform rules")
+	}
+	rules, err := parseTransformRules(data)
+	if err != nil {
+		return nil, WrapErr(err, "bad transform rules in %s", path)
+	}
+	return rules, nil
+}
+
+// Run the transforms on the event and describe the rules that changed it
+func applyTransforms(rules []transformRule, event map[string]interface{}) []string {
+	var applied []string
+	for _, rule := range rules {
+		if rule.transform.Apply(event) {
+			applied = append(applied, fmt.Sprintf("%s (line %d)", rule.name, rule.line))
+		}
+	}
+	return applied
+}
+
+// Run the configured transforms on the event of the entry
+func (jcx *journalContext) transformEntry(itemId int64, event map[string]interface{}) {
+	if applied := applyTransforms(jcx.config.transforms, event); len(applied) != 0 {
+		log("Transformed L-%d with %s", itemId, strings.Join(applied, ", "))
+	}
+}
+
+// Parse the entry file written by writeLJEventDump back into the event
+// map. Elements with child elements become maps, repeated elements arrays
+// and other elements strings with values stored in base64 decoded.
+func decodeLJStruct(data []byte) (map[string]interface{}, error) {
+	type element struct {
+		name    string
+		fields  map[string]interface{}
+		text    []byte
+		encoded bool
+	}
+	d := xml.NewDecoder(bytes.NewReader(data))
+	var stack []*element
+	var root map[string]interface{}
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			e := &element{name: t.Name.Local, fields: make(map[string]interface{})}
+			for _, attr := range t.Attr {
+				if attr.Name.Local == valueEncodingAttr && attr.Value == base64ValueEncoding {
+					e.encoded = true
+				}
+			}
+			stack = append(stack, e)
+		case xml.CharData:
+			if len(stack) != 0 {
+				e := stack[len(stack)-1]
+				e.text = append(e.text, t...)
+			}
+		case xml.EndElement:
+			e := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				root = e.fields
+				continue
+			}
+			var value interface{} = e.fields
+			if len(e.fields) == 0 {
+				text := string(e.text)
+				if e.encoded {
+					decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(text))
+					if err != nil {
+						return nil, fmt.Errorf("invalid base64 value of %s - %s", e.name, err.Error())
+					}
+					text = string(decoded)
+				}
+				value = text
+			}
+			parent := stack[len(stack)-1].fields
+			switch previous := parent[e.name].(type) {
+			case nil:
+				parent[e.name] = value
+			case []interface{}:
+				parent[e.name] = append(previous, value)
+			default:
+				parent[e.name] = []interface{}{previous, value}
+			}
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("no root element")
+	}
+	return root, nil
+}
+
+// The transform command runs the rules on the entries archived earlier,
+// so changed rules do not wait for an edit of the entry on the server. The
+// revisions of the entries are transformed too, so redacted text does not
+// stay in older copies. With -dry-run it only logs what it would change.
+func runTransform(config *Config) *Report {
+	if len(config.transforms) == 0 {
+		return ReportMsg("no transform rules, give them with -transforms or <transforms> in the config")
+	}
+	for _, journal := range config.journals {
+		if shutdownRequested() {
+			return interruptedReport()
+		}
+		if r := transformJournal(config, journal); r != nil {
+			return r
+		}
+	}
+	return nil
+}
+
+func transformJournal(config *Config, journal string) *Report {
+	jcx := &journalContext{config: config, name: journal, dir: config.journalDir(journal)}
+	files, err := listDumpFiles(jcx.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return WrapErr(err, "failed to read journal directory %s", jcx.dir)
+	}
+	revisions, err := listEntryRevisions(jcx.dir)
+	if err != nil {
+		return WrapErr(err, "")
+	}
+	if !config.dryRun {
+		if r := recoverPendingWrites(jcx.dir); r != nil {
+			return r
+		}
+		if r := readJournalDB(jcx); r != nil {
+			return r
+		}
+		index, r := loadEntriesIndex(jcx.dir)
+		if r != nil {
+			return r
+		}
+		jcx.index = index
+	}
+	changed := 0
+	for _, file := range files {
+		if !strings.HasPrefix(filepath.Base(file), "L-") {
+			continue
+		}
+		if shutdownRequested() {
+			break
+		}
+		path := filepath.Join(jcx.dir, file)
+		archived, r := jcx.transformEntryFile(path)
+		if r != nil {
+			return r
+		}
+		entryChanged := archived != nil
+		if entryChanged && jcx.index != nil {
+			jcx.index.setEntry(archived, entryRelPath(jcx.dir, archived))
+		}
+		itemId, _ := strconv.ParseInt(filepath.Base(file)[2:], 10, 64)
+		for _, n := range revisions[itemId] {
+			revision, r := jcx.transformEntryFile(revisionFilePath(jcx.dir, itemId, n))
+			if r != nil {
+				return r
+			}
+			entryChanged = entryChanged || revision != nil
+		}
+		if entryChanged {
+			changed++
+		}
+		if !config.dryRun {
+			if r := jcx.commitPendingWrites(); r != nil {
+				return r
+			}
+		}
+	}
+	if !config.dryRun {
+		if r := jcx.flushPendingWrites(); r != nil {
+			return r
+		}
+	}
+	if shutdownRequested() {
+		return interruptedReport()
+	}
+	if config.dryRun {
+		log("Would transform %d entries of %s", changed, journal)
+	} else {
+		log("Transformed %d entries of %s", changed, journal)
+	}
+	return nil
+}
+
+// Run the rules on the entry file and stage the changed file. Return the
+// changed entry or nil when no rule changed it.
+func (jcx *journalContext) transformEntryFile(path string) (*archivedEntry, *Report) {
+	name := filepath.Base(path)
+	data, err := archiveStore.ReadFile(path)
+	if err != nil {
+		return nil, WrapErr(err, "")
+	}
+	event, err := decodeLJStruct(data)
+	if err != nil {
+		return nil, WrapErr(err, "failed to parse %s", path)
+	}
+	applied := applyTransforms(jcx.config.transforms, event)
+	if len(applied) == 0 {
+		return nil, nil
+	}
+	if jcx.config.dryRun {
+		log("Would transform %s of %s with %s", name, jcx.name, strings.Join(applied, ", "))
+	} else {
+		// The values were fixed and warned about when first archived
+		transformed, _, r := encodeLJStruct("event", event)
+		if r != nil {
+			return nil, r
+		}
+		jcx.stageWrite(path, transformed)
+		log("Transformed %s of %s with %s", name, jcx.name, strings.Join(applied, ", "))
+		data = transformed
+	}
+	archived, err := parseArchivedEntryFile(path, data)
+	if err != nil {
+		return nil, WrapErr(err, "failed to read back %s", path)
+	}
+	return archived, nil
+}
+
+// Get the string value of the event field. Values that getevents sent in
+// base64 are bytes.
+func eventString(event map[string]interface{}, name string) (string, bool) {
+	switch v := event[name].(type) {
+	case string:
+		return v, true
+	case []byte:
+		return string(v), true
+	}
+	return "", false
+}
+
+// Replace the text field of the event when edit changes it
+func editEventText(event map[string]interface{}, name string, edit func(s string) string) bool {
+	s, ok := eventString(event, name)
+	if !ok {
+		return false
+	}
+	edited := edit(s)
+	if edited == s {
+		return false
+	}
+	event[name] = edited
+	return true
+}
+
+// strip-prop <name>... removes the props
+type stripPropTransform struct {
+	names []string
+}
+
+func (t *stripPropTransform) Apply(event map[string]interface{}) bool {
+	props, _ := event["props"].(map[string]interface{})
+	changed := false
+	for _, name := range t.names {
+		if _, present := props[name]; present {
+			delete(props, name)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// replace <regexp> <replacement> replaces matches in the subject and the
+// text with $1 in the replacement for submatches
+type replaceTransform struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+func (t *replaceTransform) Apply(event map[string]interface{}) bool {
+	edit := func(s string) string {
+		return t.pattern.ReplaceAllString(s, t.replacement)
+	}
+	subjectChanged := editEventText(event, "subject", edit)
+	return editEventText(event, "event", edit) || subjectChanged
+}
+
+// rewrite-host <old> <new> changes the host of links and images in the
+// text
+type rewriteHostTransform struct {
+	pattern *regexp.Regexp
+	host    string
+}
+
+func (t *rewriteHostTransform) Apply(event map[string]interface{}) bool {
+	return editEventText(event, "event", func(s string) string {
+		return t.pattern.ReplaceAllString(s, "${1}"+t.host+"${2}")
+	})
+}
+
+func init() {
+	registerTransform("strip-prop", func(args []string) (EntryTransform, error) {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("expected prop names")
+		}
+		return &stripPropTransform{args}, nil
+	})
+	registerTransform("replace", func(args []string) (EntryTransform, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("expected a regular expression and a replacement")
+		}
+		pattern, err := regexp.Compile(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return &replaceTransform{pattern, args[1]}, nil
+	})
+	registerTransform("rewrite-host", func(args []string) (EntryTransform, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("expected the old and the new host")
+		}
+		pattern := regexp.MustCompile(`(?i)(//)` + regexp.QuoteMeta(args[0]) + `([/:?#"'\s<>]|$)`)
+		return &rewriteHostTransform{pattern, args[1]}, nil
+	})
+}
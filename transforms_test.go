@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_parseTransformRules(t *testing.T) {
+	rules, err := parseTransformRules([]byte(`
+# Comments and empty lines are skipped
+strip-prop current_music current_location
+replace ` + "`hunter\\d`" + ` "[redacted]"
+rewrite-host pics.example.net img.example.org
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 3 || rules[0].name != "strip-prop" || rules[1].line != 4 {
+		t.Fatalf("Unexpected rules %+v", rules)
+	}
+
+	event := map[string]interface{}{
+		"subject": "My password is hunter2",
+		"event": []byte(`<img src="http://pics.example.net/a.jpg"> <a href="https://pics.example.net">home</a> ` +
+			`<img src="http://pics.example.net.evil/b.jpg">`),
+		"props": map[string]interface{}{"current_music": "song", "current_mood": "happy"},
+	}
+	jcx := &journalContext{config: &Config{transforms: rules}}
+	jcx.transformEntry(1, event)
+	if event["subject"] != "My password is [redacted]" {
+		t.Errorf("Unexpected subject %q", event["subject"])
+	}
+	if event["event"] != `<img src="http://img.example.org/a.jpg"> <a href="https://img.example.org">home</a> `+
+		`<img src="http://pics.example.net.evil/b.jpg">` {
+		t.Errorf("Unexpected text %q", event["event"])
+	}
+	if props := event["props"].(map[string]interface{}); len(props) != 1 || props["current_mood"] != "happy" {
+		t.Errorf("Unexpected props %v", props)
+	}
+	if rules[0].transform.Apply(event) {
+		t.Errorf("Expected no change when the props are already gone")
+	}
+
+	for _, bad := range []string{"unknown-rule x", "replace (", "strip-prop", `replace "unterminated`} {
+		if _, err := parseTransformRules([]byte(bad)); err == nil {
+			t.Errorf("Expected an error for %s", bad)
+		}
+	}
+}
+
+func Test_runTransform(t *testing.T) {
+	dumpDir, err := ioutil.TempDir("", "ljdump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dumpDir)
+	journalDir := filepath.Join(dumpDir, "bob")
+	if err := os.MkdirAll(filepath.Join(journalDir, revisionsDirName), 0777); err != nil {
+		t.Fatal(err)
+	}
+	write := func(path string, event map[string]interface{}) {
+		data, _, r := encodeLJStruct("event", event)
+		if r != nil {
+			t.Fatal(r.AsText())
+		}
+		if err := ioutil.WriteFile(path, data, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(journalDir, "L-1"), map[string]interface{}{
+		"itemid": 1, "eventtime": "2005-03-05 14:22:00", "subject": "Password hunter2", "event": "text\x01",
+		"props": map[string]interface{}{"taglist": "life", "current_music": "song"},
+	})
+	write(revisionFilePath(journalDir, 1, 1), map[string]interface{}{
+		"itemid": 1, "eventtime": "2005-03-05 14:22:00", "subject": "Password hunter1", "event": "old",
+	})
+	write(filepath.Join(journalDir, "L-2"), map[string]interface{}{
+		"itemid": 2, "eventtime": "2005-03-06 10:00:00", "subject": "Nothing to change", "event": "text",
+	})
+	rules, err := parseTransformRules([]byte("strip-prop current_music\nreplace `hunter\\d` \"[redacted]\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{
+		journals:       []string{"bob"},
+		dumpDir:        dumpDir,
+		journalAliases: make(map[string]string),
+		transforms:     rules,
+		dryRun:         true,
+	}
+	before, _ := ioutil.ReadFile(filepath.Join(journalDir, "L-1"))
+	if r := runTransform(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if after, _ := ioutil.ReadFile(filepath.Join(journalDir, "L-1")); string(after) != string(before) {
+		t.Errorf("Dry run changed the entry")
+	}
+
+	config.dryRun = false
+	if r := runTransform(config); r != nil {
+		t.Fatal(r.AsText())
+	}
+	entry, err := readArchivedEntry(filepath.Join(journalDir, "L-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.subject != "Password [redacted]" || entry.event != "text\x01" || entry.eventTime != "2005-03-05 14:22:00" ||
+		len(entry.props) != 1 || entry.props["taglist"] != "life" {
+		t.Errorf("Unexpected transformed entry %+v", entry)
+	}
+	revision, err := readArchivedEntry(revisionFilePath(journalDir, 1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revision.subject != "Password [redacted]" {
+		t.Errorf("Revision was not transformed, got subject %q", revision.subject)
+	}
+	index, r := readEntriesIndex(journalDir)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if index == nil || index.rows[1] == nil || index.rows[1].subject != "Password [redacted]" {
+		t.Errorf("Entries index was not updated")
+	}
+}
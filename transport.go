@@ -0,0 +1,142 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// All requests of a run share one http.Transport tuned for a long series
+// of requests to a few hosts: keep-alive connections are reused with a
+// small idle pool, and dialing and the TLS handshake have their own
+// timeouts below the per-request one of timeouts.go. responseLimitTransport
+// on top of it asks for gzip, decompresses the body itself and fails the
+// read of a body larger than -max-response-size after decompression, so a
+// broken or hostile server cannot make the run consume unbounded memory or
+// disk. The limit applies to the LJ protocol, export pages and media.
+
+const defaultMaxResponseSize = 1 << 30
+
+type responseTooLargeError struct {
+	url   string
+	limit int64
+}
+
+func (err *responseTooLargeError) Error() string {
+	return fmt.Sprintf("response from %s is larger than the limit of %d bytes, raise -max-response-size if the server is trusted",
+		err.url, err.limit)
+}
+
+func newHTTPTransport(config *Config) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          16,
+		MaxIdleConnsPerHost:   4,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   15 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		TLSClientConfig:       config.tlsConfig,
+
+		// responseLimitTransport handles gzip to apply the limit to the
+		// decompressed size
+		DisableCompression: true,
+	}
+}
+
+// Get the transport under timeoutTransport for all clients of the run
+func (config *Config) baseTransport() http.RoundTripper {
+	if config.transport == nil {
+		if config.tlsConfig != nil && config.tlsConfig.InsecureSkipVerify {
+			config.warn("TLS certificates are not verified with -insecure-skip-verify, anyone on the network path can read and change the traffic including the password")
+		}
+		config.transport = &responseLimitTransport{config, newHTTPTransport(config)}
+	}
+	return config.transport
+}
+
+type responseLimitTransport struct {
+	config *Config
+	base   http.RoundTripper
+}
+
+func (t *responseLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	askedGzip := false
+	if req.Header.Get("Accept-Encoding") == "" && req.Header.Get("Range") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Accept-Encoding", "gzip")
+		askedGzip = true
+	}
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	limit := t.config.maxResponseSize
+	if limit > 0 && res.ContentLength > limit {
+		res.Body.Close()
+		return nil, &responseTooLargeError{req.URL.Redacted(), limit}
+	}
+	if askedGzip && strings.EqualFold(res.Header.Get("Content-Encoding"), "gzip") {
+		res.Body = &gzipBody{compressed: res.Body}
+		res.Header.Del("Content-Encoding")
+		res.Header.Del("Content-Length")
+		res.ContentLength = -1
+		res.Uncompressed = true
+	}
+	if limit > 0 {
+		res.Body = &sizeLimitedBody{res.Body, limit, &responseTooLargeError{req.URL.Redacted(), limit}}
+	}
+	return res, nil
+}
+
+// Body decompressed on the first read so errors come from Read like with
+// the transparent decompression of http.Transport
+type gzipBody struct {
+	compressed io.ReadCloser
+	reader     *gzip.Reader
+	err        error
+}
+
+func (b *gzipBody) Read(p []byte) (int, error) {
+	if b.reader == nil && b.err == nil {
+		b.reader, b.err = gzip.NewReader(b.compressed)
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.reader.Read(p)
+}
+
+func (b *gzipBody) Close() error {
+	return b.compressed.Close()
+}
+
+type sizeLimitedBody struct {
+	io.ReadCloser
+	remaining int64
+	err       error
+}
+
+func (b *sizeLimitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, b.err
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n + int(b.remaining), b.err
+	}
+	return n, err
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_responseLimitTransport(t *testing.T) {
+	text := strings.Repeat("comment ", 1000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/gzip":
+			if req.Header.Get("Accept-Encoding") != "gzip" {
+				t.Errorf("Expected a gzip request, got %q", req.Header.Get("Accept-Encoding"))
+			}
+			var buf bytes.Buffer
+			zw := gzip.NewWriter(&buf)
+			zw.Write([]byte(text))
+			zw.Close()
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(buf.Bytes())
+		case "/chunked":
+			w.Write([]byte(text[:4000]))
+			w.(http.Flusher).Flush()
+			w.Write([]byte(text[4000:]))
+		default:
+			w.Write([]byte(text))
+		}
+	}))
+	defer server.Close()
+
+	get := func(config *Config, path string) (string, error) {
+		res, err := config.httpClient().Get(server.URL + path)
+		if err != nil {
+			return "", err
+		}
+		defer res.Body.Close()
+		data, err := ioutil.ReadAll(res.Body)
+		return string(data), err
+	}
+
+	config := &Config{maxResponseSize: 1 << 20}
+	for _, path := range []string{"/gzip", "/plain", "/chunked"} {
+		if body, err := get(config, path); err != nil || body != text {
+			t.Errorf("Unexpected response for %s, %d bytes - %v", path, len(body), err)
+		}
+	}
+
+	config = &Config{maxResponseSize: 5000}
+	for _, path := range []string{"/gzip", "/plain", "/chunked"} {
+		_, err := get(config, path)
+		if err == nil || !strings.Contains(err.Error(), "larger than the limit of 5000 bytes") {
+			t.Errorf("Expected the size limit error for %s, got %v", path, err)
+		}
+	}
+
+	config = &Config{maxResponseSize: int64(len(text))}
+	if body, err := get(config, "/chunked"); err != nil || body != text {
+		t.Errorf("Expected a response of exactly the limit to pass - %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"linedb"
+	"net/http"
+	"path/filepath"
+	"sort"
+)
+
+// Keyword to userpic URL assignment valid from validFrom until validTo.
+// Both are RFC3339 times of the dumps that noticed the change. An empty
+// validFrom means the assignment predates the history tracking and an empty
+// validTo means the assignment is still current. The default picture uses
+// the empty keyword.
+type pictureAssignment struct {
+	keyword   string
+	url       string
+	validFrom string
+	validTo   string
+}
+
+type sortPictureHistory []*pictureAssignment
+
+func (a sortPictureHistory) Len() int { return len(a) }
+func (a sortPictureHistory) Less(i, j int) bool {
+	if a[i].keyword != a[j].keyword {
+		return a[i].keyword < a[j].keyword
+	}
+	return a[i].validFrom < a[j].validFrom
+}
+func (a sortPictureHistory) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+
+func addPictureHistoryTable(e *linedb.Encoder, history []*pictureAssignment) {
+	e.Comment("picture-keyword picture-url valid-from valid-to")
+	e.Table("pictureHistory")
+	for _, a := range history {
+		e.AddString(a.keyword).AddString(a.url).AddString(a.validFrom).AddString(a.validTo).EndRow()
+	}
+	e.EndTable()
+}
+
+// Compare the current server assignments with the history, close
+// assignments for deleted or reassigned keywords and open new ones. The
+// current keyword map and default URL are updated to match the server while
+// files for superseded pictures are kept. Return true if anything changed.
+func updatePictureHistory(accountData *accountData, current map[string]string, now string) bool {
+	updated := false
+
+	// Seed the history from the data written before it was tracked
+	if len(accountData.pictureHistory) == 0 {
+		for keyword, url := range accountData.pictureKeywordUrlMap {
+			accountData.pictureHistory = append(accountData.pictureHistory, &pictureAssignment{keyword, url, "", ""})
+		}
+		if accountData.pictureDefaultUrl != "" {
+			accountData.pictureHistory = append(accountData.pictureHistory, &pictureAssignment{"", accountData.pictureDefaultUrl, "", ""})
+		}
+		updated = len(accountData.pictureHistory) != 0
+	}
+
+	open := make(map[string]*pictureAssignment)
+	for _, a := range accountData.pictureHistory {
+		if a.validTo == "" {
+			open[a.keyword] = a
+		}
+	}
+	for keyword, a := range open {
+		if url, found := current[keyword]; !found || url != a.url {
+			if keyword == "" {
+				log("Default userpic changed from %s", a.url)
+			} else {
+				log("Userpic keyword %s is no longer assigned to %s", keyword, a.url)
+			}
+			a.validTo = now
+			delete(open, keyword)
+			updated = true
+		}
+	}
+	for keyword, url := range current {
+		if open[keyword] == nil {
+			accountData.pictureHistory = append(accountData.pictureHistory, &pictureAssignment{keyword, url, now, ""})
+			updated = true
+		}
+	}
+	sort.Sort(sortPictureHistory(accountData.pictureHistory))
+
+	keywordUrlMap := make(map[string]string, len(current))
+	for keyword, url := range current {
+		if keyword != "" {
+			keywordUrlMap[keyword] = url
+		}
+	}
+	if len(keywordUrlMap) != len(accountData.pictureKeywordUrlMap) {
+		updated = true
+	}
+	accountData.pictureKeywordUrlMap = keywordUrlMap
+	if current[""] != accountData.pictureDefaultUrl {
+		accountData.pictureDefaultUrl = current[""]
+		updated = true
+	}
+	return updated
+}
+
+// Return the URL that the keyword referred to at the given RFC3339 or LJ
+// event time or an empty string if unknown. Times are compared as strings
+// so only the date and time parts matter. When no assignment covers the
+// time, the earliest known assignment of the keyword is used as entries
+// older than the history were most likely posted with it.
+func (accountData *accountData) pictureUrlAt(keyword string, when string) string {
+	earliest := ""
+	for _, a := range accountData.pictureHistory {
+		if a.keyword != keyword {
+			continue
+		}
+		if earliest == "" {
+			earliest = a.url
+		}
+		if (a.validFrom == "" || comparableTime(a.validFrom) <= comparableTime(when)) &&
+			(a.validTo == "" || comparableTime(when) < comparableTime(a.validTo)) {
+			return a.url
+		}
+	}
+	if earliest == "" && keyword != "" {
+		return accountData.pictureKeywordUrlMap[keyword]
+	}
+	return earliest
+}
+
+// Convert RFC3339 or LJ "2006-01-02 15:04:05" times to a form where string
+// comparison follows time order.
+func comparableTime(t string) string {
+	b := []byte(t)
+	if len(b) > 10 && b[10] == 'T' {
+		b[10] = ' '
+	}
+	if len(b) > 19 {
+		b = b[:19]
+	}
+	return string(b)
+}
+
+func addPictureValidatorsTable(e *linedb.Encoder, validators map[string]httpValidators) {
+	urls := make([]string, 0, len(validators))
+	for url := range validators {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	e.Comment("picture-url etag last-modified")
+	e.Table("pictureValidators")
+	for _, url := range urls {
+		e.AddString(url).AddString(validators[url].etag).AddString(validators[url].lastModified).EndRow()
+	}
+	e.EndTable()
+}
+
+// Revalidate all archived pictures with conditional requests for
+// -refresh-media and overwrite the files of pictures that changed. Pictures
+// that are gone from the server keep their files. Return true if anything
+// changed.
+func refreshPictures(config *Config, accountData *accountData) bool {
+	urls := make([]string, 0, len(accountData.pictureUrlFileMap))
+	for url := range accountData.pictureUrlFileMap {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	log("Revalidating %d archived user pictures", len(urls))
+	updated, replaced := false, 0
+	for _, url := range urls {
+		if shutdownRequested() || config.runtimeExceeded() {
+			break
+		}
+		resp, err := conditionalGet(config.httpClient(), url, accountData.pictureValidators[url])
+		if err != nil {
+			config.warn("failed to revalidate userpic %s - %s", url, err.Error())
+			continue
+		}
+		var data []byte
+		if resp.StatusCode == http.StatusOK {
+			data, err = ioutil.ReadAll(resp.Body)
+		}
+		err = fuseErr(err, resp.Body.Close())
+		if err == nil && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotModified {
+			config.warn("failed to revalidate userpic %s - %s", url, resp.Status)
+			continue
+		}
+		if err != nil {
+			config.warn("failed to revalidate userpic %s - %s", url, err.Error())
+			continue
+		}
+		if v := responseValidators(resp); !v.empty() && v != accountData.pictureValidators[url] {
+			accountData.pictureValidators[url] = v
+			updated = true
+		}
+		if resp.StatusCode == http.StatusNotModified {
+			continue
+		}
+		path := filepath.Join(config.accountDataDir, accountData.pictureUrlFileMap[url])
+		if old, err := archiveStore.ReadFile(path); err == nil && bytes.Equal(old, data) {
+			continue
+		}
+		if err := writeFileTempRename(path, data); err != nil {
+			config.warn("failed to store userpic %s - %s", url, err.Error())
+			continue
+		}
+		replaced++
+	}
+	if replaced != 0 {
+		log("Replaced %d changed user pictures", replaced)
+	}
+	return updated
+}
@@ -0,0 +1,76 @@
+package main
+
+import "testing"
+
+func Test_updatePictureHistory(t *testing.T) {
+	accountData := &accountData{
+		pictureDefaultUrl: "http://pics/1",
+		pictureUrlFileMap: map[string]string{
+			"http://pics/1": "user-picture-1.png",
+			"http://pics/2": "user-picture-2-cat.png",
+		},
+		pictureKeywordUrlMap: map[string]string{
+			"cat": "http://pics/2",
+		},
+	}
+	unchanged := map[string]string{"": "http://pics/1", "cat": "http://pics/2"}
+	if !updatePictureHistory(accountData, unchanged, "2010-01-01T00:00:00Z") {
+		t.Errorf("Expected update when seeding the history")
+	}
+	if updatePictureHistory(accountData, unchanged, "2011-01-01T00:00:00Z") {
+		t.Errorf("Unexpected update when nothing changed")
+	}
+
+	// Reassign cat and delete the default picture
+	current := map[string]string{"cat": "http://pics/3"}
+	if !updatePictureHistory(accountData, current, "2012-06-01T00:00:00Z") {
+		t.Fatalf("Expected update after keyword reassignment")
+	}
+	if accountData.pictureDefaultUrl != "" || accountData.pictureKeywordUrlMap["cat"] != "http://pics/3" {
+		t.Errorf("Current keyword map does not match the server")
+	}
+	if len(accountData.pictureHistory) != 3 {
+		t.Fatalf("Expected 3 history records, got %d", len(accountData.pictureHistory))
+	}
+	cases := []struct {
+		keyword string
+		when    string
+		url     string
+	}{
+		{"cat", "2005-03-05 14:22:00", "http://pics/2"},
+		{"cat", "2012-05-31 23:59:59", "http://pics/2"},
+		{"cat", "2012-06-01 10:00:00", "http://pics/3"},
+		{"", "2011-01-01 00:00:00", "http://pics/1"},
+		{"", "2013-01-01 00:00:00", "http://pics/1"},
+		{"dog", "2013-01-01 00:00:00", ""},
+	}
+	for _, c := range cases {
+		if url := accountData.pictureUrlAt(c.keyword, c.when); url != c.url {
+			t.Errorf("Expected %q for keyword %q at %s, got %q", c.url, c.keyword, c.when, url)
+		}
+	}
+}
+
+func Test_parseAllPicsPage(t *testing.T) {
+	page := `<html><body><table>
+<tr><td><img src="https://l-userpic.livejournal.com/11/22" width="100" alt="cat"></td>
+<td><b>Keywords:</b> cat, pets<br><b>Description:</b> My cat &amp; me<br>
+<b>Comment:</b> Drawn by a friend<br><a href="/userpic/11/22">3 comments</a></td></tr>
+<tr><td><a href="/x"><img src='https://l-userpic.livejournal.com/11/33'></a></td>
+<td><b>Keywords:</b> dog</td></tr>
+<tr><td><img src="https://l-stat.livejournal.net/img/logo.gif"></td></tr>
+</table></body></html>`
+	infos := parseAllPicsPage(page)
+	expected := []pictureInfo{
+		{"https://l-userpic.livejournal.com/11/22", "My cat & me", "Drawn by a friend", 3},
+		{"https://l-userpic.livejournal.com/11/33", "", "", 0},
+	}
+	if len(infos) != len(expected) {
+		t.Fatalf("Expected %d pictures, got %d", len(expected), len(infos))
+	}
+	for i := range expected {
+		if *infos[i] != expected[i] {
+			t.Errorf("Expected %+v, got %+v", expected[i], *infos[i])
+		}
+	}
+}
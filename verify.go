@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// Check that data is a well-formed XML document under the strict rules of
+// the standard library decoder. That rejects invalid UTF-8 and characters
+// outside the XML 1.0 range.
+func checkWellFormedXml(data []byte) error {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = true
+	for {
+		_, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+type verifyResult struct {
+	checkedFiles int
+	problems     int
+}
+
+func (vr *verifyResult) problem(format string, a ...interface{}) {
+	vr.problems++
+	log("PROBLEM: "+format, a...)
+}
+
+func verifyJournal(config *Config, journal string, vr *verifyResult) *Report {
+	dir := config.journalDir(journal)
+	files, err := listDumpFiles(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			config.warn("journal %s has no archive directory %s", journal, dir)
+			return nil
+		}
+		return WrapErr(err, "failed to read journal directory %s", dir)
+	}
+
+	log("Verifying journal %s", journal)
+	problemsBefore, filesBefore := vr.problems, vr.checkedFiles
+	replyCounts := make(map[int64]int)
+	commentCounts := make(map[int64]int)
+	commentItems := make(map[CommentId]int64)
+	for _, file := range files {
+		path := filepath.Join(dir, file)
+		data, err := archiveStore.ReadFile(path)
+		if err != nil {
+			return WrapErr(err, "failed to read %s", path)
+		}
+		vr.checkedFiles++
+		if err := checkWellFormedXml(data); err != nil {
+			vr.problem("%s is not well-formed XML - %s", path, err.Error())
+			continue
+		}
+		name := filepath.Base(file)
+		itemId, _ := strconv.ParseInt(name[2:], 10, 64)
+		switch name[0] {
+		case 'L':
+			if entry, err := parseArchivedEntryFile(path, data); err == nil && entry.props["reply_count"] != "" {
+				replyCounts[itemId], _ = strconv.Atoi(entry.props["reply_count"])
+			}
+		case 'C':
+			var stored CommentFile
+			if err := xml.Unmarshal(data, &stored); err == nil {
+				commentCounts[itemId] = len(stored.Comments)
+				for i := range stored.Comments {
+					commentItems[stored.Comments[i].Id] = itemId
+				}
+			}
+		}
+	}
+	if r := checkCommentCounts(config, journal, replyCounts, commentCounts, commentItems, vr); r != nil {
+		return r
+	}
+
+	gaps, counts, r := checkJournalYearGaps(dir)
+	if r != nil {
+		return r
+	}
+	vr.problems += reportYearGaps(journal, gaps)
+	if vr.problems != problemsBefore {
+		return nil
+	}
+	return certifyJournal(config, journal, vr.checkedFiles-filesBefore, counts)
+}
+
+// Compare the reply_count of entries with their archived comments and
+// queue entries with missing comments for the next dump. More archived
+// comments than the reply_count are fine as the count does not include
+// screened and deleted comments. comment-items.linedb is updated to match
+// the comment files in items.
+func checkCommentCounts(config *Config, journal string, replyCounts, commentCounts map[int64]int, items map[CommentId]int64, vr *verifyResult) *Report {
+	var missing sortIds
+	for itemId, replyCount := range replyCounts {
+		if commentCounts[itemId] < replyCount {
+			missing = append(missing, itemId)
+		}
+	}
+	sort.Sort(missing)
+	for _, itemId := range missing {
+		vr.problem("entry L-%d of %s has reply_count %d but only %d archived comments",
+			itemId, journal, replyCounts[itemId], commentCounts[itemId])
+	}
+
+	jcx := &journalContext{config: config, name: journal, dir: config.journalDir(journal)}
+	if r := readJournalDB(jcx); r != nil {
+		return r
+	}
+	queued := make([]int64, len(missing))
+	for i, itemId := range missing {
+		queued[i] = itemId
+	}
+	stale := 0
+	for id, itemId := range jcx.db.commentItems {
+		if archived, present := items[id]; !present || archived != itemId {
+			stale++
+		}
+	}
+	if stale != 0 || len(items) != len(jcx.db.commentItems) {
+		log("Updated the comment index of %s, %d comments were not in their files", journal, stale)
+		jcx.db.commentItems = items
+		jcx.commentItemsChanged = true
+	}
+	if len(queued) == 0 && len(jcx.db.commentRefetch) == 0 && !jcx.shouldWriteDB && !jcx.commentItemsChanged {
+		return nil
+	}
+	if len(queued) != 0 {
+		log("The next dump of %s looks for the missing comments of %d entries", journal, len(queued))
+	}
+	jcx.db.commentRefetch = queued
+	return writeJournalDB(jcx)
+}
+
+func runVerify(config *Config) *Report {
+	var vr verifyResult
+	for _, journal := range config.journals {
+		if r := verifyJournal(config, journal, &vr); r != nil {
+			return r
+		}
+	}
+	log("Checked %d files, found %d problems", vr.checkedFiles, vr.problems)
+	if vr.problems != 0 {
+		return ReportMsg("verification found %d problems", vr.problems)
+	}
+	return nil
+}
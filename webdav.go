@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Store of the archive on a WebDAV server like a NAS or Nextcloud. Paths
+// of the archive are taken relative to the base URL. WriteFile uploads the
+// data with PUT under a temporary name and then moves it over the target
+// with MOVE, which servers perform atomically like a local rename. The
+// dump stages files in memory, so nothing is written locally. SFTP is not
+// supported as the standard library has no SSH client, mount such servers
+// with sshfs instead.
+
+type webdavConfig struct {
+	url      string
+	username string
+	password string
+}
+
+type webdavStore struct {
+	base   *url.URL
+	config *webdavConfig
+	client *http.Client
+}
+
+func newWebdavStore(config *Config) (*webdavStore, *Report) {
+	base, err := url.Parse(strings.TrimSuffix(config.webdav.url, "/") + "/")
+	if err != nil {
+		return nil, WrapErr(err, "bad WebDAV URL")
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, ReportMsg("WebDAV URL %s must start with https:// or http://", config.webdav.url)
+	}
+	return &webdavStore{base, config.webdav, config.httpClient()}, nil
+}
+
+// Get the URL of the archive path. Paths that leave the archive are
+// rejected.
+func (s *webdavStore) url(p string) (string, error) {
+	clean := path.Clean(filepath.ToSlash(p))
+	if clean == "." {
+		return s.base.String(), nil
+	}
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("path %s is outside of the WebDAV archive", p)
+	}
+	ref := &url.URL{Path: clean}
+	return s.base.ResolveReference(ref).String(), nil
+}
+
+func (s *webdavStore) do(method string, p string, body []byte, header map[string]string) (*http.Response, []byte, error) {
+	u, err := s.url(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.config.username != "" {
+		req.SetBasicAuth(s.config.username, s.config.password)
+	}
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxMediaFileSize+1))
+	err = fuseErr(err, resp.Body.Close())
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) > maxMediaFileSize {
+		return nil, nil, fmt.Errorf("%s %s answered with more than %d bytes", method, u, maxMediaFileSize)
+	}
+	return resp, data, nil
+}
+
+func webdavError(op string, p string, resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return &os.PathError{Op: op, Path: p, Err: os.ErrNotExist}
+	}
+	return &os.PathError{Op: op, Path: p, Err: fmt.Errorf("WebDAV server answered %s", resp.Status)}
+}
+
+func (s *webdavStore) ReadFile(p string) ([]byte, error) {
+	resp, data, err := s.do("GET", p, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, webdavError("read", p, resp)
+	}
+	return data, nil
+}
+
+func (s *webdavStore) WriteFile(p string, data []byte, perm os.FileMode) error {
+	tmp := p + ".tmp"
+	resp, _, err := s.do("PUT", tmp, data, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return webdavError("write", tmp, resp)
+	}
+	return s.Rename(tmp, p)
+}
+
+func (s *webdavStore) Rename(from, to string) error {
+	destination, err := s.url(to)
+	if err != nil {
+		return err
+	}
+	resp, _, err := s.do("MOVE", from, nil, map[string]string{"Destination": destination, "Overwrite": "T"})
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return webdavError("rename", from, resp)
+	}
+	return nil
+}
+
+func (s *webdavStore) Remove(p string) error {
+	resp, _, err := s.do("DELETE", p, nil, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return webdavError("remove", p, resp)
+	}
+	return nil
+}
+
+func (s *webdavStore) MkdirAll(dir string) error {
+	clean := path.Clean(filepath.ToSlash(dir))
+	if clean == "." {
+		return nil
+	}
+	if info, err := s.Stat(dir); err == nil && info.IsDir() {
+		return nil
+	}
+	prefix := ""
+	for _, segment := range strings.Split(clean, "/") {
+		prefix = path.Join(prefix, segment)
+		resp, _, err := s.do("MKCOL", prefix, nil, nil)
+		if err != nil {
+			return err
+		}
+		// 405 means that the collection exists
+		if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusMethodNotAllowed {
+			return webdavError("mkdir", prefix, resp)
+		}
+	}
+	return nil
+}
+
+type webdavMultistatus struct {
+	Responses []struct {
+		Href string `xml:"href"`
+		Prop struct {
+			ResourceType struct {
+				Collection *struct{} `xml:"collection"`
+			} `xml:"resourcetype"`
+			ContentLength string `xml:"getcontentlength"`
+			LastModified  string `xml:"getlastmodified"`
+		} `xml:"propstat>prop"`
+	} `xml:"response"`
+}
+
+const webdavPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<propfind xmlns="DAV:"><prop><resourcetype/><getcontentlength/><getlastmodified/></prop></propfind>`
+
+type webdavFileInfo struct {
+	// Unescaped path of the URL without the trailing slash
+	href    string
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi *webdavFileInfo) Name() string       { return fi.name }
+func (fi *webdavFileInfo) Size() int64        { return fi.size }
+func (fi *webdavFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *webdavFileInfo) IsDir() bool        { return fi.dir }
+func (fi *webdavFileInfo) Sys() interface{}   { return nil }
+
+func (fi *webdavFileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | archiveDirMode
+	}
+	return archiveFileMode
+}
+
+func (s *webdavStore) propfind(p string, depth string) ([]*webdavFileInfo, error) {
+	resp, data, err := s.do("PROPFIND", p, []byte(webdavPropfindBody), map[string]string{
+		"Depth":        depth,
+		"Content-Type": "application/xml",
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 207 {
+		return nil, webdavError("stat", p, resp)
+	}
+	var ms webdavMultistatus
+	if err := xml.Unmarshal(data, &ms); err != nil {
+		return nil, &os.PathError{Op: "stat", Path: p, Err: err}
+	}
+	var infos []*webdavFileInfo
+	for _, r := range ms.Responses {
+		// Servers give either the absolute path or the full URL
+		href := r.Href
+		if u, err := url.Parse(r.Href); err == nil {
+			href = u.Path
+		}
+		href = strings.TrimSuffix(href, "/")
+		fi := &webdavFileInfo{
+			href: href,
+			name: path.Base(href),
+			dir:  r.Prop.ResourceType.Collection != nil,
+		}
+		fi.size, _ = strconv.ParseInt(r.Prop.ContentLength, 10, 64)
+		fi.modTime, _ = http.ParseTime(r.Prop.LastModified)
+		infos = append(infos, fi)
+	}
+	return infos, nil
+}
+
+func (s *webdavStore) Stat(p string) (os.FileInfo, error) {
+	infos, err := s.propfind(p, "0")
+	if err != nil {
+		return nil, err
+	}
+	if len(infos) == 0 {
+		return nil, &os.PathError{Op: "stat", Path: p, Err: os.ErrNotExist}
+	}
+	infos[0].name = filepath.Base(p)
+	return infos[0], nil
+}
+
+func (s *webdavStore) ReadDir(p string) ([]os.FileInfo, error) {
+	infos, err := s.propfind(p, "1")
+	if err != nil {
+		return nil, err
+	}
+	// One of the responses describes the directory itself
+	u, err := s.url(p)
+	if err != nil {
+		return nil, err
+	}
+	self, _ := url.Parse(u)
+	var result []os.FileInfo
+	for _, fi := range infos {
+		if fi.href != strings.TrimSuffix(self.Path, "/") {
+			result = append(result, fi)
+		}
+	}
+	sortFileInfosByName(result)
+	return result, nil
+}
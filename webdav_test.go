@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Minimal WebDAV server keeping files in memory. Collections are not
+// tracked, every prefix of a file is a collection.
+type fakeWebdav struct {
+	lock  sync.Mutex
+	files map[string][]byte
+}
+
+func (fs *fakeWebdav) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+	p := strings.TrimSuffix(req.URL.Path, "/")
+	switch req.Method {
+	case "GET":
+		data, ok := fs.files[p]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write(data)
+	case "PUT":
+		data, _ := ioutil.ReadAll(req.Body)
+		fs.files[p] = data
+		w.WriteHeader(http.StatusCreated)
+	case "MOVE":
+		u, _ := url.Parse(req.Header.Get("Destination"))
+		data, ok := fs.files[p]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		delete(fs.files, p)
+		fs.files[u.Path] = data
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		delete(fs.files, p)
+		w.WriteHeader(http.StatusNoContent)
+	case "MKCOL":
+		w.WriteHeader(http.StatusCreated)
+	case "PROPFIND":
+		var names []string
+		children := make(map[string]bool)
+		for name := range fs.files {
+			if name == p {
+				names = append(names, name)
+			} else if req.Header.Get("Depth") == "1" && strings.HasPrefix(name, p+"/") {
+				// Files in subdirectories are listed as their collection
+				child := p + "/" + strings.TrimPrefix(name, p+"/")
+				if i := strings.Index(strings.TrimPrefix(name, p+"/"), "/"); i >= 0 {
+					child = p + "/" + strings.TrimPrefix(name, p+"/")[:i] + "/"
+				}
+				if !children[child] {
+					children[child] = true
+					names = append(names, child)
+				}
+			}
+		}
+		if len(names) == 0 && req.Header.Get("Depth") == "0" {
+			for name := range fs.files {
+				if strings.HasPrefix(name, p+"/") {
+					names = append(names, p+"/")
+					break
+				}
+			}
+		}
+		if len(names) == 0 {
+			http.NotFound(w, req)
+			return
+		}
+		sort.Strings(names)
+		w.WriteHeader(207)
+		fmt.Fprint(w, `<?xml version="1.0"?><multistatus xmlns="DAV:">`)
+		for _, name := range names {
+			if strings.HasSuffix(name, "/") {
+				fmt.Fprintf(w, `<response><href>%s</href><propstat><prop><resourcetype><collection/></resourcetype></prop></propstat></response>`, name)
+			} else {
+				fmt.Fprintf(w, `<response><href>%s</href><propstat><prop><resourcetype/><getcontentlength>%d</getcontentlength></prop></propstat></response>`, name, len(fs.files[name]))
+			}
+		}
+		fmt.Fprint(w, `</multistatus>`)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func Test_webdavStore(t *testing.T) {
+	fs := &fakeWebdav{files: make(map[string][]byte)}
+	server := httptest.NewServer(fs)
+	defer server.Close()
+
+	config := &Config{webdav: &webdavConfig{url: server.URL + "/archive"}}
+	s, r := newWebdavStore(config)
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	if err := s.MkdirAll("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteFile("bob/L-1", []byte("entry"), archiveFileMode); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fs.files["/archive/bob/L-1.tmp"]; ok {
+		t.Errorf("Temporary file was not moved into place")
+	}
+	data, err := s.ReadFile("bob/L-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "entry" {
+		t.Errorf("Expected entry, got %s", data)
+	}
+	if _, err := s.ReadFile("bob/L-2"); !os.IsNotExist(err) {
+		t.Errorf("Expected not exist error, got %v", err)
+	}
+
+	if err := s.WriteFile("bob/C-1", []byte("comments"), archiveFileMode); err != nil {
+		t.Fatal(err)
+	}
+	infos, err := s.ReadDir("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 || infos[0].Name() != "C-1" || infos[1].Name() != "L-1" || infos[1].Size() != 5 {
+		t.Errorf("Unexpected directory listing %v", infos)
+	}
+	info, err := s.Stat("bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.IsDir() || info.Name() != "bob" {
+		t.Errorf("Expected directory bob, got %s", info.Name())
+	}
+
+	if err := s.Remove("bob/C-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Stat("bob/C-1"); !os.IsNotExist(err) {
+		t.Errorf("Expected not exist error after remove, got %v", err)
+	}
+	if _, err := s.ReadFile("../secret"); err == nil {
+		t.Errorf("Path outside of the archive was accepted")
+	}
+
+	fs.files["/archive/bob/big"] = make([]byte, maxMediaFileSize+1)
+	if _, err := s.ReadFile("bob/big"); err == nil {
+		t.Errorf("Too big file was read without an error")
+	}
+}
+
+func Test_webdavPendingWrites(t *testing.T) {
+	fs := &fakeWebdav{files: make(map[string][]byte)}
+	server := httptest.NewServer(fs)
+	defer server.Close()
+	s, r := newWebdavStore(&Config{webdav: &webdavConfig{url: server.URL + "/archive"}})
+	if r != nil {
+		t.Fatal(r.AsText())
+	}
+	savedStore := archiveStore
+	archiveStore = s
+	defer func() { archiveStore = savedStore }()
+
+	fs.files["/archive/bob/L-1"] = []byte("entry")
+	fs.files["/archive/bob/L-2"+pendingSuffix] = []byte("entry")
+	fs.files["/archive/bob/2020/01/C-3"+pendingSuffix] = []byte("comments")
+	if committing, r := commitInProgress("bob"); r != nil || !committing {
+		t.Errorf("Expected the pending files to be found %v", r)
+	}
+	if r := recoverPendingWrites("bob"); r != nil {
+		t.Fatal(r.AsText())
+	}
+	if len(fs.files) != 1 || fs.files["/archive/bob/L-1"] == nil {
+		t.Errorf("Expected only L-1 after the recovery, got %v", fs.files)
+	}
+	if committing, r := commitInProgress("bob"); r != nil || committing {
+		t.Errorf("Expected no pending files %v", r)
+	}
+}